@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// loadAliases reads a mailx-style aliases file. Each non-comment line has the
+// form `alias NAME ADDR...` (or `group NAME ADDR...`); addresses may be
+// separated by commas, or by spaces when they have no display name, and may
+// refer to other aliases.
+func loadAliases(path string) (map[string][]string, error) {
+	aliases := map[string][]string{}
+	if path == "" {
+		return aliases, nil
+	}
+	f, err := os.Open(expandHome(path))
+	if err != nil {
+		return nil, fmt.Errorf("open aliases: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || (fields[0] != "alias" && fields[0] != "group") {
+			return nil, fmt.Errorf("aliases %s:%d: expected 'alias NAME ADDR...'", path, lineNo)
+		}
+		name := strings.ToLower(fields[1])
+		rest := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+		for _, e := range recipientEntries(rest) {
+			if _, err := mail.ParseAddress(e); err != nil {
+				// mailx style: bare addresses and names separated by spaces
+				aliases[name] = append(aliases[name], strings.Fields(e)...)
+				continue
+			}
+			aliases[name] = append(aliases[name], e)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// expandAliases parses the recipient lists addrs and replaces alias names
+// with their addresses, recursively. Duplicates are dropped while
+// preserving order.
+func expandAliases(aliases map[string][]string, addrs []string) ([]*mail.Address, error) {
+	out := []*mail.Address{}
+	seen := map[string]bool{}
+	add := func(a *mail.Address) {
+		if key := strings.ToLower(a.Address); !seen[key] {
+			seen[key] = true
+			out = append(out, a)
+		}
+	}
+	var expand func(a string, stack []string) error
+	expand = func(a string, stack []string) error {
+		key := strings.ToLower(a)
+		members, ok := aliases[key]
+		if !ok {
+			addr, err := mail.ParseAddress(a)
+			if err != nil {
+				return fmt.Errorf("recipient %q: %w", a, err)
+			}
+			add(addr)
+			return nil
+		}
+		for _, s := range stack {
+			if s == key {
+				return fmt.Errorf("alias loop: %s -> %s", strings.Join(stack, " -> "), key)
+			}
+		}
+		for _, m := range members {
+			if err := expand(m, append(stack, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, a := range addrs {
+		// a plain list of addresses needs no alias lookup
+		if list, err := mail.ParseAddressList(a); err == nil {
+			for _, addr := range list {
+				add(addr)
+			}
+			continue
+		}
+		for _, part := range recipientEntries(a) {
+			if err := expand(part, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// recipientEntries splits a recipient list at the commas that are not
+// inside a quoted display name, a comment or angle brackets, so
+// `"Doe, Jane" <jane@example.com>` stays one entry. Empty entries are
+// dropped.
+func recipientEntries(s string) []string {
+	var entries []string
+	var quoted, escaped bool
+	depth, angle, start := 0, false, 0
+	flush := func(end int) {
+		if e := strings.TrimSpace(s[start:end]); e != "" {
+			entries = append(entries, e)
+		}
+	}
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && (quoted || depth > 0):
+			escaped = true
+		case r == '"' && depth == 0:
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth > 0:
+		case r == '<':
+			angle = true
+		case r == '>':
+			angle = false
+		case r == ',' && !angle:
+			flush(i)
+			start = i + 1
+		}
+	}
+	flush(len(s))
+	return entries
+}
+
+// addressList formats addrs for a To or Cc header.
+func addressList(addrs []*mail.Address) string {
+	s := make([]string, len(addrs))
+	for i, a := range addrs {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ", ")
+}
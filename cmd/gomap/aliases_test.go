@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandAliases(t *testing.T) {
+	aliases := map[string][]string{
+		"alice": {"alice@example.com"},
+		"team":  {"alice", `"Doe, Jane" <jane@example.com>`, "carol@example.com"},
+		"loop":  {"loop"},
+	}
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{`"Doe, Jane" <jane@example.com>`}, `"Doe, Jane" <jane@example.com>`},
+		{[]string{`"Doe, Jane" <jane@example.com>, bob@example.com`}, `"Doe, Jane" <jane@example.com>, <bob@example.com>`},
+		{[]string{"team, bob@example.com"}, `<alice@example.com>, "Doe, Jane" <jane@example.com>, <carol@example.com>, <bob@example.com>`},
+		{[]string{"Team", "alice@example.com"}, `<alice@example.com>, "Doe, Jane" <jane@example.com>, <carol@example.com>`},
+		{[]string{"Bob <bob@example.com>", "BOB@example.com"}, `"Bob" <bob@example.com>`},
+	}
+	for _, tt := range tests {
+		got, err := expandAliases(aliases, tt.in)
+		if err != nil {
+			t.Fatalf("expandAliases(%q): %v", tt.in, err)
+		}
+		if s := addressList(got); s != tt.want {
+			t.Errorf("expandAliases(%q) = %s, want %s", tt.in, s, tt.want)
+		}
+	}
+	for _, in := range []string{"loop", "nobody", "bob@example.com, nobody"} {
+		if _, err := expandAliases(aliases, []string{in}); err == nil {
+			t.Errorf("expandAliases(%q) succeeded", in)
+		}
+	}
+}
+
+func TestLoadAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases")
+	data := "# comment\n" +
+		"alias a a@example.com b@example.com\n" +
+		"group team  alice, \"Doe, Jane\" <jane@example.com>, Carol Roe <carol@example.com>\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadAliases(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"a":    {"a@example.com", "b@example.com"},
+		"team": {"alice", `"Doe, Jane" <jane@example.com>`, "Carol Roe <carol@example.com>"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadAliases = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type archiveOptions struct {
+	sourceOptions
+	mailboxes []string
+	olderThan string
+	target    string
+}
+
+func addArchiveFlags(cmd *cobra.Command) {
+	o := &archiveOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringArrayVar(&o.mailboxes, "mailbox", []string{"INBOX"}, "Mailbox to archive from; * and % are LIST wildcards (can be repeated)")
+	cmd.Flags().StringVar(&o.olderThan, "older-than", "", "Archive messages with INTERNALDATE before this day (YYYY-MM-DD, or an age like 90d, 6m, 2y)")
+	cmd.Flags().StringVar(&o.target, "target", "Archive/{year}", "Archive folder; {year}, {month} and {mailbox} are replaced, / separates levels")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// archiveMove is the messages of one mailbox that go to one archive
+// folder.
+type archiveMove struct {
+	mailbox string
+	target  string
+	uids    []uint32
+}
+
+// archiveTarget returns the archive folder of a message from mailbox
+// received on date: tmpl with "/" turned into the delimiter delim and its
+// placeholders filled in.
+func archiveTarget(tmpl, delim, mailbox string, date time.Time) string {
+	return strings.NewReplacer(
+		"{year}", strconv.Itoa(date.Year()),
+		"{month}", fmt.Sprintf("%02d", int(date.Month())),
+		"{mailbox}", mailbox,
+	).Replace(prefixPath(tmpl, delim))
+}
+
+// runArchive moves the messages older than --older-than out of the
+// mailboxes matching --mailbox into archive folders on the same account,
+// one per year by default, with MOVE or else COPY, \Deleted and EXPUNGE.
+func runArchive(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*archiveOptions)
+	if o.olderThan == "" {
+		return fmt.Errorf("missing required flag: --older-than")
+	}
+	before, err := parseBefore(o.olderThan, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid --older-than %q (expected YYYY-MM-DD or an age like 90d, 6m, 2y)", o.olderThan)
+	}
+	if strings.Trim(o.target, "/") == "" {
+		return fmt.Errorf("--target must not be empty")
+	}
+
+	ctx := cmd.Context()
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	delim, err := imaputil.Delimiter(c)
+	if err != nil {
+		return fmt.Errorf("list delimiter: %w", err)
+	}
+	boxes, err := matchingMailboxes(ctx, c, o.mailboxes)
+	if err != nil {
+		return err
+	}
+	if len(boxes) == 0 {
+		fmt.Println("No mailboxes matched.")
+		return nil
+	}
+
+	var moves []archiveMove
+	total := 0
+	for _, box := range boxes {
+		if _, err := imaputil.SelectMailbox(c, box, true); err != nil {
+			if imaputil.ConnClosed(err) {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "select %s: %v\n", box, err)
+			continue
+		}
+		criteria := imap.NewSearchCriteria()
+		criteria.Before = before
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "search %s: %v\n", box, err)
+			continue
+		}
+		if len(uids) == 0 {
+			continue
+		}
+		dates, err := internalDates(c, uids)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fetch %s: %v\n", box, err)
+			continue
+		}
+		byTarget := map[string][]uint32{}
+		for _, uid := range uids {
+			date, ok := dates[uid]
+			if !ok {
+				continue
+			}
+			// messages already in their archive folder stay
+			if t := archiveTarget(o.target, delim, box, date); t != box {
+				byTarget[t] = append(byTarget[t], uid)
+			}
+		}
+		targets := make([]string, 0, len(byTarget))
+		for t := range byTarget {
+			targets = append(targets, t)
+		}
+		sort.Strings(targets)
+		for _, t := range targets {
+			moves = append(moves, archiveMove{mailbox: box, target: t, uids: byTarget[t]})
+			total += len(byTarget[t])
+		}
+	}
+	if total == 0 {
+		fmt.Println("No messages to archive.")
+		return nil
+	}
+	var failed []string
+	err = runCountWork(ctx, total, "Archive", func(ctx context.Context, progress chan<- int) error {
+		const chunkSize = 500
+		created := map[string]bool{}
+		for _, m := range moves {
+			if ctx.Err() != nil {
+				break
+			}
+			if !created[m.target] {
+				if err := ensureMailbox(c, m.target); err != nil {
+					failed = append(failed, fmt.Sprintf("create %s: %v", m.target, err))
+					continue
+				}
+				created[m.target] = true
+			}
+			if _, err := imaputil.SelectMailbox(c, m.mailbox, dryRun); err != nil {
+				failed = append(failed, fmt.Sprintf("select %s: %v", m.mailbox, err))
+				continue
+			}
+			for i := 0; i < len(m.uids) && ctx.Err() == nil; i += chunkSize {
+				end := min(i+chunkSize, len(m.uids))
+				if err := moveUIDs(c, m.mailbox, m.uids[i:end], m.target); err != nil {
+					failed = append(failed, fmt.Sprintf("move %s to %s: %v", m.mailbox, m.target, err))
+					break
+				}
+				progress <- end - i
+			}
+		}
+		return ctx.Err()
+	})
+
+	for _, f := range failed {
+		fmt.Fprintln(os.Stderr, f)
+	}
+	if err != nil {
+		return fmt.Errorf("archive stopped: %w", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d move(s) failed", len(failed))
+	}
+	return nil
+}
+
+// internalDates fetches the INTERNALDATE of the messages uids in the
+// selected mailbox.
+func internalDates(c *client.Client, uids []uint32) (map[uint32]time.Time, error) {
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate}, msgs)
+	}()
+	dates := make(map[uint32]time.Time, len(uids))
+	for m := range msgs {
+		if m != nil {
+			dates[m.Uid] = m.InternalDate
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return dates, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchiveTarget(t *testing.T) {
+	date := time.Date(2023, time.March, 9, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		tmpl, delim, mailbox string
+		want                 string
+	}{
+		{"Archive/{year}", "/", "INBOX", "Archive/2023"},
+		{"Archive/{year}", ".", "INBOX", "Archive.2023"},
+		{"Archive/{year}", "", "INBOX", "Archive/2023"},
+		{"/Archive/{year}/{month}/", ".", "INBOX", "Archive.2023.03"},
+		{"Archive/{mailbox}/{year}", ".", "Lists.go", "Archive.Lists.go.2023"},
+		{"{mailbox} {year}-{month}", "/", "Sent", "Sent 2023-03"},
+	}
+	for _, tt := range tests {
+		if got := archiveTarget(tt.tmpl, tt.delim, tt.mailbox, date); got != tt.want {
+			t.Errorf("archiveTarget(%q, %q, %q) = %q, want %q", tt.tmpl, tt.delim, tt.mailbox, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+)
+
+// attachment is a file that gets streamed into an outgoing message.
+type attachment struct {
+	path        string
+	name        string
+	size        int64
+	contentType string
+}
+
+// statAttachments validates the given paths and collects sizes and content types
+// without reading file contents.
+func statAttachments(paths []string) ([]attachment, error) {
+	out := make([]attachment, 0, len(paths))
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %s: %w", p, err)
+		}
+		if fi.IsDir() {
+			return nil, fmt.Errorf("attachment %s: is a directory", p)
+		}
+		ct := mime.TypeByExtension(filepath.Ext(p))
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		out = append(out, attachment{path: p, name: filepath.Base(p), size: fi.Size(), contentType: ct})
+	}
+	return out, nil
+}
+
+const base64LineLen = 76
+
+// base64EncodedSize returns the number of bytes n input bytes occupy once
+// base64 encoded and wrapped into CRLF-terminated lines.
+func base64EncodedSize(n int64) int64 {
+	enc := (n + 2) / 3 * 4
+	lines := (enc + base64LineLen - 1) / base64LineLen
+	return enc + lines*2
+}
+
+// lineWrapper inserts CRLF after every base64LineLen bytes written.
+type lineWrapper struct {
+	w   io.Writer
+	col int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := base64LineLen - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.col += n
+		p = p[n:]
+		if l.col == base64LineLen {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+	}
+	return written, nil
+}
+
+// streamAttachment copies the file through a base64 encoder straight into w,
+// so memory use stays constant regardless of the file size.
+func streamAttachment(w io.Writer, a attachment) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	lw := &lineWrapper{w: w}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+	if _, err := io.Copy(enc, f); err != nil {
+		return fmt.Errorf("attachment %s: %w", a.path, err)
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if lw.col > 0 {
+		if _, err := io.WriteString(w, "\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingWriter discards data and counts the bytes written.
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// writeMultipart writes a multipart/mixed body (text part followed by
+// attachments) to w. The header must have been written by the caller using
+// the same boundary. attachBody is responsible for emitting the encoded
+// attachment data; this lets callers compute the final size without reading
+// the files.
+func writeMultipart(w io.Writer, boundary, body string, atts []attachment, attachBody func(io.Writer, attachment) error) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	th := textproto.MIMEHeader{}
+	th.Set("Content-Type", "text/plain; charset=UTF-8")
+	th.Set("Content-Transfer-Encoding", "8bit")
+	pw, err := mw.CreatePart(th)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(pw, body); err != nil {
+		return err
+	}
+	for _, a := range atts {
+		ah := textproto.MIMEHeader{}
+		ah.Set("Content-Type", mime.FormatMediaType(a.contentType, map[string]string{"name": a.name}))
+		ah.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.name}))
+		ah.Set("Content-Transfer-Encoding", "base64")
+		pw, err := mw.CreatePart(ah)
+		if err != nil {
+			return err
+		}
+		if err := attachBody(pw, a); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func newBoundary() string {
+	return multipart.NewWriter(io.Discard).Boundary()
+}
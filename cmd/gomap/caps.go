@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type capsOptions struct {
+	host       string
+	port       int
+	user       string
+	pass       string
+	passPrompt bool
+	identity   string
+	url        string
+	insecure   bool
+	startTLS   bool
+	json       bool
+}
+
+func addCapsFlags(cmd *cobra.Command) {
+	o := &capsOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.host, "host", "", "IMAP host")
+	cmd.Flags().IntVar(&o.port, "port", 993, "IMAP port")
+	cmd.Flags().StringVar(&o.user, "user", "", "IMAP username (optional; the capabilities after login are shown too)")
+	cmd.Flags().StringVar(&o.pass, "pass", "", "IMAP password")
+	cmd.Flags().BoolVar(&o.passPrompt, "pass-prompt", false, "Prompt for the IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.identity, "identity", "", "Use the IMAP account of this identity from the config")
+	cmd.Flags().StringVar(&o.url, "url", "", "Server as a connection URL, e.g. imaps://user@host:993 (instead of --host, --port and --user)")
+	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
+	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
+	cmd.Flags().BoolVar(&o.json, "json", false, "Print the capabilities as JSON")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// capsFeature is an extension gomap makes use of. usable reports from
+// the capabilities whether it applies.
+type capsFeature struct {
+	name   string
+	usable func(caps map[string]bool) bool
+	use    string
+}
+
+func anyCap(names ...string) func(map[string]bool) bool {
+	return func(caps map[string]bool) bool {
+		for _, n := range names {
+			if caps[n] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// capsFeatures are the extensions that make gomap faster or more precise,
+// in the order caps lists them.
+var capsFeatures = []capsFeature{
+	{"UIDPLUS", anyCap("UIDPLUS"), "copy learns the destination UIDs from APPENDUID: state show maps UIDs, --verify needs no Message-ID search"},
+	{"MULTIAPPEND", anyCap("MULTIAPPEND"), "copy appends several messages per command"},
+	{"MOVE", anyCap("MOVE"), "archive, sync --delete-mode trash and the quarantine move messages in one step instead of COPY, \\Deleted and EXPUNGE"},
+	{"CONDSTORE", anyCap("CONDSTORE", "QRESYNC"), "sync skips mailboxes whose HIGHESTMODSEQ did not change"},
+	{"COMPRESS=DEFLATE", func(map[string]bool) bool { return false }, "not used: gomap does not compress connections"},
+	{"IDLE", anyCap("IDLE"), "copy --follow and tail learn of new mail at once instead of polling"},
+	{"STATUS=SIZE", anyCap("STATUS=SIZE"), "list, migrate and the quota check get mailbox sizes without fetching message sizes"},
+	{"SPECIAL-USE", anyCap("SPECIAL-USE", "XLIST"), "Drafts, Junk, Sent and Trash are found by attribute, whatever their name"},
+	{"QUOTA", anyCap("QUOTA"), "quota, and the quota check before a copy"},
+	{"ACL", anyCap("ACL"), "copy --sync-acl"},
+	{"CATENATE", anyCap("CATENATE"), "--add-header lines are sent as a part of their own, the message is not copied into a new buffer"},
+	{"BINARY", func(caps map[string]bool) bool { return caps["BINARY"] && caps["LITERAL+"] }, "messages with NUL bytes are appended unchanged (needs LITERAL+ too)"},
+}
+
+// capsReport is the output of caps.
+type capsReport struct {
+	Server        string          `json:"server"`
+	Greeting      string          `json:"greeting"`
+	Capabilities  []string        `json:"capabilities"`
+	Auth          []string        `json:"auth"`
+	LoginDisabled bool            `json:"login_disabled"`
+	LoggedIn      []string        `json:"capabilities_after_login,omitempty"`
+	Optimizations map[string]bool `json:"optimizations"`
+}
+
+// runCaps prints the CAPABILITY list of a server before and, with
+// credentials, after login, its authentication mechanisms and which of
+// the extensions gomap uses it has, for "why is it slow" reports.
+func runCaps(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*capsOptions)
+	if o.identity != "" {
+		_, acc, _, err := lookupIdentity(o.identity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{host: &o.host, port: &o.port, user: &o.user, pass: &o.pass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if err := applyEndpoints(cmd, endpointFlag{loginTarget{host: &o.host, port: &o.port, user: &o.user, pass: &o.pass, startTLS: &o.startTLS, insecure: &o.insecure}, "imap", o.url}); err != nil {
+		return err
+	}
+	if o.passPrompt && o.pass == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if perr != nil {
+			return fmt.Errorf("read password: %w", perr)
+		}
+		o.pass = string(b)
+	}
+	if o.host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+	if (o.user == "") != (o.pass == "") {
+		return fmt.Errorf("--user and --pass go together")
+	}
+
+	c, err := imaputil.Dial(cmd.Context(), o.host, o.port, o.startTLS, &tls.Config{InsecureSkipVerify: o.insecure})
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer c.Logout()
+	r := capsReport{Server: net.JoinHostPort(o.host, strconv.Itoa(o.port)), Greeting: imaputil.Greeting(c)}
+	caps, err := c.Capability()
+	if err != nil {
+		return fmt.Errorf("capability: %w", err)
+	}
+	r.Capabilities = sortedCaps(caps)
+	for _, name := range r.Capabilities {
+		if mech, ok := strings.CutPrefix(name, "AUTH="); ok {
+			r.Auth = append(r.Auth, mech)
+		}
+	}
+	r.LoginDisabled = caps["LOGINDISABLED"]
+	if o.user != "" {
+		if err := c.Login(o.user, o.pass); err != nil {
+			return fmt.Errorf("login: %w", err)
+		}
+		if caps, err = c.Capability(); err != nil {
+			return fmt.Errorf("capability after login: %w", err)
+		}
+		r.LoggedIn = sortedCaps(caps)
+	}
+	r.Optimizations = map[string]bool{}
+	for _, f := range capsFeatures {
+		r.Optimizations[f.name] = f.usable(caps)
+	}
+
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(r)
+	}
+	fmt.Printf("Server:         %s\n", r.Server)
+	fmt.Printf("Greeting:       %s\n", r.Greeting)
+	fmt.Printf("Capabilities:   %s\n", strings.Join(r.Capabilities, " "))
+	auth := strings.Join(r.Auth, " ")
+	if r.LoginDisabled {
+		auth += " (LOGIN disabled)"
+	}
+	fmt.Printf("Authentication: %s\n", auth)
+	if r.LoggedIn != nil {
+		fmt.Printf("After login:    %s\n", strings.Join(r.LoggedIn, " "))
+	} else {
+		fmt.Println("After login:    (give --user and --pass; servers often announce more after login)")
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EXTENSION\tUSABLE\tWHAT GOMAP DOES WITH IT")
+	for _, f := range capsFeatures {
+		usable := "no"
+		switch {
+		case r.Optimizations[f.name]:
+			usable = "yes"
+		case caps[f.name]:
+			usable = "unused"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.name, usable, f.use)
+	}
+	return w.Flush()
+}
+
+// sortedCaps returns the names of caps in order.
+func sortedCaps(caps map[string]bool) []string {
+	names := make([]string, 0, len(caps))
+	for name, ok := range caps {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
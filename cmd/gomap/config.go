@@ -0,0 +1,335 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/endpoint"
+)
+
+// configPath is bound to the global --config flag.
+var configPath string
+
+const defaultConfigPath = "~/.gomap/config.json"
+
+// accountConfig describes an IMAP or SMTP login, by fields or as a
+// connection URL (see package endpoint); fields win over the URL.
+type accountConfig struct {
+	URL      string `json:"url,omitempty"`
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	User     string `json:"user"`
+	Pass     string `json:"pass,omitempty"`
+	PassEnv  string `json:"pass_env,omitempty"` // read the password from this environment variable
+	StartTLS bool   `json:"starttls,omitempty"`
+	SSL      bool   `json:"ssl,omitempty"` // SMTP implicit TLS
+	Insecure bool   `json:"insecure,omitempty"`
+}
+
+// resolveURL fills the fields left empty from the URL of the account.
+func (a accountConfig) resolveURL() (accountConfig, error) {
+	if a.URL == "" {
+		return a, nil
+	}
+	e, err := endpoint.Parse(a.URL)
+	if err != nil {
+		return a, err
+	}
+	if a.Host == "" {
+		a.Host = e.Host
+	}
+	if a.Port == 0 {
+		a.Port = e.Port
+	}
+	if a.User == "" {
+		a.User = e.User
+	}
+	if a.Pass == "" && a.PassEnv == "" {
+		if a.Pass = e.Pass; a.Pass == "" && a.User != "" {
+			a.Pass = endpoint.Keyring(a.Host, a.User)
+		}
+	}
+	a.StartTLS = a.StartTLS || (e.Protocol == "imap" && e.StartTLS)
+	a.SSL = a.SSL || (e.Protocol == "smtp" && e.SSL)
+	a.Insecure = a.Insecure || e.Insecure
+	return a, nil
+}
+
+func (a accountConfig) password() string {
+	if a.Pass == "" && a.PassEnv != "" {
+		return os.Getenv(a.PassEnv)
+	}
+	return a.Pass
+}
+
+// identityConfig bundles who a user is with the accounts used to act as them.
+type identityConfig struct {
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	SMTP       string `json:"smtp"`        // account name
+	IMAP       string `json:"imap"`        // account name
+	SentFolder string `json:"sent_folder"` // where send stores a copy (optional)
+}
+
+// receiveRuleConfig sends the mailboxes matching a regex to their own
+// backup output instead of the one given by the backup flags.
+type receiveRuleConfig struct {
+	Mailbox   string `json:"mailbox"`              // regex, like --include
+	Format    string `json:"format,omitempty"`     // replaces --format, --compress and --split-by
+	OutputDir string `json:"output_dir,omitempty"` // replaces --output-dir and --output
+	Compress  bool   `json:"compress,omitempty"`
+	SplitBy   string `json:"split_by,omitempty"`
+}
+
+// migrationConfig is the plan of 'gomap migrate'.
+type migrationConfig struct {
+	Source      string   `json:"source"`            // account name
+	Destination string   `json:"destination"`       // account name
+	Options     []string `json:"options,omitempty"` // copy flags, e.g. "--skip-junk"; verify gets those it knows
+	Deltas      int      `json:"deltas,omitempty"`  // delta passes after the initial copy (default 1)
+	// DeltaInterval is the wait before each delta pass, e.g. "30m".
+	DeltaInterval string `json:"delta_interval,omitempty"`
+}
+
+// jobConfig is a job of 'gomap daemon': a copy or backup run on a
+// schedule.
+type jobConfig struct {
+	Name        string   `json:"name"`
+	Command     string   `json:"command"`               // "copy" or "backup"
+	Source      string   `json:"source"`                // account name
+	Destination string   `json:"destination,omitempty"` // account name, for copy
+	Options     []string `json:"options,omitempty"`     // flags of the command, e.g. "--skip-junk"
+	Schedule    string   `json:"schedule,omitempty"`    // cron expression; default --schedule
+	// StateFile is the resume state of a copy job, by default
+	// gomap-<name>-state.json; a --state-file option wins.
+	StateFile string `json:"state_file,omitempty"`
+}
+
+type config struct {
+	Accounts     map[string]accountConfig  `json:"accounts"`
+	Identities   map[string]identityConfig `json:"identities"`
+	ReceiveRules []receiveRuleConfig       `json:"receive_rules"`
+	Migration    *migrationConfig          `json:"migration,omitempty"`
+	Jobs         []jobConfig               `json:"jobs,omitempty"`
+}
+
+func expandHome(path string) string {
+	if len(path) >= 2 && path[:2] == "~/" {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// loadConfig reads the config file. A missing file at the default location
+// yields an empty config; an explicitly given path must exist.
+func loadConfig() (*config, error) {
+	path := configPath
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath
+	}
+	cfg := &config{Accounts: map[string]accountConfig{}, Identities: map[string]identityConfig{}}
+	b, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// configFingerprint hashes the host, port and user of every configured
+// account (passwords are left out). It returns "" when no accounts exist.
+func configFingerprint() (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if len(cfg.Accounts) == 0 {
+		return "", nil
+	}
+	names := make([]string, 0, len(cfg.Accounts))
+	for n := range cfg.Accounts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, n := range names {
+		a := cfg.Accounts[n]
+		if e, err := endpoint.Parse(a.URL); a.URL != "" && err == nil {
+			a.Host, a.Port, a.User = cmp.Or(a.Host, e.Host), cmp.Or(a.Port, e.Port), cmp.Or(a.User, e.User)
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s\n", n, a.Host, a.Port, a.User)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// lookupIdentity resolves an identity and its referenced accounts. Accounts
+// that are not referenced are returned as zero values.
+func lookupIdentity(name string) (id identityConfig, imapAcc, smtpAcc accountConfig, err error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return id, imapAcc, smtpAcc, err
+	}
+	id, ok := cfg.Identities[name]
+	if !ok {
+		return id, imapAcc, smtpAcc, fmt.Errorf("unknown identity %q", name)
+	}
+	if id.IMAP != "" {
+		if imapAcc, ok = cfg.Accounts[id.IMAP]; !ok {
+			return id, imapAcc, smtpAcc, fmt.Errorf("identity %q: unknown imap account %q", name, id.IMAP)
+		}
+		if imapAcc, err = imapAcc.resolveURL(); err != nil {
+			return id, imapAcc, smtpAcc, fmt.Errorf("account %q: %w", id.IMAP, err)
+		}
+	}
+	if id.SMTP != "" {
+		if smtpAcc, ok = cfg.Accounts[id.SMTP]; !ok {
+			return id, imapAcc, smtpAcc, fmt.Errorf("identity %q: unknown smtp account %q", name, id.SMTP)
+		}
+		if smtpAcc, err = smtpAcc.resolveURL(); err != nil {
+			return id, imapAcc, smtpAcc, fmt.Errorf("account %q: %w", id.SMTP, err)
+		}
+	}
+	return id, imapAcc, smtpAcc, nil
+}
+
+// loginTarget points at the connection fields of one side (src, dst or
+// smtp) of a command's options.
+type loginTarget struct {
+	prefix   string // flag prefix, e.g. "src", "dst" or "smtp"; "" for --host etc.
+	host     *string
+	port     *int
+	user     *string
+	pass     *string
+	startTLS *bool
+	ssl      *bool // SMTP implicit TLS
+	insecure *bool
+}
+
+// changed reports whether the flag name of t (e.g. "host" for --src-host)
+// was set on the command line.
+func (t loginTarget) changed(cmd *cobra.Command, name string) bool {
+	if t.prefix != "" {
+		name = t.prefix + "-" + name
+	}
+	return cmd.Flags().Changed(name)
+}
+
+// urlFlag is the name of the connection URL flag of t: --src, --dst,
+// --smtp, or --url for commands with a single connection.
+func (t loginTarget) urlFlag() string {
+	if t.prefix == "" {
+		return "url"
+	}
+	return t.prefix
+}
+
+// applyAccount fills connection settings from acc for every flag that was
+// not set explicitly on the command line.
+func applyAccount(cmd *cobra.Command, t loginTarget, acc accountConfig) {
+	changed := func(name string) bool { return t.changed(cmd, name) }
+	if !changed("host") && acc.Host != "" {
+		*t.host = acc.Host
+	}
+	if !changed("port") && acc.Port != 0 {
+		*t.port = acc.Port
+	}
+	if !changed("user") && acc.User != "" {
+		*t.user = acc.User
+	}
+	if !changed("pass") && *t.pass == "" {
+		*t.pass = acc.password()
+	}
+	if t.startTLS != nil && !cmd.Flags().Changed("starttls") && acc.StartTLS {
+		*t.startTLS = true
+	}
+	if t.insecure != nil && !cmd.Flags().Changed("insecure") && acc.Insecure {
+		*t.insecure = true
+	}
+}
+
+// endpointFlag is a connection URL flag (--src, --dst, --smtp or --url)
+// and the login it describes.
+type endpointFlag struct {
+	t        loginTarget
+	protocol string // "imap" or "smtp"
+	raw      string
+}
+
+// applyEndpoints fills connection settings from connection URLs (see
+// package endpoint) for every flag that was not set explicitly. It runs
+// after applyAccount, so a URL wins over an identity. Without a password
+// in the URL, the system keyring is asked for one.
+func applyEndpoints(cmd *cobra.Command, flags ...endpointFlag) error {
+	parsed := make([]endpoint.Endpoint, len(flags))
+	for i, f := range flags {
+		if f.raw == "" {
+			continue
+		}
+		e, err := endpoint.Parse(f.raw)
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", f.t.urlFlag(), err)
+		}
+		if e.Protocol != f.protocol {
+			return fmt.Errorf("invalid --%s: expected an %s URL, got %s", f.t.urlFlag(), f.protocol, endpoint.Redact(f.raw))
+		}
+		// the connections of a command share --starttls
+		for j := 0; j < i; j++ {
+			if flags[j].raw != "" && flags[j].t.startTLS == f.t.startTLS && f.t.startTLS != nil && parsed[j].StartTLS != e.StartTLS {
+				return fmt.Errorf("--%s and --%s must both use implicit TLS or both STARTTLS", flags[j].t.urlFlag(), f.t.urlFlag())
+			}
+		}
+		parsed[i] = e
+	}
+	for i, f := range flags {
+		if f.raw != "" {
+			applyEndpoint(cmd, f.t, parsed[i])
+		}
+	}
+	return nil
+}
+
+func applyEndpoint(cmd *cobra.Command, t loginTarget, e endpoint.Endpoint) {
+	changed := func(name string) bool { return t.changed(cmd, name) }
+	if !changed("host") {
+		*t.host = e.Host
+	}
+	if !changed("port") {
+		*t.port = e.Port
+	}
+	if !changed("user") && e.User != "" {
+		*t.user = e.User
+	}
+	if !changed("pass") {
+		if e.Pass != "" {
+			*t.pass = e.Pass
+		} else if *t.pass == "" && *t.user != "" {
+			*t.pass = endpoint.Keyring(*t.host, *t.user)
+		}
+	}
+	if t.ssl != nil && !cmd.Flags().Changed(t.prefix+"-ssl") && !cmd.Flags().Changed("ssl") {
+		*t.ssl = e.SSL
+	}
+	if t.startTLS != nil && !cmd.Flags().Changed("starttls") {
+		*t.startTLS = e.StartTLS
+	}
+	if t.insecure != nil && !cmd.Flags().Changed("insecure") && e.Insecure {
+		*t.insecure = true
+	}
+}
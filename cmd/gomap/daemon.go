@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/cron"
+)
+
+type daemonOptions struct {
+	schedule string
+	logFile  string
+	jobs     []string
+}
+
+func addDaemonFlags(cmd *cobra.Command) {
+	o := &daemonOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.schedule, "schedule", "", "Cron expression for jobs without a schedule of their own, e.g. '0 */4 * * *' or @daily")
+	cmd.Flags().StringVar(&o.logFile, "log-file", "", "Also append the log to this file")
+	cmd.Flags().StringArrayVar(&o.jobs, "job", nil, "Only run the job of this name (can be repeated; default all jobs of the config)")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// jobNameRe restricts job names to what can go into a file name.
+var jobNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// daemonJob is a job of the config resolved into the command it runs.
+type daemonJob struct {
+	name     string
+	schedule *cron.Schedule
+	use      string
+	addFlags func(*cobra.Command)
+	run      func(*cobra.Command, []string) error
+	args     []string
+	next     time.Time
+}
+
+// runDaemon runs the jobs of the "jobs" section of the config on their
+// schedules until SIGINT/SIGTERM. Jobs run one at a time; a job that is
+// due while another runs starts after it, and runs missed meanwhile are
+// not made up. A stop signal cancels the running job, which saves its
+// resume state as an interrupted copy does.
+func runDaemon(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*daemonOptions)
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	jobs, err := newDaemonJobs(cfg, o)
+	if err != nil {
+		return err
+	}
+	if o.logFile != "" {
+		f, err := os.OpenFile(expandHome(o.logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		defer f.Close()
+		log.SetOutput(io.MultiWriter(os.Stderr, f))
+		defer log.SetOutput(os.Stderr)
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	now := time.Now()
+	for _, j := range jobs {
+		j.next = j.schedule.Next(now)
+		log.Printf("[daemon] job %s: first run at %s", j.name, j.next.Format("2006-01-02 15:04"))
+	}
+	for {
+		var due *daemonJob
+		for _, j := range jobs {
+			if !j.next.IsZero() && (due == nil || j.next.Before(due.next)) {
+				due = j
+			}
+		}
+		if due == nil {
+			return fmt.Errorf("no job is ever due: check the schedules")
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("[daemon] stopped")
+			return nil
+		case <-time.After(time.Until(due.next)):
+		}
+		due.runOnce(ctx)
+		if ctx.Err() != nil {
+			log.Printf("[daemon] stopped")
+			return nil
+		}
+		due.next = due.schedule.Next(time.Now())
+		log.Printf("[daemon] job %s: next run at %s", due.name, due.next.Format("2006-01-02 15:04"))
+	}
+}
+
+// newDaemonJobs checks the jobs of cfg, those named by --job if given, and
+// turns their accounts and options into command flags.
+func newDaemonJobs(cfg *config, o *daemonOptions) ([]*daemonJob, error) {
+	if len(cfg.Jobs) == 0 {
+		return nil, fmt.Errorf("no \"jobs\" section in the config (see --config)")
+	}
+	want := map[string]bool{}
+	for _, n := range o.jobs {
+		want[n] = true
+	}
+	seen := map[string]bool{}
+	var jobs []*daemonJob
+	for _, jc := range cfg.Jobs {
+		if !jobNameRe.MatchString(jc.Name) {
+			return nil, fmt.Errorf("job %q: the name must be letters, digits, '.', '_' or '-'", jc.Name)
+		}
+		if seen[jc.Name] {
+			return nil, fmt.Errorf("job %q is configured twice", jc.Name)
+		}
+		seen[jc.Name] = true
+		if len(want) > 0 && !want[jc.Name] {
+			continue
+		}
+		j, err := newDaemonJob(cfg, jc, o.schedule)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", jc.Name, err)
+		}
+		jobs = append(jobs, j)
+	}
+	for n := range want {
+		if !seen[n] {
+			return nil, fmt.Errorf("unknown job %q", n)
+		}
+	}
+	return jobs, nil
+}
+
+// newDaemonJob resolves one job; schedule is the --schedule default.
+func newDaemonJob(cfg *config, jc jobConfig, schedule string) (*daemonJob, error) {
+	j := &daemonJob{name: jc.Name}
+	if jc.Schedule != "" {
+		schedule = jc.Schedule
+	}
+	if schedule == "" {
+		return nil, fmt.Errorf("no schedule (set \"schedule\" or --schedule)")
+	}
+	var err error
+	if j.schedule, err = cron.Parse(schedule); err != nil {
+		return nil, err
+	}
+	src, err := configAccount(cfg, "source", jc.Source)
+	if err != nil {
+		return nil, err
+	}
+	j.args = accountArgs("src", src)
+	insecure := src.Insecure
+	switch jc.Command {
+	case "copy":
+		j.use, j.addFlags, j.run = "copy", addCopyFlags, runCopy
+		dst, err := configAccount(cfg, "destination", jc.Destination)
+		if err != nil {
+			return nil, err
+		}
+		if src.StartTLS != dst.StartTLS {
+			return nil, fmt.Errorf("the source and destination accounts must both use STARTTLS or both implicit TLS")
+		}
+		j.args = append(j.args, accountArgs("dst", dst)...)
+		insecure = insecure || dst.Insecure
+	case "backup", "receive":
+		j.use, j.addFlags, j.run = "backup", addReceiveFlags, runReceive
+	default:
+		return nil, fmt.Errorf("invalid command %q (must be 'copy' or 'backup')", jc.Command)
+	}
+	if src.StartTLS {
+		j.args = append(j.args, "--starttls")
+	}
+	if insecure {
+		j.args = append(j.args, "--insecure")
+	}
+	j.args = append(j.args, jc.Options...)
+	c, err := stageCommand(context.Background(), j.use, j.addFlags, j.args)
+	if err != nil {
+		return nil, err
+	}
+	if c.Flags().Changed("mode") || c.Flags().Changed("follow") {
+		return nil, fmt.Errorf("--mode and --follow cannot be used in the options, the schedule replaces them")
+	}
+	if j.use == "copy" && !c.Flags().Changed("state-file") {
+		stateFile := jc.StateFile
+		if stateFile == "" {
+			stateFile = "gomap-" + jc.Name + "-state.json"
+		}
+		j.args = append(j.args, "--state-file="+stateFile)
+	}
+	return j, nil
+}
+
+// runOnce runs the job with fresh options and logs how it went.
+func (j *daemonJob) runOnce(ctx context.Context) {
+	log.Printf("[daemon] job %s: start", j.name)
+	start := time.Now()
+	c, err := stageCommand(ctx, j.use, j.addFlags, j.args)
+	if err == nil {
+		err = j.run(c, nil)
+	}
+	elapsed := time.Since(start).Round(time.Second)
+	switch {
+	case ctx.Err() != nil:
+		log.Printf("[daemon] job %s: interrupted after %s", j.name, elapsed)
+	case err != nil:
+		log.Printf("[daemon] job %s: failed after %s: %v", j.name, elapsed, err)
+	default:
+		log.Printf("[daemon] job %s: done in %s", j.name, elapsed)
+	}
+}
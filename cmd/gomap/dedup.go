@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// dedupStateKey is the key of the --dedup index of a destination mailbox
+// in the state file. Message-ID indexes use the bare mailbox name, as
+// they did before header hashes were supported.
+func dedupStateKey(mode, mailbox string) string {
+	if mode == "header-hash" {
+		return "hash:" + mailbox
+	}
+	return mailbox
+}
+
+// envelopeDedupKey returns the --dedup key of a message from its
+// envelope, or "" when it has none (such messages are always copied).
+func envelopeDedupKey(mode string, env *imap.Envelope) string {
+	if env == nil {
+		return ""
+	}
+	if mode != "header-hash" {
+		return strings.TrimSpace(env.MessageId)
+	}
+	var from string
+	if len(env.From) > 0 && env.From[0] != nil {
+		from = env.From[0].Address()
+	}
+	return headerHash(env.Date, from, env.Subject)
+}
+
+// rawDedupKey returns the --dedup key of a raw message.
+func rawDedupKey(mode string, raw []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	if mode != "header-hash" {
+		return strings.TrimSpace(msg.Header.Get("Message-Id"))
+	}
+	date, _ := msg.Header.Date()
+	var from string
+	if addrs, err := msg.Header.AddressList("From"); err == nil && len(addrs) > 0 {
+		from = addrs[0].Address
+	}
+	return headerHash(date, from, msg.Header.Get("Subject"))
+}
+
+// headerHash identifies a message by date, sender and subject, for
+// messages whose Message-ID was rewritten or is missing. Encoded words
+// are decoded and case is folded, so the envelope of a server and the
+// raw header of the same message agree.
+func headerHash(date time.Time, from, subject string) string {
+	if date.IsZero() && from == "" && subject == "" {
+		return ""
+	}
+	if dec, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		subject = dec
+	}
+	var unix string
+	if !date.IsZero() {
+		unix = strconv.FormatInt(date.Unix(), 10)
+	}
+	sum := sha256.Sum256([]byte(unix + "\x00" + strings.ToLower(from) + "\x00" + strings.Join(strings.Fields(subject), " ")))
+	return "sha256:" + hex.EncodeToString(sum[:16])
+}
+
+// refreshDedupIndex brings the --dedup index of mailbox in the state up
+// to date by fetching the envelopes of the messages added since the last
+// scan. A mailbox that cannot be selected (it does not exist yet) has
+// nothing to index. It returns the number of messages fetched.
+func refreshDedupIndex(c *client.Client, st *state.State, mode, mailbox string) (int, error) {
+	key := dedupStateKey(mode, mailbox)
+	status, err := imaputil.SelectMailbox(c, mailbox, true)
+	if err != nil {
+		if !mailboxExists(c, mailbox) {
+			// an empty index still catches duplicates within the run
+			st.AddScannedMessageIDs(key, 0, 0, nil)
+			return 0, nil
+		}
+		return 0, err
+	}
+	from := st.MessageIDScan(key, status.UidValidity)
+	if status.Messages == 0 || (status.UidNext > 0 && status.UidNext <= from+1) {
+		st.AddScannedMessageIDs(key, status.UidValidity, from, nil)
+		return 0, nil
+	}
+	seq := new(imap.SeqSet)
+	seq.AddRange(from+1, 0)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope}, msgs)
+	}()
+	var ids []string
+	max, n := from, 0
+	for m := range msgs {
+		if m.Uid <= from {
+			continue // "*" matches the last message even if it is older
+		}
+		n++
+		if m.Uid > max {
+			max = m.Uid
+		}
+		if id := envelopeDedupKey(mode, m.Envelope); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if err := <-done; err != nil {
+		return n, err
+	}
+	st.AddScannedMessageIDs(key, status.UidValidity, max, ids)
+	return n, nil
+}
+
+// indexDedup refreshes the --dedup index of a destination mailbox.
+func (o *copyOptions) indexDedup(dst *client.Client, st *state.State, mailbox string) error {
+	n, err := refreshDedupIndex(dst, st, o.dedup, mailbox)
+	if err != nil {
+		return fmt.Errorf("index %s: %w", mailbox, err)
+	}
+	if o.verbose && n > 0 {
+		log.Printf("[dedup] %s: indexed %d new messages", mailbox, n)
+	}
+	return nil
+}
+
+// mailboxExists reports whether LIST finds mailbox.
+func mailboxExists(c *client.Client, mailbox string) bool {
+	ch := make(chan *imap.MailboxInfo, 1)
+	done := make(chan error, 1)
+	go func() { done <- c.List("", mailbox, ch) }()
+	found := false
+	for range ch {
+		found = true
+	}
+	return <-done == nil && found
+}
+
+// dedupSeen reports whether a message with key is already in the
+// destination mailbox, and otherwise notes it as present so a duplicate
+// later in the same run is skipped too. Messages without a key are never
+// skipped.
+func dedupSeen(o *copyOptions, st *state.State, mailbox, key string) bool {
+	if key == "" {
+		return false
+	}
+	if st.HasMessageID(dedupStateKey(o.dedup, mailbox), key) {
+		if o.verbose {
+			log.Printf("[dedup] %s: skip %s (already present)", mailbox, key)
+		}
+		return true
+	}
+	st.NoteMessageID(dedupStateKey(o.dedup, mailbox), key)
+	return false
+}
+
+// dedupAppend wraps appendMsg to skip messages that are already in the
+// destination mailbox.
+func dedupAppend(o *copyOptions, st *state.State, appendMsg func(mailbox string, raw []byte, flags []string, date time.Time) error) func(mailbox string, raw []byte, flags []string, date time.Time) error {
+	return func(mailbox string, raw []byte, flags []string, date time.Time) error {
+		if dedupSeen(o, st, mailbox, rawDedupKey(o.dedup, raw)) {
+			return nil
+		}
+		return appendMsg(mailbox, raw, flags, date)
+	}
+}
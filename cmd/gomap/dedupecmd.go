@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type dedupeOptions struct {
+	sourceOptions
+	mailboxes []string
+	by        string
+	expunge   bool
+	yes       bool
+}
+
+func addDedupeFlags(cmd *cobra.Command) {
+	o := &dedupeOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringArrayVar(&o.mailboxes, "mailbox", []string{"INBOX"}, "Mailbox to deduplicate; * and % are LIST wildcards (can be repeated)")
+	cmd.Flags().StringVar(&o.by, "by", "message-id", "Match duplicates by 'message-id' or 'header-hash' (date, sender and subject), as copy --dedup")
+	cmd.Flags().BoolVar(&o.expunge, "expunge", true, "Permanently remove messages after marking as \\Deleted")
+	cmd.Flags().BoolVar(&o.yes, "yes", false, "Do not ask for confirmation")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// dedupeCopy is a message dedupe removes; kept is the UID of the oldest
+// copy (lowest UID) with the same key, which stays.
+type dedupeCopy struct {
+	msg  *imap.Message
+	kept uint32
+}
+
+// dedupePlan is the duplicates dedupe removes from one mailbox.
+type dedupePlan struct {
+	mailbox string
+	copies  []dedupeCopy
+}
+
+// runDedupe deletes duplicate messages within mailboxes: of the messages
+// with the same key it keeps the oldest (lowest UID) and marks the others
+// \Deleted. Messages without a key (no Message-ID) are never touched.
+func runDedupe(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*dedupeOptions)
+	if o.by != "message-id" && o.by != "header-hash" {
+		return fmt.Errorf("invalid --by: %s (must be 'message-id' or 'header-hash')", o.by)
+	}
+
+	ctx := cmd.Context()
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	boxes, err := matchingMailboxes(ctx, c, o.mailboxes)
+	if err != nil {
+		return err
+	}
+	if len(boxes) == 0 {
+		fmt.Println("No mailboxes matched.")
+		return nil
+	}
+
+	var plans []dedupePlan
+	total := 0
+	for _, box := range boxes {
+		if _, err := imaputil.SelectMailbox(c, box, true); err != nil {
+			if imaputil.ConnClosed(err) {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "select %s: %v\n", box, err)
+			continue
+		}
+		copies, err := findDuplicates(c, o.by)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fetch %s: %v\n", box, err)
+			continue
+		}
+		if len(copies) == 0 {
+			continue
+		}
+		if o.expunge {
+			if err := imaputil.CheckExpungeUIDs(c, dedupeUIDs(copies)); err != nil {
+				fmt.Fprintf(os.Stderr, "skip %s: %v; expunge or undelete them first, or use --expunge=false\n", box, err)
+				continue
+			}
+		}
+		plans = append(plans, dedupePlan{mailbox: box, copies: copies})
+		total += len(copies)
+	}
+	if total == 0 {
+		fmt.Println("No duplicates found.")
+		return nil
+	}
+
+	var summary strings.Builder
+	for _, p := range plans {
+		fmt.Fprintf(&summary, "%s: %d duplicate(s)\n", p.mailbox, len(p.copies))
+	}
+	fmt.Fprintf(&summary, "Total: %d message(s)\nExpunge: %v", total, o.expunge)
+	switch {
+	case dryRun:
+		// name the copies; the actions on them follow
+		for _, p := range plans {
+			for _, d := range p.copies {
+				dryRunf("%s: delete %s (copy of UID %d)", p.mailbox, strings.TrimSpace(tailLine(d.msg)), d.kept)
+			}
+		}
+	case !o.yes:
+		ok, err := runConfirmTUI("Confirm dedupe", summary.String())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
+	deleted := 0
+	for _, p := range plans {
+		if _, err := imaputil.SelectMailbox(c, p.mailbox, dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "select %s: %v\n", p.mailbox, err)
+			continue
+		}
+		uids := dedupeUIDs(p.copies)
+		// expunge what was marked even if a later chunk fails
+		const chunkSize = 500
+		var stored []uint32
+		for i := 0; i < len(uids); i += chunkSize {
+			end := min(i+chunkSize, len(uids))
+			if err := storeUIDs(c, p.mailbox, uids[i:end], imap.AddFlags, []interface{}{imap.DeletedFlag}); err != nil {
+				fmt.Fprintf(os.Stderr, "store %s: %v\n", p.mailbox, err)
+				break
+			}
+			stored = append(stored, uids[i:end]...)
+		}
+		if len(stored) == 0 {
+			continue
+		}
+		if o.expunge {
+			if err := expungeUIDs(c, p.mailbox, stored); err != nil {
+				fmt.Fprintf(os.Stderr, "expunge %s: %v\n", p.mailbox, err)
+				continue
+			}
+		}
+		deleted += len(stored)
+		if !dryRun {
+			fmt.Printf("Removed %d duplicate(s) from %s.\n", len(stored), p.mailbox)
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	fmt.Printf("Done: %d of %d duplicate(s) removed.\n", deleted, total)
+	if deleted < total {
+		return fmt.Errorf("%d duplicate(s) could not be removed", total-deleted)
+	}
+	return nil
+}
+
+// dedupeUIDs returns the UIDs of copies.
+func dedupeUIDs(copies []dedupeCopy) []uint32 {
+	uids := make([]uint32, len(copies))
+	for i, d := range copies {
+		uids[i] = d.msg.Uid
+	}
+	return uids
+}
+
+// findDuplicates returns the messages of the selected mailbox whose --by
+// key an older message (lower UID) has too, ordered by UID. Messages
+// already marked \Deleted are not counted.
+func findDuplicates(c *client.Client, by string) ([]dedupeCopy, error) {
+	seq := new(imap.SeqSet)
+	seq.AddRange(1, 0)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchFlags, imap.FetchInternalDate, imap.FetchEnvelope}, msgs)
+	}()
+	var found []*imap.Message
+	for m := range msgs {
+		if m != nil && !slices.Contains(m.Flags, imap.DeletedFlag) {
+			found = append(found, m)
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Uid < found[j].Uid })
+	first := map[string]uint32{}
+	var copies []dedupeCopy
+	for _, m := range found {
+		key := envelopeDedupKey(by, m.Envelope)
+		if key == "" {
+			continue
+		}
+		if kept, ok := first[key]; ok {
+			copies = append(copies, dedupeCopy{msg: m, kept: kept})
+			continue
+		}
+		first[key] = m.Uid
+	}
+	return copies, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// dryRun is bound to the global --dry-run flag. Commands that write files
+// or change a server run their actions through perform, or the IMAP
+// helpers below built on it, which list them instead when the flag is
+// set; the reads that decide what to do still happen, so the listing
+// matches what a real run would do.
+var dryRun bool
+
+// perform runs do, or with --dry-run lists action instead.
+func perform(action string, do func() error) error {
+	if dryRun {
+		dryRunf("%s", action)
+		return nil
+	}
+	return do()
+}
+
+// dryRunf lists an action that --dry-run skipped.
+func dryRunf(format string, args ...interface{}) {
+	fmt.Printf("[dry-run] "+format+"\n", args...)
+}
+
+// ensureMailbox creates mailbox on c unless it exists. A dry run only
+// lists the mailboxes that are missing.
+func ensureMailbox(c *client.Client, mailbox string) error {
+	if dryRun {
+		ok, err := imaputil.MailboxSelectable(c, mailbox)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return perform("create mailbox "+mailbox, func() error { return imaputil.EnsureMailbox(c, mailbox) })
+}
+
+// storeUIDs changes the flags of uids in mailbox, the selected mailbox
+// of c, without asking for the new flags back.
+func storeUIDs(c *client.Client, mailbox string, uids []uint32, op imap.FlagsOp, flags []interface{}) error {
+	item := imap.FormatFlagsOp(op, true)
+	names := make([]string, len(flags))
+	for i, f := range flags {
+		names[i] = fmt.Sprint(f)
+	}
+	action := fmt.Sprintf("%s (%s) on %d message(s) in %s", item, strings.Join(names, " "), len(uids), mailbox)
+	return perform(action, func() error {
+		seq := new(imap.SeqSet)
+		seq.AddNum(uids...)
+		return c.UidStore(seq, item, flags, nil)
+	})
+}
+
+// expungeUIDs removes uids, marked \Deleted, from mailbox, the selected
+// mailbox of c (see imaputil.ExpungeUIDs).
+func expungeUIDs(c *client.Client, mailbox string, uids []uint32) error {
+	action := fmt.Sprintf("expunge %d message(s) from %s", len(uids), mailbox)
+	return perform(action, func() error { return imaputil.ExpungeUIDs(c, uids) })
+}
+
+// moveUIDs moves uids from mailbox, the selected mailbox of c, to target.
+func moveUIDs(c *client.Client, mailbox string, uids []uint32, target string) error {
+	action := fmt.Sprintf("move %d message(s) from %s to %s", len(uids), mailbox, target)
+	return perform(action, func() error { return imaputil.MoveUIDs(c, uids, target) })
+}
+
+// dryRunAppend wraps the append of a copy source so a dry run stores
+// nothing; the filters and --dedup around it still pick the messages.
+// The sources show a progress bar meanwhile, so the messages are only
+// listed in the log, with --verbose.
+func dryRunAppend(o *copyOptions, appendMsg func(mailbox string, raw []byte, flags []string, date time.Time) error) func(mailbox string, raw []byte, flags []string, date time.Time) error {
+	if !dryRun {
+		return appendMsg
+	}
+	return func(mailbox string, raw []byte, flags []string, date time.Time) error {
+		if o.verbose {
+			log.Printf("[dry-run] append %s date=%s flags=%v", mailbox, date.Format(time.RFC3339), flags)
+		}
+		return nil
+	}
+}
+
+// saveState writes the resume state of a copy, except with --dry-run:
+// the state records what was copied, and nothing was.
+func (o *copyOptions) saveState(st *state.State) error {
+	if dryRun {
+		return nil
+	}
+	return st.Save(o.stateFile)
+}
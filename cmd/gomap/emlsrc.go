@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// emlSource is one folder of a single-file backup (<uid>.eml files) and
+// the destination mailbox it goes to.
+type emlSource struct {
+	dir     string
+	mailbox string
+	files   []emlFile // sorted by UID
+}
+
+type emlFile struct {
+	path string
+	uid  uint32
+}
+
+// resolveEmlSources finds the folders below root that hold <uid>.eml files,
+// as written by backup in single-file mode. The mailbox is taken from the
+// --metadata sidecar if there is one, else from the folder's path below
+// root ("Archive/2023"). An explicitly set --dst-mailbox becomes the parent
+// folder and --map applies to the derived names.
+func resolveEmlSources(root, dstMbox string, dstMboxSet bool, folderMap map[string]string) ([]emlSource, error) {
+	byDir := map[string][]emlFile{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !strings.HasSuffix(d.Name(), ".eml") {
+			return nil
+		}
+		uid, err := strconv.ParseUint(strings.TrimSuffix(d.Name(), ".eml"), 10, 32)
+		if err != nil {
+			return nil // not written by backup
+		}
+		dir := filepath.Dir(p)
+		byDir[dir] = append(byDir[dir], emlFile{path: p, uid: uint32(uid)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", root, err)
+	}
+	if len(byDir) == 0 {
+		return nil, fmt.Errorf("no <uid>.eml files found in %s", root)
+	}
+	sources := make([]emlSource, 0, len(byDir))
+	for dir, files := range byDir {
+		sort.Slice(files, func(i, j int) bool { return files[i].uid < files[j].uid })
+		name := filepath.ToSlash(dir)
+		if rel, err := filepath.Rel(root, dir); err == nil {
+			name = filepath.ToSlash(rel)
+		}
+		if meta, err := readMessageMeta(files[0].path); err == nil && meta != nil && meta.Mailbox != "" {
+			name = meta.Mailbox
+		}
+		switch {
+		case dstMboxSet:
+			name = dstMbox + "/" + name
+		case strings.EqualFold(name, "INBOX"):
+			name = "INBOX"
+		}
+		if to, ok := folderMap[name]; ok && to != "" {
+			name = to
+		}
+		sources = append(sources, emlSource{dir: dir, mailbox: name, files: files})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].dir < sources[j].dir })
+	return sources, nil
+}
+
+// emlStateKey builds the state key for a single-file folder and destination mailbox.
+func emlStateKey(absDir, dstMailbox string) string {
+	return fmt.Sprintf("eml:%s|dst:%s", absDir, dstMailbox)
+}
+
+// emlMessage reads a message file with the flags and date to append it
+// with: from the sidecar if present, else no flags and the Date header
+// (or the file's modification time).
+func emlMessage(path string) (raw []byte, flags []string, date time.Time, err error) {
+	if raw, err = os.ReadFile(path); err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	meta, err := readMessageMeta(path)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	if meta != nil {
+		for _, f := range meta.Flags {
+			if !strings.EqualFold(f, "\\Recent") {
+				flags = append(flags, f)
+			}
+		}
+		return raw, flags, meta.InternalDate, nil
+	}
+	if msg, perr := mail.ReadMessage(bytes.NewReader(raw)); perr == nil {
+		if t, derr := msg.Header.Date(); derr == nil {
+			return raw, nil, t, nil
+		}
+	}
+	if fi, serr := os.Stat(path); serr == nil {
+		date = fi.ModTime()
+	}
+	return raw, nil, date, nil
+}
+
+func runCopyEml(cmd *cobra.Command, o *copyOptions) error {
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	sources, err := resolveEmlSources(o.emlPath, o.dstMbox, cmd.Flags().Changed("dst-mailbox"), parseMappings(o.mapPairs))
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	ctx := cmd.Context()
+	appendPacer := o.pacer()
+	var appendMsg func(mailbox string, raw []byte, flags []string, date time.Time) error
+	var ensure func(mailbox string) error
+	if o.dstLMTP != "" {
+		// LMTP cannot set flags; messages arrive as new mail
+		d := newLMTPDeliverer(o)
+		defer d.Close()
+		appendMsg = func(mailbox string, raw []byte, flags []string, date time.Time) error {
+			return appendPacer.Do(ctx, func() error { return d.Deliver(ctx, mailbox, bytes.Join(o.messageParts(raw), nil)) })
+		}
+		ensure = func(string) error { return nil }
+	} else {
+		dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+		if err != nil {
+			return fmt.Errorf("connect destination: %w", err)
+		}
+		defer dst.Logout()
+		appendMsg = func(mailbox string, raw []byte, flags []string, date time.Time) error {
+			return appendPacer.Do(ctx, func() error {
+				return imaputil.Append(dst, mailbox, o.flagMap.Apply(flags), date, o.messageParts(raw)...)
+			})
+		}
+		ensure = func(mailbox string) error { return o.ensureMailbox(dst, mailbox) }
+	}
+	appendMsg = o.filters.filterAppend(dryRunAppend(o, appendMsg))
+
+	// Drop the messages restored before, per folder
+	var total int
+	for i, src := range sources {
+		abs, _ := filepath.Abs(src.dir)
+		if !o.ignoreState {
+			mark := st.GetEmlMaxUID(emlStateKey(abs, src.mailbox))
+			kept := src.files[:0]
+			for _, f := range src.files {
+				if f.uid > mark {
+					kept = append(kept, f)
+				}
+			}
+			sources[i].files = kept
+		}
+		if o.verbose {
+			log.Printf("[eml] %s -> %s (%d messages)", src.dir, src.mailbox, len(sources[i].files))
+		}
+		total += len(sources[i].files)
+	}
+	for _, src := range sources {
+		if len(src.files) == 0 {
+			continue
+		}
+		if err := ensure(src.mailbox); err != nil {
+			return fmt.Errorf("ensure mailbox %s: %w", src.mailbox, err)
+		}
+	}
+
+	progress := make(chan int, 128)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(progress)
+		defer close(errc)
+		for _, src := range sources {
+			abs, _ := filepath.Abs(src.dir)
+			key := emlStateKey(abs, src.mailbox)
+			for _, f := range src.files {
+				raw, flags, date, err := emlMessage(f.path)
+				if err != nil {
+					errc <- fmt.Errorf("read %s: %w", f.path, err)
+					return
+				}
+				if err := appendMsg(src.mailbox, raw, flags, date); err != nil {
+					errc <- fmt.Errorf("append %s: %w", f.path, err)
+					return
+				}
+				st.SetEmlMaxUID(key, f.uid)
+				_ = o.saveState(st)
+				progress <- 1
+			}
+		}
+		errc <- nil
+	}()
+
+	_ = runMboxTUI(total, progress, errc)
+	return nil
+}
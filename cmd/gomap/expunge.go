@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+type expungeOptions struct {
+	sourceOptions
+	mailboxes []string
+	trash     bool
+	yes       bool
+}
+
+func addExpungeFlags(cmd *cobra.Command) {
+	o := &expungeOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringArrayVar(&o.mailboxes, "mailbox", nil, "Mailbox to expunge; * and % are LIST wildcards (can be repeated)")
+	cmd.Flags().BoolVar(&o.trash, "all-special-trash", false, "Expunge the mailboxes the server marks as \\Trash (special-use)")
+	cmd.Flags().BoolVar(&o.yes, "yes", false, "Do not ask for confirmation")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// runExpunge permanently removes the messages marked \Deleted from the
+// mailboxes matching --mailbox and, with --all-special-trash, the trash.
+func runExpunge(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*expungeOptions)
+	if len(o.mailboxes) == 0 && !o.trash {
+		return fmt.Errorf("missing required flag: --mailbox or --all-special-trash")
+	}
+
+	ctx := cmd.Context()
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	var boxes []string
+	if len(o.mailboxes) > 0 {
+		if boxes, err = matchingMailboxes(ctx, c, o.mailboxes); err != nil {
+			return err
+		}
+	}
+	if o.trash {
+		uses, err := imaputil.SpecialUse(c)
+		if err != nil {
+			return fmt.Errorf("list special-use mailboxes: %w", err)
+		}
+		found := false
+		for name, attr := range uses {
+			if attr == imap.TrashAttr {
+				found = true
+				if !slices.Contains(boxes, name) {
+					boxes = append(boxes, name)
+				}
+			}
+		}
+		if !found {
+			fmt.Fprintln(os.Stderr, "The server marks no mailbox as \\Trash.")
+		}
+		sort.Strings(boxes)
+	}
+	if len(boxes) == 0 {
+		fmt.Println("No mailboxes matched.")
+		return nil
+	}
+	return expungeDeleted(c, o.srcUser+"@"+o.srcHost, boxes, "", o.yes, nil)
+}
+
+// copiedUIDs collects the UIDs a copy appended, per source mailbox. The
+// copy workers add to it concurrently.
+type copiedUIDs struct {
+	mu   sync.Mutex
+	uids map[string][]uint32
+}
+
+func (c *copiedUIDs) add(mailbox string, uid uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.uids == nil {
+		c.uids = map[string][]uint32{}
+	}
+	c.uids[mailbox] = append(c.uids[mailbox], uid)
+}
+
+func (c *copiedUIDs) get(mailbox string) []uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.uids[mailbox]
+}
+
+// expungeSourceBoxes permanently removes from the source mailboxes of a
+// finished copy (--expunge-source) the messages marked \Deleted that are
+// confirmed copied: appended by this run or recorded in the UID map of
+// the resume state. Messages the filters left out or that failed stay.
+// The counts are shown for confirmation first unless --yes is given.
+func (o *copyOptions) expungeSourceBoxes(src *client.Client, boxes []string, copied *copiedUIDs, st *state.State) error {
+	only := make(map[string][]uint32, len(boxes))
+	for _, b := range boxes {
+		only[b] = append(copied.get(b), st.MappedUIDs(b)...)
+	}
+	return expungeDeleted(src, o.srcUser+"@"+o.srcHost, boxes, "source ", o.yes, only)
+}
+
+// expungeDeleted permanently removes the messages marked \Deleted from
+// boxes on c, the account server, after showing their counts for
+// confirmation unless yes. With only set, just the UIDs it lists per
+// mailbox are candidates; a mailbox where the server lacks UID EXPUNGE
+// and other messages are marked \Deleted is then left alone. side
+// prefixes the mailbox names in messages, e.g. "source ".
+func expungeDeleted(c *client.Client, server string, boxes []string, side string, yes bool, only map[string][]uint32) error {
+	type boxPlan struct {
+		name string
+		uids []uint32
+	}
+	var plans []boxPlan
+	total, skipped := 0, 0
+	for _, box := range boxes {
+		if _, err := imaputil.SelectMailbox(c, box, true); err != nil {
+			return fmt.Errorf("select %s%s: %w", side, box, err)
+		}
+		criteria := imap.NewSearchCriteria()
+		criteria.WithFlags = []string{imap.DeletedFlag}
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			return fmt.Errorf("search %s%s: %w", side, box, err)
+		}
+		if only != nil {
+			uids = intersectUIDs(uids, only[box])
+			if len(uids) == 0 {
+				continue
+			}
+			if err := imaputil.CheckExpungeUIDs(c, uids); err != nil {
+				if !errors.Is(err, imaputil.ErrOtherDeleted) {
+					return fmt.Errorf("search %s%s: %w", side, box, err)
+				}
+				fmt.Printf("Not expunging %s%s: %v\n", side, box, err)
+				skipped++
+				continue
+			}
+		}
+		if len(uids) > 0 {
+			plans = append(plans, boxPlan{name: box, uids: uids})
+			total += len(uids)
+		}
+	}
+	if total == 0 {
+		fmt.Printf("No %smessages are marked \\Deleted; nothing to expunge.\n", side)
+		return skippedErr(skipped)
+	}
+	if !yes && !dryRun {
+		var summary strings.Builder
+		fmt.Fprintf(&summary, "Server: %s\n", server)
+		for _, p := range plans {
+			fmt.Fprintf(&summary, "%s: %d message(s)\n", p.name, len(p.uids))
+		}
+		fmt.Fprintf(&summary, "Total: %d message(s) marked \\Deleted", total)
+		ok, err := runConfirmTUI("Confirm "+side+"expunge", summary.String())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+	for _, p := range plans {
+		if _, err := imaputil.SelectMailbox(c, p.name, dryRun); err != nil {
+			return fmt.Errorf("select %s%s: %w", side, p.name, err)
+		}
+		if err := expungeUIDs(c, side+p.name, p.uids); err != nil {
+			return fmt.Errorf("expunge %s%s: %w", side, p.name, err)
+		}
+		if !dryRun {
+			fmt.Printf("Expunged %d message(s) from %s%s.\n", len(p.uids), side, p.name)
+		}
+	}
+	return skippedErr(skipped)
+}
+
+// skippedErr reports the mailboxes expungeDeleted had to leave alone.
+func skippedErr(n int) error {
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d mailbox(es) not expunged", n)
+}
+
+// intersectUIDs returns the UIDs of uids that are also in keep, in the
+// order of uids.
+func intersectUIDs(uids, keep []uint32) []uint32 {
+	in := make(map[uint32]bool, len(keep))
+	for _, uid := range keep {
+		in[uid] = true
+	}
+	var out []uint32
+	for _, uid := range uids {
+		if in[uid] {
+			out = append(out, uid)
+		}
+	}
+	return out
+}
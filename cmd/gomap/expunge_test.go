@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIntersectUIDs(t *testing.T) {
+	tests := []struct {
+		uids, keep []uint32
+		want       string
+	}{
+		{nil, []uint32{1, 2}, "[]"},
+		{[]uint32{1, 2, 3}, nil, "[]"},
+		{[]uint32{3, 5, 8, 9}, []uint32{9, 1, 5}, "[5 9]"},
+		{[]uint32{4, 7}, []uint32{4, 4, 7}, "[4 7]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(intersectUIDs(tt.uids, tt.keep)); got != tt.want {
+			t.Errorf("intersectUIDs(%v, %v) = %s, want %s", tt.uids, tt.keep, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+// exTempFail is sysexits' EX_TEMPFAIL: procmail, maildrop and MTAs keep the
+// message and retry later.
+const exTempFail = 75
+
+type filterOptions struct {
+	// Destination IMAP
+	dstHost     string
+	dstPort     int
+	dstUser     string
+	dstPass     string
+	dstIdentity string
+	dstURL      string
+	insecure    bool
+	startTLS    bool
+	// Destination SMTP
+	smtpHost string
+	smtpPort int
+	smtpUser string
+	smtpPass string
+	smtpSSL  bool
+	smtpURL  string
+	from     string
+	to       []string
+
+	mailbox string
+	routes  []string
+}
+
+func addFilterFlags(cmd *cobra.Command) {
+	o := &filterOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.dstHost, "dst-host", "", "Destination IMAP host (deliver via APPEND)")
+	cmd.Flags().IntVar(&o.dstPort, "dst-port", 993, "Destination IMAP port")
+	cmd.Flags().StringVar(&o.dstUser, "dst-user", "", "Destination IMAP username")
+	cmd.Flags().StringVar(&o.dstPass, "dst-pass", "", "Destination IMAP password")
+	cmd.Flags().StringVar(&o.dstIdentity, "dst-identity", "", "Use the IMAP account of this identity from the config as destination")
+	cmd.Flags().StringVar(&o.dstURL, "dst", "", "Destination as a connection URL, e.g. imaps://user@host:993 (instead of --dst-host, --dst-port and --dst-user)")
+	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
+	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS (IMAP)")
+	cmd.Flags().StringVar(&o.smtpHost, "smtp-host", "", "Forward via this SMTP server instead of IMAP APPEND")
+	cmd.Flags().IntVar(&o.smtpPort, "smtp-port", 587, "SMTP server port")
+	cmd.Flags().StringVar(&o.smtpUser, "smtp-user", "", "SMTP username")
+	cmd.Flags().StringVar(&o.smtpPass, "smtp-pass", "", "SMTP password")
+	cmd.Flags().BoolVar(&o.smtpSSL, "smtp-ssl", false, "Use implicit TLS for SMTP (port 465)")
+	cmd.Flags().StringVar(&o.smtpURL, "smtp", "", "Forward via the SMTP server of this connection URL, e.g. smtps://user@host:465 (instead of --smtp-host, --smtp-port and --smtp-user)")
+	cmd.Flags().StringVar(&o.from, "from", "", "SMTP envelope sender (default: Return-Path of the message, else empty)")
+	cmd.Flags().StringArrayVar(&o.to, "to", nil, "SMTP recipient when no route matches (repeatable)")
+	cmd.Flags().StringVar(&o.mailbox, "mailbox", "INBOX", "IMAP mailbox when no route matches")
+	cmd.Flags().StringArrayVar(&o.routes, "route", nil, "Routing rule HEADER:REGEX=TARGET (repeatable, first match wins); TARGET is a mailbox, or recipients with --smtp-host")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// exitCodeError makes main exit with code instead of 1.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+type route struct {
+	header string
+	re     *regexp.Regexp
+	target string
+}
+
+// parseRoutes parses HEADER:REGEX=TARGET rules. The regex may itself
+// contain ':' and '=': the header ends at the first ':' and the target
+// starts after the last '='.
+func parseRoutes(specs []string) ([]route, error) {
+	routes := make([]route, 0, len(specs))
+	for _, spec := range specs {
+		header, rest, ok := strings.Cut(spec, ":")
+		i := strings.LastIndex(rest, "=")
+		if !ok || i < 0 || strings.TrimSpace(header) == "" || strings.TrimSpace(rest[i+1:]) == "" {
+			return nil, fmt.Errorf("invalid --route %q (expected HEADER:REGEX=TARGET)", spec)
+		}
+		re, err := regexp.Compile(rest[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --route %q: %w", spec, err)
+		}
+		routes = append(routes, route{header: strings.TrimSpace(header), re: re, target: strings.TrimSpace(rest[i+1:])})
+	}
+	return routes, nil
+}
+
+// matchRoute returns the target of the first route whose header matches.
+// Encoded words are decoded first; a repeated header (Received, To) matches
+// if any instance does.
+func matchRoute(routes []route, hdr mail.Header) (string, bool) {
+	dec := new(mime.WordDecoder)
+	for _, r := range routes {
+		for _, v := range hdr[textproto.CanonicalMIMEHeaderKey(r.header)] {
+			if d, err := dec.DecodeHeader(v); err == nil {
+				v = d
+			}
+			if r.re.MatchString(v) {
+				return r.target, true
+			}
+		}
+	}
+	return "", false
+}
+
+func runFilter(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*filterOptions)
+	routes, err := parseRoutes(o.routes)
+	if err != nil {
+		return err
+	}
+	err = applyEndpoints(cmd, endpointFlag{loginTarget{prefix: "smtp", host: &o.smtpHost, port: &o.smtpPort, user: &o.smtpUser, pass: &o.smtpPass, ssl: &o.smtpSSL, insecure: &o.insecure}, "smtp", o.smtpURL})
+	if err != nil {
+		return err
+	}
+	viaSMTP := o.smtpHost != ""
+	if !viaSMTP {
+		if o.dstIdentity != "" {
+			_, acc, _, err := lookupIdentity(o.dstIdentity)
+			if err != nil {
+				return err
+			}
+			applyAccount(cmd, loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+		}
+		err := applyEndpoints(cmd, endpointFlag{loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, "imap", o.dstURL})
+		if err != nil {
+			return err
+		}
+		if !dryRun && (o.dstHost == "" || o.dstUser == "" || o.dstPass == "") {
+			return fmt.Errorf("missing required flags: --dst-host, --dst-user, --dst-pass (or --smtp-host)")
+		}
+	}
+
+	// procmail and maildrop may hand over the mbox "From " envelope line.
+	br := bufio.NewReader(os.Stdin)
+	if peek, _ := br.Peek(5); string(peek) == "From " {
+		if _, err := br.ReadString('\n'); err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+	}
+	raw, err := io.ReadAll(br)
+	if err != nil {
+		return fmt.Errorf("read message: %w", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("parse message: %w", err)
+	}
+
+	target, matched := matchRoute(routes, msg.Header)
+	if viaSMTP {
+		rcpts := o.to
+		if matched {
+			rcpts = strings.Split(target, ",")
+			for i := range rcpts {
+				rcpts[i] = strings.TrimSpace(rcpts[i])
+			}
+		}
+		if len(rcpts) == 0 {
+			return fmt.Errorf("no recipient: no route matched and --to is empty")
+		}
+		from := o.from
+		if from == "" {
+			if a, err := mail.ParseAddress(msg.Header.Get("Return-Path")); err == nil {
+				from = a.Address
+			}
+		}
+		srv := smtpServer{host: o.smtpHost, port: o.smtpPort, user: o.smtpUser, pass: o.smtpPass, startTLS: true, ssl: o.smtpSSL, insecure: o.insecure}
+		return perform(fmt.Sprintf("forward via %s to %s", o.smtpHost, strings.Join(rcpts, ", ")), func() error {
+			err := srv.send(from, rcpts, int64(len(raw)), func(w io.Writer) error {
+				_, err := w.Write(raw)
+				return err
+			})
+			if err != nil {
+				return &exitCodeError{code: exTempFail, err: fmt.Errorf("forward: %w", err)}
+			}
+			return nil
+		})
+	}
+
+	mailbox := imaputil.DecodeMailboxName(o.mailbox)
+	if matched {
+		mailbox = target
+	}
+	// a dry run needs no destination login, so it does not connect
+	return perform("append to "+mailbox, func() error {
+		dst, err := imaputil.DialAndLogin(cmd.Context(), o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, &tls.Config{InsecureSkipVerify: o.insecure})
+		if err != nil {
+			return &exitCodeError{code: exTempFail, err: fmt.Errorf("connect destination: %w", err)}
+		}
+		defer dst.Logout()
+		if err := imaputil.EnsureMailbox(dst, mailbox); err != nil {
+			return &exitCodeError{code: exTempFail, err: fmt.Errorf("ensure mailbox: %w", err)}
+		}
+		if err := dst.Append(mailbox, nil, time.Now(), bytes.NewReader(raw)); err != nil {
+			return &exitCodeError{code: exTempFail, err: fmt.Errorf("append: %w", err)}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type flagsOptions struct {
+	sourceOptions
+	mailboxes []string
+	add       []string
+	remove    []string
+	search    string
+	yes       bool
+}
+
+func addFlagsFlags(cmd *cobra.Command) {
+	o := &flagsOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringArrayVar(&o.mailboxes, "mailbox", []string{"INBOX"}, "Mailbox to change; * and % are LIST wildcards (can be repeated)")
+	cmd.Flags().StringArrayVar(&o.add, "add", nil, "Flag or keyword to set, e.g. '\\Seen' or '$Junk' (can be repeated)")
+	cmd.Flags().StringArrayVar(&o.remove, "remove", nil, "Flag or keyword to clear (can be repeated)")
+	cmd.Flags().StringVar(&o.search, "search", "all", "Messages to change, e.g. 'unseen' or 'seen unflagged': all, seen, unseen, flagged, unflagged, answered, unanswered, draft, undraft, deleted, undeleted")
+	cmd.Flags().BoolVar(&o.yes, "yes", false, "Do not ask for confirmation")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// flagSearchKeys are the --search keywords of flags: the flag a message
+// must have, or lack for the "un" form.
+var flagSearchKeys = map[string]string{
+	"seen":     imap.SeenFlag,
+	"flagged":  imap.FlaggedFlag,
+	"answered": imap.AnsweredFlag,
+	"draft":    imap.DraftFlag,
+	"deleted":  imap.DeletedFlag,
+}
+
+// parseFlagSearch returns the SEARCH criteria of a --search value: space
+// or comma separated keywords that must all hold.
+func parseFlagSearch(s string) (*imap.SearchCriteria, error) {
+	c := imap.NewSearchCriteria()
+	for _, key := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ' ' || r == ',' }) {
+		if key == "all" {
+			continue
+		}
+		if flag, ok := flagSearchKeys[key]; ok {
+			c.WithFlags = append(c.WithFlags, flag)
+			continue
+		}
+		if flag, ok := flagSearchKeys[strings.TrimPrefix(key, "un")]; ok && strings.HasPrefix(key, "un") {
+			c.WithoutFlags = append(c.WithoutFlags, flag)
+			continue
+		}
+		return nil, fmt.Errorf("invalid --search keyword %q (expected all, seen, unseen, flagged, unflagged, answered, unanswered, draft, undraft, deleted or undeleted)", key)
+	}
+	return c, nil
+}
+
+// storeFlags returns the flags of --add or --remove as sent to the server:
+// system flags may be given without the backslash ("Seen").
+func storeFlags(names []string) ([]interface{}, error) {
+	var out []interface{}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" || strings.ContainsAny(n, " ()\"") {
+			return nil, fmt.Errorf("invalid flag %q", n)
+		}
+		for _, sys := range flagSearchKeys {
+			if strings.EqualFold(n, sys) || strings.EqualFold(n, sys[1:]) {
+				n = sys
+			}
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// flagsPlan is the messages flags changes in one mailbox.
+type flagsPlan struct {
+	mailbox string
+	uids    []uint32
+}
+
+// runFlags sets and clears flags on the messages of the mailboxes matching
+// --mailbox that match --search, after a confirmation.
+func runFlags(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*flagsOptions)
+	if len(o.add) == 0 && len(o.remove) == 0 {
+		return fmt.Errorf("nothing to do: give --add or --remove")
+	}
+	add, err := storeFlags(o.add)
+	if err != nil {
+		return fmt.Errorf("--add: %w", err)
+	}
+	remove, err := storeFlags(o.remove)
+	if err != nil {
+		return fmt.Errorf("--remove: %w", err)
+	}
+	criteria, err := parseFlagSearch(o.search)
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	boxes, err := matchingMailboxes(ctx, c, o.mailboxes)
+	if err != nil {
+		return err
+	}
+	if len(boxes) == 0 {
+		fmt.Println("No mailboxes matched.")
+		return nil
+	}
+
+	var plans []flagsPlan
+	total := 0
+	for _, box := range boxes {
+		if _, err := imaputil.SelectMailbox(c, box, true); err != nil {
+			if imaputil.ConnClosed(err) {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "select %s: %v\n", box, err)
+			continue
+		}
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "search %s: %v\n", box, err)
+			continue
+		}
+		if len(uids) == 0 {
+			continue
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+		plans = append(plans, flagsPlan{mailbox: box, uids: uids})
+		total += len(uids)
+	}
+	if total == 0 {
+		fmt.Println("No messages matched.")
+		return nil
+	}
+
+	var summary strings.Builder
+	for _, p := range plans {
+		fmt.Fprintf(&summary, "%s: %d message(s)\n", p.mailbox, len(p.uids))
+	}
+	fmt.Fprintf(&summary, "Total: %d message(s)", total)
+	if len(add) > 0 {
+		fmt.Fprintf(&summary, "\nAdd: %s", strings.Join(o.add, " "))
+	}
+	if len(remove) > 0 {
+		fmt.Fprintf(&summary, "\nRemove: %s", strings.Join(o.remove, " "))
+	}
+	if !o.yes && !dryRun {
+		ok, err := runConfirmTUI("Confirm flags", summary.String())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
+	var failed []string
+	err = runCountWork(ctx, total, "Flags", func(ctx context.Context, progress chan<- int) error {
+		const chunkSize = 500
+		for _, p := range plans {
+			if ctx.Err() != nil {
+				break
+			}
+			if _, err := imaputil.SelectMailbox(c, p.mailbox, dryRun); err != nil {
+				failed = append(failed, fmt.Sprintf("select %s: %v", p.mailbox, err))
+				continue
+			}
+			for i := 0; i < len(p.uids) && ctx.Err() == nil; i += chunkSize {
+				end := min(i+chunkSize, len(p.uids))
+				var err error
+				if len(add) > 0 {
+					err = storeUIDs(c, p.mailbox, p.uids[i:end], imap.AddFlags, add)
+				}
+				if err == nil && len(remove) > 0 {
+					err = storeUIDs(c, p.mailbox, p.uids[i:end], imap.RemoveFlags, remove)
+				}
+				if err != nil {
+					failed = append(failed, fmt.Sprintf("store %s: %v", p.mailbox, err))
+					break
+				}
+				progress <- end - i
+			}
+		}
+		return ctx.Err()
+	})
+
+	for _, f := range failed {
+		fmt.Fprintln(os.Stderr, f)
+	}
+	if err != nil {
+		return fmt.Errorf("flags stopped: %w", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d mailbox(es) could not be changed", len(failed))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseFlagSearch(t *testing.T) {
+	tests := []struct {
+		in          string
+		with, witho string
+	}{
+		{"", "[]", "[]"},
+		{"all", "[]", "[]"},
+		{"unseen", "[]", `[\Seen]`},
+		{"Flagged, unanswered", `[\Flagged]`, `[\Answered]`},
+		{"seen undeleted draft", `[\Seen \Draft]`, `[\Deleted]`},
+	}
+	for _, tt := range tests {
+		c, err := parseFlagSearch(tt.in)
+		if err != nil {
+			t.Fatalf("parseFlagSearch(%q): %v", tt.in, err)
+		}
+		if with, witho := fmt.Sprint(c.WithFlags), fmt.Sprint(c.WithoutFlags); with != tt.with || witho != tt.witho {
+			t.Errorf("parseFlagSearch(%q) = with %s, without %s; want %s, %s", tt.in, with, witho, tt.with, tt.witho)
+		}
+	}
+	for _, in := range []string{"recent", "unknown", "seen,old"} {
+		if _, err := parseFlagSearch(in); err == nil {
+			t.Errorf("parseFlagSearch(%q) succeeded", in)
+		}
+	}
+}
+
+func TestStoreFlags(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{nil, "[]"},
+		{[]string{"Seen", `\flagged`, " draft "}, `[\Seen \Flagged \Draft]`},
+		{[]string{"$Junk", "NonJunk"}, "[$Junk NonJunk]"},
+	}
+	for _, tt := range tests {
+		got, err := storeFlags(tt.in)
+		if err != nil {
+			t.Fatalf("storeFlags(%q): %v", tt.in, err)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("storeFlags(%q) = %v, want %s", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"", "two words", "(Seen)", `"x"`} {
+		if _, err := storeFlags([]string{in}); err == nil {
+			t.Errorf("storeFlags(%q) succeeded", in)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+// followMailbox is the source mailbox --follow watches with IDLE: the one
+// new mail arrives in. Messages filed into other mailboxes are picked up
+// by the full pass every --follow-interval.
+const followMailbox = "INBOX"
+
+// followRetry is how long --follow waits before it reconnects after the
+// watching connection broke.
+const followRetry = 30 * time.Second
+
+// followSource keeps copying after the initial copy until SIGINT/SIGTERM, so a
+// cutover leaves next to no delta: it waits in IDLE on the source INBOX
+// and copies its new messages as soon as the server announces them, and
+// every --follow-interval it copies the new messages of all mailboxes
+// keep accepts. Servers without IDLE are polled every --follow-poll. Each
+// pass is an incremental copy from the state file with fresh connections,
+// as nightly-delta runs are.
+func (o *copyOptions) followSource(ctx context.Context, keep func(name string) bool) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	inbox := func(name string) bool { return name == followMailbox && keep(name) }
+	full := time.NewTicker(o.followInterval)
+	defer full.Stop()
+	log.Printf("[follow] watching the source %s for new messages, all mailboxes every %s (Ctrl-C to stop)", followMailbox, o.followInterval)
+	for {
+		err := o.watch(ctx, full.C, func(all bool) {
+			filter := inbox
+			if all {
+				filter = keep
+			}
+			o.followPass(ctx, filter)
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("[follow] %v; reconnecting in %s", err, followRetry)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(followRetry):
+		}
+		// the connection may have broken while mail came in
+		o.followPass(ctx, keep)
+	}
+}
+
+// watch waits in IDLE on followMailbox with a connection of its own and
+// calls pass(false) whenever the mailbox changed, and pass(true) on each
+// tick of full. It returns when ctx ends or the connection fails.
+func (o *copyOptions) watch(ctx context.Context, full <-chan time.Time, pass func(all bool)) error {
+	c, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, &tls.Config{InsecureSkipVerify: o.insecure})
+	if err != nil {
+		return fmt.Errorf("connect source: %w", err)
+	}
+	defer c.Logout()
+	newMail := newMailSignal(c)
+	if _, err := imaputil.SelectMailbox(c, followMailbox, true); err != nil {
+		return fmt.Errorf("select %s: %w", followMailbox, err)
+	}
+	for {
+		stopIdle := make(chan struct{})
+		idleDone := make(chan error, 1)
+		go func() {
+			idleDone <- c.Idle(stopIdle, &client.IdleOptions{PollInterval: o.followPoll})
+		}()
+		all := false
+		select {
+		case <-ctx.Done():
+			close(stopIdle)
+			<-idleDone
+			return ctx.Err()
+		case err := <-idleDone:
+			return fmt.Errorf("idle: %w", err)
+		case <-newMail:
+		case <-full:
+			all = true
+		}
+		close(stopIdle)
+		if err := <-idleDone; err != nil {
+			return fmt.Errorf("idle: %w", err)
+		}
+		pass(all)
+	}
+}
+
+// followPass runs one incremental copy of the mailboxes keep accepts and
+// logs what it copied and what failed.
+func (o *copyOptions) followPass(ctx context.Context, keep func(name string) bool) {
+	res := syncDelta(ctx, o, keep)
+	if ctx.Err() != nil {
+		return
+	}
+	boxes := make([]string, 0, len(res.copied))
+	for box := range res.copied {
+		boxes = append(boxes, box)
+	}
+	sort.Strings(boxes)
+	for _, box := range boxes {
+		log.Printf("[follow] %s: copied %d new message(s)", box, res.copied[box])
+	}
+	for _, err := range res.errs {
+		log.Printf("[follow] %v", err)
+	}
+	o.writeFailures(res.summary)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/gmailapi"
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// gmailSystemFolders maps Gmail system label IDs to destination folder names.
+// System labels not listed here (CHAT, UNREAD, STARRED, CATEGORY_*, ...) are
+// not copied as folders.
+var gmailSystemFolders = map[string]string{
+	"INBOX":     "INBOX",
+	"SENT":      "Sent",
+	"DRAFT":     "Drafts",
+	"SPAM":      "Junk",
+	"TRASH":     "Trash",
+	"IMPORTANT": "Important",
+}
+
+// gmailFlags derives IMAP flags from Gmail label IDs.
+func gmailFlags(labelIDs []string) []string {
+	seen := true
+	flags := []string{}
+	for _, l := range labelIDs {
+		switch l {
+		case "UNREAD":
+			seen = false
+		case "STARRED":
+			flags = append(flags, imap.FlaggedFlag)
+		case "DRAFT":
+			flags = append(flags, imap.DraftFlag)
+		}
+	}
+	if seen {
+		flags = append(flags, imap.SeenFlag)
+	}
+	return flags
+}
+
+// gmailStateKey identifies the resume state of a Gmail label copied to
+// dstMailbox.
+func gmailStateKey(labelID, dstMailbox string) string {
+	return fmt.Sprintf("gmail:%s|dst:%s", labelID, dstMailbox)
+}
+
+func runCopyGmail(cmd *cobra.Command, o *copyOptions) error {
+	includeRe, excludeRe, err := mailboxFilters(o.include, o.exclude)
+	if err != nil {
+		return err
+	}
+	var sinceTime time.Time
+	if o.since != "" {
+		sinceTime, err = time.Parse("2006-01-02", o.since)
+		if err != nil {
+			return fmt.Errorf("invalid --since date: %w (expected YYYY-MM-DD)", err)
+		}
+	}
+
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+
+	ctx := cmd.Context()
+	gc := gmailapi.New(o.srcGmailToken)
+	labels, err := gc.ListLabels(ctx)
+	if err != nil {
+		return fmt.Errorf("list labels: %w", err)
+	}
+
+	folderMap := parseMappings(o.mapPairs)
+	type labelPlan struct {
+		label gmailapi.Label
+		dst   string
+		key   string
+		ids   []string
+	}
+	plans := []labelPlan{}
+	total := 0
+	for _, l := range labels {
+		name := l.Name
+		if l.Type == "system" {
+			folder, ok := gmailSystemFolders[l.ID]
+			if !ok {
+				continue
+			}
+			name = folder
+		}
+		if includeRe != nil && !includeRe.MatchString(name) {
+			continue
+		}
+		if excludeRe != nil && excludeRe.MatchString(name) {
+			continue
+		}
+		if (o.skipSpecial || o.skipTrash) && o.trashAs == "" && l.ID == "TRASH" ||
+			(o.skipSpecial || o.skipJunk) && o.junkAs == "" && l.ID == "SPAM" ||
+			(o.skipSpecial || o.skipDrafts) && l.ID == "DRAFT" ||
+			(o.skipSpecial || o.skipSent) && l.ID == "SENT" {
+			continue
+		}
+		ids, err := gc.ListMessageIDs(ctx, l.ID, sinceTime, o.beforeTime)
+		if err != nil {
+			return fmt.Errorf("list messages for label %s: %w", l.Name, err)
+		}
+		dst := name
+		if to, ok := folderMap[name]; ok && to != "" {
+			dst = to
+		} else if l.ID == "TRASH" && o.trashAs != "" {
+			dst = o.trashAs
+		} else if l.ID == "SPAM" && o.junkAs != "" {
+			dst = o.junkAs
+		} else {
+			dst = o.prefixName(name)
+		}
+		dst = o.flatName(dst)
+		key := gmailStateKey(l.ID, dst)
+		// Drop the messages copied before
+		if !o.ignoreState {
+			done := st.GmailDoneIDs(key)
+			kept := ids[:0]
+			for _, id := range ids {
+				if !done[id] {
+					kept = append(kept, id)
+				}
+			}
+			ids = kept
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		plans = append(plans, labelPlan{label: l, dst: dst, key: key, ids: ids})
+		total += len(ids)
+		if o.verbose {
+			log.Printf("[gmail] label %s -> %s: %d messages", l.Name, dst, len(ids))
+		}
+	}
+	if total == 0 {
+		fmt.Println("No messages to process.")
+		return nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("connect destination: %w", err)
+	}
+	defer dst.Logout()
+	appendPacer := o.pacer()
+	for _, p := range plans {
+		if err := o.ensureMailbox(dst, p.dst); err != nil {
+			return fmt.Errorf("ensure mailbox %s: %w", p.dst, err)
+		}
+	}
+
+	progress := make(chan int, 128)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(progress)
+		defer close(errc)
+		for _, p := range plans {
+			for start := 0; start < len(p.ids); start += gmailapi.MaxBatch {
+				end := start + gmailapi.MaxBatch
+				if end > len(p.ids) {
+					end = len(p.ids)
+				}
+				// a dry run does not download the messages
+				if dryRun {
+					if o.verbose {
+						log.Printf("[dry-run] append %d messages from label %s to %s", end-start, p.label.Name, p.dst)
+					}
+					progress <- end - start
+					continue
+				}
+				msgs, err := gc.BatchGetRaw(ctx, p.ids[start:end])
+				if err != nil {
+					errc <- fmt.Errorf("fetch label %s: %w", p.label.Name, err)
+					return
+				}
+				for _, m := range msgs {
+					err := appendPacer.Do(ctx, func() error {
+						return imaputil.Append(dst, p.dst, o.flagMap.Apply(gmailFlags(m.LabelIDs)), m.InternalDate, o.messageParts(m.Raw)...)
+					})
+					if err != nil {
+						errc <- fmt.Errorf("append to %s: %w", p.dst, err)
+						return
+					}
+					st.AddGmailDone(p.key, m.ID)
+					progress <- 1
+				}
+				// once per batch: the state holds every ID copied, so
+				// saving it per message would grow quadratically
+				if err := o.saveState(st); err != nil {
+					errc <- fmt.Errorf("save state: %w", err)
+					return
+				}
+			}
+		}
+		errc <- nil
+	}()
+
+	errs := runCountTUI(total, "Gmail API copy", progress, errc)
+	if len(errs) > 0 {
+		fmt.Println("Finished with errors:")
+		for _, e := range errs {
+			fmt.Println(" -", e)
+		}
+	}
+	if err := o.saveState(st); err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	return nil
+}
+
+// gmailToken returns the OAuth2 access token from the flag or the
+// GOMAP_GMAIL_TOKEN environment variable.
+func gmailToken(flagVal string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	return strings.TrimSpace(os.Getenv("GOMAP_GMAIL_TOKEN"))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/mailstore"
+)
+
+// mailstoreFile is the database name written by `backup --format sqlite`.
+const mailstoreFile = "gomap.db"
+
+type grepOptions struct {
+	db      string
+	mailbox string
+	limit   int
+	mbox    bool
+}
+
+func addGrepFlags(cmd *cobra.Command) {
+	o := &grepOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.db, "db", filepath.Join("gomap-download", mailstoreFile), "Database written by 'backup --format sqlite'")
+	cmd.Flags().StringVar(&o.mailbox, "mailbox", "", "Only search this mailbox")
+	cmd.Flags().IntVar(&o.limit, "limit", 50, "Maximum number of results (0 = unlimited)")
+	cmd.Flags().BoolVar(&o.mbox, "mbox", false, "Write the matching messages to stdout in mbox format instead of a summary")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+func runGrep(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*grepOptions)
+	if _, err := os.Stat(o.db); err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	store, err := mailstore.Open(o.db)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer store.Close()
+
+	hits, err := store.Search(strings.Join(args, " "), o.mailbox, o.limit)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	if o.mbox {
+		for _, h := range hits {
+			raw, err := store.Raw(h.Mailbox, h.UID)
+			if err != nil {
+				return fmt.Errorf("read %s/%d: %w", h.Mailbox, h.UID, err)
+			}
+			if err := appendToMbox(os.Stdout, raw, h.InternalDate, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, h := range hits {
+		fmt.Printf("%s/%d  %s  %s  %s\n", h.Mailbox, h.UID, h.InternalDate.Format("2006-01-02"), h.From, h.Subject)
+		if s := strings.Join(strings.Fields(h.Snippet), " "); s != "" {
+			fmt.Printf("    %s\n", s)
+		}
+	}
+	if len(hits) == 0 {
+		fmt.Fprintln(os.Stderr, "No matches.")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+// headerFilter is one --header-filter: messages are kept if a value of
+// header matches re, or, with exclude, if none does.
+type headerFilter struct {
+	header  string
+	re      *regexp.Regexp
+	exclude bool
+}
+
+// headerFilters is the set of --header-filter and --subject-filter of a
+// run. A message passes if it passes every filter.
+type headerFilters []headerFilter
+
+// parseHeaderFilters parses --header-filter 'HEADER:REGEX' specs and
+// --subject-filter 'REGEX' specs, the latter short for 'Subject:REGEX'.
+// A leading "!" turns a filter into an exclusion. Patterns match
+// case-insensitively.
+func parseHeaderFilters(headers, subjects []string) (headerFilters, error) {
+	var fs headerFilters
+	add := func(flag, spec, header, pattern string) error {
+		exclude := strings.HasPrefix(header, "!")
+		header = strings.TrimSpace(strings.TrimPrefix(header, "!"))
+		if header == "" || strings.ContainsAny(header, " \t") {
+			return fmt.Errorf("invalid %s %q (expected HEADER:REGEX)", flag, spec)
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", flag, spec, err)
+		}
+		fs = append(fs, headerFilter{header: textproto.CanonicalMIMEHeaderKey(header), re: re, exclude: exclude})
+		return nil
+	}
+	for _, spec := range headers {
+		header, pattern, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid --header-filter %q (expected HEADER:REGEX)", spec)
+		}
+		if err := add("--header-filter", spec, header, pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range subjects {
+		header := "Subject"
+		if strings.HasPrefix(spec, "!") {
+			header, spec = "!Subject", spec[1:]
+		}
+		if err := add("--subject-filter", spec, header, spec); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// fields returns the header names the filters look at.
+func (fs headerFilters) fields() []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, f := range fs {
+		if !seen[f.header] {
+			seen[f.header] = true
+			out = append(out, f.header)
+		}
+	}
+	return out
+}
+
+// match reports whether a message with header hdr passes the filters.
+// Encoded words are decoded first; a repeated header matches if any
+// instance does, and a missing header matches nothing.
+func (fs headerFilters) match(hdr mail.Header) bool {
+	dec := new(mime.WordDecoder)
+	for _, f := range fs {
+		found := false
+		for _, v := range hdr[f.header] {
+			if d, err := dec.DecodeHeader(v); err == nil {
+				v = d
+			}
+			if f.re.MatchString(v) {
+				found = true
+				break
+			}
+		}
+		if found == f.exclude {
+			return false
+		}
+	}
+	return true
+}
+
+// matcher returns match, or nil without filters so that nothing is
+// fetched for them.
+func (fs headerFilters) matcher() func(hdr mail.Header) bool {
+	if len(fs) == 0 {
+		return nil
+	}
+	return fs.match
+}
+
+// filterAppend wraps appendMsg to drop raw messages that do not pass the
+// filters.
+func (fs headerFilters) filterAppend(appendMsg func(mailbox string, raw []byte, flags []string, date time.Time) error) func(mailbox string, raw []byte, flags []string, date time.Time) error {
+	if len(fs) == 0 {
+		return appendMsg
+	}
+	return func(mailbox string, raw []byte, flags []string, date time.Time) error {
+		if !fs.match(imaputil.ParseHeader(raw)) {
+			return nil
+		}
+		return appendMsg(mailbox, raw, flags, date)
+	}
+}
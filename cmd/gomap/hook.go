@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// runMessageHook runs the --exec-per-message command for the message of
+// mailbox with the given UID that was written to path. Like watch --exec
+// it goes through the shell (see runShellCommand); every "{}" is replaced
+// with the quoted path, similar to find -exec, and if there is none the
+// path is appended as the last argument. GOMAP_FILE, GOMAP_MAILBOX and
+// GOMAP_UID describe the message in the environment.
+func runMessageHook(ctx context.Context, command, path, mailbox string, uid uint32) error {
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
+	if quoted := shellQuote(path); strings.Contains(command, "{}") {
+		command = strings.ReplaceAll(command, "{}", quoted)
+	} else {
+		command += " " + quoted
+	}
+	env := []string{
+		"GOMAP_FILE=" + path,
+		"GOMAP_MAILBOX=" + mailbox,
+		"GOMAP_UID=" + strconv.FormatUint(uint64(uid), 10),
+	}
+	if err := runShellCommand(ctx, command, env); err != nil {
+		return fmt.Errorf("hook: %w", err)
+	}
+	return nil
+}
+
+// runShellCommand runs command through the shell (sh -c, cmd /C on
+// Windows) with env added to the environment, so the command line can
+// use the variables, e.g. notify-send "$GOMAP_FROM" "$GOMAP_SUBJECT".
+// Output is passed through to the terminal.
+func runShellCommand(ctx context.Context, command string, env []string) error {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		c = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	c.Env = append(os.Environ(), env...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("exec %q: %w", command, err)
+	}
+	return nil
+}
+
+// shellQuote quotes s as one word for runShellCommand.
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + s + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
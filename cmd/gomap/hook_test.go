@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRunMessageHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	dir := t.TempDir()
+	eml := filepath.Join(dir, "it's 7.eml")
+	out := filepath.Join(dir, "out")
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{`printf '%s|%s|%s|%s' {} "$GOMAP_FILE" "$GOMAP_MAILBOX" "$GOMAP_UID" > ` + shellQuote(out), eml + "|" + eml + "|INBOX|7"},
+		{`printf '%s' > ` + shellQuote(out), eml},
+	}
+	for _, tt := range tests {
+		if err := runMessageHook(context.Background(), tt.command, eml, "INBOX", 7); err != nil {
+			t.Fatalf("runMessageHook(%q): %v", tt.command, err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("runMessageHook(%q) wrote %q, want %q", tt.command, got, tt.want)
+		}
+	}
+	if err := runMessageHook(context.Background(), "exit 3", eml, "INBOX", 7); err == nil {
+		t.Error("failing hook returned no error")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type listOptions struct {
+	sourceOptions
+	include string
+	exclude string
+	sample  int
+	json    bool
+}
+
+func addListFlags(cmd *cobra.Command) {
+	o := &listOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringVar(&o.include, "include", "", "Regex of mailboxes to list")
+	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regex of mailboxes to leave out")
+	cmd.Flags().IntVar(&o.sample, "sample", 200, "Without STATUS=SIZE, estimate the size of larger mailboxes from the RFC822.SIZE of N messages (0 fetches all sizes)")
+	cmd.Flags().BoolVar(&o.json, "json", false, "Print the mailboxes as JSON")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// mailboxInfo is one line of list.
+type mailboxInfo struct {
+	Name     string `json:"name"`
+	Messages uint32 `json:"messages"`
+	Unseen   uint32 `json:"unseen"`
+	Bytes    int64  `json:"bytes"`
+	// SizeExact is false for sizes extrapolated from a sample.
+	SizeExact bool   `json:"size_exact"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runList prints the mailboxes of an account with their message and
+// unseen counts and their size, for planning a migration and its
+// --include/--exclude filters.
+func runList(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*listOptions)
+	includeRe, excludeRe, err := mailboxFilters(o.include, o.exclude)
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	boxes, err := imaputil.ListMailboxes(ctx, c)
+	if err != nil {
+		return fmt.Errorf("list mailboxes: %w", err)
+	}
+	sort.Strings(boxes)
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen}
+	hasSize, _ := c.Support("STATUS=SIZE")
+	if hasSize {
+		items = append(items, "SIZE")
+	}
+
+	var infos []mailboxInfo
+	failed := 0
+	for _, b := range boxes {
+		if includeRe != nil && !matchMailbox(includeRe, b) || excludeRe != nil && matchMailbox(excludeRe, b) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info := mailboxInfo{Name: b}
+		if err := o.inspect(c, &info, items); err != nil {
+			if imaputil.ConnClosed(err) {
+				return err
+			}
+			info.Error = err.Error()
+			failed++
+		}
+		infos = append(infos, info)
+	}
+
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(struct {
+			Mailboxes []mailboxInfo `json:"mailboxes"`
+		}{infos}); err != nil {
+			return err
+		}
+	} else if err := printMailboxes(infos); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d mailbox(es) could not be read", failed)
+	}
+	return nil
+}
+
+// inspect fills in the counts and size of info.Name: from STATUS, and
+// without STATUS=SIZE from the RFC822.SIZE of (a sample of) its messages.
+func (o *listOptions) inspect(c *client.Client, info *mailboxInfo, items []imap.StatusItem) error {
+	status, err := c.Status(info.Name, items)
+	if err != nil {
+		return err
+	}
+	info.Messages, info.Unseen = status.Messages, status.Unseen
+	if v, ok := status.Items["SIZE"]; ok {
+		info.Bytes, _ = strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		info.SizeExact = true
+		return nil
+	}
+	if info.Messages == 0 {
+		info.SizeExact = true
+		return nil
+	}
+	selected, err := imaputil.SelectMailbox(c, info.Name, true)
+	if err != nil {
+		return err
+	}
+	info.Bytes, info.SizeExact, err = imaputil.MailboxSize(c, selected.Messages, o.sample)
+	return err
+}
+
+// printMailboxes prints infos as a table with a total; "~" marks sizes
+// estimated from a sample.
+func printMailboxes(infos []mailboxInfo) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MAILBOX\tMESSAGES\tUNSEEN\tSIZE")
+	var total mailboxInfo
+	total.SizeExact = true
+	for _, info := range infos {
+		if info.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t%s\n", info.Name, info.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", info.Name, info.Messages, info.Unseen, listSize(info.Bytes, info.SizeExact))
+		total.Messages += info.Messages
+		total.Unseen += info.Unseen
+		total.Bytes += info.Bytes
+		total.SizeExact = total.SizeExact && info.SizeExact
+	}
+	fmt.Fprintf(w, "%d mailbox(es)\t%d\t%d\t%s\n", len(infos), total.Messages, total.Unseen, listSize(total.Bytes, total.SizeExact))
+	return w.Flush()
+}
+
+func listSize(n int64, exact bool) string {
+	if exact {
+		return formatBytes(n)
+	}
+	return "~" + formatBytes(n)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"sync"
+
+	"github.com/pepperpark/gomap/internal/lmtp"
+)
+
+// lmtpDeliverer delivers copied messages via LMTP instead of IMAP APPEND.
+// The folder is carried in the recipient's detail part (user+Folder), which
+// Dovecot honours with lmtp_save_to_detail_mailbox = yes.
+type lmtpDeliverer struct {
+	addr  string
+	rcpt  string
+	delim string
+
+	mu sync.Mutex
+	c  *lmtp.Client
+}
+
+func newLMTPDeliverer(o *copyOptions) *lmtpDeliverer {
+	rcpt := o.lmtpRcpt
+	if rcpt == "" {
+		rcpt = o.dstUser
+	}
+	return &lmtpDeliverer{addr: o.dstLMTP, rcpt: rcpt, delim: o.lmtpDelim}
+}
+
+// Deliver sends raw into mailbox. The session is opened on first use and
+// reopened once if the server dropped it in between.
+func (d *lmtpDeliverer) Deliver(ctx context.Context, mailbox string, raw []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rcpt := lmtp.Recipient(d.rcpt, d.delim, mailbox)
+	reused := d.c != nil
+	if d.c == nil {
+		c, err := lmtp.Dial(d.addr)
+		if err != nil {
+			return err
+		}
+		d.c = c
+	}
+	err := d.c.Deliver(rcpt, raw)
+	var reply *textproto.Error
+	if err != nil && reused && !errors.As(err, &reply) {
+		// not a server reply: the idle session was dropped
+		d.c.Close()
+		c, derr := lmtp.Dial(d.addr)
+		if derr != nil {
+			d.c = nil
+			return derr
+		}
+		d.c = c
+		err = d.c.Deliver(rcpt, raw)
+	}
+	return err
+}
+
+func (d *lmtpDeliverer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.c == nil {
+		return nil
+	}
+	err := d.c.Close()
+	d.c = nil
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/maildir"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// maildirSource is one Maildir++ folder (relative to the root, "" for
+// INBOX) and the destination mailbox it goes to.
+type maildirSource struct {
+	dir     string
+	mailbox string
+}
+
+// resolveMaildirSources lists the folders of the Maildir++ tree at root and
+// maps them to destination mailboxes using the destination's hierarchy
+// delimiter (".Archive.2023" becomes "Archive/2023" on a "/" server). An
+// explicitly set --dst-mailbox becomes the parent folder and --map applies
+// to the derived names.
+func resolveMaildirSources(root, delim, dstMbox string, dstMboxSet bool, folderMap map[string]string) ([]maildirSource, error) {
+	folders, err := maildir.Folders(root)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]maildirSource, 0, len(folders))
+	for _, dir := range folders {
+		name := maildir.MailboxName(dir, delim)
+		if dstMboxSet {
+			if dir == "" {
+				name = dstMbox
+			} else {
+				name = dstMbox + delim + name
+			}
+		}
+		if to, ok := folderMap[name]; ok && to != "" {
+			name = to
+		}
+		sources = append(sources, maildirSource{dir: dir, mailbox: name})
+	}
+	return sources, nil
+}
+
+// maildirStateKey builds the state key for a Maildir folder and destination mailbox.
+func maildirStateKey(absDir, dstMailbox string) string {
+	return fmt.Sprintf("maildir:%s|dst:%s", absDir, dstMailbox)
+}
+
+func runCopyMaildir(cmd *cobra.Command, o *copyOptions) error {
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	ctx := cmd.Context()
+	appendPacer := o.pacer()
+	delim := "/"
+	var appendMsg func(mailbox string, raw []byte, flags []string, date time.Time) error
+	var ensure func(mailbox string) error
+	if o.dstLMTP != "" {
+		// LMTP cannot set flags; messages arrive as new mail
+		d := newLMTPDeliverer(o)
+		defer d.Close()
+		appendMsg = func(mailbox string, raw []byte, flags []string, date time.Time) error {
+			return appendPacer.Do(ctx, func() error { return d.Deliver(ctx, mailbox, bytes.Join(o.messageParts(raw), nil)) })
+		}
+		ensure = func(string) error { return nil }
+	} else {
+		dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+		if err != nil {
+			return fmt.Errorf("connect destination: %w", err)
+		}
+		defer dst.Logout()
+		if delim, err = imaputil.Delimiter(dst); err != nil {
+			return fmt.Errorf("list hierarchy delimiter: %w", err)
+		}
+		appendMsg = func(mailbox string, raw []byte, flags []string, date time.Time) error {
+			return appendPacer.Do(ctx, func() error {
+				return imaputil.Append(dst, mailbox, o.flagMap.Apply(flags), date, o.messageParts(raw)...)
+			})
+		}
+		ensure = func(mailbox string) error { return o.ensureMailbox(dst, mailbox) }
+	}
+	appendMsg = o.filters.filterAppend(dryRunAppend(o, appendMsg))
+
+	sources, err := resolveMaildirSources(o.maildirPath, delim, o.dstMbox, cmd.Flags().Changed("dst-mailbox"), parseMappings(o.mapPairs))
+	if err != nil {
+		return fmt.Errorf("open maildir: %w", err)
+	}
+	o.dstDelim = delim
+	for i := range sources {
+		sources[i].mailbox = o.nameLimits().Fit(o.flatName(sources[i].mailbox), delim)
+	}
+	root, _ := filepath.Abs(o.maildirPath)
+
+	// Collect the messages not copied yet, per folder
+	pending := make([][]maildir.Message, len(sources))
+	var total int
+	for i, src := range sources {
+		msgs, err := maildir.Messages(filepath.Join(root, src.dir))
+		if err != nil {
+			return fmt.Errorf("read maildir: %w", err)
+		}
+		if !o.ignoreState {
+			mark := st.GetMaildirMark(maildirStateKey(filepath.Join(root, src.dir), src.mailbox))
+			kept := msgs[:0]
+			for _, m := range msgs {
+				if m.Date.UnixNano() > mark {
+					kept = append(kept, m)
+				}
+			}
+			msgs = kept
+		}
+		if o.verbose {
+			log.Printf("[maildir] %s -> %s (%d messages)", filepath.Join(root, src.dir), src.mailbox, len(msgs))
+		}
+		pending[i] = msgs
+		total += len(msgs)
+	}
+	for i, src := range sources {
+		if len(pending[i]) == 0 {
+			continue
+		}
+		if err := ensure(src.mailbox); err != nil {
+			return fmt.Errorf("ensure mailbox %s: %w", src.mailbox, err)
+		}
+	}
+
+	progress := make(chan int, 128)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(progress)
+		defer close(errc)
+		for i, src := range sources {
+			key := maildirStateKey(filepath.Join(root, src.dir), src.mailbox)
+			for _, m := range pending[i] {
+				raw, err := os.ReadFile(m.Path)
+				if err != nil {
+					errc <- fmt.Errorf("read maildir: %w", err)
+					return
+				}
+				if err := appendMsg(src.mailbox, raw, m.Flags, m.Date); err != nil {
+					errc <- fmt.Errorf("append %s: %w", m.Path, err)
+					return
+				}
+				st.SetMaildirMark(key, m.Date.UnixNano())
+				_ = o.saveState(st)
+				progress <- 1
+			}
+		}
+		errc <- nil
+	}()
+
+	_ = runMboxTUI(total, progress, errc)
+	return nil
+}
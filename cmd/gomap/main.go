@@ -1,19 +1,28 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"cmp"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/mail"
 	"net/smtp"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-imap"
@@ -21,8 +30,14 @@ import (
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 
-	"github.com/emersion/go-mbox"
+	"github.com/pepperpark/gomap/internal/bwlimit"
 	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/maildir"
+	"github.com/pepperpark/gomap/internal/mailstore"
+	"github.com/pepperpark/gomap/internal/mboxutil"
+	"github.com/pepperpark/gomap/internal/pacer"
+	"github.com/pepperpark/gomap/internal/quirks"
+	"github.com/pepperpark/gomap/internal/runlog"
 	"github.com/pepperpark/gomap/internal/state"
 	"github.com/pepperpark/gomap/internal/syncer"
 )
@@ -34,6 +49,10 @@ var (
 	date    = ""
 )
 
+// timeouts is bound to the global --timeout and --io-timeout flags and
+// reaches imaputil.DialAndLogin through the command context.
+var timeouts imaputil.Timeouts
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "gomap",
@@ -46,7 +65,12 @@ func main() {
 
 	var showVersion bool
 	rootCmd.PersistentFlags().BoolVarP(&showVersion, "version", "v", false, "Print version and exit")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Config file with accounts and identities (default ~/.gomap/config.json)")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Don't write files or modify servers, just list the actions")
+	rootCmd.PersistentFlags().DurationVar(&timeouts.Dial, "timeout", imaputil.DefaultTimeouts.Dial, "Give up connecting to an IMAP server (TCP, TLS, greeting) after this long (0 waits forever)")
+	rootCmd.PersistentFlags().DurationVar(&timeouts.IO, "io-timeout", imaputil.DefaultTimeouts.IO, "Close an IMAP connection whose server sends nothing for this long while a command waits for its reply (0 waits forever)")
 	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		cmd.SetContext(imaputil.WithTimeouts(cmd.Context(), timeouts))
 		if showVersion {
 			fmt.Printf("gomap %s", version)
 			if commit != "" {
@@ -63,7 +87,7 @@ func main() {
 	// copy subcommand
 	copyCmd := &cobra.Command{
 		Use:   "copy",
-		Short: "Copy emails from IMAP or MBOX to destination IMAP",
+		Short: "Copy emails from IMAP, MBOX, Maildir or Outlook .msg files to destination IMAP",
 		RunE:  runCopy,
 	}
 	addCopyFlags(copyCmd)
@@ -109,9 +133,278 @@ func main() {
 	}
 	addAnalyzeMboxFlags(analyzeMboxCmd)
 
-	rootCmd.AddCommand(sendCmd, backupCmd, markReadCmd, deleteCmd, analyzeMboxCmd)
+	// self-update / upgrade-check commands
+	selfUpdateCmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Download and install the latest signed release binary",
+		RunE:  runSelfUpdate,
+	}
+	addSelfUpdateFlags(selfUpdateCmd)
+	upgradeCheckCmd := &cobra.Command{
+		Use:          "upgrade-check",
+		Short:        "Check whether a newer release is available",
+		SilenceUsage: true,
+		RunE:         runUpgradeCheck,
+	}
+
+	// grep command
+	grepCmd := &cobra.Command{
+		Use:   "grep QUERY...",
+		Short: "Full-text search a backup written with --format sqlite",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runGrep,
+	}
+	addGrepFlags(grepCmd)
+
+	// state export/import commands
+	stateCmd := &cobra.Command{
+		Use:   "state",
+		Short: "Inspect resume state or move it between machines",
+	}
+	stateShowCmd := &cobra.Command{
+		Use:   "show [MAILBOX]",
+		Short: "Show the resume state per mailbox, or the source to destination UID map of one mailbox",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  runStateShow,
+	}
+	addStateShowFlags(stateShowCmd)
+	stateExportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the resume state to a portable bundle",
+		RunE:  runStateExport,
+	}
+	addStateExportFlags(stateExportCmd)
+	stateImportCmd := &cobra.Command{
+		Use:   "import BUNDLE",
+		Short: "Restore resume state from a bundle written by 'state export'",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runStateImport,
+	}
+	addStateImportFlags(stateImportCmd)
+	stateCmd.AddCommand(stateShowCmd, stateExportCmd, stateImportCmd)
+
+	// prune-duplicates command
+	pruneCmd := &cobra.Command{
+		Use:   "prune-duplicates",
+		Short: "Remove surplus copies from the destination of messages that exist on the source",
+		RunE:  runPrune,
+	}
+	addPruneFlags(pruneCmd)
+
+	// prune command
+	pruneMailCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete messages matching search criteria from mailboxes on the server",
+		Args:  cobra.NoArgs,
+		RunE:  runPruneMail,
+	}
+	addPruneMailFlags(pruneMailCmd)
+
+	// archive command
+	archiveCmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Move old messages into per-year archive folders on the same account",
+		Args:  cobra.NoArgs,
+		RunE:  runArchive,
+	}
+	addArchiveFlags(archiveCmd)
+
+	// dedupe command
+	dedupeCmd := &cobra.Command{
+		Use:   "dedupe",
+		Short: "Delete duplicate messages within mailboxes, keeping the oldest copy",
+		Args:  cobra.NoArgs,
+		RunE:  runDedupe,
+	}
+	addDedupeFlags(dedupeCmd)
+
+	// flags command
+	flagsCmd := &cobra.Command{
+		Use:   "flags",
+		Short: "Set or clear flags on the messages of mailboxes in bulk",
+		Args:  cobra.NoArgs,
+		RunE:  runFlags,
+	}
+	addFlagsFlags(flagsCmd)
+
+	// expunge command
+	expungeCmd := &cobra.Command{
+		Use:   "expunge",
+		Short: "Permanently remove the messages marked \\Deleted from mailboxes",
+		Args:  cobra.NoArgs,
+		RunE:  runExpunge,
+	}
+	addExpungeFlags(expungeCmd)
+
+	// quota command
+	quotaCmd := &cobra.Command{
+		Use:   "quota",
+		Short: "Show the storage and message quota of an account",
+		Args:  cobra.NoArgs,
+		RunE:  runQuota,
+	}
+	addQuotaFlags(quotaCmd)
+
+	// caps command
+	capsCmd := &cobra.Command{
+		Use:     "caps",
+		Aliases: []string{"capabilities"},
+		Short:   "Show the capabilities of an IMAP server and which gomap can use",
+		Args:    cobra.NoArgs,
+		RunE:    runCaps,
+	}
+	addCapsFlags(capsCmd)
+
+	// filter command
+	filterCmd := &cobra.Command{
+		Use:   "filter",
+		Short: "Deliver one RFC822 message from stdin via IMAP APPEND or SMTP (for procmail/maildrop)",
+		Args:  cobra.NoArgs,
+		RunE:  runFilter,
+	}
+	addFilterFlags(filterCmd)
+
+	// parse command
+	parseCmd := &cobra.Command{
+		Use:   "parse FILE...",
+		Short: "Show the structure of RFC822 messages (use - for stdin)",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runParse,
+	}
+	addParseFlags(parseCmd)
+
+	// list command
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the mailboxes of an account with message, unseen and size totals",
+		Args:  cobra.NoArgs,
+		RunE:  runList,
+	}
+	addListFlags(listCmd)
+
+	// stats command
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show message counts and sizes per mailbox and year, and the top senders of an account",
+		Args:  cobra.NoArgs,
+		RunE:  runStats,
+	}
+	addStatsFlags(statsCmd)
+
+	// search command
+	searchCmd := &cobra.Command{
+		Use:   "search",
+		Short: "Search a mailbox on the server and print UID, date, sender and subject of the matches",
+		Args:  cobra.NoArgs,
+		RunE:  runSearch,
+	}
+	addSearchFlags(searchCmd)
+
+	// tail command
+	tailCmd := &cobra.Command{
+		Use:   "tail [MAILBOX]",
+		Short: "Print new messages in a mailbox as they arrive (read-only, like tail -f)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  runTail,
+	}
+	addTailFlags(tailCmd)
+
+	// watch command
+	watchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Run a command for each new message in a mailbox (IDLE, read-only)",
+		RunE:  runWatch,
+	}
+	addWatchFlags(watchCmd)
+
+	// restore command
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Upload a backup directory (single-file, mbox or maildir) to destination IMAP",
+		RunE:  runRestore,
+	}
+	addRestoreFlags(restoreCmd)
+
+	// sync command
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Propagate new messages between two IMAP accounts (one-way, or both ways with --two-way)",
+		Args:  cobra.NoArgs,
+		RunE:  runSync,
+	}
+	addSyncFlags(syncCmd)
+
+	// verify command
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Compare source and destination mailboxes by message counts per month",
+		Args:  cobra.NoArgs,
+		RunE:  runVerify,
+	}
+	addVerifyFlags(verifyCmd)
+
+	// raw command
+	rawCmd := &cobra.Command{
+		Use:   "raw [COMMAND...]",
+		Short: "Run IMAP commands verbatim in an authenticated session and print the responses",
+		Long: `Run IMAP commands verbatim in an authenticated session and print the
+server's responses, e.g. gomap raw --host imap.example.org --user me 'SELECT INBOX' 'UID SEARCH SINCE 1-Jan-2024'.
+Without arguments, commands are read from stdin, one per line. Tags are added automatically.`,
+		RunE: runRaw,
+	}
+	addRawFlags(rawCmd)
+
+	// report commands
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "Compare the stored summaries of copy runs",
+	}
+	reportListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the stored run summaries",
+		Args:  cobra.NoArgs,
+		RunE:  runReportList,
+	}
+	addReportFlags(reportListCmd)
+	reportDiffCmd := &cobra.Command{
+		Use:   "diff [RUN1 RUN2]",
+		Short: "Show new folders, message deltas and error deltas between two runs (default: the last two)",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 && len(args) != 2 {
+				return fmt.Errorf("diff takes two runs or none, got %d", len(args))
+			}
+			return nil
+		},
+		RunE: runReportDiff,
+	}
+	addReportFlags(reportDiffCmd)
+	reportCmd.AddCommand(reportListCmd, reportDiffCmd)
+
+	// migrate command
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Run a planned migration from the config: estimate, checks, copy, deltas, verify, report (resumable)",
+		Args:  cobra.NoArgs,
+		RunE:  runMigrate,
+	}
+	addMigrateFlags(migrateCmd)
+
+	// daemon command
+	daemonCmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run the copy and backup jobs of the config on cron schedules",
+		Args:  cobra.NoArgs,
+		RunE:  runDaemon,
+	}
+	addDaemonFlags(daemonCmd)
+
+	rootCmd.AddCommand(sendCmd, backupCmd, markReadCmd, deleteCmd, analyzeMboxCmd, selfUpdateCmd, upgradeCheckCmd, grepCmd, stateCmd, pruneCmd, pruneMailCmd, archiveCmd, dedupeCmd, flagsCmd, expungeCmd, filterCmd, parseCmd, listCmd, statsCmd, quotaCmd, searchCmd, tailCmd, watchCmd, restoreCmd, syncCmd, verifyCmd, rawCmd, capsCmd, reportCmd, migrateCmd, daemonCmd)
 
 	if err := rootCmd.Execute(); err != nil {
+		var ec *exitCodeError
+		if errors.As(err, &ec) {
+			os.Exit(ec.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -124,11 +417,40 @@ type copyOptions struct {
 	srcUser       string
 	srcPass       string
 	srcPassPrompt bool
+	srcIdentity   string
+	srcURL        string
 	// MBOX source
 	mboxPath                string
-	dstMbox                 string // destination mailbox name when using mbox
-	mboxOnlyMissingDate     bool   // when true, only import MBOX messages without a Date header (ignore resume state)
-	mboxOnlyUnparseableDate bool   // when true, only import MBOX messages where Date header exists but cannot be parsed (ignore resume state)
+	dstMbox                 string        // destination mailbox name when using mbox
+	mboxOnlyMissingDate     bool          // when true, only import MBOX messages without a Date header (ignore resume state)
+	mboxOnlyUnparseableDate bool          // when true, only import MBOX messages where Date header exists but cannot be parsed (ignore resume state)
+	mboxFormat              string        // auto | mboxo | mboxrd | mboxcl | mboxcl2
+	mboxMergeSplit          bool          // import <name>-2023(-05).mbox files into <name> (restore of --split-by backups)
+	dedup                   string        // "" | message-id | header-hash: skip messages already in the destination mailbox
+	headerFilter            []string      // --header-filter HEADER:REGEX
+	subjectFilter           []string      // --subject-filter REGEX
+	filters                 headerFilters // both parsed
+	onlyUnseen              bool
+	onlyFlagged             bool
+	skipDeleted             bool
+	limit                   int  // messages per mailbox and run (0 = all)
+	newestFirst             bool // initial copies from the newest message down
+	mboxSkipCorrupt         bool // skip unreadable mbox entries and report them instead of failing
+	mboxMaxSize             int  // MiB; larger mbox entries are treated as corrupt (0 = no limit)
+	// single mailbox and UIDs
+	onlyMailbox string       // --mailbox
+	uidList     []string     // --uid
+	uidRange    string       // --uid-range
+	uidSet      *imap.SeqSet // both parsed, nil for all
+	// Maildir source
+	maildirPath string
+	// single-file backup source (<uid>.eml files, used by restore)
+	emlPath string
+	// Outlook .msg source
+	msgPath string
+	// Gmail API source
+	srcGmailAPI   bool
+	srcGmailToken string
 
 	// Destination IMAP
 	dstHost       string
@@ -136,23 +458,233 @@ type copyOptions struct {
 	dstUser       string
 	dstPass       string
 	dstPassPrompt bool
+	dstIdentity   string
+	dstURL        string
+	// Destination LMTP (instead of IMAP APPEND)
+	dstLMTP   string
+	lmtpRcpt  string
+	lmtpDelim string
 
 	insecure    bool
 	startTLS    bool
 	include     string
 	exclude     string
+	boxesFile   string // --mailboxes-file
 	since       string
-	dryRun      bool
+	before      string
+	beforeTime  time.Time // --before parsed (zero = no bound)
 	concurrency int
+	connections int // source/destination connections per mailbox
+	reconnects  int // attempts to resume a mailbox after a lost connection
+	srcMaxConns int // connection pool sizes (0 = concurrency × connections)
+	dstMaxConns int
 	stateFile   string
 	ignoreState bool
-	skipSpecial bool
-	skipTrash   bool
-	skipJunk    bool
-	skipDrafts  bool
-	skipSent    bool
-	mapPairs    []string
-	verbose     bool
+
+	// artifact store for run reports ("" disables)
+	artifacts          string
+	artifactKeep       int
+	artifactMaxAgeDays int
+
+	splitAt  int
+	splitBy  string // --split-window: year or month
+	largeMsg int    // MiB; larger messages show byte progress (0 = off)
+	maxSize  string
+	maxBytes int64 // --max-size parsed (0 = no limit)
+	// go on past messages that cannot be copied and list them in
+	// failuresReport (.csv or JSON)
+	continueOnError bool
+	failuresReport  string
+	// look up every appended message before it counts as copied
+	verify      string
+	verifyLevel imaputil.Verify // --verify parsed
+	// translate or drop keywords the destination may refuse on APPEND
+	flagMapPairs []string
+	stripFlags   bool
+	flagMap      *imaputil.FlagMap // --flag-map and --strip-nonstandard-flags parsed
+	// leave created destination folders unsubscribed
+	noSubscribe bool
+	// copy the access control lists of the mailboxes (shared folders)
+	syncACL bool
+	// with --dry-run: write what a real run would copy to this file
+	planReport string
+	// keep copying new messages after the initial copy (see followSource)
+	follow         bool
+	followInterval time.Duration
+	followPoll     time.Duration
+	// messages and bytes per MULTIAPPEND batch (1 message = no batching)
+	appendBatch      int
+	appendBatchSize  string
+	appendBatchBytes int64 // --append-batch-bytes parsed
+	// destination mailbox name caps (0 = none)
+	maxFolderLen     int
+	maxFolderDepth   int
+	maxRate          float64
+	maxAppendsPerMin int // --max-appends-per-min; the lower of both caps applies
+	noPacing         bool
+	bwLimit          string // --bwlimit, bytes per second each way
+	skipSpecial      bool
+	skipTrash        bool
+	skipJunk         bool
+	skipDrafts       bool
+	skipSent         bool
+	trashAs          string // copy Trash folders into this mailbox instead
+	junkAs           string // copy Junk folders into this mailbox instead
+	mapPairs         []string
+	srcPrefix        string // strip this parent folder from source names
+	dstPrefix        string // nest the copied folders below this one
+	flatten          bool   // copy every folder into a top-level one
+	flattenSep       string // joins the levels of flattened names
+	// hierarchy delimiters the prefixes and --flatten are resolved with
+	// (see loadDelimiters)
+	srcDelim   string
+	dstDelim   string
+	addHeaders []string
+	headers    []byte // --add-header lines, CRLF-terminated
+	verbose    bool
+	// expunge \Deleted messages from the source mailboxes after the copy
+	expungeSource bool
+	yes           bool
+	// Scheduled mode
+	mode       string // "" (single run) | nightly-delta
+	nightlyAt  string // HH:MM local time
+	notifyTo   []string
+	notifyFrom string
+	smtpHost   string
+	smtpPort   int
+	smtpUser   string
+	smtpPass   string
+	smtpSSL    bool
+	smtpURL    string
+}
+
+// applyEndpoints applies the --src, --dst and --smtp connection URLs.
+func (o *copyOptions) applyEndpoints(cmd *cobra.Command) error {
+	return applyEndpoints(cmd,
+		endpointFlag{loginTarget{prefix: "src", host: &o.srcHost, port: &o.srcPort, user: &o.srcUser, pass: &o.srcPass, startTLS: &o.startTLS, insecure: &o.insecure}, "imap", o.srcURL},
+		endpointFlag{loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, "imap", o.dstURL},
+		endpointFlag{loginTarget{prefix: "smtp", host: &o.smtpHost, port: &o.smtpPort, user: &o.smtpUser, pass: &o.smtpPass, ssl: &o.smtpSSL, insecure: &o.insecure}, "smtp", o.smtpURL},
+	)
+}
+
+// pacer returns the append pacer for the destination, or nil when pacing is
+// disabled.
+func (o *copyOptions) pacer() *pacer.Pacer {
+	if o.noPacing {
+		return nil
+	}
+	rate := o.maxRate
+	if r := float64(o.maxAppendsPerMin) / 60; r > 0 && (rate == 0 || r < rate) {
+		rate = r
+	}
+	return pacer.New(rate)
+}
+
+// parseAddHeaders validates the --add-header lines and joins them into the
+// header block prepended to copied messages.
+func parseAddHeaders(lines []string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, l := range lines {
+		name, value, ok := strings.Cut(l, ":")
+		if !ok || name == "" || strings.ContainsAny(l, "\r\n") || strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' }) >= 0 {
+			return nil, fmt.Errorf("invalid --add-header %q (expected 'Name: value')", l)
+		}
+		b.WriteString(name + ":" + value + "\r\n")
+	}
+	return b.Bytes(), nil
+}
+
+// parseByteSize parses a size such as 25M, 512K, 1.5G or 1048576 (bytes);
+// K, M and G are powers of 1024. An empty size is 0.
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	mult := float64(1)
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMG", num[n-1]); i >= 0 {
+			mult = float64(int64(1) << (10 * (i + 1)))
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%q is not a size like 25M", s)
+	}
+	return int64(v * mult), nil
+}
+
+// withBandwidthLimit parses --bwlimit and puts the limiter into the
+// context of cmd, where imaputil.DialAndLogin picks it up for every
+// connection of the run.
+func withBandwidthLimit(cmd *cobra.Command, spec string) error {
+	rate, err := parseByteSize(spec)
+	if err != nil {
+		return fmt.Errorf("invalid --bwlimit: %w", err)
+	}
+	cmd.SetContext(bwlimit.NewContext(cmd.Context(), bwlimit.New(rate)))
+	return nil
+}
+
+// parseBefore parses a --before date: YYYY-MM-DD, or an age such as 90d,
+// 6m or 2y (days, weeks, months or years before today). An empty value is
+// the zero time.
+func parseBefore(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid --before %q (expected YYYY-MM-DD or an age like 90d, 6m, 2y)", s)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch s[len(s)-1] {
+	case 'd':
+		return today.AddDate(0, 0, -n), nil
+	case 'w':
+		return today.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return today.AddDate(0, -n, 0), nil
+	case 'y':
+		return today.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --before %q (expected YYYY-MM-DD or an age like 90d, 6m, 2y)", s)
+}
+
+// oversizedList collects the messages skipped for --max-size, listed at
+// the end of a copy.
+type oversizedList struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *oversizedList) add(mailbox string, uid uint32, size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf("%s UID %d (%s)", mailbox, uid, formatBytes(size)))
+}
+
+func (l *oversizedList) print() {
+	if len(l.lines) == 0 {
+		return
+	}
+	fmt.Printf("Skipped %d message(s) over --max-size:\n", len(l.lines))
+	for _, line := range l.lines {
+		fmt.Println(" -", line)
+	}
+}
+
+// messageParts returns raw with the --add-header block as a separate
+// leading part, for imaputil.Append.
+func (o *copyOptions) messageParts(raw []byte) [][]byte {
+	if len(o.headers) == 0 {
+		return [][]byte{raw}
+	}
+	return [][]byte{o.headers, raw}
 }
 
 func addCopyFlags(cmd *cobra.Command) {
@@ -164,35 +696,113 @@ func addCopyFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&o.srcUser, "src-user", "", "Source IMAP username")
 	cmd.Flags().StringVar(&o.srcPass, "src-pass", "", "Source IMAP password")
 	cmd.Flags().BoolVar(&o.srcPassPrompt, "src-pass-prompt", false, "Prompt for source IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.srcIdentity, "src-identity", "", "Use the IMAP account of this identity from the config as source")
+	cmd.Flags().StringVar(&o.srcURL, "src", "", "Source as a connection URL, e.g. imaps://user@host:993 or imap+starttls://user@host (instead of --src-host, --src-port and --src-user)")
 	// MBOX
-	cmd.Flags().StringVar(&o.mboxPath, "mbox", "", "Read from local MBOX file instead of source IMAP")
-	cmd.Flags().StringVar(&o.dstMbox, "dst-mailbox", "INBOX", "Destination mailbox name when using --mbox")
+	cmd.Flags().StringVar(&o.mboxPath, "mbox", "", "Read from a local MBOX file, a directory of MBOX files or a glob pattern instead of source IMAP")
+	cmd.Flags().StringVar(&o.maildirPath, "maildir", "", "Read from a local Maildir++ tree (Dovecot/Courier layout, subfolders as .Sent, .Archive.2023) instead of source IMAP")
+	cmd.Flags().StringVar(&o.msgPath, "msg", "", "Read from a directory of Outlook .msg files (subdirectories become folders) instead of source IMAP")
+	cmd.Flags().StringVar(&o.dstMbox, "dst-mailbox", "INBOX", "Destination mailbox name when using --mbox or --msg (parent folder with several MBOX files, --msg subdirectories or --maildir)")
 	cmd.Flags().BoolVar(&o.mboxOnlyMissingDate, "mbox-only-missing-date", false, "With --mbox: only import messages without a Date header (ignores resume state)")
 	cmd.Flags().BoolVar(&o.mboxOnlyUnparseableDate, "mbox-only-unparseable-date", false, "With --mbox: only import messages whose Date header exists but cannot be parsed (ignores resume state)")
+	cmd.Flags().BoolVar(&o.mboxSkipCorrupt, "mbox-skip-corrupt", false, "With --mbox: skip entries that cannot be read (broken From_ separators, above --mbox-max-size) and list them at the end instead of failing")
+	cmd.Flags().IntVar(&o.mboxMaxSize, "mbox-max-size", 0, "With --mbox: treat messages larger than N MiB as corrupt (0 = no limit)")
+	cmd.Flags().StringVar(&o.dedup, "dedup", "", "Skip messages already in the destination mailbox, matched by 'message-id' or 'header-hash' (date, sender and subject)")
+	cmd.Flags().StringArrayVar(&o.headerFilter, "header-filter", nil, "Only copy messages with a header matching HEADER:REGEX, e.g. 'From:@example\\.com' (case-insensitive; '!HEADER:REGEX' excludes matches instead; repeatable, all must hold)")
+	cmd.Flags().StringArrayVar(&o.subjectFilter, "subject-filter", nil, "Only copy messages whose subject matches REGEX ('!REGEX' excludes matches instead; repeatable)")
+	cmd.Flags().BoolVar(&o.onlyUnseen, "only-unseen", false, "Only copy messages without \\Seen (IMAP source)")
+	cmd.Flags().BoolVar(&o.onlyFlagged, "only-flagged", false, "Only copy messages with \\Flagged (IMAP source)")
+	cmd.Flags().BoolVar(&o.skipDeleted, "skip-deleted", false, "Do not copy messages marked \\Deleted (IMAP source)")
+	cmd.Flags().IntVar(&o.limit, "limit", 0, "Copy at most N messages per mailbox in this run; later runs continue (IMAP source)")
+	cmd.Flags().BoolVar(&o.newestFirst, "newest-first", false, "Copy mailboxes without resume state from the newest message down; later runs copy new mail and backfill older messages (IMAP source)")
+	cmd.Flags().StringVar(&o.onlyMailbox, "mailbox", "", "Copy only this source mailbox (IMAP source)")
+	cmd.Flags().StringSliceVar(&o.uidList, "uid", nil, "With --mailbox: copy only the messages with these UIDs, even if copied before (repeatable or comma-separated)")
+	cmd.Flags().StringVar(&o.uidRange, "uid-range", "", "With --mailbox: copy only the messages with UIDs in this range, e.g. 1000:2000 or 1000:* (even if copied before)")
+	cmd.Flags().StringVar(&o.mboxFormat, "mbox-format", "auto", "With --mbox: format variant (auto, mboxo, mboxrd, mboxcl, mboxcl2); auto detects it per file, mboxcl/mboxcl2 delimit messages by Content-Length")
+	// Gmail API
+	cmd.Flags().BoolVar(&o.srcGmailAPI, "src-gmail-api", false, "Read from Gmail via the REST API instead of source IMAP (labels become folders)")
+	cmd.Flags().StringVar(&o.srcGmailToken, "src-gmail-token", "", "OAuth2 access token for --src-gmail-api (or env GOMAP_GMAIL_TOKEN)")
 
 	cmd.Flags().StringVar(&o.dstHost, "dst-host", "", "Destination IMAP host")
 	cmd.Flags().IntVar(&o.dstPort, "dst-port", 993, "Destination IMAP port")
 	cmd.Flags().StringVar(&o.dstUser, "dst-user", "", "Destination IMAP username")
 	cmd.Flags().StringVar(&o.dstPass, "dst-pass", "", "Destination IMAP password")
 	cmd.Flags().BoolVar(&o.dstPassPrompt, "dst-pass-prompt", false, "Prompt for destination IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.dstIdentity, "dst-identity", "", "Use the IMAP account of this identity from the config as destination")
+	cmd.Flags().StringVar(&o.dstURL, "dst", "", "Destination as a connection URL, e.g. imaps://user@host:993 (instead of --dst-host, --dst-port and --dst-user)")
+	// LMTP
+	cmd.Flags().StringVar(&o.dstLMTP, "dst-lmtp", "", "Deliver via LMTP instead of IMAP APPEND (host[:port] or Unix socket path, e.g. /var/run/dovecot/lmtp)")
+	cmd.Flags().StringVar(&o.lmtpRcpt, "lmtp-rcpt", "", "With --dst-lmtp: recipient address (default --dst-user)")
+	cmd.Flags().StringVar(&o.lmtpDelim, "lmtp-detail-delimiter", "+", "With --dst-lmtp: delimiter that carries the folder in the recipient (user+Folder); empty delivers everything to INBOX")
 
 	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
 	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
 	cmd.Flags().StringVar(&o.include, "include", "", "Regex of mailboxes to include (IMAP source)")
 	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regex of mailboxes to exclude (IMAP source)")
+	cmd.Flags().StringVar(&o.boxesFile, "mailboxes-file", "", "Copy exactly the mailboxes listed in this file, one per line; # starts a comment (instead of --include/--exclude; IMAP source)")
 	cmd.Flags().StringVar(&o.since, "since", "", "Only copy messages with INTERNALDATE >= since (YYYY-MM-DD)")
-	cmd.Flags().BoolVar(&o.dryRun, "dry-run", false, "Don't actually copy, just list actions")
+	cmd.Flags().StringVar(&o.before, "before", "", "Only copy messages with INTERNALDATE before this day (YYYY-MM-DD, or an age like 90d, 6m, 2y; IMAP and Gmail sources)")
 	cmd.Flags().IntVar(&o.concurrency, "concurrency", 2, "Number of concurrent mailboxes to copy (IMAP source)")
+	cmd.Flags().IntVar(&o.connections, "connections-per-mailbox", 1, "Copy each large mailbox over up to N source and N destination connections, each fetching part of its messages (IMAP source)")
+	cmd.Flags().IntVar(&o.reconnects, "reconnects", 5, "Resume a mailbox on new connections up to N times in a row when the server drops one (0 disables; IMAP source)")
+	cmd.Flags().IntVar(&o.srcMaxConns, "src-max-conns", 0, "Most connections open to the source at once (default --concurrency × --connections-per-mailbox)")
+	cmd.Flags().IntVar(&o.dstMaxConns, "dst-max-conns", 0, "Most connections open to the destination at once (default --concurrency × --connections-per-mailbox)")
 	cmd.Flags().StringVar(&o.stateFile, "state-file", "gomap-state.json", "Path to resume state JSON")
 	cmd.Flags().BoolVar(&o.ignoreState, "ignore-state", false, "Ignore resume state (start from UID 0)")
+	cmd.Flags().StringVar(&o.artifacts, "artifacts", "gomap-artifacts", "Artifact store for run reports ('gomap report'): a directory or s3://bucket/prefix (empty disables)")
+	cmd.Flags().IntVar(&o.artifactKeep, "artifact-keep", 0, "Keep only the newest N reports in the artifact store (0 = all)")
+	cmd.Flags().IntVar(&o.artifactMaxAgeDays, "artifact-max-age", 0, "Remove reports older than N days from the artifact store (0 = never; the newest is always kept)")
+	cmd.Flags().Float64Var(&o.maxRate, "max-rate", 0, "Upper limit for the adaptive append rate in messages per second (0 = no limit)")
+	cmd.Flags().IntVar(&o.maxAppendsPerMin, "max-appends-per-min", 0, "Upper limit for appends per minute, for providers that count them that way (0 = no limit)")
+	cmd.Flags().BoolVar(&o.noPacing, "no-pacing", false, "Disable adaptive pacing of destination appends")
+	cmd.Flags().StringVar(&o.bwLimit, "bwlimit", "", "Limit the IMAP connections to this many bytes per second in each direction, all together, e.g. 5M (K, M and G count in 1024s)")
+	cmd.Flags().IntVar(&o.appendBatch, "append-batch", 20, "Append up to N messages per APPEND command where the destination supports MULTIAPPEND (1 disables; IMAP source)")
+	cmd.Flags().StringVar(&o.appendBatchSize, "append-batch-bytes", "8M", "Most bytes of one --append-batch batch, e.g. 16M (K, M and G count in 1024s)")
+	cmd.Flags().IntVar(&o.splitAt, "split-threshold", 20000, "Split the initial copy of mailboxes with at least N messages and no resume state into yearly windows (0 disables)")
+	cmd.Flags().StringVar(&o.splitBy, "split-window", "year", "Size of the --split-threshold windows: year or month (for mailboxes where a year is still too much for one SEARCH)")
+	cmd.Flags().StringVar(&o.maxSize, "max-size", "", "Skip and list messages larger than this, e.g. 25M (IMAP source; K, M and G count in 1024s)")
+	cmd.Flags().IntVar(&o.largeMsg, "large-message-size", 32, "Fetch messages of at least N MiB in chunks and show their byte progress (0 disables)")
+	cmd.Flags().IntVar(&o.maxFolderLen, "max-folder-length", 0, "Shorten destination folder name levels longer than N bytes (0: only when the server refuses a name)")
+	cmd.Flags().IntVar(&o.maxFolderDepth, "max-folder-depth", 0, "Fold destination folders nested deeper than N levels into their level N parent's name (0: only when the server refuses a name)")
 
 	cmd.Flags().BoolVar(&o.skipSpecial, "skip-special", false, "Skip common special folders like Trash/Junk/Drafts/Sent")
 	cmd.Flags().BoolVar(&o.skipTrash, "skip-trash", false, "Skip Trash folders")
 	cmd.Flags().BoolVar(&o.skipJunk, "skip-junk", false, "Skip Junk/Spam folders")
 	cmd.Flags().BoolVar(&o.skipDrafts, "skip-drafts", false, "Skip Drafts folders")
 	cmd.Flags().BoolVar(&o.skipSent, "skip-sent", false, "Skip Sent folders")
+	cmd.Flags().StringVar(&o.trashAs, "trash-as", "", "Copy Trash folders into this mailbox instead of the destination Trash, e.g. Archive/OldTrash (overrides --skip-trash)")
+	cmd.Flags().StringVar(&o.junkAs, "junk-as", "", "Copy Junk/Spam folders into this mailbox instead of the destination Junk (overrides --skip-junk)")
 	cmd.Flags().StringArrayVar(&o.mapPairs, "map", nil, "Folder mapping src=dst (can be repeated)")
+	cmd.Flags().StringVar(&o.srcPrefix, "src-prefix", "", "Strip this parent folder from the source folder names, e.g. INBOX or a shared namespace (IMAP source)")
+	cmd.Flags().StringVar(&o.dstPrefix, "dst-prefix", "", "Nest all copied folders below this destination folder, e.g. Archive/old-account (IMAP and Gmail API source)")
+	cmd.Flags().BoolVar(&o.flatten, "flatten", false, "Copy every folder into a top-level destination folder, e.g. Clients/Acme/2023 into Clients_Acme_2023 (for destinations without hierarchy)")
+	cmd.Flags().StringVar(&o.flattenSep, "flatten-separator", "_", "With --flatten: what joins the levels of a folder name")
+	cmd.Flags().StringArrayVar(&o.addHeaders, "add-header", nil, "Header line prepended to every copied message, e.g. 'X-Migrated-From: old.example.org' (repeatable)")
 	cmd.Flags().BoolVar(&o.verbose, "verbose", false, "Enable detailed per-mailbox logs")
+	cmd.Flags().BoolVar(&o.continueOnError, "continue-on-error", false, "Go on past messages that cannot be copied, e.g. ones the destination refuses, and list them in --failures-report (IMAP source)")
+	cmd.Flags().StringVar(&o.failuresReport, "failures-report", "gomap-failures.json", "With --continue-on-error: file listing the messages that were not copied (CSV if it ends in .csv, else JSON)")
+	cmd.Flags().StringVar(&o.verify, "verify", "", "Check every appended message on the destination before it counts as copied: exists, size or sha256 (--verify alone means exists; IMAP source and destination)")
+	cmd.Flags().Lookup("verify").NoOptDefVal = "exists"
+	cmd.Flags().StringArrayVar(&o.flagMapPairs, "flag-map", nil, "Flag mapping src=dst for copied messages, e.g. '$label1=$Important'; 'src=' drops the flag (can be repeated)")
+	cmd.Flags().BoolVar(&o.syncACL, "sync-acl", false, "Give the destination mailboxes the ACL entries (GETACL/SETACL) of the source mailboxes, e.g. for shared folders; with --dry-run only list the changes (IMAP source and destination with the ACL extension)")
+	cmd.Flags().BoolVar(&o.noSubscribe, "no-subscribe", false, "Do not SUBSCRIBE the destination folders (by default those subscribed on an IMAP source, or all if it has no subscriptions)")
+	cmd.Flags().BoolVar(&o.stripFlags, "strip-nonstandard-flags", false, "Drop flags other than the system flags and registered keywords ($Junk, $NotJunk, $Forwarded, ...) after mapping common aliases such as NonJunk")
+	cmd.Flags().BoolVar(&o.expungeSource, "expunge-source", false, "After a copy without errors, EXPUNGE the copied messages that are marked \\Deleted in the source (asks for confirmation; IMAP source)")
+	cmd.Flags().BoolVar(&o.yes, "yes", false, "With --expunge-source: do not ask for confirmation")
+	cmd.Flags().StringVar(&o.mode, "mode", "", "Run mode: nightly-delta keeps running and copies new messages once a day (IMAP source)")
+	cmd.Flags().StringVar(&o.planReport, "report", "", "With --dry-run: write the plan as JSON to this file: per mailbox the destination, the messages to copy and their bytes (IMAP source)")
+	cmd.Flags().BoolVar(&o.follow, "follow", false, "After the copy, keep running and copy new messages as they arrive: IDLE on the source INBOX, all mailboxes every --follow-interval (IMAP source)")
+	cmd.Flags().DurationVar(&o.followInterval, "follow-interval", 5*time.Minute, "With --follow: how often to copy the new messages of all mailboxes")
+	cmd.Flags().DurationVar(&o.followPoll, "follow-poll", time.Minute, "With --follow: how often to poll a source without IDLE")
+	cmd.Flags().StringVar(&o.nightlyAt, "nightly-at", "02:00", "With --mode nightly-delta: local time of the daily run (HH:MM)")
+	cmd.Flags().StringArrayVar(&o.notifyTo, "notify-to", nil, "With --mode nightly-delta: email a digest of each run to this address (repeatable)")
+	cmd.Flags().StringVar(&o.notifyFrom, "notify-from", "", "Sender address of the digest email")
+	cmd.Flags().StringVar(&o.smtpHost, "smtp-host", "", "SMTP server for the digest email")
+	cmd.Flags().IntVar(&o.smtpPort, "smtp-port", 587, "SMTP server port")
+	cmd.Flags().StringVar(&o.smtpUser, "smtp-user", "", "SMTP username")
+	cmd.Flags().StringVar(&o.smtpPass, "smtp-pass", "", "SMTP password")
+	cmd.Flags().BoolVar(&o.smtpSSL, "smtp-ssl", false, "Use implicit TLS for SMTP (port 465)")
+	cmd.Flags().StringVar(&o.smtpURL, "smtp", "", "SMTP server as a connection URL, e.g. smtps://user@host:465 or smtp+starttls://user@host:587 (instead of --smtp-host, --smtp-port and --smtp-user)")
 
 	// Bind into context
 	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
@@ -206,6 +816,27 @@ type ctxKey struct{}
 func runCopy(cmd *cobra.Command, args []string) error {
 	o := cmd.Context().Value(ctxKey{}).(*copyOptions)
 
+	// Fill connection settings from configured identities
+	if o.srcIdentity != "" {
+		_, acc, _, err := lookupIdentity(o.srcIdentity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "src", host: &o.srcHost, port: &o.srcPort, user: &o.srcUser, pass: &o.srcPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if o.dstIdentity != "" {
+		_, acc, _, err := lookupIdentity(o.dstIdentity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if err := o.applyEndpoints(cmd); err != nil {
+		return err
+	}
+	// like --map, accept the destination in modified UTF-7 as well
+	o.dstMbox = imaputil.DecodeMailboxName(o.dstMbox)
+
 	// Prompt passwords if requested
 	if o.srcPassPrompt && o.srcPass == "" {
 		fmt.Fprint(os.Stderr, "Source password: ")
@@ -226,7 +857,145 @@ func runCopy(cmd *cobra.Command, args []string) error {
 		o.dstPass = string(b)
 	}
 
+	var err error
+	if o.headers, err = parseAddHeaders(o.addHeaders); err != nil {
+		return err
+	}
+	if o.maxBytes, err = parseByteSize(o.maxSize); err != nil {
+		return fmt.Errorf("invalid --max-size: %w", err)
+	}
+	if o.verifyLevel, err = imaputil.ParseVerify(o.verify); err != nil {
+		return err
+	}
+	if o.flagMap, err = imaputil.NewFlagMap(o.flagMapPairs, o.stripFlags); err != nil {
+		return err
+	}
+	if o.appendBatchBytes, err = parseByteSize(o.appendBatchSize); err != nil {
+		return fmt.Errorf("invalid --append-batch-bytes: %w", err)
+	}
+	if err := withBandwidthLimit(cmd, o.bwLimit); err != nil {
+		return err
+	}
+	if o.filters, err = parseHeaderFilters(o.headerFilter, o.subjectFilter); err != nil {
+		return err
+	}
+	if o.beforeTime, err = parseBefore(o.before, time.Now()); err != nil {
+		return err
+	}
+	if o.uidSet, err = parseUIDSelection(o.uidList, o.uidRange); err != nil {
+		return err
+	}
+	o.onlyMailbox = imaputil.DecodeMailboxName(o.onlyMailbox)
+	switch {
+	case o.before != "" && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != ""):
+		return fmt.Errorf("--before requires an IMAP or Gmail source")
+	case o.dedup != "" && o.dedup != "message-id" && o.dedup != "header-hash":
+		return fmt.Errorf("invalid --dedup: %s (must be 'message-id' or 'header-hash')", o.dedup)
+	case o.dedup != "" && (o.maildirPath != "" || o.srcGmailAPI || o.dstLMTP != ""):
+		return fmt.Errorf("--dedup requires an IMAP or --mbox source and an IMAP destination")
+	case o.continueOnError && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI):
+		return fmt.Errorf("--continue-on-error requires an IMAP source")
+	case o.verify != "" && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI || o.dstLMTP != ""):
+		return fmt.Errorf("--verify requires an IMAP source and an IMAP destination")
+	case o.syncACL && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI || o.dstLMTP != ""):
+		return fmt.Errorf("--sync-acl requires an IMAP source and an IMAP destination")
+	case o.connections > 1 && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI):
+		return fmt.Errorf("--connections-per-mailbox requires an IMAP source")
+	case o.splitBy != "year" && o.splitBy != "month":
+		return fmt.Errorf("invalid --split-window: %s (must be 'year' or 'month')", o.splitBy)
+	case o.reconnects < 0:
+		return fmt.Errorf("invalid --reconnects: %d", o.reconnects)
+	case o.limit < 0:
+		return fmt.Errorf("invalid --limit: %d", o.limit)
+	case o.maxAppendsPerMin < 0:
+		return fmt.Errorf("invalid --max-appends-per-min: %d", o.maxAppendsPerMin)
+	case o.appendBatch < 1:
+		return fmt.Errorf("invalid --append-batch: %d (must be at least 1)", o.appendBatch)
+	case (o.limit > 0 || o.newestFirst) && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI):
+		return fmt.Errorf("--limit and --newest-first require an IMAP source")
+	case !o.flagFilter().IsZero() && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI):
+		return fmt.Errorf("--only-unseen, --only-flagged and --skip-deleted require an IMAP source")
+	case len(o.filters) > 0 && o.srcGmailAPI:
+		return fmt.Errorf("--header-filter and --subject-filter cannot be used with --src-gmail-api")
+	case o.expungeSource && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI):
+		return fmt.Errorf("--expunge-source requires an IMAP source")
+	case o.expungeSource && o.mode != "":
+		return fmt.Errorf("--expunge-source cannot be used with --mode")
+	case o.planReport != "" && !dryRun:
+		return fmt.Errorf("--report requires --dry-run")
+	case o.planReport != "" && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI || o.mode != ""):
+		return fmt.Errorf("--report requires an IMAP source and cannot be used with --mode")
+	case o.follow && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI):
+		return fmt.Errorf("--follow requires an IMAP source")
+	case o.follow && (o.mode != "" || o.expungeSource || dryRun):
+		return fmt.Errorf("--follow cannot be used with --mode, --expunge-source or --dry-run")
+	case o.follow && (o.followInterval <= 0 || o.followPoll <= 0):
+		return fmt.Errorf("invalid --follow-interval or --follow-poll: must be above 0")
+	case o.onlyMailbox != "" && (o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI):
+		return fmt.Errorf("--mailbox requires an IMAP source")
+	case o.onlyMailbox != "" && (o.include != "" || o.exclude != "" || o.boxesFile != ""):
+		return fmt.Errorf("--mailbox cannot be combined with --include, --exclude or --mailboxes-file")
+	case o.uidSet != nil && o.onlyMailbox == "":
+		return fmt.Errorf("--uid and --uid-range require --mailbox")
+	case o.uidSet != nil && (o.mode != "" || o.follow || o.limit > 0 || o.newestFirst):
+		return fmt.Errorf("--uid and --uid-range cannot be used with --mode, --follow, --limit or --newest-first")
+	}
+
+	switch o.mode {
+	case "":
+	case "nightly-delta":
+		return runNightlyDelta(cmd, o)
+	default:
+		return fmt.Errorf("invalid --mode: %s (must be 'nightly-delta')", o.mode)
+	}
+
 	// Validate required flags depending on mode
+	if o.dstLMTP != "" {
+		if o.srcGmailAPI {
+			return fmt.Errorf("--dst-lmtp cannot be combined with --src-gmail-api")
+		}
+		if o.lmtpRcpt == "" && o.dstUser == "" {
+			return fmt.Errorf("missing required flags: --lmtp-rcpt (or --dst-user) with --dst-lmtp")
+		}
+		if o.mboxPath != "" {
+			return runCopyMBOX(cmd, o)
+		}
+		if o.maildirPath != "" {
+			return runCopyMaildir(cmd, o)
+		}
+		if o.msgPath != "" {
+			return runCopyMsg(cmd, o)
+		}
+		if o.srcHost == "" || o.srcUser == "" || o.srcPass == "" {
+			return fmt.Errorf("missing required flags: --src-host, --src-user, --src-pass")
+		}
+		return runCopyIMAP(cmd, o)
+	}
+	if o.srcGmailAPI {
+		o.srcGmailToken = gmailToken(o.srcGmailToken)
+		if o.srcGmailToken == "" || o.dstHost == "" || o.dstUser == "" || o.dstPass == "" {
+			return fmt.Errorf("missing required flags: --src-gmail-token (or GOMAP_GMAIL_TOKEN), --dst-host, --dst-user, --dst-pass (required with --src-gmail-api)")
+		}
+		return runCopyGmail(cmd, o)
+	}
+	if o.maildirPath != "" {
+		if o.mboxPath != "" {
+			return fmt.Errorf("--maildir cannot be combined with --mbox")
+		}
+		if o.dstHost == "" || o.dstUser == "" || o.dstPass == "" {
+			return fmt.Errorf("missing required flags: --dst-host, --dst-user, --dst-pass (required with --maildir)")
+		}
+		return runCopyMaildir(cmd, o)
+	}
+	if o.msgPath != "" {
+		if o.mboxPath != "" || o.maildirPath != "" {
+			return fmt.Errorf("--msg cannot be combined with --mbox or --maildir")
+		}
+		if o.dstHost == "" || o.dstUser == "" || o.dstPass == "" {
+			return fmt.Errorf("missing required flags: --dst-host, --dst-user, --dst-pass (required with --msg)")
+		}
+		return runCopyMsg(cmd, o)
+	}
 	if o.mboxPath == "" {
 		// IMAP source mode
 		if o.srcHost == "" || o.srcUser == "" || o.srcPass == "" || o.dstHost == "" || o.dstUser == "" || o.dstPass == "" {
@@ -250,18 +1019,35 @@ type receiveOptions struct {
 	srcUser       string
 	srcPass       string
 	srcPassPrompt bool
+	identity      string
+	srcURL        string
 	insecure      bool
 	startTLS      bool
 	include       string
 	exclude       string
 	since         string
+	before        string
+	beforeTime    time.Time // --before parsed
 	skipSpecial   bool
 	skipTrash     bool
 	skipJunk      bool
 	skipDrafts    bool
 	skipSent      bool
 	outputDir     string
-	format        string // single-file | mbox
+	output        string // remote destination URL (s3, sftp, webdav, webdavs)
+	format        string // single-file | mbox | tar | sqlite | maildir
+	compress      bool   // gzip mbox output
+	splitBy       string // mbox: "" | year | month
+	execPerMsg    string // command run for each newly written .eml
+	metadata      bool   // single-file: write a <uid>.json sidecar per message
+	noRules       bool   // ignore receive_rules of the config
+	headerFilter  []string
+	subjectFilter []string
+	filters       headerFilters // both parsed
+	onlyUnseen    bool
+	onlyFlagged   bool
+	skipDeleted   bool
+	bwLimit       string
 	verbose       bool
 }
 
@@ -274,18 +1060,33 @@ func addReceiveFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&o.srcUser, "src-user", "", "Source IMAP username")
 	cmd.Flags().StringVar(&o.srcPass, "src-pass", "", "Source IMAP password")
 	cmd.Flags().BoolVar(&o.srcPassPrompt, "src-pass-prompt", false, "Prompt for source IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.identity, "identity", "", "Use the IMAP account of this identity from the config as source")
+	cmd.Flags().StringVar(&o.srcURL, "src", "", "Source as a connection URL, e.g. imaps://user@host:993 or imap+starttls://user@host (instead of --src-host, --src-port and --src-user)")
 	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
 	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
 	cmd.Flags().StringVar(&o.include, "include", "", "Regex of mailboxes to include")
 	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regex of mailboxes to exclude")
 	cmd.Flags().StringVar(&o.since, "since", "", "Only download messages with INTERNALDATE >= since (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&o.before, "before", "", "Only download messages with INTERNALDATE before this day (YYYY-MM-DD, or an age like 90d, 6m, 2y)")
 	cmd.Flags().BoolVar(&o.skipSpecial, "skip-special", false, "Skip common special folders like Trash/Junk/Drafts/Sent")
 	cmd.Flags().BoolVar(&o.skipTrash, "skip-trash", false, "Skip Trash folders")
 	cmd.Flags().BoolVar(&o.skipJunk, "skip-junk", false, "Skip Junk/Spam folders")
 	cmd.Flags().BoolVar(&o.skipDrafts, "skip-drafts", false, "Skip Drafts folders")
 	cmd.Flags().BoolVar(&o.skipSent, "skip-sent", false, "Skip Sent folders")
 	cmd.Flags().StringVar(&o.outputDir, "output-dir", "gomap-download", "Directory to store downloaded emails")
-	cmd.Flags().StringVar(&o.format, "format", "single-file", "Storage format: single-file or mbox")
+	cmd.Flags().StringVar(&o.output, "output", "", "Write to remote storage instead of --output-dir: s3://bucket/prefix, sftp://user@host/path, webdav(s)://user@host/path")
+	cmd.Flags().StringVar(&o.format, "format", "single-file", "Storage format: single-file, mbox, tar (one .tar.gz for all mailboxes), sqlite (searchable gomap.db, see 'gomap grep') or maildir (Maildir++ tree with flags)")
+	cmd.Flags().BoolVar(&o.compress, "compress", false, "Gzip-compress mbox output (writes <mailbox>.mbox.gz)")
+	cmd.Flags().StringVar(&o.splitBy, "split-by", "", "Split mbox output by INTERNALDATE: year (<mailbox>-2023.mbox) or month (<mailbox>-2023-05.mbox)")
+	cmd.Flags().StringVar(&o.execPerMsg, "exec-per-message", "", "Shell command run for each newly written .eml ('{}' is replaced by the quoted path, also in GOMAP_FILE; single-file only)")
+	cmd.Flags().BoolVar(&o.metadata, "metadata", false, "Write a <uid>.json sidecar with flags, INTERNALDATE, UID and UIDVALIDITY next to each .eml (single-file only)")
+	cmd.Flags().BoolVar(&o.noRules, "no-rules", false, "Ignore the receive_rules of the config and write every mailbox to --output-dir/--output")
+	cmd.Flags().StringArrayVar(&o.headerFilter, "header-filter", nil, "Only download messages with a header matching HEADER:REGEX, e.g. 'From:@example\\.com' (case-insensitive; '!HEADER:REGEX' excludes matches instead; repeatable, all must hold)")
+	cmd.Flags().StringArrayVar(&o.subjectFilter, "subject-filter", nil, "Only download messages whose subject matches REGEX ('!REGEX' excludes matches instead; repeatable)")
+	cmd.Flags().BoolVar(&o.onlyUnseen, "only-unseen", false, "Only download messages without \\Seen")
+	cmd.Flags().BoolVar(&o.onlyFlagged, "only-flagged", false, "Only download messages with \\Flagged")
+	cmd.Flags().BoolVar(&o.skipDeleted, "skip-deleted", false, "Do not download messages marked \\Deleted")
+	cmd.Flags().StringVar(&o.bwLimit, "bwlimit", "", "Limit the IMAP connection to this many bytes per second in each direction, e.g. 5M (K, M and G count in 1024s)")
 	cmd.Flags().BoolVar(&o.verbose, "verbose", false, "Enable detailed logs")
 	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
 		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
@@ -302,6 +1103,7 @@ type markReadOptions struct {
 	dstUser       string
 	dstPass       string
 	dstPassPrompt bool
+	dstURL        string
 	insecure      bool
 	startTLS      bool
 	mailbox       string
@@ -321,6 +1123,7 @@ func addMarkReadFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&o.dstUser, "dst-user", "", "Destination IMAP username")
 	cmd.Flags().StringVar(&o.dstPass, "dst-pass", "", "Destination IMAP password")
 	cmd.Flags().BoolVar(&o.dstPassPrompt, "dst-pass-prompt", false, "Prompt for destination IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.dstURL, "dst", "", "Destination as a connection URL, e.g. imaps://user@host:993 (instead of --dst-host, --dst-port and --dst-user)")
 	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
 	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
 	cmd.Flags().StringVar(&o.mailbox, "mailbox", "INBOX", "Mailbox to mark as read (\\Seen)")
@@ -337,6 +1140,9 @@ func addMarkReadFlags(cmd *cobra.Command) {
 
 func runMarkRead(cmd *cobra.Command, args []string) error {
 	o := cmd.Context().Value(ctxKey{}).(*markReadOptions)
+	if err := applyEndpoints(cmd, endpointFlag{loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, "imap", o.dstURL}); err != nil {
+		return err
+	}
 	if o.dstPassPrompt && o.dstPass == "" {
 		fmt.Fprint(os.Stderr, "Destination password: ")
 		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
@@ -358,32 +1164,23 @@ func runMarkRead(cmd *cobra.Command, args []string) error {
 	defer dst.Logout()
 
 	// Resolve mailbox list
-	boxes := []string{o.mailbox}
+	boxes := []string{imaputil.DecodeMailboxName(o.mailbox)}
 	if o.all {
 		// list all, then filter include/exclude
 		allBoxes, err := imaputil.ListMailboxes(ctx, dst)
 		if err != nil {
 			return fmt.Errorf("list mailboxes: %w", err)
 		}
-		var includeRe, excludeRe *regexp.Regexp
-		if o.include != "" {
-			includeRe, err = regexp.Compile(o.include)
-			if err != nil {
-				return fmt.Errorf("invalid --include: %w", err)
-			}
-		}
-		if o.exclude != "" {
-			excludeRe, err = regexp.Compile(o.exclude)
-			if err != nil {
-				return fmt.Errorf("invalid --exclude: %w", err)
-			}
+		includeRe, excludeRe, err := mailboxFilters(o.include, o.exclude)
+		if err != nil {
+			return err
 		}
 		boxes = boxes[:0]
 		for _, b := range allBoxes {
-			if includeRe != nil && !includeRe.MatchString(b) {
+			if includeRe != nil && !matchMailbox(includeRe, b) {
 				continue
 			}
-			if excludeRe != nil && excludeRe.MatchString(b) {
+			if excludeRe != nil && matchMailbox(excludeRe, b) {
 				continue
 			}
 			boxes = append(boxes, b)
@@ -459,30 +1256,28 @@ func runMarkRead(cmd *cobra.Command, args []string) error {
 		fmt.Println("No messages matched.")
 		return nil
 	}
-
-	progress := make(chan int, 128)
-	errc := make(chan error, 1)
-	go func() {
-		defer close(progress)
-		defer close(errc)
+	return runCountWork(ctx, total, "Mark as \\Seen", func(ctx context.Context, progress chan<- int) error {
 		const chunkSize = 500
 		for _, p := range plans {
+			if ctx.Err() != nil {
+				break
+			}
 			// Select RW to update flags
-			if _, err := imaputil.SelectMailbox(dst, p.name, false); err != nil {
+			if _, err := imaputil.SelectMailbox(dst, p.name, dryRun); err != nil {
 				fmt.Fprintf(os.Stderr, "select %s: %v\n", p.name, err)
 				continue
 			}
 			if p.all {
 				seq := new(imap.SeqSet)
 				seq.AddRange(1, 0)
-				if err := dst.Store(seq, imap.AddFlags, []interface{}{imap.SeenFlag}, nil); err != nil {
+				if err := markSeen(dst, p.name, seq, p.count); err != nil {
 					fmt.Fprintf(os.Stderr, "store %s: %v\n", p.name, err)
 					continue
 				}
 				progress <- p.count
 			} else {
 				// chunked updates
-				for i := 0; i < len(p.seqNums); i += chunkSize {
+				for i := 0; i < len(p.seqNums) && ctx.Err() == nil; i += chunkSize {
 					end := i + chunkSize
 					if end > len(p.seqNums) {
 						end = len(p.seqNums)
@@ -491,7 +1286,7 @@ func runMarkRead(cmd *cobra.Command, args []string) error {
 					for _, n := range p.seqNums[i:end] {
 						seq.AddNum(n)
 					}
-					if err := dst.Store(seq, imap.AddFlags, []interface{}{imap.SeenFlag}, nil); err != nil {
+					if err := markSeen(dst, p.name, seq, end-i); err != nil {
 						fmt.Fprintf(os.Stderr, "store %s: %v\n", p.name, err)
 						break
 					}
@@ -499,12 +1294,17 @@ func runMarkRead(cmd *cobra.Command, args []string) error {
 				}
 			}
 		}
-		errc <- nil
-	}()
+		return nil
+	})
+}
 
-	// Run TUI progress
-	_ = runCountTUI(total, "Mark as \\Seen", progress, errc)
-	return nil
+// markSeen adds \Seen to the n messages seq of mailbox, the selected
+// mailbox of dst.
+func markSeen(dst *client.Client, mailbox string, seq *imap.SeqSet, n int) error {
+	action := fmt.Sprintf("mark %d message(s) in %s as \\Seen", n, mailbox)
+	return perform(action, func() error {
+		return dst.Store(seq, imap.AddFlags, []interface{}{imap.SeenFlag}, nil)
+	})
 }
 
 // ========================= DELETE =========================
@@ -515,6 +1315,7 @@ type deleteOptions struct {
 	dstUser       string
 	dstPass       string
 	dstPassPrompt bool
+	dstURL        string
 	insecure      bool
 	startTLS      bool
 	mailbox       string
@@ -524,7 +1325,6 @@ type deleteOptions struct {
 	startDate     string
 	endDate       string
 	expunge       bool
-	dryRun        bool
 }
 
 func addDeleteFlags(cmd *cobra.Command) {
@@ -536,6 +1336,7 @@ func addDeleteFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&o.dstUser, "dst-user", "", "Destination IMAP username")
 	cmd.Flags().StringVar(&o.dstPass, "dst-pass", "", "Destination IMAP password")
 	cmd.Flags().BoolVar(&o.dstPassPrompt, "dst-pass-prompt", false, "Prompt for destination IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.dstURL, "dst", "", "Destination as a connection URL, e.g. imaps://user@host:993 (instead of --dst-host, --dst-port and --dst-user)")
 	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
 	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
 	cmd.Flags().StringVar(&o.mailbox, "mailbox", "INBOX", "Mailbox to delete messages from")
@@ -545,7 +1346,6 @@ func addDeleteFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&o.startDate, "start-date", "", "Only affect messages with INTERNALDATE >= start-date (YYYY-MM-DD)")
 	cmd.Flags().StringVar(&o.endDate, "end-date", "", "Only affect messages with INTERNALDATE <= end-date (YYYY-MM-DD)")
 	cmd.Flags().BoolVar(&o.expunge, "expunge", true, "Permanently remove messages after marking as \\Deleted")
-	cmd.Flags().BoolVar(&o.dryRun, "dry-run", false, "Don't modify anything, just print what would happen")
 	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
 		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
 		return nil
@@ -554,6 +1354,9 @@ func addDeleteFlags(cmd *cobra.Command) {
 
 func runDelete(cmd *cobra.Command, args []string) error {
 	o := cmd.Context().Value(ctxKey{}).(*deleteOptions)
+	if err := applyEndpoints(cmd, endpointFlag{loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, "imap", o.dstURL}); err != nil {
+		return err
+	}
 	if o.dstPassPrompt && o.dstPass == "" {
 		fmt.Fprint(os.Stderr, "Destination password: ")
 		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
@@ -575,31 +1378,22 @@ func runDelete(cmd *cobra.Command, args []string) error {
 	defer dst.Logout()
 
 	// build mailbox list
-	boxes := []string{o.mailbox}
+	boxes := []string{imaputil.DecodeMailboxName(o.mailbox)}
 	if o.all {
 		allBoxes, err := imaputil.ListMailboxes(ctx, dst)
 		if err != nil {
 			return fmt.Errorf("list mailboxes: %w", err)
 		}
-		var includeRe, excludeRe *regexp.Regexp
-		if o.include != "" {
-			includeRe, err = regexp.Compile(o.include)
-			if err != nil {
-				return fmt.Errorf("invalid --include: %w", err)
-			}
-		}
-		if o.exclude != "" {
-			excludeRe, err = regexp.Compile(o.exclude)
-			if err != nil {
-				return fmt.Errorf("invalid --exclude: %w", err)
-			}
+		includeRe, excludeRe, err := mailboxFilters(o.include, o.exclude)
+		if err != nil {
+			return err
 		}
 		boxes = boxes[:0]
 		for _, b := range allBoxes {
-			if includeRe != nil && !includeRe.MatchString(b) {
+			if includeRe != nil && !matchMailbox(includeRe, b) {
 				continue
 			}
-			if excludeRe != nil && excludeRe.MatchString(b) {
+			if excludeRe != nil && matchMailbox(excludeRe, b) {
 				continue
 			}
 			boxes = append(boxes, b)
@@ -627,7 +1421,7 @@ func runDelete(cmd *cobra.Command, args []string) error {
 	}
 
 	for _, box := range boxes {
-		if _, err := imaputil.SelectMailbox(dst, box, false); err != nil {
+		if _, err := imaputil.SelectMailbox(dst, box, dryRun); err != nil {
 			fmt.Fprintf(os.Stderr, "select %s: %v\n", box, err)
 			continue
 		}
@@ -658,10 +1452,6 @@ func runDelete(cmd *cobra.Command, args []string) error {
 			}
 			actionDesc = fmt.Sprintf("%d matching messages", len(seqNums))
 		}
-		if o.dryRun {
-			fmt.Printf("[dry-run] delete in %s: %s\n", box, actionDesc)
-			continue
-		}
 		// Confirmation prompt via Bubble Tea
 		summary := fmt.Sprintf("Mailbox: %s\nAction: delete%s\nRange: %s\nExpunge: %v",
 			box,
@@ -680,27 +1470,35 @@ func runDelete(cmd *cobra.Command, args []string) error {
 			}(),
 			o.expunge,
 		)
-		ok, err := runConfirmTUI("Confirm delete", summary)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			fmt.Println("Cancelled.")
-			continue
+		if !dryRun {
+			ok, err := runConfirmTUI("Confirm delete", summary)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Println("Cancelled.")
+				continue
+			}
 		}
-		if err := dst.Store(seq, imap.AddFlags, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		err := perform(fmt.Sprintf("mark %s in %s as \\Deleted", actionDesc, box), func() error {
+			return dst.Store(seq, imap.AddFlags, []interface{}{imap.DeletedFlag}, nil)
+		})
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "store %s: %v\n", box, err)
 			continue
 		}
 		if o.expunge {
-			if err := dst.Expunge(nil); err != nil {
+			if err := perform("expunge "+box, func() error { return dst.Expunge(nil) }); err != nil {
 				fmt.Fprintf(os.Stderr, "expunge %s: %v\n", box, err)
 				continue
 			}
 		}
-		if o.expunge {
+		switch {
+		case dryRun:
+			// perform listed the actions
+		case o.expunge:
 			fmt.Printf("Deleted %s in %s (expunged).\n", actionDesc, box)
-		} else {
+		default:
 			fmt.Printf("Marked %s in %s as \\Deleted (not expunged).\n", actionDesc, box)
 		}
 	}
@@ -710,6 +1508,16 @@ func runDelete(cmd *cobra.Command, args []string) error {
 func runReceive(cmd *cobra.Command, args []string) error {
 	o := cmd.Context().Value(ctxKey{}).(*receiveOptions)
 
+	if o.identity != "" {
+		_, acc, _, err := lookupIdentity(o.identity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "src", host: &o.srcHost, port: &o.srcPort, user: &o.srcUser, pass: &o.srcPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if err := applyEndpoints(cmd, endpointFlag{loginTarget{prefix: "src", host: &o.srcHost, port: &o.srcPort, user: &o.srcUser, pass: &o.srcPass, startTLS: &o.startTLS, insecure: &o.insecure}, "imap", o.srcURL}); err != nil {
+		return err
+	}
 	if o.srcPassPrompt && o.srcPass == "" {
 		fmt.Fprint(os.Stderr, "Source password: ")
 		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
@@ -722,26 +1530,29 @@ func runReceive(cmd *cobra.Command, args []string) error {
 	if o.srcHost == "" || o.srcUser == "" || o.srcPass == "" {
 		return fmt.Errorf("missing required flags: --src-host, --src-user, --src-pass")
 	}
-	if o.format != "single-file" && o.format != "mbox" {
-		return fmt.Errorf("invalid --format: %s (must be 'single-file' or 'mbox')", o.format)
-	}
-	if err := os.MkdirAll(o.outputDir, 0o755); err != nil {
-		return fmt.Errorf("create output-dir: %w", err)
+	if err := o.validateOutput(); err != nil {
+		return err
 	}
-	var includeRe, excludeRe *regexp.Regexp
 	var err error
-	if o.include != "" {
-		includeRe, err = regexp.Compile(o.include)
-		if err != nil {
-			return fmt.Errorf("invalid --include regex: %w", err)
-		}
+	if o.filters, err = parseHeaderFilters(o.headerFilter, o.subjectFilter); err != nil {
+		return err
 	}
-	if o.exclude != "" {
-		excludeRe, err = regexp.Compile(o.exclude)
-		if err != nil {
-			return fmt.Errorf("invalid --exclude regex: %w", err)
+	if o.beforeTime, err = parseBefore(o.before, time.Now()); err != nil {
+		return err
+	}
+	if err := withBandwidthLimit(cmd, o.bwLimit); err != nil {
+		return err
+	}
+	var rules []receiveRule
+	if !o.noRules {
+		if rules, err = loadReceiveRules(o); err != nil {
+			return err
 		}
 	}
+	includeRe, excludeRe, err := mailboxFilters(o.include, o.exclude)
+	if err != nil {
+		return err
+	}
 	var sinceTime time.Time
 	if o.since != "" {
 		sinceTime, err = time.Parse("2006-01-02", o.since)
@@ -771,34 +1582,46 @@ func runReceive(cmd *cobra.Command, args []string) error {
 
 	specialPatterns := []string{}
 	if o.skipSpecial || o.skipTrash {
-		specialPatterns = append(specialPatterns, `(?i)^(Trash|Gelöscht.*|Deleted Items|Papierkorb)$`)
+		specialPatterns = append(specialPatterns, trashFolders)
 	}
 	if o.skipSpecial || o.skipJunk {
-		specialPatterns = append(specialPatterns, `(?i)^(Junk|Spam|Bulk Mail|Unerw.*)$`)
+		specialPatterns = append(specialPatterns, junkFolders)
 	}
 	if o.skipSpecial || o.skipDrafts {
-		specialPatterns = append(specialPatterns, `(?i)^(Drafts|Entwürfe)$`)
+		specialPatterns = append(specialPatterns, draftsFolders)
 	}
 	if o.skipSpecial || o.skipSent {
-		specialPatterns = append(specialPatterns, `(?i)^(Sent( Items)?|Gesendet.*)$`)
+		specialPatterns = append(specialPatterns, sentFolders)
 	}
 	var specialRe *regexp.Regexp
 	if len(specialPatterns) > 0 {
 		specialRe = regexp.MustCompile(strings.Join(specialPatterns, "|"))
 	}
+	// folders the server marks as special are skipped by role, whatever
+	// their name
+	skipRole := map[string]bool{
+		"trash":  o.skipSpecial || o.skipTrash,
+		"junk":   o.skipSpecial || o.skipJunk,
+		"drafts": o.skipSpecial || o.skipDrafts,
+		"sent":   o.skipSpecial || o.skipSent,
+	}
+	srcQuirks := serverQuirks(src, o.srcHost)
 
 	filtered := make([]string, 0, len(boxes))
 	for _, b := range boxes {
 		name := b
-		if includeRe != nil && !includeRe.MatchString(name) {
+		if includeRe != nil && !matchMailbox(includeRe, name) {
 			continue
 		}
-		if excludeRe != nil && excludeRe.MatchString(name) {
+		if excludeRe != nil && matchMailbox(excludeRe, name) {
 			continue
 		}
 		if specialRe != nil && specialRe.MatchString(name) {
 			continue
 		}
+		if skipRole[srcQuirks.Role(name)] {
+			continue
+		}
 		filtered = append(filtered, name)
 	}
 	if len(filtered) == 0 {
@@ -809,25 +1632,191 @@ func runReceive(cmd *cobra.Command, args []string) error {
 		log.Printf("Mailboxes to download (%d): %s", len(filtered), strings.Join(filtered, ", "))
 	}
 
-	for _, box := range filtered {
+	var notice func() string
+	if o.verbose {
+		notice = updateNotice(ctx)
+	}
+	targets := receiveTargets(o, rules, filtered)
+	for _, t := range targets {
+		if len(targets) > 1 && o.verbose {
+			log.Printf("Writing %s as %s to %s", strings.Join(t.boxes, ", "), t.o.format, t.o.destination())
+		}
+		if err := receiveInto(ctx, &src, t.o, t.boxes, sinceTime); err != nil {
+			return err
+		}
+	}
+	if notice != nil {
+		if n := notice(); n != "" {
+			log.Print(n)
+		}
+	}
+	return nil
+}
+
+// destination names where o writes, for logs.
+func (o *receiveOptions) destination() string {
+	if o.output != "" {
+		return o.output
+	}
+	return o.outputDir
+}
+
+// receiveInto downloads boxes into the output of o: the format's sinks
+// are opened, every mailbox is downloaded and the run-wide outputs (tar
+// archive) are finished.
+func receiveInto(ctx context.Context, src **client.Client, o *receiveOptions, boxes []string, sinceTime time.Time) error {
+	var err error
+	var sinks backupSinks
+	if o.output != "" {
+		if sinks.remote, err = openRemoteOutput(o.output); err != nil {
+			return err
+		}
+		defer sinks.remote.Close()
+	} else if dryRun {
+		// nothing is written
+	} else if err := os.MkdirAll(o.outputDir, 0o755); err != nil {
+		return fmt.Errorf("create output-dir: %w", err)
+	}
+
+	// tar format: a single write-once archive for the whole run
+	finishTar := func() error { return nil }
+	if o.format == "tar" && !dryRun {
+		tarName := fmt.Sprintf("gomap-backup-%s.tar.gz", time.Now().Format("20060102-150405"))
+		var f *os.File
+		if sinks.remote != nil {
+			// staged locally, uploaded once complete
+			f, err = os.CreateTemp("", "gomap-backup-*.tar.gz")
+			if err == nil {
+				defer os.Remove(f.Name())
+			}
+		} else {
+			f, err = os.OpenFile(filepath.Join(o.outputDir, tarName), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		}
+		if err != nil {
+			return fmt.Errorf("create archive: %w", err)
+		}
+		defer f.Close()
+		zw := gzip.NewWriter(f)
+		sinks.tw = tar.NewWriter(zw)
+		finishTar = func() error {
+			if err := sinks.tw.Close(); err != nil {
+				return fmt.Errorf("write archive: %w", err)
+			}
+			if err := zw.Close(); err != nil {
+				return fmt.Errorf("write archive: %w", err)
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("write archive: %w", err)
+			}
+			if sinks.remote == nil {
+				return nil
+			}
+			if o.verbose {
+				log.Printf("Uploading archive to %s%s", sinks.remote, tarName)
+			}
+			return uploadFile(ctx, sinks.remote, tarName, f.Name())
+		}
+		if o.verbose {
+			log.Printf("Writing archive %s", tarName)
+		}
+	}
+	// sqlite format: one database with a full-text index, reused across runs
+	// (with --dry-run only an existing one, to tell which messages are new)
+	dbPath := filepath.Join(o.outputDir, mailstoreFile)
+	if _, serr := os.Stat(dbPath); o.format == "sqlite" && (!dryRun || serr == nil) {
+		sinks.store, err = mailstore.Open(dbPath)
+		if err != nil {
+			return fmt.Errorf("open database: %w", err)
+		}
+		defer sinks.store.Close()
+		if o.verbose {
+			log.Printf("Writing database %s", dbPath)
+		}
+	}
+	// maildir format: the output directory is the Maildir++ root (INBOX)
+	if o.format == "maildir" {
+		if sinks.delim, err = imaputil.Delimiter(*src); err != nil {
+			return fmt.Errorf("list hierarchy delimiter: %w", err)
+		}
+		if dryRun {
+			// nothing is written
+		} else if err := maildir.Create(o.outputDir, false); err != nil {
+			return fmt.Errorf("create maildir: %w", err)
+		}
+	}
+
+	for _, box := range boxes {
 		if o.verbose {
 			log.Printf("[%s] scanning", box)
 		}
-		if err := downloadMailbox(ctx, &src, box, sinceTime, o); err != nil {
+		if err := downloadMailbox(ctx, src, box, sinceTime, o, sinks); err != nil {
 			fmt.Fprintf(os.Stderr, "[%s] error: %v\n", box, err)
 		}
 	}
+	return finishTar()
+}
+
+// listDownload lists for --dry-run what downloadMailbox would write for
+// uids. Messages already in the output are left out where that is known
+// without fetching them: existing .eml files and messages in an existing
+// sqlite database.
+func listDownload(box string, uids []uint32, o *receiveOptions, sinks backupSinks, base, folder string) error {
+	n := 0
+	for _, uid := range uids {
+		switch {
+		case o.format == "single-file" && sinks.remote == nil:
+			if _, err := os.Stat(filepath.Join(base, fmt.Sprintf("%d.eml", uid))); err == nil {
+				continue
+			}
+		case o.format == "sqlite" && sinks.store != nil:
+			has, err := sinks.store.Has(box, uid)
+			if err != nil {
+				return err
+			}
+			if has {
+				continue
+			}
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	var dest string
+	switch {
+	case sinks.remote != nil:
+		dest = fmt.Sprintf("%s%s", sinks.remote, filepath.ToSlash(mailboxPath("", box)))
+	case o.format == "single-file":
+		dest = base
+	case o.format == "mbox":
+		dest = base + ".mbox"
+		if o.splitBy != "" {
+			dest = base + "-<" + o.splitBy + ">.mbox"
+		}
+		if o.compress {
+			dest += ".gz"
+		}
+	case o.format == "tar":
+		dest = "the archive in " + o.outputDir
+	case o.format == "sqlite":
+		dest = filepath.Join(o.outputDir, mailstoreFile)
+	case o.format == "maildir":
+		dest = folder
+	}
+	dryRunf("download %d message(s) from %s to %s", n, box, dest)
 	return nil
 }
 
-func isConnClosed(err error) bool {
-	if err == nil {
-		return false
+// writeEml writes a single-file message under a temporary name and
+// renames it into place, so an interrupted run never leaves a partial
+// <uid>.eml that the next run would take as downloaded.
+func writeEml(path string, raw []byte) error {
+	tmp := path + ".part"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		os.Remove(tmp)
+		return err
 	}
-	msg := err.Error()
-	return strings.Contains(msg, "imap: connection closed") ||
-		strings.Contains(msg, "connection closed during command execution") ||
-		strings.Contains(msg, "unexpected EOF")
+	return os.Rename(tmp, path)
 }
 
 func reconnectSrc(ctx context.Context, src **client.Client, o *receiveOptions) error {
@@ -843,12 +1832,25 @@ func reconnectSrc(ctx context.Context, src **client.Client, o *receiveOptions) e
 	return nil
 }
 
-func downloadMailbox(ctx context.Context, src **client.Client, box string, since time.Time, o *receiveOptions) error {
+// backupSinks holds the run-wide outputs of a backup; which ones are set
+// depends on --format and --output.
+type backupSinks struct {
+	tw     *tar.Writer      // tar format
+	store  *mailstore.Store // sqlite format
+	remote remoteOutput     // --output, nil for the local filesystem
+	delim  string           // maildir format: source hierarchy delimiter
+}
+
+func downloadMailbox(ctx context.Context, src **client.Client, box string, since time.Time, o *receiveOptions, sinks backupSinks) error {
 	const fetchBatchSize = 500
 	const maxReconnectAttempts = 3
 
+	var uidValidity uint32
 	selectMailbox := func() error {
-		_, err := imaputil.SelectMailbox(*src, box, true)
+		status, err := imaputil.SelectMailbox(*src, box, true)
+		if err == nil {
+			uidValidity = status.UidValidity
+		}
 		return err
 	}
 	reconnectAndSelect := func() error {
@@ -861,16 +1863,20 @@ func downloadMailbox(ctx context.Context, src **client.Client, box string, since
 			}
 			if err := selectMailbox(); err == nil {
 				return nil
-			} else if !isConnClosed(err) {
+			} else if !imaputil.ConnClosed(err) {
 				return err
 			}
 		}
 		return fmt.Errorf("reconnect failed after %d attempts", maxReconnectAttempts)
 	}
 
-	// Select mailbox
+	// Select mailbox; one deleted since it was listed is skipped
 	if err := selectMailbox(); err != nil {
-		if !isConnClosed(err) {
+		if errors.Is(err, imaputil.ErrNoMailbox) {
+			log.Printf("[%s] no longer exists on the source, skipped", box)
+			return nil
+		}
+		if !imaputil.ConnClosed(err) {
 			return err
 		}
 		if err := reconnectAndSelect(); err != nil {
@@ -878,56 +1884,201 @@ func downloadMailbox(ctx context.Context, src **client.Client, box string, since
 		}
 	}
 	// Search UIDs
-	uids, err := imaputil.SearchUIDsSince(*src, since, 0)
+	flags := imaputil.FlagFilter{OnlyUnseen: o.onlyUnseen, OnlyFlagged: o.onlyFlagged, SkipDeleted: o.skipDeleted}
+	uids, err := imaputil.SearchUIDs(*src, since, o.beforeTime, 0, flags)
 	if err != nil {
-		if isConnClosed(err) {
+		if imaputil.ConnClosed(err) {
 			if err := reconnectAndSelect(); err != nil {
 				return err
 			}
-			uids, err = imaputil.SearchUIDsSince(*src, since, 0)
+			uids, err = imaputil.SearchUIDs(*src, since, o.beforeTime, 0, flags)
 		}
 		if err != nil {
 			return err
 		}
 	}
-	if len(uids) == 0 {
-		if o.verbose {
-			log.Printf("[%s] no messages to download", box)
-		}
-		return nil
-	}
-	// Prepare output paths
-	base := mailboxPath(o.outputDir, box)
-	if o.format == "single-file" {
-		if err := os.MkdirAll(base, 0o755); err != nil {
+	// mailbox path relative to the output root, slash-separated
+	relDir := filepath.ToSlash(mailboxPath("", box))
+	// remote single-file: skip messages uploaded before without fetching them
+	if sinks.remote != nil && o.format == "single-file" && len(uids) > 0 {
+		names, err := sinks.remote.List(ctx, relDir)
+		if err != nil {
 			return err
 		}
-	} else {
-		// ensure parent directory for mbox file exists
-		parent := filepath.Dir(base)
-		if err := os.MkdirAll(parent, 0o755); err != nil {
-			return err
+		existing := make(map[string]bool, len(names))
+		for _, n := range names {
+			existing[n] = true
+		}
+		kept := uids[:0]
+		for _, uid := range uids {
+			if !existing[path.Join(relDir, fmt.Sprintf("%d.eml", uid))] {
+				kept = append(kept, uid)
+			}
+		}
+		if o.verbose && len(kept) < len(uids) {
+			log.Printf("[%s] skip %d messages already in %s", box, len(uids)-len(kept), sinks.remote)
 		}
+		uids = kept
 	}
-
-	section := &imap.BodySectionName{}
-	items := []imap.FetchItem{section.FetchItem(), imap.FetchInternalDate, imap.FetchUid}
-
-	var mboxFile *os.File
-	var mboxPath string
-	if o.format == "mbox" {
-		// mbox file named after the mailbox, in its parent directory
-		mboxPath = filepath.Join(filepath.Dir(base), filepath.Base(base)+".mbox")
-		// Create or append
-		f, err := os.OpenFile(mboxPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	// maildir: one Maildir++ folder per mailbox; skip UIDs written before
+	var folder string
+	if o.format == "maildir" {
+		folder = filepath.Join(o.outputDir, maildir.FolderDir(box, sinks.delim))
+		if !dryRun {
+			if err := maildir.Create(folder, folder != o.outputDir); err != nil {
+				return err
+			}
+		}
+		existing, err := maildir.UIDs(folder)
+		if err != nil {
+			return err
+		}
+		kept := uids[:0]
+		for _, uid := range uids {
+			if !existing[uid] {
+				kept = append(kept, uid)
+			}
+		}
+		if o.verbose && len(kept) < len(uids) {
+			log.Printf("[%s] skip %d messages already in %s", box, len(uids)-len(kept), folder)
+		}
+		uids = kept
+	}
+	// --header-filter: drop non-matching messages before fetching bodies
+	if len(o.filters) > 0 && len(uids) > 0 {
+		hdrs, err := imaputil.HeaderFields(*src, uids, o.filters.fields())
 		if err != nil {
 			return err
 		}
-		mboxFile = f
-		defer mboxFile.Close()
+		kept := uids[:0]
+		for _, uid := range uids {
+			if hdr, ok := hdrs[uid]; ok && o.filters.match(hdr) {
+				kept = append(kept, uid)
+			}
+		}
+		if o.verbose && len(kept) < len(uids) {
+			log.Printf("[%s] skip %d messages not matching the header filter", box, len(uids)-len(kept))
+		}
+		uids = kept
+	}
+	if len(uids) == 0 {
+		if o.verbose {
+			log.Printf("[%s] no messages to download", box)
+		}
+		return nil
+	}
+	// Prepare output paths
+	base := mailboxPath(o.outputDir, box)
+	if dryRun {
+		return listDownload(box, uids, o, sinks, base, folder)
+	}
+	if sinks.remote != nil {
+		// nothing to prepare
+	} else if o.format == "single-file" {
+		if err := os.MkdirAll(base, 0o755); err != nil {
+			return err
+		}
+	} else if o.format == "mbox" {
+		// ensure parent directory for mbox file exists
+		parent := filepath.Dir(base)
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return err
+		}
 	}
 
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{section.FetchItem(), imap.FetchInternalDate, imap.FetchUid}
+	if o.format == "maildir" || o.format == "mbox" || o.metadata {
+		items = append(items, imap.FetchFlags)
+	}
+
+	// mbox files of this mailbox, keyed by --split-by period ("" when not
+	// split) and opened on first use
+	type mboxFile struct {
+		path string // local path or remote name
+		f    *os.File
+		zw   *gzip.Writer
+		w    io.Writer
+		n    int
+	}
+	mboxFiles := map[string]*mboxFile{}
+	var mboxOrder []*mboxFile
+	stamp := time.Now().Format("20060102-150405")
 	count := 0
+	defer func() {
+		// flush what was written if the mailbox fails half-way
+		for _, mf := range mboxOrder {
+			if mf.zw != nil {
+				_ = mf.zw.Close()
+			}
+			mf.f.Close()
+			if sinks.remote != nil {
+				os.Remove(mf.f.Name())
+			}
+		}
+	}()
+	openMbox := func(period string) (*mboxFile, error) {
+		if mf := mboxFiles[period]; mf != nil {
+			return mf, nil
+		}
+		suffix := ""
+		if period != "" {
+			suffix = "-" + period
+		}
+		mf := &mboxFile{}
+		var err error
+		if sinks.remote != nil {
+			// Remote outputs are write-once, so every run uploads its own
+			// file, staged locally until the mailbox is complete.
+			mf.path = fmt.Sprintf("%s%s-%s.mbox", relDir, suffix, stamp)
+			mf.f, err = os.CreateTemp("", "gomap-*.mbox")
+		} else {
+			// mbox file named after the mailbox, in its parent directory
+			mf.path = filepath.Join(filepath.Dir(base), filepath.Base(base)+suffix+".mbox")
+			if o.compress {
+				mf.path += ".gz"
+			}
+			// Create or append
+			mf.f, err = os.OpenFile(mf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sinks.remote != nil && o.compress {
+			mf.path += ".gz"
+		}
+		mf.w = mf.f
+		if o.compress {
+			// Each run appends a new gzip member; readers treat concatenated
+			// members as one stream.
+			mf.zw = gzip.NewWriter(mf.f)
+			mf.w = mf.zw
+		}
+		mboxFiles[period] = mf
+		mboxOrder = append(mboxOrder, mf)
+		return mf, nil
+	}
+	finishMbox := func() error {
+		for _, mf := range mboxOrder {
+			if mf.zw != nil {
+				if err := mf.zw.Close(); err != nil {
+					return err
+				}
+			}
+			if err := mf.f.Close(); err != nil {
+				return err
+			}
+			if sinks.remote == nil || mf.n == 0 {
+				continue
+			}
+			if err := uploadFile(ctx, sinks.remote, mf.path, mf.f.Name()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tarWritten := map[uint32]bool{} // avoids duplicate entries when a batch is retried
 	fetchBatch := func(batch []uint32) error {
 		seq := new(imap.SeqSet)
 		for _, uid := range batch {
@@ -959,31 +2110,130 @@ func downloadMailbox(ctx context.Context, src **client.Client, box string, since
 				continue
 			}
 			raw := buf.Bytes()
-			if o.format == "single-file" {
-				outPath := filepath.Join(base, fmt.Sprintf("%d.eml", uid))
-				// resume: skip if exists
-				if _, err := os.Stat(outPath); err == nil {
-					if o.verbose {
-						log.Printf("[%s] skip existing %s", box, outPath)
+			var meta []byte
+			if o.metadata {
+				m := &messageMeta{Mailbox: box, UID: uid, UIDValidity: uidValidity, InternalDate: msg.InternalDate, Flags: msg.Flags, Size: len(raw)}
+				if meta, err = m.marshal(); err != nil {
+					firstErr = err
+					continue
+				}
+			}
+			if o.format == "single-file" && sinks.remote != nil {
+				name := path.Join(relDir, fmt.Sprintf("%d.eml", uid))
+				if meta != nil {
+					// before the .eml, so a message counted as present has one
+					if err := sinks.remote.Put(ctx, metaName(name), bytes.NewReader(meta), int64(len(meta))); err != nil {
+						firstErr = err
+						continue
 					}
+				}
+				if err := sinks.remote.Put(ctx, name, bytes.NewReader(raw), int64(len(raw))); err != nil {
+					firstErr = err
 					continue
 				}
-				if err := os.WriteFile(outPath, raw, 0o644); err != nil {
+				if o.verbose {
+					log.Printf("[%s] uploaded %s", box, name)
+				}
+			} else if o.format == "single-file" {
+				outPath := filepath.Join(base, fmt.Sprintf("%d.eml", uid))
+				if meta != nil {
+					// also adds sidecars to messages from runs without --metadata
+					if err := os.WriteFile(metaName(outPath), meta, 0o644); err != nil {
+						firstErr = err
+						continue
+					}
+				}
+				// resume: skip complete files; an empty or short one was
+				// cut off by a run that predates the rename below
+				if fi, err := os.Stat(outPath); err == nil {
+					if fi.Size() == int64(len(raw)) {
+						if o.verbose {
+							log.Printf("[%s] skip existing %s", box, outPath)
+						}
+						continue
+					}
+					log.Printf("[%s] %s has %d of %d bytes, downloading again", box, outPath, fi.Size(), len(raw))
+				}
+				if err := writeEml(outPath, raw); err != nil {
 					firstErr = err
 					continue
 				}
 				if o.verbose {
 					log.Printf("[%s] wrote %s", box, outPath)
 				}
+				if o.execPerMsg != "" {
+					if err := runMessageHook(ctx, o.execPerMsg, outPath, box, uid); err != nil {
+						fmt.Fprintf(os.Stderr, "[%s] %v\n", box, err)
+					}
+				}
+			} else if o.format == "tar" {
+				if tarWritten[uid] {
+					continue
+				}
+				date := msg.InternalDate
+				if date.IsZero() {
+					date = time.Now()
+				}
+				hdr := &tar.Header{
+					Name:    path.Join(relDir, fmt.Sprintf("%d.eml", uid)),
+					Mode:    0o644,
+					Size:    int64(len(raw)),
+					ModTime: date,
+				}
+				if err := sinks.tw.WriteHeader(hdr); err != nil {
+					firstErr = fmt.Errorf("write archive: %w", err)
+					continue
+				}
+				if _, err := sinks.tw.Write(raw); err != nil {
+					firstErr = fmt.Errorf("write archive: %w", err)
+					continue
+				}
+				tarWritten[uid] = true
+			} else if o.format == "sqlite" {
+				date := msg.InternalDate
+				if date.IsZero() {
+					date = time.Now()
+				}
+				added, err := sinks.store.Add(mailstore.Message{Mailbox: box, UID: uid, InternalDate: date, Raw: raw})
+				if err != nil {
+					firstErr = fmt.Errorf("store message: %w", err)
+					continue
+				}
+				if !added {
+					continue
+				}
+			} else if o.format == "maildir" {
+				date := msg.InternalDate
+				if date.IsZero() {
+					date = time.Now()
+				}
+				outPath, err := maildir.Deliver(folder, raw, msg.Flags, date, uid)
+				if err != nil {
+					firstErr = fmt.Errorf("write maildir: %w", err)
+					continue
+				}
+				if o.verbose {
+					log.Printf("[%s] wrote %s", box, outPath)
+				}
 			} else {
 				date := msg.InternalDate
 				if date.IsZero() {
 					date = time.Now()
 				}
-				if err := appendToMbox(mboxFile, raw, date); err != nil {
+				mf, err := openMbox(mboxPeriod(date, o.splitBy))
+				if err != nil {
+					firstErr = err
+					continue
+				}
+				flags := msg.Flags
+				if flags == nil {
+					flags = []string{} // unread: still written as "Status: O"
+				}
+				if err := appendToMbox(mf.w, raw, date, flags); err != nil {
 					firstErr = fmt.Errorf("append to mbox: %w", err)
 					continue
 				}
+				mf.n++
 			}
 			count++
 		}
@@ -1004,7 +2254,7 @@ func downloadMailbox(ctx context.Context, src **client.Client, box string, since
 			if err == nil {
 				break
 			}
-			if !isConnClosed(err) {
+			if !imaputil.ConnClosed(err) {
 				return err
 			}
 			if attempt >= maxReconnectAttempts {
@@ -1015,9 +2265,16 @@ func downloadMailbox(ctx context.Context, src **client.Client, box string, since
 			}
 		}
 	}
+	if err := finishMbox(); err != nil {
+		return fmt.Errorf("write mbox: %w", err)
+	}
 	if o.verbose {
 		if o.format == "mbox" {
-			log.Printf("[%s] appended %d messages to %s", box, count, mboxPath)
+			paths := make([]string, 0, len(mboxOrder))
+			for _, mf := range mboxOrder {
+				paths = append(paths, mf.path)
+			}
+			log.Printf("[%s] appended %d messages to %s", box, count, strings.Join(paths, ", "))
 		} else {
 			log.Printf("[%s] downloaded %d messages", box, count)
 		}
@@ -1025,6 +2282,18 @@ func downloadMailbox(ctx context.Context, src **client.Client, box string, since
 	return nil
 }
 
+// mboxPeriod returns the --split-by period of a message date: "2023" for
+// year, "2023-05" for month, or "" when output is not split.
+func mboxPeriod(date time.Time, splitBy string) string {
+	switch splitBy {
+	case "year":
+		return date.Format("2006")
+	case "month":
+		return date.Format("2006-01")
+	}
+	return ""
+}
+
 func mailboxPath(outputDir, mailbox string) string {
 	// Build a safe path under outputDir following mailbox hierarchy
 	parts := strings.Split(mailbox, "/")
@@ -1043,14 +2312,19 @@ func mailboxPath(outputDir, mailbox string) string {
 	return filepath.Join(safe...)
 }
 
-func appendToMbox(f *os.File, raw []byte, date time.Time) error {
+// appendToMbox writes raw mboxrd-quoted after a From_ line for date. Non-nil
+// flags are kept in Status/X-Status/X-Keywords headers.
+func appendToMbox(f io.Writer, raw []byte, date time.Time, flags []string) error {
 	// mboxrd style
 	if date.IsZero() {
 		date = time.Now()
 	}
+	if flags != nil {
+		raw = mboxutil.SetFlags(raw, flags)
+	}
 	// Standard mbox From_ line uses ctime format
 	fromLine := fmt.Sprintf("From MAILER-DAEMON %s\n", date.Format(time.ANSIC))
-	if _, err := f.WriteString(fromLine); err != nil {
+	if _, err := io.WriteString(f, fromLine); err != nil {
 		return err
 	}
 	// Escape any line beginning with 'From '
@@ -1059,11 +2333,11 @@ func appendToMbox(f *os.File, raw []byte, date time.Time) error {
 		line, err := br.ReadString('\n')
 		if len(line) > 0 {
 			if strings.HasPrefix(line, "From ") {
-				if _, werr := f.WriteString(">" + line); werr != nil {
+				if _, werr := io.WriteString(f, ">"+line); werr != nil {
 					return werr
 				}
 			} else {
-				if _, werr := f.WriteString(line); werr != nil {
+				if _, werr := io.WriteString(f, line); werr != nil {
 					return werr
 				}
 			}
@@ -1076,7 +2350,7 @@ func appendToMbox(f *os.File, raw []byte, date time.Time) error {
 		}
 	}
 	// Ensure trailing newline between messages
-	if _, err := f.WriteString("\n"); err != nil {
+	if _, err := io.WriteString(f, "\n"); err != nil {
 		return err
 	}
 	return nil
@@ -1095,10 +2369,16 @@ type sendOptions struct {
 	insecure       bool
 	from           string
 	to             []string
+	cc             []string
+	bcc            []string
+	aliases        string
 	subject        string
 	body           string
 	bodyFile       string
 	rawFile        string
+	attach         []string
+	identity       string
+	smtpURL        string
 }
 
 func addSendFlags(cmd *cobra.Command) {
@@ -1115,10 +2395,16 @@ func addSendFlags(cmd *cobra.Command) {
 	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
 	cmd.Flags().StringVar(&o.from, "from", "", "From email address")
 	cmd.Flags().StringArrayVar(&o.to, "to", nil, "Recipient email address (repeatable)")
+	cmd.Flags().StringArrayVar(&o.cc, "cc", nil, "Cc recipient email address (repeatable)")
+	cmd.Flags().StringArrayVar(&o.bcc, "bcc", nil, "Bcc recipient email address (repeatable, not shown in headers)")
+	cmd.Flags().StringVar(&o.aliases, "aliases", "", "Aliases file (mailx-style 'alias NAME ADDR...') expanded in --to/--cc/--bcc")
 	cmd.Flags().StringVar(&o.subject, "subject", "", "Email subject")
 	cmd.Flags().StringVar(&o.body, "body", "", "Email body (text/plain)")
 	cmd.Flags().StringVar(&o.bodyFile, "body-file", "", "Read body from file")
 	cmd.Flags().StringVar(&o.rawFile, "raw-file", "", "Send a raw RFC822 message from file (overrides other fields)")
+	cmd.Flags().StringArrayVar(&o.attach, "attach", nil, "Attach a file (repeatable; streamed from disk)")
+	cmd.Flags().StringVar(&o.identity, "identity", "", "Use this identity from the config (SMTP account, From, sent folder)")
+	cmd.Flags().StringVar(&o.smtpURL, "smtp", "", "SMTP server as a connection URL, e.g. smtps://user@host:465 or smtp+starttls://user@host:587 (instead of --smtp-host, --smtp-port, --smtp-user, --starttls and --ssl)")
 	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
 		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
 		return nil
@@ -1127,12 +2413,50 @@ func addSendFlags(cmd *cobra.Command) {
 
 func runSend(cmd *cobra.Command, args []string) error {
 	o := cmd.Context().Value(ctxKey{}).(*sendOptions)
+	fromHeader := o.from
+	var sentAcc accountConfig
+	var sentFolder string
+	if o.identity != "" {
+		id, imapAcc, smtpAcc, err := lookupIdentity(o.identity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "smtp", host: &o.smtpHost, port: &o.smtpPort, user: &o.smtpUser, pass: &o.smtpPass, insecure: &o.insecure}, smtpAcc)
+		if !cmd.Flags().Changed("ssl") && smtpAcc.SSL {
+			o.ssl = true
+		}
+		if o.from == "" && id.Email != "" {
+			o.from = id.Email
+			fromHeader = (&mail.Address{Name: id.Name, Address: id.Email}).String()
+		}
+		sentAcc, sentFolder = imapAcc, id.SentFolder
+	}
+	err := applyEndpoints(cmd, endpointFlag{loginTarget{prefix: "smtp", host: &o.smtpHost, port: &o.smtpPort, user: &o.smtpUser, pass: &o.smtpPass, startTLS: &o.startTLS, ssl: &o.ssl, insecure: &o.insecure}, "smtp", o.smtpURL})
+	if err != nil {
+		return err
+	}
 	if o.smtpHost == "" || o.smtpPort == 0 {
 		return fmt.Errorf("missing --smtp-host/--smtp-port")
 	}
 	if len(o.to) == 0 {
 		return fmt.Errorf("at least one --to is required")
 	}
+	aliases, err := loadAliases(o.aliases)
+	if err != nil {
+		return err
+	}
+	to, err := expandAliases(aliases, o.to)
+	if err != nil {
+		return err
+	}
+	cc, err := expandAliases(aliases, o.cc)
+	if err != nil {
+		return err
+	}
+	bcc, err := expandAliases(aliases, o.bcc)
+	if err != nil {
+		return err
+	}
 	if o.from == "" {
 		return fmt.Errorf("--from is required")
 	}
@@ -1145,14 +2469,25 @@ func runSend(cmd *cobra.Command, args []string) error {
 		}
 		o.smtpPass = string(b)
 	}
-	// Build message
-	var msg []byte
+	// Build message. Raw files and attachments are streamed into the DATA
+	// writer instead of being loaded into memory.
+	var msgSize int64
+	var writeMsg func(w io.Writer) error
 	if o.rawFile != "" {
-		b, err := os.ReadFile(o.rawFile)
+		fi, err := os.Stat(o.rawFile)
 		if err != nil {
 			return err
 		}
-		msg = b
+		msgSize = fi.Size()
+		writeMsg = func(w io.Writer) error {
+			f, err := os.Open(o.rawFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		}
 	} else {
 		var body string
 		if o.bodyFile != "" {
@@ -1164,27 +2499,110 @@ func runSend(cmd *cobra.Command, args []string) error {
 		} else {
 			body = o.body
 		}
+		atts, err := statAttachments(o.attach)
+		if err != nil {
+			return err
+		}
 		hdr := bytes.Buffer{}
-		hdr.WriteString(fmt.Sprintf("From: %s\r\n", o.from))
-		hdr.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(o.to, ", ")))
+		hdr.WriteString(fmt.Sprintf("From: %s\r\n", fromHeader))
+		hdr.WriteString(fmt.Sprintf("To: %s\r\n", addressList(to)))
+		if len(cc) > 0 {
+			hdr.WriteString(fmt.Sprintf("Cc: %s\r\n", addressList(cc)))
+		}
 		if o.subject != "" {
 			hdr.WriteString(fmt.Sprintf("Subject: %s\r\n", o.subject))
 		}
 		hdr.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
 		hdr.WriteString("MIME-Version: 1.0\r\n")
-		hdr.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
-		hdr.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
-		msg = append(hdr.Bytes(), []byte(body)...)
+		if len(atts) == 0 {
+			hdr.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+			hdr.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+			msg := append(hdr.Bytes(), []byte(body)...)
+			msgSize = int64(len(msg))
+			writeMsg = func(w io.Writer) error {
+				_, err := w.Write(msg)
+				return err
+			}
+		} else {
+			boundary := newBoundary()
+			hdr.WriteString(fmt.Sprintf("Content-Type: %s\r\n\r\n", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": boundary})))
+			// Compute the final size up front from file sizes only.
+			cw := &countingWriter{n: int64(hdr.Len())}
+			if err := writeMultipart(cw, boundary, body, atts, func(_ io.Writer, a attachment) error {
+				cw.n += base64EncodedSize(a.size)
+				return nil
+			}); err != nil {
+				return err
+			}
+			msgSize = cw.n
+			writeMsg = func(w io.Writer) error {
+				if _, err := w.Write(hdr.Bytes()); err != nil {
+					return err
+				}
+				return writeMultipart(w, boundary, body, atts, streamAttachment)
+			}
+		}
+	}
+
+	// Keep a copy of the sent message for the identity's sent folder
+	var sentCopy *os.File
+	if sentFolder != "" && sentAcc.Host != "" {
+		tmp, err := os.CreateTemp("", "gomap-sent-*.eml")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		sentCopy = tmp
+		inner := writeMsg
+		writeMsg = func(w io.Writer) error {
+			return inner(io.MultiWriter(w, tmp))
+		}
+	}
+
+	var rcpts []string
+	for _, a := range append(append(append([]*mail.Address{}, to...), cc...), bcc...) {
+		rcpts = append(rcpts, a.Address)
+	}
+	srv := smtpServer{host: o.smtpHost, port: o.smtpPort, user: o.smtpUser, pass: o.smtpPass, startTLS: o.startTLS, ssl: o.ssl, insecure: o.insecure}
+	err = perform(fmt.Sprintf("send %d bytes from %s via %s to %s", msgSize, o.from, o.smtpHost, strings.Join(rcpts, ", ")), func() error {
+		return srv.send(o.from, rcpts, msgSize, writeMsg)
+	})
+	if err != nil {
+		return err
+	}
+	if sentCopy != nil {
+		err := perform(fmt.Sprintf("append a copy to %s on %s", sentFolder, sentAcc.Host), func() error {
+			return saveSentCopy(cmd.Context(), sentAcc, sentFolder, sentCopy)
+		})
+		if err != nil {
+			return fmt.Errorf("message sent, but saving to %s failed: %w", sentFolder, err)
+		}
 	}
+	return nil
+}
+
+// smtpServer holds the connection settings for an SMTP submission.
+type smtpServer struct {
+	host     string
+	port     int
+	user     string
+	pass     string
+	startTLS bool // use STARTTLS on plain connection (e.g., 587)
+	ssl      bool // implicit TLS (e.g., 465)
+	insecure bool
+}
 
-	addr := fmt.Sprintf("%s:%d", o.smtpHost, o.smtpPort)
-	tlsCfg := &tls.Config{ServerName: o.smtpHost, InsecureSkipVerify: o.insecure}
+// send submits one message of msgSize bytes, written by writeMsg, to rcpts.
+func (s smtpServer) send(from string, rcpts []string, msgSize int64, writeMsg func(w io.Writer) error) error {
+	addr := fmt.Sprintf("%s:%d", s.host, s.port)
+	tlsCfg := &tls.Config{ServerName: s.host, InsecureSkipVerify: s.insecure}
 
 	// Helper to perform SMTP transaction using a client
 	sendWithClient := func(c *smtp.Client) error {
 		defer c.Close()
 		// STARTTLS if requested and supported
-		if !o.ssl && o.startTLS {
+		if !s.ssl && s.startTLS {
 			if ok, _ := c.Extension("STARTTLS"); ok {
 				if err := c.StartTLS(tlsCfg); err != nil {
 					return err
@@ -1192,16 +2610,22 @@ func runSend(cmd *cobra.Command, args []string) error {
 			}
 		}
 		// Auth if provided
-		if o.smtpUser != "" {
-			auth := smtp.PlainAuth("", o.smtpUser, o.smtpPass, o.smtpHost)
+		if s.user != "" {
+			auth := smtp.PlainAuth("", s.user, s.pass, s.host)
 			if err := c.Auth(auth); err != nil {
 				return err
 			}
 		}
-		if err := c.Mail(o.from); err != nil {
+		// Reject early if the server advertises a smaller SIZE limit
+		if ok, param := c.Extension("SIZE"); ok && param != "" {
+			if max, err := strconv.ParseInt(param, 10, 64); err == nil && max > 0 && msgSize > max {
+				return fmt.Errorf("message size %d bytes exceeds server limit of %d bytes", msgSize, max)
+			}
+		}
+		if err := c.Mail(from); err != nil {
 			return err
 		}
-		for _, rcpt := range o.to {
+		for _, rcpt := range rcpts {
 			if err := c.Rcpt(rcpt); err != nil {
 				return err
 			}
@@ -1210,7 +2634,7 @@ func runSend(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
-		if _, err := wc.Write(msg); err != nil {
+		if err := writeMsg(wc); err != nil {
 			_ = wc.Close()
 			return err
 		}
@@ -1220,13 +2644,13 @@ func runSend(cmd *cobra.Command, args []string) error {
 		return c.Quit()
 	}
 
-	if o.ssl {
+	if s.ssl {
 		// Implicit TLS
 		conn, err := tls.Dial("tcp", addr, tlsCfg)
 		if err != nil {
 			return err
 		}
-		c, err := smtp.NewClient(conn, o.smtpHost)
+		c, err := smtp.NewClient(conn, s.host)
 		if err != nil {
 			return err
 		}
@@ -1240,389 +2664,913 @@ func runSend(cmd *cobra.Command, args []string) error {
 	return sendWithClient(c)
 }
 
-func runCopyIMAP(cmd *cobra.Command, o *copyOptions) error {
-	var includeRe, excludeRe *regexp.Regexp
-	var err error
-	if o.include != "" {
-		includeRe, err = regexp.Compile(o.include)
-		if err != nil {
-			return fmt.Errorf("invalid --include regex: %w", err)
+// fileLiteral adapts a file to imap.Literal.
+type fileLiteral struct {
+	*os.File
+	size int
+}
+
+func (l fileLiteral) Len() int { return l.size }
+
+// saveSentCopy appends the message in f to the given folder as \Seen.
+func saveSentCopy(ctx context.Context, acc accountConfig, folder string, f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	port := acc.Port
+	if port == 0 {
+		port = 993
+	}
+	c, err := imaputil.DialAndLogin(ctx, acc.Host, port, acc.User, acc.password(), acc.StartTLS, &tls.Config{InsecureSkipVerify: acc.Insecure})
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	if err := imaputil.EnsureMailbox(c, folder); err != nil {
+		return err
+	}
+	return c.Append(folder, []string{imap.SeenFlag}, time.Now(), fileLiteral{File: f, size: int(fi.Size())})
+}
+
+// copyMailboxFilter returns a function that reports whether a source
+// mailbox is copied, according to --include, --exclude and the --skip-*
+// options.
+// Names of special folders as common servers and clients create them.
+const (
+	trashFolders  = `(?i)^(Trash|Gelöscht.*|Deleted Items|Papierkorb)$`
+	junkFolders   = `(?i)^(Junk|Spam|Bulk Mail|Unerw.*)$`
+	draftsFolders = `(?i)^(Drafts|Entwürfe)$`
+	sentFolders   = `(?i)^(Sent( Items)?|Gesendet.*)$`
+)
+
+var (
+	trashFolderRe = regexp.MustCompile(trashFolders)
+	junkFolderRe  = regexp.MustCompile(junkFolders)
+)
+
+// folderMap returns the --map mappings for the source mailboxes boxes, plus
+// --trash-as/--junk-as for the Trash and Junk folders among them and the
+// --src-prefix/--dst-prefix names of the others. An explicit --map entry
+// wins.
+func (o *copyOptions) folderMap(boxes []string) map[string]string {
+	m := parseMappings(o.mapPairs)
+	for _, b := range boxes {
+		if _, ok := m[b]; ok {
+			continue
+		}
+		if o.trashAs != "" && trashFolderRe.MatchString(b) {
+			m[b] = o.trashAs
+		} else if o.junkAs != "" && junkFolderRe.MatchString(b) {
+			m[b] = o.junkAs
+		} else if to := o.prefixName(b); to != b {
+			m[b] = to
 		}
 	}
-	if o.exclude != "" {
-		excludeRe, err = regexp.Compile(o.exclude)
-		if err != nil {
-			return fmt.Errorf("invalid --exclude regex: %w", err)
+	if o.flatten {
+		from := map[string]string{}
+		for _, b := range boxes {
+			to := b
+			if mapped, ok := m[b]; ok && mapped != "" {
+				to = mapped
+			}
+			flat := o.flatName(to)
+			if other, ok := from[flat]; ok {
+				log.Printf("[mailbox] %s and %s are both copied into %q with --flatten", other, b, flat)
+			}
+			from[flat] = b
+			if flat != to {
+				m[b] = flat
+			}
 		}
 	}
+	return m
+}
 
-	var sinceTime time.Time
-	if o.since != "" {
-		sinceTime, err = time.Parse("2006-01-02", o.since)
-		if err != nil {
-			return fmt.Errorf("invalid --since date: %w (expected YYYY-MM-DD)", err)
+// flatName joins the hierarchy levels of the destination name with
+// --flatten-separator when --flatten is set. Both delimiters separate
+// levels: the source's, which the name came with, and the destination's,
+// which would create a hierarchy otherwise.
+func (o *copyOptions) flatName(name string) string {
+	if !o.flatten {
+		return name
+	}
+	for _, delim := range []string{cmp.Or(o.srcDelim, "/"), o.dstDelim} {
+		if delim != "" {
+			name = strings.ReplaceAll(name, delim, o.flattenSep)
 		}
-	} else {
-		sinceTime = time.Unix(0, 0).UTC()
 	}
+	return name
+}
 
-	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
-	ctx := cmd.Context()
-
-	st, err := state.Load(o.stateFile)
-	if err != nil {
-		return fmt.Errorf("load state: %w", err)
+// prefixPath returns prefix, written with "/" between its levels, in the
+// hierarchy delimiter delim ("/" if unknown).
+func prefixPath(prefix, delim string) string {
+	if delim == "" {
+		delim = "/"
 	}
+	prefix = strings.Trim(prefix, "/")
+	prefix = strings.TrimSuffix(prefix, delim)
+	return strings.ReplaceAll(prefix, "/", delim)
+}
 
-	src, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, tlsConfig)
-	if err != nil {
-		return fmt.Errorf("connect source: %w", err)
+// prefixName moves the source mailbox name from below --src-prefix to
+// below --dst-prefix. Names outside --src-prefix keep their place.
+func (o *copyOptions) prefixName(name string) string {
+	if o.srcPrefix != "" {
+		delim := cmp.Or(o.srcDelim, "/")
+		if rest, ok := strings.CutPrefix(name, prefixPath(o.srcPrefix, delim)+delim); ok && rest != "" {
+			name = rest
+		}
 	}
-	defer src.Logout()
-
-	dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
-	if err != nil {
-		return fmt.Errorf("connect destination: %w", err)
+	if o.dstPrefix != "" {
+		name = prefixPath(o.dstPrefix, o.dstDelim) + cmp.Or(o.dstDelim, "/") + name
 	}
-	defer dst.Logout()
+	return name
+}
 
-	boxes, err := imaputil.ListMailboxes(ctx, src)
-	if err != nil {
-		return fmt.Errorf("list mailboxes: %w", err)
+// unprefixName is the reverse of prefixName for a destination mailbox. ok
+// is false for mailboxes outside --dst-prefix.
+func (o *copyOptions) unprefixName(name string) (string, bool) {
+	if o.dstPrefix != "" {
+		rest, found := strings.CutPrefix(name, prefixPath(o.dstPrefix, o.dstDelim)+cmp.Or(o.dstDelim, "/"))
+		if !found || rest == "" {
+			return name, false
+		}
+		name = rest
+	}
+	if o.srcPrefix != "" && !strings.EqualFold(name, "INBOX") {
+		name = prefixPath(o.srcPrefix, o.srcDelim) + cmp.Or(o.srcDelim, "/") + name
 	}
+	return name, true
+}
 
-	specialPatterns := []string{}
-	if o.skipSpecial || o.skipTrash {
-		specialPatterns = append(specialPatterns, `(?i)^(Trash|Gelöscht.*|Deleted Items|Papierkorb)$`)
+// loadDelimiters looks up the hierarchy delimiters of the source and the
+// destination (nil for LMTP) for --src-prefix, --dst-prefix and --flatten.
+func (o *copyOptions) loadDelimiters(src, dst *client.Client) error {
+	var err error
+	if (o.srcPrefix != "" || o.flatten) && o.srcDelim == "" {
+		if o.srcDelim, err = imaputil.Delimiter(src); err != nil {
+			return fmt.Errorf("list source hierarchy delimiter: %w", err)
+		}
 	}
-	if o.skipSpecial || o.skipJunk {
-		specialPatterns = append(specialPatterns, `(?i)^(Junk|Spam|Bulk Mail|Unerw.*)$`)
+	if (o.dstPrefix != "" || o.flatten) && o.dstDelim == "" && dst != nil {
+		if o.dstDelim, err = imaputil.Delimiter(dst); err != nil {
+			return fmt.Errorf("list destination hierarchy delimiter: %w", err)
+		}
 	}
-	if o.skipSpecial || o.skipDrafts {
-		specialPatterns = append(specialPatterns, `(?i)^(Drafts|Entwürfe)$`)
+	return nil
+}
+
+// nameLimits returns the --max-folder-length and --max-folder-depth caps.
+func (o *copyOptions) nameLimits() imaputil.NameLimits {
+	return imaputil.NameLimits{MaxLength: o.maxFolderLen, MaxDepth: o.maxFolderDepth}
+}
+
+// connPools returns the connection pools the mailboxes of a copy are
+// copied on, sized by --src-max-conns and --dst-max-conns. They start with
+// src and dst; without dst (--dst-lmtp) there is no destination pool.
+func (o *copyOptions) connPools(src, dst *client.Client, tlsConfig *tls.Config) (srcPool, dstPool *imaputil.Pool) {
+	size := max(o.concurrency, 1) * max(o.connections, 1)
+	srcMax, dstMax := o.srcMaxConns, o.dstMaxConns
+	if srcMax <= 0 {
+		srcMax = size
 	}
-	if o.skipSpecial || o.skipSent {
-		specialPatterns = append(specialPatterns, `(?i)^(Sent( Items)?|Gesendet.*)$`)
+	if dstMax <= 0 {
+		dstMax = size
 	}
-	var specialRe *regexp.Regexp
-	if len(specialPatterns) > 0 {
-		specialRe = regexp.MustCompile(strings.Join(specialPatterns, "|"))
+	srcPool = imaputil.NewPool(src, srcMax, func(ctx context.Context) (*client.Client, error) {
+		return imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, tlsConfig)
+	})
+	if dst != nil {
+		dstPool = imaputil.NewPool(dst, dstMax, func(ctx context.Context) (*client.Client, error) {
+			return imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+		})
 	}
+	return srcPool, dstPool
+}
 
-	filtered := make([]string, 0, len(boxes))
+// flagFilter returns the --only-unseen, --only-flagged and --skip-deleted
+// search restrictions.
+func (o *copyOptions) flagFilter() imaputil.FlagFilter {
+	return imaputil.FlagFilter{OnlyUnseen: o.onlyUnseen, OnlyFlagged: o.onlyFlagged, SkipDeleted: o.skipDeleted}
+}
+
+// subscriptions returns which source mailboxes get their destination
+// folder subscribed: those subscribed on src plus INBOX, or all of them
+// if src has no subscriptions or cannot list them. With --no-subscribe it
+// returns nil.
+func (o *copyOptions) subscriptions(src *client.Client) func(name string) bool {
+	if o.noSubscribe {
+		return nil
+	}
+	subs, err := imaputil.Subscribed(src)
+	if err != nil {
+		log.Printf("[mailbox] cannot list source subscriptions, subscribing every copied folder: %v", err)
+	}
+	if len(subs) == 0 {
+		return func(string) bool { return true }
+	}
+	return func(name string) bool { return subs[name] || strings.EqualFold(name, "INBOX") }
+}
+
+// copyACLs reports whether to copy ACLs: with --sync-acl, when both
+// servers support the ACL extension.
+func (o *copyOptions) copyACLs(src, dst *client.Client) bool {
+	if !o.syncACL {
+		return false
+	}
+	switch {
+	case !imaputil.HasACL(src):
+		log.Printf("[acl] not copying ACLs: the source server lacks the ACL extension")
+	case !imaputil.HasACL(dst):
+		log.Printf("[acl] not copying ACLs: the destination server lacks the ACL extension")
+	default:
+		return true
+	}
+	return false
+}
+
+// ensureMailbox makes sure mailbox exists on dst and, unless
+// --no-subscribe, subscribes it, for sources without subscriptions of
+// their own. A refused SUBSCRIBE is only logged.
+func (o *copyOptions) ensureMailbox(dst *client.Client, mailbox string) error {
+	if err := ensureMailbox(dst, mailbox); err != nil || dryRun {
+		return err
+	}
+	if !o.noSubscribe {
+		if err := dst.Subscribe(mailbox); err != nil {
+			log.Printf("[mailbox] %s: subscribe: %v", mailbox, err)
+		}
+	}
+	return nil
+}
+
+// fitFolderMap shortens the destinations of boxes in m (missing entries
+// keep the source name) to the name limits and records the shortened ones
+// in the run summary.
+func (o *copyOptions) fitFolderMap(m map[string]string, boxes []string, delim string, s *runlog.Summary) {
+	l := o.nameLimits()
+	if l == (imaputil.NameLimits{}) {
+		return
+	}
 	for _, b := range boxes {
-		name := b
-		if includeRe != nil && !includeRe.MatchString(name) {
-			continue
+		to := b
+		if mapped, ok := m[b]; ok && mapped != "" {
+			to = mapped
 		}
-		if excludeRe != nil && excludeRe.MatchString(name) {
-			continue
+		if fitted := l.Fit(to, delim); fitted != to {
+			m[b] = fitted
+			s.Renamed(b, fitted)
+			if o.verbose {
+				log.Printf("[mailbox] %s: destination %q shortened to %q", b, to, fitted)
+			}
+		}
+	}
+}
+
+func copyMailboxFilter(o *copyOptions) (func(name string) bool, error) {
+	if o.onlyMailbox != "" {
+		return func(name string) bool {
+			return name == o.onlyMailbox || strings.EqualFold(name, "INBOX") && strings.EqualFold(o.onlyMailbox, "INBOX")
+		}, nil
+	}
+	var listed map[string]bool
+	var err error
+	if o.boxesFile != "" {
+		if o.include != "" || o.exclude != "" {
+			return nil, errors.New("--mailboxes-file cannot be combined with --include or --exclude")
+		}
+		if listed, err = readMailboxesFile(o.boxesFile); err != nil {
+			return nil, err
+		}
+	}
+	includeRe, excludeRe, err := mailboxFilters(o.include, o.exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	specialPatterns := []string{}
+	if (o.skipSpecial || o.skipTrash) && o.trashAs == "" {
+		specialPatterns = append(specialPatterns, trashFolders)
+	}
+	if (o.skipSpecial || o.skipJunk) && o.junkAs == "" {
+		specialPatterns = append(specialPatterns, junkFolders)
+	}
+	if o.skipSpecial || o.skipDrafts {
+		specialPatterns = append(specialPatterns, draftsFolders)
+	}
+	if o.skipSpecial || o.skipSent {
+		specialPatterns = append(specialPatterns, sentFolders)
+	}
+	var specialRe *regexp.Regexp
+	if len(specialPatterns) > 0 {
+		specialRe = regexp.MustCompile(strings.Join(specialPatterns, "|"))
+	}
+
+	return func(name string) bool {
+		if listed != nil && !listedMailbox(listed, name) {
+			return false
+		}
+		if includeRe != nil && !matchMailbox(includeRe, name) {
+			return false
+		}
+		if excludeRe != nil && matchMailbox(excludeRe, name) {
+			return false
 		}
 		if specialRe != nil && specialRe.MatchString(name) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+// parseUIDSelection parses --uid and --uid-range into one set, nil if both
+// are empty.
+func parseUIDSelection(uids []string, uidRange string) (*imap.SeqSet, error) {
+	if len(uids) == 0 && uidRange == "" {
+		return nil, nil
+	}
+	set := new(imap.SeqSet)
+	for _, v := range uids {
+		uid, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32)
+		if err != nil || uid == 0 {
+			return nil, fmt.Errorf("invalid --uid: %s", v)
+		}
+		set.AddNum(uint32(uid))
+	}
+	if uidRange != "" {
+		lo, hi, ok := strings.Cut(uidRange, ":")
+		start, err := strconv.ParseUint(lo, 10, 32)
+		if !ok || err != nil || start == 0 {
+			return nil, fmt.Errorf("invalid --uid-range: %s (expected FROM:TO or FROM:*)", uidRange)
+		}
+		if hi == "*" {
+			set.AddRange(uint32(start), 0)
+		} else if stop, err := strconv.ParseUint(hi, 10, 32); err == nil && stop >= start {
+			set.AddRange(uint32(start), uint32(stop))
+		} else {
+			return nil, fmt.Errorf("invalid --uid-range: %s (expected FROM:TO or FROM:*)", uidRange)
+		}
+	}
+	return set, nil
+}
+
+// readMailboxesFile reads a --mailboxes-file: one mailbox name per line,
+// readable or in modified UTF-7. Blank lines and lines starting with #
+// are skipped; INBOX is matched in any case.
+func readMailboxesFile(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read --mailboxes-file: %w", err)
+	}
+	listed := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" || strings.HasPrefix(name, "#") {
 			continue
 		}
-		filtered = append(filtered, name)
+		if strings.EqualFold(name, "INBOX") {
+			name = "INBOX"
+		}
+		listed[imaputil.DecodeMailboxName(name)] = true
+	}
+	if len(listed) == 0 {
+		return nil, fmt.Errorf("--mailboxes-file %s lists no mailboxes", path)
+	}
+	return listed, nil
+}
+
+// listedMailbox reports whether the source mailbox name is in listed.
+func listedMailbox(listed map[string]bool, name string) bool {
+	if strings.EqualFold(name, "INBOX") {
+		name = "INBOX"
+	}
+	return listed[name]
+}
+
+// warnUnlisted logs the mailboxes of --mailboxes-file that are not among
+// the source mailboxes boxes, likely typos or folders renamed since the
+// file was written.
+func (o *copyOptions) warnUnlisted(boxes []string) {
+	if o.boxesFile == "" {
+		return
+	}
+	listed, err := readMailboxesFile(o.boxesFile)
+	if err != nil {
+		return
+	}
+	for _, b := range boxes {
+		delete(listed, b)
+		if strings.EqualFold(b, "INBOX") {
+			delete(listed, "INBOX")
+		}
+	}
+	names := make([]string, 0, len(listed))
+	for name := range listed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("[mailbox] %s: in --mailboxes-file but not on the source", name)
+	}
+}
+
+func runCopyIMAP(cmd *cobra.Command, o *copyOptions) error {
+	keep, err := copyMailboxFilter(o)
+	if err != nil {
+		return err
+	}
+
+	var sinceTime time.Time
+	if o.since != "" {
+		sinceTime, err = time.Parse("2006-01-02", o.since)
+		if err != nil {
+			return fmt.Errorf("invalid --since date: %w (expected YYYY-MM-DD)", err)
+		}
+	} else {
+		sinceTime = time.Unix(0, 0).UTC()
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	ctx := cmd.Context()
+
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+
+	src, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("connect source: %w", err)
+	}
+	defer src.Logout()
+
+	var dst *client.Client
+	var deliver func(ctx context.Context, mailbox string, raw []byte) error
+	if o.dstLMTP != "" {
+		d := newLMTPDeliverer(o)
+		defer d.Close()
+		deliver = d.Deliver
+	} else {
+		dst, err = imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+		if err != nil {
+			return fmt.Errorf("connect destination: %w", err)
+		}
+		defer dst.Logout()
+	}
+
+	srcQuirks := serverQuirks(src, o.srcHost)
+	var dstQuirks quirks.Profile
+	if dst != nil {
+		dstQuirks = serverQuirks(dst, o.dstHost)
+	}
+	keep = o.applyQuirks(keep, srcQuirks, dstQuirks)
+
+	boxes, err := imaputil.ListMailboxes(ctx, src)
+	if err != nil {
+		return fmt.Errorf("list mailboxes: %w", err)
+	}
+
+	o.warnUnlisted(boxes)
+	filtered := make([]string, 0, len(boxes))
+	for _, b := range boxes {
+		if keep(b) {
+			filtered = append(filtered, b)
+		}
+	}
+	if len(filtered) == 0 && o.onlyMailbox != "" {
+		return fmt.Errorf("mailbox %s not found on the source", o.onlyMailbox)
 	}
 	if len(filtered) == 0 {
 		fmt.Println("No mailboxes to process.")
 		return nil
 	}
+	if dst != nil {
+		o.warnQuota(src, dst, filtered, st)
+	}
 
-	folderMap := parseMappings(o.mapPairs)
+	if err := o.loadDelimiters(src, dst); err != nil {
+		return err
+	}
+	folderMap := o.quirkFolderMap(o.folderMap(filtered), filtered, srcQuirks, dstQuirks)
+	summary := o.newRunSummary()
+	var delim string
+	if dst != nil {
+		if delim, err = imaputil.Delimiter(dst); err != nil {
+			return fmt.Errorf("list hierarchy delimiter: %w", err)
+		}
+		o.fitFolderMap(folderMap, filtered, delim, summary)
+	}
+	var skip func(mailbox string, env *imap.Envelope) bool
+	if o.dedup != "" {
+		for _, b := range filtered {
+			to := b
+			if m, ok := folderMap[b]; ok && m != "" {
+				to = m
+			}
+			if err := o.indexDedup(dst, st, to); err != nil {
+				return err
+			}
+		}
+		_ = o.saveState(st)
+		skip = func(mailbox string, env *imap.Envelope) bool {
+			return dedupSeen(o, st, mailbox, envelopeDedupKey(o.dedup, env))
+		}
+	}
+	srcPool, dstPool := o.connPools(src, dst, tlsConfig)
+	defer srcPool.Close()
+	defer dstPool.Close()
+	oversized := &oversizedList{}
+	plan := o.newPlanRecorder()
+	copied := &copiedUIDs{}
 	worker := syncer.NewMailboxSyncer(src, dst, st, syncer.Options{
-		DryRun:      o.dryRun,
-		Since:       sinceTime,
-		Concurrency: o.concurrency,
-		Quiet:       !o.verbose,
-		Map:         folderMap,
-		IgnoreState: o.ignoreState,
+		DryRun:         dryRun,
+		Since:          sinceTime,
+		Before:         o.beforeTime,
+		Concurrency:    o.concurrency,
+		Quiet:          !o.verbose,
+		Map:            folderMap,
+		IgnoreState:    o.ignoreState,
+		SplitThreshold: o.splitAt,
+		SplitMonthly:   o.splitBy == "month",
+		LargeMessage:   int64(o.largeMsg) << 20,
+		MaxSize:        o.maxBytes,
+		Oversized: func(mailbox string, uid uint32, size int64) {
+			summary.Skipped(mailbox, uid)
+			oversized.add(mailbox, uid, size)
+		},
+		Delimiter:        delim,
+		NameLimits:       o.nameLimits(),
+		Renamed:          summary.Renamed,
+		Pacer:            o.pacer(),
+		AppendBatch:      o.appendBatch,
+		AppendBatchBytes: o.appendBatchBytes,
+		Verify:           o.verifyLevel,
+		FlagMap:          o.flagMap,
+		Subscribe:        o.subscriptions(src),
+		ACL:              o.copyACLs(src, dst),
+		ACLOwners:        []string{o.srcUser, o.dstUser},
+		Deliver:          deliver,
+		Headers:          o.headers,
+		FetchRFC822:      srcQuirks.FetchRFC822,
+		Skip:             skip,
+		Limit:            o.limit,
+		NewestFirst:      o.newestFirst,
+		UIDs:             o.uidSet,
+		Connections:      o.connections,
+		Reconnects:       o.reconnects,
+		SrcPool:          srcPool,
+		DstPool:          dstPool,
+		Flags:            o.flagFilter(),
+		FilterFields:     o.filters.fields(),
+		Filter:           o.filters.matcher(),
+		Failed:           o.failed(summary),
+		Selected:         summary.Selected,
+		Planned:          plan.planned(),
+		Copied: func(mailbox string, uid uint32) {
+			summary.Copied(mailbox)
+			if o.expungeSource {
+				copied.add(mailbox, uid)
+			}
+		},
+		Checkpoint: func() {
+			_ = o.saveState(st)
+		},
 	})
 
 	if o.verbose {
+		printQuirks("Source", srcQuirks)
+		printQuirks("Destination", dstQuirks)
 		resumeBoxes := 0
 		for _, b := range filtered {
 			if st.GetMaxUID(b) > 0 {
 				resumeBoxes++
 			}
 		}
-		fmt.Printf("Starting sync: %d mailbox(es), concurrency=%d, dry-run=%v\n", len(filtered), o.concurrency, o.dryRun)
+		fmt.Printf("Starting sync: %d mailbox(es), concurrency=%d, dry-run=%v\n", len(filtered), o.concurrency, dryRun)
 		fmt.Printf("  since=%s  ignore-state=%v  state-file=%s\n", sinceTime.Format("2006-01-02"), o.ignoreState, o.stateFile)
+		if !o.beforeTime.IsZero() {
+			fmt.Printf("  before=%s\n", o.beforeTime.Format("2006-01-02"))
+		}
+		if dst != nil && o.appendBatch > 1 {
+			if ok, _ := dst.Support("MULTIAPPEND"); ok {
+				fmt.Printf("  appends: up to %d messages per APPEND (MULTIAPPEND)\n", o.appendBatch)
+			} else {
+				fmt.Println("  appends: one message per APPEND (destination lacks MULTIAPPEND)")
+			}
+		}
 		fmt.Printf("  resume status: %d/%d mailbox(es) have prior progress\n", resumeBoxes, len(filtered))
 		if !o.ignoreState && resumeBoxes > 0 {
 			fmt.Println("  tip: use --ignore-state or a fresh --state-file to process everything again")
 		}
 	}
 
+	var notice func() string
+	if o.verbose {
+		notice = updateNotice(ctx)
+	}
 	errs := runTUI(ctx, worker, filtered)
+	o.saveRunSummary(ctx, summary, errs)
+	if notice != nil {
+		if n := notice(); n != "" {
+			fmt.Println(n)
+		}
+	}
+	oversized.print()
+	o.writeFailures(summary)
+	if plan != nil {
+		if err := plan.write(o.planReport, filtered, folderMap, summary); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote the plan to %s\n", o.planReport)
+	}
 	if len(errs) > 0 {
 		fmt.Println("Finished with errors:")
 		for _, e := range errs {
 			fmt.Println(" -", e)
 		}
 	}
-	if err := st.Save(o.stateFile); err != nil {
+	if err := o.saveState(st); err != nil {
 		return fmt.Errorf("save state: %w", err)
 	}
+	if o.expungeSource {
+		if len(errs) > 0 || ctx.Err() != nil {
+			fmt.Println("Not expunging the source: the copy did not complete.")
+			return nil
+		}
+		return o.expungeSourceBoxes(src, filtered, copied, st)
+	}
+	if o.follow {
+		return o.followSource(ctx, keep)
+	}
 	return nil
 }
 
 func runCopyMBOX(cmd *cobra.Command, o *copyOptions) error {
-	// Open mbox
-	f, err := os.Open(o.mboxPath)
+	format, err := mboxutil.ParseFormat(o.mboxFormat)
 	if err != nil {
-		return fmt.Errorf("open mbox: %w", err)
+		return fmt.Errorf("invalid --mbox-format: %w", err)
 	}
-	defer f.Close()
-
 	// Load state to support resume by byte offset
 	st, err := state.Load(o.stateFile)
 	if err != nil {
 		return fmt.Errorf("load state: %w", err)
 	}
-
-	absPath, _ := filepath.Abs(o.mboxPath)
-	stateKey := fmt.Sprintf("mbox:%s|dst:%s", absPath, o.dstMbox)
-	var startOffset int64
-	// If importing only messages missing Date or with unparseable Date, scan the whole file;
-	// ignore resume state to avoid skipping earlier matches.
-	if !o.ignoreState && !o.mboxOnlyMissingDate && !o.mboxOnlyUnparseableDate {
-		startOffset = st.GetMboxOffset(stateKey)
+	sources, err := resolveMboxSources(o.mboxPath, o.dstMbox, cmd.Flags().Changed("dst-mailbox"), o.mboxMergeSplit, parseMappings(o.mapPairs))
+	if err != nil {
+		return fmt.Errorf("open mbox: %w", err)
 	}
-	if startOffset > 0 {
-		if _, err := f.Seek(startOffset, io.SeekStart); err != nil {
-			return fmt.Errorf("seek mbox to offset %d: %w", startOffset, err)
-		}
+	for i := range sources {
+		sources[i].mailbox = o.flatName(sources[i].mailbox)
 	}
 
 	// Count messages for progress
 	var total int
-	if o.mboxOnlyMissingDate || o.mboxOnlyUnparseableDate {
-		// Count only messages that match the selection
-		total, err = countMboxSelected(f, o.mboxOnlyMissingDate, o.mboxOnlyUnparseableDate)
+	for i, src := range sources {
+		if sources[i].format, err = mboxFileFormat(format, src.path); err != nil {
+			return fmt.Errorf("open mbox: %w", err)
+		}
+		if format == mboxutil.FormatAuto && o.verbose {
+			log.Printf("[mbox] %s: detected %s", src.path, sources[i].format)
+		}
+		f, err := mboxutil.Open(src.path, mboxStartOffset(o, st, src))
 		if err != nil {
-			return err
+			return fmt.Errorf("open mbox: %w", err)
 		}
-	} else {
-		// Count remaining messages quickly from current position
-		total, err = countMboxMessages(f)
+		var n int
+		if o.mboxOnlyMissingDate || o.mboxOnlyUnparseableDate {
+			// Count only messages that match the selection
+			n, err = countMboxSelected(o.mboxReader(f, sources[i].format), o.mboxOnlyMissingDate, o.mboxOnlyUnparseableDate)
+		} else {
+			// Count remaining messages quickly from current position
+			n, err = countMboxMessages(o.mboxReader(f, sources[i].format))
+		}
+		f.Close()
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", src.path, err)
 		}
+		total += n
 	}
-	// reset file
-	if _, err := f.Seek(startOffset, io.SeekStart); err != nil {
-		return err
+	if len(sources) > 1 && o.verbose {
+		for _, src := range sources {
+			log.Printf("[mbox] %s -> %s", src.path, src.mailbox)
+		}
 	}
 
 	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
 	ctx := cmd.Context()
-	dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
-	if err != nil {
-		return fmt.Errorf("connect destination: %w", err)
-	}
-	defer dst.Logout()
+	appendPacer := o.pacer()
+	var appendMsg func(mailbox string, raw []byte, flags []string, date time.Time) error
+	if o.dstLMTP != "" {
+		d := newLMTPDeliverer(o)
+		defer d.Close()
+		appendMsg = func(mailbox string, raw []byte, flags []string, date time.Time) error {
+			return appendPacer.Do(ctx, func() error { return d.Deliver(ctx, mailbox, bytes.Join(o.messageParts(raw), nil)) })
+		}
+		appendMsg = dryRunAppend(o, appendMsg)
+	} else {
+		dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+		if err != nil {
+			return fmt.Errorf("connect destination: %w", err)
+		}
+		defer dst.Logout()
 
-	// Ensure destination mailbox exists
-	if err := imaputil.EnsureMailbox(dst, o.dstMbox); err != nil {
-		return fmt.Errorf("ensure mailbox: %w", err)
+		// Ensure destination mailboxes exist
+		for _, src := range sources {
+			if err := o.ensureMailbox(dst, src.mailbox); err != nil {
+				return fmt.Errorf("ensure mailbox %s: %w", src.mailbox, err)
+			}
+		}
+		appendMsg = func(mailbox string, raw []byte, flags []string, date time.Time) error {
+			if _, err := imaputil.SelectMailbox(dst, mailbox, false); err != nil {
+				return err
+			}
+			return appendPacer.Do(ctx, func() error {
+				return imaputil.Append(dst, mailbox, o.flagMap.Apply(flags), date, o.messageParts(raw)...)
+			})
+		}
+		appendMsg = dryRunAppend(o, appendMsg)
+		if o.dedup != "" {
+			for _, src := range sources {
+				if err := o.indexDedup(dst, st, src.mailbox); err != nil {
+					return err
+				}
+			}
+			_ = o.saveState(st)
+			appendMsg = dedupAppend(o, st, appendMsg)
+		}
 	}
+	appendMsg = o.filters.filterAppend(appendMsg)
 
+	skipped := &mboxSkips{}
 	progress := make(chan int, 128)
 	errc := make(chan error, 1)
 
 	go func() {
 		defer close(progress)
 		defer close(errc)
-		r := mbox.NewReader(f)
-		for {
-			curPos, _ := f.Seek(0, io.SeekCurrent)
-			mr, err := r.NextMessage()
-			if err == io.EOF {
-				// reached end, save final offset
-				if !o.dryRun {
-					endPos, _ := f.Seek(0, io.SeekCurrent)
-					st.SetMboxOffset(stateKey, endPos)
-					_ = st.Save(o.stateFile)
+		for _, src := range sources {
+			if err := importMboxFile(o, st, src, appendMsg, progress, skipped); err != nil {
+				if len(sources) > 1 {
+					err = fmt.Errorf("%s: %w", src.path, err)
 				}
-				errc <- nil
+				errc <- err
 				return
 			}
-			if err != nil {
-				errc <- fmt.Errorf("read mbox: %w", err)
-				return
-			}
-			var bldr strings.Builder
-			if _, err := io.Copy(&bldr, mr); err != nil {
-				errc <- fmt.Errorf("read message: %w", err)
-				return
-			}
-			raw := bldr.String()
-			// Parse headers to determine date
-			var date time.Time
-			var hasDateHeader bool
-			var dateHeaderParsed bool
-			if msg, perr := mail.ReadMessage(strings.NewReader(raw)); perr == nil {
-				// 1) Primary: Date
-				if dh := msg.Header.Get("Date"); dh != "" {
-					hasDateHeader = true
-					if t, per := mail.ParseDate(dh); per == nil {
-						date = t
-						dateHeaderParsed = true
-					} else {
-						// Fallback: detect presence of Date header with a lightweight scan
-						if hasDateHeaderFast(raw) {
-							hasDateHeader = true
-						}
-					}
-				}
-				// 2) Fallbacks if Date missing/unparseable
-				if date.IsZero() {
-					// Resent-Date
-					if v := msg.Header.Get("Resent-Date"); v != "" {
-						if t, per := mail.ParseDate(v); per == nil {
-							date = t
-						}
-					}
-				}
-				if date.IsZero() {
-					// Delivery-date (seen in some MTAs)
-					if v := msg.Header.Get("Delivery-date"); v != "" {
-						if t, per := mail.ParseDate(v); per == nil {
-							date = t
-						}
-					}
-				}
-				if date.IsZero() {
-					// Received: parse the date part after the last ';' and pick the earliest
-					recvs := msg.Header["Received"]
-					var earliest time.Time
-					for _, rv := range recvs {
-						if idx := strings.LastIndex(rv, ";"); idx != -1 {
-							ds := strings.TrimSpace(rv[idx+1:])
-							if t, per := mail.ParseDate(ds); per == nil {
-								if earliest.IsZero() || t.Before(earliest) {
-									earliest = t
-								}
-							}
-						}
-					}
-					if !earliest.IsZero() {
-						date = earliest
-					}
-				}
-			}
-			// Apply filters
-			// Only missing Date: skip any with a Date header
-			if o.mboxOnlyMissingDate && hasDateHeader {
-				if !o.dryRun {
-					endPos, _ := f.Seek(0, io.SeekCurrent)
-					st.SetMboxOffset(stateKey, endPos)
-				}
-				continue
-			}
-			// Only unparseable Date: include only if Date header exists but could not be parsed
-			if o.mboxOnlyUnparseableDate && !(hasDateHeader && !dateHeaderParsed) {
-				// advance state to current position to avoid reprocessing on save below
-				if !o.dryRun {
-					endPos, _ := f.Seek(0, io.SeekCurrent)
-					st.SetMboxOffset(stateKey, endPos)
-				}
-				continue
-			}
-			if date.IsZero() {
-				// As a last resort, use current time
-				date = time.Now()
-			}
-			included := true
-			// Apply selection flags once more to decide inclusion
-			if o.mboxOnlyMissingDate && hasDateHeader {
-				included = false
-			}
-			if o.mboxOnlyUnparseableDate && !(hasDateHeader && date.IsZero()) {
-				// Note: date.IsZero() here indicates Date header was present but not parsed into 'date'
-				included = false
-			}
-
-			if !included {
-				// skip progress increment for excluded messages
-				continue
-			}
-
-			if o.dryRun {
-				if o.verbose {
-					log.Printf("[dry-run] append %s date=%s", o.dstMbox, func() string {
-						if date.IsZero() {
-							return "<now>"
-						}
-						return date.Format(time.RFC3339)
-					}())
-				}
-			} else {
-				if _, err := imaputil.SelectMailbox(dst, o.dstMbox, false); err != nil {
-					errc <- err
-					return
-				}
-				lit := bytes.NewReader([]byte(raw))
-				if err := dst.Append(o.dstMbox, nil, date, lit); err != nil {
-					errc <- fmt.Errorf("append: %w", err)
-					return
-				}
-				// update state offset after successful append
-				endPos, _ := f.Seek(0, io.SeekCurrent)
-				// If NextMessage advanced file cursor from curPos to endPos, save endPos
-				// In rare cases of reader buffering, prefer endPos when larger
-				if endPos <= curPos {
-					endPos = curPos
-				}
-				st.SetMboxOffset(stateKey, endPos)
-				_ = st.Save(o.stateFile)
-			}
-			progress <- 1
 		}
+		errc <- nil
 	}()
 
 	_ = runMboxTUI(total, progress, errc)
+	skipped.report(os.Stderr)
 	return nil
 }
 
-func countMboxMessages(r io.Reader) (int, error) {
-	// Count lines beginning with 'From ' which separate messages.
-	// Using a buffered scanner for speed.
-	br := bufio.NewReader(r)
+// mboxStartOffset returns the resume offset of src. Importing only messages
+// with a missing or unparseable Date scans the whole file, so earlier
+// matches are not skipped.
+func mboxStartOffset(o *copyOptions, st *state.State, src mboxSource) int64 {
+	if o.ignoreState || o.mboxOnlyMissingDate || o.mboxOnlyUnparseableDate {
+		return 0
+	}
+	absPath, _ := filepath.Abs(src.path)
+	return st.GetMboxOffset(mboxStateKey(absPath, src.mailbox))
+}
+
+// importMboxFile appends the messages of one mbox file from its resume
+// offset on, advancing the offset in the state file after every message.
+func importMboxFile(o *copyOptions, st *state.State, src mboxSource, appendMsg func(mailbox string, raw []byte, flags []string, date time.Time) error, progress chan<- int, skipped *mboxSkips) error {
+	absPath, _ := filepath.Abs(src.path)
+	stateKey := mboxStateKey(absPath, src.mailbox)
+	startOffset := mboxStartOffset(o, st, src)
+	// Open mbox (plain or gzip) at the resume offset
+	f, err := mboxutil.Open(src.path, startOffset)
+	if err != nil {
+		return fmt.Errorf("open mbox: %w", err)
+	}
+	defer f.Close()
+	r := o.mboxReader(f, src.format)
+	for {
+		msgBytes, err := r.NextMessage()
+		if err == io.EOF {
+			// reached end, save final offset
+			st.SetMboxOffset(stateKey, startOffset+r.Offset())
+			_ = o.saveState(st)
+			return nil
+		}
+		var ce *mboxutil.CorruptError
+		if errors.As(err, &ce) {
+			ce.Offset += startOffset
+			if !o.mboxSkipCorrupt {
+				return fmt.Errorf("read mbox: %w (use --mbox-skip-corrupt to skip it)", err)
+			}
+			skipped.add(src.path, ce)
+			st.SetMboxOffset(stateKey, startOffset+r.Offset())
+			_ = o.saveState(st)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read mbox: %w", err)
+		}
+		// flags kept in Status/X-Status/X-Keywords (nil if there are none)
+		msgBytes, flags, _ := mboxutil.ParseFlags(msgBytes)
+		raw := string(msgBytes)
+		// Parse headers to determine date
+		var date time.Time
+		var hasDateHeader bool
+		var dateHeaderParsed bool
+		if msg, perr := mail.ReadMessage(strings.NewReader(raw)); perr == nil {
+			if dh := msg.Header.Get("Date"); dh != "" {
+				hasDateHeader = true
+				_, per := mail.ParseDate(dh)
+				dateHeaderParsed = per == nil
+			}
+			// Date, else Resent-Date, Delivery-Date or the earliest Received
+			date = imaputil.MessageDate(msg.Header)
+		}
+		// Apply filters
+		// Only missing Date: skip any with a Date header
+		if o.mboxOnlyMissingDate && hasDateHeader {
+			st.SetMboxOffset(stateKey, startOffset+r.Offset())
+			continue
+		}
+		// Only unparseable Date: include only if Date header exists but could not be parsed
+		if o.mboxOnlyUnparseableDate && !(hasDateHeader && !dateHeaderParsed) {
+			// advance state to current position to avoid reprocessing on save below
+			st.SetMboxOffset(stateKey, startOffset+r.Offset())
+			continue
+		}
+		if date.IsZero() {
+			// As a last resort, use current time
+			date = time.Now()
+		}
+		included := true
+		// Apply selection flags once more to decide inclusion
+		if o.mboxOnlyMissingDate && hasDateHeader {
+			included = false
+		}
+		if o.mboxOnlyUnparseableDate && !(hasDateHeader && date.IsZero()) {
+			// Note: date.IsZero() here indicates Date header was present but not parsed into 'date'
+			included = false
+		}
+
+		if !included {
+			// skip progress increment for excluded messages
+			continue
+		}
+
+		if err := appendMsg(src.mailbox, []byte(raw), flags, date); err != nil {
+			return fmt.Errorf("append: %w", err)
+		}
+		// update state offset after successful append
+		st.SetMboxOffset(stateKey, startOffset+r.Offset())
+		_ = o.saveState(st)
+		progress <- 1
+	}
+}
+
+// countMboxMessages counts the readable messages left in r; corrupt
+// entries are not counted.
+func countMboxMessages(mr *mboxutil.Reader) (int, error) {
 	count := 0
 	for {
-		line, err := br.ReadString('\n')
+		_, err := mr.NextMessage()
 		if err == io.EOF {
 			break
 		}
+		var ce *mboxutil.CorruptError
+		if errors.As(err, &ce) {
+			continue
+		}
 		if err != nil {
 			return 0, err
 		}
-		if strings.HasPrefix(line, "From ") {
-			count++
-		}
+		count++
 	}
 	return count, nil
 }
 
 // countMboxSelected counts only messages that match selection flags.
-func countMboxSelected(f *os.File, onlyMissingDate, onlyUnparseableDate bool) (int, error) {
+func countMboxSelected(r *mboxutil.Reader, onlyMissingDate, onlyUnparseableDate bool) (int, error) {
 	// Start from current position; caller should have seeked appropriately
-	r := mbox.NewReader(f)
 	count := 0
 	for {
-		mr, err := r.NextMessage()
+		msgBytes, err := r.NextMessage()
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			return 0, err
+		var ce *mboxutil.CorruptError
+		if errors.As(err, &ce) {
+			continue
 		}
-		var bldr strings.Builder
-		if _, err := io.Copy(&bldr, mr); err != nil {
+		if err != nil {
 			return 0, err
 		}
-		raw := bldr.String()
+		raw := string(msgBytes)
 		var hasDateHeader bool
 		var dateHeaderParsed bool
 		if msg, perr := mail.ReadMessage(strings.NewReader(raw)); perr == nil {
@@ -1685,8 +3633,10 @@ func hasDateHeaderFast(raw string) bool {
 	return false
 }
 
-// parseMappings converts `src=dst` pairs into a map
-
+// parseMappings converts `src=dst` pairs into a map. Names may be given
+// readable ("Entwürfe") or in IMAP modified UTF-7 ("Entw&APw-rfe"), as
+// they appear in server logs and LIST output; both become the readable
+// form that mailboxes are listed and created with.
 func parseMappings(pairs []string) map[string]string {
 	m := make(map[string]string)
 	for _, p := range pairs {
@@ -1695,18 +3645,26 @@ func parseMappings(pairs []string) map[string]string {
 			fmt.Fprintf(os.Stderr, "Invalid --map value (expected src=dst): %s\n", p)
 			continue
 		}
-		m[parts[0]] = parts[1]
+		m[imaputil.DecodeMailboxName(parts[0])] = imaputil.DecodeMailboxName(parts[1])
 	}
 	return m
 }
 
+// matchMailbox reports whether re matches the readable name of a mailbox
+// or its modified UTF-7 form, so --include '^Entw&APw-rfe$' selects
+// "Entwürfe" too.
+func matchMailbox(re *regexp.Regexp, name string) bool {
+	return re.MatchString(name) || re.MatchString(imaputil.EncodeMailboxName(name))
+}
+
 // TUI implemented in tui.go
 
 // ========================= ANALYZE-MBOX =========================
 
 type analyzeMboxOptions struct {
-	mboxPath string
-	limit    int // sample lines per category
+	mboxPath   string
+	mboxFormat string
+	limit      int // sample lines per category
 }
 
 func addAnalyzeMboxFlags(cmd *cobra.Command) {
@@ -1714,6 +3672,7 @@ func addAnalyzeMboxFlags(cmd *cobra.Command) {
 	cmd.SilenceUsage = true
 	cmd.SilenceErrors = false
 	cmd.Flags().StringVar(&o.mboxPath, "mbox", "", "Path to MBOX file to analyze")
+	cmd.Flags().StringVar(&o.mboxFormat, "mbox-format", "auto", "MBOX format variant (auto, mboxo, mboxrd, mboxcl, mboxcl2)")
 	cmd.Flags().IntVar(&o.limit, "limit", 5, "Sample size per category to print")
 	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
 		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
@@ -1726,13 +3685,20 @@ func runAnalyzeMbox(cmd *cobra.Command, args []string) error {
 	if o.mboxPath == "" {
 		return fmt.Errorf("--mbox is required")
 	}
-	f, err := os.Open(o.mboxPath)
+	format, err := mboxutil.ParseFormat(o.mboxFormat)
+	if err != nil {
+		return fmt.Errorf("invalid --mbox-format: %w", err)
+	}
+	if format, err = mboxFileFormat(format, o.mboxPath); err != nil {
+		return fmt.Errorf("open mbox: %w", err)
+	}
+	f, err := mboxutil.Open(o.mboxPath, 0)
 	if err != nil {
 		return fmt.Errorf("open mbox: %w", err)
 	}
 	defer f.Close()
 
-	r := mbox.NewReader(f)
+	r := mboxutil.NewReader(f, format)
 	type sample struct{ header string }
 	var withDateParsed, withDateUnparsed, withoutDate int
 	withDateParsedSamples := []sample{}
@@ -1740,7 +3706,7 @@ func runAnalyzeMbox(cmd *cobra.Command, args []string) error {
 	withoutDateSamples := []sample{}
 
 	for {
-		mr, err := r.NextMessage()
+		msgBytes, err := r.NextMessage()
 		if err == io.EOF {
 			break
 		}
@@ -1748,7 +3714,7 @@ func runAnalyzeMbox(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("read mbox: %w", err)
 		}
 		// Read only headers quickly (up to first blank line)
-		br := bufio.NewReader(mr)
+		br := bufio.NewReader(bytes.NewReader(msgBytes))
 		var headerBuf bytes.Buffer
 		for {
 			line, rerr := br.ReadString('\n')
@@ -1800,6 +3766,7 @@ func runAnalyzeMbox(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Printf("MBOX analyze: %s\n", o.mboxPath)
+	fmt.Printf("  format:                 %s\n", format)
 	fmt.Printf("  with Date (parsed):     %d\n", withDateParsed)
 	fmt.Printf("  with Date (unparsed):   %d\n", withDateUnparsed)
 	fmt.Printf("  without Date header:    %d\n", withoutDate)
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pepperpark/gomap/internal/mboxutil"
+)
+
+// mboxSource is one mbox file and the destination mailbox it goes to.
+type mboxSource struct {
+	path    string
+	mailbox string
+	format  mboxutil.Format // resolved variant, never FormatAuto
+}
+
+// mboxFileFormat resolves --mbox-format for one file, detecting the variant
+// when it is auto.
+func mboxFileFormat(format mboxutil.Format, path string) (mboxutil.Format, error) {
+	if format != mboxutil.FormatAuto {
+		return format, nil
+	}
+	return mboxutil.DetectFile(path)
+}
+
+// resolveMboxSources expands --mbox. A plain file is imported into dstMbox
+// as before. A directory (searched recursively, as Thunderbird nests
+// folders in .sbd directories) or a glob pattern yields one source per mbox
+// file, with the mailbox derived from the file's name and location. For
+// those, an explicitly set --dst-mailbox becomes the parent folder and
+// --map applies to the derived names; a top-level "Inbox" goes to INBOX.
+// With mergeSplit, the files of a backup split by year or month
+// ("Archive-2023.mbox", "Archive-2023-05.mbox") all go to "Archive".
+func resolveMboxSources(pattern, dstMbox string, dstMboxSet, mergeSplit bool, folderMap map[string]string) ([]mboxSource, error) {
+	fi, statErr := os.Stat(pattern)
+	if statErr == nil && !fi.IsDir() {
+		return []mboxSource{{path: pattern, mailbox: dstMbox}}, nil
+	}
+	var root string
+	var files []string
+	if statErr == nil {
+		root = pattern
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type().IsRegular() && isMboxFile(p) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", root, err)
+		}
+	} else {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --mbox pattern: %w", err)
+		}
+		if len(matches) == 0 {
+			if !strings.ContainsAny(pattern, "*?[") {
+				return nil, statErr
+			}
+			return nil, fmt.Errorf("no files match %s", pattern)
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() && isMboxFile(m) {
+				files = append(files, m)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no mbox files found in %s", pattern)
+	}
+	sources := make([]mboxSource, 0, len(files))
+	for _, f := range files {
+		name := mboxMailboxName(root, f)
+		if mergeSplit {
+			name = splitPeriodRe.ReplaceAllString(name, "")
+		}
+		switch {
+		case dstMboxSet:
+			name = dstMbox + "/" + name
+		case strings.EqualFold(name, "INBOX"):
+			name = "INBOX"
+		}
+		if to, ok := folderMap[name]; ok && to != "" {
+			name = to
+		}
+		sources = append(sources, mboxSource{path: f, mailbox: name})
+	}
+	return sources, nil
+}
+
+// splitPeriodRe matches the period suffix of backup --split-by file names.
+var splitPeriodRe = regexp.MustCompile(`-\d{4}(-\d{2})?$`)
+
+// mboxMailboxName derives a mailbox name from an mbox file: the path below
+// root (or just the file name) with Thunderbird's ".sbd" directory suffix
+// and the .mbox/.mbx/.gz extensions removed, e.g. "Archives.sbd/2020.mbox"
+// becomes "Archives/2020".
+func mboxMailboxName(root, file string) string {
+	rel := filepath.Base(file)
+	if root != "" {
+		if r, err := filepath.Rel(root, file); err == nil {
+			rel = r
+		}
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts[:len(parts)-1] {
+		parts[i] = strings.TrimSuffix(parts[i], ".sbd")
+	}
+	last := strings.TrimSuffix(parts[len(parts)-1], ".gz")
+	for _, ext := range []string{".mbox", ".mbx"} {
+		if strings.HasSuffix(strings.ToLower(last), ext) {
+			last = last[:len(last)-len(ext)]
+		}
+	}
+	parts[len(parts)-1] = last
+	return strings.Join(parts, "/")
+}
+
+// isMboxFile reports whether path (plain or gzip) starts with a "From "
+// line. This skips Thunderbird's .msf indexes and other side files, and
+// empty mbox files that have nothing to import.
+func isMboxFile(path string) bool {
+	f, err := mboxutil.Open(path, 0)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return string(head) == "From "
+}
+
+// mboxReader returns a Reader with the --mbox-max-size limit applied.
+func (o *copyOptions) mboxReader(r io.Reader, format mboxutil.Format) *mboxutil.Reader {
+	mr := mboxutil.NewReader(r, format)
+	mr.SetMaxSize(int64(o.mboxMaxSize) << 20)
+	return mr
+}
+
+// mboxSkips collects the entries skipped with --mbox-skip-corrupt.
+type mboxSkips struct {
+	mu      sync.Mutex
+	entries []mboxSkip
+}
+
+type mboxSkip struct {
+	path string
+	err  *mboxutil.CorruptError
+}
+
+func (s *mboxSkips) add(path string, ce *mboxutil.CorruptError) {
+	log.Printf("[mbox] %s: skipped %v", path, ce)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, mboxSkip{path: path, err: ce})
+}
+
+// report prints the skipped entries, if any, so they can be extracted and
+// looked at by hand. Offsets refer to the uncompressed data.
+func (s *mboxSkips) report(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Skipped %d corrupt mbox entries:\n", len(s.entries))
+	for _, e := range s.entries {
+		fmt.Fprintf(w, "  %s: offset %d, %d bytes: %v\n", e.path, e.err.Offset, e.err.Size, e.err.Err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// messageMeta is the JSON sidecar written next to a single-file backup
+// (<uid>.eml -> <uid>.json) with --metadata. It keeps what the .eml file
+// alone loses, so a later upload can restore flags and dates.
+type messageMeta struct {
+	Mailbox      string    `json:"mailbox"`
+	UID          uint32    `json:"uid"`
+	UIDValidity  uint32    `json:"uidvalidity"`
+	InternalDate time.Time `json:"internal_date"`
+	Flags        []string  `json:"flags"`
+	Size         int       `json:"size"`
+}
+
+// metaName returns the sidecar name for a message file name or path.
+func metaName(emlName string) string {
+	return strings.TrimSuffix(emlName, ".eml") + ".json"
+}
+
+func (m *messageMeta) marshal() ([]byte, error) {
+	if m.Flags == nil {
+		m.Flags = []string{}
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// readMessageMeta loads the sidecar of the message file emlPath. A missing
+// sidecar returns nil without error.
+func readMessageMeta(emlPath string) (*messageMeta, error) {
+	b, err := os.ReadFile(metaName(emlPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m messageMeta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", metaName(emlPath), err)
+	}
+	return &m, nil
+}
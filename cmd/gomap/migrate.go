@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/pepperpark/gomap/internal/artifact"
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/migrate"
+	"github.com/pepperpark/gomap/internal/quirks"
+)
+
+type migrateOptions struct {
+	statusFile string
+	restart    bool
+}
+
+func addMigrateFlags(cmd *cobra.Command) {
+	o := &migrateOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.statusFile, "status-file", "gomap-migrate.json", "Where the status of the stages is kept between runs")
+	cmd.Flags().BoolVar(&o.restart, "restart", false, "Run all stages again instead of resuming after the last finished one (the copy still resumes from its state file)")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// migration is a migration plan resolved into the flags of its stages.
+type migration struct {
+	copyArgs   []string
+	verifyArgs []string
+	deltas     int
+	interval   time.Duration
+	// size of the selected source mailboxes, 0 if the estimate did not
+	// run in this process or the server does not report sizes
+	size int64
+}
+
+// runMigrate runs the migration planned in the "migration" section of the
+// config as one pipeline: estimate, pre-flight checks, initial copy, delta
+// passes, verify and report. Finished stages are recorded in the status
+// file, so running it again resumes with the first unfinished stage.
+func runMigrate(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*migrateOptions)
+	ctx := cmd.Context()
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	m, err := newMigration(cfg)
+	if err != nil {
+		return err
+	}
+
+	path := o.statusFile
+	if dryRun {
+		path = ""
+	}
+	st, err := migrate.Load(path)
+	if err != nil {
+		return fmt.Errorf("load migration status: %w", err)
+	}
+	if o.restart {
+		st.Started, st.Stages = time.Time{}, nil
+	}
+
+	stages := []migrate.Stage{
+		{Name: "estimate", Run: m.estimate},
+		{Name: "doctor", Run: m.doctor},
+		{Name: "copy", Run: m.copy},
+	}
+	for i := 1; i <= m.deltas; i++ {
+		stages = append(stages, migrate.Stage{Name: fmt.Sprintf("delta-%d", i), Run: m.delta})
+	}
+	stages = append(stages,
+		migrate.Stage{Name: "verify", Run: m.verify},
+		migrate.Stage{Name: "report", Run: func(ctx context.Context) (string, error) { return m.report(ctx, st.Started) }},
+	)
+	err = migrate.Run(ctx, st, stages, os.Stdout)
+	printMigrationStatus(st, stages)
+	return err
+}
+
+// newMigration checks the plan of cfg and turns its accounts and options
+// into stage flags.
+func newMigration(cfg *config) (*migration, error) {
+	plan := cfg.Migration
+	if plan == nil {
+		return nil, fmt.Errorf("no \"migration\" section in the config (see --config)")
+	}
+	src, err := configAccount(cfg, "source", plan.Source)
+	if err != nil {
+		return nil, fmt.Errorf("migration: %w", err)
+	}
+	dst, err := configAccount(cfg, "destination", plan.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("migration: %w", err)
+	}
+	if src.StartTLS != dst.StartTLS {
+		return nil, fmt.Errorf("migration: the source and destination accounts must both use STARTTLS or both implicit TLS")
+	}
+	m := &migration{deltas: plan.Deltas}
+	if m.deltas <= 0 {
+		m.deltas = 1
+	}
+	if plan.DeltaInterval != "" {
+		if m.interval, err = time.ParseDuration(plan.DeltaInterval); err != nil {
+			return nil, fmt.Errorf("migration: invalid delta_interval: %w", err)
+		}
+	}
+
+	m.copyArgs = append(accountArgs("src", src), accountArgs("dst", dst)...)
+	if src.StartTLS {
+		m.copyArgs = append(m.copyArgs, "--starttls")
+	}
+	if src.Insecure || dst.Insecure {
+		m.copyArgs = append(m.copyArgs, "--insecure")
+	}
+	m.copyArgs = append(m.copyArgs, plan.Options...)
+	c, err := stageCommand(context.Background(), "copy", addCopyFlags, m.copyArgs)
+	if err != nil {
+		return nil, err
+	}
+	if c.Flags().Changed("mode") {
+		return nil, fmt.Errorf("migration: --mode cannot be used in the options, the delta passes replace it")
+	}
+	v := &cobra.Command{Use: "verify"}
+	addVerifyFlags(v)
+	m.verifyArgs = sharedArgs(c.Flags(), v.Flags())
+	return m, nil
+}
+
+// configAccount returns the configured account name, the side of a
+// migration or daemon job.
+func configAccount(cfg *config, side, name string) (accountConfig, error) {
+	if name == "" {
+		return accountConfig{}, fmt.Errorf("no %s account", side)
+	}
+	acc, ok := cfg.Accounts[name]
+	if !ok {
+		return acc, fmt.Errorf("unknown %s account %q", side, name)
+	}
+	acc, err := acc.resolveURL()
+	if err != nil {
+		return acc, fmt.Errorf("account %q: %w", name, err)
+	}
+	return acc, nil
+}
+
+// accountArgs returns the --<prefix>-* login flags for acc.
+func accountArgs(prefix string, acc accountConfig) []string {
+	args := []string{"--" + prefix + "-host=" + acc.Host, "--" + prefix + "-user=" + acc.User, "--" + prefix + "-pass=" + acc.password()}
+	if acc.Port != 0 {
+		args = append(args, "--"+prefix+"-port="+strconv.Itoa(acc.Port))
+	}
+	return args
+}
+
+// sharedArgs returns the flags set in from that to also has, as arguments
+// for to.
+func sharedArgs(from, to *pflag.FlagSet) []string {
+	var args []string
+	from.Visit(func(f *pflag.Flag) {
+		if to.Lookup(f.Name) == nil {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range sv.GetSlice() {
+				args = append(args, "--"+f.Name+"="+v)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// stageCommand returns the command 'gomap use' with its flags parsed from
+// args, ready to run.
+func stageCommand(ctx context.Context, use string, addFlags func(*cobra.Command), args []string) (*cobra.Command, error) {
+	c := &cobra.Command{Use: use}
+	addFlags(c)
+	if err := c.ParseFlags(args); err != nil {
+		return nil, fmt.Errorf("migration options for %s: %w", use, err)
+	}
+	c.SetContext(ctx)
+	if err := c.PreRunE(c, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// copyOptions returns the options of a copy with the migration's flags.
+func (m *migration) copyOptions(ctx context.Context) (*copyOptions, error) {
+	c, err := stageCommand(ctx, "copy", addCopyFlags, m.copyArgs)
+	if err != nil {
+		return nil, err
+	}
+	return c.Context().Value(ctxKey{}).(*copyOptions), nil
+}
+
+// estimate counts the messages, and their size where the server reports
+// it (STATUS=SIZE), of the source mailboxes the copy will select.
+func (m *migration) estimate(ctx context.Context) (string, error) {
+	o, err := m.copyOptions(ctx)
+	if err != nil {
+		return "", err
+	}
+	keep, err := copyMailboxFilter(o)
+	if err != nil {
+		return "", err
+	}
+	src, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, &tls.Config{InsecureSkipVerify: o.insecure})
+	if err != nil {
+		return "", fmt.Errorf("connect source: %w", err)
+	}
+	defer src.Logout()
+	keep = o.applyQuirks(keep, serverQuirks(src, o.srcHost), quirks.Profile{})
+	boxes, err := imaputil.ListMailboxes(ctx, src)
+	if err != nil {
+		return "", fmt.Errorf("list mailboxes: %w", err)
+	}
+	items := []imap.StatusItem{imap.StatusMessages}
+	hasSize, _ := src.Support("STATUS=SIZE")
+	if hasSize {
+		items = append(items, "SIZE")
+	}
+	selected, messages, size := 0, uint32(0), int64(0)
+	for _, b := range boxes {
+		if !keep(b) {
+			continue
+		}
+		status, err := src.Status(b, items)
+		if err != nil {
+			return "", fmt.Errorf("status %s: %w", b, err)
+		}
+		selected++
+		messages += status.Messages
+		if v, ok := status.Items["SIZE"]; ok {
+			n, _ := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+			size += n
+		}
+	}
+	result := fmt.Sprintf("%d mailboxes, %d messages", selected, messages)
+	if hasSize {
+		m.size = size
+		result += ", " + formatBytes(size)
+	}
+	return result, nil
+}
+
+// doctor checks before the copy that both accounts can log in, that the
+// destination quota leaves room for the estimated size and that the resume
+// state can be written.
+func (m *migration) doctor(ctx context.Context) (string, error) {
+	o, err := m.copyOptions(ctx)
+	if err != nil {
+		return "", err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	var problems []string
+	report := func(ok bool, format string, a ...interface{}) {
+		msg := fmt.Sprintf(format, a...)
+		if ok {
+			fmt.Printf("      ok: %s\n", msg)
+			return
+		}
+		fmt.Printf("      problem: %s\n", msg)
+		problems = append(problems, msg)
+	}
+
+	src, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return "", fmt.Errorf("source login: %w", err)
+	}
+	defer src.Logout()
+	report(true, "source login %s@%s", o.srcUser, o.srcHost)
+	dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return "", fmt.Errorf("destination login: %w", err)
+	}
+	defer dst.Logout()
+	report(true, "destination login %s@%s", o.dstUser, o.dstHost)
+
+	srcDelim, err := imaputil.Delimiter(src)
+	if err != nil {
+		return "", fmt.Errorf("list source hierarchy delimiter: %w", err)
+	}
+	dstDelim, err := imaputil.Delimiter(dst)
+	if err != nil {
+		return "", fmt.Errorf("list destination hierarchy delimiter: %w", err)
+	}
+	report(true, "hierarchy delimiters %q (source) and %q (destination)", srcDelim, dstDelim)
+
+	switch q, ok, err := imaputil.StorageQuota(dst, "INBOX"); {
+	case err != nil:
+		report(false, "destination quota: %v", err)
+	case !ok:
+		report(true, "destination sets no storage quota")
+	case m.size > 0 && m.size > q.Free():
+		report(false, "destination quota: %s free, the source mailboxes hold %s", formatBytes(q.Free()), formatBytes(m.size))
+	default:
+		report(true, "destination quota: %s of %s free", formatBytes(q.Free()), formatBytes(q.Limit))
+	}
+
+	if !dryRun {
+		f, err := os.CreateTemp(filepath.Dir(o.stateFile), ".gomap-doctor-*")
+		if err == nil {
+			f.Close()
+			err = os.Remove(f.Name())
+		}
+		report(err == nil, "resume state %s is writable", o.stateFile)
+	}
+
+	if len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return "all checks passed", nil
+}
+
+// copy is the initial copy.
+func (m *migration) copy(ctx context.Context) (string, error) {
+	c, err := stageCommand(ctx, "copy", addCopyFlags, m.copyArgs)
+	if err != nil {
+		return "", err
+	}
+	return "", runCopy(c, nil)
+}
+
+// delta waits for the delta interval and copies what arrived since the
+// last pass; the resume state makes the copy incremental.
+func (m *migration) delta(ctx context.Context) (string, error) {
+	if m.interval > 0 && !dryRun {
+		fmt.Printf("      waiting %s before the pass\n", m.interval)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(m.interval):
+		}
+	}
+	return m.copy(ctx)
+}
+
+// verify compares the mailboxes on both sides.
+func (m *migration) verify(ctx context.Context) (string, error) {
+	c, err := stageCommand(ctx, "verify", addVerifyFlags, m.verifyArgs)
+	if err != nil {
+		return "", err
+	}
+	return "", runVerify(c, nil)
+}
+
+// report sums up the copy runs of the migration, from the run summaries
+// in the artifact store.
+func (m *migration) report(ctx context.Context, since time.Time) (string, error) {
+	o, err := m.copyOptions(ctx)
+	if err != nil {
+		return "", err
+	}
+	if o.artifacts == "" || dryRun {
+		return "no run summaries stored", nil
+	}
+	store, err := artifact.Open(o.artifacts)
+	if err != nil {
+		return "", err
+	}
+	ids, err := reportIDs(ctx, store)
+	if err != nil {
+		return "", err
+	}
+	first := since.UTC().Format("20060102T150405Z")
+	runs, copied, errs := 0, 0, 0
+	folders := map[string]bool{}
+	for _, id := range ids {
+		if id < first {
+			continue
+		}
+		s, err := loadReport(ctx, store, ids, id)
+		if err != nil {
+			return "", err
+		}
+		runs++
+		errs += len(s.Errors)
+		for name, mb := range s.Mailboxes {
+			copied += mb.Copied
+			folders[name] = true
+		}
+	}
+	result := fmt.Sprintf("%d messages copied from %d folders in %d runs, %d errors", copied, len(folders), runs, errs)
+	if runs > 0 {
+		result += fmt.Sprintf(" (details: gomap report list --artifacts %s)", o.artifacts)
+	}
+	return result, nil
+}
+
+// printMigrationStatus lists the state of every stage.
+func printMigrationStatus(st *migrate.Status, stages []migrate.Stage) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\nSTAGE\tSTATE\tTOOK\tRESULT")
+	for _, stage := range stages {
+		s := st.Stage(stage.Name)
+		state, took, result := s.State, "", s.Result
+		if state == migrate.Pending {
+			state = "pending"
+		}
+		if d := s.Duration(); d > 0 || s.State != migrate.Pending {
+			took = d.String()
+		}
+		if s.Error != "" {
+			result = s.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", stage.Name, state, took, result)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/msgfile"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// msgSource is one folder of Outlook .msg files and the destination
+// mailbox it goes to.
+type msgSource struct {
+	dir     string
+	mailbox string
+	files   []string // base names, sorted
+}
+
+// resolveMsgSources finds the folders below root that hold .msg files.
+// Files directly in root go to --dst-mailbox, subfolders keep their path
+// below root ("Projects/2023"), under --dst-mailbox if it was set
+// explicitly. --map applies to the derived names.
+func resolveMsgSources(root, dstMbox string, dstMboxSet bool, folderMap map[string]string) ([]msgSource, error) {
+	byDir := map[string][]string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && strings.EqualFold(filepath.Ext(d.Name()), ".msg") {
+			dir := filepath.Dir(p)
+			byDir[dir] = append(byDir[dir], d.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", root, err)
+	}
+	if len(byDir) == 0 {
+		return nil, fmt.Errorf("no .msg files found in %s", root)
+	}
+	sources := make([]msgSource, 0, len(byDir))
+	for dir, files := range byDir {
+		sort.Strings(files)
+		name := dstMbox
+		if rel, err := filepath.Rel(root, dir); err == nil && rel != "." {
+			name = filepath.ToSlash(rel)
+			if dstMboxSet {
+				name = dstMbox + "/" + name
+			}
+		}
+		if strings.EqualFold(name, "INBOX") {
+			name = "INBOX"
+		}
+		if to, ok := folderMap[name]; ok && to != "" {
+			name = to
+		}
+		sources = append(sources, msgSource{dir: dir, mailbox: name, files: files})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].dir < sources[j].dir })
+	return sources, nil
+}
+
+// msgStateKey builds the state key for a .msg folder and destination mailbox.
+func msgStateKey(absDir, dstMailbox string) string {
+	return fmt.Sprintf("msg:%s|dst:%s", absDir, dstMailbox)
+}
+
+// msgMessage converts a .msg file. Messages without delivery or submit
+// time get the file's modification time.
+func msgMessage(path string) (*msgfile.Message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := msgfile.Convert(data)
+	if err != nil {
+		return nil, err
+	}
+	if m.Date.IsZero() {
+		if fi, serr := os.Stat(path); serr == nil {
+			m.Date = fi.ModTime()
+		}
+	}
+	return m, nil
+}
+
+func runCopyMsg(cmd *cobra.Command, o *copyOptions) error {
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	sources, err := resolveMsgSources(o.msgPath, o.dstMbox, cmd.Flags().Changed("dst-mailbox"), parseMappings(o.mapPairs))
+	if err != nil {
+		return err
+	}
+	for i := range sources {
+		sources[i].mailbox = o.flatName(sources[i].mailbox)
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	ctx := cmd.Context()
+	appendPacer := o.pacer()
+	var appendMsg func(mailbox string, raw []byte, flags []string, date time.Time) error
+	var ensure func(mailbox string) error
+	if o.dstLMTP != "" {
+		// LMTP cannot set flags; messages arrive as new mail
+		d := newLMTPDeliverer(o)
+		defer d.Close()
+		appendMsg = func(mailbox string, raw []byte, flags []string, date time.Time) error {
+			return appendPacer.Do(ctx, func() error { return d.Deliver(ctx, mailbox, bytes.Join(o.messageParts(raw), nil)) })
+		}
+		ensure = func(string) error { return nil }
+	} else {
+		dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+		if err != nil {
+			return fmt.Errorf("connect destination: %w", err)
+		}
+		defer dst.Logout()
+		appendMsg = func(mailbox string, raw []byte, flags []string, date time.Time) error {
+			return appendPacer.Do(ctx, func() error {
+				return imaputil.Append(dst, mailbox, o.flagMap.Apply(flags), date, o.messageParts(raw)...)
+			})
+		}
+		ensure = func(mailbox string) error { return o.ensureMailbox(dst, mailbox) }
+	}
+	appendMsg = o.filters.filterAppend(dryRunAppend(o, appendMsg))
+
+	// Drop the files copied before, per folder
+	var total int
+	for i, src := range sources {
+		abs, _ := filepath.Abs(src.dir)
+		if !o.ignoreState {
+			mark := st.GetMsgMark(msgStateKey(abs, src.mailbox))
+			kept := src.files[:0]
+			for _, f := range src.files {
+				if f > mark {
+					kept = append(kept, f)
+				}
+			}
+			sources[i].files = kept
+		}
+		if o.verbose {
+			log.Printf("[msg] %s -> %s (%d messages)", src.dir, src.mailbox, len(sources[i].files))
+		}
+		total += len(sources[i].files)
+	}
+	for _, src := range sources {
+		if len(src.files) == 0 {
+			continue
+		}
+		if err := ensure(src.mailbox); err != nil {
+			return fmt.Errorf("ensure mailbox %s: %w", src.mailbox, err)
+		}
+	}
+
+	progress := make(chan int, 128)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(progress)
+		defer close(errc)
+		for _, src := range sources {
+			abs, _ := filepath.Abs(src.dir)
+			key := msgStateKey(abs, src.mailbox)
+			for _, name := range src.files {
+				path := filepath.Join(src.dir, name)
+				m, err := msgMessage(path)
+				if err != nil {
+					errc <- fmt.Errorf("convert %s: %w", path, err)
+					return
+				}
+				if err := appendMsg(src.mailbox, m.Raw, m.Flags, m.Date); err != nil {
+					errc <- fmt.Errorf("append %s: %w", path, err)
+					return
+				}
+				st.SetMsgMark(key, name)
+				_ = o.saveState(st)
+				progress <- 1
+			}
+		}
+		errc <- nil
+	}()
+
+	_ = runMboxTUI(total, progress, errc)
+	return nil
+}
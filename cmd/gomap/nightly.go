@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/runlog"
+	"github.com/pepperpark/gomap/internal/state"
+	"github.com/pepperpark/gomap/internal/syncer"
+)
+
+// nightlyMaxRate caps appends in nightly-delta mode unless --max-rate is
+// given, so the unattended run stays well below provider limits. The mode
+// also copies one mailbox at a time unless --concurrency is given.
+const nightlyMaxRate = 5
+
+// deltaResult summarizes one delta sync.
+type deltaResult struct {
+	started time.Time
+	elapsed time.Duration
+	copied  map[string]int // per source mailbox
+	errs    []error
+	summary *runlog.Summary
+}
+
+// runNightlyDelta keeps running and syncs new messages once a day at
+// --nightly-at, resuming from the state file, and mails a digest of each
+// run when --notify-to is set. It stops on SIGINT/SIGTERM.
+func runNightlyDelta(cmd *cobra.Command, o *copyOptions) error {
+	if o.mboxPath != "" || o.maildirPath != "" || o.msgPath != "" || o.srcGmailAPI {
+		return fmt.Errorf("--mode nightly-delta needs an IMAP source")
+	}
+	if o.srcHost == "" || o.srcUser == "" || o.srcPass == "" {
+		return fmt.Errorf("missing required flags: --src-host, --src-user, --src-pass")
+	}
+	if o.dstLMTP == "" && (o.dstHost == "" || o.dstUser == "" || o.dstPass == "") {
+		return fmt.Errorf("missing required flags: --dst-host, --dst-user, --dst-pass (or --dst-lmtp)")
+	}
+	if o.ignoreState {
+		return fmt.Errorf("--ignore-state cannot be used with --mode nightly-delta")
+	}
+	at, err := time.Parse("15:04", o.nightlyAt)
+	if err != nil {
+		return fmt.Errorf("invalid --nightly-at: %s (expected HH:MM)", o.nightlyAt)
+	}
+	if len(o.notifyTo) > 0 {
+		if o.smtpHost == "" {
+			return fmt.Errorf("--notify-to requires --smtp-host")
+		}
+		if o.notifyFrom == "" {
+			return fmt.Errorf("--notify-to requires --notify-from")
+		}
+	}
+	keep, err := copyMailboxFilter(o)
+	if err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("max-rate") {
+		o.maxRate = nightlyMaxRate
+	}
+	if !cmd.Flags().Changed("concurrency") {
+		o.concurrency = 1
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for {
+		next := nextDailyRun(time.Now(), at.Hour(), at.Minute())
+		log.Printf("next delta sync at %s", next.Format("2006-01-02 15:04"))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+		res := syncDelta(ctx, o, keep)
+		if ctx.Err() != nil {
+			return nil
+		}
+		o.saveRunSummary(ctx, res.summary, res.errs)
+		o.writeFailures(res.summary)
+		digest := res.digest(o)
+		fmt.Print(digest)
+		if len(o.notifyTo) > 0 {
+			if err := sendDigest(o, res, digest); err != nil {
+				log.Printf("send digest: %v", err)
+			}
+		}
+	}
+}
+
+// nextDailyRun returns the next time after now at hour:min local time.
+func nextDailyRun(now time.Time, hour, min int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, min, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// syncDelta runs one headless incremental copy with fresh connections and
+// counts the copied messages per mailbox. Connection and listing failures
+// are reported as errors of the run, so the next night tries again.
+func syncDelta(ctx context.Context, o *copyOptions, keep func(name string) bool) *deltaResult {
+	res := &deltaResult{started: time.Now(), copied: map[string]int{}, summary: o.newRunSummary()}
+	defer func() { res.elapsed = time.Since(res.started) }()
+	fail := func(err error) *deltaResult {
+		res.errs = append(res.errs, err)
+		return res
+	}
+
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fail(fmt.Errorf("load state: %w", err))
+	}
+	var sinceTime time.Time
+	if o.since != "" {
+		if sinceTime, err = time.Parse("2006-01-02", o.since); err != nil {
+			return fail(fmt.Errorf("invalid --since date: %w (expected YYYY-MM-DD)", err))
+		}
+	} else {
+		sinceTime = time.Unix(0, 0).UTC()
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	src, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return fail(fmt.Errorf("connect source: %w", err))
+	}
+	defer src.Logout()
+	var dst *client.Client
+	var deliver func(ctx context.Context, mailbox string, raw []byte) error
+	if o.dstLMTP != "" {
+		d := newLMTPDeliverer(o)
+		defer d.Close()
+		deliver = d.Deliver
+	} else {
+		dst, err = imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+		if err != nil {
+			return fail(fmt.Errorf("connect destination: %w", err))
+		}
+		defer dst.Logout()
+	}
+
+	boxes, err := imaputil.ListMailboxes(ctx, src)
+	if err != nil {
+		return fail(fmt.Errorf("list mailboxes: %w", err))
+	}
+	filtered := make([]string, 0, len(boxes))
+	for _, b := range boxes {
+		if keep(b) {
+			filtered = append(filtered, b)
+		}
+	}
+	if err := o.loadDelimiters(src, dst); err != nil {
+		return fail(err)
+	}
+
+	// The syncer logs out both connections when its context ends, so each
+	// run gets its own.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	copied := make(chan string, 128)
+	counted := make(chan struct{})
+	go func() {
+		for box := range copied {
+			res.copied[box]++
+		}
+		close(counted)
+	}()
+	srcPool, dstPool := o.connPools(src, dst, tlsConfig)
+	defer srcPool.Close()
+	defer dstPool.Close()
+	worker := syncer.NewMailboxSyncer(src, dst, st, syncer.Options{
+		DryRun:           dryRun,
+		Since:            sinceTime,
+		Before:           o.beforeTime,
+		Concurrency:      o.concurrency,
+		Quiet:            !o.verbose,
+		Map:              o.folderMap(filtered),
+		SplitThreshold:   o.splitAt,
+		SplitMonthly:     o.splitBy == "month",
+		Pacer:            o.pacer(),
+		AppendBatch:      o.appendBatch,
+		AppendBatchBytes: o.appendBatchBytes,
+		Verify:           o.verifyLevel,
+		FlagMap:          o.flagMap,
+		Subscribe:        o.subscriptions(src),
+		ACL:              o.copyACLs(src, dst),
+		ACLOwners:        []string{o.srcUser, o.dstUser},
+		Deliver:          deliver,
+		Headers:          o.headers,
+		Limit:            o.limit,
+		NewestFirst:      o.newestFirst,
+		Connections:      o.connections,
+		Reconnects:       o.reconnects,
+		SrcPool:          srcPool,
+		DstPool:          dstPool,
+		Flags:            o.flagFilter(),
+		FilterFields:     o.filters.fields(),
+		Filter:           o.filters.matcher(),
+		Copied: func(box string, uid uint32) {
+			res.summary.Copied(box)
+			copied <- box
+		},
+		Selected: res.summary.Selected,
+		Failed:   o.failed(res.summary),
+		MaxSize:  o.maxBytes,
+		Oversized: func(mailbox string, uid uint32, size int64) {
+			res.summary.Skipped(mailbox, uid)
+		},
+		Checkpoint: func() {
+			if !dryRun {
+				_ = o.saveState(st)
+			}
+		},
+	})
+	go func() {
+		// progress events are not needed without the TUI
+		for range worker.Events() {
+		}
+	}()
+	res.errs = worker.SyncAll(runCtx, filtered)
+	close(copied)
+	<-counted
+	if !dryRun {
+		if err := o.saveState(st); err != nil {
+			res.errs = append(res.errs, fmt.Errorf("save state: %w", err))
+		}
+	}
+	return res
+}
+
+// total returns the number of messages copied in all mailboxes.
+func (r *deltaResult) total() int {
+	n := 0
+	for _, c := range r.copied {
+		n += c
+	}
+	return n
+}
+
+// digest renders the plain-text summary of a run.
+func (r *deltaResult) digest(o *copyOptions) string {
+	var b strings.Builder
+	dst := o.dstUser + "@" + o.dstHost
+	if o.dstLMTP != "" {
+		dst = "LMTP " + o.dstLMTP
+	}
+	fmt.Fprintf(&b, "Delta sync %s@%s -> %s\n", o.srcUser, o.srcHost, dst)
+	fmt.Fprintf(&b, "Started %s, took %s.\n\n", r.started.Format("2006-01-02 15:04"), r.elapsed.Round(time.Second))
+	if r.total() == 0 {
+		b.WriteString("No new messages.\n")
+	} else {
+		boxes := make([]string, 0, len(r.copied))
+		for box := range r.copied {
+			boxes = append(boxes, box)
+		}
+		sort.Strings(boxes)
+		for _, box := range boxes {
+			fmt.Fprintf(&b, "%7d  %s\n", r.copied[box], box)
+		}
+		fmt.Fprintf(&b, "%7d  total\n", r.total())
+	}
+	var oversized []string
+	for box, mb := range r.summary.Mailboxes {
+		for _, uid := range mb.Oversized {
+			oversized = append(oversized, fmt.Sprintf("%s UID %d", box, uid))
+		}
+	}
+	if len(oversized) > 0 {
+		sort.Strings(oversized)
+		b.WriteString("\nSkipped over --max-size:\n")
+		for _, m := range oversized {
+			fmt.Fprintf(&b, " - %s\n", m)
+		}
+	}
+	if failures := r.summary.Failures(); len(failures) > 0 {
+		b.WriteString("\nNot copied (--continue-on-error):\n")
+		for _, f := range failures {
+			fmt.Fprintf(&b, " - %s UID %d: %s\n", f.Mailbox, f.UID, f.Error)
+		}
+	}
+	if len(r.errs) > 0 {
+		b.WriteString("\nErrors:\n")
+		for _, e := range r.errs {
+			fmt.Fprintf(&b, " - %v\n", e)
+		}
+	}
+	return b.String()
+}
+
+// sendDigest mails the digest of a run to --notify-to.
+func sendDigest(o *copyOptions, r *deltaResult, digest string) error {
+	subject := fmt.Sprintf("gomap: %d new messages synced", r.total())
+	if len(r.errs) > 0 {
+		subject += fmt.Sprintf(", %d errors", len(r.errs))
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n%s",
+		o.notifyFrom, strings.Join(o.notifyTo, ", "), subject, time.Now().Format(time.RFC1123Z),
+		strings.ReplaceAll(digest, "\n", "\r\n"))
+	srv := smtpServer{host: o.smtpHost, port: o.smtpPort, user: o.smtpUser, pass: o.smtpPass, startTLS: true, ssl: o.smtpSSL, insecure: o.insecure}
+	return srv.send(o.notifyFrom, o.notifyTo, int64(len(msg)), func(w io.Writer) error {
+		_, err := io.WriteString(w, msg)
+		return err
+	})
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/pepperpark/gomap/internal/s3"
+	"github.com/pepperpark/gomap/internal/webdav"
+)
+
+// remoteOutput is a backup destination other than the local filesystem.
+// Names are slash-separated and relative to the output root.
+type remoteOutput interface {
+	// Put stores size bytes from r under name, replacing any existing file.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	// List returns the names of the files in dir (object stores may include
+	// files further below). A missing dir yields no names.
+	List(ctx context.Context, dir string) ([]string, error)
+	Close() error
+	String() string
+}
+
+// openRemoteOutput parses a --output URL.
+func openRemoteOutput(raw string) (remoteOutput, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --output: %w", err)
+	}
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid --output: missing bucket in %q", raw)
+		}
+		c, err := s3.NewFromEnv(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return &s3Output{client: c, bucket: u.Host, prefix: cleanPrefix(u.Path)}, nil
+	case "sftp":
+		return openSFTPOutput(u)
+	case "webdav", "webdavs":
+		user, pass := outputCredentials(u)
+		root := *u
+		root.Scheme = "https"
+		if u.Scheme == "webdav" {
+			root.Scheme = "http"
+		}
+		root.User = nil
+		return &webdavOutput{client: webdav.New(&root, user, pass), display: redactURL(u)}, nil
+	}
+	return nil, fmt.Errorf("invalid --output: unsupported scheme %q (expected s3://, sftp://, webdav:// or webdavs://)", u.Scheme)
+}
+
+// outputCredentials returns the user and password of an --output URL. The
+// password may also come from GOMAP_OUTPUT_PASS, which keeps it out of ps.
+func outputCredentials(u *url.URL) (user, pass string) {
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	if pass == "" {
+		pass = os.Getenv("GOMAP_OUTPUT_PASS")
+	}
+	return user, pass
+}
+
+func redactURL(u *url.URL) string {
+	c := *u
+	if c.User != nil {
+		c.User = url.User(c.User.Username())
+	}
+	return c.String()
+}
+
+// cleanPrefix turns a URL path into "" or "dir/sub/".
+func cleanPrefix(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
+
+type s3Output struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (o *s3Output) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	return o.client.Put(ctx, o.prefix+name, r, size)
+}
+
+func (o *s3Output) List(ctx context.Context, dir string) ([]string, error) {
+	keys, err := o.client.List(ctx, o.prefix+cleanPrefix(dir))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		names = append(names, strings.TrimPrefix(k, o.prefix))
+	}
+	return names, nil
+}
+
+func (o *s3Output) Close() error { return nil }
+
+func (o *s3Output) String() string { return "s3://" + o.bucket + "/" + o.prefix }
+
+type webdavOutput struct {
+	client  *webdav.Client
+	display string
+}
+
+func (o *webdavOutput) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	return o.client.Put(ctx, name, r, size)
+}
+
+func (o *webdavOutput) List(ctx context.Context, dir string) ([]string, error) {
+	return o.client.List(ctx, dir)
+}
+
+func (o *webdavOutput) Close() error { return nil }
+
+func (o *webdavOutput) String() string { return o.display }
+
+type sftpOutput struct {
+	conn    *ssh.Client
+	client  *sftp.Client
+	root    string
+	display string
+}
+
+// openSFTPOutput connects to sftp://[user[:pass]@]host[:port]/path. It
+// authenticates with the SSH agent, the default keys in ~/.ssh and, if
+// given, a password, and verifies the host key against ~/.ssh/known_hosts.
+func openSFTPOutput(u *url.URL) (*sftpOutput, error) {
+	user, pass := outputCredentials(u)
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	hostKeys, err := knownhosts.New(expandHome("~/.ssh/known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("sftp: load known_hosts: %w", err)
+	}
+	auths := []ssh.AuthMethod{}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	signers := []ssh.Signer{}
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		b, err := os.ReadFile(expandHome(filepath.Join("~/.ssh", name)))
+		if err != nil {
+			continue
+		}
+		if s, err := ssh.ParsePrivateKey(b); err == nil {
+			signers = append(signers, s) // passphrase-protected keys need the agent
+		}
+	}
+	if len(signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(signers...))
+	}
+	if pass != "" {
+		auths = append(auths, ssh.Password(pass))
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{User: user, Auth: auths, HostKeyCallback: hostKeys})
+	if err != nil {
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return nil, fmt.Errorf("sftp: %s is not in ~/.ssh/known_hosts (add it with: ssh-keyscan -p %s %s >> ~/.ssh/known_hosts)", u.Hostname(), portOr(u, "22"), u.Hostname())
+		}
+		return nil, fmt.Errorf("sftp: connect %s: %w", host, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp: %w", err)
+	}
+	root := u.Path
+	if root == "" {
+		root = "."
+	}
+	return &sftpOutput{conn: conn, client: client, root: root, display: redactURL(u)}, nil
+}
+
+func portOr(u *url.URL, def string) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	return def
+}
+
+// Put writes to a temporary name first, so an interrupted upload never
+// leaves a truncated file that a later run would skip.
+func (o *sftpOutput) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	full := path.Join(o.root, name)
+	if err := o.client.MkdirAll(path.Dir(full)); err != nil {
+		return fmt.Errorf("sftp: mkdir %s: %w", path.Dir(full), err)
+	}
+	tmp := full + ".part"
+	f, err := o.client.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("sftp: create %s: %w", tmp, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("sftp: write %s: %w", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("sftp: write %s: %w", tmp, err)
+	}
+	if err := o.client.PosixRename(tmp, full); err != nil {
+		// servers without the posix-rename extension cannot replace files
+		_ = o.client.Remove(full)
+		if err := o.client.Rename(tmp, full); err != nil {
+			return fmt.Errorf("sftp: rename %s: %w", tmp, err)
+		}
+	}
+	return nil
+}
+
+func (o *sftpOutput) List(ctx context.Context, dir string) ([]string, error) {
+	entries, err := o.client.ReadDir(path.Join(o.root, dir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("sftp: list %s: %w", dir, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, path.Join(dir, e.Name()))
+		}
+	}
+	return names, nil
+}
+
+func (o *sftpOutput) Close() error {
+	o.client.Close()
+	return o.conn.Close()
+}
+
+func (o *sftpOutput) String() string { return o.display }
+
+// uploadFile stores the local file as name.
+func uploadFile(ctx context.Context, out remoteOutput, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := out.Put(ctx, name, f, fi.Size()); err != nil {
+		return fmt.Errorf("upload %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/mailparse"
+)
+
+type parseOptions struct {
+	json bool
+}
+
+func addParseFlags(cmd *cobra.Command) {
+	o := &parseOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().BoolVar(&o.json, "json", false, "Print the message as JSON (headers, MIME tree, attachments, text bodies)")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+func runParse(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*parseOptions)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	for _, name := range args {
+		var raw []byte
+		var err error
+		if name == "-" {
+			raw, err = io.ReadAll(os.Stdin)
+		} else {
+			raw, err = os.ReadFile(name)
+		}
+		if err != nil {
+			return err
+		}
+		// a message cut from an mbox may still carry its "From " line
+		if bytes.HasPrefix(raw, []byte("From ")) {
+			if i := bytes.IndexByte(raw, '\n'); i >= 0 {
+				raw = raw[i+1:]
+			}
+		}
+		m, err := mailparse.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", name, err)
+		}
+		if o.json {
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(args) > 1 {
+			fmt.Printf("==> %s <==\n", name)
+		}
+		printParsed(m)
+	}
+	return nil
+}
+
+// printParsed writes a short human-readable summary of m.
+func printParsed(m *mailparse.Message) {
+	addrs := func(list []mailparse.Address) string {
+		s := make([]string, 0, len(list))
+		for _, a := range list {
+			switch {
+			case strings.ContainsAny(a.Name, `,;:<>"`):
+				s = append(s, fmt.Sprintf("%q <%s>", a.Name, a.Address))
+			case a.Name != "":
+				s = append(s, fmt.Sprintf("%s <%s>", a.Name, a.Address))
+			default:
+				s = append(s, a.Address)
+			}
+		}
+		return strings.Join(s, ", ")
+	}
+	fmt.Printf("From:    %s\n", addrs(m.From))
+	fmt.Printf("To:      %s\n", addrs(m.To))
+	if len(m.Cc) > 0 {
+		fmt.Printf("Cc:      %s\n", addrs(m.Cc))
+	}
+	fmt.Printf("Subject: %s\n", m.Subject)
+	if m.Date != nil {
+		fmt.Printf("Date:    %s\n", m.Date.Format("2006-01-02 15:04:05 -0700"))
+	}
+	fmt.Printf("Size:    %d bytes\n\n", m.Size)
+	var walk func(p mailparse.Part, indent string)
+	walk = func(p mailparse.Part, indent string) {
+		line := indent + p.ContentType
+		if p.Path != "" {
+			line = indent + p.Path + " " + p.ContentType
+		}
+		if p.Filename != "" {
+			line += fmt.Sprintf(" %q", p.Filename)
+		}
+		if !strings.HasPrefix(p.ContentType, "multipart/") {
+			line += fmt.Sprintf(" (%d bytes)", p.Size)
+		}
+		fmt.Println(line)
+		for _, c := range p.Parts {
+			walk(c, indent+"  ")
+		}
+	}
+	walk(m.Body, "")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pepperpark/gomap/internal/runlog"
+)
+
+// copyPlan is the --report of a dry run: what a real run with the same
+// flags would copy, to review or diff before it.
+type copyPlan struct {
+	Generated   time.Time        `json:"generated"`
+	Source      string           `json:"source"`
+	Destination string           `json:"destination"`
+	Messages    int              `json:"messages"` // to copy, in all mailboxes
+	Bytes       int64            `json:"bytes"`
+	Mailboxes   []plannedMailbox `json:"mailboxes"`
+}
+
+// plannedMailbox is one source mailbox of a copyPlan.
+type plannedMailbox struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Total       uint32 `json:"total"` // messages in the source mailbox
+	Messages    int    `json:"messages"`
+	Bytes       int64  `json:"bytes"` // RFC822.SIZE of the messages
+	Oversized   int    `json:"oversized,omitempty"`
+}
+
+// planRecorder collects the messages a dry run would append, as the
+// syncer's Planned callback.
+type planRecorder struct {
+	mu    sync.Mutex
+	count map[string]int
+	bytes map[string]int64
+}
+
+// newPlanRecorder returns a recorder for --report, or nil without it.
+func (o *copyOptions) newPlanRecorder() *planRecorder {
+	if o.planReport == "" {
+		return nil
+	}
+	return &planRecorder{count: map[string]int{}, bytes: map[string]int64{}}
+}
+
+// planned returns the syncer's Planned callback, nil for a nil recorder.
+func (r *planRecorder) planned() func(mailbox string, uid uint32, size int64) {
+	if r == nil {
+		return nil
+	}
+	return func(mailbox string, uid uint32, size int64) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.count[mailbox]++
+		r.bytes[mailbox] += size
+	}
+}
+
+// write writes the plan for the source mailboxes boxes to path as JSON.
+// folderMap is the copy's folder mapping (missing entries keep the source
+// name) and s the summary of the dry run, for the mailbox sizes and the
+// messages skipped for --max-size.
+func (r *planRecorder) write(path string, boxes []string, folderMap map[string]string, s *runlog.Summary) error {
+	p := copyPlan{Generated: time.Now(), Source: s.Source, Destination: s.Destination, Mailboxes: []plannedMailbox{}}
+	sorted := append([]string(nil), boxes...)
+	sort.Strings(sorted)
+	for _, b := range sorted {
+		mb := plannedMailbox{Source: b, Destination: b, Messages: r.count[b], Bytes: r.bytes[b]}
+		if to, ok := folderMap[b]; ok && to != "" {
+			mb.Destination = to
+		}
+		if sm := s.Mailboxes[b]; sm != nil {
+			mb.Total = sm.Messages
+			mb.Oversized = len(sm.Oversized)
+		}
+		p.Messages += mb.Messages
+		p.Bytes += mb.Bytes
+		p.Mailboxes = append(p.Mailboxes, mb)
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write plan: %w", err)
+	}
+	return nil
+}
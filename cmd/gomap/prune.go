@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type pruneOptions struct {
+	pairOptions
+	include  string
+	exclude  string
+	mapPairs []string
+	expunge  bool
+	yes      bool
+}
+
+func addPruneFlags(cmd *cobra.Command) {
+	o := &pruneOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addPairFlags(cmd, &o.pairOptions)
+	cmd.Flags().StringVar(&o.include, "include", "", "Regex of source mailboxes to include")
+	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regex of source mailboxes to exclude")
+	cmd.Flags().StringArrayVar(&o.mapPairs, "map", nil, "Folder mapping src=dst (can be repeated)")
+	cmd.Flags().BoolVar(&o.expunge, "expunge", true, "Permanently remove messages after marking as \\Deleted")
+	cmd.Flags().BoolVar(&o.yes, "yes", false, "Do not ask for confirmation")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// dupKey identifies a message across accounts.
+type dupKey struct {
+	messageID string
+	size      uint32
+}
+
+// fetchDupKeys returns the UIDs of all messages in the selected mailbox,
+// grouped by Message-ID and size. Messages without a Message-ID are left out
+// because they cannot be matched reliably.
+func fetchDupKeys(c *client.Client) (map[dupKey][]uint32, error) {
+	seq := new(imap.SeqSet)
+	seq.AddRange(1, 0)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchRFC822Size, imap.FetchEnvelope}, msgs)
+	}()
+	keys := map[dupKey][]uint32{}
+	for msg := range msgs {
+		if msg.Envelope == nil || strings.TrimSpace(msg.Envelope.MessageId) == "" {
+			continue
+		}
+		k := dupKey{messageID: strings.TrimSpace(msg.Envelope.MessageId), size: msg.Size}
+		keys[k] = append(keys[k], msg.Uid)
+	}
+	return keys, <-done
+}
+
+type prunePlan struct {
+	srcBox, dstBox string
+	uids           []uint32
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*pruneOptions)
+	includeRe, excludeRe, err := mailboxFilters(o.include, o.exclude)
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	src, dst, err := connectPair(cmd, &o.pairOptions)
+	if err != nil {
+		return err
+	}
+	defer src.Logout()
+	defer dst.Logout()
+
+	srcBoxes, err := imaputil.ListMailboxes(ctx, src)
+	if err != nil {
+		return fmt.Errorf("list source mailboxes: %w", err)
+	}
+	dstBoxes, err := imaputil.ListMailboxes(ctx, dst)
+	if err != nil {
+		return fmt.Errorf("list destination mailboxes: %w", err)
+	}
+	dstExists := map[string]bool{}
+	for _, b := range dstBoxes {
+		dstExists[b] = true
+	}
+	folderMap := parseMappings(o.mapPairs)
+
+	// Plan: in every mapped mailbox, keep as many copies of a message as the
+	// source has and delete the surplus, newest (highest UID) first.
+	plans := []prunePlan{}
+	total := 0
+	for _, box := range srcBoxes {
+		if includeRe != nil && !matchMailbox(includeRe, box) {
+			continue
+		}
+		if excludeRe != nil && matchMailbox(excludeRe, box) {
+			continue
+		}
+		dstBox := box
+		if to, ok := folderMap[box]; ok && to != "" {
+			dstBox = to
+		}
+		if !dstExists[dstBox] {
+			continue
+		}
+		if _, err := imaputil.SelectMailbox(src, box, true); err != nil {
+			fmt.Fprintf(os.Stderr, "select %s: %v\n", box, err)
+			continue
+		}
+		srcKeys, err := fetchDupKeys(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fetch %s: %v\n", box, err)
+			continue
+		}
+		if _, err := imaputil.SelectMailbox(dst, dstBox, true); err != nil {
+			fmt.Fprintf(os.Stderr, "select %s: %v\n", dstBox, err)
+			continue
+		}
+		dstKeys, err := fetchDupKeys(dst)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fetch %s: %v\n", dstBox, err)
+			continue
+		}
+		surplus := []uint32{}
+		for k, uids := range dstKeys {
+			keep := len(srcKeys[k])
+			if keep == 0 || len(uids) <= keep {
+				continue
+			}
+			sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+			surplus = append(surplus, uids[keep:]...)
+		}
+		if len(surplus) == 0 {
+			continue
+		}
+		sort.Slice(surplus, func(i, j int) bool { return surplus[i] < surplus[j] })
+		if o.expunge {
+			if err := imaputil.CheckExpungeUIDs(dst, surplus); err != nil {
+				fmt.Fprintf(os.Stderr, "skip %s: %v; expunge or undelete them first, or use --expunge=false\n", dstBox, err)
+				continue
+			}
+		}
+		plans = append(plans, prunePlan{srcBox: box, dstBox: dstBox, uids: surplus})
+		total += len(surplus)
+	}
+	if total == 0 {
+		fmt.Println("No duplicates found.")
+		return nil
+	}
+
+	var summary strings.Builder
+	for _, p := range plans {
+		fmt.Fprintf(&summary, "%s: %d duplicate(s)\n", p.dstBox, len(p.uids))
+	}
+	fmt.Fprintf(&summary, "Total: %d message(s)\nExpunge: %v", total, o.expunge)
+	if !o.yes && !dryRun {
+		ok, err := runConfirmTUI("Confirm prune-duplicates", summary.String())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
+	var failed []string
+	deleted := 0
+	err = runCountWork(ctx, total, "Prune duplicates", func(ctx context.Context, progress chan<- int) error {
+		const chunkSize = 500
+		for _, p := range plans {
+			if ctx.Err() != nil {
+				break
+			}
+			if _, err := imaputil.SelectMailbox(dst, p.dstBox, dryRun); err != nil {
+				failed = append(failed, fmt.Sprintf("select %s: %v", p.dstBox, err))
+				continue
+			}
+			// a stop between chunks still expunges what was marked
+			var stored []uint32
+			for i := 0; i < len(p.uids) && ctx.Err() == nil; i += chunkSize {
+				end := min(i+chunkSize, len(p.uids))
+				if err := storeUIDs(dst, p.dstBox, p.uids[i:end], imap.AddFlags, []interface{}{imap.DeletedFlag}); err != nil {
+					failed = append(failed, fmt.Sprintf("store %s: %v", p.dstBox, err))
+					break
+				}
+				stored = append(stored, p.uids[i:end]...)
+				progress <- end - i
+			}
+			if len(stored) > 0 && o.expunge {
+				if err := expungeUIDs(dst, p.dstBox, stored); err != nil {
+					failed = append(failed, fmt.Sprintf("expunge %s: %v", p.dstBox, err))
+					continue
+				}
+			}
+			deleted += len(stored)
+		}
+		return ctx.Err()
+	})
+
+	for _, f := range failed {
+		fmt.Fprintln(os.Stderr, f)
+	}
+	if dryRun {
+		return err
+	}
+	fmt.Printf("Done: %d of %d duplicate(s) removed.\n", deleted, total)
+	if err != nil {
+		return fmt.Errorf("prune-duplicates stopped: %w", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d mailbox(es) could not be pruned", len(failed))
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type pruneMailOptions struct {
+	sourceOptions
+	mailboxes []string
+	messageCriteria
+	expunge bool
+	yes     bool
+}
+
+func addPruneMailFlags(cmd *cobra.Command) {
+	o := &pruneMailOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringArrayVar(&o.mailboxes, "mailbox", nil, "Mailbox to prune; * and % are LIST wildcards, e.g. 'Lists/*' (can be repeated)")
+	addCriteriaFlags(cmd, &o.messageCriteria)
+	cmd.Flags().BoolVar(&o.expunge, "expunge", true, "Permanently remove messages after marking as \\Deleted")
+	cmd.Flags().BoolVar(&o.yes, "yes", false, "Do not ask for confirmation")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// pruneMailPlan is the messages prune removes from one mailbox.
+type pruneMailPlan struct {
+	mailbox string
+	uids    []uint32
+}
+
+// runPruneMail marks the messages matching the criteria in the mailboxes
+// matching --mailbox \Deleted and expunges them, after a confirmation.
+func runPruneMail(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*pruneMailOptions)
+	if len(o.mailboxes) == 0 {
+		return fmt.Errorf("missing required flag: --mailbox")
+	}
+	if o.messageCriteria.empty() {
+		return fmt.Errorf("prune needs at least one of --before, --since, --from, --to, --subject, --unseen, --flagged (use delete to empty a mailbox)")
+	}
+	criteria, err := o.criteria()
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	boxes, err := matchingMailboxes(ctx, c, o.mailboxes)
+	if err != nil {
+		return err
+	}
+	if len(boxes) == 0 {
+		fmt.Println("No mailboxes matched.")
+		return nil
+	}
+
+	var plans []pruneMailPlan
+	total := 0
+	for _, box := range boxes {
+		if _, err := imaputil.SelectMailbox(c, box, true); err != nil {
+			if imaputil.ConnClosed(err) {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "select %s: %v\n", box, err)
+			continue
+		}
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "search %s: %v\n", box, err)
+			continue
+		}
+		if len(uids) == 0 {
+			continue
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+		if o.expunge {
+			if err := imaputil.CheckExpungeUIDs(c, uids); err != nil {
+				fmt.Fprintf(os.Stderr, "skip %s: %v; expunge or undelete them first, or use --expunge=false\n", box, err)
+				continue
+			}
+		}
+		plans = append(plans, pruneMailPlan{mailbox: box, uids: uids})
+		total += len(uids)
+	}
+	if total == 0 {
+		fmt.Println("No messages matched.")
+		return nil
+	}
+
+	var summary strings.Builder
+	for _, p := range plans {
+		fmt.Fprintf(&summary, "%s: %d message(s)\n", p.mailbox, len(p.uids))
+	}
+	fmt.Fprintf(&summary, "Total: %d message(s)\nExpunge: %v", total, o.expunge)
+	if !o.yes && !dryRun {
+		ok, err := runConfirmTUI("Confirm prune", summary.String())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
+	var failed []string
+	err = runCountWork(ctx, total, "Prune", func(ctx context.Context, progress chan<- int) error {
+		const chunkSize = 500
+		for _, p := range plans {
+			if ctx.Err() != nil {
+				break
+			}
+			if _, err := imaputil.SelectMailbox(c, p.mailbox, dryRun); err != nil {
+				failed = append(failed, fmt.Sprintf("select %s: %v", p.mailbox, err))
+				continue
+			}
+			// a stop between chunks still expunges what was marked
+			var stored []uint32
+			for i := 0; i < len(p.uids) && ctx.Err() == nil; i += chunkSize {
+				end := min(i+chunkSize, len(p.uids))
+				if err := storeUIDs(c, p.mailbox, p.uids[i:end], imap.AddFlags, []interface{}{imap.DeletedFlag}); err != nil {
+					failed = append(failed, fmt.Sprintf("store %s: %v", p.mailbox, err))
+					break
+				}
+				stored = append(stored, p.uids[i:end]...)
+				progress <- end - i
+			}
+			if len(stored) > 0 && o.expunge {
+				if err := expungeUIDs(c, p.mailbox, stored); err != nil {
+					failed = append(failed, fmt.Sprintf("expunge %s: %v", p.mailbox, err))
+				}
+			}
+		}
+		return ctx.Err()
+	})
+
+	for _, f := range failed {
+		fmt.Fprintln(os.Stderr, f)
+	}
+	if err != nil {
+		return fmt.Errorf("prune stopped: %w", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d mailbox(es) could not be pruned", len(failed))
+	}
+	return nil
+}
+
+// matchingMailboxes returns the selectable mailboxes of c, sorted, whose
+// name matches one of the LIST patterns (see imaputil.MatchPattern).
+func matchingMailboxes(ctx context.Context, c *client.Client, patterns []string) ([]string, error) {
+	delim, err := imaputil.Delimiter(c)
+	if err != nil {
+		return nil, fmt.Errorf("list delimiter: %w", err)
+	}
+	all, err := imaputil.ListMailboxes(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("list mailboxes: %w", err)
+	}
+	sort.Strings(all)
+	var boxes []string
+	for _, b := range all {
+		for _, p := range patterns {
+			if imaputil.MatchPattern(imaputil.DecodeMailboxName(p), delim, b) {
+				boxes = append(boxes, b)
+				break
+			}
+		}
+	}
+	return boxes, nil
+}
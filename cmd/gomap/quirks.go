@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap/client"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/quirks"
+)
+
+// serverQuirks returns the quirks profile of the server c is logged in to,
+// with the special folders it marks by SPECIAL-USE or XLIST attributes.
+func serverQuirks(c *client.Client, host string) quirks.Profile {
+	caps, _ := c.Capability()
+	p := quirks.Detect(host, imaputil.Greeting(c), caps)
+	uses, _ := imaputil.SpecialUse(c)
+	return p.WithSpecialUse(uses)
+}
+
+// printQuirks lists the workarounds applied for a server (verbose output).
+func printQuirks(side string, p quirks.Profile) {
+	if p.Provider == "" {
+		return
+	}
+	fmt.Printf("%s server is %s, applying workarounds:\n", side, p.Provider)
+	for _, q := range p.Quirks {
+		fmt.Printf("  - %s: %s\n", q.Name, q.Description)
+	}
+}
+
+// applyQuirks wraps the mailbox filter keep for a source with profile p:
+// the provider's duplicate views are skipped unless --include selects
+// them, and its own special folder names follow --skip-*. It also caps
+// the append rate for the destination profile dst when --max-rate is
+// unset.
+func (o *copyOptions) applyQuirks(keep func(string) bool, p, dst quirks.Profile) func(string) bool {
+	if o.maxRate == 0 && dst.MaxRate > 0 {
+		o.maxRate = dst.MaxRate
+	}
+	return func(name string) bool {
+		if !keep(name) {
+			return false
+		}
+		if p.SkipFolders != nil && p.SkipFolders.MatchString(name) && o.include == "" {
+			return false
+		}
+		switch p.Role(name) {
+		case "drafts":
+			return !o.skipSpecial && !o.skipDrafts
+		case "sent":
+			return !o.skipSpecial && !o.skipSent
+		case "junk":
+			return o.junkAs != "" || (!o.skipSpecial && !o.skipJunk)
+		case "trash":
+			return o.trashAs != "" || (!o.skipSpecial && !o.skipTrash)
+		}
+		return true
+	}
+}
+
+// quirkFolderMap adds the --trash-as and --junk-as targets for the
+// special folders of the source profile p to m. Other special folders go
+// to the destination folder with the same role in profile dst, e.g. a
+// French "Envoyés" into "Sent Items", so mail does not end up next to
+// the folder the destination's clients use.
+func (o *copyOptions) quirkFolderMap(m map[string]string, boxes []string, p, dst quirks.Profile) map[string]string {
+	for _, b := range boxes {
+		if _, ok := m[b]; ok {
+			continue
+		}
+		role := p.Role(b)
+		switch {
+		case role == "":
+			continue
+		case role == "trash" && o.trashAs != "":
+			m[b] = o.trashAs
+		case role == "junk" && o.junkAs != "":
+			m[b] = o.junkAs
+		default:
+			if to := dst.Folder(role); to != "" && to != b {
+				m[b] = to
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+type quotaOptions struct {
+	sourceOptions
+	mailbox string
+	json    bool
+}
+
+func addQuotaFlags(cmd *cobra.Command) {
+	o := &quotaOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringVar(&o.mailbox, "mailbox", "INBOX", "Mailbox whose quota roots are shown")
+	cmd.Flags().BoolVar(&o.json, "json", false, "Print the quota as JSON")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// quotaLine is one resource of a quota root in the output of quota.
+// Storage is in bytes.
+type quotaLine struct {
+	Root     string `json:"root"`
+	Resource string `json:"resource"`
+	Usage    int64  `json:"usage"`
+	Limit    int64  `json:"limit"`
+}
+
+// runQuota prints the quota roots of a mailbox (GETQUOTAROOT) with the
+// usage and limit of each resource.
+func runQuota(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*quotaOptions)
+
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	if has, err := c.Support("QUOTA"); err != nil {
+		return err
+	} else if !has {
+		return fmt.Errorf("the server does not support QUOTA (RFC 9208)")
+	}
+	mailbox := imaputil.DecodeMailboxName(o.mailbox)
+	roots, err := imaputil.QuotaRoots(c, mailbox)
+	if err != nil {
+		return fmt.Errorf("getquotaroot %s: %w", mailbox, err)
+	}
+	lines := []quotaLine{}
+	for _, root := range roots {
+		for _, res := range root.Resources {
+			l := quotaLine{Root: root.Name, Resource: res.Name, Usage: res.Usage, Limit: res.Limit}
+			if res.Name == "STORAGE" {
+				l.Usage, l.Limit = l.Usage<<10, l.Limit<<10
+			}
+			lines = append(lines, l)
+		}
+	}
+
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(struct {
+			Mailbox string      `json:"mailbox"`
+			Quota   []quotaLine `json:"quota"`
+		}{mailbox, lines})
+	}
+	if len(lines) == 0 {
+		fmt.Printf("No quota set for %s.\n", mailbox)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOT\tRESOURCE\tUSED\tLIMIT\tUSE")
+	for _, l := range lines {
+		root := l.Root
+		if root == "" {
+			root = `""`
+		}
+		used, limit := strconv.FormatInt(l.Usage, 10), strconv.FormatInt(l.Limit, 10)
+		if l.Resource == "STORAGE" {
+			used, limit = formatBytes(l.Usage), formatBytes(l.Limit)
+		}
+		use := "-"
+		if l.Limit > 0 {
+			use = fmt.Sprintf("%d%%", l.Usage*100/l.Limit)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", root, l.Resource, used, limit, use)
+	}
+	return w.Flush()
+}
+
+// warnQuota logs a warning before a copy when the destination storage
+// quota has less room than the selected source mailboxes still have to
+// copy. Where the resume state has copied messages of a mailbox, only the
+// sizes of the messages above its highest copied UID (and not marked done)
+// are added up; other mailboxes are sized with STATUS=SIZE, else estimated
+// from a sample of message sizes. Nothing is checked when the destination
+// sets no quota.
+func (o *copyOptions) warnQuota(src, dst *client.Client, boxes []string, st *state.State) {
+	q, ok, err := imaputil.StorageQuota(dst, "INBOX")
+	if err != nil {
+		log.Printf("[quota] cannot read the destination quota, skipping the check: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	size, exact, err := o.pendingSize(src, boxes, st)
+	if err != nil {
+		log.Printf("[quota] cannot size the source mailboxes, skipping the check: %v", err)
+		return
+	}
+	if size <= q.Free() {
+		return
+	}
+	estimate := formatBytes(size)
+	if !exact {
+		estimate = "about " + estimate
+	}
+	log.Printf("[quota] warning: the destination quota has %s free of %s, but %s remain to be copied from the selected source mailboxes; the copy may fail with OVERQUOTA", formatBytes(q.Free()), formatBytes(q.Limit), estimate)
+}
+
+// pendingSize returns the total size of the messages of boxes a copy with
+// the resume state st still has to copy, and whether it is exact.
+func (o *copyOptions) pendingSize(src *client.Client, boxes []string, st *state.State) (size int64, exact bool, err error) {
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusUidValidity}
+	hasSize, _ := src.Support("STATUS=SIZE")
+	if hasSize {
+		items = append(items, "SIZE")
+	}
+	exact = true
+	for _, b := range boxes {
+		status, err := src.Status(b, items)
+		if err != nil {
+			return 0, false, fmt.Errorf("status %s: %w", b, err)
+		}
+		if status.Messages == 0 {
+			continue
+		}
+		var maxUID uint32
+		if !o.ignoreState {
+			if v := st.GetUIDValidity(b); v == 0 || v == status.UidValidity {
+				maxUID = st.GetMaxUID(b)
+			}
+		}
+		if maxUID == 0 {
+			if v, ok := status.Items["SIZE"]; ok {
+				n, _ := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+				size += n
+				continue
+			}
+		}
+		if _, err := imaputil.SelectMailbox(src, b, true); err != nil {
+			return 0, false, fmt.Errorf("select %s: %w", b, err)
+		}
+		if maxUID == 0 {
+			n, sizeExact, err := imaputil.MailboxSize(src, status.Messages, 200)
+			if err != nil {
+				return 0, false, fmt.Errorf("fetch sizes of %s: %w", b, err)
+			}
+			size += n
+			exact = exact && sizeExact
+			continue
+		}
+		sizes, err := imaputil.SizesAbove(src, maxUID)
+		if err != nil {
+			return 0, false, fmt.Errorf("fetch sizes of %s: %w", b, err)
+		}
+		uids := make([]uint32, 0, len(sizes))
+		for uid := range sizes {
+			uids = append(uids, uid)
+		}
+		for _, uid := range st.SkipDone(b, uids) {
+			size += int64(sizes[uid])
+		}
+	}
+	return size, exact, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type rawOptions struct {
+	host       string
+	port       int
+	user       string
+	pass       string
+	passPrompt bool
+	identity   string
+	url        string
+	insecure   bool
+	startTLS   bool
+}
+
+func addRawFlags(cmd *cobra.Command) {
+	o := &rawOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.host, "host", "", "IMAP host")
+	cmd.Flags().IntVar(&o.port, "port", 993, "IMAP port")
+	cmd.Flags().StringVar(&o.user, "user", "", "IMAP username")
+	cmd.Flags().StringVar(&o.pass, "pass", "", "IMAP password")
+	cmd.Flags().BoolVar(&o.passPrompt, "pass-prompt", false, "Prompt for the IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.identity, "identity", "", "Use the IMAP account of this identity from the config")
+	cmd.Flags().StringVar(&o.url, "url", "", "Server as a connection URL, e.g. imaps://user@host:993 (instead of --host, --port and --user)")
+	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
+	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// rawRefused are commands that would break the session gomap manages:
+// they change the connection or wait for client input the passthrough
+// cannot give.
+var rawRefused = map[string]string{
+	"LOGIN":        "the session is already authenticated",
+	"AUTHENTICATE": "the session is already authenticated",
+	"STARTTLS":     "use --starttls",
+	"COMPRESS":     "the connection cannot be switched",
+	"IDLE":         "it waits for DONE; use 'gomap tail' instead",
+	"APPEND":       "literals are not supported; use 'gomap filter'",
+	"LOGOUT":       "the session logs out when the command ends",
+}
+
+// runRaw executes IMAP commands verbatim in an authenticated session and
+// prints the server's responses. Commands come from the arguments, or
+// one per line from stdin when there are none.
+func runRaw(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*rawOptions)
+	if o.identity != "" {
+		_, acc, _, err := lookupIdentity(o.identity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{host: &o.host, port: &o.port, user: &o.user, pass: &o.pass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if err := applyEndpoints(cmd, endpointFlag{loginTarget{host: &o.host, port: &o.port, user: &o.user, pass: &o.pass, startTLS: &o.startTLS, insecure: &o.insecure}, "imap", o.url}); err != nil {
+		return err
+	}
+	if o.passPrompt && o.pass == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if perr != nil {
+			return fmt.Errorf("read password: %w", perr)
+		}
+		o.pass = string(b)
+	}
+	if o.host == "" || o.user == "" || o.pass == "" {
+		return fmt.Errorf("missing required flags: --host, --user, --pass")
+	}
+
+	c, err := imaputil.DialAndLogin(cmd.Context(), o.host, o.port, o.user, o.pass, o.startTLS, &tls.Config{InsecureSkipVerify: o.insecure})
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer c.Logout()
+
+	failed := 0
+	run := func(line string) error {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil
+		}
+		name, _, _ := strings.Cut(line, " ")
+		if why, ok := rawRefused[strings.ToUpper(name)]; ok {
+			fmt.Fprintf(os.Stderr, "refused %s: %s\n", strings.ToUpper(name), why)
+			failed++
+			return nil
+		}
+		return perform("send "+line, func() error {
+			status, err := c.Execute(rawCommand(line), responses.HandlerFunc(func(resp imap.Resp) error {
+				switch resp := resp.(type) {
+				case *imap.DataResp:
+					fmt.Println(formatRawFields("*", resp.Fields))
+				case *imap.StatusResp:
+					fmt.Println(formatRawStatus(resp))
+				default:
+					return responses.ErrUnhandled
+				}
+				return nil
+			}))
+			if err != nil {
+				return err
+			}
+			fmt.Println(formatRawStatus(status))
+			if status.Type != imap.StatusRespOk {
+				failed++
+			}
+			return nil
+		})
+	}
+
+	if len(args) > 0 {
+		for _, a := range args {
+			if err := run(a); err != nil {
+				return err
+			}
+		}
+	} else {
+		interactive := term.IsTerminal(int(os.Stdin.Fd()))
+		in := bufio.NewReader(os.Stdin)
+		for {
+			if interactive {
+				fmt.Fprint(os.Stderr, "imap> ")
+			}
+			line, rerr := in.ReadString('\n')
+			if err := run(line); err != nil {
+				return err
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return rerr
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d command(s) failed", failed)
+	}
+	return nil
+}
+
+// rawCommand is an IMAP command line sent as typed, after the tag.
+type rawCommand string
+
+func (r rawCommand) Command() *imap.Command {
+	name, rest, _ := strings.Cut(string(r), " ")
+	cmd := &imap.Command{Name: name}
+	if rest != "" {
+		cmd.Arguments = []interface{}{imap.RawString(rest)}
+	}
+	return cmd
+}
+
+// rawWidth is the line length above which parenthesized lists are
+// broken up, one element per line.
+const rawWidth = 100
+
+// formatRawStatus prints a status response the way the server sent it.
+func formatRawStatus(s *imap.StatusResp) string {
+	var b strings.Builder
+	b.WriteString(s.Tag + " " + string(s.Type))
+	if s.Code != "" {
+		b.WriteString(" [" + string(s.Code))
+		for _, a := range s.Arguments {
+			b.WriteString(" " + formatRawField(readLiterals(a), "", true))
+		}
+		b.WriteString("]")
+	}
+	if s.Info != "" {
+		b.WriteString(" " + s.Info)
+	}
+	return b.String()
+}
+
+// formatRawFields prints an untagged data response. Lists that do not fit
+// in rawWidth columns are indented one element per line, and multi-line
+// literals (message bodies) follow on their own lines.
+func formatRawFields(prefix string, fields []interface{}) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, f := range fields {
+		b.WriteString(" " + formatRawField(readLiterals(f), "", false))
+	}
+	return b.String()
+}
+
+// rawLiteral is the content of a literal, read once so a list can be
+// formatted twice.
+type rawLiteral []byte
+
+// readLiterals replaces the literals in f by their content.
+func readLiterals(f interface{}) interface{} {
+	switch f := f.(type) {
+	case imap.Literal:
+		data, _ := io.ReadAll(f)
+		return rawLiteral(data)
+	case []interface{}:
+		out := make([]interface{}, len(f))
+		for i, e := range f {
+			out[i] = readLiterals(e)
+		}
+		return out
+	}
+	return f
+}
+
+func formatRawField(f interface{}, indent string, flat bool) string {
+	switch f := f.(type) {
+	case nil:
+		return "NIL"
+	case string:
+		return quoteRaw(f)
+	case rawLiteral:
+		data := f
+		if flat || !strings.Contains(string(data), "\n") {
+			return quoteRaw(string(data))
+		}
+		body := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		return fmt.Sprintf("{%d}\n%s  %s", len(data), indent, strings.ReplaceAll(body, "\n", "\n"+indent+"  "))
+	case []interface{}:
+		parts := make([]string, len(f))
+		for i, e := range f {
+			parts[i] = formatRawField(e, indent+"  ", true)
+		}
+		line := "(" + strings.Join(parts, " ") + ")"
+		if flat || len(indent)+len(line) <= rawWidth {
+			return line
+		}
+		if isItemList(f) {
+			// FETCH-style "NAME value" pairs, one pair per line
+			parts = parts[:0]
+			for i := 0; i < len(f); i += 2 {
+				parts = append(parts, f[i].(string)+" "+formatRawField(f[i+1], indent+"  ", false))
+			}
+		} else {
+			for i, e := range f {
+				parts[i] = formatRawField(e, indent+"  ", false)
+			}
+		}
+		return "(\n" + indent + "  " + strings.Join(parts, "\n"+indent+"  ") + "\n" + indent + ")"
+	}
+	return fmt.Sprint(f)
+}
+
+// isItemList reports whether l alternates item names and values, like
+// the data of a FETCH response.
+func isItemList(l []interface{}) bool {
+	if len(l) == 0 || len(l)%2 != 0 {
+		return false
+	}
+	for i := 0; i < len(l); i += 2 {
+		name, ok := l[i].(string)
+		if !ok || name == "" || name != strings.ToUpper(name) || strings.HasPrefix(name, "\\") {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteRaw quotes a string field unless it reads as an atom.
+func quoteRaw(s string) string {
+	if s != "" && !strings.ContainsAny(s, " ()\"{\r\n") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", `\r`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// receiveRule is a compiled receive_rules entry of the config.
+type receiveRule struct {
+	re  *regexp.Regexp
+	cfg receiveRuleConfig
+}
+
+// receiveTarget is one output of a backup run and the mailboxes it gets.
+type receiveTarget struct {
+	o     *receiveOptions
+	boxes []string
+}
+
+// loadReceiveRules compiles the receive_rules of the config and checks
+// the output each rule leads to, so a bad rule fails before connecting.
+func loadReceiveRules(o *receiveOptions) ([]receiveRule, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]receiveRule, 0, len(cfg.ReceiveRules))
+	for i, rc := range cfg.ReceiveRules {
+		if rc.Mailbox == "" {
+			return nil, fmt.Errorf("receive rule %d: missing mailbox regex", i+1)
+		}
+		re, err := regexp.Compile(rc.Mailbox)
+		if err != nil {
+			return nil, fmt.Errorf("receive rule %d: invalid mailbox regex: %w", i+1, err)
+		}
+		r := receiveRule{re: re, cfg: rc}
+		if err := r.apply(o).validateOutput(); err != nil {
+			return nil, fmt.Errorf("receive rule %d (%s): %w", i+1, rc.Mailbox, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// apply returns a copy of o that writes where the rule says. A rule that
+// sets a format brings its own compress and split_by; the single-file
+// extras (--metadata, --exec-per-message) only carry over to single-file
+// rules.
+func (r receiveRule) apply(o *receiveOptions) *receiveOptions {
+	ro := *o
+	if r.cfg.Format != "" {
+		ro.format = r.cfg.Format
+		ro.compress = r.cfg.Compress
+		ro.splitBy = r.cfg.SplitBy
+		if ro.format != "single-file" {
+			ro.execPerMsg = ""
+			ro.metadata = false
+		}
+	}
+	if r.cfg.OutputDir != "" {
+		ro.outputDir = expandHome(r.cfg.OutputDir)
+		ro.output = ""
+	}
+	return &ro
+}
+
+// receiveTargets groups boxes by the first rule that matches them, in
+// the order the mailboxes come. Mailboxes no rule matches go to the
+// output of the flags.
+func receiveTargets(o *receiveOptions, rules []receiveRule, boxes []string) []receiveTarget {
+	var targets []receiveTarget
+	index := map[int]int{} // rule (-1: flags) -> target
+	for _, box := range boxes {
+		rule := -1
+		for i, r := range rules {
+			if matchMailbox(r.re, box) {
+				rule = i
+				break
+			}
+		}
+		t, ok := index[rule]
+		if !ok {
+			ro := o
+			if rule >= 0 {
+				ro = rules[rule].apply(o)
+			}
+			t = len(targets)
+			index[rule] = t
+			targets = append(targets, receiveTarget{o: ro})
+		}
+		targets[t].boxes = append(targets[t].boxes, box)
+	}
+	return targets
+}
+
+// validateOutput checks that the format options of o fit together.
+func (o *receiveOptions) validateOutput() error {
+	if o.format != "single-file" && o.format != "mbox" && o.format != "tar" && o.format != "sqlite" && o.format != "maildir" {
+		return fmt.Errorf("invalid --format: %s (must be 'single-file', 'mbox', 'tar', 'sqlite' or 'maildir')", o.format)
+	}
+	if o.compress && o.format != "mbox" {
+		return fmt.Errorf("--compress requires --format mbox")
+	}
+	if o.splitBy != "" {
+		if o.splitBy != "year" && o.splitBy != "month" {
+			return fmt.Errorf("invalid --split-by: %s (must be 'year' or 'month')", o.splitBy)
+		}
+		if o.format != "mbox" {
+			return fmt.Errorf("--split-by requires --format mbox")
+		}
+	}
+	if o.execPerMsg != "" && o.format != "single-file" {
+		return fmt.Errorf("--exec-per-message requires --format single-file")
+	}
+	if o.metadata && o.format != "single-file" {
+		return fmt.Errorf("--metadata requires --format single-file")
+	}
+	if o.output != "" {
+		if o.format == "sqlite" || o.format == "maildir" {
+			return fmt.Errorf("--format %s cannot be used with --output", o.format)
+		}
+		if o.execPerMsg != "" {
+			return fmt.Errorf("--exec-per-message cannot be used with --output")
+		}
+	}
+	return nil
+}
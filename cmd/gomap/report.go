@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/artifact"
+	"github.com/pepperpark/gomap/internal/runlog"
+)
+
+// newRunSummary starts the summary of an IMAP copy run.
+func (o *copyOptions) newRunSummary() *runlog.Summary {
+	dst := o.dstUser + "@" + o.dstHost
+	if o.dstLMTP != "" {
+		dst = "LMTP " + o.dstLMTP
+	}
+	return runlog.New(o.srcUser+"@"+o.srcHost, dst)
+}
+
+// failed returns the syncer's Failed callback for --continue-on-error,
+// which records the messages in s, or nil without the flag.
+func (o *copyOptions) failed(s *runlog.Summary) func(mailbox string, uid uint32, err error) {
+	if !o.continueOnError {
+		return nil
+	}
+	return s.Failed
+}
+
+// writeFailures writes the messages --continue-on-error went past to
+// --failures-report. Like the run summary, a report that cannot be
+// written is only logged.
+func (o *copyOptions) writeFailures(s *runlog.Summary) {
+	failures := s.Failures()
+	if len(failures) == 0 {
+		return
+	}
+	f, err := os.Create(o.failuresReport)
+	if err == nil {
+		err = runlog.WriteFailures(f, failures, strings.EqualFold(filepath.Ext(o.failuresReport), ".csv"))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("write failures report: %v", err)
+		return
+	}
+	fmt.Printf("%d message(s) could not be copied; see %s\n", len(failures), o.failuresReport)
+}
+
+// saveRunSummary stores the summary in the --artifacts store for 'gomap
+// report' and applies the retention limits. Dry runs are not stored, and
+// failures are only logged: the copy itself went through. It also runs
+// after an interrupt, so the summary of a cut-short run is kept.
+func (o *copyOptions) saveRunSummary(ctx context.Context, s *runlog.Summary, errs []error) {
+	if dryRun || o.artifacts == "" {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	s.Finish(errs)
+	store, err := artifact.Open(o.artifacts)
+	if err != nil {
+		log.Printf("save run summary: %v", err)
+		return
+	}
+	data, err := s.Marshal()
+	if err == nil {
+		err = store.Put(ctx, artifact.KindReports, s.Name(), data)
+	}
+	if err != nil {
+		log.Printf("save run summary to %s: %v", store, err)
+		return
+	}
+	r := artifact.Retention{Keep: o.artifactKeep, MaxAge: time.Duration(o.artifactMaxAgeDays) * 24 * time.Hour}
+	removed, err := artifact.Prune(ctx, store, artifact.KindReports, r, time.Now())
+	if err != nil {
+		log.Printf("prune reports in %s: %v", store, err)
+	}
+	if o.verbose && len(removed) > 0 {
+		log.Printf("removed %d old report(s) from %s", len(removed), store)
+	}
+}
+
+type reportOptions struct {
+	artifacts string
+}
+
+func addReportFlags(cmd *cobra.Command) {
+	o := &reportOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.artifacts, "artifacts", "gomap-artifacts", "Artifact store the copy runs wrote their reports to: a directory or s3://bucket/prefix")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// reportIDs returns the IDs of the stored run summaries, oldest first.
+func reportIDs(ctx context.Context, store artifact.Store) ([]string, error) {
+	objs, err := store.List(ctx, artifact.KindReports)
+	if err != nil {
+		return nil, fmt.Errorf("list reports in %s: %w", store, err)
+	}
+	ids := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		if id, ok := strings.CutSuffix(obj.Name, ".json"); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// loadReport loads the run ref: a run ID, a unique ID prefix or the path
+// of a summary file.
+func loadReport(ctx context.Context, store artifact.Store, ids []string, ref string) (*runlog.Summary, error) {
+	var data []byte
+	if b, err := os.ReadFile(ref); err == nil && strings.HasSuffix(ref, ".json") {
+		data = b
+	} else {
+		id, err := runlog.Resolve(ids, ref)
+		if err != nil {
+			return nil, fmt.Errorf("%w in %s", err, store)
+		}
+		if data, err = store.Get(ctx, artifact.KindReports, id+".json"); err != nil {
+			return nil, err
+		}
+	}
+	s, err := runlog.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse report %s: %w", ref, err)
+	}
+	return s, nil
+}
+
+func runReportList(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*reportOptions)
+	ctx := cmd.Context()
+	store, err := artifact.Open(o.artifacts)
+	if err != nil {
+		return err
+	}
+	ids, err := reportIDs(ctx, store)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		fmt.Printf("No runs in %s.\n", store)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED\tFOLDERS\tCOPIED\tERRORS")
+	for _, id := range ids {
+		s, err := loadReport(ctx, store, ids, id)
+		if err != nil {
+			return err
+		}
+		copied := 0
+		for _, mb := range s.Mailboxes {
+			copied += mb.Copied
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", s.ID, s.Started.Format("2006-01-02 15:04"), len(s.Mailboxes), copied, len(s.Errors))
+	}
+	return w.Flush()
+}
+
+// runReportDiff compares two stored runs, by default the last two.
+func runReportDiff(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*reportOptions)
+	ctx := cmd.Context()
+	store, err := artifact.Open(o.artifacts)
+	if err != nil {
+		return err
+	}
+	ids, err := reportIDs(ctx, store)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		if len(ids) < 2 {
+			return fmt.Errorf("need two runs in %s to compare, found %d", store, len(ids))
+		}
+		args = ids[len(ids)-2:]
+	}
+	a, err := loadReport(ctx, store, ids, args[0])
+	if err != nil {
+		return err
+	}
+	b, err := loadReport(ctx, store, ids, args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Print(renderDiff(runlog.Compare(a, b)))
+	return nil
+}
+
+// renderDiff formats a diff for the terminal. Folders that lost messages
+// on the source come first and are marked, as they are the usual sign of
+// trouble (a client deleting or moving mail, a broken filter).
+func renderDiff(d *runlog.Diff) string {
+	var b strings.Builder
+	for _, r := range []struct {
+		label string
+		s     *runlog.Summary
+	}{{"A", d.A}, {"B", d.B}} {
+		fmt.Fprintf(&b, "%s: %s  %s", r.label, r.s.ID, r.s.Started.Format("2006-01-02 15:04"))
+		if !r.s.Finished.IsZero() {
+			fmt.Fprintf(&b, ", took %s", r.s.Finished.Sub(r.s.Started).Round(time.Second))
+		}
+		if r.s.Source != "" {
+			fmt.Fprintf(&b, "  (%s -> %s)", r.s.Source, r.s.Destination)
+		}
+		b.WriteString("\n")
+	}
+	if len(d.NewFolders) == 0 && len(d.RemovedFolders) == 0 && len(d.Changed) == 0 && len(d.NewErrors) == 0 && len(d.ResolvedErrors) == 0 {
+		b.WriteString("\nNo changes.\n")
+		return b.String()
+	}
+	if len(d.NewFolders) > 0 {
+		b.WriteString("\nNew folders:\n")
+		for _, name := range d.NewFolders {
+			mb := d.B.Mailboxes[name]
+			fmt.Fprintf(&b, "  + %s (%d messages, %d copied)\n", name, mb.Messages, mb.Copied)
+		}
+	}
+	if len(d.RemovedFolders) > 0 {
+		b.WriteString("\nFolders missing in B:\n")
+		for _, name := range d.RemovedFolders {
+			fmt.Fprintf(&b, "  - %s (had %d messages)\n", name, d.A.Mailboxes[name].Messages)
+		}
+	}
+	if len(d.Changed) > 0 {
+		b.WriteString("\nMessage deltas (source size A -> B, copied A -> B):\n")
+		w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		for _, c := range d.Changed {
+			mark := ""
+			if c.Delta() < 0 && c.Before > 0 {
+				mark = fmt.Sprintf("\tshrunk by %d%%", -c.Delta()*100/int(c.Before))
+			}
+			fmt.Fprintf(w, "  %s\t%d -> %d\t%+d\tcopied %d -> %d%s\n", c.Mailbox, c.Before, c.After, c.Delta(), c.CopiedA, c.CopiedB, mark)
+		}
+		w.Flush()
+	}
+	fmt.Fprintf(&b, "\nErrors: %d -> %d (%+d)\n", len(d.A.Errors), len(d.B.Errors), len(d.B.Errors)-len(d.A.Errors))
+	for _, e := range d.NewErrors {
+		fmt.Fprintf(&b, "  + %s\n", e)
+	}
+	for _, e := range d.ResolvedErrors {
+		fmt.Fprintf(&b, "  - %s\n", e)
+	}
+	return b.String()
+}
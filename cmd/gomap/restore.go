@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/maildir"
+)
+
+type restoreOptions struct {
+	copyOptions
+	inputDir string
+	format   string // auto | single-file | mbox | maildir
+}
+
+func addRestoreFlags(cmd *cobra.Command) {
+	o := &restoreOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.inputDir, "input-dir", "gomap-download", "Directory written by 'gomap backup'")
+	cmd.Flags().StringVar(&o.format, "format", "auto", "Backup format: auto, single-file, mbox or maildir")
+	cmd.Flags().StringVar(&o.mboxFormat, "mbox-format", "auto", "With mbox backups: format variant (auto, mboxo, mboxrd, mboxcl, mboxcl2)")
+	cmd.Flags().StringVar(&o.dstHost, "dst-host", "", "Destination IMAP host")
+	cmd.Flags().IntVar(&o.dstPort, "dst-port", 993, "Destination IMAP port")
+	cmd.Flags().StringVar(&o.dstUser, "dst-user", "", "Destination IMAP username")
+	cmd.Flags().StringVar(&o.dstPass, "dst-pass", "", "Destination IMAP password")
+	cmd.Flags().BoolVar(&o.dstPassPrompt, "dst-pass-prompt", false, "Prompt for destination IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.dstIdentity, "dst-identity", "", "Use the IMAP account of this identity from the config as destination")
+	cmd.Flags().StringVar(&o.dstURL, "dst", "", "Destination as a connection URL, e.g. imaps://user@host:993 (instead of --dst-host, --dst-port and --dst-user)")
+	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
+	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
+	cmd.Flags().StringVar(&o.dstMbox, "dst-mailbox", "", "Restore below this parent folder instead of the original hierarchy")
+	cmd.Flags().StringArrayVar(&o.mapPairs, "map", nil, "Folder mapping src=dst (can be repeated)")
+	cmd.Flags().BoolVar(&o.noSubscribe, "no-subscribe", false, "Do not SUBSCRIBE the restored folders")
+	cmd.Flags().StringArrayVar(&o.flagMapPairs, "flag-map", nil, "Flag mapping src=dst for restored messages, e.g. '$label1=$Important'; 'src=' drops the flag (can be repeated)")
+	cmd.Flags().BoolVar(&o.stripFlags, "strip-nonstandard-flags", false, "Drop flags other than the system flags and registered keywords, as copy does")
+	cmd.Flags().StringVar(&o.stateFile, "state-file", "gomap-state.json", "Path to resume state JSON")
+	cmd.Flags().BoolVar(&o.ignoreState, "ignore-state", false, "Ignore resume state (upload everything again)")
+	cmd.Flags().Float64Var(&o.maxRate, "max-rate", 0, "Upper limit for the adaptive append rate in messages per second (0 = no limit)")
+	cmd.Flags().IntVar(&o.maxAppendsPerMin, "max-appends-per-min", 0, "Upper limit for appends per minute, for providers that count them that way (0 = no limit)")
+	cmd.Flags().BoolVar(&o.noPacing, "no-pacing", false, "Disable adaptive pacing of destination appends")
+	cmd.Flags().StringVar(&o.bwLimit, "bwlimit", "", "Limit the IMAP connection to this many bytes per second in each direction, e.g. 5M (K, M and G count in 1024s)")
+	cmd.Flags().BoolVar(&o.verbose, "verbose", false, "Enable detailed per-mailbox logs")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// runRestore uploads a local backup back to an IMAP server. It reuses the
+// mbox and Maildir importers of copy, and the single-file importer for
+// <uid>.eml trees, so resume state, pacing and --map work the same way.
+func runRestore(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*restoreOptions)
+	if o.dstIdentity != "" {
+		_, acc, _, err := lookupIdentity(o.dstIdentity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if err := o.applyEndpoints(cmd); err != nil {
+		return err
+	}
+	if o.dstPassPrompt && o.dstPass == "" {
+		fmt.Fprint(os.Stderr, "Destination password: ")
+		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if perr != nil {
+			return fmt.Errorf("read destination password: %w", perr)
+		}
+		o.dstPass = string(b)
+	}
+	if o.dstHost == "" || o.dstUser == "" || o.dstPass == "" {
+		return fmt.Errorf("missing required flags: --dst-host, --dst-user, --dst-pass")
+	}
+	if err := withBandwidthLimit(cmd, o.bwLimit); err != nil {
+		return err
+	}
+	var err error
+	if o.flagMap, err = imaputil.NewFlagMap(o.flagMapPairs, o.stripFlags); err != nil {
+		return err
+	}
+
+	format := o.format
+	if format == "auto" {
+		if format, err = detectBackupFormat(o.inputDir); err != nil {
+			return err
+		}
+		if o.verbose {
+			fmt.Fprintf(os.Stderr, "Detected %s backup in %s\n", format, o.inputDir)
+		}
+	}
+	switch format {
+	case "single-file":
+		o.emlPath = o.inputDir
+		return runCopyEml(cmd, &o.copyOptions)
+	case "mbox":
+		o.mboxPath = o.inputDir
+		o.mboxMergeSplit = true
+		return runCopyMBOX(cmd, &o.copyOptions)
+	case "maildir":
+		o.maildirPath = o.inputDir
+		return runCopyMaildir(cmd, &o.copyOptions)
+	}
+	return fmt.Errorf("invalid --format: %s (must be 'auto', 'single-file', 'mbox' or 'maildir')", o.format)
+}
+
+// errFoundFile stops the backup format scan at the first message file.
+var errFoundFile = errors.New("found")
+
+// detectBackupFormat tells which backup format dir holds: a Maildir++
+// tree, <uid>.eml files or mbox files. Formats that cannot be restored
+// directly get an error saying what to do instead.
+func detectBackupFormat(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("open backup: %w", err)
+	}
+	if _, err := maildir.Folders(dir); err == nil {
+		return "maildir", nil
+	}
+	var format string
+	var tarball string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name := d.Name()
+		switch {
+		case name == mailstoreFile:
+			return fmt.Errorf("%s is a sqlite backup; restore is not supported for it, use 'gomap grep --mbox' to export messages", p)
+		case strings.HasSuffix(name, ".tar.gz"):
+			tarball = p
+		case strings.HasSuffix(name, ".eml"):
+			if _, err := strconv.ParseUint(strings.TrimSuffix(name, ".eml"), 10, 32); err == nil {
+				format = "single-file"
+				return errFoundFile
+			}
+		case isMboxFile(p):
+			format = "mbox"
+			return errFoundFile
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errFoundFile) {
+		return "", err
+	}
+	if format == "" {
+		if tarball != "" {
+			return "", fmt.Errorf("%s is a tar backup; extract it first (tar -xzf) and restore the extracted directory", tarball)
+		}
+		return "", fmt.Errorf("no backup found in %s (expected <uid>.eml files, mbox files or a Maildir++ tree)", dir)
+	}
+	return format, nil
+}
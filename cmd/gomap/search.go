@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type searchOptions struct {
+	sourceOptions
+	mailbox string
+	messageCriteria
+	skipDeleted bool
+	limit       int
+	json        bool
+}
+
+// messageCriteria are the filter flags of search and prune.
+type messageCriteria struct {
+	from    string
+	to      string
+	subject string
+	since   string
+	before  string
+	unseen  bool
+	flagged bool
+}
+
+func addCriteriaFlags(cmd *cobra.Command, o *messageCriteria) {
+	cmd.Flags().StringVar(&o.from, "from", "", "Only messages whose From contains this text")
+	cmd.Flags().StringVar(&o.to, "to", "", "Only messages whose To contains this text")
+	cmd.Flags().StringVar(&o.subject, "subject", "", "Only messages whose Subject contains this text")
+	cmd.Flags().StringVar(&o.since, "since", "", "Only messages with INTERNALDATE >= since (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&o.before, "before", "", "Only messages with INTERNALDATE before this day (YYYY-MM-DD, or an age like 90d, 6m, 2y)")
+	cmd.Flags().BoolVar(&o.unseen, "unseen", false, "Only messages without \\Seen (like copy --only-unseen)")
+	cmd.Flags().BoolVar(&o.flagged, "flagged", false, "Only messages with \\Flagged (like copy --only-flagged)")
+}
+
+func addSearchFlags(cmd *cobra.Command) {
+	o := &searchOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringVar(&o.mailbox, "mailbox", "INBOX", "Mailbox to search")
+	addCriteriaFlags(cmd, &o.messageCriteria)
+	cmd.Flags().BoolVar(&o.skipDeleted, "skip-deleted", false, "Leave out messages marked \\Deleted (like copy --skip-deleted)")
+	cmd.Flags().IntVar(&o.limit, "limit", 0, "Print only the newest N matches, by UID (0 = all)")
+	cmd.Flags().BoolVar(&o.json, "json", false, "Print the matches as JSON")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// searchHit is one message found by search.
+type searchHit struct {
+	UID     uint32    `json:"uid"`
+	Date    time.Time `json:"date"`
+	From    string    `json:"from"`
+	Subject string    `json:"subject"`
+}
+
+// criteria returns the SEARCH criteria of the options.
+func (o *messageCriteria) criteria() (*imap.SearchCriteria, error) {
+	c := imap.NewSearchCriteria()
+	for _, h := range []struct{ key, value string }{{"From", o.from}, {"To", o.to}, {"Subject", o.subject}} {
+		if h.value != "" {
+			c.Header.Add(h.key, h.value)
+		}
+	}
+	if o.since != "" {
+		since, err := time.Parse("2006-01-02", o.since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --since date: %w (expected YYYY-MM-DD)", err)
+		}
+		c.Since = since
+	}
+	before, err := parseBefore(o.before, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	c.Before = before
+	if o.unseen {
+		c.WithoutFlags = append(c.WithoutFlags, imap.SeenFlag)
+	}
+	if o.flagged {
+		c.WithFlags = append(c.WithFlags, imap.FlaggedFlag)
+	}
+	return c, nil
+}
+
+// empty reports whether no filter is set, so that every message matches.
+func (o *messageCriteria) empty() bool {
+	return *o == messageCriteria{}
+}
+
+// runSearch runs an IMAP SEARCH on one mailbox and prints UID, date,
+// sender and subject of the matches, to preview what copy filters select.
+func runSearch(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*searchOptions)
+	if o.limit < 0 {
+		return fmt.Errorf("invalid --limit: %d", o.limit)
+	}
+	criteria, err := o.criteria()
+	if err != nil {
+		return err
+	}
+	if o.skipDeleted {
+		criteria.WithoutFlags = append(criteria.WithoutFlags, imap.DeletedFlag)
+	}
+	mailbox := imaputil.DecodeMailboxName(o.mailbox)
+
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	if _, err := imaputil.SelectMailbox(c, mailbox, true); err != nil {
+		return fmt.Errorf("select %s: %w", mailbox, err)
+	}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	if o.limit > 0 && len(uids) > o.limit {
+		uids = uids[len(uids)-o.limit:]
+	}
+
+	var found []*imap.Message
+	if len(uids) > 0 {
+		seq := new(imap.SeqSet)
+		seq.AddNum(uids...)
+		msgs := make(chan *imap.Message, 16)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchInternalDate}, msgs)
+		}()
+		for m := range msgs {
+			found = append(found, m)
+		}
+		if err := <-done; err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i].Uid < found[j].Uid })
+	}
+
+	if o.json {
+		hits := make([]searchHit, 0, len(found))
+		for _, m := range found {
+			date, from, subject := messageSummary(m)
+			hits = append(hits, searchHit{UID: m.Uid, Date: date, From: from, Subject: subject})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(hits)
+	}
+	for _, m := range found {
+		fmt.Println(tailLine(m))
+	}
+	fmt.Fprintf(os.Stderr, "%d message(s) in %s\n", len(found), mailbox)
+	return nil
+}
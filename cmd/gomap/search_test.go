@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMessageCriteria(t *testing.T) {
+	tests := []struct {
+		o    messageCriteria
+		want string
+	}{
+		{messageCriteria{}, "header=map[] since=0001-01-01 with=[] without=[]"},
+		{messageCriteria{from: "alice@example.com", subject: "invoice"}, "header=map[From:[alice@example.com] Subject:[invoice]] since=0001-01-01 with=[] without=[]"},
+		{messageCriteria{since: "2024-01-31", unseen: true, flagged: true}, `header=map[] since=2024-01-31 with=[\Flagged] without=[\Seen]`},
+	}
+	for _, tt := range tests {
+		c, err := tt.o.criteria()
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.o, err)
+		}
+		got := fmt.Sprintf("header=%v since=%s with=%v without=%v", c.Header, c.Since.Format("2006-01-02"), c.WithFlags, c.WithoutFlags)
+		if got != tt.want {
+			t.Errorf("%+v: criteria %s, want %s", tt.o, got, tt.want)
+		}
+		if tt.o.empty() != (tt.o == messageCriteria{}) {
+			t.Errorf("%+v: empty() = %v", tt.o, tt.o.empty())
+		}
+	}
+
+	c, err := (&messageCriteria{before: "2023-05-01"}).criteria()
+	if err != nil || !c.Before.Equal(time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("before 2023-05-01: %v, %v", c, err)
+	}
+	for _, o := range []messageCriteria{{since: "31.01.2024"}, {before: "soon"}} {
+		if _, err := o.criteria(); err == nil {
+			t.Errorf("%+v accepted", o)
+		}
+	}
+}
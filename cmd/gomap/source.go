@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+// sourceOptions are the connection flags of the commands that work on a
+// single IMAP account (list, search, stats, flags, ...).
+type sourceOptions struct {
+	srcHost       string
+	srcPort       int
+	srcUser       string
+	srcPass       string
+	srcPassPrompt bool
+	identity      string
+	srcURL        string
+	insecure      bool
+	startTLS      bool
+}
+
+func addSourceFlags(cmd *cobra.Command, o *sourceOptions) {
+	cmd.Flags().StringVar(&o.srcHost, "src-host", "", "IMAP host")
+	cmd.Flags().IntVar(&o.srcPort, "src-port", 993, "IMAP port")
+	cmd.Flags().StringVar(&o.srcUser, "src-user", "", "IMAP username")
+	cmd.Flags().StringVar(&o.srcPass, "src-pass", "", "IMAP password")
+	cmd.Flags().BoolVar(&o.srcPassPrompt, "src-pass-prompt", false, "Prompt for the IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.identity, "identity", "", "Use the IMAP account of this identity from the config")
+	cmd.Flags().StringVar(&o.srcURL, "src", "", "Server as a connection URL, e.g. imaps://user@host:993 (instead of --src-host, --src-port and --src-user)")
+	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
+	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
+}
+
+// resolve fills the connection settings from --identity and --src,
+// prompts for the password if asked to and checks that nothing is
+// missing.
+func (o *sourceOptions) resolve(cmd *cobra.Command) error {
+	t := o.target()
+	if err := applyIdentity(cmd, t, o.identity); err != nil {
+		return err
+	}
+	if err := applyEndpoints(cmd, endpointFlag{t, "imap", o.srcURL}); err != nil {
+		return err
+	}
+	if o.srcPassPrompt && o.srcPass == "" {
+		if err := promptPassword("Password", &o.srcPass); err != nil {
+			return err
+		}
+	}
+	if o.srcHost == "" || o.srcUser == "" || o.srcPass == "" {
+		return fmt.Errorf("missing required flags: --src-host, --src-user, --src-pass")
+	}
+	return nil
+}
+
+func (o *sourceOptions) target() loginTarget {
+	return loginTarget{prefix: "src", host: &o.srcHost, port: &o.srcPort, user: &o.srcUser, pass: &o.srcPass, startTLS: &o.startTLS, insecure: &o.insecure}
+}
+
+// dial connects and logs in with the settings resolved before.
+func (o *sourceOptions) dial(ctx context.Context) (*client.Client, error) {
+	c, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, &tls.Config{InsecureSkipVerify: o.insecure})
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	return c, nil
+}
+
+// connectSource resolves the connection flags of o and logs in. Commands
+// check their other flags first, so a mistake there costs no password
+// prompt and no connection.
+func connectSource(cmd *cobra.Command, o *sourceOptions) (*client.Client, error) {
+	if err := o.resolve(cmd); err != nil {
+		return nil, err
+	}
+	return o.dial(cmd.Context())
+}
+
+// pairOptions are the connection flags of the commands that compare a
+// source with a destination account (prune-duplicates). The source side
+// is a sourceOptions whose --identity is spelled --src-identity.
+type pairOptions struct {
+	sourceOptions
+	dstHost       string
+	dstPort       int
+	dstUser       string
+	dstPass       string
+	dstPassPrompt bool
+	dstIdentity   string
+	dstURL        string
+}
+
+func addPairFlags(cmd *cobra.Command, o *pairOptions) {
+	cmd.Flags().StringVar(&o.srcHost, "src-host", "", "Source IMAP host")
+	cmd.Flags().IntVar(&o.srcPort, "src-port", 993, "Source IMAP port")
+	cmd.Flags().StringVar(&o.srcUser, "src-user", "", "Source IMAP username")
+	cmd.Flags().StringVar(&o.srcPass, "src-pass", "", "Source IMAP password")
+	cmd.Flags().BoolVar(&o.srcPassPrompt, "src-pass-prompt", false, "Prompt for source IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.identity, "src-identity", "", "Use the IMAP account of this identity from the config as source")
+	cmd.Flags().StringVar(&o.srcURL, "src", "", "Source as a connection URL, e.g. imaps://user@host:993 or imap+starttls://user@host (instead of --src-host, --src-port and --src-user)")
+	cmd.Flags().StringVar(&o.dstHost, "dst-host", "", "Destination IMAP host")
+	cmd.Flags().IntVar(&o.dstPort, "dst-port", 993, "Destination IMAP port")
+	cmd.Flags().StringVar(&o.dstUser, "dst-user", "", "Destination IMAP username")
+	cmd.Flags().StringVar(&o.dstPass, "dst-pass", "", "Destination IMAP password")
+	cmd.Flags().BoolVar(&o.dstPassPrompt, "dst-pass-prompt", false, "Prompt for destination IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.dstIdentity, "dst-identity", "", "Use the IMAP account of this identity from the config as destination")
+	cmd.Flags().StringVar(&o.dstURL, "dst", "", "Destination as a connection URL, e.g. imaps://user@host:993 (instead of --dst-host, --dst-port and --dst-user)")
+	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
+	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
+}
+
+// resolve does what sourceOptions.resolve does for both accounts.
+func (o *pairOptions) resolve(cmd *cobra.Command) error {
+	src := o.target()
+	dst := loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}
+	if err := applyIdentity(cmd, src, o.identity); err != nil {
+		return err
+	}
+	if err := applyIdentity(cmd, dst, o.dstIdentity); err != nil {
+		return err
+	}
+	if err := applyEndpoints(cmd, endpointFlag{src, "imap", o.srcURL}, endpointFlag{dst, "imap", o.dstURL}); err != nil {
+		return err
+	}
+	if o.srcPassPrompt && o.srcPass == "" {
+		if err := promptPassword("Source password", &o.srcPass); err != nil {
+			return err
+		}
+	}
+	if o.dstPassPrompt && o.dstPass == "" {
+		if err := promptPassword("Destination password", &o.dstPass); err != nil {
+			return err
+		}
+	}
+	if o.srcHost == "" || o.srcUser == "" || o.srcPass == "" || o.dstHost == "" || o.dstUser == "" || o.dstPass == "" {
+		return fmt.Errorf("missing required flags: --src-host, --src-user, --src-pass, --dst-host, --dst-user, --dst-pass")
+	}
+	return nil
+}
+
+// connectPair resolves the connection flags of o and logs in to both
+// accounts, the source first.
+func connectPair(cmd *cobra.Command, o *pairOptions) (src, dst *client.Client, err error) {
+	if err := o.resolve(cmd); err != nil {
+		return nil, nil, err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	src, err = imaputil.DialAndLogin(cmd.Context(), o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect source: %w", err)
+	}
+	dst, err = imaputil.DialAndLogin(cmd.Context(), o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+	if err != nil {
+		src.Logout()
+		return nil, nil, fmt.Errorf("connect destination: %w", err)
+	}
+	return src, dst, nil
+}
+
+// applyIdentity fills t from the IMAP account of the config identity
+// name, if one is given.
+func applyIdentity(cmd *cobra.Command, t loginTarget, name string) error {
+	if name == "" {
+		return nil
+	}
+	_, acc, _, err := lookupIdentity(name)
+	if err != nil {
+		return err
+	}
+	applyAccount(cmd, t, acc)
+	return nil
+}
+
+// promptPassword reads a password from the terminal without echo.
+func promptPassword(label string, pass *string) error {
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", strings.ToLower(label), err)
+	}
+	*pass = string(b)
+	return nil
+}
+
+// mailboxFilters compiles the --include and --exclude regexes; an empty
+// flag gives a nil regex.
+func mailboxFilters(include, exclude string) (includeRe, excludeRe *regexp.Regexp, err error) {
+	if include != "" {
+		if includeRe, err = regexp.Compile(include); err != nil {
+			return nil, nil, fmt.Errorf("invalid --include regex: %w", err)
+		}
+	}
+	if exclude != "" {
+		if excludeRe, err = regexp.Compile(exclude); err != nil {
+			return nil, nil, fmt.Errorf("invalid --exclude regex: %w", err)
+		}
+	}
+	return includeRe, excludeRe, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestMailboxFilters(t *testing.T) {
+	tests := []struct {
+		include, exclude string
+		name             string
+		want             bool
+	}{
+		{"", "", "INBOX", true},
+		{"^Work", "", "Work/Projects", true},
+		{"^Work", "", "INBOX", false},
+		{"", "(?i)trash", "Trash", false},
+		{"", "(?i)trash", "Sent", true},
+		{"^Work", "Old$", "Work/Old", false},
+		// names are matched in UTF-8 and in modified UTF-7
+		{"Entwürfe", "", "Entwürfe", true},
+		{"Entw&APw-rfe", "", "Entwürfe", true},
+	}
+	for _, tt := range tests {
+		includeRe, excludeRe, err := mailboxFilters(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatalf("mailboxFilters(%q, %q): %v", tt.include, tt.exclude, err)
+		}
+		got := (includeRe == nil || matchMailbox(includeRe, tt.name)) && (excludeRe == nil || !matchMailbox(excludeRe, tt.name))
+		if got != tt.want {
+			t.Errorf("include %q, exclude %q: %s selected = %v, want %v", tt.include, tt.exclude, tt.name, got, tt.want)
+		}
+	}
+	if _, _, err := mailboxFilters("(", ""); err == nil {
+		t.Error("invalid --include accepted")
+	}
+	if _, _, err := mailboxFilters("", "[a-"); err == nil {
+		t.Error("invalid --exclude accepted")
+	}
+}
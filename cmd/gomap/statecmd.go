@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/mboxutil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// mboxTailLen is how many bytes before a stored MBOX offset are hashed to
+// check that an imported state refers to the same file.
+const mboxTailLen = 1 << 20
+
+// mboxStateKey builds the state key for an MBOX source and destination mailbox.
+func mboxStateKey(absPath, dstMailbox string) string {
+	return fmt.Sprintf("mbox:%s|dst:%s", absPath, dstMailbox)
+}
+
+// splitMboxStateKey is the inverse of mboxStateKey.
+func splitMboxStateKey(key string) (path, dstMailbox string, ok bool) {
+	kind, path, dstMailbox, ok := splitPathStateKey(key)
+	if !ok || kind != "mbox" {
+		return "", "", false
+	}
+	return path, dstMailbox, true
+}
+
+// splitPathStateKey splits the state key of a local source, as built by
+// mboxStateKey, maildirStateKey, emlStateKey and msgStateKey, into the
+// kind of source ("mbox", "maildir", "eml" or "msg"), its path and the
+// destination mailbox.
+func splitPathStateKey(key string) (kind, path, dstMailbox string, ok bool) {
+	kind, rest, ok := strings.Cut(key, ":")
+	if !ok {
+		return "", "", "", false
+	}
+	switch kind {
+	case "mbox", "maildir", "eml", "msg":
+	default:
+		return "", "", "", false
+	}
+	i := strings.LastIndex(rest, "|dst:")
+	if i < 0 {
+		return "", "", "", false
+	}
+	return kind, rest[:i], rest[i+len("|dst:"):], true
+}
+
+// mboxTail hashes the decompressed bytes right before off.
+func mboxTail(path string, off int64) (string, int64, error) {
+	n := off
+	if n > mboxTailLen {
+		n = mboxTailLen
+	}
+	f, err := mboxutil.Open(path, off-n)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return "", 0, fmt.Errorf("read %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+type stateShowOptions struct {
+	stateFile string
+	uid       uint32
+}
+
+type stateExportOptions struct {
+	stateFile string
+	output    string
+}
+
+type stateImportOptions struct {
+	stateFile string
+	mboxPaths []string
+	force     bool
+}
+
+func addStateShowFlags(cmd *cobra.Command) {
+	o := &stateShowOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.stateFile, "state-file", "gomap-state.json", "Resume state JSON to show")
+	cmd.Flags().Uint32Var(&o.uid, "uid", 0, "With MAILBOX: show only where this source UID was copied to")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+func addStateExportFlags(cmd *cobra.Command) {
+	o := &stateExportOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.stateFile, "state-file", "gomap-state.json", "Resume state JSON to export")
+	cmd.Flags().StringVar(&o.output, "output", "gomap-state.bundle", "Bundle file to write")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+func addStateImportFlags(cmd *cobra.Command) {
+	o := &stateImportOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.stateFile, "state-file", "gomap-state.json", "Resume state JSON to write")
+	cmd.Flags().StringArrayVar(&o.mboxPaths, "mbox-path", nil, "Rewrite MBOX, Maildir, .eml and .msg source paths old=new (file or directory prefix, can be repeated)")
+	cmd.Flags().BoolVar(&o.force, "force", false, "Overwrite an existing state file and ignore MBOX fingerprint mismatches")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// runStateShow prints, per source mailbox, the highest UID copied and how
+// many UIDs are mapped to the destination, or with a mailbox argument its
+// UID map (source UID, destination UID).
+func runStateShow(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*stateShowOptions)
+	if _, err := os.Stat(o.stateFile); err != nil {
+		return fmt.Errorf("state file: %w", err)
+	}
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	if len(args) == 0 {
+		if o.uid != 0 {
+			return fmt.Errorf("--uid requires a MAILBOX")
+		}
+		names := map[string]bool{}
+		for name := range st.MailMax {
+			names[name] = true
+		}
+		for name := range st.UIDMap {
+			names[name] = true
+		}
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MAILBOX\tUIDVALIDITY\tMAX UID\tMAPPED\tDESTINATION")
+		for _, name := range sorted {
+			mapped, dst := 0, ""
+			if m := st.UIDMap[name]; m != nil {
+				mapped, dst = len(m.UIDs), m.DstMailbox
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", name, st.UIDValidity[name], st.MailMax[name], mapped, dst)
+		}
+		return w.Flush()
+	}
+
+	name := args[0]
+	m := st.UIDMap[name]
+	if m == nil {
+		return fmt.Errorf("no UID map for %s (the destination must support UIDPLUS)", name)
+	}
+	if o.uid != 0 {
+		box, uid, ok := st.DstUID(name, o.uid)
+		if !ok {
+			return fmt.Errorf("UID %d of %s is not mapped", o.uid, name)
+		}
+		fmt.Printf("%s %d -> %s %d\n", name, o.uid, box, uid)
+		return nil
+	}
+	srcUIDs := make([]uint32, 0, len(m.UIDs))
+	for uid := range m.UIDs {
+		srcUIDs = append(srcUIDs, uid)
+	}
+	sort.Slice(srcUIDs, func(i, j int) bool { return srcUIDs[i] < srcUIDs[j] })
+	fmt.Printf("%s -> %s (UIDVALIDITY %d -> %d)\n", name, m.DstMailbox, st.UIDValidity[name], m.DstUIDValidity)
+	for _, uid := range srcUIDs {
+		fmt.Printf("%d\t%d\n", uid, m.UIDs[uid])
+	}
+	return nil
+}
+
+func runStateExport(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*stateExportOptions)
+	if _, err := os.Stat(o.stateFile); err != nil {
+		return fmt.Errorf("state file: %w", err)
+	}
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	fp, err := configFingerprint()
+	if err != nil {
+		return err
+	}
+	b := &state.Bundle{
+		Version:           state.BundleVersion,
+		Created:           time.Now().UTC(),
+		GomapVersion:      version,
+		ConfigFingerprint: fp,
+		State:             st,
+		MboxSources:       map[string]state.MboxSource{},
+	}
+	for key, off := range st.MboxOffsets {
+		path, _, ok := splitMboxStateKey(key)
+		if !ok {
+			continue
+		}
+		src := state.MboxSource{Path: path}
+		if sum, n, err := mboxTail(path, off); err == nil {
+			src.TailSHA256, src.TailLen = sum, n
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: cannot fingerprint %s: %v\n", path, err)
+		}
+		b.MboxSources[key] = src
+	}
+
+	summary := fmt.Sprintf("%d mailboxes, %d windowed mailboxes and %d MBOX sources to %s",
+		len(st.MailMax), len(st.Windows), len(b.MboxSources), o.output)
+	err = perform("export "+summary, func() error {
+		f, err := os.OpenFile(o.output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("create bundle: %w", err)
+		}
+		if err := state.WriteBundle(f, b); err != nil {
+			f.Close()
+			return fmt.Errorf("write bundle: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("write bundle: %w", err)
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return err
+	}
+	fmt.Println("Exported " + summary)
+	return nil
+}
+
+func runStateImport(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*stateImportOptions)
+	if _, err := os.Stat(o.stateFile); err == nil && !o.force {
+		return fmt.Errorf("%s already exists (use --force to overwrite)", o.stateFile)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("state file: %w", err)
+	}
+	rewrites := map[string]string{}
+	for _, p := range o.mboxPaths {
+		from, to, ok := strings.Cut(p, "=")
+		if !ok || from == "" || to == "" {
+			return fmt.Errorf("invalid --mbox-path value (expected old=new): %s", p)
+		}
+		rewrites[filepath.Clean(from)] = to
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("open bundle: %w", err)
+	}
+	b, err := state.ReadBundle(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	fp, err := configFingerprint()
+	if err != nil {
+		return err
+	}
+	if b.ConfigFingerprint != "" && fp != b.ConfigFingerprint {
+		fmt.Fprintln(os.Stderr, "warning: the accounts in this machine's config differ from the exporting machine; make sure you resume against the same servers")
+	}
+
+	offsets := make(map[string]int64, len(b.State.MboxOffsets))
+	problems := []string{}
+	for key, off := range b.State.MboxOffsets {
+		path, dst, ok := splitMboxStateKey(key)
+		if !ok {
+			offsets[key] = off
+			continue
+		}
+		newPath, err := filepath.Abs(rewriteMboxPath(path, rewrites))
+		if err != nil {
+			return err
+		}
+		offsets[mboxStateKey(newPath, dst)] = off
+		src := b.MboxSources[key]
+		if src.TailSHA256 == "" {
+			continue
+		}
+		sum, n, err := mboxTail(newPath, off)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Fprintf(os.Stderr, "warning: %s not found; copy it there before resuming (or use --mbox-path)\n", newPath)
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", newPath, err))
+		case n != src.TailLen || sum != src.TailSHA256:
+			problems = append(problems, fmt.Sprintf("%s: content before offset %d differs from the exported file", newPath, off))
+		}
+	}
+	if len(problems) > 0 && !o.force {
+		sort.Strings(problems)
+		return fmt.Errorf("MBOX fingerprint mismatch (use --force to import anyway):\n  %s", strings.Join(problems, "\n  "))
+	}
+	b.State.MboxOffsets = offsets
+	if err := remapSourcePaths(b.State, rewrites); err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("state exported %s by gomap %s into %s", b.Created.Local().Format("2006-01-02 15:04"), b.GomapVersion, o.stateFile)
+	err = perform("import "+summary, func() error {
+		if err := b.State.Save(o.stateFile); err != nil {
+			return fmt.Errorf("save state: %w", err)
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return err
+	}
+	fmt.Println("Imported " + summary)
+	return nil
+}
+
+// rewriteMboxPath applies the longest matching --mbox-path rewrite, which
+// may name the file itself or one of its parent directories.
+func rewriteMboxPath(path string, rewrites map[string]string) string {
+	best := ""
+	for from := range rewrites {
+		if (path == from || strings.HasPrefix(path, from+string(filepath.Separator)) || strings.HasPrefix(path, from+"/")) && len(from) > len(best) {
+			best = from
+		}
+	}
+	if best == "" {
+		return path
+	}
+	return rewrites[best] + path[len(best):]
+}
+
+// remapSourcePaths applies the --mbox-path rewrites to the marks of the
+// Maildir, .eml and .msg sources, which are keyed by absolute path like
+// the MBOX offsets.
+func remapSourcePaths(st *state.State, rewrites map[string]string) error {
+	var err error
+	if st.MaildirMarks, err = remapPathKeys(st.MaildirMarks, rewrites); err != nil {
+		return err
+	}
+	if st.EmlMaxUID, err = remapPathKeys(st.EmlMaxUID, rewrites); err != nil {
+		return err
+	}
+	st.MsgMarks, err = remapPathKeys(st.MsgMarks, rewrites)
+	return err
+}
+
+// remapPathKeys returns m with the paths in its state keys rewritten.
+func remapPathKeys[V any](m map[string]V, rewrites map[string]string) (map[string]V, error) {
+	if m == nil {
+		return nil, nil
+	}
+	out := make(map[string]V, len(m))
+	for key, v := range m {
+		kind, path, dst, ok := splitPathStateKey(key)
+		if !ok {
+			out[key] = v
+			continue
+		}
+		newPath, err := filepath.Abs(rewriteMboxPath(path, rewrites))
+		if err != nil {
+			return nil, err
+		}
+		out[kind+":"+newPath+"|dst:"+dst] = v
+	}
+	return out, nil
+}
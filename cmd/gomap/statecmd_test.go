@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+func TestRemapSourcePaths(t *testing.T) {
+	rewrites := map[string]string{"/Users/me/exports": "/srv/exports", "/Users/me/exports/old": "/archive"}
+	st := &state.State{
+		MaildirMarks: map[string]int64{
+			maildirStateKey("/Users/me/exports/Maildir/.Sent", "Sent"): 7,
+			maildirStateKey("/home/me/Maildir", "INBOX"):               9,
+		},
+		EmlMaxUID: map[string]uint32{
+			emlStateKey("/Users/me/exports/old/INBOX", "INBOX"): 42,
+		},
+		MsgMarks: map[string]string{
+			msgStateKey("/Users/me/exports", "Outlook"): "b.msg",
+			"not a source key":                          "a.msg",
+		},
+	}
+	if err := remapSourcePaths(st, rewrites); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want string
+	}{
+		{"Maildir", fmt.Sprint(st.MaildirMarks), "map[maildir:/home/me/Maildir|dst:INBOX:9 maildir:/srv/exports/Maildir/.Sent|dst:Sent:7]"},
+		{"eml", fmt.Sprint(st.EmlMaxUID), "map[eml:/archive/INBOX|dst:INBOX:42]"},
+		{"msg", fmt.Sprint(st.MsgMarks), "map[msg:/srv/exports|dst:Outlook:b.msg not a source key:a.msg]"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s marks: %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+	st = &state.State{}
+	if err := remapSourcePaths(st, rewrites); err != nil || st.MaildirMarks != nil || st.EmlMaxUID != nil || st.MsgMarks != nil {
+		t.Errorf("empty state: %v, marks %v %v %v", err, st.MaildirMarks, st.EmlMaxUID, st.MsgMarks)
+	}
+}
+
+func TestSplitPathStateKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{mboxStateKey("/srv/a.mbox", "INBOX"), "mbox /srv/a.mbox INBOX true"},
+		{maildirStateKey("/srv/Maildir", "Sent|x"), "maildir /srv/Maildir Sent|x true"},
+		{emlStateKey("/srv/backup/INBOX", "INBOX"), "eml /srv/backup/INBOX INBOX true"},
+		{msgStateKey("/srv/pst", "Outlook"), "msg /srv/pst Outlook true"},
+		{"gmail:Label|dst:INBOX", "   false"},
+		{"mbox:/srv/a.mbox", "   false"},
+	}
+	for _, tt := range tests {
+		kind, path, dst, ok := splitPathStateKey(tt.key)
+		if got := fmt.Sprint(kind, " ", path, " ", dst, " ", ok); got != tt.want {
+			t.Errorf("splitPathStateKey(%q) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
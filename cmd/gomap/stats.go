@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type statsOptions struct {
+	sourceOptions
+	mailboxes []string
+	top       int
+	json      bool
+	csv       bool
+}
+
+func addStatsFlags(cmd *cobra.Command) {
+	o := &statsOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringArrayVar(&o.mailboxes, "mailbox", []string{"*"}, "Mailbox to count; * and % are LIST wildcards (can be repeated)")
+	cmd.Flags().IntVar(&o.top, "top", 10, "Number of top senders to show (0 for all)")
+	cmd.Flags().BoolVar(&o.json, "json", false, "Print the statistics as JSON")
+	cmd.Flags().BoolVar(&o.csv, "csv", false, "Print the statistics as CSV (kind,name,messages,bytes,error)")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// statsCount is a message count and the total RFC822.SIZE of the
+// messages, under a name: a mailbox, a year or a sender address.
+type statsCount struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	Bytes    int64  `json:"bytes"`
+	Error    string `json:"error,omitempty"`
+}
+
+// accountStats is the output of stats.
+type accountStats struct {
+	Total     statsCount   `json:"total"`
+	Mailboxes []statsCount `json:"mailboxes"`
+	Years     []statsCount `json:"years"`
+	Senders   []statsCount `json:"senders"`
+}
+
+// statsTally adds up the messages of the mailboxes per year and sender.
+type statsTally struct {
+	years   map[string]*statsCount
+	senders map[string]*statsCount
+}
+
+// add counts m, fetched with its envelope, size and INTERNALDATE, in box
+// and in the year and sender totals.
+func (t *statsTally) add(box *statsCount, m *imap.Message) {
+	size := int64(m.Size)
+	box.Messages++
+	box.Bytes += size
+	year := "unknown"
+	if !m.InternalDate.IsZero() {
+		year = strconv.Itoa(m.InternalDate.Year())
+	}
+	sender := "unknown"
+	if e := m.Envelope; e != nil && len(e.From) > 0 {
+		if a := strings.ToLower(e.From[0].Address()); a != "" && a != "@" {
+			sender = a
+		}
+	}
+	for _, c := range []struct {
+		m   map[string]*statsCount
+		key string
+	}{{t.years, year}, {t.senders, sender}} {
+		n := c.m[c.key]
+		if n == nil {
+			n = &statsCount{Name: c.key}
+			c.m[c.key] = n
+		}
+		n.Messages++
+		n.Bytes += size
+	}
+}
+
+// rows returns the years in order and the senders with the most
+// messages first, at most top of them unless top is 0.
+func (t *statsTally) rows(top int) (years, senders []statsCount) {
+	for _, y := range t.years {
+		years = append(years, *y)
+	}
+	sort.Slice(years, func(i, j int) bool { return years[i].Name < years[j].Name })
+	for _, s := range t.senders {
+		senders = append(senders, *s)
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		a, b := senders[i], senders[j]
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.Name < b.Name
+	})
+	if top > 0 && len(senders) > top {
+		senders = senders[:top]
+	}
+	return years, senders
+}
+
+// runStats prints per-mailbox message counts and sizes, messages per year
+// and the top senders of an account, from the envelope, size and
+// INTERNALDATE of every message. Mailboxes are opened read-only.
+func runStats(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*statsOptions)
+	if o.json && o.csv {
+		return fmt.Errorf("--json and --csv cannot be combined")
+	}
+	if o.top < 0 {
+		return fmt.Errorf("invalid --top: %d", o.top)
+	}
+
+	ctx := cmd.Context()
+	c, err := connectSource(cmd, &o.sourceOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	boxes, err := matchingMailboxes(ctx, c, o.mailboxes)
+	if err != nil {
+		return err
+	}
+	if len(boxes) == 0 {
+		fmt.Println("No mailboxes matched.")
+		return nil
+	}
+
+	tally := statsTally{years: map[string]*statsCount{}, senders: map[string]*statsCount{}}
+	stats := accountStats{Total: statsCount{Name: "total"}}
+	failed := 0
+	for _, b := range boxes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		box := statsCount{Name: b}
+		if err := tallyMailbox(c, b, &tally, &box); err != nil {
+			if imaputil.ConnClosed(err) {
+				return err
+			}
+			box.Error = err.Error()
+			failed++
+		}
+		stats.Mailboxes = append(stats.Mailboxes, box)
+		stats.Total.Messages += box.Messages
+		stats.Total.Bytes += box.Bytes
+	}
+	stats.Years, stats.Senders = tally.rows(o.top)
+
+	switch {
+	case o.json:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(stats)
+	case o.csv:
+		err = writeStatsCSV(os.Stdout, stats)
+	default:
+		err = printStats(stats)
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d mailbox(es) could not be read", failed)
+	}
+	return nil
+}
+
+// tallyMailbox counts the messages of mailbox in box and tally.
+func tallyMailbox(c *client.Client, mailbox string, tally *statsTally, box *statsCount) error {
+	status, err := imaputil.SelectMailbox(c, mailbox, true)
+	if err != nil {
+		return err
+	}
+	if status.Messages == 0 {
+		return nil
+	}
+	seq := new(imap.SeqSet)
+	seq.AddRange(1, status.Messages)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Fetch(seq, []imap.FetchItem{imap.FetchEnvelope, imap.FetchRFC822Size, imap.FetchInternalDate}, msgs)
+	}()
+	for m := range msgs {
+		if m != nil {
+			tally.add(box, m)
+		}
+	}
+	return <-done
+}
+
+// printStats prints stats as three tables: mailboxes with a total, years
+// and top senders.
+func printStats(stats accountStats) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MAILBOX\tMESSAGES\tSIZE")
+	for _, b := range stats.Mailboxes {
+		if b.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t%s\n", b.Name, b.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", b.Name, b.Messages, formatBytes(b.Bytes))
+	}
+	fmt.Fprintf(w, "%d mailbox(es)\t%d\t%s\n", len(stats.Mailboxes), stats.Total.Messages, formatBytes(stats.Total.Bytes))
+	for _, table := range []struct {
+		title string
+		rows  []statsCount
+	}{{"YEAR", stats.Years}, {"SENDER", stats.Senders}} {
+		if len(table.rows) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s\tMESSAGES\tSIZE\n", table.title)
+		for _, r := range table.rows {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", r.Name, r.Messages, formatBytes(r.Bytes))
+		}
+	}
+	return w.Flush()
+}
+
+// writeStatsCSV writes stats to w as CSV with a header line and one row per
+// total, mailbox, year and sender; the kind column tells them apart.
+func writeStatsCSV(w io.Writer, stats accountStats) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"kind", "name", "messages", "bytes", "error"})
+	row := func(kind string, c statsCount) {
+		_ = cw.Write([]string{kind, c.Name, strconv.Itoa(c.Messages), strconv.FormatInt(c.Bytes, 10), c.Error})
+	}
+	row("total", stats.Total)
+	for _, b := range stats.Mailboxes {
+		row("mailbox", b)
+	}
+	for _, y := range stats.Years {
+		row("year", y)
+	}
+	for _, s := range stats.Senders {
+		row("sender", s)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestStatsTally(t *testing.T) {
+	msg := func(from string, year int, size uint32) *imap.Message {
+		m := &imap.Message{Size: size, Envelope: &imap.Envelope{}}
+		if year != 0 {
+			m.InternalDate = time.Date(year, time.June, 1, 0, 0, 0, 0, time.UTC)
+		}
+		if from != "" {
+			mailbox, host, _ := strings.Cut(from, "@")
+			m.Envelope.From = []*imap.Address{{MailboxName: mailbox, HostName: host}}
+		}
+		return m
+	}
+	tally := statsTally{years: map[string]*statsCount{}, senders: map[string]*statsCount{}}
+	inbox, sent := statsCount{Name: "INBOX"}, statsCount{Name: "Sent"}
+	tally.add(&inbox, msg("alice@example.com", 2023, 100))
+	tally.add(&inbox, msg("Alice@Example.com", 2024, 200))
+	tally.add(&inbox, msg("bob@example.com", 2023, 50))
+	tally.add(&sent, msg("carol@example.com", 2022, 10))
+	tally.add(&sent, msg("", 0, 5))
+	tally.add(&sent, &imap.Message{Size: 1})
+
+	if inbox.Messages != 3 || inbox.Bytes != 350 || sent.Messages != 3 || sent.Bytes != 16 {
+		t.Errorf("mailboxes %+v, %+v", inbox, sent)
+	}
+	tests := []struct {
+		top            int
+		years, senders string
+	}{
+		{0, "[2022:1/10 2023:2/150 2024:1/200 unknown:2/6]", "[alice@example.com:2/300 unknown:2/6 bob@example.com:1/50 carol@example.com:1/10]"},
+		{2, "[2022:1/10 2023:2/150 2024:1/200 unknown:2/6]", "[alice@example.com:2/300 unknown:2/6]"},
+		{10, "[2022:1/10 2023:2/150 2024:1/200 unknown:2/6]", "[alice@example.com:2/300 unknown:2/6 bob@example.com:1/50 carol@example.com:1/10]"},
+	}
+	format := func(rows []statsCount) string {
+		var s []string
+		for _, r := range rows {
+			s = append(s, fmt.Sprintf("%s:%d/%d", r.Name, r.Messages, r.Bytes))
+		}
+		return fmt.Sprint(s)
+	}
+	for _, tt := range tests {
+		years, senders := tally.rows(tt.top)
+		if got := format(years); got != tt.years {
+			t.Errorf("top %d: years %s, want %s", tt.top, got, tt.years)
+		}
+		if got := format(senders); got != tt.senders {
+			t.Errorf("top %d: senders %s, want %s", tt.top, got, tt.senders)
+		}
+	}
+}
+
+func TestWriteStatsCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats accountStats
+		want  string
+	}{
+		{"empty", accountStats{Total: statsCount{Name: "total"}}, "kind,name,messages,bytes,error\ntotal,total,0,0,\n"},
+		{"all kinds", accountStats{
+			Total:     statsCount{Name: "total", Messages: 3, Bytes: 300},
+			Mailboxes: []statsCount{{Name: "INBOX", Messages: 3, Bytes: 300}, {Name: "Lists, old", Error: "select: NO access denied"}},
+			Years:     []statsCount{{Name: "2024", Messages: 3, Bytes: 300}},
+			Senders:   []statsCount{{Name: "alice@example.com", Messages: 3, Bytes: 300}},
+		}, "kind,name,messages,bytes,error\n" +
+			"total,total,3,300,\n" +
+			"mailbox,INBOX,3,300,\n" +
+			"mailbox,\"Lists, old\",0,0,select: NO access denied\n" +
+			"year,2024,3,300,\n" +
+			"sender,alice@example.com,3,300,\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeStatsCSV(&buf, tt.stats); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, buf.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/pacer"
+	"github.com/pepperpark/gomap/internal/quarantine"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+type syncOptions struct {
+	copyOptions
+	twoWay         bool
+	delete         bool
+	deleteMode     string // quarantine | trash | expunge
+	quarantineDays int
+
+	pace     *pacer.Pacer
+	dstTrash string // --delete-mode trash target
+}
+
+func addSyncFlags(cmd *cobra.Command) {
+	o := &syncOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.srcHost, "src-host", "", "Source IMAP host")
+	cmd.Flags().IntVar(&o.srcPort, "src-port", 993, "Source IMAP port")
+	cmd.Flags().StringVar(&o.srcUser, "src-user", "", "Source IMAP username")
+	cmd.Flags().StringVar(&o.srcPass, "src-pass", "", "Source IMAP password")
+	cmd.Flags().BoolVar(&o.srcPassPrompt, "src-pass-prompt", false, "Prompt for source IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.srcIdentity, "src-identity", "", "Use the IMAP account of this identity from the config as source")
+	cmd.Flags().StringVar(&o.srcURL, "src", "", "Source as a connection URL, e.g. imaps://user@host:993 or imap+starttls://user@host (instead of --src-host, --src-port and --src-user)")
+	cmd.Flags().StringVar(&o.dstHost, "dst-host", "", "Destination IMAP host")
+	cmd.Flags().IntVar(&o.dstPort, "dst-port", 993, "Destination IMAP port")
+	cmd.Flags().StringVar(&o.dstUser, "dst-user", "", "Destination IMAP username")
+	cmd.Flags().StringVar(&o.dstPass, "dst-pass", "", "Destination IMAP password")
+	cmd.Flags().BoolVar(&o.dstPassPrompt, "dst-pass-prompt", false, "Prompt for destination IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.dstIdentity, "dst-identity", "", "Use the IMAP account of this identity from the config as destination")
+	cmd.Flags().StringVar(&o.dstURL, "dst", "", "Destination as a connection URL, e.g. imaps://user@host:993 (instead of --dst-host, --dst-port and --dst-user)")
+	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
+	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
+	cmd.Flags().BoolVar(&o.twoWay, "two-way", false, "Also copy new destination messages back to the source")
+	cmd.Flags().BoolVar(&o.delete, "delete", false, "Mirror deletions: remove destination messages whose source message was deleted since the last run (one-way only)")
+	cmd.Flags().StringVar(&o.deleteMode, "delete-mode", "quarantine", "With --delete: quarantine (move to gomap-quarantine), trash (move to the destination Trash) or expunge")
+	cmd.Flags().IntVar(&o.quarantineDays, "quarantine-days", 30, "With --delete: purge quarantine folders after N days (0 = keep)")
+	cmd.Flags().StringVar(&o.include, "include", "", "Regex of mailboxes to include (source names)")
+	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regex of mailboxes to exclude (source names)")
+	cmd.Flags().StringVar(&o.boxesFile, "mailboxes-file", "", "Only the mailboxes listed in this file, one per line; # starts a comment (source names; instead of --include/--exclude)")
+	cmd.Flags().BoolVar(&o.skipSpecial, "skip-special", false, "Skip common special folders like Trash/Junk/Drafts/Sent")
+	cmd.Flags().BoolVar(&o.skipTrash, "skip-trash", false, "Skip Trash folders")
+	cmd.Flags().BoolVar(&o.skipJunk, "skip-junk", false, "Skip Junk/Spam folders")
+	cmd.Flags().BoolVar(&o.skipDrafts, "skip-drafts", false, "Skip Drafts folders")
+	cmd.Flags().BoolVar(&o.skipSent, "skip-sent", false, "Skip Sent folders")
+	cmd.Flags().StringArrayVar(&o.mapPairs, "map", nil, "Folder mapping src=dst (can be repeated)")
+	cmd.Flags().StringVar(&o.srcPrefix, "src-prefix", "", "Strip this parent folder from the source folder names, e.g. INBOX or a shared namespace")
+	cmd.Flags().StringVar(&o.dstPrefix, "dst-prefix", "", "Pair the source folders with folders below this destination folder, e.g. Archive/old-account")
+	cmd.Flags().BoolVar(&o.flatten, "flatten", false, "Pair the source folders with top-level destination folders named by joining their levels, as copy --flatten does")
+	cmd.Flags().StringVar(&o.flattenSep, "flatten-separator", "_", "With --flatten: what joins the levels of a folder name")
+	cmd.Flags().StringArrayVar(&o.flagMapPairs, "flag-map", nil, "Flag mapping src=dst for copied messages, e.g. '$label1=$Important'; 'src=' drops the flag (can be repeated)")
+	cmd.Flags().BoolVar(&o.stripFlags, "strip-nonstandard-flags", false, "Drop flags other than the system flags and registered keywords, as copy does")
+	cmd.Flags().StringVar(&o.stateFile, "state-file", "gomap-sync-state.json", "Path to sync state JSON (keep one per account pair)")
+	cmd.Flags().Float64Var(&o.maxRate, "max-rate", 0, "Upper limit for the adaptive append rate in messages per second (0 = no limit)")
+	cmd.Flags().IntVar(&o.maxAppendsPerMin, "max-appends-per-min", 0, "Upper limit for appends per minute, for providers that count them that way (0 = no limit)")
+	cmd.Flags().BoolVar(&o.noPacing, "no-pacing", false, "Disable adaptive pacing of appends")
+	cmd.Flags().BoolVar(&o.verbose, "verbose", false, "Enable detailed per-mailbox logs")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// syncPair is a source mailbox and the destination mailbox it syncs with.
+type syncPair struct {
+	src, dst string
+}
+
+// syncMsg is a message not yet seen by sync on one side.
+type syncMsg struct {
+	uid uint32
+	key string
+}
+
+// syncSide is one end of a mailbox pair during a run.
+type syncSide struct {
+	c        *client.Client
+	label    string // "source" or "destination"
+	mailbox  string
+	stateKey string
+	validity uint32
+	scanned  uint32    // highest UID handled by earlier runs
+	news     []syncMsg // messages above scanned, by UID
+	missing  bool      // mailbox does not exist (dry run only)
+	rfc822   bool      // fetch RFC822 instead of BODY[] (quirks)
+
+	condstore  bool   // server supports CONDSTORE
+	prevModSeq uint64 // HIGHESTMODSEQ at the end of the last run, 0 if unknown
+	unchanged  bool   // nothing happened in the mailbox since the last run
+}
+
+// syncStateKey names the Message-ID index of one side in the state file.
+// It is separate from the --dedup index of copy: here an index entry
+// means "already reconciled", not just "present".
+func syncStateKey(side, mailbox string) string {
+	return "sync:" + side + ":" + mailbox
+}
+
+// reconcileKey identifies a message on both sides: its Message-ID, or
+// date, sender and subject for messages without one.
+func reconcileKey(env *imap.Envelope) string {
+	if env == nil {
+		return ""
+	}
+	if id := strings.TrimSpace(env.MessageId); id != "" {
+		return id
+	}
+	from := ""
+	if len(env.From) > 0 {
+		from = strings.ToLower(env.From[0].Address())
+	}
+	return "nomid:" + env.Date.UTC().Format(time.RFC3339) + "|" + from + "|" + env.Subject
+}
+
+// runSync propagates new messages between two accounts. Each side keeps
+// its own UID high-water mark and an index of the messages it has seen;
+// a new message is copied unless the other side already has one with the
+// same reconciliation key, so messages delivered to both accounts, and
+// the copies sync made itself, are not duplicated.
+func runSync(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*syncOptions)
+	if o.srcIdentity != "" {
+		_, acc, _, err := lookupIdentity(o.srcIdentity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "src", host: &o.srcHost, port: &o.srcPort, user: &o.srcUser, pass: &o.srcPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if o.dstIdentity != "" {
+		_, acc, _, err := lookupIdentity(o.dstIdentity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if err := o.applyEndpoints(cmd); err != nil {
+		return err
+	}
+	if o.srcPassPrompt && o.srcPass == "" {
+		fmt.Fprint(os.Stderr, "Source password: ")
+		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if perr != nil {
+			return fmt.Errorf("read source password: %w", perr)
+		}
+		o.srcPass = string(b)
+	}
+	if o.dstPassPrompt && o.dstPass == "" {
+		fmt.Fprint(os.Stderr, "Destination password: ")
+		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if perr != nil {
+			return fmt.Errorf("read destination password: %w", perr)
+		}
+		o.dstPass = string(b)
+	}
+	if o.srcHost == "" || o.srcUser == "" || o.srcPass == "" || o.dstHost == "" || o.dstUser == "" || o.dstPass == "" {
+		return fmt.Errorf("missing required flags: --src-host, --src-user, --src-pass, --dst-host, --dst-user, --dst-pass")
+	}
+	switch {
+	case o.deleteMode != "quarantine" && o.deleteMode != "trash" && o.deleteMode != "expunge":
+		return fmt.Errorf("invalid --delete-mode: %s (must be 'quarantine', 'trash' or 'expunge')", o.deleteMode)
+	case o.delete && o.twoWay:
+		return fmt.Errorf("--delete cannot be combined with --two-way")
+	}
+	keep, err := copyMailboxFilter(&o.copyOptions)
+	if err != nil {
+		return err
+	}
+	if o.flagMap, err = imaputil.NewFlagMap(o.flagMapPairs, o.stripFlags); err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	ctx := cmd.Context()
+	st, err := state.Load(o.stateFile)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	src, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("connect source: %w", err)
+	}
+	defer src.Logout()
+	dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("connect destination: %w", err)
+	}
+	defer dst.Logout()
+
+	srcBoxes, err := imaputil.ListMailboxes(ctx, src)
+	if err != nil {
+		return fmt.Errorf("list source mailboxes: %w", err)
+	}
+	dstBoxes, err := imaputil.ListMailboxes(ctx, dst)
+	if err != nil {
+		return fmt.Errorf("list destination mailboxes: %w", err)
+	}
+	srcQuirks, dstQuirks := serverQuirks(src, o.srcHost), serverQuirks(dst, o.dstHost)
+	// appends go both ways, so the stricter rate cap applies
+	capQuirks := dstQuirks
+	if o.twoWay && srcQuirks.MaxRate > 0 && (capQuirks.MaxRate == 0 || srcQuirks.MaxRate < capQuirks.MaxRate) {
+		capQuirks = srcQuirks
+	}
+	keep = o.applyQuirks(keep, srcQuirks, capQuirks)
+
+	allDst := append([]string(nil), dstBoxes...)
+	// the quarantine of mirror deletions and the destination's duplicate
+	// views (Gmail labels) are not synced
+	for _, side := range []struct {
+		c     *client.Client
+		boxes *[]string
+		skip  *regexp.Regexp
+	}{{src, &srcBoxes, nil}, {dst, &dstBoxes, dstQuirks.SkipFolders}} {
+		delim, err := imaputil.Delimiter(side.c)
+		if err != nil {
+			return fmt.Errorf("list mailboxes: %w", err)
+		}
+		if side.c == dst {
+			o.dstDelim = delim
+		} else {
+			o.srcDelim = delim
+		}
+		kept := (*side.boxes)[:0]
+		for _, b := range *side.boxes {
+			if !quarantine.Contains(b, delim) && (side.skip == nil || !side.skip.MatchString(b)) {
+				kept = append(kept, b)
+			}
+		}
+		*side.boxes = kept
+	}
+	pairs := o.syncPairs(srcBoxes, dstBoxes, keep, o.quirkFolderMap(o.folderMap(srcBoxes), srcBoxes, srcQuirks, dstQuirks))
+	if len(pairs) == 0 {
+		fmt.Println("No mailboxes to process.")
+		return nil
+	}
+	srcExists := map[string]bool{}
+	for _, b := range srcBoxes {
+		srcExists[b] = true
+	}
+	dstExists := map[string]bool{}
+	for _, b := range dstBoxes {
+		dstExists[b] = true
+	}
+	if o.delete && o.deleteMode == "trash" {
+		for _, b := range dstBoxes {
+			if trashFolderRe.MatchString(b) {
+				o.dstTrash = b
+				break
+			}
+		}
+		if o.dstTrash == "" {
+			return fmt.Errorf("--delete-mode trash: no Trash folder on the destination")
+		}
+	}
+	o.pace = o.pacer()
+	if o.verbose {
+		printQuirks("Source", srcQuirks)
+		printQuirks("Destination", dstQuirks)
+		fmt.Printf("Starting sync: %d mailbox pair(s), two-way=%v, dry-run=%v, state-file=%s\n", len(pairs), o.twoWay, dryRun, o.stateFile)
+	}
+
+	srcCondstore, dstCondstore := imaputil.HasCondstore(src), imaputil.HasCondstore(dst)
+	var errs []error
+	var total syncCounts
+	for _, p := range pairs {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		a := &syncSide{c: src, label: "source", mailbox: p.src, stateKey: syncStateKey("src", p.src), missing: !srcExists[p.src], rfc822: srcQuirks.FetchRFC822, condstore: srcCondstore}
+		b := &syncSide{c: dst, label: "destination", mailbox: p.dst, stateKey: syncStateKey("dst", p.dst), missing: !dstExists[p.dst], rfc822: dstQuirks.FetchRFC822, condstore: dstCondstore}
+		n, err := o.syncMailboxPair(ctx, st, a, b)
+		total.toDst += n.toDst
+		total.toSrc += n.toSrc
+		total.deleted += n.deleted
+		total.flagged += n.flagged
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.src, err))
+		}
+		if !dryRun {
+			if err := o.saveState(st); err != nil {
+				return fmt.Errorf("save state: %w", err)
+			}
+		}
+	}
+
+	if o.delete && o.deleteMode == "quarantine" && !dryRun && ctx.Err() == nil {
+		if err := o.purgeQuarantine(dst, allDst); err != nil {
+			errs = append(errs, fmt.Errorf("purge quarantine: %w", err))
+		}
+	}
+
+	prefix := ""
+	if dryRun {
+		prefix = "[dry-run] would copy: "
+	}
+	switch {
+	case o.twoWay:
+		fmt.Printf("%s%d message(s) to destination, %d to source\n", prefix, total.toDst, total.toSrc)
+	case o.delete:
+		fmt.Printf("%s%d message(s) to destination, %d removed (%s)\n", prefix, total.toDst, total.deleted, o.deleteMode)
+	default:
+		fmt.Printf("%s%d message(s) to destination\n", prefix, total.toDst)
+	}
+	switch {
+	case total.flagged > 0 && dryRun:
+		dryRunf("would update flags of %d message(s)", total.flagged)
+	case total.flagged > 0:
+		fmt.Printf("flags of %d message(s) updated\n", total.flagged)
+	}
+	if len(errs) > 0 {
+		fmt.Println("Finished with errors:")
+		for _, e := range errs {
+			fmt.Println(" -", e)
+		}
+		return fmt.Errorf("%d mailbox pair(s) failed", len(errs))
+	}
+	return nil
+}
+
+// syncPairs pairs the selected source mailboxes with their destination
+// names in folderMap. With --two-way, destination mailboxes without a
+// source counterpart are added too, mapped back through --map or the
+// folder prefixes.
+func (o *syncOptions) syncPairs(srcBoxes, dstBoxes []string, keep func(string) bool, folderMap map[string]string) []syncPair {
+	var pairs []syncPair
+	paired := map[string]bool{}
+	for _, b := range srcBoxes {
+		if !keep(b) {
+			continue
+		}
+		to := b
+		if m, ok := folderMap[b]; ok && m != "" {
+			to = m
+		}
+		pairs = append(pairs, syncPair{src: b, dst: to})
+		paired[to] = true
+	}
+	if !o.twoWay {
+		return pairs
+	}
+	reverse := map[string]string{}
+	for from, to := range parseMappings(o.mapPairs) {
+		reverse[to] = from
+	}
+	known := map[string]bool{}
+	for _, b := range srcBoxes {
+		known[b] = true
+	}
+	for _, b := range dstBoxes {
+		if paired[b] {
+			continue
+		}
+		from, ok := reverse[b]
+		if !ok {
+			if from, ok = o.unprefixName(b); !ok {
+				continue // outside --dst-prefix
+			}
+		}
+		if known[from] || !keep(from) {
+			continue // excluded on the source side
+		}
+		pairs = append(pairs, syncPair{src: from, dst: b})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].src < pairs[j].src })
+	return pairs
+}
+
+// syncCounts is what a run did to the messages of a mailbox pair.
+type syncCounts struct {
+	toDst, toSrc int
+	deleted      int // destination messages removed by --delete
+	flagged      int // messages whose flags were updated (CONDSTORE)
+}
+
+// syncMailboxPair reconciles one mailbox pair.
+func (o *syncOptions) syncMailboxPair(ctx context.Context, st *state.State, a, b *syncSide) (syncCounts, error) {
+	var n syncCounts
+	for _, s := range []*syncSide{a, b} {
+		if err := o.checkModSeq(st, s); err != nil {
+			return n, fmt.Errorf("status %s %s: %w", s.label, s.mailbox, err)
+		}
+	}
+	if o.pairUnchanged(a, b) {
+		if o.verbose {
+			fmt.Printf("%s <-> %s: unchanged since the last run (HIGHESTMODSEQ)\n", a.mailbox, b.mailbox)
+		}
+		return n, nil
+	}
+	for _, s := range []*syncSide{a, b} {
+		if err := o.scanSide(st, s); err != nil {
+			return n, fmt.Errorf("scan %s %s: %w", s.label, s.mailbox, err)
+		}
+	}
+	toB, toA := o.pending(st, a, b)
+	if o.verbose || (dryRun && len(toA)+len(toB) > 0) {
+		fmt.Printf("%s <-> %s: %d new on source, %d new on destination; %d to copy to destination, %d to source\n",
+			a.mailbox, b.mailbox, len(a.news), len(b.news), len(toB), len(toA))
+	}
+	var errB, errA, errD error
+	if dryRun {
+		n.toDst, n.toSrc = len(toB), len(toA)
+	} else {
+		n.toDst, errB = o.transfer(ctx, st, a, b, toB)
+		if o.twoWay {
+			n.toSrc, errA = o.transfer(ctx, st, b, a, toA)
+		} else {
+			// one-way, the destination's news stay where they are; index
+			// them so the next run does not fetch their envelopes again
+			markScanned(st, b, lastNewUID(b))
+		}
+	}
+	if o.delete {
+		n.deleted, errD = o.mirrorDeletions(st, a, b)
+	}
+	for _, err := range []error{errB, errA, errD} {
+		if err != nil {
+			return n, err
+		}
+	}
+	flagged, err := o.syncFlags(a, b)
+	n.flagged += flagged
+	if err != nil {
+		return n, err
+	}
+	if o.twoWay {
+		flagged, err = o.syncFlags(b, a)
+		n.flagged += flagged
+		if err != nil {
+			return n, err
+		}
+	}
+	for _, s := range []*syncSide{a, b} {
+		if err := o.saveModSeq(st, s); err != nil {
+			return n, fmt.Errorf("status %s %s: %w", s.label, s.mailbox, err)
+		}
+	}
+	return n, nil
+}
+
+// scanSide selects the mailbox of s and collects the messages above the
+// side's high-water mark. A UIDVALIDITY change resets the side: all its
+// messages are reconciled again, which copies nothing the other side
+// already has.
+func (o *syncOptions) scanSide(st *state.State, s *syncSide) error {
+	if s.missing {
+		if dryRun {
+			return nil
+		}
+		if err := imaputil.EnsureMailbox(s.c, s.mailbox); err != nil {
+			return err
+		}
+		s.missing = false
+	}
+	status, err := imaputil.SelectMailbox(s.c, s.mailbox, true)
+	if err != nil {
+		return err
+	}
+	s.validity = status.UidValidity
+	s.scanned = st.MessageIDScan(s.stateKey, status.UidValidity)
+	if status.Messages == 0 || (status.UidNext > 0 && status.UidNext <= s.scanned+1) {
+		return nil
+	}
+	seq := new(imap.SeqSet)
+	seq.AddRange(s.scanned+1, 0)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope}, msgs)
+	}()
+	for m := range msgs {
+		if m.Uid <= s.scanned {
+			continue // "*" matches the last message even if it is older
+		}
+		s.news = append(s.news, syncMsg{uid: m.Uid, key: reconcileKey(m.Envelope)})
+	}
+	sort.Slice(s.news, func(i, j int) bool { return s.news[i].uid < s.news[j].uid })
+	return <-done
+}
+
+// pairUnchanged reports whether a pair can be skipped because nothing
+// happened since the last run (CONDSTORE). One-way, destination changes
+// matter only once the source has news.
+func (o *syncOptions) pairUnchanged(a, b *syncSide) bool {
+	return a.unchanged && (b.unchanged || !o.twoWay)
+}
+
+// pending returns the messages to copy from a to b and, with --two-way,
+// from b to a.
+func (o *syncOptions) pending(st *state.State, a, b *syncSide) (toB, toA []syncMsg) {
+	toB = pendingFor(st, a, b)
+	if o.twoWay {
+		toA = pendingFor(st, b, a)
+	}
+	return toB, toA
+}
+
+// pendingFor returns the new messages of from that to has neither seen in
+// an earlier run nor received since.
+func pendingFor(st *state.State, from, to *syncSide) []syncMsg {
+	arrived := map[string]bool{}
+	for _, m := range to.news {
+		arrived[m.key] = true
+	}
+	var out []syncMsg
+	for _, m := range from.news {
+		if m.key != "" && (arrived[m.key] || st.HasMessageID(to.stateKey, m.key)) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// syncFetchBatch is how many messages are fetched per round trip before
+// they are appended to the other side.
+const syncFetchBatch = 20
+
+// transfer copies msgs from one side to the other and then advances the
+// high-water mark of from. If an append fails, the mark stops below the
+// failed message so the next run retries it.
+func (o *syncOptions) transfer(ctx context.Context, st *state.State, from, to *syncSide, msgs []syncMsg) (int, error) {
+	limit := lastNewUID(from)
+	copied := 0
+	var err error
+	for i := 0; i < len(msgs) && err == nil; i += syncFetchBatch {
+		batch := msgs[i:min(i+syncFetchBatch, len(msgs))]
+		var n int
+		n, err = o.transferBatch(ctx, from, to, batch, &copied)
+		if err != nil {
+			limit = batch[n].uid - 1
+		}
+	}
+
+	markScanned(st, from, limit)
+	if o.verbose && copied > 0 {
+		fmt.Printf("  %s -> %s: copied %d message(s)\n", from.mailbox, to.mailbox, copied)
+	}
+	return copied, err
+}
+
+// lastNewUID returns the UID of the newest message of s found by the
+// scan, 0 if there is none.
+func lastNewUID(s *syncSide) uint32 {
+	if len(s.news) == 0 {
+		return 0
+	}
+	return s.news[len(s.news)-1].uid
+}
+
+// markScanned adds the new messages of s up to UID limit to the side's
+// Message-ID index and moves its high-water mark there, so the next run
+// scans only above it.
+func markScanned(st *state.State, s *syncSide, limit uint32) {
+	var keys []string
+	for _, m := range s.news {
+		if m.uid <= limit && m.key != "" {
+			keys = append(keys, m.key)
+		}
+	}
+	st.AddScannedMessageIDs(s.stateKey, s.validity, max(limit, s.scanned), keys)
+}
+
+// transferBatch fetches batch from its side and appends the messages to
+// the other, in UID order, counting appends in copied. On error it
+// returns the index of the message that failed.
+func (o *syncOptions) transferBatch(ctx context.Context, from, to *syncSide, batch []syncMsg, copied *int) (int, error) {
+	if _, err := imaputil.SelectMailbox(from.c, from.mailbox, true); err != nil {
+		return 0, fmt.Errorf("select %s %s: %w", from.label, from.mailbox, err)
+	}
+	seq := new(imap.SeqSet)
+	for _, m := range batch {
+		seq.AddNum(m.uid)
+	}
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchFlags, imap.FetchInternalDate, section.FetchItem()}
+	if from.rfc822 {
+		items[3] = imap.FetchRFC822 // filed under BODY[] as well
+	}
+	msgs := make(chan *imap.Message, len(batch))
+	if err := from.c.UidFetch(seq, items, msgs); err != nil {
+		return 0, fmt.Errorf("fetch from %s %s: %w", from.label, from.mailbox, err)
+	}
+	fetched := map[uint32]*imap.Message{}
+	for m := range msgs {
+		fetched[m.Uid] = m
+	}
+
+	if _, err := imaputil.SelectMailbox(to.c, to.mailbox, false); err != nil {
+		return 0, fmt.Errorf("select %s %s: %w", to.label, to.mailbox, err)
+	}
+	for i, m := range batch {
+		msg := fetched[m.uid]
+		if msg == nil {
+			continue // expunged since the scan
+		}
+		body := msg.GetBody(section)
+		if body == nil {
+			return i, fmt.Errorf("fetch UID %d from %s %s: no body", m.uid, from.label, from.mailbox)
+		}
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(body); err != nil {
+			return i, fmt.Errorf("read UID %d from %s %s: %w", m.uid, from.label, from.mailbox, err)
+		}
+		flags := make([]string, 0, len(msg.Flags))
+		for _, f := range msg.Flags {
+			if !strings.EqualFold(f, imap.RecentFlag) {
+				flags = append(flags, f)
+			}
+		}
+		err := o.pace.Do(ctx, func() error {
+			return imaputil.Append(to.c, to.mailbox, o.flagMap.Apply(flags), imaputil.InternalDate(msg.InternalDate, buf.Bytes()), buf.Bytes())
+		})
+		if err != nil {
+			return i, fmt.Errorf("append to %s %s: %w", to.label, to.mailbox, err)
+		}
+		*copied++
+	}
+	return len(batch), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+func TestSyncPairs(t *testing.T) {
+	src := []string{"Archive", "INBOX", "Trash"}
+	keep := func(name string) bool { return name != "Trash" }
+	tests := []struct {
+		name string
+		o    syncOptions
+		dst  []string
+		want string
+	}{
+		{"one-way", syncOptions{}, []string{"Archive", "INBOX", "Later"},
+			"[{Archive Archive} {INBOX INBOX}]"},
+		{"two-way", syncOptions{twoWay: true}, []string{"Archive", "INBOX", "Later", "Trash"},
+			"[{Archive Archive} {INBOX INBOX} {Later Later}]"},
+		{"two-way mapped", syncOptions{twoWay: true, copyOptions: copyOptions{mapPairs: []string{"Archive=Ablage", "Notes=Later"}}},
+			[]string{"Ablage", "INBOX", "Later", "Papierkorb"},
+			"[{Archive Ablage} {INBOX INBOX} {Notes Later} {Papierkorb Papierkorb}]"},
+		{"two-way prefix", syncOptions{twoWay: true, copyOptions: copyOptions{dstPrefix: "Old"}},
+			[]string{"Old/Archive", "Old/INBOX", "Old/Projects", "Other"},
+			"[{Archive Old/Archive} {INBOX Old/INBOX} {Projects Old/Projects}]"},
+	}
+	for _, tt := range tests {
+		folderMap := parseMappings(tt.o.mapPairs)
+		if tt.o.dstPrefix != "" {
+			for _, b := range src {
+				folderMap[b] = tt.o.dstPrefix + "/" + b
+			}
+		}
+		if got := fmt.Sprint(tt.o.syncPairs(src, tt.dst, keep, folderMap)); got != tt.want {
+			t.Errorf("%s: pairs %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSyncPending(t *testing.T) {
+	st := &state.State{}
+	st.AddScannedMessageIDs("sync:dst:INBOX", 1, 10, []string{"<old@x>"})
+	st.AddScannedMessageIDs("sync:src:INBOX", 1, 10, []string{"<back@x>"})
+	a := &syncSide{stateKey: "sync:src:INBOX", news: []syncMsg{{11, "<new@x>"}, {12, "<old@x>"}, {13, "<both@x>"}, {14, ""}}}
+	b := &syncSide{stateKey: "sync:dst:INBOX", news: []syncMsg{{11, "<both@x>"}, {12, "<back@x>"}, {13, "<reply@x>"}}}
+	tests := []struct {
+		twoWay   bool
+		toB, toA string
+	}{
+		{false, "[{11 <new@x>} {14 }]", "[]"},
+		{true, "[{11 <new@x>} {14 }]", "[{13 <reply@x>}]"},
+	}
+	for _, tt := range tests {
+		o := &syncOptions{twoWay: tt.twoWay}
+		toB, toA := o.pending(st, a, b)
+		if fmt.Sprint(toB) != tt.toB || fmt.Sprint(toA) != tt.toA {
+			t.Errorf("two-way %v: to destination %v, to source %v; want %s, %s", tt.twoWay, toB, toA, tt.toB, tt.toA)
+		}
+	}
+}
+
+func TestSyncPairUnchanged(t *testing.T) {
+	tests := []struct {
+		twoWay, srcUnchanged, dstUnchanged bool
+		want                               bool
+	}{
+		{false, true, false, true},
+		{false, false, true, false},
+		{true, true, false, false},
+		{true, true, true, true},
+		{true, false, true, false},
+	}
+	for _, tt := range tests {
+		o := &syncOptions{twoWay: tt.twoWay}
+		if got := o.pairUnchanged(&syncSide{unchanged: tt.srcUnchanged}, &syncSide{unchanged: tt.dstUnchanged}); got != tt.want {
+			t.Errorf("two-way %v, source unchanged %v, destination unchanged %v: skip = %v, want %v", tt.twoWay, tt.srcUnchanged, tt.dstUnchanged, got, tt.want)
+		}
+	}
+}
+
+func TestMarkScanned(t *testing.T) {
+	st := &state.State{}
+	s := &syncSide{stateKey: "sync:dst:INBOX", validity: 7, scanned: 4, news: []syncMsg{{5, "<a@x>"}, {6, ""}, {9, "<b@x>"}}}
+	tests := []struct {
+		limit    uint32
+		wantScan uint32
+		wantIDs  string
+	}{
+		{0, 4, "[false false]"},
+		{6, 6, "[true false]"},
+		{lastNewUID(s), 9, "[true true]"},
+	}
+	for _, tt := range tests {
+		markScanned(st, s, tt.limit)
+		got := fmt.Sprint([]bool{st.HasMessageID(s.stateKey, "<a@x>"), st.HasMessageID(s.stateKey, "<b@x>")})
+		if scan := st.MessageIDScan(s.stateKey, 7); scan != tt.wantScan || got != tt.wantIDs {
+			t.Errorf("limit %d: scanned to %d, IDs %s; want %d, %s", tt.limit, scan, got, tt.wantScan, tt.wantIDs)
+		}
+	}
+	if lastNewUID(&syncSide{}) != 0 {
+		t.Error("lastNewUID of a side without news is not 0")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/quarantine"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// fetchKeys returns the reconciliation key per UID of the messages uids
+// of the selected mailbox.
+func fetchKeys(c *client.Client, uids []uint32) (map[uint32]string, error) {
+	keys := map[uint32]string{}
+	if len(uids) == 0 {
+		return keys, nil
+	}
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope}, msgs)
+	}()
+	for m := range msgs {
+		keys[m.Uid] = reconcileKey(m.Envelope)
+	}
+	return keys, <-done
+}
+
+// mirrorDeletions removes the destination copies of messages that were
+// deleted on the source since the last --delete run and returns how many
+// destination messages it removed (or would remove in a dry run). The
+// first run only records the source's message set.
+func (o *syncOptions) mirrorDeletions(st *state.State, a, b *syncSide) (int, error) {
+	if _, err := imaputil.SelectMailbox(a.c, a.mailbox, true); err != nil {
+		return 0, fmt.Errorf("select source %s: %w", a.mailbox, err)
+	}
+	current, err := a.c.UidSearch(imap.NewSearchCriteria())
+	if err != nil {
+		return 0, fmt.Errorf("search source %s: %w", a.mailbox, err)
+	}
+	prev := st.GetMirrorSet(a.stateKey, a.validity)
+	if prev == nil {
+		all, err := fetchKeys(a.c, current)
+		if err != nil {
+			return 0, fmt.Errorf("fetch source %s: %w", a.mailbox, err)
+		}
+		if !dryRun {
+			st.SetMirrorSet(a.stateKey, a.validity, all)
+		}
+		if o.verbose {
+			fmt.Printf("  %s: recorded %d message(s) for --delete; deletions are mirrored from the next run on\n", a.mailbox, len(all))
+		}
+		return 0, nil
+	}
+	next := make(map[uint32]string, len(current))
+	var unknown []uint32
+	for _, uid := range current {
+		if key, ok := prev[uid]; ok {
+			next[uid] = key
+		} else {
+			unknown = append(unknown, uid)
+		}
+	}
+	if len(unknown) > 0 {
+		keys, err := fetchKeys(a.c, unknown)
+		if err != nil {
+			return 0, fmt.Errorf("fetch source %s: %w", a.mailbox, err)
+		}
+		for uid, key := range keys {
+			next[uid] = key
+		}
+	}
+	// a key is gone once no source message carries it any more
+	remaining := map[string]bool{}
+	for _, key := range next {
+		remaining[key] = true
+	}
+	gone := map[string][]uint32{}
+	for uid, key := range prev {
+		if _, ok := next[uid]; !ok && key != "" && !remaining[key] {
+			gone[key] = append(gone[key], uid)
+		}
+	}
+	if len(gone) == 0 {
+		if !dryRun {
+			st.SetMirrorSet(a.stateKey, a.validity, next)
+		}
+		return 0, nil
+	}
+
+	var uids []uint32
+	if !b.missing {
+		if _, err := imaputil.SelectMailbox(b.c, b.mailbox, true); err != nil {
+			return 0, fmt.Errorf("select destination %s: %w", b.mailbox, err)
+		}
+		all, err := b.c.UidSearch(imap.NewSearchCriteria())
+		if err != nil {
+			return 0, fmt.Errorf("search destination %s: %w", b.mailbox, err)
+		}
+		dstKeys, err := fetchKeys(b.c, all)
+		if err != nil {
+			return 0, fmt.Errorf("fetch destination %s: %w", b.mailbox, err)
+		}
+		for uid, key := range dstKeys {
+			if _, ok := gone[key]; ok {
+				uids = append(uids, uid)
+			}
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	}
+	if dryRun || len(uids) == 0 {
+		if dryRun && len(uids) > 0 {
+			fmt.Printf("%s: %d message(s) deleted on the source, would %s %d on the destination\n", a.mailbox, len(gone), o.deleteAction(), len(uids))
+		}
+		if !dryRun {
+			st.SetMirrorSet(a.stateKey, a.validity, next)
+		}
+		return len(uids), nil
+	}
+
+	if err := o.removeFromDst(b, uids); err != nil {
+		// keep the deleted messages in the set so the next run retries
+		for key, old := range gone {
+			for _, uid := range old {
+				next[uid] = key
+			}
+		}
+		st.SetMirrorSet(a.stateKey, a.validity, next)
+		return 0, err
+	}
+	st.SetMirrorSet(a.stateKey, a.validity, next)
+	if o.verbose {
+		fmt.Printf("  %s: removed %d message(s) deleted on the source (%s)\n", b.mailbox, len(uids), o.deleteAction())
+	}
+	return len(uids), nil
+}
+
+// deleteAction names what --delete-mode does, for messages.
+func (o *syncOptions) deleteAction() string {
+	switch o.deleteMode {
+	case "trash":
+		return "move to " + o.dstTrash
+	case "expunge":
+		return "expunge"
+	}
+	return "quarantine"
+}
+
+// removeFromDst applies --delete-mode to the destination messages uids.
+func (o *syncOptions) removeFromDst(b *syncSide, uids []uint32) error {
+	switch o.deleteMode {
+	case "trash":
+		if _, err := imaputil.SelectMailbox(b.c, b.mailbox, dryRun); err != nil {
+			return fmt.Errorf("select destination %s: %w", b.mailbox, err)
+		}
+		if err := moveUIDs(b.c, b.mailbox, uids, o.dstTrash); err != nil {
+			return fmt.Errorf("move to %s: %w", o.dstTrash, err)
+		}
+	case "expunge":
+		if _, err := imaputil.SelectMailbox(b.c, b.mailbox, dryRun); err != nil {
+			return fmt.Errorf("select destination %s: %w", b.mailbox, err)
+		}
+		// refuse before marking anything when EXPUNGE would take other
+		// \Deleted messages along
+		if err := imaputil.CheckExpungeUIDs(b.c, uids); err != nil {
+			return fmt.Errorf("expunge %s: %w", b.mailbox, err)
+		}
+		if err := storeUIDs(b.c, b.mailbox, uids, imap.AddFlags, []interface{}{imap.DeletedFlag}); err != nil {
+			return fmt.Errorf("mark deleted in %s: %w", b.mailbox, err)
+		}
+		if err := expungeUIDs(b.c, b.mailbox, uids); err != nil {
+			return fmt.Errorf("expunge %s: %w", b.mailbox, err)
+		}
+	default:
+		if _, err := quarantine.Stage(b.c, o.dstDelim, b.mailbox, uids, time.Now()); err != nil {
+			return fmt.Errorf("quarantine: %w", err)
+		}
+	}
+	return nil
+}
+
+// purgeQuarantine deletes the destination's quarantine folders older
+// than --quarantine-days.
+func (o *syncOptions) purgeQuarantine(dst *client.Client, boxes []string) error {
+	if o.quarantineDays <= 0 {
+		return nil
+	}
+	retention := time.Duration(o.quarantineDays) * 24 * time.Hour
+	deleted, err := quarantine.Purge(dst, o.dstDelim, boxes, retention, time.Now())
+	if o.verbose && len(deleted) > 0 {
+		fmt.Printf("Purged %d expired quarantine folder(s)\n", len(deleted))
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// checkModSeq compares the STATUS of the mailbox of s on a CONDSTORE
+// server with the mark of the last run, without selecting it. The side
+// is unchanged when no message was added, expunged or flagged since and
+// every message up to UIDNEXT was handled by an earlier run.
+func (o *syncOptions) checkModSeq(st *state.State, s *syncSide) error {
+	if !s.condstore || s.missing {
+		return nil
+	}
+	ms, err := imaputil.StatusModSeq(s.c, s.mailbox)
+	if err != nil {
+		return err
+	}
+	prev, ok := st.GetModSeq(s.stateKey)
+	if !ok || prev.UIDValidity != ms.UIDValidity || prev.HighestModSeq == 0 {
+		return nil
+	}
+	s.prevModSeq = prev.HighestModSeq
+	s.unchanged = state.ModSeqMark(ms) == prev && ms.UIDNext <= st.MessageIDScan(s.stateKey, ms.UIDValidity)+1
+	return nil
+}
+
+// saveModSeq records the STATUS of the mailbox of s after a run. The
+// mark is taken at the end so the changes of the run itself (appends,
+// flag updates) do not count as changes next time.
+func (o *syncOptions) saveModSeq(st *state.State, s *syncSide) error {
+	if !s.condstore || s.missing || dryRun {
+		return nil
+	}
+	ms, err := imaputil.StatusModSeq(s.c, s.mailbox)
+	if err != nil {
+		return err
+	}
+	if ms.HighestModSeq > 0 {
+		st.SetModSeq(s.stateKey, state.ModSeqMark(ms))
+	}
+	return nil
+}
+
+// syncFlags copies the flags of messages of from that changed since the
+// last run (CHANGEDSINCE) to their copies on to, found by Message-ID.
+// Messages new in this run are left out: they were copied with their
+// flags. It returns how many messages of to were updated.
+func (o *syncOptions) syncFlags(from, to *syncSide) (int, error) {
+	if from.prevModSeq == 0 || from.unchanged || from.scanned == 0 || to.missing {
+		return 0, nil
+	}
+	if _, err := imaputil.SelectMailbox(from.c, from.mailbox, true); err != nil {
+		return 0, fmt.Errorf("select %s %s: %w", from.label, from.mailbox, err)
+	}
+	seq := new(imap.SeqSet)
+	seq.AddRange(1, from.scanned)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- imaputil.FetchChangedSince(from.c, seq, from.prevModSeq, []imap.FetchItem{imap.FetchUid, imap.FetchFlags, imap.FetchEnvelope}, msgs)
+	}()
+	changed := map[string][]string{}
+	for m := range msgs {
+		if m.Uid > from.scanned || m.Envelope == nil {
+			continue
+		}
+		if id := strings.TrimSpace(m.Envelope.MessageId); id != "" {
+			changed[id] = storableFlags(m.Flags)
+		}
+	}
+	if err := <-done; err != nil {
+		return 0, fmt.Errorf("fetch changed flags from %s %s: %w", from.label, from.mailbox, err)
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	if dryRun {
+		if o.verbose {
+			fmt.Printf("  %s -> %s: flags changed on %d message(s)\n", from.mailbox, to.mailbox, len(changed))
+		}
+		return len(changed), nil
+	}
+
+	if _, err := imaputil.SelectMailbox(to.c, to.mailbox, false); err != nil {
+		return 0, fmt.Errorf("select %s %s: %w", to.label, to.mailbox, err)
+	}
+	updated := 0
+	for id, flags := range changed {
+		criteria := imap.NewSearchCriteria()
+		criteria.Header.Add("Message-Id", id)
+		uids, err := to.c.UidSearch(criteria)
+		if err != nil {
+			return updated, fmt.Errorf("search %s %s: %w", to.label, to.mailbox, err)
+		}
+		if len(uids) == 0 {
+			continue // not copied, or deleted on this side
+		}
+		stale, err := staleFlagUIDs(to, uids, flags)
+		if err != nil {
+			return updated, err
+		}
+		if stale.Empty() {
+			continue
+		}
+		values := make([]interface{}, len(flags))
+		for i, f := range flags {
+			values[i] = f
+		}
+		if err := to.c.UidStore(stale, imap.FormatFlagsOp(imap.SetFlags, true), values, nil); err != nil {
+			return updated, fmt.Errorf("store flags in %s %s: %w", to.label, to.mailbox, err)
+		}
+		updated++
+	}
+	if o.verbose && updated > 0 {
+		fmt.Printf("  %s -> %s: updated flags of %d message(s)\n", from.mailbox, to.mailbox, updated)
+	}
+	return updated, nil
+}
+
+// staleFlagUIDs returns the messages of uids whose flags differ from
+// flags, so unchanged copies are not written (and their mod-sequence not
+// bumped).
+func staleFlagUIDs(s *syncSide, uids []uint32, flags []string) (*imap.SeqSet, error) {
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	msgs := make(chan *imap.Message, len(uids))
+	if err := s.c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, msgs); err != nil {
+		return nil, fmt.Errorf("fetch flags from %s %s: %w", s.label, s.mailbox, err)
+	}
+	want := sortedFlags(flags)
+	stale := new(imap.SeqSet)
+	for m := range msgs {
+		if !slices.Equal(sortedFlags(storableFlags(m.Flags)), want) {
+			stale.AddNum(m.Uid)
+		}
+	}
+	return stale, nil
+}
+
+// storableFlags drops \Recent, which clients cannot set.
+func storableFlags(flags []string) []string {
+	out := make([]string, 0, len(flags))
+	for _, f := range flags {
+		if !strings.EqualFold(f, imap.RecentFlag) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func sortedFlags(flags []string) []string {
+	out := make([]string, len(flags))
+	for i, f := range flags {
+		out[i] = strings.ToLower(f)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/mailparse"
+)
+
+type tailOptions struct {
+	sourceOptions
+	lines int
+	poll  time.Duration
+}
+
+func addTailFlags(cmd *cobra.Command) {
+	o := &tailOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().IntVarP(&o.lines, "lines", "n", 10, "Print the last N existing messages first")
+	cmd.Flags().DurationVar(&o.poll, "poll", 30*time.Second, "Polling interval when the server does not support IDLE")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// runTail prints a line per message as new mail arrives in a mailbox,
+// using IDLE (or NOOP polling) on a read-only selection. It runs until
+// interrupted.
+func runTail(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*tailOptions)
+	mailbox := "INBOX"
+	if len(args) > 0 {
+		mailbox = imaputil.DecodeMailboxName(args[0])
+	}
+	if err := o.resolve(cmd); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c, err := o.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	newMail := newMailSignal(c)
+	status, err := imaputil.SelectMailbox(c, mailbox, true)
+	if err != nil {
+		return fmt.Errorf("select %s: %w", mailbox, err)
+	}
+
+	// the last --lines messages, by sequence number; at least the last
+	// one to learn the highest UID
+	var lastUID uint32
+	if status.Messages > 0 {
+		n := uint32(1)
+		if o.lines > 1 {
+			n = uint32(o.lines)
+		}
+		if n > status.Messages {
+			n = status.Messages
+		}
+		seq := new(imap.SeqSet)
+		seq.AddRange(status.Messages-n+1, status.Messages)
+		var show func(*imap.Message)
+		if o.lines > 0 {
+			show = printTailLine
+		}
+		if lastUID, err = fetchSummaries(c, false, seq, 0, show); err != nil {
+			return err
+		}
+	}
+	if status.UidNext > lastUID+1 {
+		lastUID = status.UidNext - 1
+	}
+	fmt.Fprintf(os.Stderr, "Watching %s for new messages (Ctrl-C to stop)...\n", mailbox)
+
+	return followNewMessages(ctx, c, newMail, o.poll, lastUID, printTailLine)
+}
+
+// newMailSignal sets up c to report new mail in the selected mailbox on
+// the returned channel. The client blocks until updates are read, so they
+// are drained all the time and only the fact that new mail arrived is
+// kept.
+func newMailSignal(c *client.Client) <-chan struct{} {
+	updates := make(chan client.Update, 16)
+	newMail := make(chan struct{}, 1)
+	go func() {
+		for u := range updates {
+			if _, ok := u.(*client.MailboxUpdate); ok {
+				select {
+				case newMail <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	c.Updates = updates
+	return newMail
+}
+
+// followNewMessages waits for new mail in the selected mailbox with IDLE (or
+// NOOP polling every poll) and calls each for every message with a UID
+// above lastUID, in UID order. It returns nil once ctx is done.
+func followNewMessages(ctx context.Context, c *client.Client, newMail <-chan struct{}, poll time.Duration, lastUID uint32, each func(*imap.Message)) error {
+	for {
+		stopIdle := make(chan struct{})
+		idleDone := make(chan error, 1)
+		go func() {
+			idleDone <- c.Idle(stopIdle, &client.IdleOptions{PollInterval: poll})
+		}()
+		select {
+		case <-ctx.Done():
+			close(stopIdle)
+			<-idleDone
+			return nil
+		case err := <-idleDone:
+			return fmt.Errorf("idle: %w", err)
+		case <-newMail:
+		}
+		close(stopIdle)
+		if err := <-idleDone; err != nil {
+			return fmt.Errorf("idle: %w", err)
+		}
+		seq := new(imap.SeqSet)
+		seq.AddRange(lastUID+1, 0)
+		var err error
+		if lastUID, err = fetchSummaries(c, true, seq, lastUID, each); err != nil {
+			return err
+		}
+	}
+}
+
+// fetchSummaries fetches the envelopes of seq and, if each is set, calls
+// it in UID order for every message with a UID above minUID (UID ranges
+// ending in "*" always match the last message). It returns the highest UID
+// seen, at least minUID.
+func fetchSummaries(c *client.Client, uid bool, seq *imap.SeqSet, minUID uint32, each func(*imap.Message)) (uint32, error) {
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchInternalDate, imap.FetchRFC822Size}
+	msgs := make(chan *imap.Message, 16)
+	done := make(chan error, 1)
+	go func() {
+		if uid {
+			done <- c.UidFetch(seq, items, msgs)
+		} else {
+			done <- c.Fetch(seq, items, msgs)
+		}
+	}()
+	max := minUID
+	var found []*imap.Message
+	for m := range msgs {
+		if m.Uid <= minUID {
+			continue
+		}
+		if m.Uid > max {
+			max = m.Uid
+		}
+		found = append(found, m)
+	}
+	if err := <-done; err != nil {
+		return max, fmt.Errorf("fetch: %w", err)
+	}
+	if each != nil {
+		sort.Slice(found, func(i, j int) bool { return found[i].Uid < found[j].Uid })
+		for _, m := range found {
+			each(m)
+		}
+	}
+	return max, nil
+}
+
+// printTailLine prints the tailLine of a message.
+func printTailLine(m *imap.Message) {
+	fmt.Println(tailLine(m))
+}
+
+// tailLine formats a message as "UID  date  from  subject".
+func tailLine(m *imap.Message) string {
+	date, from, subject := messageSummary(m)
+	return fmt.Sprintf("%6d  %s  %s  %s", m.Uid, date.Local().Format("2006-01-02 15:04"), from, subject)
+}
+
+// messageSummary returns the date (Date header, else INTERNALDATE), the
+// first sender and the subject of a message fetched with its envelope,
+// decoded and on one line.
+func messageSummary(m *imap.Message) (date time.Time, from, subject string) {
+	date = m.InternalDate
+	if e := m.Envelope; e != nil {
+		if !e.Date.IsZero() {
+			date = e.Date
+		}
+		if len(e.From) > 0 {
+			a := e.From[0]
+			from = a.Address()
+			if name := mailparse.DecodeHeader(a.PersonalName); name != "" {
+				from = fmt.Sprintf("%s <%s>", name, from)
+			}
+		}
+		subject = strings.Join(strings.Fields(mailparse.DecodeHeader(e.Subject)), " ")
+	}
+	return date, from, subject
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
@@ -26,6 +27,7 @@ type model struct {
 	worker   *syncer.MailboxSyncer
 	boxes    []string
 	prog     map[string]mailboxProgress
+	large    map[string]syncer.Event // message progress of large messages, by mailbox
 	totalAll int
 	doneAll  int
 	spinner  spinner.Model
@@ -49,7 +51,7 @@ func newModel(ctx context.Context, worker *syncer.MailboxSyncer, boxes []string)
 	s.Spinner = spinner.Line
 	bar := progress.New(progress.WithDefaultGradient())
 	now := time.Now()
-	return &model{ctx: cctx, cancel: cancel, worker: worker, boxes: boxes, prog: map[string]mailboxProgress{}, spinner: s, bar: bar, started: now, lastAt: now}
+	return &model{ctx: cctx, cancel: cancel, worker: worker, boxes: boxes, prog: map[string]mailboxProgress{}, large: map[string]syncer.Event{}, spinner: s, bar: bar, started: now, lastAt: now}
 }
 
 func (m *model) Init() tea.Cmd {
@@ -104,8 +106,11 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				mp := m.prog[ev.Mailbox]
 				mp.total, mp.done = ev.Total, ev.Done
 				m.prog[ev.Mailbox] = mp
+				delete(m.large, ev.Mailbox)
 				// Update global
 				m.recomputeTotals()
+			case syncer.EventMessageProgress:
+				m.large[ev.Mailbox] = ev
 			}
 		default:
 			return m, nil
@@ -132,6 +137,22 @@ func (m *model) View() string {
 	eta := m.formatETA()
 	s += fmt.Sprintf("%s Overall %d/%d   %s\n", m.spinner.View(), m.doneAll, m.totalAll, eta)
 	s += m.bar.ViewAs(pct) + "\n\n"
+	if !m.finished && len(m.large) > 0 {
+		boxes := make([]string, 0, len(m.large))
+		for b := range m.large {
+			boxes = append(boxes, b)
+		}
+		sort.Strings(boxes)
+		for _, b := range boxes {
+			ev := m.large[b]
+			verb := "fetching"
+			if ev.Phase == "append" {
+				verb = "appending"
+			}
+			s += fmt.Sprintf("  %s UID %d: %s %s / %s\n", b, ev.UID, verb, formatBytes(ev.Bytes), formatBytes(ev.Size))
+		}
+		s += "\n"
+	}
 	if m.finished && len(m.errs) > 0 {
 		s += lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Render("Errors:\n")
 		for _, e := range m.errs {
@@ -190,6 +211,20 @@ func (m *model) formatETA() string {
 	return fmt.Sprintf("ETA %ds", int(d.Seconds()))
 }
 
+// formatBytes formats n as a binary size such as "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // updateEMARate updates the EMA of processing rate based on deltas since last tick.
 func (m *model) updateEMARate() {
 	now := time.Now()
@@ -532,6 +567,41 @@ func runCountTUI(total int, title string, progress <-chan int, errc <-chan error
 	return []error{}
 }
 
+// runCountWork runs work in the background behind a runCountTUI progress
+// bar and waits for it. Quitting the bar (q, ctrl+c) cancels the context
+// passed to work, which should stop at the next point where nothing is
+// left half done. It returns the error of work, the only error
+// runCountTUI reports. A dry run has no progress bar: work lists what it
+// would do instead.
+func runCountWork(ctx context.Context, total int, title string, work func(ctx context.Context, progress chan<- int) error) error {
+	if dryRun {
+		progress := make(chan int)
+		go func() {
+			for range progress {
+			}
+		}()
+		defer close(progress)
+		return work(ctx, progress)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	progress := make(chan int, 128)
+	errc := make(chan error, 1)
+	var workErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		workErr = work(ctx, progress)
+		close(progress)
+		errc <- workErr
+		close(errc)
+	}()
+	_ = runCountTUI(total, title, progress, errc)
+	cancel()
+	<-done
+	return workErr
+}
+
 type confirmModel struct {
 	title   string
 	summary string
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const releasesAPI = "https://api.github.com/repos/pepperpark/gomap/releases/latest"
+
+// updatePublicKey is the base64 ed25519 public key used to verify the
+// signature of checksums.txt. Set via -ldflags at build time.
+var updatePublicKey = ""
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type releaseInfo struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+func (r *releaseInfo) version() string { return strings.TrimPrefix(r.TagName, "v") }
+
+func (r *releaseInfo) asset(name string) (releaseAsset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+func fetchLatestRelease(ctx context.Context) (*releaseInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releasesAPI, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("query latest release: %s", resp.Status)
+	}
+	var rel releaseInfo
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// newerVersion reports whether latest is newer than current. Development
+// builds are always considered outdated.
+func newerVersion(current, latest string) bool {
+	if current == "dev" || current == "" {
+		return true
+	}
+	parse := func(v string) []int {
+		v = strings.SplitN(strings.TrimPrefix(v, "v"), "-", 2)[0]
+		out := []int{}
+		for _, p := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(p)
+			out = append(out, n)
+		}
+		return out
+	}
+	a, b := parse(current), parse(latest)
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return y > x
+		}
+	}
+	return false
+}
+
+// updateNotice checks for a newer release in the background and returns a
+// function that yields a one-line notice (or "") once the run is over. The
+// check never delays the caller by more than a few seconds and can be
+// disabled with GOMAP_NO_UPDATE_CHECK=1.
+func updateNotice(ctx context.Context) func() string {
+	if os.Getenv("GOMAP_NO_UPDATE_CHECK") == "1" || version == "dev" {
+		return func() string { return "" }
+	}
+	ch := make(chan string, 1)
+	go func() {
+		cctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		rel, err := fetchLatestRelease(cctx)
+		if err != nil || !newerVersion(version, rel.version()) {
+			ch <- ""
+			return
+		}
+		ch <- fmt.Sprintf("A newer gomap version is available: %s (current %s). Run 'gomap self-update' to upgrade.", rel.version(), version)
+	}()
+	return func() string {
+		select {
+		case s := <-ch:
+			return s
+		case <-time.After(100 * time.Millisecond):
+			return ""
+		}
+	}
+}
+
+func download(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// verifiedChecksum downloads checksums.txt and its ed25519 signature,
+// verifies the signature and returns the expected SHA-256 of assetName.
+func verifiedChecksum(ctx context.Context, rel *releaseInfo, assetName string) (string, error) {
+	if updatePublicKey == "" {
+		return "", fmt.Errorf("this build has no release signing key; download releases manually")
+	}
+	pub, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("invalid embedded release signing key")
+	}
+	sums, ok := rel.asset("checksums.txt")
+	if !ok {
+		return "", fmt.Errorf("release %s has no checksums.txt", rel.TagName)
+	}
+	sig, ok := rel.asset("checksums.txt.sig")
+	if !ok {
+		return "", fmt.Errorf("release %s has no checksums.txt.sig", rel.TagName)
+	}
+	var sumsBuf, sigBuf strings.Builder
+	if err := download(ctx, sums.URL, &sumsBuf); err != nil {
+		return "", err
+	}
+	if err := download(ctx, sig.URL, &sigBuf); err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), []byte(sumsBuf.String()), []byte(sigBuf.String())) {
+		return "", fmt.Errorf("signature verification of checksums.txt failed")
+	}
+	sc := bufio.NewScanner(strings.NewReader(sumsBuf.String()))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[1] == assetName {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no entry for %s", assetName)
+}
+
+type selfUpdateOptions struct {
+	checkOnly bool
+	yes       bool
+}
+
+func addSelfUpdateFlags(cmd *cobra.Command) {
+	o := &selfUpdateOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().BoolVar(&o.checkOnly, "check", false, "Only check whether a newer release exists")
+	cmd.Flags().BoolVar(&o.yes, "yes", false, "Do not ask for confirmation")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+func runUpgradeCheck(cmd *cobra.Command, args []string) error {
+	rel, err := fetchLatestRelease(cmd.Context())
+	if err != nil {
+		return err
+	}
+	if newerVersion(version, rel.version()) {
+		fmt.Printf("New version available: %s (current %s)\n", rel.version(), version)
+	} else {
+		fmt.Printf("gomap %s is up to date.\n", version)
+	}
+	return nil
+}
+
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*selfUpdateOptions)
+	if o.checkOnly {
+		return runUpgradeCheck(cmd, args)
+	}
+	ctx := cmd.Context()
+	rel, err := fetchLatestRelease(ctx)
+	if err != nil {
+		return err
+	}
+	if !newerVersion(version, rel.version()) {
+		fmt.Printf("gomap %s is up to date.\n", version)
+		return nil
+	}
+	assetName := fmt.Sprintf("gomap_%s_%s_%s", rel.version(), runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		assetName += ".exe"
+	}
+	asset, ok := rel.asset(assetName)
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	wantSum, err := verifiedChecksum(ctx, rel, assetName)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if dryRun {
+		dryRunf("replace %s (gomap %s) with gomap %s from %s", exe, version, rel.version(), asset.URL)
+		return nil
+	}
+	if !o.yes {
+		ok, err := runConfirmTUI("Confirm self-update", fmt.Sprintf("Current: %s\nNew: %s\nBinary: %s", version, rel.version(), exe))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
+	// Download next to the executable so the final rename stays on one filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".gomap-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if err := download(ctx, asset.URL, io.MultiWriter(tmp, h)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != wantSum {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", assetName, got, wantSum)
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		// A running executable cannot be replaced on Windows, but it can be renamed
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return err
+	}
+	fmt.Printf("Updated gomap %s -> %s\n", version, rel.version())
+	return nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/verify"
+)
+
+// exDiffers is the exit code of verify when mailboxes differ.
+const exDiffers = 2
+
+type verifyOptions struct {
+	copyOptions
+	deep    bool
+	compare string // count | message-id | size | sha256
+}
+
+func addVerifyFlags(cmd *cobra.Command) {
+	o := &verifyOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	cmd.Flags().StringVar(&o.srcHost, "src-host", "", "Source IMAP host")
+	cmd.Flags().IntVar(&o.srcPort, "src-port", 993, "Source IMAP port")
+	cmd.Flags().StringVar(&o.srcUser, "src-user", "", "Source IMAP username")
+	cmd.Flags().StringVar(&o.srcPass, "src-pass", "", "Source IMAP password")
+	cmd.Flags().BoolVar(&o.srcPassPrompt, "src-pass-prompt", false, "Prompt for source IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.srcIdentity, "src-identity", "", "Use the IMAP account of this identity from the config as source")
+	cmd.Flags().StringVar(&o.srcURL, "src", "", "Source as a connection URL, e.g. imaps://user@host:993 or imap+starttls://user@host (instead of --src-host, --src-port and --src-user)")
+	cmd.Flags().StringVar(&o.dstHost, "dst-host", "", "Destination IMAP host")
+	cmd.Flags().IntVar(&o.dstPort, "dst-port", 993, "Destination IMAP port")
+	cmd.Flags().StringVar(&o.dstUser, "dst-user", "", "Destination IMAP username")
+	cmd.Flags().StringVar(&o.dstPass, "dst-pass", "", "Destination IMAP password")
+	cmd.Flags().BoolVar(&o.dstPassPrompt, "dst-pass-prompt", false, "Prompt for destination IMAP password (no echo)")
+	cmd.Flags().StringVar(&o.dstIdentity, "dst-identity", "", "Use the IMAP account of this identity from the config as destination")
+	cmd.Flags().StringVar(&o.dstURL, "dst", "", "Destination as a connection URL, e.g. imaps://user@host:993 (instead of --dst-host, --dst-port and --dst-user)")
+	cmd.Flags().BoolVar(&o.insecure, "insecure", false, "Skip TLS verification")
+	cmd.Flags().BoolVar(&o.startTLS, "starttls", false, "Use STARTTLS instead of implicit TLS")
+	cmd.Flags().StringVar(&o.include, "include", "", "Regex of mailboxes to include (source names)")
+	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regex of mailboxes to exclude (source names)")
+	cmd.Flags().StringVar(&o.boxesFile, "mailboxes-file", "", "Only the mailboxes listed in this file, one per line; # starts a comment (source names; instead of --include/--exclude)")
+	cmd.Flags().BoolVar(&o.skipSpecial, "skip-special", false, "Skip common special folders like Trash/Junk/Drafts/Sent")
+	cmd.Flags().BoolVar(&o.skipTrash, "skip-trash", false, "Skip Trash folders")
+	cmd.Flags().BoolVar(&o.skipJunk, "skip-junk", false, "Skip Junk/Spam folders")
+	cmd.Flags().BoolVar(&o.skipDrafts, "skip-drafts", false, "Skip Drafts folders")
+	cmd.Flags().BoolVar(&o.skipSent, "skip-sent", false, "Skip Sent folders")
+	cmd.Flags().StringArrayVar(&o.mapPairs, "map", nil, "Folder mapping src=dst (can be repeated)")
+	cmd.Flags().StringVar(&o.srcPrefix, "src-prefix", "", "Strip this parent folder from the source folder names, e.g. INBOX or a shared namespace")
+	cmd.Flags().StringVar(&o.dstPrefix, "dst-prefix", "", "Pair the source folders with folders below this destination folder, e.g. Archive/old-account")
+	cmd.Flags().BoolVar(&o.flatten, "flatten", false, "Pair the source folders with top-level destination folders named by joining their levels, as copy --flatten does")
+	cmd.Flags().StringVar(&o.flattenSep, "flatten-separator", "_", "With --flatten: what joins the levels of a folder name")
+	cmd.Flags().BoolVar(&o.deep, "deep", false, "List the messages missing on either side in the months whose counts differ (fetches their envelopes)")
+	cmd.Flags().StringVar(&o.compare, "compare", "count", "What to compare: count (messages per month), message-id (the messages themselves), size (also their sizes) or sha256 (also their content; downloads every message)")
+	cmd.Flags().BoolVar(&o.verbose, "verbose", false, "Also list mailboxes that match")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// runVerify compares the selected source mailboxes with their
+// destination counterparts by message counts per month, computed with
+// SEARCH on the servers, and reports the months that differ. With
+// --compare other than count it matches the messages themselves and
+// lists those missing, extra or changed.
+func runVerify(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*verifyOptions)
+	if o.srcIdentity != "" {
+		_, acc, _, err := lookupIdentity(o.srcIdentity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "src", host: &o.srcHost, port: &o.srcPort, user: &o.srcUser, pass: &o.srcPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if o.dstIdentity != "" {
+		_, acc, _, err := lookupIdentity(o.dstIdentity)
+		if err != nil {
+			return err
+		}
+		applyAccount(cmd, loginTarget{prefix: "dst", host: &o.dstHost, port: &o.dstPort, user: &o.dstUser, pass: &o.dstPass, startTLS: &o.startTLS, insecure: &o.insecure}, acc)
+	}
+	if err := o.applyEndpoints(cmd); err != nil {
+		return err
+	}
+	if o.srcPassPrompt && o.srcPass == "" {
+		fmt.Fprint(os.Stderr, "Source password: ")
+		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if perr != nil {
+			return fmt.Errorf("read source password: %w", perr)
+		}
+		o.srcPass = string(b)
+	}
+	if o.dstPassPrompt && o.dstPass == "" {
+		fmt.Fprint(os.Stderr, "Destination password: ")
+		b, perr := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if perr != nil {
+			return fmt.Errorf("read destination password: %w", perr)
+		}
+		o.dstPass = string(b)
+	}
+	if o.srcHost == "" || o.srcUser == "" || o.srcPass == "" || o.dstHost == "" || o.dstUser == "" || o.dstPass == "" {
+		return fmt.Errorf("missing required flags: --src-host, --src-user, --src-pass, --dst-host, --dst-user, --dst-pass")
+	}
+	switch o.compare {
+	case "count", "message-id", "size", "sha256":
+	default:
+		return fmt.Errorf("invalid --compare: %s (must be 'count', 'message-id', 'size' or 'sha256')", o.compare)
+	}
+	keep, err := copyMailboxFilter(&o.copyOptions)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecure}
+	ctx := cmd.Context()
+	src, err := imaputil.DialAndLogin(ctx, o.srcHost, o.srcPort, o.srcUser, o.srcPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("connect source: %w", err)
+	}
+	defer src.Logout()
+	dst, err := imaputil.DialAndLogin(ctx, o.dstHost, o.dstPort, o.dstUser, o.dstPass, o.startTLS, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("connect destination: %w", err)
+	}
+	defer dst.Logout()
+	srcQuirks, dstQuirks := serverQuirks(src, o.srcHost), serverQuirks(dst, o.dstHost)
+	keep = o.applyQuirks(keep, srcQuirks, dstQuirks)
+
+	srcBoxes, err := imaputil.ListMailboxes(ctx, src)
+	if err != nil {
+		return fmt.Errorf("list source mailboxes: %w", err)
+	}
+	dstBoxes, err := imaputil.ListMailboxes(ctx, dst)
+	if err != nil {
+		return fmt.Errorf("list destination mailboxes: %w", err)
+	}
+	dstExists := map[string]bool{}
+	for _, b := range dstBoxes {
+		dstExists[b] = true
+	}
+	if err := o.loadDelimiters(src, dst); err != nil {
+		return err
+	}
+	folderMap := o.quirkFolderMap(o.folderMap(srcBoxes), srcBoxes, srcQuirks, dstQuirks)
+
+	checked, differ := 0, 0
+	var errs []error
+	for _, box := range srcBoxes {
+		if !keep(box) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		to := box
+		if m, ok := folderMap[box]; ok && m != "" {
+			to = m
+		}
+		checked++
+		if !dstExists[to] {
+			fmt.Printf("%s -> %s: missing on the destination\n", box, to)
+			differ++
+			continue
+		}
+		ok, err := o.verifyMailbox(src, dst, box, to)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", box, err))
+			continue
+		}
+		if !ok {
+			differ++
+		}
+	}
+
+	fmt.Printf("%d of %d mailbox(es) differ\n", differ, checked)
+	if len(errs) > 0 {
+		fmt.Println("Finished with errors:")
+		for _, e := range errs {
+			fmt.Println(" -", e)
+		}
+		return fmt.Errorf("%d mailbox(es) could not be verified", len(errs))
+	}
+	if differ > 0 {
+		return &exitCodeError{code: exDiffers, err: fmt.Errorf("%d mailbox(es) differ", differ)}
+	}
+	return nil
+}
+
+// verifyMailbox compares box on the source with to on the destination
+// and prints the result. It reports whether they match.
+func (o *verifyOptions) verifyMailbox(src, dst *client.Client, box, to string) (bool, error) {
+	srcStatus, err := imaputil.SelectMailbox(src, box, true)
+	if err != nil {
+		return false, fmt.Errorf("select source: %w", err)
+	}
+	dstStatus, err := imaputil.SelectMailbox(dst, to, true)
+	if err != nil {
+		return false, fmt.Errorf("select destination %s: %w", to, err)
+	}
+	if o.compare != "count" {
+		return o.compareMessages(src, dst, box, to, srcStatus.Messages, dstStatus.Messages)
+	}
+	first := time.Now()
+	for _, side := range []struct {
+		c *client.Client
+		n uint32
+	}{{src, srcStatus.Messages}, {dst, dstStatus.Messages}} {
+		if side.n == 0 {
+			continue
+		}
+		d, err := firstDate(side.c)
+		if err != nil {
+			return false, err
+		}
+		if !d.IsZero() && d.Before(first) {
+			first = d
+		}
+	}
+
+	buckets, err := verify.Localize(searchCounter(src), searchCounter(dst), first, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if len(buckets) == 0 {
+		if o.verbose {
+			fmt.Printf("%s -> %s: %d messages, OK\n", box, to, srcStatus.Messages)
+		}
+		return true, nil
+	}
+	fmt.Printf("%s -> %s: %d / %d messages\n", box, to, srcStatus.Messages, dstStatus.Messages)
+	for _, b := range buckets {
+		fmt.Printf("  %s: source %d, destination %d\n", b.Label(), b.Src, b.Dst)
+		if o.deep {
+			if err := printBucketDiff(src, dst, b); err != nil {
+				return false, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// firstDate returns the INTERNALDATE of the first message in the selected
+// mailbox, usually its oldest.
+func firstDate(c *client.Client) (time.Time, error) {
+	seq := new(imap.SeqSet)
+	seq.AddNum(1)
+	msgs := make(chan *imap.Message, 1)
+	if err := c.Fetch(seq, []imap.FetchItem{imap.FetchInternalDate}, msgs); err != nil {
+		return time.Time{}, fmt.Errorf("fetch first message: %w", err)
+	}
+	var d time.Time
+	for m := range msgs {
+		d = m.InternalDate
+	}
+	return d, nil
+}
+
+// searchCounter counts messages of the selected mailbox in a date window
+// with UID SEARCH SINCE/BEFORE.
+func searchCounter(c *client.Client) verify.Counter {
+	return func(since, before time.Time) (int, error) {
+		uids, err := imaputil.SearchUIDsRange(c, since, before, 0)
+		return len(uids), err
+	}
+}
+
+// verifyListLimit caps how many messages --deep lists per side and month.
+const verifyListLimit = 20
+
+// printBucketDiff lists the messages of the window b that only one side
+// has, matched like sync by Message-ID (else date, sender and subject).
+func printBucketDiff(src, dst *client.Client, b verify.Bucket) error {
+	srcMsgs, err := windowEnvelopes(src, b)
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	dstMsgs, err := windowEnvelopes(dst, b)
+	if err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+	onlyIn := func(a, other []*imap.Envelope) []*imap.Envelope {
+		count := map[string]int{}
+		for _, e := range other {
+			count[reconcileKey(e)]++
+		}
+		var out []*imap.Envelope
+		for _, e := range a {
+			k := reconcileKey(e)
+			if count[k] > 0 {
+				count[k]--
+				continue
+			}
+			out = append(out, e)
+		}
+		return out
+	}
+	for _, side := range []struct {
+		label string
+		msgs  []*imap.Envelope
+	}{{"missing on destination", onlyIn(srcMsgs, dstMsgs)}, {"only on destination", onlyIn(dstMsgs, srcMsgs)}} {
+		for i, e := range side.msgs {
+			if i == verifyListLimit {
+				fmt.Printf("    %s: ... and %d more\n", side.label, len(side.msgs)-i)
+				break
+			}
+			fmt.Printf("    %s: %s %s %q\n", side.label, e.Date.Format("2006-01-02"), e.MessageId, e.Subject)
+		}
+	}
+	return nil
+}
+
+// windowEnvelopes returns the envelopes of the messages of the selected
+// mailbox in the window b, by date.
+func windowEnvelopes(c *client.Client, b verify.Bucket) ([]*imap.Envelope, error) {
+	uids, err := imaputil.SearchUIDsRange(c, b.Since, b.Before, 0)
+	if err != nil || len(uids) == 0 {
+		return nil, err
+	}
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope}, msgs)
+	}()
+	var out []*imap.Envelope
+	for m := range msgs {
+		if m.Envelope != nil {
+			out = append(out, m.Envelope)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Date.Before(out[j].Date) })
+	return out, <-done
+}
+
+// compareMessages fetches the messages of the selected mailboxes box and
+// to on both sides, matches them like sync by Message-ID (else date,
+// sender and subject), compares sizes or content as --compare asks, and
+// prints every message that differs. It reports whether they match.
+func (o *verifyOptions) compareMessages(src, dst *client.Client, box, to string, srcN, dstN uint32) (bool, error) {
+	srcMsgs, srcEnvs, err := o.mailboxMessages(src, srcN)
+	if err != nil {
+		return false, fmt.Errorf("source: %w", err)
+	}
+	dstMsgs, dstEnvs, err := o.mailboxMessages(dst, dstN)
+	if err != nil {
+		return false, fmt.Errorf("destination %s: %w", to, err)
+	}
+	d := verify.Compare(srcMsgs, dstMsgs)
+	if d.Empty() {
+		if o.verbose {
+			fmt.Printf("%s -> %s: %d messages, OK\n", box, to, len(srcMsgs))
+		}
+		return true, nil
+	}
+	fmt.Printf("%s -> %s: %d / %d messages, %d missing, %d extra, %d changed\n", box, to, len(srcMsgs), len(dstMsgs), len(d.Missing), len(d.Extra), len(d.Changed))
+	line := func(label string, e *imap.Envelope) {
+		fmt.Printf("  %s: %s %s %q\n", label, e.Date.Format("2006-01-02"), e.MessageId, e.Subject)
+	}
+	for _, i := range d.Missing {
+		line("missing on destination", srcEnvs[i])
+	}
+	for _, j := range d.Extra {
+		line("only on destination", dstEnvs[j])
+	}
+	for _, p := range d.Changed {
+		label := "content differs"
+		if s, t := srcMsgs[p[0]].Size, dstMsgs[p[1]].Size; s != t {
+			label = fmt.Sprintf("size differs (%d / %d bytes)", s, t)
+		}
+		line(label, srcEnvs[p[0]])
+	}
+	return false, nil
+}
+
+// mailboxMessages fetches the n messages of the selected mailbox for
+// compareMessages, with their envelopes in the same order.
+func (o *verifyOptions) mailboxMessages(c *client.Client, n uint32) ([]verify.Message, []*imap.Envelope, error) {
+	if n == 0 {
+		return nil, nil, nil
+	}
+	seq := new(imap.SeqSet)
+	seq.AddRange(1, 0)
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope}
+	if o.compare == "size" || o.compare == "sha256" {
+		items = append(items, imap.FetchRFC822Size)
+	}
+	if o.compare == "sha256" {
+		items = append(items, section.FetchItem())
+	}
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, items, msgs)
+	}()
+	var out []verify.Message
+	var envs []*imap.Envelope
+	for m := range msgs {
+		if m.Envelope == nil {
+			continue
+		}
+		msg := verify.Message{Key: reconcileKey(m.Envelope), Size: m.Size}
+		if lit := m.GetBody(section); lit != nil {
+			h := sha256.New()
+			_, _ = io.Copy(h, lit)
+			msg.Hash = hex.EncodeToString(h.Sum(nil))
+		}
+		out = append(out, msg)
+		envs = append(envs, m.Envelope)
+	}
+	return out, envs, <-done
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+type watchOptions struct {
+	sourceOptions
+	mailbox string
+	exec    string
+	poll    time.Duration
+}
+
+func addWatchFlags(cmd *cobra.Command) {
+	o := &watchOptions{}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = false
+	addSourceFlags(cmd, &o.sourceOptions)
+	cmd.Flags().StringVar(&o.mailbox, "mailbox", "INBOX", "Mailbox to watch")
+	cmd.Flags().StringVar(&o.exec, "exec", "", "Shell command run for each new message; the message is described in GOMAP_* environment variables")
+	cmd.Flags().DurationVar(&o.poll, "poll", 30*time.Second, "Polling interval when the server does not support IDLE")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKey{}, o))
+		return nil
+	}
+}
+
+// runWatch runs --exec for each message that arrives in a mailbox, using
+// IDLE (or NOOP polling) on a read-only selection like tail. Messages
+// already in the mailbox are skipped. It runs until interrupted; a failing
+// command is logged and does not stop the watch, and a broken connection
+// is reopened after followRetry.
+func runWatch(cmd *cobra.Command, args []string) error {
+	o := cmd.Context().Value(ctxKey{}).(*watchOptions)
+	if o.exec == "" {
+		return fmt.Errorf("missing required flag: --exec")
+	}
+	if err := o.resolve(cmd); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	mailbox := imaputil.DecodeMailboxName(o.mailbox)
+	var w watchState
+	for {
+		err := o.watchOnce(ctx, mailbox, &w)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !w.started {
+			// the first connection failed: wrong credentials or mailbox
+			return err
+		}
+		log.Printf("[watch] %v; reconnecting in %s", err, followRetry)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(followRetry):
+		}
+	}
+}
+
+// watchState is what watch keeps across reconnects: the UIDVALIDITY of the
+// mailbox and the highest UID handled.
+type watchState struct {
+	started     bool
+	uidValidity uint32
+	lastUID     uint32
+}
+
+// watchOnce connects, selects mailbox read-only and runs --exec for each
+// new message until ctx ends or the connection fails. Messages that
+// arrived while disconnected are handled first; after a UIDVALIDITY change
+// the mailbox counts as new and nothing is run for its messages.
+func (o *watchOptions) watchOnce(ctx context.Context, mailbox string, w *watchState) error {
+	c, err := o.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	newMail := newMailSignal(c)
+	status, err := imaputil.SelectMailbox(c, mailbox, true)
+	if err != nil {
+		return fmt.Errorf("select %s: %w", mailbox, err)
+	}
+
+	run := func(m *imap.Message) {
+		err := perform(fmt.Sprintf("run %q for %s", o.exec, strings.TrimSpace(tailLine(m))), func() error {
+			return runShellCommand(ctx, o.exec, watchEnv(mailbox, m))
+		})
+		if err != nil {
+			log.Printf("[watch] UID %d: %v", m.Uid, err)
+		}
+	}
+	if w.started && w.uidValidity == status.UidValidity {
+		seq := new(imap.SeqSet)
+		seq.AddRange(w.lastUID+1, 0)
+		if w.lastUID, err = fetchSummaries(c, true, seq, w.lastUID, run); err != nil {
+			return err
+		}
+	} else {
+		// the highest UID so far, from the last message
+		w.lastUID = 0
+		if status.Messages > 0 {
+			seq := new(imap.SeqSet)
+			seq.AddNum(status.Messages)
+			if w.lastUID, err = fetchSummaries(c, false, seq, 0, nil); err != nil {
+				return err
+			}
+		}
+		if status.UidNext > w.lastUID+1 {
+			w.lastUID = status.UidNext - 1
+		}
+		if !w.started {
+			fmt.Fprintf(os.Stderr, "Watching %s for new messages (Ctrl-C to stop)...\n", mailbox)
+		}
+	}
+	w.started, w.uidValidity = true, status.UidValidity
+	return followNewMessages(ctx, c, newMail, o.poll, w.lastUID, func(m *imap.Message) {
+		w.lastUID = m.Uid
+		run(m)
+	})
+}
+
+// watchEnv returns the GOMAP_* environment variables describing a new
+// message for the --exec command of watch.
+func watchEnv(mailbox string, m *imap.Message) []string {
+	date, from, subject := messageSummary(m)
+	var messageID string
+	if m.Envelope != nil {
+		messageID = m.Envelope.MessageId
+	}
+	return []string{
+		"GOMAP_MAILBOX=" + mailbox,
+		"GOMAP_UID=" + strconv.FormatUint(uint64(m.Uid), 10),
+		"GOMAP_FROM=" + from,
+		"GOMAP_SUBJECT=" + subject,
+		"GOMAP_DATE=" + date.Format(time.RFC3339),
+		"GOMAP_MESSAGE_ID=" + messageID,
+		"GOMAP_SIZE=" + strconv.FormatUint(uint64(m.Size), 10),
+	}
+}
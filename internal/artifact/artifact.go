@@ -0,0 +1,204 @@
+// Package artifact stores the evidence of runs (reports, manifests,
+// traces) in a local directory or an S3 bucket, grouped by kind, and
+// prunes old artifacts by count or age.
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pepperpark/gomap/internal/s3"
+)
+
+// KindReports holds the run summaries read by 'gomap report'.
+const KindReports = "reports"
+
+// ErrNotFound is returned by Get for a missing artifact.
+var ErrNotFound = errors.New("artifact not found")
+
+// Object is a stored artifact.
+type Object struct {
+	Name     string
+	Modified time.Time
+}
+
+// Store keeps artifacts as <kind>/<name>.
+type Store interface {
+	Put(ctx context.Context, kind, name string, data []byte) error
+	Get(ctx context.Context, kind, name string) ([]byte, error)
+	// List returns the artifacts of kind sorted by name; none if the kind
+	// has no artifacts yet.
+	List(ctx context.Context, kind string) ([]Object, error)
+	Delete(ctx context.Context, kind, name string) error
+	String() string
+}
+
+// Open returns the store at location: s3://bucket/prefix (credentials and
+// endpoint from the AWS environment variables) or a local directory.
+func Open(location string) (Store, error) {
+	if rest, ok := strings.CutPrefix(location, "s3://"); ok {
+		u, err := url.Parse("s3://" + rest)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid artifact store %q (expected s3://bucket/prefix)", location)
+		}
+		c, err := s3.NewFromEnv(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		prefix := strings.Trim(u.Path, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		return &s3Store{client: c, bucket: u.Host, prefix: prefix}, nil
+	}
+	if strings.Contains(location, "://") {
+		return nil, fmt.Errorf("invalid artifact store %q (expected a directory or s3://bucket/prefix)", location)
+	}
+	return dirStore(location), nil
+}
+
+// dirStore keeps artifacts in a local directory.
+type dirStore string
+
+func (d dirStore) path(kind, name string) string {
+	return filepath.Join(string(d), kind, filepath.FromSlash(name))
+}
+
+func (d dirStore) Put(ctx context.Context, kind, name string, data []byte) error {
+	p := d.path(kind, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (d dirStore) Get(ctx context.Context, kind, name string) ([]byte, error) {
+	b, err := os.ReadFile(d.path(kind, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s/%s: %w", kind, name, ErrNotFound)
+	}
+	return b, err
+}
+
+func (d dirStore) List(ctx context.Context, kind string) ([]Object, error) {
+	entries, err := os.ReadDir(filepath.Join(string(d), kind))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var objs []Object
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		objs = append(objs, Object{Name: e.Name(), Modified: info.ModTime()})
+	}
+	return objs, nil
+}
+
+func (d dirStore) Delete(ctx context.Context, kind, name string) error {
+	err := os.Remove(d.path(kind, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (d dirStore) String() string { return string(d) }
+
+type s3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3Store) key(kind, name string) string {
+	return s.prefix + kind + "/" + name
+}
+
+func (s *s3Store) Put(ctx context.Context, kind, name string, data []byte) error {
+	return s.client.Put(ctx, s.key(kind, name), bytes.NewReader(data), int64(len(data)))
+}
+
+func (s *s3Store) Get(ctx context.Context, kind, name string) ([]byte, error) {
+	b, err := s.client.Get(ctx, s.key(kind, name))
+	if err != nil && strings.Contains(err.Error(), "404") {
+		return nil, fmt.Errorf("%s/%s: %w", kind, name, ErrNotFound)
+	}
+	return b, err
+}
+
+func (s *s3Store) List(ctx context.Context, kind string) ([]Object, error) {
+	list, err := s.client.ListObjects(ctx, s.prefix+kind+"/")
+	if err != nil {
+		return nil, err
+	}
+	var objs []Object
+	for _, o := range list {
+		name := strings.TrimPrefix(o.Key, s.prefix+kind+"/")
+		if name != "" && !strings.Contains(name, "/") {
+			objs = append(objs, Object{Name: name, Modified: o.LastModified})
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Name < objs[j].Name })
+	return objs, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, kind, name string) error {
+	return s.client.Delete(ctx, s.key(kind, name))
+}
+
+func (s *s3Store) String() string { return "s3://" + s.bucket + "/" + s.prefix }
+
+// Retention limits how many artifacts of a kind are kept. Zero values
+// keep everything.
+type Retention struct {
+	Keep   int           // newest artifacts to keep
+	MaxAge time.Duration // older artifacts are removed
+}
+
+// Prune removes the artifacts of kind that fall outside r and returns
+// their names. Keep counts by name order (names start with the run's
+// timestamp), MaxAge by modification time. The newest artifact is always
+// kept, so there is something to compare the next run with.
+func Prune(ctx context.Context, s Store, kind string, r Retention, now time.Time) ([]string, error) {
+	if r.Keep <= 0 && r.MaxAge <= 0 {
+		return nil, nil
+	}
+	objs, err := s.List(ctx, kind)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for i, o := range objs {
+		newer := len(objs) - 1 - i
+		tooMany := r.Keep > 0 && newer >= r.Keep
+		tooOld := r.MaxAge > 0 && newer > 0 && now.Sub(o.Modified) > r.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := s.Delete(ctx, kind, o.Name); err != nil {
+			return removed, fmt.Errorf("delete %s/%s: %w", kind, o.Name, err)
+		}
+		removed = append(removed, o.Name)
+	}
+	return removed, nil
+}
@@ -0,0 +1,116 @@
+package artifact
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 serves path-style requests for one bucket from memory.
+func fakeS3(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+			var keys []string
+			for k := range objects {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			fmt.Fprint(w, "<ListBucketResult>")
+			for _, k := range keys {
+				fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>2024-10-05T03:00:00.000Z</LastModified></Contents>", k)
+			}
+			fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+		case r.Method == http.MethodPut:
+			objects[key], _ = io.ReadAll(r.Body)
+		case r.Method == http.MethodGet:
+			b, ok := objects[key]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStores(t *testing.T) {
+	srv := fakeS3(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+
+	ctx := context.Background()
+	for _, loc := range []string{t.TempDir(), "s3://bucket/jobs/42"} {
+		s, err := Open(loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if objs, err := s.List(ctx, KindReports); err != nil || len(objs) != 0 {
+			t.Fatalf("%s: List(empty) = %v, %v", s, objs, err)
+		}
+		for _, name := range []string{"20241012.json", "20241005.json"} {
+			if err := s.Put(ctx, KindReports, name, []byte(name)); err != nil {
+				t.Fatalf("%s: Put: %v", s, err)
+			}
+		}
+		if b, err := s.Get(ctx, KindReports, "20241005.json"); err != nil || string(b) != "20241005.json" {
+			t.Errorf("%s: Get = %q, %v", s, b, err)
+		}
+		if _, err := s.Get(ctx, KindReports, "missing.json"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: Get(missing) error = %v, want ErrNotFound", s, err)
+		}
+		removed, err := Prune(ctx, s, KindReports, Retention{Keep: 1}, time.Now())
+		if err != nil || !reflect.DeepEqual(removed, []string{"20241005.json"}) {
+			t.Errorf("%s: Prune = %v, %v", s, removed, err)
+		}
+		objs, err := s.List(ctx, KindReports)
+		if err != nil || len(objs) != 1 || objs[0].Name != "20241012.json" {
+			t.Errorf("%s: List after prune = %v, %v", s, objs, err)
+		}
+	}
+
+	if _, err := Open("ftp://host/x"); err == nil {
+		t.Error("Open(ftp://) succeeded")
+	}
+}
+
+func TestPruneMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir)
+	ctx := context.Background()
+	now := time.Now()
+	for i, name := range []string{"a.json", "b.json", "c.json"} {
+		s.Put(ctx, KindReports, name, nil)
+		// all older than the limit; c is the newest
+		mtime := now.Add(-time.Duration(40-i) * 24 * time.Hour)
+		os.Chtimes(filepath.Join(dir, KindReports, name), mtime, mtime)
+	}
+	removed, err := Prune(ctx, s, KindReports, Retention{MaxAge: 30 * 24 * time.Hour}, now)
+	if err != nil || !reflect.DeepEqual(removed, []string{"a.json", "b.json"}) {
+		t.Errorf("Prune = %v, %v (the newest artifact must stay)", removed, err)
+	}
+}
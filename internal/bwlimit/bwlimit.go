@@ -0,0 +1,118 @@
+// Package bwlimit caps the bandwidth of network connections with token
+// buckets, so a migration does not saturate an office uplink. One Limiter
+// is shared by all connections of a run: the cap holds for their sum.
+package bwlimit
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// minBurst is the smallest chunk a connection reads or writes at once.
+const minBurst = 4 << 10
+
+// Limiter caps the bytes per second read and, separately, written
+// through the connections it wraps. A nil *Limiter does not limit.
+type Limiter struct {
+	read, write *bucket
+}
+
+// New returns a Limiter of rate bytes per second in each direction, or
+// nil for a rate of 0 or less.
+func New(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{read: newBucket(rate), write: newBucket(rate)}
+}
+
+// Conn returns c with its reads and writes limited by l.
+func (l *Limiter) Conn(c net.Conn) net.Conn {
+	if l == nil {
+		return c
+	}
+	return &conn{Conn: c, l: l}
+}
+
+type ctxKey struct{}
+
+// NewContext returns ctx carrying l, for dialers deep down the call chain
+// (see imaputil.DialAndLogin).
+func NewContext(ctx context.Context, l *Limiter) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the Limiter of ctx, or nil.
+func FromContext(ctx context.Context) *Limiter {
+	l, _ := ctx.Value(ctxKey{}).(*Limiter)
+	return l
+}
+
+// bucket is a token bucket of bytes. Callers take what they need and
+// sleep off any debt, so concurrent connections share the rate.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64 // most tokens saved up; also the chunk size
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int64) *bucket {
+	burst := max(float64(rate)/4, minBurst)
+	return &bucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// chunk caps n at the burst size.
+func (b *bucket) chunk(n int) int {
+	return min(n, int(b.burst))
+}
+
+// take takes n tokens and waits until the bucket is out of debt.
+func (b *bucket) take(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.burst)
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	time.Sleep(wait)
+}
+
+type conn struct {
+	net.Conn
+	l *Limiter
+}
+
+// Read reads at most one burst and then pays for what it got.
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p[:c.l.read.chunk(len(p))])
+	if n > 0 {
+		c.l.read.take(n)
+	}
+	return n, err
+}
+
+// Write writes p in chunks of one burst, each paid for first.
+func (c *conn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := c.l.write.chunk(len(p) - written)
+		c.l.write.take(n)
+		m, err := c.Conn.Write(p[written : written+n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,40 @@
+package bwlimit
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	const rate = 1 << 20
+	l := New(rate)
+	srv, cli := net.Pipe()
+	defer srv.Close()
+	go io.Copy(io.Discard, srv)
+
+	// the first burst (a quarter second's worth) is free, the rest waits
+	start := time.Now()
+	if _, err := l.Conn(cli).Write(make([]byte, rate/4+rate/2)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond || d > 2*time.Second {
+		t.Errorf("writing 768 KiB at 1 MiB/s took %s, want about 500ms", d)
+	}
+
+	if New(0) != nil || (*Limiter)(nil).Conn(cli) != cli {
+		t.Error("a zero rate does not give a no-op limiter")
+	}
+}
+
+func TestReadChunks(t *testing.T) {
+	l := New(minBurst) // the burst is minBurst
+	srv, cli := net.Pipe()
+	defer srv.Close()
+	go srv.Write(make([]byte, 3*minBurst))
+	n, err := l.Conn(cli).Read(make([]byte, 3*minBurst))
+	if err != nil || n > minBurst {
+		t.Errorf("Read = %d, %v; want at most one burst of %d", n, err, minBurst)
+	}
+}
@@ -0,0 +1,45 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, 5, 15, 10, 17, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		spec, want string
+	}{
+		{"0 */4 * * *", "2024-05-15 12:00"},
+		{"*/15 * * * *", "2024-05-15 10:30"},
+		{"30 2 * * *", "2024-05-16 02:30"},
+		{"@daily", "2024-05-16 00:00"},
+		{"0 9 * * mon-fri", "2024-05-16 09:00"},
+		{"0 9 * * 0", "2024-05-19 09:00"},
+		{"0 9 * * 7", "2024-05-19 09:00"},
+		// either day field matches when both are restricted
+		{"0 0 1 * sat", "2024-05-18 00:00"},
+		{"0 0 1,15 jun *", "2024-06-01 00:00"},
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+		{"5/20 10 * * *", "2024-05-15 10:25"},
+	} {
+		s, err := Parse(tc.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.spec, err)
+			continue
+		}
+		if got := s.Next(from).Format("2006-01-02 15:04"); got != tc.want {
+			t.Errorf("%q: Next = %s, want %s", tc.spec, got, tc.want)
+		}
+	}
+
+	if s, err := Parse("0 0 30 2 *"); err != nil || !s.Next(from).IsZero() {
+		t.Errorf("a schedule that never fires: %v, %v", s, err)
+	}
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
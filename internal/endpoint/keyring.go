@@ -0,0 +1,45 @@
+package endpoint
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Keyring looks up the password of user on host in the system keyring,
+// through the command line tools so no keyring library is linked in:
+// secret-tool (libsecret, GNOME Keyring, KWallet) on Linux and the BSDs,
+// security on macOS. It returns "" when the tool is missing or has no
+// password stored.
+//
+// On Linux the password is expected under the attributes service=gomap,
+// host and user, as stored by
+//
+//	secret-tool store --label='gomap' service gomap host imap.example.org user jane
+//
+// and on macOS as an internet password for the host and account.
+func Keyring(host, user string) string {
+	var name string
+	var args []string
+	switch runtime.GOOS {
+	case "darwin":
+		name, args = "security", []string{"find-internet-password", "-s", host, "-a", user, "-w"}
+	case "windows":
+		return ""
+	default:
+		name, args = "secret-tool", []string{"lookup", "service", "gomap", "host", host, "user", user}
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return ""
+	}
+	// a locked keyring may prompt for its password; do not wait forever
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(out), "\r\n")
+}
@@ -0,0 +1,339 @@
+// Package gmailapi is a minimal client for the Gmail REST API, covering the
+// calls needed to use a Gmail account as a copy source: listing labels,
+// listing message IDs per label and fetching raw messages in batches.
+package gmailapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiBase   = "https://gmail.googleapis.com/gmail/v1/users/me"
+	batchURL  = "https://gmail.googleapis.com/batch/gmail/v1"
+	batchPath = "/gmail/v1/users/me"
+
+	// MaxBatch is the largest number of requests Gmail accepts in one batch
+	// for messages.get without aggressive throttling.
+	MaxBatch = 50
+)
+
+// Client talks to the Gmail API using an OAuth2 access token.
+type Client struct {
+	token string
+	http  *http.Client
+	// retryDelay is the wait before the first retry of a rate-limited or
+	// failed request; it doubles with every further retry.
+	retryDelay time.Duration
+	batchURL   string
+}
+
+// maxRetries is how often a request (or an item of a batch) is retried
+// after a 429 or 5xx answer before the error is returned.
+const maxRetries = 5
+
+// New returns a client authenticating with the given OAuth2 access token.
+func New(token string) *Client {
+	return &Client{token: token, http: &http.Client{Timeout: 5 * time.Minute}, retryDelay: time.Second, batchURL: batchURL}
+}
+
+// Label is a Gmail label.
+type Label struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"` // system | user
+}
+
+// Message is a fetched message in RFC822 form.
+type Message struct {
+	ID           string
+	LabelIDs     []string
+	InternalDate time.Time
+	Raw          []byte
+}
+
+// statusError is a non-2xx answer of the API to a request, or to one item
+// of a batch request.
+type statusError struct {
+	what   string
+	code   int
+	status string
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("gmail api: %s: %s: %s", e.what, e.status, e.body)
+}
+
+// retryable reports whether the request may succeed when repeated: it was
+// rate limited (429) or the server failed (5xx).
+func (e *statusError) retryable() bool {
+	return e.code == http.StatusTooManyRequests || e.code/100 == 5
+}
+
+// pause waits before retry number attempt (0 for the first).
+func (c *Client) pause(ctx context.Context, attempt int) error {
+	t := time.NewTimer(c.retryDelay << attempt)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, u string, body []byte, contentType string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 == 2 {
+			return resp, nil
+		}
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		serr := &statusError{what: method + " " + u, code: resp.StatusCode, status: resp.Status, body: strings.TrimSpace(string(b))}
+		if !serr.retryable() || attempt == maxRetries {
+			return nil, serr
+		}
+		if err := c.pause(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) getJSON(ctx context.Context, u string, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, u, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// ListLabels returns all labels of the account.
+func (c *Client) ListLabels(ctx context.Context) ([]Label, error) {
+	var out struct {
+		Labels []Label `json:"labels"`
+	}
+	if err := c.getJSON(ctx, apiBase+"/labels", &out); err != nil {
+		return nil, err
+	}
+	return out.Labels, nil
+}
+
+// ListMessageIDs returns the IDs of all messages carrying labelID. If since
+// is non-zero, only messages received on or after that day are returned,
+// and if before is non-zero, only those received before that day.
+func (c *Client) ListMessageIDs(ctx context.Context, labelID string, since, before time.Time) ([]string, error) {
+	ids := []string{}
+	pageToken := ""
+	for {
+		q := url.Values{}
+		q.Set("labelIds", labelID)
+		q.Set("maxResults", "500")
+		q.Set("includeSpamTrash", "true")
+		var query []string
+		if !since.IsZero() {
+			query = append(query, "after:"+since.Format("2006/01/02"))
+		}
+		if !before.IsZero() {
+			query = append(query, "before:"+before.Format("2006/01/02"))
+		}
+		if len(query) > 0 {
+			q.Set("q", strings.Join(query, " "))
+		}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		var out struct {
+			Messages []struct {
+				ID string `json:"id"`
+			} `json:"messages"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := c.getJSON(ctx, apiBase+"/messages?"+q.Encode(), &out); err != nil {
+			return nil, err
+		}
+		for _, m := range out.Messages {
+			ids = append(ids, m.ID)
+		}
+		if out.NextPageToken == "" {
+			return ids, nil
+		}
+		pageToken = out.NextPageToken
+	}
+}
+
+type rawMessage struct {
+	ID           string   `json:"id"`
+	LabelIDs     []string `json:"labelIds"`
+	InternalDate string   `json:"internalDate"` // epoch millis
+	Raw          string   `json:"raw"`          // base64url
+}
+
+func (r rawMessage) decode() (Message, error) {
+	raw, err := base64.URLEncoding.DecodeString(r.Raw)
+	if err != nil {
+		// Gmail omits padding in some responses
+		raw, err = base64.RawURLEncoding.DecodeString(r.Raw)
+		if err != nil {
+			return Message{}, fmt.Errorf("decode message %s: %w", r.ID, err)
+		}
+	}
+	msg := Message{ID: r.ID, LabelIDs: r.LabelIDs, Raw: raw}
+	if ms, err := strconv.ParseInt(r.InternalDate, 10, 64); err == nil {
+		msg.InternalDate = time.UnixMilli(ms)
+	}
+	return msg, nil
+}
+
+// BatchGetRaw fetches up to MaxBatch messages in RFC822 form using a single
+// HTTP batch request. Messages are returned in the order of ids. Items that
+// are rate limited or fail on the server side are fetched again after a
+// pause; any other failure is an error for the whole batch.
+func (c *Client) BatchGetRaw(ctx context.Context, ids []string) ([]Message, error) {
+	if len(ids) > MaxBatch {
+		return nil, fmt.Errorf("batch of %d exceeds maximum of %d", len(ids), MaxBatch)
+	}
+	byID := make(map[string]Message, len(ids))
+	pending := ids
+	for attempt := 0; len(pending) > 0; attempt++ {
+		msgs, failed, err := c.batchGet(ctx, pending)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			byID[msg.ID] = msg
+		}
+		var retry []string
+		for _, e := range failed {
+			if !e.retryable() || attempt == maxRetries {
+				return nil, e
+			}
+			retry = append(retry, pending[e.item])
+		}
+		if len(retry) > 0 {
+			if err := c.pause(ctx, attempt); err != nil {
+				return nil, err
+			}
+		}
+		pending = retry
+	}
+	out := make([]Message, 0, len(ids))
+	for _, id := range ids {
+		msg, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("gmail api: message %s missing from batch response", id)
+		}
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+// batchGet sends one batch request for the messages ids and returns those
+// received and the items that failed.
+func (c *Client) batchGet(ctx context.Context, ids []string) ([]Message, []*itemError, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, id := range ids {
+		h := map[string][]string{
+			"Content-Type": {"application/http"},
+			"Content-ID":   {fmt.Sprintf("<item%d>", i)},
+		}
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Fprintf(pw, "GET %s/messages/%s?format=raw\r\n\r\n", batchPath, url.PathEscape(id))
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, c.batchURL, body.Bytes(), "multipart/mixed; boundary="+mw.Boundary())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("batch response: %w", err)
+	}
+	return parseBatch(resp.Body, params["boundary"], ids)
+}
+
+// itemError is the failure of item number item of a batch request.
+type itemError struct {
+	statusError
+	item int
+}
+
+// parseBatch reads the multipart/mixed response to a batch of
+// messages.get requests for ids. Each part answers the request item
+// named in its Content-ID (<response-itemN> for <itemN>).
+func parseBatch(r io.Reader, boundary string, ids []string) ([]Message, []*itemError, error) {
+	var msgs []Message
+	var failed []*itemError
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return msgs, failed, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("batch response: %w", err)
+		}
+		inner, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("batch response part: %w", err)
+		}
+		b, err := io.ReadAll(inner.Body)
+		inner.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if inner.StatusCode/100 != 2 {
+			cid := part.Header.Get("Content-ID")
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(cid, "<response-item"), ">"))
+			if err != nil || n < 0 || n >= len(ids) {
+				return nil, nil, fmt.Errorf("gmail api: batch item %s: %s: %s", cid, inner.Status, strings.TrimSpace(string(b)))
+			}
+			failed = append(failed, &itemError{
+				statusError: statusError{what: "message " + ids[n], code: inner.StatusCode, status: inner.Status, body: strings.TrimSpace(string(b))},
+				item:        n,
+			})
+			continue
+		}
+		var rm rawMessage
+		if err := json.Unmarshal(b, &rm); err != nil {
+			return nil, nil, err
+		}
+		msg, err := rm.decode()
+		if err != nil {
+			return nil, nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
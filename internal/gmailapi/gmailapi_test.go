@@ -0,0 +1,184 @@
+package gmailapi
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchResponse is a batch response as Gmail sends it, trimmed: one
+// message, one rate-limited item and one item for a deleted message.
+const batchResponse = "--batch_x9\r\n" +
+	"Content-Type: application/http\r\n" +
+	"Content-ID: <response-item0>\r\n" +
+	"\r\n" +
+	"HTTP/1.1 200 OK\r\n" +
+	"Content-Type: application/json; charset=UTF-8\r\n" +
+	"Vary: Origin\r\n" +
+	"\r\n" +
+	"{\n  \"id\": \"18c2a\",\n  \"threadId\": \"18c2a\",\n  \"labelIds\": [\"INBOX\", \"UNREAD\"],\n" +
+	"  \"internalDate\": \"1700000000000\",\n  \"raw\": \"U3ViamVjdDogaGkNCg0KYm9keQ0K\"\n}\n" +
+	"\r\n" +
+	"--batch_x9\r\n" +
+	"Content-Type: application/http\r\n" +
+	"Content-ID: <response-item1>\r\n" +
+	"\r\n" +
+	"HTTP/1.1 429 Too Many Requests\r\n" +
+	"Content-Type: application/json; charset=UTF-8\r\n" +
+	"\r\n" +
+	"{\n  \"error\": {\n    \"code\": 429,\n    \"message\": \"Too many concurrent requests for user\",\n    \"status\": \"RESOURCE_EXHAUSTED\"\n  }\n}\n" +
+	"\r\n" +
+	"--batch_x9\r\n" +
+	"Content-Type: application/http\r\n" +
+	"Content-ID: <response-item2>\r\n" +
+	"\r\n" +
+	"HTTP/1.1 404 Not Found\r\n" +
+	"Content-Type: application/json; charset=UTF-8\r\n" +
+	"\r\n" +
+	"{\n  \"error\": {\n    \"code\": 404,\n    \"message\": \"Requested entity was not found.\",\n    \"status\": \"NOT_FOUND\"\n  }\n}\n" +
+	"\r\n" +
+	"--batch_x9--\r\n"
+
+func TestParseBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		ids      []string
+		wantMsgs []string
+		// failed items as "index:code"
+		wantFailed []string
+		wantErr    bool
+	}{
+		{"mixed", batchResponse, []string{"18c2a", "18c2b", "18c2c"}, []string{"18c2a"}, []string{"1:429", "2:404"}, false},
+		{"empty", "--batch_x9--\r\n", []string{"18c2a"}, nil, nil, false},
+		{"unknown item", strings.ReplaceAll(batchResponse, "response-item2", "response-item7"), []string{"18c2a", "18c2b", "18c2c"}, nil, nil, true},
+		{"truncated", batchResponse[:len(batchResponse)-30], []string{"18c2a", "18c2b", "18c2c"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		msgs, failed, err := parseBatch(strings.NewReader(tt.body), "batch_x9", tt.ids)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		var gotMsgs, gotFailed []string
+		for _, m := range msgs {
+			gotMsgs = append(gotMsgs, m.ID)
+		}
+		for _, e := range failed {
+			gotFailed = append(gotFailed, fmt.Sprintf("%d:%d", e.item, e.code))
+		}
+		if fmt.Sprint(gotMsgs) != fmt.Sprint(tt.wantMsgs) || fmt.Sprint(gotFailed) != fmt.Sprint(tt.wantFailed) {
+			t.Errorf("%s: messages %v, failed %v; want %v, %v", tt.name, gotMsgs, gotFailed, tt.wantMsgs, tt.wantFailed)
+		}
+	}
+
+	msgs, _, err := parseBatch(strings.NewReader(batchResponse), "batch_x9", []string{"18c2a", "18c2b", "18c2c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := msgs[0]
+	if string(m.Raw) != "Subject: hi\r\n\r\nbody\r\n" || !m.InternalDate.Equal(time.UnixMilli(1700000000000)) || len(m.LabelIDs) != 2 {
+		t.Errorf("unexpected message %+v", m)
+	}
+}
+
+// batchServer answers batch requests with script: for each request, the
+// status per requested message ID (200 by default), or a status for the
+// whole request under "*".
+func batchServer(t *testing.T, script []map[string]int) (*Client, *[][]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n := len(requests)
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var ids []string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			b, _ := io.ReadAll(p)
+			line, _, _ := strings.Cut(string(b), "?")
+			ids = append(ids, line[strings.LastIndex(line, "/")+1:])
+		}
+		requests = append(requests, ids)
+		mu.Unlock()
+		var step map[string]int
+		if n < len(script) {
+			step = script[n]
+		}
+		if code := step["*"]; code != 0 {
+			http.Error(w, "backend error", code)
+			return
+		}
+		w.Header().Set("Content-Type", "multipart/mixed; boundary=batch_r")
+		for i, id := range ids {
+			fmt.Fprintf(w, "--batch_r\r\nContent-Type: application/http\r\nContent-ID: <response-item%d>\r\n\r\n", i)
+			if code := step[id]; code != 0 {
+				fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\n\r\n{\"error\": {\"code\": %d}}\r\n", code, http.StatusText(code), code)
+				continue
+			}
+			raw := base64.RawURLEncoding.EncodeToString([]byte("Subject: " + id + "\r\n\r\n"))
+			fmt.Fprintf(w, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n{\"id\": %q, \"raw\": %q}\r\n", id, raw)
+		}
+		fmt.Fprint(w, "--batch_r--\r\n")
+	}))
+	t.Cleanup(srv.Close)
+	c := New("token")
+	c.batchURL = srv.URL
+	c.retryDelay = time.Millisecond
+	return c, &requests
+}
+
+func TestBatchGetRawRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   []map[string]int
+		want     string // requested IDs per request
+		wantCode int    // status of the returned error, 0 for success
+	}{
+		{"no failures", nil, "[[a b c]]", 0},
+		{"items retried", []map[string]int{{"b": 429, "c": 503}, {"c": 500}}, "[[a b c] [b c] [c]]", 0},
+		{"request retried", []map[string]int{{"*": 503}}, "[[a b c] [a b c]]", 0},
+		{"not found", []map[string]int{{"b": 429, "c": 404}}, "[[a b c]]", 404},
+		{"gives up", []map[string]int{{"b": 429}, {"b": 429}, {"b": 429}, {"b": 429}, {"b": 429}, {"b": 429}}, "[[a b c] [b] [b] [b] [b] [b]]", 429},
+	}
+	for _, tt := range tests {
+		c, requests := batchServer(t, tt.script)
+		msgs, err := c.BatchGetRaw(context.Background(), []string{"a", "b", "c"})
+		if got := fmt.Sprint(*requests); got != tt.want {
+			t.Errorf("%s: requests %s, want %s", tt.name, got, tt.want)
+		}
+		if tt.wantCode != 0 {
+			e, ok := err.(*itemError)
+			if !ok || e.code != tt.wantCode {
+				t.Errorf("%s: err = %v, want status %d", tt.name, err, tt.wantCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, m := range msgs {
+			got = append(got, m.ID+"="+strings.TrimSpace(string(m.Raw)))
+		}
+		if fmt.Sprint(got) != "[a=Subject: a b=Subject: b c=Subject: c]" {
+			t.Errorf("%s: messages %v", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package imaputil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+)
+
+// ACL is the access control list of a mailbox (RFC 4314): the rights of
+// each identifier, e.g. "anyone" -> "lr".
+type ACL map[string]string
+
+// aclList collects the entries of an ACL response.
+type aclList struct {
+	acl ACL
+}
+
+func (r *aclList) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != "ACL" {
+		return responses.ErrUnhandled
+	}
+	// the mailbox name, then identifier and rights pairs
+	for i := 1; i+1 < len(fields); i += 2 {
+		id, err := imap.ParseString(fields[i])
+		if err != nil {
+			return fmt.Errorf("cannot parse ACL identifier: %w", err)
+		}
+		rights, err := imap.ParseString(fields[i+1])
+		if err != nil {
+			return fmt.Errorf("cannot parse ACL rights of %s: %w", id, err)
+		}
+		r.acl[id] = rights
+	}
+	return nil
+}
+
+// HasACL reports whether c announces the ACL extension.
+func HasACL(c *client.Client) bool {
+	ok, err := c.Support("ACL")
+	return err == nil && ok
+}
+
+// GetACL returns the access control list of mailbox.
+func GetACL(c *client.Client, mailbox string) (ACL, error) {
+	h := &aclList{acl: ACL{}}
+	status, err := c.Execute(&imap.Command{Name: "GETACL", Arguments: []interface{}{EncodeMailboxName(mailbox)}}, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	return h.acl, nil
+}
+
+// SetACL gives identifier exactly rights on mailbox.
+func SetACL(c *client.Client, mailbox, identifier, rights string) error {
+	status, err := c.Execute(&imap.Command{Name: "SETACL", Arguments: []interface{}{EncodeMailboxName(mailbox), identifier, rights}}, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// ACLChange is the SETACL that gives Identifier the rights New in place
+// of Old (empty if it had none).
+type ACLChange struct {
+	Identifier, Old, New string
+}
+
+// DiffACL returns the changes that give dst the entries of src, sorted by
+// identifier. Rights compare regardless of their order; identifiers in
+// skip (the owners, whose names differ between the servers) and entries
+// that only dst has are left alone.
+func DiffACL(src, dst ACL, skip ...string) []ACLChange {
+	var out []ACLChange
+	for id, rights := range src {
+		if containsFold(skip, id) || sortedRights(dst[id]) == sortedRights(rights) {
+			continue
+		}
+		out = append(out, ACLChange{Identifier: id, Old: dst[id], New: rights})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Identifier < out[j].Identifier })
+	return out
+}
+
+// sortedRights returns the letters of rights sorted.
+func sortedRights(rights string) string {
+	b := []byte(rights)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return string(b)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
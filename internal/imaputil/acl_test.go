@@ -0,0 +1,34 @@
+package imaputil
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	c := scriptedServerCaps(t, "IMAP4rev1 ACL", [][]string{
+		{`* ACL Shared/Team alice@example.org lrswipkxtea "bob smith" lr anyone l`, "$ OK done"},
+		{"$ OK done"},
+	})
+	if !HasACL(c) {
+		t.Fatal("ACL not detected")
+	}
+	acl, err := GetACL(c, "Shared/Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(acl); got != "map[alice@example.org:lrswipkxtea anyone:l bob smith:lr]" {
+		t.Errorf("GetACL = %s", got)
+	}
+	if err := SetACL(c, "Shared/Team", "bob smith", "lrs"); err != nil {
+		t.Error(err)
+	}
+
+	// the same rights in another order are no change
+	dst := ACL{"admin": "lrswipkxtea", "anyone": "l", "bob smith": "rl"}
+	acl["anyone"] = "lr"
+	got := fmt.Sprint(DiffACL(acl, dst, "Alice@example.org"))
+	if want := "[{anyone l lr}]"; got != want {
+		t.Errorf("DiffACL = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,402 @@
+package imaputil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/utf7"
+
+	"github.com/pepperpark/gomap/internal/pacer"
+)
+
+// appendAttempts bounds how often Append tries a message.
+const appendAttempts = 3
+
+// definiteRejections are response codes with which the server refuses a
+// message for good; trying again cannot help.
+var definiteRejections = map[imap.StatusRespCode]bool{
+	imap.CodeTryCreate: true, imap.CodeParse: true, imap.CodeBadCharset: true,
+	"OVERQUOTA": true, "TOOBIG": true, "LIMIT": true, "NOPERM": true, "CANNOT": true,
+}
+
+// Append appends the message made of parts, concatenated in order, to
+// mailbox. Callers pass a transformation such as prepended headers as its
+// own part next to the original message instead of joining them into a
+// second buffer.
+//
+// With several parts and a server that supports CATENATE (RFC 4469), every
+// part is sent as a TEXT literal of its own; otherwise the parts are
+// streamed as one literal. A message containing NUL bytes is rejected in a
+// plain literal, so it is sent as a BINARY literal8 (RFC 3516) when the
+// server supports BINARY. go-imap cannot wait for a continuation before a
+// literal8, so that also needs LITERAL+; without it the message goes out as
+// before and the server decides.
+//
+// A failed APPEND is tried up to appendAttempts times in total, as long as
+// the connection is still usable. A timeout or server error does not prove
+// that the message was not stored, so before each retry the mailbox is
+// searched for the message's Message-ID among the messages added since it
+// was selected; if it is there, the append counts as done. Throttle and
+// freeze replies are returned at once for the pacer to handle, as are
+// definite rejections like TRYCREATE or OVERQUOTA.
+func Append(c *client.Client, mailbox string, flags []string, date time.Time, parts ...[]byte) error {
+	return AppendProgress(c, mailbox, flags, date, nil, parts...)
+}
+
+// AppendProgress is Append for large messages: while the message is sent,
+// progress is called with the number of bytes written so far, at most once
+// per progressStep and once at the end. It starts over from 0 when an
+// append is retried. A message sent as a BINARY literal is reported only
+// as a whole.
+func AppendProgress(c *client.Client, mailbox string, flags []string, date time.Time, progress func(sent int64), parts ...[]byte) error {
+	_, err := AppendUID(c, mailbox, flags, date, progress, parts...)
+	return err
+}
+
+// AppendUID is AppendProgress that also returns the UID the server gave
+// the message in an APPENDUID reply (UIDPLUS, RFC 4315), or 0 if it did
+// not say.
+func AppendUID(c *client.Client, mailbox string, flags []string, date time.Time, progress func(sent int64), parts ...[]byte) (uint32, error) {
+	var minUID uint32
+	if mbox := c.Mailbox(); mbox != nil && mbox.Name == mailbox {
+		minUID = mbox.UidNext
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		var status *imap.StatusResp
+		status, err = appendOnce(c, mailbox, flags, date, parts, progress)
+		if err == nil {
+			if err = status.Err(); err == nil {
+				var uid uint32
+				if uids := appendedUIDs(status); len(uids) == 1 {
+					uid = uids[0]
+				}
+				return uid, nil
+			}
+		}
+		if attempt == appendAttempts || !appendRetryable(c, status, err) {
+			return 0, err
+		}
+		id := messageID(parts)
+		if id == "" {
+			// cannot tell whether it landed; a duplicate beats a lost message
+			log.Printf("[append] %s: %v; retrying (attempt %d of %d)", mailbox, err, attempt+1, appendAttempts)
+		} else {
+			landed, serr := appendLanded(c, mailbox, id, minUID)
+			if serr != nil {
+				return 0, err
+			}
+			if landed {
+				log.Printf("[append] %s: %v, but %s is stored; not retrying", mailbox, err, id)
+				return 0, nil
+			}
+			log.Printf("[append] %s: %v; %s not stored, retrying (attempt %d of %d)", mailbox, err, id, attempt+1, appendAttempts)
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// AppendMessage is one message of MultiAppend, made of Parts like the
+// message of Append.
+type AppendMessage struct {
+	Flags []string
+	Date  time.Time
+	Parts [][]byte
+}
+
+// MultiAppend appends msgs to mailbox with a single APPEND command
+// (MULTIAPPEND, RFC 3502), which saves a round trip per message; the
+// server stores either all of them or none. The caller checks that the
+// server supports MULTIAPPEND. Every message goes out as one plain
+// literal, without CATENATE or BINARY.
+//
+// If the command fails while the connection stays usable, the messages
+// are appended one by one with Append instead, which isolates a message
+// the server refuses (such as one with NUL bytes) and retries like Append.
+// After a failure without a server reply, messages whose Message-ID is
+// found among those added since the mailbox was selected count as
+// stored. Throttle and freeze replies are returned at once for the pacer
+// to handle. It returns the number of messages, from the start of msgs,
+// that are stored, and their UIDs from APPENDUID replies (0 where the
+// server did not say).
+func MultiAppend(c *client.Client, mailbox string, msgs []AppendMessage) (int, []uint32, error) {
+	var minUID uint32
+	if mbox := c.Mailbox(); mbox != nil && mbox.Name == mailbox {
+		minUID = mbox.UidNext
+	}
+	uids := make([]uint32, len(msgs))
+	status, err := c.Execute(&multiAppendCommand{mailbox: mailbox, msgs: msgs}, nil)
+	if err == nil {
+		if err = status.Err(); err == nil {
+			if got := appendedUIDs(status); len(got) == len(msgs) {
+				uids = got
+			}
+			return len(msgs), uids, nil
+		}
+	}
+	if c.State() == imap.LogoutState || pacer.IsThrottle(err) {
+		return 0, nil, err
+	}
+	if _, frozen := pacer.DetectFreeze(err); frozen {
+		return 0, nil, err
+	}
+	log.Printf("[append] %s: appending %d messages at once failed: %v; appending them one by one", mailbox, len(msgs), err)
+	for i, msg := range msgs {
+		if status == nil {
+			if id := messageID(msg.Parts); id != "" {
+				if landed, lerr := appendLanded(c, mailbox, id, minUID); lerr == nil && landed {
+					continue
+				}
+			}
+		}
+		uid, err := AppendUID(c, mailbox, msg.Flags, msg.Date, nil, msg.Parts...)
+		if err != nil {
+			return i, uids[:i], err
+		}
+		uids[i] = uid
+	}
+	return len(msgs), uids, nil
+}
+
+func appendOnce(c *client.Client, mailbox string, flags []string, date time.Time, parts [][]byte, progress func(int64)) (*imap.StatusResp, error) {
+	cmd := &appendCommand{mailbox: mailbox, flags: flags, date: date, parts: parts, progress: progress}
+	if len(parts) > 1 {
+		cmd.catenate, _ = c.Support("CATENATE")
+	}
+	if hasNUL(parts) {
+		binary, _ := c.Support("BINARY")
+		nonSync, _ := c.Support("LITERAL+")
+		cmd.binary = binary && nonSync
+	}
+	return c.Execute(cmd, nil)
+}
+
+// appendRetryable reports whether a failed APPEND is worth another try on
+// the same connection.
+func appendRetryable(c *client.Client, status *imap.StatusResp, err error) bool {
+	if c.State() == imap.LogoutState || pacer.IsThrottle(err) {
+		return false
+	}
+	if _, frozen := pacer.DetectFreeze(err); frozen {
+		return false
+	}
+	if status != nil {
+		return status.Type == imap.StatusRespNo && !definiteRejections[status.Code]
+	}
+	return true
+}
+
+// messageID returns the Message-ID header of the message made of parts.
+func messageID(parts [][]byte) string {
+	readers := make([]io.Reader, len(parts))
+	for i, p := range parts {
+		readers[i] = bytes.NewReader(p)
+	}
+	msg, err := mail.ReadMessage(io.MultiReader(readers...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(msg.Header.Get("Message-Id"))
+}
+
+// appendedUIDs returns the UIDs of an APPENDUID reply, in the order of
+// the messages appended, or nil.
+func appendedUIDs(status *imap.StatusResp) []uint32 {
+	if status == nil || status.Code != "APPENDUID" || len(status.Arguments) != 2 {
+		return nil
+	}
+	s, ok := status.Arguments[1].(string)
+	if !ok {
+		if n, err := imap.ParseNumber(status.Arguments[1]); err == nil {
+			return []uint32{n}
+		}
+		return nil
+	}
+	set, err := imap.ParseSeqSet(s)
+	if err != nil {
+		return nil
+	}
+	var uids []uint32
+	for _, r := range set.Set {
+		if r.Stop == 0 || r.Stop < r.Start {
+			return nil
+		}
+		for uid := r.Start; uid <= r.Stop; uid++ {
+			uids = append(uids, uid)
+		}
+	}
+	return uids
+}
+
+// appendLanded reports whether mailbox holds a message with Message-ID id
+// and a UID of at least minUID.
+func appendLanded(c *client.Client, mailbox, id string, minUID uint32) (bool, error) {
+	uids, err := landedUIDs(c, mailbox, id, minUID)
+	return len(uids) > 0, err
+}
+
+// landedUIDs returns the UIDs of the messages in mailbox with Message-ID
+// id and a UID of at least minUID. It selects mailbox anew, so messages
+// appended meanwhile are seen.
+func landedUIDs(c *client.Client, mailbox, id string, minUID uint32) ([]uint32, error) {
+	if _, err := c.Select(mailbox, false); err != nil {
+		return nil, err
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Message-Id", id)
+	if minUID > 0 {
+		criteria.Uid = new(imap.SeqSet)
+		criteria.Uid.AddRange(minUID, 0)
+	}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+	var out []uint32
+	for _, uid := range uids {
+		// "n:*" also matches the last message when all UIDs are below n
+		if uid >= minUID {
+			out = append(out, uid)
+		}
+	}
+	return out, nil
+}
+
+func hasNUL(parts [][]byte) bool {
+	for _, p := range parts {
+		if bytes.IndexByte(p, 0) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// appendCommand is APPEND with optional CATENATE parts and binary literals.
+type appendCommand struct {
+	mailbox  string
+	flags    []string
+	date     time.Time
+	parts    [][]byte
+	catenate bool
+	binary   bool
+	progress func(sent int64)
+}
+
+func (cmd *appendCommand) Command() *imap.Command {
+	mailbox, _ := utf7.Encoding.NewEncoder().String(cmd.mailbox)
+	args := []interface{}{imap.FormatMailboxName(mailbox)}
+	if cmd.flags != nil {
+		flags := make([]interface{}, len(cmd.flags))
+		for i, f := range cmd.flags {
+			flags[i] = imap.RawString(f)
+		}
+		args = append(args, flags)
+	}
+	if !cmd.date.IsZero() {
+		args = append(args, cmd.date)
+	}
+	var counter *progressCounter
+	if cmd.progress != nil && !cmd.binary {
+		counter = &progressCounter{fn: cmd.progress}
+		for _, p := range cmd.parts {
+			counter.total += int64(len(p))
+		}
+	}
+	if cmd.catenate {
+		text := make([]interface{}, 0, 2*len(cmd.parts))
+		for _, p := range cmd.parts {
+			text = append(text, imap.RawString("TEXT"), counter.wrap(cmd.literal(p)))
+		}
+		args = append(args, imap.RawString("CATENATE"), text)
+	} else if cmd.binary {
+		args = append(args, cmd.literal(bytes.Join(cmd.parts, nil)))
+	} else {
+		lit := &partsLiteral{}
+		readers := make([]io.Reader, len(cmd.parts))
+		for i, p := range cmd.parts {
+			readers[i] = bytes.NewReader(p)
+			lit.n += len(p)
+		}
+		lit.Reader = io.MultiReader(readers...)
+		args = append(args, counter.wrap(lit))
+	}
+	return &imap.Command{Name: "APPEND", Arguments: args}
+}
+
+// multiAppendCommand is APPEND of several messages (MULTIAPPEND).
+type multiAppendCommand struct {
+	mailbox string
+	msgs    []AppendMessage
+}
+
+func (cmd *multiAppendCommand) Command() *imap.Command {
+	mailbox, _ := utf7.Encoding.NewEncoder().String(cmd.mailbox)
+	args := []interface{}{imap.FormatMailboxName(mailbox)}
+	for _, msg := range cmd.msgs {
+		one := (&appendCommand{flags: msg.Flags, date: msg.Date, parts: msg.Parts}).Command()
+		args = append(args, one.Arguments[1:]...)
+	}
+	return &imap.Command{Name: "APPEND", Arguments: args}
+}
+
+// literal formats p as a literal argument. A binary literal8 is written as
+// a raw non-synchronizing "~{n+}" literal since go-imap has no literal8.
+func (cmd *appendCommand) literal(p []byte) interface{} {
+	if cmd.binary {
+		return imap.RawString(fmt.Sprintf("~{%d+}\r\n", len(p)) + string(p))
+	}
+	return bytes.NewReader(p)
+}
+
+// partsLiteral streams several buffers as one literal.
+type partsLiteral struct {
+	io.Reader
+	n int
+}
+
+func (l *partsLiteral) Len() int { return l.n }
+
+// progressStep is how many bytes of a literal are written between two
+// reports of AppendProgress.
+const progressStep = 1 << 20
+
+// progressCounter counts the bytes read from the literals of one APPEND.
+type progressCounter struct {
+	fn       func(sent int64)
+	total    int64
+	sent     int64
+	reported int64
+}
+
+// wrap returns lit counting into c, or lit itself for a nil c or an
+// argument that is not a literal.
+func (c *progressCounter) wrap(lit interface{}) interface{} {
+	l, ok := lit.(imap.Literal)
+	if c == nil || !ok {
+		return lit
+	}
+	return &progressLiteral{Literal: l, c: c}
+}
+
+type progressLiteral struct {
+	imap.Literal
+	c *progressCounter
+}
+
+func (l *progressLiteral) Read(p []byte) (int, error) {
+	n, err := l.Literal.Read(p)
+	c := l.c
+	c.sent += int64(n)
+	if c.sent-c.reported >= progressStep || (n > 0 && c.sent == c.total) {
+		c.reported = c.sent
+		c.fn(c.sent)
+	}
+	return n, err
+}
@@ -0,0 +1,158 @@
+package imaputil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestAppendCommand(t *testing.T) {
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	hdr, msg := []byte("X-A: 1\r\n"), []byte("Subject: x\r\n\r\nhi\r\n")
+	for _, tc := range []struct {
+		name string
+		cmd  appendCommand
+		want string
+	}{
+		{"plain", appendCommand{mailbox: "INBOX", flags: []string{imap.SeenFlag}, date: date, parts: [][]byte{hdr, msg}},
+			"A APPEND INBOX (\\Seen) \" 1-Mar-2024 12:00:00 +0000\" {26}\r\nX-A: 1\r\nSubject: x\r\n\r\nhi\r\n\r\n"},
+		{"catenate", appendCommand{mailbox: "INBOX", parts: [][]byte{hdr, msg}, catenate: true},
+			"A APPEND INBOX CATENATE (TEXT {8}\r\nX-A: 1\r\n TEXT {18}\r\nSubject: x\r\n\r\nhi\r\n)\r\n"},
+		{"binary", appendCommand{mailbox: "Sent", parts: [][]byte{[]byte("a\x00b")}, binary: true},
+			"A APPEND \"Sent\" ~{3+}\r\na\x00b\r\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.cmd.Command()
+			c.Tag = "A"
+			var b bytes.Buffer
+			if err := c.WriteTo(imap.NewWriter(&b)); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tc.want {
+				t.Errorf("got %q, want %q", b.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestMultiAppendCommand(t *testing.T) {
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cmd := (&multiAppendCommand{mailbox: "INBOX", msgs: []AppendMessage{
+		{Flags: []string{imap.SeenFlag}, Date: date, Parts: [][]byte{[]byte("X-A: 1\r\n"), []byte("Subject: x\r\n\r\nhi\r\n")}},
+		{Parts: [][]byte{[]byte("Subject: y\r\n\r\n")}},
+	}}).Command()
+	cmd.Tag = "A"
+	var b bytes.Buffer
+	if err := cmd.WriteTo(imap.NewWriter(&b)); err != nil {
+		t.Fatal(err)
+	}
+	want := "A APPEND INBOX (\\Seen) \" 1-Mar-2024 12:00:00 +0000\" {26}\r\nX-A: 1\r\nSubject: x\r\n\r\nhi\r\n {14}\r\nSubject: y\r\n\r\n\r\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+// lossyBackend fails the first APPEND, after storing the message if stored
+// is set, like a server that times out while or after saving it.
+type lossyBackend struct {
+	*memory.Backend
+	stored bool
+	failed bool
+}
+
+func (be *lossyBackend) Login(ci *imap.ConnInfo, username, password string) (backend.User, error) {
+	u, err := be.Backend.Login(ci, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &lossyUser{User: u, be: be}, nil
+}
+
+type lossyUser struct {
+	backend.User
+	be *lossyBackend
+}
+
+func (u *lossyUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mb, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return &lossyMailbox{Mailbox: mb, be: u.be}, nil
+}
+
+type lossyMailbox struct {
+	backend.Mailbox
+	be *lossyBackend
+}
+
+func (mb *lossyMailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	if mb.be.failed {
+		return mb.Mailbox.CreateMessage(flags, date, body)
+	}
+	mb.be.failed = true
+	if mb.be.stored {
+		if err := mb.Mailbox.CreateMessage(flags, date, body); err != nil {
+			return err
+		}
+	}
+	return errors.New("internal error, message may not have been saved")
+}
+
+func TestAppendRetry(t *testing.T) {
+	for _, stored := range []bool{false, true} {
+		be := &lossyBackend{Backend: memory.New(), stored: stored}
+		s := server.New(be)
+		s.AllowInsecureAuth = true
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Serve(l)
+		defer s.Close()
+		c, err := client.Dial(l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Login("username", "password"); err != nil {
+			t.Fatal(err)
+		}
+		status, err := c.Select("INBOX", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := status.Messages
+		msg := []byte("Message-ID: <retry@test>\r\nSubject: x\r\n\r\nhi\r\n")
+		if err := Append(c, "INBOX", nil, time.Now(), msg); err != nil {
+			t.Fatalf("stored=%v: %v", stored, err)
+		}
+		status, err = c.Select("INBOX", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status.Messages - before; got != 1 {
+			t.Fatalf("stored=%v: expected 1 new message, got %d", stored, got)
+		}
+	}
+}
+
+func TestAppendedUIDs(t *testing.T) {
+	for set, want := range map[string]string{"3955": "[3955]", "3955:3957,3960": "[3955 3956 3957 3960]", "3955:*": "[]"} {
+		reply := &imap.StatusResp{Type: imap.StatusRespOk, Code: "APPENDUID", Arguments: []interface{}{"38505", set}}
+		if got := fmt.Sprint(appendedUIDs(reply)); got != want {
+			t.Errorf("APPENDUID 38505 %s: got %s, want %s", set, got, want)
+		}
+	}
+	if appendedUIDs(&imap.StatusResp{Type: imap.StatusRespOk}) != nil {
+		t.Error("expected no UIDs without APPENDUID")
+	}
+}
@@ -0,0 +1,77 @@
+package imaputil
+
+import (
+	"strconv"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// ModSeqStatus is what STATUS reports about a mailbox on a server with
+// CONDSTORE (RFC 7162). As long as all four values stay the same, no
+// message was added, expunged or had its flags changed.
+type ModSeqStatus struct {
+	UIDValidity   uint32
+	UIDNext       uint32
+	Messages      uint32
+	HighestModSeq uint64
+}
+
+// HasCondstore reports whether the server supports CONDSTORE, which
+// QRESYNC implies.
+func HasCondstore(c *client.Client) bool {
+	for _, name := range []string{"CONDSTORE", "QRESYNC"} {
+		if ok, err := c.Support(name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusModSeq asks for the STATUS of a mailbox including HIGHESTMODSEQ,
+// without selecting it. A server without CONDSTORE, or one that keeps no
+// mod-sequences for the mailbox (NOMODSEQ), reports HighestModSeq 0.
+func StatusModSeq(c *client.Client, mailbox string) (ModSeqStatus, error) {
+	status, err := c.Status(mailbox, []imap.StatusItem{imap.StatusUidValidity, imap.StatusUidNext, imap.StatusMessages, "HIGHESTMODSEQ"})
+	if err != nil {
+		return ModSeqStatus{}, err
+	}
+	ms := ModSeqStatus{UIDValidity: status.UidValidity, UIDNext: status.UidNext, Messages: status.Messages}
+	if v, ok := status.Items["HIGHESTMODSEQ"]; ok {
+		if s, ok := v.(string); ok {
+			ms.HighestModSeq, _ = strconv.ParseUint(s, 10, 64)
+		}
+	}
+	return ms, nil
+}
+
+// changedSince is a FETCH with the CHANGEDSINCE modifier.
+type changedSince struct {
+	commands.Fetch
+	modSeq uint64
+}
+
+func (cmd *changedSince) Command() *imap.Command {
+	c := cmd.Fetch.Command()
+	c.Arguments = append(c.Arguments, []interface{}{imap.RawString("CHANGEDSINCE"), imap.RawString(strconv.FormatUint(cmd.modSeq, 10))})
+	return c
+}
+
+// FetchChangedSince fetches items of the messages in uids (of the
+// selected mailbox) whose mod-sequence is above modSeq, i.e. that were
+// flagged, unflagged or added since the server reported modSeq as its
+// HIGHESTMODSEQ. Like UidFetch it closes ch when done.
+func FetchChangedSince(c *client.Client, uids *imap.SeqSet, modSeq uint64, items []imap.FetchItem, ch chan *imap.Message) error {
+	defer close(ch)
+	if c.State() != imap.SelectedState {
+		return client.ErrNoMailboxSelected
+	}
+	cmd := &commands.Uid{Cmd: &changedSince{Fetch: commands.Fetch{SeqSet: uids, Items: items}, modSeq: modSeq}}
+	status, err := c.Execute(cmd, &responses.Fetch{Messages: ch, SeqSet: uids, Uid: true})
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
@@ -0,0 +1,106 @@
+package imaputil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+)
+
+func TestChangedSinceCommand(t *testing.T) {
+	seq := new(imap.SeqSet)
+	seq.AddRange(1, 500)
+	cmd := (&commands.Uid{Cmd: &changedSince{
+		Fetch:  commands.Fetch{SeqSet: seq, Items: []imap.FetchItem{imap.FetchUid, imap.FetchFlags}},
+		modSeq: 12345678901,
+	}}).Command()
+	cmd.Tag = "A"
+	var b bytes.Buffer
+	if err := cmd.WriteTo(imap.NewWriter(&b)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "A UID FETCH 1:500 (UID FLAGS) (CHANGEDSINCE 12345678901)\r\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+// scriptedServer answers each command line with the response lines of
+// the next script entry, "$" standing for the command's tag. CAPABILITY
+// is answered on the side.
+func scriptedServer(t *testing.T, script [][]string) *client.Client {
+	t.Helper()
+	return scriptedServerCaps(t, "IMAP4rev1 CONDSTORE", script)
+}
+
+// scriptedServerCaps is scriptedServer announcing the capabilities caps.
+func scriptedServerCaps(t *testing.T, caps string, script [][]string) *client.Client {
+	t.Helper()
+	srv, cli := net.Pipe()
+	go func() {
+		defer srv.Close()
+		r := bufio.NewReader(srv)
+		io.WriteString(srv, "* PREAUTH ready\r\n")
+		for _, lines := range script {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			tag, cmd, _ := strings.Cut(line, " ")
+			for strings.HasPrefix(cmd, "CAPABILITY") {
+				io.WriteString(srv, "* CAPABILITY "+caps+"\r\n"+tag+" OK done\r\n")
+				if line, err = r.ReadString('\n'); err != nil {
+					return
+				}
+				tag, cmd, _ = strings.Cut(line, " ")
+			}
+			for _, l := range lines {
+				io.WriteString(srv, strings.ReplaceAll(l, "$", tag)+"\r\n")
+			}
+		}
+	}()
+	c, err := client.New(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCondstore(t *testing.T) {
+	c := scriptedServer(t, [][]string{
+		{"* STATUS INBOX (UIDVALIDITY 5 UIDNEXT 10 MESSAGES 3 HIGHESTMODSEQ 90000000001)", "$ OK done"},
+		{"* 3 EXISTS", "* OK [UIDVALIDITY 5] x", "$ OK [READ-ONLY] done"},
+		{"* 2 FETCH (UID 7 FLAGS (\\Seen) MODSEQ (90000000001))", "$ OK done"},
+	})
+	if !HasCondstore(c) {
+		t.Fatal("CONDSTORE not detected")
+	}
+	ms, err := StatusModSeq(c, "INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ModSeqStatus{UIDValidity: 5, UIDNext: 10, Messages: 3, HighestModSeq: 90000000001}); ms != want {
+		t.Fatalf("StatusModSeq = %+v, want %+v", ms, want)
+	}
+	if _, err := c.Select("INBOX", true); err != nil {
+		t.Fatal(err)
+	}
+	seq := new(imap.SeqSet)
+	seq.AddRange(1, 9)
+	ch := make(chan *imap.Message, 4)
+	if err := FetchChangedSince(c, seq, 90000000000, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, ch); err != nil {
+		t.Fatal(err)
+	}
+	var got []uint32
+	for m := range ch {
+		got = append(got, m.Uid)
+	}
+	if len(got) != 1 || got[0] != 7 {
+		t.Errorf("changed UIDs = %v, want [7]", got)
+	}
+}
@@ -0,0 +1,77 @@
+package imaputil
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+)
+
+// ErrOtherDeleted is returned when only a plain EXPUNGE is available (no
+// UIDPLUS) but the selected mailbox holds messages marked \Deleted besides
+// the ones to remove: EXPUNGE would remove those too.
+var ErrOtherDeleted = errors.New("the mailbox holds other messages marked \\Deleted and the server has no UIDPLUS for UID EXPUNGE")
+
+// HasUIDPlus reports whether the server supports UIDPLUS (RFC 4315) and
+// with it UID EXPUNGE.
+func HasUIDPlus(c *client.Client) bool {
+	ok, err := c.Support("UIDPLUS")
+	return err == nil && ok
+}
+
+// CheckExpungeUIDs returns an error wrapping ErrOtherDeleted if
+// ExpungeUIDs(c, uids) would have to refuse, so a command can stop before
+// it marks anything \Deleted.
+func CheckExpungeUIDs(c *client.Client, uids []uint32) error {
+	if HasUIDPlus(c) {
+		return nil
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.WithFlags = []string{imap.DeletedFlag}
+	deleted, err := c.UidSearch(criteria)
+	if err != nil {
+		return err
+	}
+	own := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		own[uid] = true
+	}
+	others := 0
+	for _, uid := range deleted {
+		if !own[uid] {
+			others++
+		}
+	}
+	if others > 0 {
+		return fmt.Errorf("%w (%d message(s))", ErrOtherDeleted, others)
+	}
+	return nil
+}
+
+// ExpungeUIDs permanently removes the messages uids of the selected
+// mailbox, which must be marked \Deleted, and no others: with UID EXPUNGE
+// where the server has UIDPLUS, else with EXPUNGE as long as no other
+// message is marked \Deleted (see CheckExpungeUIDs).
+func ExpungeUIDs(c *client.Client, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	if !HasUIDPlus(c) {
+		if err := CheckExpungeUIDs(c, uids); err != nil {
+			return err
+		}
+		return c.Expunge(nil)
+	}
+	if c.State() != imap.SelectedState {
+		return client.ErrNoMailboxSelected
+	}
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	status, err := c.Execute(&commands.Uid{Cmd: &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{seq}}}, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
@@ -0,0 +1,143 @@
+package imaputil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// recordingServer is scriptedServerCaps that also returns the commands
+// the client sent, without tags and CAPABILITY, once the script is done.
+func recordingServer(t *testing.T, caps string, script [][]string) (*client.Client, <-chan []string) {
+	t.Helper()
+	srv, cli := net.Pipe()
+	sent := make(chan []string, 1)
+	go func() {
+		defer srv.Close()
+		var cmds []string
+		defer func() { sent <- cmds }()
+		r := bufio.NewReader(srv)
+		io.WriteString(srv, "* PREAUTH ready\r\n")
+		for _, lines := range script {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			tag, cmd, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+			for strings.HasPrefix(cmd, "CAPABILITY") {
+				io.WriteString(srv, "* CAPABILITY "+caps+"\r\n"+tag+" OK done\r\n")
+				if line, err = r.ReadString('\n'); err != nil {
+					return
+				}
+				tag, cmd, _ = strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+			}
+			cmds = append(cmds, cmd)
+			for _, l := range lines {
+				io.WriteString(srv, strings.ReplaceAll(l, "$", tag)+"\r\n")
+			}
+		}
+	}()
+	c, err := client.New(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ErrorLog = log.New(io.Discard, "", 0)
+	return c, sent
+}
+
+var selectInbox = []string{"* 12 EXISTS", "* OK [UIDVALIDITY 5] x", "$ OK [READ-WRITE] done"}
+
+func TestExpungeUIDs(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		caps   string
+		script [][]string
+		want   []string
+		err    error
+	}{
+		{
+			name:   "UIDPLUS",
+			caps:   "IMAP4rev1 UIDPLUS",
+			script: [][]string{selectInbox, {"* 3 EXPUNGE", "$ OK done"}},
+			want:   []string{"SELECT INBOX", "UID EXPUNGE 7,9"},
+		},
+		{
+			name:   "only own messages deleted",
+			caps:   "IMAP4rev1",
+			script: [][]string{selectInbox, {"* SEARCH 7 9", "$ OK done"}, {"$ OK done"}},
+			want:   []string{"SELECT INBOX", "UID SEARCH CHARSET UTF-8 DELETED", "EXPUNGE"},
+		},
+		{
+			name:   "other messages deleted",
+			caps:   "IMAP4rev1",
+			script: [][]string{selectInbox, {"* SEARCH 7 9 12", "$ OK done"}},
+			want:   []string{"SELECT INBOX", "UID SEARCH CHARSET UTF-8 DELETED"},
+			err:    ErrOtherDeleted,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, sent := recordingServer(t, tt.caps, tt.script)
+			if _, err := c.Select("INBOX", false); err != nil {
+				t.Fatal(err)
+			}
+			err := ExpungeUIDs(c, []uint32{7, 9})
+			if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("ExpungeUIDs = %v, want %v", err, tt.err)
+			}
+			c.Terminate()
+			if got := <-sent; strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoveUIDs(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		caps   string
+		script [][]string
+		want   []string
+		err    bool
+	}{
+		{
+			name:   "MOVE",
+			caps:   "IMAP4rev1 MOVE",
+			script: [][]string{selectInbox, {"$ OK moved"}},
+			want:   []string{"SELECT INBOX", "UID MOVE 7,9 \"Archive\""},
+		},
+		{
+			name:   "MOVE refused",
+			caps:   "IMAP4rev1 MOVE UIDPLUS",
+			script: [][]string{selectInbox, {"$ NO not here"}, {"$ OK copied"}, {"$ OK stored"}, {"$ OK expunged"}},
+			want:   []string{"SELECT INBOX", "UID MOVE 7,9 \"Archive\"", "UID COPY 7,9 \"Archive\"", "UID STORE 7,9 +FLAGS.SILENT (\\Deleted)", "UID EXPUNGE 7,9"},
+		},
+		{
+			name:   "no MOVE, other messages deleted",
+			caps:   "IMAP4rev1",
+			script: [][]string{selectInbox, {"* SEARCH 3", "$ OK done"}},
+			want:   []string{"SELECT INBOX", "UID SEARCH CHARSET UTF-8 DELETED"},
+			err:    true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, sent := recordingServer(t, tt.caps, tt.script)
+			if _, err := c.Select("INBOX", false); err != nil {
+				t.Fatal(err)
+			}
+			if err := MoveUIDs(c, []uint32{7, 9}, "Archive"); (err != nil) != tt.err {
+				t.Fatalf("MoveUIDs = %v, want error %v", err, tt.err)
+			}
+			c.Terminate()
+			if got := <-sent; strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
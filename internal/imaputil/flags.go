@@ -0,0 +1,108 @@
+package imaputil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// systemFlags are the flags of RFC 3501 a client may set.
+var systemFlags = []string{imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag}
+
+// standardKeywords are the keywords of the IANA registry (RFC 5788) that
+// common servers accept, lower-cased.
+var standardKeywords = map[string]bool{
+	"$forwarded": true, "$mdnsent": true, "$junk": true, "$notjunk": true,
+	"$phishing": true, "$important": true, "$submitpending": true, "$submitted": true,
+}
+
+// keywordAliases translate keywords of common clients into standard ones
+// before the rest are stripped (lower-cased keys).
+var keywordAliases = map[string]string{
+	"junk":      "$Junk",
+	"nonjunk":   "$NotJunk",
+	"notjunk":   "$NotJunk",
+	"forwarded": "$Forwarded",
+	"$label1":   "$Important", // Thunderbird's "Important" tag
+}
+
+// FlagMap translates the flags of copied messages for the destination,
+// as many servers refuse APPENDs with keywords they do not know. A nil
+// *FlagMap keeps flags as they are.
+type FlagMap struct {
+	m     map[string]string // lower-cased source flag -> destination flag, "" drops it
+	strip bool
+}
+
+// NewFlagMap returns a FlagMap from "SRC=DST" pairs (an empty DST drops
+// the flag), or nil if there is nothing to do. With strip, flags other
+// than the system flags and standard keywords are dropped after mapping;
+// common aliases such as NonJunk are translated first unless pairs say
+// otherwise.
+func NewFlagMap(pairs []string, strip bool) (*FlagMap, error) {
+	if len(pairs) == 0 && !strip {
+		return nil, nil
+	}
+	fm := &FlagMap{m: map[string]string{}, strip: strip}
+	if strip {
+		for from, to := range keywordAliases {
+			fm.m[from] = to
+		}
+	}
+	for _, p := range pairs {
+		from, to, ok := strings.Cut(p, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" {
+			return nil, fmt.Errorf("invalid --flag-map %q (expected SRC=DST, or SRC= to drop it)", p)
+		}
+		if to != "" && !validFlag(to) {
+			return nil, fmt.Errorf("invalid --flag-map %q: %s is not a flag a client can set", p, to)
+		}
+		fm.m[strings.ToLower(from)] = to
+	}
+	return fm, nil
+}
+
+// Apply returns flags mapped, stripped and without duplicates.
+func (fm *FlagMap) Apply(flags []string) []string {
+	if fm == nil {
+		return flags
+	}
+	out := make([]string, 0, len(flags))
+	seen := map[string]bool{}
+	for _, f := range flags {
+		if to, ok := fm.m[strings.ToLower(f)]; ok {
+			f = to
+		}
+		if f == "" || fm.strip && !standardFlag(f) || seen[strings.ToLower(f)] {
+			continue
+		}
+		seen[strings.ToLower(f)] = true
+		out = append(out, f)
+	}
+	return out
+}
+
+// standardFlag reports whether f is a system flag or a standard keyword.
+func standardFlag(f string) bool {
+	for _, s := range systemFlags {
+		if strings.EqualFold(f, s) {
+			return true
+		}
+	}
+	return standardKeywords[strings.ToLower(f)]
+}
+
+// validFlag reports whether f is a system flag or a keyword atom.
+func validFlag(f string) bool {
+	if strings.HasPrefix(f, `\`) {
+		for _, s := range systemFlags {
+			if strings.EqualFold(f, s) {
+				return true
+			}
+		}
+		return false
+	}
+	return !strings.ContainsAny(f, " ()[]{%*\"\\") && !strings.ContainsFunc(f, func(r rune) bool { return r < 0x21 || r > 0x7e })
+}
@@ -0,0 +1,33 @@
+package imaputil
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFlagMap(t *testing.T) {
+	flags := []string{`\Seen`, "$label1", "JunkRecorded", "NonJunk", "$Forwarded", "Project-X"}
+	for _, tc := range []struct {
+		pairs []string
+		strip bool
+		want  string
+	}{
+		{nil, false, `[\Seen $label1 JunkRecorded NonJunk $Forwarded Project-X]`},
+		{[]string{"JunkRecorded=", "project-x=$Important"}, false, `[\Seen $label1 NonJunk $Forwarded $Important]`},
+		{nil, true, `[\Seen $Important $NotJunk $Forwarded]`},
+		{[]string{"$label1=", "Project-X=\\Flagged"}, true, `[\Seen $NotJunk $Forwarded \Flagged]`},
+	} {
+		fm, err := NewFlagMap(tc.pairs, tc.strip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(fm.Apply(flags)); got != tc.want {
+			t.Errorf("%v strip=%v: got %s, want %s", tc.pairs, tc.strip, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"Junk", "=x", "x=\\Recent", "x=a b"} {
+		if _, err := NewFlagMap([]string{bad}, false); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
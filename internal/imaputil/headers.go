@@ -0,0 +1,95 @@
+package imaputil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// HeaderFields fetches the header fields named in fields of the messages
+// uids in the selected mailbox, by UID, without setting \Seen. Messages
+// the server did not return (expunged meanwhile) are missing from the
+// result; a message without any of the fields maps to an empty header.
+func HeaderFields(c *client.Client, uids []uint32, fields []string) (map[uint32]mail.Header, error) {
+	section := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier, Fields: fields},
+		Peek:         true,
+	}
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, msgs)
+	}()
+	out := make(map[uint32]mail.Header, len(uids))
+	for msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		hdr := mail.Header{}
+		if lit := msg.GetBody(section); lit != nil {
+			raw, _ := io.ReadAll(lit)
+			hdr = ParseHeader(raw)
+		}
+		out[msg.Uid] = hdr
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ParseHeader parses the header block at the start of raw, as much of it
+// as is well-formed.
+func ParseHeader(raw []byte) mail.Header {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	h, _ := r.ReadMIMEHeader()
+	return mail.Header(h)
+}
+
+// MessageDate returns the date of a message according to its header: the
+// Date header, else Resent-Date or Delivery-Date, else the earliest
+// timestamp of its Received headers. It is zero if none of them parse.
+func MessageDate(hdr mail.Header) time.Time {
+	for _, name := range []string{"Date", "Resent-Date", "Delivery-Date"} {
+		if v := hdr.Get(name); v != "" {
+			if t, err := mail.ParseDate(v); err == nil {
+				return t
+			}
+		}
+	}
+	var earliest time.Time
+	for _, v := range hdr["Received"] {
+		i := strings.LastIndex(v, ";")
+		if i < 0 {
+			continue
+		}
+		if t, err := mail.ParseDate(strings.TrimSpace(v[i+1:])); err == nil && (earliest.IsZero() || t.Before(earliest)) {
+			earliest = t
+		}
+	}
+	return earliest
+}
+
+// InternalDate returns date, the INTERNALDATE a server reported for the
+// message raw, unless it is missing: some servers report none or the Unix
+// epoch, and appending the message without a date would make it show the
+// day of the migration. Then it returns the date from the header of raw
+// (see MessageDate), if it has one.
+func InternalDate(date time.Time, raw []byte) time.Time {
+	if date.Unix() > 0 {
+		return date
+	}
+	if t := MessageDate(ParseHeader(raw)); !t.IsZero() {
+		return t
+	}
+	return date
+}
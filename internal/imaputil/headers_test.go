@@ -0,0 +1,28 @@
+package imaputil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInternalDate(t *testing.T) {
+	known := time.Date(2021, 5, 4, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name string
+		date time.Time
+		raw  string
+		want string
+	}{
+		{"reported", known, "Date: Mon, 1 Feb 2010 08:00:00 +0000\r\n\r\n", "2021-05-04T10:00:00Z"},
+		{"date header", time.Time{}, "Date: Mon, 1 Feb 2010 08:00:00 +0000\r\n\r\n", "2010-02-01T08:00:00Z"},
+		{"epoch", time.Unix(0, 0), "Resent-Date: Tue, 2 Feb 2010 08:00:00 +0000\r\n\r\n", "2010-02-02T08:00:00Z"},
+		{"received", time.Time{}, "Date: garbage\r\n" +
+			"Received: from a by b; Thu, 4 Feb 2010 08:00:00 +0000\r\n" +
+			"Received: from c by a; Wed, 3 Feb 2010 08:00:00 +0000\r\n\r\n", "2010-02-03T08:00:00Z"},
+		{"none", time.Time{}, "Subject: x\r\n\r\n", "0001-01-01T00:00:00Z"},
+	} {
+		if got := InternalDate(tc.date, []byte(tc.raw)).UTC().Format(time.RFC3339); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
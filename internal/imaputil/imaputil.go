@@ -3,107 +3,350 @@ package imaputil
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+
+	"github.com/pepperpark/gomap/internal/bwlimit"
+	"github.com/pepperpark/gomap/internal/pacer"
 )
 
-// DialAndLogin connects and logs into an IMAP server.
+// greetings holds the server greeting of each client made by Dial;
+// go-imap does not keep it.
+var greetings sync.Map // *client.Client -> string
+
+// Greeting returns the text of the server greeting c received, e.g.
+// "Gimap ready for requests from ..." ("" if unknown).
+func Greeting(c *client.Client) string {
+	g, _ := greetings.Load(c)
+	s, _ := g.(string)
+	return s
+}
+
+// greetingConn records the first line the server sends.
+type greetingConn struct {
+	net.Conn
+	mu   sync.Mutex
+	line []byte
+	done bool
+}
+
+func (g *greetingConn) Read(p []byte) (int, error) {
+	n, err := g.Conn.Read(p)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.done {
+		for _, b := range p[:n] {
+			if b == '\n' || len(g.line) >= 1024 {
+				g.done = true
+				break
+			}
+			g.line = append(g.line, b)
+		}
+	}
+	return n, err
+}
+
+// text returns the greeting without its tag, status and response code.
+func (g *greetingConn) text() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return greetingText(string(g.line))
+}
+
+// greetingText returns a greeting line without its tag, status and
+// response code.
+func greetingText(line string) string {
+	s := strings.TrimSpace(line)
+	for _, p := range []string{"* OK", "* PREAUTH", "* BYE"} {
+		s = strings.TrimSpace(strings.TrimPrefix(s, p))
+	}
+	if strings.HasPrefix(s, "[") {
+		if i := strings.Index(s, "]"); i >= 0 {
+			s = strings.TrimSpace(s[i+1:])
+		}
+	}
+	return s
+}
+
+// DialAndLogin connects and logs into an IMAP server. If ctx carries a
+// bwlimit.Limiter, the connection's bytes on the wire are limited by it,
+// and its Timeouts (see WithTimeouts) bound the waits for the server.
 func DialAndLogin(ctx context.Context, host string, port int, user, pass string, startTLS bool, tlsConfig *tls.Config) (*client.Client, error) {
-	addr := fmt.Sprintf("%s:%d", host, port)
-	var c *client.Client
-	var err error
+	c, err := Dial(ctx, host, port, startTLS, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Login(user, pass); err != nil {
+		_ = c.Logout()
+		if f, ok := pacer.DetectFreeze(err); ok {
+			return nil, fmt.Errorf("%w (%s)", err, f)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// Dial connects to an IMAP server like DialAndLogin, without logging in.
+func Dial(ctx context.Context, host string, port int, startTLS bool, tlsConfig *tls.Config) (*client.Client, error) {
+	t := timeoutsFrom(ctx)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := net.Dialer{Timeout: t.Dial}
+	raw, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.Dial > 0 {
+		// lifted once the greeting is in
+		_ = raw.SetDeadline(time.Now().Add(t.Dial))
+	}
+	cfg := &tls.Config{}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	conn := bwlimit.FromContext(ctx).Conn(raw)
 	if startTLS {
-		// Plain connection, then upgrade with STARTTLS
-		c, err = client.Dial(addr)
+		tc, greeting, err := negotiateTLS(conn, cfg)
 		if err != nil {
+			raw.Close()
 			return nil, err
 		}
-		if err := c.StartTLS(tlsConfig); err != nil {
-			_ = c.Logout()
-			return nil, err
-		}
+		// go-imap reads the greeting again, without the capabilities of
+		// the plain-text session
+		conn = &prefixConn{Conn: newStallConn(tc, t.IO), prefix: []byte("* OK " + greetingText(greeting) + "\r\n")}
 	} else {
-		c, err = client.DialTLS(addr, tlsConfig)
-		if err != nil {
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			raw.Close()
 			return nil, err
 		}
+		conn = newStallConn(tc, t.IO)
 	}
+	gc := &greetingConn{Conn: conn}
+	c, err := client.New(gc)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = raw.SetDeadline(time.Time{})
+	greetings.Store(c, gc.text())
 	// Enable raw IMAP wire debug if requested via environment variable
 	if os.Getenv("GOMAP_IMAP_DEBUG") == "1" {
 		c.SetDebug(os.Stderr)
 	}
-	// Login
-	if err := c.Login(user, pass); err != nil {
-		_ = c.Logout()
-		return nil, err
-	}
 	return c, nil
 }
 
-// ListMailboxes returns all mailbox names.
+// ListMailboxes returns the names of all selectable mailboxes, decoded
+// from modified UTF-7. Containers (\Noselect) are left out, and so are
+// mailboxes whose name cannot be decoded, which are logged.
 func ListMailboxes(ctx context.Context, c *client.Client) ([]string, error) {
+	h := &lenientList{}
+	status, err := c.Execute(&commands.List{Reference: "", Mailbox: "*"}, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	for _, raw := range h.skipped {
+		log.Printf("[list] skipping mailbox %q: name is not valid modified UTF-7", raw)
+	}
 	mailboxes := []string{}
-	ch := make(chan *imap.MailboxInfo, 32)
-	done := make(chan error, 1)
 	hasInbox := false
+	for _, m := range h.mailboxes {
+		if !selectable(m) {
+			continue
+		}
+		mailboxes = append(mailboxes, m.Name)
+		if strings.EqualFold(m.Name, "INBOX") {
+			hasInbox = true
+		}
+	}
+	if !hasInbox {
+		mailboxes = append(mailboxes, "INBOX")
+	}
+	return mailboxes, nil
+}
+
+// Subscribed returns the mailboxes c is subscribed to (LSUB). Names that
+// are not valid modified UTF-7 are left out.
+func Subscribed(c *client.Client) (map[string]bool, error) {
+	h := &lenientList{name: "LSUB"}
+	status, err := c.Execute(&commands.List{Reference: "", Mailbox: "*", Subscribed: true}, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	subs := make(map[string]bool, len(h.mailboxes))
+	for _, m := range h.mailboxes {
+		subs[m.Name] = true
+	}
+	return subs, nil
+}
+
+// Delimiter returns the server's hierarchy delimiter, "/" if it reports
+// none (a flat namespace).
+func Delimiter(c *client.Client) (string, error) {
+	ch := make(chan *imap.MailboxInfo, 4)
+	done := make(chan error, 1)
 	go func() {
-		done <- c.List("", "*", ch)
-		close(done)
+		done <- c.List("", "", ch)
 	}()
+	delim := "/"
 	for m := range ch {
-		if m != nil {
-			mailboxes = append(mailboxes, m.Name)
-			if strings.EqualFold(m.Name, "INBOX") {
-				hasInbox = true
-			}
+		if m != nil && m.Delimiter != "" {
+			delim = m.Delimiter
 		}
 	}
 	if err := <-done; err != nil {
-		return nil, err
-	}
-	if !hasInbox {
-		mailboxes = append(mailboxes, "INBOX")
+		return "", err
 	}
-	return mailboxes, nil
+	return delim, nil
 }
 
-// SelectMailbox selects a mailbox in read-only or read-write mode.
+// ErrNoMailbox is returned by SelectMailbox for a mailbox that does not
+// exist or cannot be selected, e.g. one deleted since it was listed.
+var ErrNoMailbox = errors.New("mailbox does not exist")
+
+// SelectMailbox selects a mailbox in read-only or read-write mode. If the
+// server refuses and a LIST shows the mailbox is gone (or a \Noselect
+// container), the error wraps ErrNoMailbox.
 func SelectMailbox(c *client.Client, name string, readOnly bool) (*imap.MailboxStatus, error) {
-	return c.Select(name, readOnly)
+	status, err := c.Select(name, readOnly)
+	if err != nil && !ConnClosed(err) {
+		if ok, lerr := MailboxSelectable(c, name); lerr == nil && !ok {
+			return nil, fmt.Errorf("%w: %v", ErrNoMailbox, err)
+		}
+	}
+	return status, err
+}
+
+// MailboxSelectable reports whether LIST shows name as a selectable
+// mailbox.
+func MailboxSelectable(c *client.Client, name string) (bool, error) {
+	h := &lenientList{}
+	status, err := c.Execute(&commands.List{Reference: "", Mailbox: name}, h)
+	if err != nil {
+		return false, err
+	}
+	if err := status.Err(); err != nil {
+		return false, err
+	}
+	for _, m := range h.mailboxes {
+		// the name may hold wildcards matching other mailboxes
+		if m.Name == name || strings.EqualFold(name, "INBOX") && strings.EqualFold(m.Name, "INBOX") {
+			return selectable(m), nil
+		}
+	}
+	return false, nil
+}
+
+// selectable reports whether m can be selected: not a \Noselect
+// container nor a \NonExistent placeholder (RFC 5258).
+func selectable(m *imap.MailboxInfo) bool {
+	for _, attr := range m.Attributes {
+		if strings.EqualFold(attr, imap.NoSelectAttr) || strings.EqualFold(attr, `\NonExistent`) {
+			return false
+		}
+	}
+	return true
 }
 
 // SearchUIDsSince returns UIDs since a time and after a minimal UID.
 func SearchUIDsSince(c *client.Client, since time.Time, minUID uint32) ([]uint32, error) {
-	criteria := imap.NewSearchCriteria()
-	if !since.IsZero() {
-		criteria.Since = since
-	}
-	if minUID > 0 {
-		criteria.Uid = new(imap.SeqSet)
-		criteria.Uid.AddRange(uint32(minUID+1), 4294967295)
+	return SearchUIDsRange(c, since, time.Time{}, minUID)
+}
+
+// SearchUIDsRange returns UIDs with since <= INTERNALDATE < before and above
+// a minimal UID. Zero times leave the corresponding bound open. Mailboxes
+// too large for one SEARCH, or whose server rejects or truncates the
+// result, are searched in UID windows (see searchWindows).
+func SearchUIDsRange(c *client.Client, since, before time.Time, minUID uint32) ([]uint32, error) {
+	return SearchUIDs(c, since, before, minUID, FlagFilter{})
+}
+
+// SearchUIDs is SearchUIDsRange for the messages whose flags pass f.
+func SearchUIDs(c *client.Client, since, before time.Time, minUID uint32, f FlagFilter) ([]uint32, error) {
+	mbox := c.Mailbox()
+	if mbox != nil && mbox.Messages > SearchWindow {
+		return searchWindows(c, since, before, minUID, f)
 	}
-	uids, err := c.UidSearch(criteria)
+	uids, err := c.UidSearch(rangeCriteria(since, before, minUID+1, 4294967295, f))
 	if err != nil {
-		return nil, err
+		if c.State() == imap.LogoutState {
+			return nil, err
+		}
+		log.Printf("[search] %s: %v; searching in UID windows", mailboxName(mbox), err)
+		return searchWindows(c, since, before, minUID, f)
+	}
+	if mbox != nil && since.IsZero() && before.IsZero() && minUID == 0 && f.IsZero() && uint32(len(uids)) < mbox.Messages {
+		log.Printf("[search] %s: SEARCH returned %d of %d messages; searching in UID windows", mailboxName(mbox), len(uids), mbox.Messages)
+		return searchWindows(c, since, before, minUID, f)
 	}
 	return uids, nil
 }
 
 // EnsureMailbox tries to select mailbox and creates it if missing.
 func EnsureMailbox(c *client.Client, name string) error {
-	if _, err := SelectMailbox(c, name, false); err == nil {
+	if _, err := c.Select(name, false); err == nil {
 		return nil
 	}
 	if err := c.Create(name); err != nil {
-		if _, selErr := SelectMailbox(c, name, false); selErr == nil {
+		if _, selErr := c.Select(name, false); selErr == nil {
 			return nil
 		}
 		return err
 	}
 	return nil
 }
+
+// MoveUIDs moves messages of the selected mailbox to dest. Where MOVE is
+// missing or refused outright (some servers announce it but answer NO)
+// they are copied, marked \Deleted and removed with ExpungeUIDs, so other
+// messages marked \Deleted stay; without UIDPLUS the move fails with
+// ErrOtherDeleted before anything is copied if there are any.
+func MoveUIDs(c *client.Client, uids []uint32, dest string) error {
+	if c.State() != imap.SelectedState {
+		return client.ErrNoMailboxSelected
+	}
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	if ok, err := c.Support("MOVE"); err != nil {
+		return err
+	} else if ok {
+		status, err := c.Execute(&commands.Uid{Cmd: &commands.Move{SeqSet: seq, Mailbox: dest}}, nil)
+		if err != nil {
+			return err
+		}
+		if status == nil || status.Type == imap.StatusRespOk {
+			return status.Err()
+		}
+	}
+	if err := CheckExpungeUIDs(c, uids); err != nil {
+		return err
+	}
+	if err := c.UidCopy(seq, dest); err != nil {
+		return err
+	}
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := c.UidStore(seq, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+	return ExpungeUIDs(c, uids)
+}
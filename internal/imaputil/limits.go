@@ -0,0 +1,118 @@
+package imaputil
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+)
+
+// NameLimits caps the mailbox names created on a server. Zero fields mean
+// no limit.
+type NameLimits struct {
+	MaxLength int // bytes of one hierarchy level, in modified UTF-7
+	MaxDepth  int // hierarchy levels
+}
+
+// fallbackLength is the level length tried when a server refuses a name
+// without saying what its limit is.
+const fallbackLength = 64
+
+// Fit returns name shortened to l, with delim as the hierarchy delimiter.
+// Levels beyond MaxDepth are joined into the last allowed level with "_".
+// A level longer than MaxLength is cut and ends in "~" and 8 hex digits of
+// the SHA-1 of the whole level, so shortened names stay apart and come out
+// the same on every run.
+func (l NameLimits) Fit(name, delim string) string {
+	levels := []string{name}
+	if delim != "" {
+		levels = strings.Split(name, delim)
+	}
+	if l.MaxDepth > 0 && len(levels) > l.MaxDepth {
+		last := strings.Join(levels[l.MaxDepth-1:], "_")
+		levels = append(levels[:l.MaxDepth-1], last)
+	}
+	if l.MaxLength > 0 {
+		for i, level := range levels {
+			levels[i] = fitLevel(level, l.MaxLength)
+		}
+	}
+	return strings.Join(levels, delim)
+}
+
+func fitLevel(level string, max int) string {
+	if len(EncodeMailboxName(level)) <= max {
+		return level
+	}
+	sum := sha1.Sum([]byte(level))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+	runes := []rune(level)
+	for n := len(runes); n > 0; n-- {
+		if s := string(runes[:n]) + suffix; len(EncodeMailboxName(s)) <= max {
+			return s
+		}
+	}
+	return suffix
+}
+
+// nameLimitRe matches the texts with which servers refuse over-long or
+// too deeply nested mailbox names.
+var nameLimitRe = regexp.MustCompile(`(?i)too long|too deep|nest|depth|length|hierarchy`)
+
+// CreateFitting makes sure a mailbox for name exists, like EnsureMailbox,
+// and returns the name it got. The name is first fitted to l. If the
+// server still refuses it for its length or depth (a LIMIT response code,
+// RFC 5530, or a reply text saying so), levels are cut to fallbackLength
+// bytes and then the depth is reduced one level at a time until CREATE
+// succeeds.
+func CreateFitting(c *client.Client, name, delim string, l NameLimits) (string, error) {
+	fitted := l.Fit(name, delim)
+	limited, err := ensureFitting(c, fitted)
+	if !limited {
+		return fitted, err
+	}
+	if l.MaxLength == 0 || l.MaxLength > fallbackLength {
+		l.MaxLength = fallbackLength
+	}
+	depth := 1
+	if delim != "" {
+		depth = strings.Count(name, delim) + 1
+	}
+	if l.MaxDepth == 0 || l.MaxDepth > depth {
+		l.MaxDepth = depth
+	}
+	for ; l.MaxDepth > 0; l.MaxDepth-- {
+		try := l.Fit(name, delim)
+		if try == fitted {
+			continue
+		}
+		fitted = try
+		if limited, err = ensureFitting(c, fitted); !limited {
+			return fitted, err
+		}
+	}
+	return fitted, err
+}
+
+// ensureFitting is EnsureMailbox that also reports whether a failed
+// CREATE was refused for the length or depth of the name.
+func ensureFitting(c *client.Client, name string) (limited bool, err error) {
+	if _, err := c.Select(name, false); err == nil {
+		return false, nil
+	}
+	// go-imap drops the response code from errors
+	status, err := c.Execute(&commands.Create{Mailbox: name}, nil)
+	if err == nil {
+		err = status.Err()
+	}
+	if err == nil {
+		return false, nil
+	}
+	if _, selErr := c.Select(name, false); selErr == nil {
+		return false, nil
+	}
+	return status != nil && (status.Code == "LIMIT" || nameLimitRe.MatchString(status.Info)), err
+}
@@ -0,0 +1,50 @@
+package imaputil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNameLimitsFit(t *testing.T) {
+	long := strings.Repeat("x", 80)
+	l := NameLimits{MaxLength: 20, MaxDepth: 3}
+	got := l.Fit("A/B/C/D/"+long, "/")
+	levels := strings.Split(got, "/")
+	if len(levels) != 3 || levels[0] != "A" || levels[1] != "B" {
+		t.Fatalf("Fit = %q, want 3 levels below A/B", got)
+	}
+	if n := len(levels[2]); n > 20 || !strings.HasPrefix(levels[2], "C_D_x") || !strings.Contains(levels[2], "~") {
+		t.Errorf("last level %q (%d bytes), want C_D_x... cut to 20 bytes with a hash", levels[2], n)
+	}
+	if again := l.Fit(got, "/"); again != got {
+		t.Errorf("Fit is not stable: %q -> %q", got, again)
+	}
+	if other := l.Fit("A/B/C/D/"+long+"y", "/"); other == got {
+		t.Errorf("different names fit to the same %q", got)
+	}
+	// the length counts the modified UTF-7 form
+	if got := (NameLimits{MaxLength: 16}).Fit(strings.Repeat("ü", 10), "/"); len(EncodeMailboxName(got)) > 16 {
+		t.Errorf("Fit(ü...) = %q, encoded %d bytes", got, len(EncodeMailboxName(got)))
+	}
+	if got := (NameLimits{}).Fit("A/B/"+long, "/"); got != "A/B/"+long {
+		t.Errorf("zero limits changed the name to %q", got)
+	}
+}
+
+func TestCreateFittingFallsBack(t *testing.T) {
+	long := strings.Repeat("y", 100)
+	c := scriptedServer(t, [][]string{
+		{"$ NO no such mailbox"},               // SELECT
+		{"$ NO [LIMIT] mailbox name too long"}, // CREATE
+		{"$ NO no such mailbox"},               // SELECT
+		{"$ NO no such mailbox"},               // SELECT of the shortened name
+		{"$ OK created"},                       // CREATE
+	})
+	got, err := CreateFitting(c, "Archive/"+long, "/", NameLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (NameLimits{MaxLength: fallbackLength}).Fit("Archive/"+long, "/"); got != want {
+		t.Errorf("CreateFitting = %q, want %q", got, want)
+	}
+}
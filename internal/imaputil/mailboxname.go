@@ -0,0 +1,96 @@
+package imaputil
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// DecodeMailboxName returns the readable form of a mailbox name written in
+// IMAP modified UTF-7 (RFC 3501 section 5.1.3), e.g. "Entwürfe" for
+// "Entw&APw-rfe". A name that is not valid modified UTF-7 is returned
+// unchanged, so names that are already decoded pass through.
+func DecodeMailboxName(name string) string {
+	if !strings.Contains(name, "&") {
+		return name
+	}
+	dec, err := utf7.Encoding.NewDecoder().String(name)
+	if err != nil {
+		return name
+	}
+	return dec
+}
+
+// EncodeMailboxName returns the modified UTF-7 form of name as it is sent
+// to the server.
+func EncodeMailboxName(name string) string {
+	enc, err := utf7.Encoding.NewEncoder().String(name)
+	if err != nil {
+		return name
+	}
+	return enc
+}
+
+// MatchPattern reports whether the mailbox name matches pattern with the
+// wildcards of LIST (RFC 3501 section 6.3.8): "*" matches any characters,
+// "%" any characters but the hierarchy delimiter delim. INBOX matches
+// case-insensitively.
+func MatchPattern(pattern, delim, name string) bool {
+	if strings.EqualFold(name, "INBOX") && strings.EqualFold(pattern, "INBOX") {
+		return true
+	}
+	if pattern == "" {
+		return name == ""
+	}
+	switch pattern[0] {
+	case '*', '%':
+		for i := 0; i <= len(name); i++ {
+			if MatchPattern(pattern[1:], delim, name[i:]) {
+				return true
+			}
+			if pattern[0] == '%' && delim != "" && strings.HasPrefix(name[i:], delim) {
+				return false
+			}
+		}
+		return false
+	}
+	return name != "" && name[0] == pattern[0] && MatchPattern(pattern[1:], delim, name[1:])
+}
+
+// lenientList collects the mailboxes of a LIST response. go-imap fails the
+// whole LIST on the first name that is not valid modified UTF-7 (a stray
+// "&" or raw 8-bit bytes from a lax server); those names are collected in
+// skipped instead, as go-imap could not address them anyway. name is the
+// response name to collect, "LIST" if empty.
+type lenientList struct {
+	name      string
+	mailboxes []*imap.MailboxInfo
+	skipped   []string
+}
+
+func (r *lenientList) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	want := r.name
+	if want == "" {
+		want = "LIST"
+	}
+	if !ok || name != want {
+		return responses.ErrUnhandled
+	}
+	info := &imap.MailboxInfo{}
+	if err := info.Parse(fields); err != nil {
+		if len(fields) < 3 {
+			return err
+		}
+		raw, perr := imap.ParseString(fields[2])
+		if perr != nil {
+			return err
+		}
+		r.skipped = append(r.skipped, raw)
+		return nil
+	}
+	r.mailboxes = append(r.mailboxes, info)
+	return nil
+}
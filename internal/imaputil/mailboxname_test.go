@@ -0,0 +1,137 @@
+package imaputil
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestMailboxNameCoding(t *testing.T) {
+	tests := []struct{ raw, name string }{
+		{"INBOX", "INBOX"},
+		{"Entw&APw-rfe", "Entwürfe"},
+		{"Gel&APY-schte Elemente", "Gelöschte Elemente"},
+		{"Tom &- Jerry", "Tom & Jerry"},
+	}
+	for _, tt := range tests {
+		if got := DecodeMailboxName(tt.raw); got != tt.name {
+			t.Errorf("DecodeMailboxName(%q) = %q, want %q", tt.raw, got, tt.name)
+		}
+		if got := EncodeMailboxName(tt.name); got != tt.raw {
+			t.Errorf("EncodeMailboxName(%q) = %q, want %q", tt.name, got, tt.raw)
+		}
+	}
+	// already decoded or not valid modified UTF-7: unchanged
+	for _, name := range []string{"Entwürfe", "R&D"} {
+		if got := DecodeMailboxName(name); got != name {
+			t.Errorf("DecodeMailboxName(%q) = %q, want it unchanged", name, got)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"Lists/*", "Lists/go", true},
+		{"Lists/*", "Lists/go/nuts", true},
+		{"Lists/*", "Lists", false},
+		{"Lists/%", "Lists/go", true},
+		{"Lists/%", "Lists/go/nuts", false},
+		{"%/Archive", "2019/Archive", true},
+		{"*", "Entwürfe", true},
+		{"Entw*", "Entwürfe", true},
+		{"inbox", "INBOX", true},
+		{"Sent", "Sent Items", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPattern(tt.pattern, "/", tt.name); got != tt.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListMailboxesSkipsInvalidNames(t *testing.T) {
+	c := scriptedServer(t, [][]string{{
+		`* LIST () "/" "Entw&APw-rfe"`,
+		`* LIST () "/" "R&D"`,
+		`* LIST () "/" INBOX`,
+		"$ OK done",
+	}})
+	boxes, err := ListMailboxes(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(boxes, ","); got != "Entwürfe,INBOX" {
+		t.Errorf("ListMailboxes = %q, want Entwürfe,INBOX", got)
+	}
+}
+
+func TestListMailboxesSkipsContainers(t *testing.T) {
+	c := scriptedServer(t, [][]string{{
+		`* LIST (\Noselect \HasChildren) "/" Archive`,
+		`* LIST (\HasNoChildren) "/" Archive/2023`,
+		`* LIST (\NonExistent) "/" Old`,
+		`* LIST () "/" INBOX`,
+		"$ OK done",
+	}})
+	boxes, err := ListMailboxes(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(boxes, ","); got != "Archive/2023,INBOX" {
+		t.Errorf("ListMailboxes = %q, want Archive/2023,INBOX", got)
+	}
+}
+
+func TestSelectMissingMailbox(t *testing.T) {
+	c := scriptedServer(t, [][]string{
+		{"$ NO No such mailbox"},
+		{"$ OK done"}, // LIST: gone
+		{"$ NO Mailbox is locked"},
+		{`* LIST () "/" Busy`, "$ OK done"},
+		{"$ NO Not selectable"},
+		{`* LIST (\Noselect) "/" Archive`, "$ OK done"},
+	})
+	if _, err := SelectMailbox(c, "Gone", true); !errors.Is(err, ErrNoMailbox) {
+		t.Errorf("SelectMailbox(Gone) = %v, want ErrNoMailbox", err)
+	}
+	if _, err := SelectMailbox(c, "Busy", true); err == nil || errors.Is(err, ErrNoMailbox) {
+		t.Errorf("SelectMailbox(Busy) = %v, want the server's error", err)
+	}
+	if _, err := SelectMailbox(c, "Archive", true); !errors.Is(err, ErrNoMailbox) {
+		t.Errorf("SelectMailbox(Archive) = %v, want ErrNoMailbox", err)
+	}
+}
+
+func TestSpecialUse(t *testing.T) {
+	c := scriptedServer(t, [][]string{{
+		`* LIST (\HasNoChildren) "/" INBOX`,
+		`* LIST (\HasNoChildren \Trash) "/" Corbeille`,
+		`* LIST (\Spam) "/" "Courrier ind&AOk-sirable"`,
+		`* LIST (\Sent) "/" "Envoy&AOk-s"`,
+		`* LIST (\Archive) "/" Archives`,
+		"$ OK done",
+	}})
+	uses, err := SpecialUse(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Corbeille":            imap.TrashAttr,
+		"Courrier indésirable": imap.JunkAttr,
+		"Envoyés":              imap.SentAttr,
+	}
+	if len(uses) != len(want) {
+		t.Errorf("SpecialUse = %v, want %v", uses, want)
+	}
+	for name, attr := range want {
+		if uses[name] != attr {
+			t.Errorf("SpecialUse[%q] = %q, want %q", name, uses[name], attr)
+		}
+	}
+}
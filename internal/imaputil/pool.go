@@ -0,0 +1,133 @@
+package imaputil
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// ErrPoolBusy is returned by Pool.TryGet when every connection is in use.
+var ErrPoolBusy = errors.New("all connections in use")
+
+// Pool shares the connections to one IMAP account among goroutines, at
+// most max at a time. A connection is used by one goroutine between Get
+// and Put; which mailbox is selected is up to that goroutine. Connections
+// are opened on demand with dial and kept for reuse.
+type Pool struct {
+	dial  func(ctx context.Context) (*client.Client, error)
+	slots chan struct{}
+	mu    sync.Mutex
+	idle  []*client.Client
+	owned []*client.Client // opened by the pool, logged out by Close
+}
+
+// NewPool returns a pool of at most size connections (at least 1). first,
+// if not nil, is an open connection the pool starts with; it stays the
+// caller's and is not logged out by Close.
+func NewPool(first *client.Client, size int, dial func(ctx context.Context) (*client.Client, error)) *Pool {
+	p := &Pool{dial: dial, slots: make(chan struct{}, max(size, 1))}
+	if first != nil {
+		p.idle = append(p.idle, first)
+	}
+	return p
+}
+
+// Get returns a connection, waiting while all of them are in use.
+func (p *Pool) Get(ctx context.Context) (*client.Client, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.take(ctx)
+}
+
+// TryGet is Get without waiting: it fails with ErrPoolBusy when all
+// connections are in use.
+func (p *Pool) TryGet(ctx context.Context) (*client.Client, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return nil, ErrPoolBusy
+	}
+	return p.take(ctx)
+}
+
+// take returns an idle connection or opens one; the caller holds a slot.
+func (p *Pool) take(ctx context.Context) (*client.Client, error) {
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if Alive(c) {
+			p.mu.Unlock()
+			return c, nil
+		}
+	}
+	p.mu.Unlock()
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	p.mu.Lock()
+	p.owned = append(p.owned, c)
+	p.mu.Unlock()
+	return c, nil
+}
+
+// Put gives back a connection from Get or TryGet. Connections that were
+// closed meanwhile are dropped, so the next Get opens a new one.
+func (p *Pool) Put(c *client.Client) {
+	p.mu.Lock()
+	if Alive(c) {
+		p.idle = append(p.idle, c)
+	}
+	p.mu.Unlock()
+	<-p.slots
+}
+
+// Alive reports whether c can still be used: it is not logged out, and
+// its connection was not closed by the server or a network error.
+func Alive(c *client.Client) bool {
+	if c.State() == imap.LogoutState {
+		return false
+	}
+	select {
+	case <-c.LoggedOut():
+		return false
+	default:
+		return true
+	}
+}
+
+// ConnClosed reports whether err means the connection it happened on is
+// gone, as when the server drops idle or long-running sessions.
+func ConnClosed(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "imap: connection closed") ||
+		strings.Contains(msg, "connection closed during command execution") ||
+		strings.Contains(msg, "unexpected EOF")
+}
+
+// Close logs out the connections the pool opened, including those still
+// in use, which unblocks their pending commands. A nil Pool is a no-op.
+func (p *Pool) Close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	owned := p.owned
+	p.owned = nil
+	p.mu.Unlock()
+	for _, c := range owned {
+		_ = c.Logout()
+	}
+}
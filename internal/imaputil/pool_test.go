@@ -0,0 +1,79 @@
+package imaputil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestPool(t *testing.T) {
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+	dials := 0
+	dial := func(context.Context) (*client.Client, error) {
+		dials++
+		c, err := client.Dial(l.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return c, c.Login("username", "password")
+	}
+	first, err := dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Logout()
+	dials = 0
+
+	ctx := context.Background()
+	p := NewPool(first, 2, dial)
+	a, err := p.Get(ctx)
+	if err != nil || a != first {
+		t.Fatalf("first Get = %v, %v; want the connection the pool started with", a, err)
+	}
+	b, err := p.Get(ctx)
+	if err != nil || b == first || dials != 1 {
+		t.Fatalf("second Get = %v, %v after %d dials; want a new connection", b, err, dials)
+	}
+	if _, err := p.TryGet(ctx); !errors.Is(err, ErrPoolBusy) {
+		t.Errorf("TryGet with both connections in use = %v, want ErrPoolBusy", err)
+	}
+	waitCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := p.Get(waitCtx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Get on a full pool with a canceled context = %v", err)
+	}
+
+	// a closed connection is dropped and replaced on the next Get
+	b.Logout()
+	p.Put(b)
+	c, err := p.TryGet(ctx)
+	if err != nil || c == b || dials != 2 {
+		t.Errorf("TryGet after a dropped connection = %v, %v after %d dials", c, err, dials)
+	}
+	p.Put(c)
+	p.Put(a)
+	if again, _ := p.Get(ctx); again != a && again != c {
+		t.Error("Get did not reuse an idle connection")
+	}
+
+	p.Close()
+	if c.State() != imap.LogoutState {
+		t.Error("Close left a pool connection open")
+	}
+	if first.State() == imap.LogoutState {
+		t.Error("Close logged out the caller's connection")
+	}
+}
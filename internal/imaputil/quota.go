@@ -0,0 +1,112 @@
+package imaputil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+)
+
+// Quota is the storage quota of a mailbox (RFC 9208), in bytes.
+type Quota struct {
+	Used, Limit int64
+}
+
+// Free is the space left under the quota.
+func (q Quota) Free() int64 {
+	if q.Used >= q.Limit {
+		return 0
+	}
+	return q.Limit - q.Used
+}
+
+// QuotaResource is the usage and limit of one resource of a quota root,
+// e.g. STORAGE or MESSAGE. STORAGE is counted in units of 1024 octets.
+type QuotaResource struct {
+	Name         string
+	Usage, Limit int64
+}
+
+// QuotaRoot is a quota root and its resource limits (RFC 9208).
+type QuotaRoot struct {
+	Name      string
+	Resources []QuotaResource
+}
+
+// quotaList collects the QUOTA responses.
+type quotaList struct {
+	roots []QuotaRoot
+}
+
+func (r *quotaList) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != "QUOTAROOT" && name != "QUOTA" {
+		return responses.ErrUnhandled
+	}
+	if name == "QUOTAROOT" || len(fields) < 2 {
+		return nil
+	}
+	root, _ := imap.ParseString(fields[0])
+	list, ok := fields[1].([]interface{})
+	if !ok {
+		return fmt.Errorf("cannot parse QUOTA response: resource list is a %T", fields[1])
+	}
+	q := QuotaRoot{Name: root}
+	for i := 0; i+2 < len(list); i += 3 {
+		res, _ := list[i].(string)
+		used, err := strconv.ParseInt(fmt.Sprint(list[i+1]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot parse QUOTA usage: %w", err)
+		}
+		limit, err := strconv.ParseInt(fmt.Sprint(list[i+2]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot parse QUOTA limit: %w", err)
+		}
+		q.Resources = append(q.Resources, QuotaResource{Name: strings.ToUpper(res), Usage: used, Limit: limit})
+	}
+	r.roots = append(r.roots, q)
+	return nil
+}
+
+// QuotaRoots returns the quota roots of mailbox with their resources,
+// asked with GETQUOTAROOT. It returns nil when the server has no QUOTA
+// extension.
+func QuotaRoots(c *client.Client, mailbox string) ([]QuotaRoot, error) {
+	if has, err := c.Support("QUOTA"); err != nil || !has {
+		return nil, err
+	}
+	h := &quotaList{}
+	status, err := c.Execute(&imap.Command{Name: "GETQUOTAROOT", Arguments: []interface{}{EncodeMailboxName(mailbox)}}, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	return h.roots, nil
+}
+
+// StorageQuota returns the tightest storage quota of mailbox, asked with
+// GETQUOTAROOT. ok is false when the server has no QUOTA extension or sets
+// no storage limit for the mailbox.
+func StorageQuota(c *client.Client, mailbox string) (q Quota, ok bool, err error) {
+	roots, err := QuotaRoots(c, mailbox)
+	if err != nil {
+		return Quota{}, false, err
+	}
+	for _, root := range roots {
+		for _, res := range root.Resources {
+			if res.Name != "STORAGE" {
+				continue
+			}
+			cur := Quota{Used: res.Usage << 10, Limit: res.Limit << 10}
+			if !ok || cur.Free() < q.Free() {
+				q, ok = cur, true
+			}
+		}
+	}
+	return q, ok, nil
+}
@@ -0,0 +1,41 @@
+package imaputil
+
+import "testing"
+
+func TestStorageQuota(t *testing.T) {
+	c := scriptedServerCaps(t, "IMAP4rev1 QUOTA", [][]string{{
+		`* QUOTAROOT INBOX "" "user"`,
+		`* QUOTA "" (STORAGE 1024 4096 MESSAGE 10 100000)`,
+		`* QUOTA "user" (STORAGE 3000 5000)`,
+		"$ OK done",
+	}})
+	q, ok, err := StorageQuota(c, "INBOX")
+	if err != nil || !ok {
+		t.Fatalf("StorageQuota = %v, %v", ok, err)
+	}
+	if q.Used != 3000<<10 || q.Limit != 5000<<10 || q.Free() != 2000<<10 {
+		t.Errorf("StorageQuota = %+v, want the tighter user quota", q)
+	}
+
+	c = scriptedServerCaps(t, "IMAP4rev1 QUOTA", [][]string{{
+		`* QUOTAROOT INBOX "" "user"`,
+		`* QUOTA "" (STORAGE 1024 4096 MESSAGE 10 100000)`,
+		`* QUOTA "user" (STORAGE 3000 5000)`,
+		"$ OK done",
+	}})
+	roots, err := QuotaRoots(c, "INBOX")
+	if err != nil || len(roots) != 2 {
+		t.Fatalf("QuotaRoots = %+v, %v", roots, err)
+	}
+	if roots[0].Name != "" || len(roots[0].Resources) != 2 || roots[0].Resources[1] != (QuotaResource{Name: "MESSAGE", Usage: 10, Limit: 100000}) {
+		t.Errorf("root %+v, want the STORAGE and MESSAGE limits of \"\"", roots[0])
+	}
+	if roots[1].Name != "user" {
+		t.Errorf("root %q, want user", roots[1].Name)
+	}
+
+	c = scriptedServer(t, [][]string{{}})
+	if _, ok, err := StorageQuota(c, "INBOX"); ok || err != nil {
+		t.Errorf("StorageQuota without QUOTA = %v, %v", ok, err)
+	}
+}
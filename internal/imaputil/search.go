@@ -0,0 +1,173 @@
+package imaputil
+
+import (
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// SearchWindow is the UID span searched at once in mailboxes with more
+// messages than that. Some servers truncate or fail SEARCH results with
+// hundreds of thousands of UIDs; a window keeps every result small.
+const SearchWindow = 50000
+
+// FlagFilter narrows a search by message flags. The zero value matches
+// every message.
+type FlagFilter struct {
+	OnlyUnseen  bool // messages without \Seen
+	OnlyFlagged bool // messages with \Flagged
+	SkipDeleted bool // messages without \Deleted
+}
+
+// IsZero reports whether f matches every message.
+func (f FlagFilter) IsZero() bool { return f == FlagFilter{} }
+
+func (f FlagFilter) apply(criteria *imap.SearchCriteria) {
+	if f.OnlyUnseen {
+		criteria.WithoutFlags = append(criteria.WithoutFlags, imap.SeenFlag)
+	}
+	if f.OnlyFlagged {
+		criteria.WithFlags = append(criteria.WithFlags, imap.FlaggedFlag)
+	}
+	if f.SkipDeleted {
+		criteria.WithoutFlags = append(criteria.WithoutFlags, imap.DeletedFlag)
+	}
+}
+
+// match reports whether a message with flags passes f.
+func (f FlagFilter) match(flags []string) bool {
+	has := func(flag string) bool {
+		for _, fl := range flags {
+			if strings.EqualFold(fl, flag) {
+				return true
+			}
+		}
+		return false
+	}
+	return !(f.OnlyUnseen && has(imap.SeenFlag) || f.OnlyFlagged && !has(imap.FlaggedFlag) || f.SkipDeleted && has(imap.DeletedFlag))
+}
+
+// rangeCriteria matches since <= INTERNALDATE < before, lo <= UID <= hi
+// and f.
+func rangeCriteria(since, before time.Time, lo, hi uint32, f FlagFilter) *imap.SearchCriteria {
+	criteria := imap.NewSearchCriteria()
+	f.apply(criteria)
+	if !since.IsZero() {
+		criteria.Since = since
+	}
+	if !before.IsZero() {
+		criteria.Before = before
+	}
+	if lo > 1 || hi < 4294967295 {
+		criteria.Uid = new(imap.SeqSet)
+		criteria.Uid.AddRange(lo, hi)
+	}
+	return criteria
+}
+
+// uidWindows splits the UIDs above minUID and below uidNext into spans of
+// SearchWindow UIDs.
+func uidWindows(minUID, uidNext uint32) [][2]uint32 {
+	var out [][2]uint32
+	for lo := uint64(minUID) + 1; lo < uint64(uidNext); lo += SearchWindow {
+		hi := min(lo+SearchWindow, uint64(uidNext)) - 1
+		out = append(out, [2]uint32{uint32(lo), uint32(hi)})
+	}
+	return out
+}
+
+// searchWindows searches the selected mailbox in UID windows from minUID
+// up to UIDNEXT. A window whose SEARCH fails is read with FETCH (UID
+// INTERNALDATE FLAGS) and filtered here instead, so a server that cannot
+// search at all still yields the full UID list.
+func searchWindows(c *client.Client, since, before time.Time, minUID uint32, f FlagFilter) ([]uint32, error) {
+	uidNext, err := nextUID(c)
+	if err != nil {
+		return nil, err
+	}
+	var out []uint32
+	for _, w := range uidWindows(minUID, uidNext) {
+		uids, err := c.UidSearch(rangeCriteria(since, before, w[0], w[1], f))
+		if err != nil {
+			if c.State() == imap.LogoutState {
+				return nil, err
+			}
+			if uids, err = fetchWindow(c, since, before, w[0], w[1], f); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, uids...)
+	}
+	return out, nil
+}
+
+// nextUID returns the UIDNEXT of the selected mailbox, asking for the UID
+// of the last message when the server did not report it on SELECT.
+func nextUID(c *client.Client) (uint32, error) {
+	mbox := c.Mailbox()
+	if mbox == nil || mbox.Messages == 0 {
+		return 0, nil
+	}
+	if mbox.UidNext > 0 {
+		return mbox.UidNext, nil
+	}
+	seq := new(imap.SeqSet)
+	seq.AddNum(mbox.Messages)
+	ch := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() { done <- c.Fetch(seq, []imap.FetchItem{imap.FetchUid}, ch) }()
+	var last uint32
+	for m := range ch {
+		last = max(last, m.Uid)
+	}
+	if err := <-done; err != nil {
+		return 0, err
+	}
+	return last + 1, nil
+}
+
+// fetchWindow returns the UIDs lo..hi whose INTERNALDATE lies in
+// [since, before), comparing calendar days like SEARCH does, and whose
+// flags pass f.
+func fetchWindow(c *client.Client, since, before time.Time, lo, hi uint32, f FlagFilter) ([]uint32, error) {
+	seq := new(imap.SeqSet)
+	seq.AddRange(lo, hi)
+	ch := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchFlags}, ch)
+	}()
+	var out []uint32
+	for m := range ch {
+		if m.Uid >= lo && m.Uid <= hi && inDateRange(m.InternalDate, since, before) && f.match(m.Flags) {
+			out = append(out, m.Uid)
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// inDateRange reports whether the day of t (in its own zone) is within
+// [since, before); zero bounds are open.
+func inDateRange(t, since, before time.Time) bool {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if !since.IsZero() && day.Before(time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.UTC)) {
+		return false
+	}
+	if !before.IsZero() && !day.Before(time.Date(before.Year(), before.Month(), before.Day(), 0, 0, 0, 0, time.UTC)) {
+		return false
+	}
+	return true
+}
+
+// mailboxName names the selected mailbox in log messages.
+func mailboxName(mbox *imap.MailboxStatus) string {
+	if mbox == nil {
+		return "mailbox"
+	}
+	return mbox.Name
+}
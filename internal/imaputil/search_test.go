@@ -0,0 +1,171 @@
+package imaputil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestUIDWindows(t *testing.T) {
+	got := uidWindows(10, 2*SearchWindow+20)
+	want := [][2]uint32{{11, SearchWindow + 10}, {SearchWindow + 11, 2*SearchWindow + 10}, {2*SearchWindow + 11, 2*SearchWindow + 19}}
+	if len(got) != len(want) {
+		t.Fatalf("uidWindows = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("window %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if w := uidWindows(5, 6); len(w) != 0 {
+		t.Errorf("uidWindows(5, 6) = %v, want none", w)
+	}
+}
+
+// noSearchBackend rejects every SEARCH, like a server refusing a huge
+// result set.
+type noSearchBackend struct{ *memory.Backend }
+
+func (be noSearchBackend) Login(ci *imap.ConnInfo, username, password string) (backend.User, error) {
+	u, err := be.Backend.Login(ci, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return noSearchUser{u}, nil
+}
+
+type noSearchUser struct{ backend.User }
+
+func (u noSearchUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mb, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return noSearchMailbox{mb}, nil
+}
+
+type noSearchMailbox struct{ backend.Mailbox }
+
+func (noSearchMailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	return nil, errors.New("too many results")
+}
+
+func TestSearchFallback(t *testing.T) {
+	s := server.New(noSearchBackend{memory.New()})
+	s.AllowInsecureAuth = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+	c, err := client.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []time.Time{
+		time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC),
+	} {
+		msg := bytes.NewBufferString("Subject: x\r\n\r\nhi\r\n")
+		if err := c.Append("INBOX", nil, d, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, err := c.Select("INBOX", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := SearchUIDsRange(c, time.Time{}, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint32(len(all)) != status.Messages {
+		t.Fatalf("fallback found %d UIDs, want %d", len(all), status.Messages)
+	}
+	got, err := SearchUIDsRange(c, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != all[len(all)-2] {
+		t.Errorf("2022 = %v, want [%d]", got, all[len(all)-2])
+	}
+	got, err = SearchUIDsSince(c, time.Time{}, all[len(all)-2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != all[len(all)-1] {
+		t.Errorf("above UID %d = %v, want [%d]", all[len(all)-2], got, all[len(all)-1])
+	}
+}
+
+func TestSearchFlags(t *testing.T) {
+	for _, be := range []backend.Backend{memory.New(), noSearchBackend{memory.New()}} {
+		s := server.New(be)
+		s.AllowInsecureAuth = true
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Serve(l)
+		defer s.Close()
+		c, err := client.Dial(l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Login("username", "password"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Select("INBOX", true); err != nil {
+			t.Fatal(err)
+		}
+		before, err := SearchUIDsSince(c, time.Time{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		minUID := before[len(before)-1]
+		flags := [][]string{nil, {imap.SeenFlag}, {imap.FlaggedFlag}, {imap.SeenFlag, imap.DeletedFlag}}
+		for _, f := range flags {
+			if err := c.Append("INBOX", f, time.Now(), bytes.NewBufferString("Subject: x\r\n\r\nhi\r\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := c.Select("INBOX", true); err != nil {
+			t.Fatal(err)
+		}
+		all, err := SearchUIDsSince(c, time.Time{}, minUID)
+		if err != nil || len(all) != len(flags) {
+			t.Fatalf("SearchUIDsSince = %v, %v", all, err)
+		}
+		tests := []struct {
+			f    FlagFilter
+			want []uint32
+		}{
+			{FlagFilter{OnlyUnseen: true}, []uint32{all[0], all[2]}},
+			{FlagFilter{OnlyFlagged: true}, []uint32{all[2]}},
+			{FlagFilter{SkipDeleted: true}, all[:3]},
+			{FlagFilter{OnlyUnseen: true, OnlyFlagged: true}, []uint32{all[2]}},
+		}
+		for _, tt := range tests {
+			got, err := SearchUIDs(c, time.Time{}, time.Time{}, minUID, tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%T: SearchUIDs(%+v) = %v, want %v", be, tt.f, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package imaputil
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// MailboxSize returns the total RFC822.SIZE of the selected mailbox, which
+// holds messages messages. With sample above 0 and below messages only
+// that many messages, spread evenly over the mailbox, are fetched and the
+// total is extrapolated from them; exact reports whether all were fetched.
+func MailboxSize(c *client.Client, messages uint32, sample int) (size int64, exact bool, err error) {
+	if messages == 0 {
+		return 0, true, nil
+	}
+	seq := new(imap.SeqSet)
+	exact = sample <= 0 || uint32(sample) >= messages
+	if exact {
+		seq.AddRange(1, messages)
+	} else {
+		for i := 0; i < sample; i++ {
+			seq.AddNum(uint32(uint64(i)*uint64(messages)/uint64(sample)) + 1)
+		}
+	}
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Fetch(seq, []imap.FetchItem{imap.FetchRFC822Size}, msgs)
+	}()
+	n := 0
+	for msg := range msgs {
+		size += int64(msg.Size)
+		n++
+	}
+	if err := <-done; err != nil {
+		return 0, false, err
+	}
+	if !exact && n > 0 {
+		size = size * int64(messages) / int64(n)
+	}
+	return size, exact, nil
+}
+
+// SizesAbove returns the RFC822.SIZE of the messages of the selected
+// mailbox with a UID above minUID, by UID.
+func SizesAbove(c *client.Client, minUID uint32) (map[uint32]uint32, error) {
+	seq := new(imap.SeqSet)
+	seq.AddRange(minUID+1, 0)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchRFC822Size}, msgs)
+	}()
+	sizes := map[uint32]uint32{}
+	for msg := range msgs {
+		// "n:*" always matches the last message, even below n
+		if msg.Uid > minUID {
+			sizes[msg.Uid] = msg.Size
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
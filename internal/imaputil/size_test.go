@@ -0,0 +1,72 @@
+package imaputil
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestMailboxSize(t *testing.T) {
+	be := memory.New()
+	u, _ := be.Login(nil, "username", "password")
+	mb, _ := u.GetMailbox("INBOX")
+	inbox := mb.(*memory.Mailbox)
+	inbox.Messages = nil
+	body := "Subject: x\r\n\r\n" + strings.Repeat("x", 84) + "\r\n" // 100 bytes
+	for i := 0; i < 50; i++ {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+	c, err := client.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.Select("INBOX", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		sample int
+		exact  bool
+	}{{0, true}, {100, true}, {7, false}} {
+		size, exact, err := MailboxSize(c, status.Messages, tt.sample)
+		if err != nil || size != 5000 || exact != tt.exact {
+			t.Errorf("MailboxSize(sample %d) = %d, %v, %v; want 5000, %v", tt.sample, size, exact, err, tt.exact)
+		}
+	}
+	if size, exact, err := MailboxSize(c, 0, 10); size != 0 || !exact || err != nil {
+		t.Errorf("MailboxSize of an empty mailbox = %d, %v, %v", size, exact, err)
+	}
+	for _, tt := range []struct {
+		minUID uint32
+		want   int
+	}{{0, 50}, {40, 10}, {50, 0}, {90, 0}} {
+		sizes, err := SizesAbove(c, tt.minUID)
+		if err != nil || len(sizes) != tt.want {
+			t.Errorf("SizesAbove(%d) = %d message(s), %v; want %d", tt.minUID, len(sizes), err, tt.want)
+		}
+		for uid, size := range sizes {
+			if uid <= tt.minUID || size != 100 {
+				t.Errorf("SizesAbove(%d) has UID %d of %d bytes", tt.minUID, uid, size)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package imaputil
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// specialUseAttrs are the special-use attributes (RFC 6154) gomap acts
+// on, by their lower-case form. Gmail's XLIST says \Spam for \Junk.
+var specialUseAttrs = map[string]string{
+	`\drafts`: imap.DraftsAttr,
+	`\junk`:   imap.JunkAttr,
+	`\spam`:   imap.JunkAttr,
+	`\sent`:   imap.SentAttr,
+	`\trash`:  imap.TrashAttr,
+}
+
+// SpecialUse returns the mailboxes the server marks as Drafts, Junk, Sent
+// or Trash, mapped to that attribute (imap.DraftsAttr, ...). It asks with
+// LIST RETURN (SPECIAL-USE) if the server supports RFC 6154, with XLIST on
+// older servers that have it (Gmail), and reads the plain LIST attributes
+// otherwise. Servers that mark nothing give an empty map.
+func SpecialUse(c *client.Client) (map[string]string, error) {
+	caps, err := c.Capability()
+	if err != nil {
+		return nil, err
+	}
+	cmd := &imap.Command{Name: "LIST", Arguments: []interface{}{"", "*"}}
+	switch {
+	case caps["SPECIAL-USE"]:
+		cmd.Arguments = append(cmd.Arguments, imap.RawString("RETURN (SPECIAL-USE)"))
+	case caps["XLIST"]:
+		cmd.Name = "XLIST"
+	}
+	h := &lenientList{name: cmd.Name}
+	status, err := c.Execute(cmd, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	uses := map[string]string{}
+	for _, m := range h.mailboxes {
+		for _, a := range m.Attributes {
+			if attr, ok := specialUseAttrs[strings.ToLower(a)]; ok {
+				uses[m.Name] = attr
+			}
+		}
+	}
+	return uses, nil
+}
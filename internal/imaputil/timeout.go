@@ -0,0 +1,244 @@
+package imaputil
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeouts bound how long DialAndLogin and the connections it makes wait
+// for a server. A zero timeout is disabled.
+type Timeouts struct {
+	// Dial caps connecting: TCP, the TLS handshake, the server greeting
+	// and STARTTLS.
+	Dial time.Duration
+	// IO is the longest a server may stay silent while a command waits
+	// for its reply; then the connection is closed. Idle connections and
+	// IDLE are not affected.
+	IO time.Duration
+}
+
+// DefaultTimeouts apply when the context carries none.
+var DefaultTimeouts = Timeouts{Dial: 30 * time.Second, IO: 5 * time.Minute}
+
+type timeoutsKey struct{}
+
+// WithTimeouts returns ctx carrying t, for DialAndLogin deep down the
+// call chain.
+func WithTimeouts(ctx context.Context, t Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, t)
+}
+
+// timeoutsFrom returns the Timeouts of ctx, or DefaultTimeouts.
+func timeoutsFrom(ctx context.Context) Timeouts {
+	if t, ok := ctx.Value(timeoutsKey{}).(Timeouts); ok {
+		return t
+	}
+	return DefaultTimeouts
+}
+
+// negotiateTLS reads the plain-text greeting from conn, issues STARTTLS
+// and returns the TLS connection and the greeting line. go-imap's
+// StartTLS would put the TLS layer above any wrapper of conn, where
+// stallConn only sees ciphertext.
+func negotiateTLS(conn net.Conn, cfg *tls.Config) (*tls.Conn, string, error) {
+	r := bufio.NewReader(conn)
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return nil, "", fmt.Errorf("read greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return nil, "", fmt.Errorf("server greeting: %s", strings.TrimSpace(greeting))
+	}
+	if _, err := conn.Write([]byte("T1 STARTTLS\r\n")); err != nil {
+		return nil, "", err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, "", fmt.Errorf("STARTTLS: %w", err)
+		}
+		if strings.HasPrefix(line, "*") {
+			continue
+		}
+		if !strings.HasPrefix(line, "T1 OK") {
+			return nil, "", fmt.Errorf("STARTTLS: %s", strings.TrimSpace(strings.TrimPrefix(line, "T1 ")))
+		}
+		break
+	}
+	if r.Buffered() > 0 {
+		return nil, "", errors.New("STARTTLS: unexpected data before the TLS handshake")
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.Handshake(); err != nil {
+		return nil, "", err
+	}
+	return tc, greeting, nil
+}
+
+// prefixConn reads prefix before the data of Conn.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (p *prefixConn) Read(b []byte) (int, error) {
+	if len(p.prefix) > 0 {
+		n := copy(b, p.prefix)
+		p.prefix = p.prefix[n:]
+		return n, nil
+	}
+	return p.Conn.Read(b)
+}
+
+// stallConn fails reads once the server stays silent for longer than
+// timeout while a command waits for its reply, and writes the server does
+// not take within timeout. To tell waiting from idle it follows the IMAP
+// framing both ways: commands sent, tagged replies received, literals
+// skipped, and continuation requests that hand the turn to the client
+// (IDLE waits there for the client's DONE).
+type stallConn struct {
+	net.Conn
+	timeout time.Duration
+
+	mu      sync.Mutex
+	in, out framer
+	pending int  // commands sent without a tagged reply
+	turn    bool // the server asked for a continuation
+	reply   bool // the client is writing its continuation
+}
+
+// newStallConn returns conn with timeout applied, or conn for a zero
+// timeout.
+func newStallConn(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout <= 0 {
+		return conn
+	}
+	return &stallConn{Conn: conn, timeout: timeout}
+}
+
+func (c *stallConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.in.feed(p[:n], nil, func(first byte) {
+		switch first {
+		case '*':
+		case '+':
+			if !c.out.mid && c.out.literal == 0 {
+				c.turn = true
+			}
+		default:
+			c.pending = max(c.pending-1, 0)
+		}
+	})
+	c.arm()
+	c.mu.Unlock()
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		err = fmt.Errorf("no reply from the server for %s: %w", c.timeout, err)
+	}
+	return n, err
+}
+
+func (c *stallConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.out.feed(p, func(byte) {
+		if c.turn {
+			c.reply = true
+		} else {
+			c.pending++
+		}
+	}, func(byte) {
+		if c.reply {
+			c.reply, c.turn = false, false
+		}
+	})
+	c.arm()
+	c.mu.Unlock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
+
+// SetDeadline leaves the deadlines to c; go-imap clears them before each
+// command.
+func (c *stallConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+// arm sets the read deadline for the current state; the caller holds mu.
+func (c *stallConn) arm() {
+	if c.pending > 0 && !c.turn {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	} else {
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// framer splits one direction of an IMAP stream into lines. A line ending
+// in a literal ("{123}" or "{123+}") goes on after the literal's bytes,
+// which are skipped.
+type framer struct {
+	literal int64  // literal bytes still to skip
+	mid     bool   // inside a line
+	first   byte   // first byte of the current line
+	tail    []byte // the last bytes of the current line, for the literal
+}
+
+// feed scans p, calling start, if set, with the first byte of each line
+// and end with it at the end of each line.
+func (f *framer) feed(p []byte, start, end func(first byte)) {
+	for len(p) > 0 {
+		if f.literal > 0 {
+			n := min(int64(len(p)), f.literal)
+			f.literal -= n
+			p = p[n:]
+			continue
+		}
+		b := p[0]
+		p = p[1:]
+		if !f.mid {
+			f.mid, f.first, f.tail = true, b, f.tail[:0]
+			if start != nil {
+				start(b)
+			}
+		}
+		if b != '\n' {
+			if len(f.tail) == 32 {
+				f.tail = append(f.tail[:0], f.tail[1:]...)
+			}
+			f.tail = append(f.tail, b)
+			continue
+		}
+		if n, ok := literalSize(f.tail); ok {
+			f.literal, f.tail = n, f.tail[:0]
+			continue
+		}
+		f.mid = false
+		end(f.first)
+	}
+}
+
+// literalSize returns the size of the literal announced at the end of
+// line, without its line break.
+func literalSize(line []byte) (int64, bool) {
+	s := strings.TrimSuffix(string(line), "\r")
+	if !strings.HasSuffix(s, "}") {
+		return 0, false
+	}
+	i := strings.LastIndexByte(s, '{')
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(s[i+1:len(s)-1], "+"), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
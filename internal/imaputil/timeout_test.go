@@ -0,0 +1,92 @@
+package imaputil
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+func TestStallConn(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	srv, cli := net.Pipe()
+	defer srv.Close()
+	go func() {
+		r := bufio.NewReader(srv)
+		io.WriteString(srv, "* OK ready\r\n")
+		for _, reply := range []string{
+			"$ OK logged in",
+			"$ OK noop",
+			"* 1 EXISTS\r\n$ OK [READ-WRITE] selected",
+			// the literal holds a line that looks like a tagged reply;
+			// the real one never comes
+			"* 1 FETCH (UID 1 BODY[] {12}\r\nx OK\r\nbody\r\n)",
+		} {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			tag, cmd, _ := strings.Cut(line, " ")
+			for strings.HasPrefix(cmd, "CAPABILITY") {
+				io.WriteString(srv, "* CAPABILITY IMAP4rev1\r\n"+tag+" OK done\r\n")
+				if line, err = r.ReadString('\n'); err != nil {
+					return
+				}
+				tag, cmd, _ = strings.Cut(line, " ")
+			}
+			io.WriteString(srv, strings.ReplaceAll(reply, "$", tag)+"\r\n")
+		}
+		io.Copy(io.Discard, r)
+	}()
+	c, err := client.New(newStallConn(cli, timeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	// an idle connection outlives the timeout
+	time.Sleep(3 * timeout)
+	if err := c.Noop(); err != nil {
+		t.Fatalf("NOOP after idling: %v", err)
+	}
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatal(err)
+	}
+	seq := new(imap.SeqSet)
+	seq.AddNum(1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, []imap.FetchItem{imap.FetchUid, "BODY[]"}, make(chan *imap.Message, 10))
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("FETCH without a reply succeeded")
+		}
+	case <-time.After(20 * timeout):
+		t.Fatal("FETCH without a reply hangs")
+	}
+}
+
+func TestLiteralSize(t *testing.T) {
+	for line, want := range map[string]int64{
+		"* 1 FETCH (BODY[] {123}\r": 123,
+		"A1 APPEND INBOX {5+}\r":    5,
+		"A1 LOGIN user pass\r":      -1,
+		`* OK "{x}"` + "\r":         -1,
+	} {
+		n, ok := literalSize([]byte(line))
+		if !ok {
+			n = -1
+		}
+		if n != want {
+			t.Errorf("literalSize(%q) = %d, want %d", line, n, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package imaputil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// Verify says how closely VerifyAppended checks a copied message on the
+// destination (see copy --verify).
+type Verify int
+
+const (
+	VerifyOff    Verify = iota
+	VerifyExists        // the message is stored
+	VerifySize          // ... with the RFC822.SIZE it was sent with
+	VerifySHA256        // ... with the same content, fetched again
+)
+
+// ParseVerify parses a --verify level: "exists", "size" or "sha256", or
+// "" for none.
+func ParseVerify(s string) (Verify, error) {
+	switch s {
+	case "":
+		return VerifyOff, nil
+	case "exists":
+		return VerifyExists, nil
+	case "size":
+		return VerifySize, nil
+	case "sha256":
+		return VerifySHA256, nil
+	}
+	return VerifyOff, fmt.Errorf("invalid --verify: %s (must be 'exists', 'size' or 'sha256')", s)
+}
+
+// VerifyAppended checks that the message made of parts, just appended to
+// mailbox, is stored there as closely as v asks. uid is the UID from the
+// APPENDUID reply; without one, the message is looked up by its
+// Message-ID among the UIDs from minUID, the UIDNEXT before the append,
+// and any match that passes counts. A message with neither cannot be
+// found again; it is logged and passes.
+func VerifyAppended(c *client.Client, mailbox string, uid, minUID uint32, parts [][]byte, v Verify) error {
+	if v == VerifyOff {
+		return nil
+	}
+	uids := []uint32{uid}
+	if uid == 0 {
+		id := messageID(parts)
+		if id == "" {
+			log.Printf("[verify] %s: a message without Message-ID cannot be verified on a server without UIDPLUS", mailbox)
+			return nil
+		}
+		var err error
+		if uids, err = landedUIDs(c, mailbox, id, minUID); err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+		if len(uids) == 0 {
+			return fmt.Errorf("verify: %s is not stored in %s", id, mailbox)
+		}
+		if v == VerifyExists {
+			return nil
+		}
+	}
+
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchRFC822Size}
+	if v == VerifySHA256 {
+		items = append(items, section.FetchItem())
+	}
+	size := 0
+	sum := sha256.New()
+	for _, p := range parts {
+		size += len(p)
+		sum.Write(p)
+	}
+	want := sum.Sum(nil)
+
+	msgs := make(chan *imap.Message, len(uids))
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seq, items, msgs)
+	}()
+	var found, matched bool
+	var mismatch error
+	for msg := range msgs {
+		if msg == nil || matched {
+			continue
+		}
+		found = true
+		switch {
+		case int(msg.Size) != size:
+			mismatch = fmt.Errorf("verify: UID %d in %s has %d bytes, sent %d", msg.Uid, mailbox, msg.Size, size)
+		case v == VerifySHA256 && !bytes.Equal(checksum(msg.GetBody(section)), want):
+			mismatch = fmt.Errorf("verify: UID %d in %s differs from the message sent", msg.Uid, mailbox)
+		default:
+			matched = true
+		}
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	switch {
+	case matched:
+		return nil
+	case !found:
+		return fmt.Errorf("verify: UID %d is not stored in %s", uids[0], mailbox)
+	}
+	return mismatch
+}
+
+// checksum returns the SHA-256 of lit, which may be nil.
+func checksum(lit imap.Literal) []byte {
+	h := sha256.New()
+	if lit != nil {
+		_, _ = io.Copy(h, lit)
+	}
+	return h.Sum(nil)
+}
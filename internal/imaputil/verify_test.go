@@ -0,0 +1,65 @@
+package imaputil
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestVerifyAppended(t *testing.T) {
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+	c, err := client.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.Select("INBOX", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minUID := status.UidNext
+	sent := [][]byte{[]byte("X-A: 1\r\n"), []byte("Message-ID: <v@test>\r\nSubject: x\r\n\r\nhi\r\n")}
+	if err := Append(c, "INBOX", nil, time.Now(), sent...); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := [][]byte{[]byte("X-A: 2\r\n"), sent[1]}
+	for _, tc := range []struct {
+		parts [][]byte
+		v     Verify
+		ok    bool
+	}{
+		{sent, VerifyExists, true},
+		{sent, VerifySize, true},
+		{sent, VerifySHA256, true},
+		{changed, VerifySize, true}, // same size
+		{changed, VerifySHA256, false},
+		{[][]byte{sent[1]}, VerifySize, false},
+		{[][]byte{[]byte("Message-ID: <missing@test>\r\n\r\n")}, VerifyExists, false},
+		{[][]byte{[]byte("Subject: no id\r\n\r\n")}, VerifySHA256, true}, // cannot tell
+	} {
+		err := VerifyAppended(c, "INBOX", 0, minUID, tc.parts, tc.v)
+		if (err == nil) != tc.ok {
+			t.Errorf("VerifyAppended(%q, %d) = %v, want ok=%v", tc.parts, tc.v, err, tc.ok)
+		}
+	}
+	if err := VerifyAppended(c, "INBOX", minUID, 0, sent, VerifySHA256); err != nil {
+		t.Errorf("by UID: %v", err)
+	}
+	if err := VerifyAppended(c, "INBOX", minUID+5, 0, sent, VerifyExists); err == nil {
+		t.Error("expected an unknown UID to fail")
+	}
+}
@@ -0,0 +1,140 @@
+// Package lmtp is a small LMTP (RFC 2033) client for delivering messages
+// into a local mail store such as Dovecot.
+package lmtp
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// Client is one LMTP session. It is not safe for concurrent use.
+type Client struct {
+	conn net.Conn
+	text *textproto.Conn
+}
+
+// Dial connects to addr and greets the server. addr is either a Unix socket
+// path (absolute, or prefixed with "unix:") or host[:port]; the port
+// defaults to 24.
+func Dial(addr string) (*Client, error) {
+	network, address := "tcp", addr
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix:")
+	case strings.HasPrefix(addr, "/"):
+		network = "unix"
+	default:
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			address = net.JoinHostPort(addr, "24")
+		}
+	}
+	conn, err := net.DialTimeout(network, address, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("lmtp: %w", err)
+	}
+	c := &Client{conn: conn, text: textproto.NewConn(conn)}
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		c.text.Close()
+		return nil, fmt.Errorf("lmtp: greeting: %w", err)
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "localhost"
+	}
+	if _, _, err := c.cmd(250, "LHLO %s", host); err != nil {
+		c.text.Close()
+		return nil, fmt.Errorf("lmtp: LHLO: %w", err)
+	}
+	return c, nil
+}
+
+func (c *Client) cmd(expect int, format string, args ...any) (int, string, error) {
+	id, err := c.text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.text.StartResponse(id)
+	defer c.text.EndResponse(id)
+	return c.text.ReadResponse(expect)
+}
+
+// Deliver sends raw to rcpt with a null envelope sender, so that Sieve
+// vacation rules and the like do not answer migrated mail.
+func (c *Client) Deliver(rcpt string, raw []byte) error {
+	if _, _, err := c.cmd(250, "MAIL FROM:<>"); err != nil {
+		return fmt.Errorf("lmtp: MAIL FROM: %w", err)
+	}
+	if _, _, err := c.cmd(25, "RCPT TO:<%s>", rcpt); err != nil {
+		c.reset()
+		return fmt.Errorf("lmtp: RCPT TO %s: %w", rcpt, err)
+	}
+	if _, _, err := c.cmd(354, "DATA"); err != nil {
+		c.reset()
+		return fmt.Errorf("lmtp: DATA: %w", err)
+	}
+	w := c.text.DotWriter()
+	if _, err := w.Write(raw); err != nil {
+		w.Close()
+		return fmt.Errorf("lmtp: DATA: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("lmtp: DATA: %w", err)
+	}
+	// LMTP answers once per accepted recipient; there is exactly one.
+	if _, _, err := c.text.ReadResponse(250); err != nil {
+		return fmt.Errorf("lmtp: deliver to %s: %w", rcpt, err)
+	}
+	return nil
+}
+
+func (c *Client) reset() {
+	_, _, _ = c.cmd(250, "RSET")
+}
+
+// Close ends the session.
+func (c *Client) Close() error {
+	_, _, err := c.cmd(221, "QUIT")
+	if cerr := c.text.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Recipient returns the address that delivers to mailbox for user, using
+// Dovecot's detail syntax (user+Mailbox@domain with
+// lmtp_save_to_detail_mailbox enabled). INBOX, or an empty delimiter, yields
+// user unchanged.
+func Recipient(user, delim, mailbox string) string {
+	if delim == "" || mailbox == "" || strings.EqualFold(mailbox, "INBOX") {
+		return user
+	}
+	local, domain, hasDomain := strings.Cut(user, "@")
+	local = local + delim + mailbox
+	if needsQuote(local) {
+		local = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(local) + `"`
+	}
+	if !hasDomain {
+		return local
+	}
+	return local + "@" + domain
+}
+
+// needsQuote reports whether local is not a valid dot-atom (RFC 5321).
+func needsQuote(local string) bool {
+	if local == "" || strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || strings.Contains(local, "..") {
+		return true
+	}
+	for _, r := range local {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("!#$%&'*+-/=?^_`{|}~.", r):
+		default:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package lmtp
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestDeliver(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := textproto.NewReader(bufio.NewReader(conn))
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		lines := []string{}
+		reply("220 test LMTP ready")
+		for {
+			line, err := r.ReadLine()
+			if err != nil {
+				got <- lines
+				return
+			}
+			lines = append(lines, line)
+			switch {
+			case strings.HasPrefix(line, "LHLO"):
+				reply("250-test\r\n250 PIPELINING")
+			case strings.HasPrefix(line, "RCPT TO:<nobody"):
+				reply("550 5.1.1 User doesn't exist")
+			case line == "DATA":
+				reply("354 OK")
+				body, _ := r.ReadDotLines()
+				lines = append(lines, body...)
+				reply("250 2.0.0 Saved")
+			case line == "QUIT":
+				reply("221 Bye")
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	c, err := Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Deliver("nobody@example.com", []byte("Subject: x\n\nbody\n")); err == nil {
+		t.Fatal("expected an error for a rejected recipient")
+	}
+	if err := c.Deliver("me+Archive@example.com", []byte("Subject: hi\n\n.dot\nbody\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-got
+	want := []string{"MAIL FROM:<>", "RCPT TO:<nobody@example.com>", "RSET",
+		"MAIL FROM:<>", "RCPT TO:<me+Archive@example.com>", "DATA", "Subject: hi", "", ".dot", "body", "QUIT"}
+	if strings.Join(lines[1:], "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected session %q", lines)
+	}
+}
+
+func TestRecipient(t *testing.T) {
+	cases := []struct{ user, delim, box, want string }{
+		{"me@example.com", "+", "INBOX", "me@example.com"},
+		{"me@example.com", "+", "Archive", "me+Archive@example.com"},
+		{"me@example.com", "+", "Sent Items", `"me+Sent Items"@example.com`},
+		{"me", "+", "Lists/go", "me+Lists/go"},
+		{"me@example.com", "", "Archive", "me@example.com"},
+	}
+	for _, c := range cases {
+		if got := Recipient(c.user, c.delim, c.box); got != c.want {
+			t.Errorf("Recipient(%q, %q, %q) = %q, want %q", c.user, c.delim, c.box, got, c.want)
+		}
+	}
+}
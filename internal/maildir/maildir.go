@@ -0,0 +1,268 @@
+// Package maildir reads and writes Maildir++ trees as used by Dovecot and
+// Courier: INBOX lives in the root (cur/new/tmp) and every other folder is
+// a dot-prefixed directory next to it, with "." separating the hierarchy
+// levels (".Sent", ".Archive.2023").
+package maildir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// FolderDir returns the folder directory, relative to the Maildir++ root,
+// for an IMAP mailbox whose hierarchy delimiter is delim. INBOX maps to the
+// root itself (""). A leading "INBOX" level, as servers with a Courier-style
+// "INBOX." namespace report it, is dropped. Names are stored in modified
+// UTF-7 like Dovecot does; a "." or "/" inside a level cannot be
+// represented and becomes "_".
+func FolderDir(mailbox, delim string) string {
+	parts := []string{mailbox}
+	if delim != "" {
+		parts = strings.Split(mailbox, delim)
+	}
+	if strings.EqualFold(parts[0], "INBOX") {
+		parts = parts[1:]
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	enc := utf7.Encoding.NewEncoder()
+	for i, p := range parts {
+		if e, err := enc.String(p); err == nil {
+			p = e
+		}
+		parts[i] = strings.NewReplacer(".", "_", "/", "_").Replace(p)
+	}
+	return "." + strings.Join(parts, ".")
+}
+
+// MailboxName is the inverse of FolderDir: it turns a folder directory name
+// (".Archive.2023", or "" for the root) into an IMAP mailbox name using the
+// hierarchy delimiter delim.
+func MailboxName(dir, delim string) string {
+	dir = strings.TrimPrefix(dir, ".")
+	if dir == "" {
+		return "INBOX"
+	}
+	dec := utf7.Encoding.NewDecoder()
+	parts := strings.Split(dir, ".")
+	for i, p := range parts {
+		if d, err := dec.String(p); err == nil {
+			parts[i] = d
+		}
+	}
+	if delim == "" {
+		delim = "/"
+	}
+	return strings.Join(parts, delim)
+}
+
+// infoFlags maps the Maildir info letters to IMAP system flags. The
+// letters must stay in ASCII order, as the spec requires for file names.
+var infoFlags = []struct {
+	letter byte
+	flag   string
+}{
+	{'D', imap.DraftFlag},
+	{'F', imap.FlaggedFlag},
+	{'R', imap.AnsweredFlag},
+	{'S', imap.SeenFlag},
+	{'T', imap.DeletedFlag},
+}
+
+// Info returns the ":2," info suffix letters for IMAP flags. Keywords have
+// no portable representation and are dropped.
+func Info(flags []string) string {
+	var b strings.Builder
+	for _, f := range infoFlags {
+		for _, fl := range flags {
+			if strings.EqualFold(fl, f.flag) {
+				b.WriteByte(f.letter)
+				break
+			}
+		}
+	}
+	return b.String()
+}
+
+// Flags returns the IMAP flags encoded in the info part of a message file
+// name ("1700000000.x.host:2,RS").
+func Flags(name string) []string {
+	_, info, ok := strings.Cut(name, ":2,")
+	if !ok {
+		return nil
+	}
+	var flags []string
+	for _, f := range infoFlags {
+		if strings.IndexByte(info, f.letter) >= 0 {
+			flags = append(flags, f.flag)
+		}
+	}
+	return flags
+}
+
+// Create makes the cur, new and tmp directories of folder dir. Subfolders
+// (sub true) also get the "maildirfolder" marker file Maildir++ expects.
+func Create(dir string, sub bool) error {
+	for _, d := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o700); err != nil {
+			return err
+		}
+	}
+	if sub {
+		f, err := os.OpenFile(filepath.Join(dir, "maildirfolder"), os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+var deliveries atomic.Uint64
+
+// Deliver writes raw into folder dir the way Maildir requires: to tmp/
+// first, then renamed into cur/ with its flags in the file name. The
+// file's modification time is set to date, which readers use as the
+// internal date. uid, if non-zero, is embedded in the name so that UIDs
+// can later tell which messages are already present. It returns the path
+// of the new file.
+func Deliver(dir string, raw []byte, flags []string, date time.Time, uid uint32) (string, error) {
+	host, _ := os.Hostname()
+	host = strings.NewReplacer("/", "_", ":", "_").Replace(host)
+	if host == "" {
+		host = "localhost"
+	}
+	var uniq string
+	if uid != 0 {
+		uniq = fmt.Sprintf("%d.U%d.gomap-%s", date.Unix(), uid, host)
+	} else {
+		uniq = fmt.Sprintf("%d.M%dP%dQ%d.%s", time.Now().Unix(), time.Now().Nanosecond()/1000, os.Getpid(), deliveries.Add(1), host)
+	}
+	tmp := filepath.Join(dir, "tmp", uniq)
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return "", err
+	}
+	if !date.IsZero() {
+		_ = os.Chtimes(tmp, date, date)
+	}
+	dst := filepath.Join(dir, "cur", uniq+":2,"+Info(flags))
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dst, nil
+}
+
+var uidName = regexp.MustCompile(`^\d+\.U(\d+)\.gomap-`)
+
+// UIDs returns the UIDs of the messages in folder dir that were written by
+// Deliver with a UID.
+func UIDs(dir string) (map[uint32]bool, error) {
+	msgs, err := Messages(dir)
+	if err != nil {
+		return nil, err
+	}
+	uids := make(map[uint32]bool, len(msgs))
+	for _, m := range msgs {
+		if sm := uidName.FindStringSubmatch(filepath.Base(m.Path)); sm != nil {
+			if n, err := strconv.ParseUint(sm[1], 10, 32); err == nil {
+				uids[uint32(n)] = true
+			}
+		}
+	}
+	return uids, nil
+}
+
+// Folders returns the folder directory names below root: "" for the root
+// (INBOX) if it is a maildir, followed by the dot-prefixed subfolders in
+// sorted order.
+func Folders(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var folders, subs []string
+	if isMaildir(root) {
+		folders = append(folders, "")
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || len(name) < 2 || name[0] != '.' || name == ".." {
+			continue
+		}
+		if isMaildir(filepath.Join(root, name)) {
+			subs = append(subs, name)
+		}
+	}
+	sort.Strings(subs)
+	folders = append(folders, subs...)
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("%s is not a maildir (no cur/new directories)", root)
+	}
+	return folders, nil
+}
+
+func isMaildir(dir string) bool {
+	for _, d := range []string{"cur", "new"} {
+		if fi, err := os.Stat(filepath.Join(dir, d)); err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// Message is one message file of a folder.
+type Message struct {
+	Path  string
+	Flags []string
+	Date  time.Time // file modification time
+	Size  int64
+}
+
+// Messages lists the messages in new/ and cur/ of folder dir, oldest first.
+// Messages still in new/ have not been seen by a mail client and carry no
+// flags.
+func Messages(dir string) ([]Message, error) {
+	var msgs []Message
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				continue
+			}
+			m := Message{Path: filepath.Join(dir, sub, e.Name()), Date: fi.ModTime(), Size: fi.Size()}
+			if sub == "cur" {
+				m.Flags = Flags(e.Name())
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if !msgs[i].Date.Equal(msgs[j].Date) {
+			return msgs[i].Date.Before(msgs[j].Date)
+		}
+		return msgs[i].Path < msgs[j].Path
+	})
+	return msgs, nil
+}
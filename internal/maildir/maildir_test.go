@@ -0,0 +1,77 @@
+package maildir
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestFolderDir(t *testing.T) {
+	cases := []struct{ mailbox, delim, dir string }{
+		{"INBOX", "/", ""},
+		{"Sent", "/", ".Sent"},
+		{"Archive/2023", "/", ".Archive.2023"},
+		{"INBOX.Archive.2023", ".", ".Archive.2023"},
+		{"Entwürfe", "/", ".Entw&APw-rfe"},
+		{"v1.2/notes", "/", ".v1_2.notes"},
+	}
+	for _, c := range cases {
+		if got := FolderDir(c.mailbox, c.delim); got != c.dir {
+			t.Errorf("FolderDir(%q, %q) = %q, want %q", c.mailbox, c.delim, got, c.dir)
+		}
+	}
+	if got := MailboxName(".Entw&APw-rfe.2023", "/"); got != "Entwürfe/2023" {
+		t.Errorf("MailboxName = %q", got)
+	}
+	if got := MailboxName("", "."); got != "INBOX" {
+		t.Errorf("MailboxName root = %q", got)
+	}
+}
+
+func TestDeliverAndRead(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, ".Archive.2023")
+	if err := Create(root, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Create(sub, true); err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	p, err := Deliver(sub, []byte("Subject: x\r\n\r\nhi\r\n"), []string{imap.SeenFlag, imap.FlaggedFlag, "$Label1"}, date, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(p, ":2,FS") || filepath.Dir(p) != filepath.Join(sub, "cur") {
+		t.Errorf("unexpected name %s", p)
+	}
+	if _, err := os.Stat(filepath.Join(sub, "maildirfolder")); err != nil {
+		t.Error("missing maildirfolder marker")
+	}
+	folders, err := Folders(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(folders, []string{"", ".Archive.2023"}) {
+		t.Errorf("folders %q", folders)
+	}
+	msgs, err := Messages(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !msgs[0].Date.Equal(date) || !reflect.DeepEqual(msgs[0].Flags, []string{imap.FlaggedFlag, imap.SeenFlag}) {
+		t.Errorf("messages %+v", msgs)
+	}
+	uids, err := UIDs(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !uids[42] || len(uids) != 1 {
+		t.Errorf("uids %v", uids)
+	}
+}
@@ -0,0 +1,271 @@
+// Package mailparse turns an RFC822 message into a structured, JSON-friendly
+// view: decoded headers, the MIME tree, attachment metadata and text bodies.
+package mailparse
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Message is the parsed view of one message.
+type Message struct {
+	Headers     []Header     `json:"headers"`
+	From        []Address    `json:"from,omitempty"`
+	To          []Address    `json:"to,omitempty"`
+	Cc          []Address    `json:"cc,omitempty"`
+	Bcc         []Address    `json:"bcc,omitempty"`
+	ReplyTo     []Address    `json:"reply_to,omitempty"`
+	Subject     string       `json:"subject"`
+	Date        *time.Time   `json:"date,omitempty"`
+	MessageID   string       `json:"message_id,omitempty"`
+	InReplyTo   []string     `json:"in_reply_to,omitempty"`
+	References  []string     `json:"references,omitempty"`
+	Size        int          `json:"size"`
+	Body        Part         `json:"body"`
+	Text        string       `json:"text,omitempty"` // text/plain parts, UTF-8
+	HTML        string       `json:"html,omitempty"` // text/html parts, UTF-8
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Header is one header field in message order, with encoded words decoded.
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Address is one parsed mailbox of an address header.
+type Address struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+// Part is a node of the MIME tree. Path is the IMAP section number ("1",
+// "2.1"); the root of a multipart message has an empty path.
+type Part struct {
+	Path        string            `json:"path"`
+	ContentType string            `json:"content_type"`
+	Params      map[string]string `json:"params,omitempty"`
+	Disposition string            `json:"disposition,omitempty"`
+	Filename    string            `json:"filename,omitempty"`
+	ContentID   string            `json:"content_id,omitempty"`
+	Encoding    string            `json:"encoding,omitempty"`
+	Size        int               `json:"size"` // decoded bytes; 0 for multiparts
+	Parts       []Part            `json:"parts,omitempty"`
+}
+
+// Attachment describes a leaf part that is not a message body.
+type Attachment struct {
+	Path        string `json:"path"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Inline      bool   `json:"inline,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+const maxDepth = 20
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// DecodeHeader decodes RFC 2047 encoded words; undecodable input is
+// returned unchanged.
+func DecodeHeader(v string) string {
+	if d, err := wordDecoder.DecodeHeader(v); err == nil {
+		return d
+	}
+	return v
+}
+
+// Parse parses raw. Malformed MIME structure below the top-level header
+// is tolerated: the affected parts are reported with what could be read.
+func Parse(raw []byte) (*Message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	h := msg.Header
+	m := &Message{
+		Headers:     orderedHeaders(raw),
+		From:        addressList(h, "From"),
+		To:          addressList(h, "To"),
+		Cc:          addressList(h, "Cc"),
+		Bcc:         addressList(h, "Bcc"),
+		ReplyTo:     addressList(h, "Reply-To"),
+		Subject:     DecodeHeader(h.Get("Subject")),
+		MessageID:   strings.TrimSpace(h.Get("Message-Id")),
+		InReplyTo:   msgIDRe.FindAllString(h.Get("In-Reply-To"), -1),
+		References:  msgIDRe.FindAllString(h.Get("References"), -1),
+		Size:        len(raw),
+		Attachments: []Attachment{},
+	}
+	if t, err := h.Date(); err == nil {
+		m.Date = &t
+	}
+	var plain, html []string
+	m.Body = m.walk(h, msg.Body, "", 0, &plain, &html)
+	m.Text = strings.Join(plain, "\n")
+	m.HTML = strings.Join(html, "\n")
+	return m, nil
+}
+
+var msgIDRe = regexp.MustCompile(`<[^<>\s]+>`)
+
+// orderedHeaders returns the top-level header fields in their original
+// order (net/mail keeps them in a map).
+func orderedHeaders(raw []byte) []Header {
+	end := bytes.Index(raw, []byte("\n\n"))
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 && (end < 0 || i < end) {
+		end = i
+	}
+	if end < 0 {
+		end = len(raw)
+	}
+	headers := []Header{}
+	for _, line := range strings.Split(strings.ReplaceAll(string(raw[:end]), "\r\n", "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		headers = append(headers, Header{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+	}
+	for i := range headers {
+		headers[i].Value = DecodeHeader(headers[i].Value)
+	}
+	return headers
+}
+
+var addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+
+func addressList(h mail.Header, key string) []Address {
+	v := h.Get(key)
+	if v == "" {
+		return nil
+	}
+	list, err := addressParser.ParseList(v)
+	if err != nil {
+		return nil
+	}
+	out := make([]Address, 0, len(list))
+	for _, a := range list {
+		out = append(out, Address{Name: a.Name, Address: a.Address})
+	}
+	return out
+}
+
+// partHeader is satisfied by both mail.Header and textproto.MIMEHeader.
+type partHeader interface {
+	Get(key string) string
+}
+
+func (m *Message) walk(h partHeader, body io.Reader, path string, depth int, plain, html *[]string) Part {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	p := Part{ContentType: mediaType, Params: params, ContentID: strings.Trim(h.Get("Content-Id"), "<> ")}
+	if len(p.Params) == 0 {
+		p.Params = nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxDepth {
+		p.Path = path
+		mr := multipart.NewReader(body, params["boundary"])
+		for i := 1; ; i++ {
+			child, err := mr.NextRawPart()
+			if err != nil {
+				break
+			}
+			childPath := strconv.Itoa(i)
+			if path != "" {
+				childPath = path + "." + childPath
+			}
+			p.Parts = append(p.Parts, m.walk(child.Header, child, childPath, depth+1, plain, html))
+		}
+		return p
+	}
+	if path == "" {
+		path = "1" // the body of a single-part message
+	}
+	p.Path = path
+	p.Encoding = strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding")))
+	switch p.Encoding {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, _ := io.ReadAll(body)
+	p.Size = len(data)
+
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	p.Disposition = disposition
+	p.Filename = dparams["filename"]
+	if p.Filename == "" {
+		p.Filename = params["name"]
+	}
+	p.Filename = DecodeHeader(p.Filename)
+
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+	if isText && disposition != "attachment" && p.Filename == "" {
+		text := decodeCharset(data, params["charset"])
+		if mediaType == "text/plain" {
+			*plain = append(*plain, text)
+		} else {
+			*html = append(*html, text)
+		}
+		return p
+	}
+	m.Attachments = append(m.Attachments, Attachment{
+		Path:        p.Path,
+		Filename:    p.Filename,
+		ContentType: mediaType,
+		Size:        p.Size,
+		Inline:      disposition == "inline",
+		ContentID:   p.ContentID,
+	})
+	return p
+}
+
+// decodeCharset converts data to UTF-8. Unknown charsets are passed
+// through as-is.
+func decodeCharset(data []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return string(data)
+	}
+	r, err := charsetReader(charset, bytes.NewReader(data))
+	if err != nil {
+		return string(data)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return string(data)
+	}
+	return string(b)
+}
@@ -0,0 +1,84 @@
+package mailparse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sample = "From: =?ISO-8859-1?Q?J=F6rg?= <joerg@example.com>\r\n" +
+	"To: a@example.com, \"B, Bee\" <b@example.com>\r\n" +
+	"Subject: =?UTF-8?B?w5xiZXJzaWNodA==?=\r\n" +
+	"Message-ID: <m1@example.com>\r\n" +
+	"References: <r1@example.com>\r\n <r2@example.com>\r\n" +
+	"Date: Mon, 2 Jan 2023 10:00:00 +0000\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Gr=FC=DFe\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Gr\xc3\xbc\xc3\x9fe</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"report.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename*=UTF-8''Bericht%20%C3%BC.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0x\r\nLjQK\r\n" +
+	"--outer--\r\n"
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Übersicht" {
+		t.Errorf("subject %q", m.Subject)
+	}
+	if len(m.From) != 1 || m.From[0].Name != "Jörg" || m.From[0].Address != "joerg@example.com" {
+		t.Errorf("from %+v", m.From)
+	}
+	if len(m.To) != 2 || m.To[1].Name != "B, Bee" {
+		t.Errorf("to %+v", m.To)
+	}
+	if !reflect.DeepEqual(m.References, []string{"<r1@example.com>", "<r2@example.com>"}) {
+		t.Errorf("references %q", m.References)
+	}
+	if m.Headers[0].Name != "From" || m.Headers[4].Value != "<r1@example.com> <r2@example.com>" {
+		t.Errorf("headers %+v", m.Headers)
+	}
+	if m.Date == nil || m.Date.Year() != 2023 {
+		t.Errorf("date %v", m.Date)
+	}
+	if strings.TrimSpace(m.Text) != "Grüße" {
+		t.Errorf("text %q", m.Text)
+	}
+	if !strings.Contains(m.HTML, "<p>Grüße</p>") {
+		t.Errorf("html %q", m.HTML)
+	}
+	if m.Body.Path != "" || len(m.Body.Parts) != 2 || m.Body.Parts[0].Parts[1].Path != "1.2" {
+		t.Errorf("tree %+v", m.Body)
+	}
+	want := []Attachment{{Path: "2", Filename: "Bericht ü.pdf", ContentType: "application/pdf", Size: 9}}
+	if !reflect.DeepEqual(m.Attachments, want) {
+		t.Errorf("attachments %+v", m.Attachments)
+	}
+}
+
+func TestParseSinglePart(t *testing.T) {
+	m, err := Parse([]byte("Subject: hi\n\nbody\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Body.Path != "1" || m.Body.ContentType != "text/plain" || m.Text != "body\n" {
+		t.Errorf("unexpected %+v", m)
+	}
+}
@@ -0,0 +1,201 @@
+// Package mailstore keeps raw messages and their parsed headers in a SQLite
+// database with an FTS5 full-text index over subject, addresses and body.
+package mailstore
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go driver, keeps CGO_ENABLED=0 builds working
+
+	"github.com/pepperpark/gomap/internal/mailparse"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id            INTEGER PRIMARY KEY,
+	mailbox       TEXT    NOT NULL,
+	uid           INTEGER NOT NULL,
+	internal_date INTEGER NOT NULL,
+	message_id    TEXT    NOT NULL DEFAULT '',
+	sender        TEXT    NOT NULL DEFAULT '',
+	recipients    TEXT    NOT NULL DEFAULT '',
+	subject       TEXT    NOT NULL DEFAULT '',
+	date          TEXT    NOT NULL DEFAULT '',
+	size          INTEGER NOT NULL,
+	raw           BLOB    NOT NULL,
+	UNIQUE (mailbox, uid)
+);
+CREATE INDEX IF NOT EXISTS messages_message_id ON messages (message_id);
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5 (subject, sender, recipients, body);
+`
+
+// Store is an open mail database.
+type Store struct {
+	db *sql.DB
+}
+
+// Message is a message to be stored.
+type Message struct {
+	Mailbox      string
+	UID          uint32
+	InternalDate time.Time
+	Raw          []byte
+}
+
+// Hit is a search result.
+type Hit struct {
+	Mailbox      string
+	UID          uint32
+	InternalDate time.Time
+	From         string
+	Subject      string
+	Snippet      string
+}
+
+// Open opens (or creates) the database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection avoids SQLITE_BUSY between our own writers.
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000", schema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("init %s: %w", path, err)
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error { return s.db.Close() }
+
+// Has reports whether the message with the given mailbox and UID is stored.
+func (s *Store) Has(mailbox string, uid uint32) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE mailbox = ? AND uid = ?`, mailbox, uid).Scan(&n)
+	return n > 0, err
+}
+
+// Add stores m, indexes it and reports whether it was new. Messages that are
+// already stored (same mailbox and UID) are left untouched.
+func (s *Store) Add(m Message) (bool, error) {
+	h := parseHeaders(m.Raw)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO messages (mailbox, uid, internal_date, message_id, sender, recipients, subject, date, size, raw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (mailbox, uid) DO NOTHING`,
+		m.Mailbox, m.UID, m.InternalDate.Unix(), h.messageID, h.from, h.to, h.subject, h.date, len(m.Raw), m.Raw)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO messages_fts (rowid, subject, sender, recipients, body) VALUES (?, ?, ?, ?, ?)`,
+		id, h.subject, h.from, h.to, bodyText(m.Raw)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Search runs an FTS5 query and returns the best matches first. An empty
+// mailbox searches all mailboxes.
+func (s *Store) Search(query, mailbox string, limit int) ([]Hit, error) {
+	q := `SELECT m.mailbox, m.uid, m.internal_date, m.sender, m.subject,
+			snippet(messages_fts, 3, '[', ']', '...', 12)
+		FROM messages_fts JOIN messages m ON m.id = messages_fts.rowid
+		WHERE messages_fts MATCH ?`
+	args := []any{query}
+	if mailbox != "" {
+		q += ` AND m.mailbox = ?`
+		args = append(args, mailbox)
+	}
+	q += ` ORDER BY rank`
+	if limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hits := []Hit{}
+	for rows.Next() {
+		var h Hit
+		var ts int64
+		if err := rows.Scan(&h.Mailbox, &h.UID, &ts, &h.From, &h.Subject, &h.Snippet); err != nil {
+			return nil, err
+		}
+		h.InternalDate = time.Unix(ts, 0)
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// Raw returns the stored message.
+func (s *Store) Raw(mailbox string, uid uint32) ([]byte, error) {
+	var raw []byte
+	err := s.db.QueryRow(`SELECT raw FROM messages WHERE mailbox = ? AND uid = ?`, mailbox, uid).Scan(&raw)
+	return raw, err
+}
+
+type headers struct {
+	messageID, from, to, subject, date string
+}
+
+func decodeHeader(v string) string {
+	return mailparse.DecodeHeader(v)
+}
+
+func parseHeaders(raw []byte) headers {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return headers{}
+	}
+	h := msg.Header
+	to := []string{}
+	for _, k := range []string{"To", "Cc"} {
+		if v := h.Get(k); v != "" {
+			to = append(to, decodeHeader(v))
+		}
+	}
+	return headers{
+		messageID: strings.TrimSpace(h.Get("Message-Id")),
+		from:      decodeHeader(h.Get("From")),
+		to:        strings.Join(to, ", "),
+		subject:   decodeHeader(h.Get("Subject")),
+		date:      h.Get("Date"),
+	}
+}
+
+// bodyText extracts the searchable text of a message: all text/plain parts,
+// or the tag-stripped text/html parts when there is no plain text.
+func bodyText(raw []byte) string {
+	m, err := mailparse.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if m.Text != "" {
+		return m.Text
+	}
+	return htmlTagRe.ReplaceAllString(m.HTML, " ")
+}
+
+var htmlTagRe = regexp.MustCompile(`(?s)<[^>]*>`)
@@ -0,0 +1,57 @@
+package mailstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const multipartMsg = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: =?UTF-8?Q?Quarterly_r=C3=A9port?=\r\n" +
+	"Content-Type: multipart/alternative; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"The numbers look=20good, see the spreadsheet.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>The numbers look good</p>\r\n" +
+	"--b1--\r\n"
+
+func TestStoreAddSearch(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "mail.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	m := Message{Mailbox: "INBOX", UID: 7, InternalDate: time.Unix(1700000000, 0), Raw: []byte(multipartMsg)}
+	if added, err := s.Add(m); err != nil || !added {
+		t.Fatalf("add: added=%v err=%v", added, err)
+	}
+	if added, err := s.Add(m); err != nil || added {
+		t.Fatalf("second add: added=%v err=%v", added, err)
+	}
+	if ok, err := s.Has("INBOX", 7); err != nil || !ok {
+		t.Fatalf("has: %v %v", ok, err)
+	}
+
+	hits, err := s.Search("spreadsheet", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].UID != 7 || hits[0].Subject != "Quarterly réport" {
+		t.Fatalf("unexpected hits %+v", hits)
+	}
+	if hits, _ := s.Search("sender:alice", "Archive", 10); len(hits) != 0 {
+		t.Fatalf("mailbox filter ignored: %+v", hits)
+	}
+	raw, err := s.Raw("INBOX", 7)
+	if err != nil || string(raw) != multipartMsg {
+		t.Fatalf("raw roundtrip failed: %v", err)
+	}
+}
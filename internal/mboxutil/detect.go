@@ -0,0 +1,115 @@
+package mboxutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// FormatAuto asks for the variant to be detected from the file, see Detect.
+const FormatAuto Format = "auto"
+
+// detectSample is how much of a file Detect looks at.
+const detectSample = 16 << 20
+
+// DetectFile reads the start of the mbox file at path (plain or gzip) and
+// returns its variant.
+func DetectFile(path string) (Format, error) {
+	f, err := Open(path, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, detectSample))
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", path, err)
+	}
+	return Detect(data), nil
+}
+
+// Detect guesses the variant of the mbox data (the start of a file is
+// enough):
+//
+//   - If messages carry Content-Length headers and every one of them ends
+//     exactly at a From_ line (or the end of the data), the file is mboxcl
+//     when bodies contain ">From " but no unquoted "From " lines, mboxcl2
+//     otherwise.
+//   - Else the file is From_-delimited and read as mboxrd. mboxo cannot be
+//     told apart from the data; the two only read differently for body
+//     lines with several '>' before "From ", which are rare.
+func Detect(data []byte) Format {
+	var clSeen, clQuoted, clUnquoted bool
+	clOK := true
+	pos := skipBlank(data, 0)
+	for pos < len(data) && clOK {
+		if !isFromLine(data[pos:]) {
+			break
+		}
+		pos = nextLine(data, pos)
+		// header block
+		contentLength := int64(-1)
+		for pos < len(data) {
+			end := nextLine(data, pos)
+			line := data[pos:end]
+			pos = end
+			if isBlank(line) {
+				break
+			}
+			if v, ok := headerValue(line, "content-length"); ok {
+				if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+					contentLength = n
+				}
+			}
+		}
+		if contentLength < 0 {
+			// From_-delimited message: skip to the next From_ line
+			for pos < len(data) && !isFromLine(data[pos:]) {
+				pos = nextLine(data, pos)
+			}
+			continue
+		}
+		clSeen = true
+		if int64(len(data)-pos) < contentLength {
+			break // truncated sample
+		}
+		body := data[pos : pos+int(contentLength)]
+		if bytes.HasPrefix(body, []byte("From ")) || bytes.Contains(body, []byte("\nFrom ")) {
+			clUnquoted = true
+		}
+		if bytes.HasPrefix(body, []byte(">From ")) || bytes.Contains(body, []byte("\n>From ")) {
+			clQuoted = true
+		}
+		pos = skipBlank(data, pos+int(contentLength))
+		if pos < len(data) && !isFromLine(data[pos:]) {
+			clOK = false
+		}
+	}
+	switch {
+	case clSeen && clOK && clQuoted && !clUnquoted:
+		return FormatMboxcl
+	case clSeen && clOK:
+		return FormatMboxcl2
+	}
+	return FormatMboxrd
+}
+
+// nextLine returns the offset after the line starting at pos.
+func nextLine(data []byte, pos int) int {
+	if i := bytes.IndexByte(data[pos:], '\n'); i >= 0 {
+		return pos + i + 1
+	}
+	return len(data)
+}
+
+// skipBlank returns the offset of the first non-blank line at or after pos.
+func skipBlank(data []byte, pos int) int {
+	for pos < len(data) {
+		end := nextLine(data, pos)
+		if !isBlank(data[pos:end]) {
+			return pos
+		}
+		pos = end
+	}
+	return pos
+}
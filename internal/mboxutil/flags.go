@@ -0,0 +1,190 @@
+package mboxutil
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// Flags are kept in mbox files the way Dovecot and mutt do it: "Status: RO"
+// for \Seen (R) and not \Recent (O), "X-Status" for \Answered (A),
+// \Flagged (F), \Draft (T) and \Deleted (D), and keywords separated by
+// spaces in "X-Keywords".
+var (
+	statusFlags  = []letterFlag{{'R', imap.SeenFlag}}
+	xStatusFlags = []letterFlag{{'A', imap.AnsweredFlag}, {'F', imap.FlaggedFlag}, {'T', imap.DraftFlag}, {'D', imap.DeletedFlag}}
+)
+
+type letterFlag struct {
+	letter byte
+	flag   string
+}
+
+var flagHeaders = []string{"Status", "X-Status", "X-Keywords"}
+
+// SetFlags returns raw with its Status, X-Status and X-Keywords headers
+// replaced by ones encoding flags. \Recent is dropped.
+func SetFlags(raw []byte, flags []string) []byte {
+	head, body := splitHeader(raw)
+	eol := "\n"
+	if bytes.Contains(head, []byte("\r\n")) || (len(head) == 0 && bytes.HasPrefix(body, []byte("\r\n"))) {
+		eol = "\r\n"
+	}
+	var b bytes.Buffer
+	b.Grow(len(raw) + 64)
+	b.Write(stripHeaders(head, flagHeaders))
+	status, xStatus := "", ""
+	var keywords []string
+	for _, f := range flags {
+		switch {
+		case strings.EqualFold(f, imap.RecentFlag):
+		case letterOf(statusFlags, f) != 0:
+			status += string(letterOf(statusFlags, f))
+		case letterOf(xStatusFlags, f) != 0:
+			xStatus += string(letterOf(xStatusFlags, f))
+		case !strings.HasPrefix(f, "\\"):
+			keywords = append(keywords, f)
+		}
+	}
+	b.WriteString("Status: " + status + "O" + eol)
+	if xStatus != "" {
+		b.WriteString("X-Status: " + xStatus + eol)
+	}
+	if len(keywords) > 0 {
+		b.WriteString("X-Keywords: " + strings.Join(keywords, " ") + eol)
+	}
+	b.Write(body)
+	return b.Bytes()
+}
+
+// ParseFlags reads the flags kept in the headers of an mbox message: the
+// Status/X-Status/X-Keywords headers, or Thunderbird's X-Mozilla-Status.
+// ok is false when the message has none of them. The returned message has
+// the Status, X-Status and X-Keywords headers removed, as they only
+// describe the mbox copy.
+func ParseFlags(raw []byte) (msg []byte, flags []string, ok bool) {
+	head, body := splitHeader(raw)
+	flags = []string{}
+	var mozilla []string
+	for _, line := range headerLines(head) {
+		if v, found := headerValue(line, "Status"); found {
+			ok = true
+			flags = appendLetters(flags, statusFlags, v)
+		} else if v, found := headerValue(line, "X-Status"); found {
+			ok = true
+			flags = appendLetters(flags, xStatusFlags, v)
+		} else if v, found := headerValue(line, "X-Keywords"); found {
+			ok = true
+			for _, k := range strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' || r == '\t' || r == '\r' || r == '\n' }) {
+				flags = appendFlag(flags, k)
+			}
+		} else if v, found := headerValue(line, "X-Mozilla-Status"); found {
+			if bits, err := strconv.ParseUint(v, 16, 16); err == nil {
+				for _, mf := range []struct {
+					bit  uint64
+					flag string
+				}{{0x1, imap.SeenFlag}, {0x2, imap.AnsweredFlag}, {0x4, imap.FlaggedFlag}, {0x8, imap.DeletedFlag}} {
+					if bits&mf.bit != 0 {
+						mozilla = append(mozilla, mf.flag)
+					}
+				}
+				if bits == 0 {
+					mozilla = []string{}
+				}
+			}
+		}
+	}
+	if !ok {
+		if mozilla == nil {
+			return raw, nil, false
+		}
+		return raw, mozilla, true
+	}
+	return append(stripHeaders(head, flagHeaders), body...), flags, true
+}
+
+func letterOf(table []letterFlag, flag string) byte {
+	for _, lf := range table {
+		if strings.EqualFold(lf.flag, flag) {
+			return lf.letter
+		}
+	}
+	return 0
+}
+
+func appendLetters(flags []string, table []letterFlag, v string) []string {
+	for _, lf := range table {
+		if strings.IndexByte(v, lf.letter) >= 0 {
+			flags = appendFlag(flags, lf.flag)
+		}
+	}
+	return flags
+}
+
+func appendFlag(flags []string, flag string) []string {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return flags
+		}
+	}
+	return append(flags, flag)
+}
+
+// splitHeader splits raw after the header section: head holds the header
+// lines, body starts with the blank separator line.
+func splitHeader(raw []byte) (head, body []byte) {
+	for i := 0; i < len(raw); {
+		j := bytes.IndexByte(raw[i:], '\n')
+		if j < 0 {
+			return raw, nil
+		}
+		if isBlank(raw[i : i+j+1]) {
+			return raw[:i], raw[i:]
+		}
+		i += j + 1
+	}
+	return raw, nil
+}
+
+// headerLines splits a header section into fields, keeping folded
+// continuation lines with their field.
+func headerLines(head []byte) [][]byte {
+	var lines [][]byte
+	start := 0
+	for i := 0; i < len(head); {
+		j := bytes.IndexByte(head[i:], '\n')
+		next := len(head)
+		if j >= 0 {
+			next = i + j + 1
+		}
+		if i > 0 && head[i] != ' ' && head[i] != '\t' {
+			lines = append(lines, head[start:i])
+			start = i
+		}
+		i = next
+	}
+	if start < len(head) {
+		lines = append(lines, head[start:])
+	}
+	return lines
+}
+
+// stripHeaders returns a copy of head without the named fields.
+func stripHeaders(head []byte, names []string) []byte {
+	out := make([]byte, 0, len(head))
+	for _, line := range headerLines(head) {
+		drop := false
+		for _, n := range names {
+			if _, found := headerValue(line, n); found {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
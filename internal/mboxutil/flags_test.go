@@ -0,0 +1,38 @@
+package mboxutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestFlagsRoundTrip(t *testing.T) {
+	raw := "Subject: hi\r\nStatus: O\r\nX-Keywords: old\r\n  folded\r\nTo: a@example.org\r\n\r\nStatus: body line\r\n"
+	flags := []string{imap.SeenFlag, imap.FlaggedFlag, imap.RecentFlag, "$Label1"}
+	set := string(SetFlags([]byte(raw), flags))
+	want := "Subject: hi\r\nTo: a@example.org\r\nStatus: RO\r\nX-Status: F\r\nX-Keywords: $Label1\r\n\r\nStatus: body line\r\n"
+	if set != want {
+		t.Fatalf("SetFlags:\ngot  %q\nwant %q", set, want)
+	}
+	msg, got, ok := ParseFlags([]byte(set))
+	if !ok || !reflect.DeepEqual(got, []string{imap.SeenFlag, imap.FlaggedFlag, "$Label1"}) {
+		t.Fatalf("ParseFlags = %v, %v", got, ok)
+	}
+	if string(msg) != "Subject: hi\r\nTo: a@example.org\r\n\r\nStatus: body line\r\n" {
+		t.Fatalf("flag headers not stripped: %q", msg)
+	}
+	if _, got, ok := ParseFlags(SetFlags([]byte("Subject: x\n\nbody\n"), []string{})); !ok || len(got) != 0 {
+		t.Fatalf("unread message: got %v, %v", got, ok)
+	}
+}
+
+func TestParseFlagsMozilla(t *testing.T) {
+	_, got, ok := ParseFlags([]byte("X-Mozilla-Status: 0005\r\nSubject: x\r\n\r\n"))
+	if !ok || !reflect.DeepEqual(got, []string{imap.SeenFlag, imap.FlaggedFlag}) {
+		t.Fatalf("got %v, %v", got, ok)
+	}
+	if _, _, ok := ParseFlags([]byte("Subject: x\r\n\r\nbody\r\n")); ok {
+		t.Fatal("expected no flags without flag headers")
+	}
+}
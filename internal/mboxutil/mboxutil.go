@@ -0,0 +1,285 @@
+// Package mboxutil reads mbox archives in the common format variants
+// (mboxo, mboxrd, mboxcl, mboxcl2) while tracking exact byte offsets, which
+// the copy command uses to resume.
+package mboxutil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is an mbox variant.
+type Format string
+
+const (
+	// FormatMboxo quotes body lines starting with "From " as ">From ".
+	FormatMboxo Format = "mboxo"
+	// FormatMboxrd quotes lines matching ^>*From with one extra '>'.
+	FormatMboxrd Format = "mboxrd"
+	// FormatMboxcl is mboxo quoting plus a Content-Length header that
+	// delimits the body.
+	FormatMboxcl Format = "mboxcl"
+	// FormatMboxcl2 relies on Content-Length only; bodies are not quoted.
+	FormatMboxcl2 Format = "mboxcl2"
+)
+
+var (
+	// ErrInvalidFormat is returned when the data does not start with a From_
+	// separator line where one is expected.
+	ErrInvalidFormat = errors.New("invalid mbox format")
+	// ErrTooLarge is returned for messages above the size set with
+	// SetMaxSize.
+	ErrTooLarge = errors.New("message too large")
+)
+
+// CorruptError reports an mbox entry that could not be read. The Reader
+// has skipped the entry, so reading can go on with the next message.
+type CorruptError struct {
+	Offset int64 // where the entry starts, relative to the start of the reader
+	Size   int64 // bytes skipped
+	Err    error // ErrInvalidFormat or ErrTooLarge
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%v at offset %d (%d bytes skipped)", e.Err, e.Offset, e.Size)
+}
+
+func (e *CorruptError) Unwrap() error { return e.Err }
+
+// ParseFormat validates a format name. "auto" is accepted too; resolve it
+// per file with DetectFile before creating a Reader.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatAuto, FormatMboxo, FormatMboxrd, FormatMboxcl, FormatMboxcl2:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown mbox format %q (expected auto, mboxo, mboxrd, mboxcl or mboxcl2)", s)
+}
+
+func (f Format) usesContentLength() bool {
+	return f == FormatMboxcl || f == FormatMboxcl2
+}
+
+// Reader splits an mbox stream into messages.
+type Reader struct {
+	br      *bufio.Reader
+	format  Format
+	off     int64  // bytes consumed from the underlying reader
+	pending []byte // From_ line of the next message, already consumed
+	maxSize int64  // 0 = unlimited
+}
+
+// NewReader returns a Reader for the given variant.
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{br: bufio.NewReaderSize(r, 64*1024), format: format}
+}
+
+// SetMaxSize makes NextMessage skip messages larger than n bytes (after
+// line ending conversion) with ErrTooLarge instead of buffering them;
+// 0 removes the limit.
+func (r *Reader) SetMaxSize(n int64) {
+	r.maxSize = n
+}
+
+// Offset returns the number of bytes of the input that belong to messages
+// returned so far, i.e. the position at which the next message starts.
+func (r *Reader) Offset() int64 {
+	return r.off - int64(len(r.pending))
+}
+
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.br.ReadBytes('\n')
+	r.off += int64(len(line))
+	return line, err
+}
+
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("From "))
+}
+
+func isBlank(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r\n")) == 0
+}
+
+// NextMessage returns the next message with CRLF line endings and From
+// quoting removed. It returns io.EOF when no messages are left. Entries
+// that cannot be read are skipped up to the next From_ line and reported
+// as *CorruptError.
+func (r *Reader) NextMessage() ([]byte, error) {
+	start := r.Offset()
+	// Locate the From_ separator.
+	if r.pending == nil {
+		for {
+			line, err := r.readLine()
+			if len(line) > 0 && !isBlank(line) {
+				if !isFromLine(line) {
+					start = r.off - int64(len(line))
+					if err := r.skipToFrom(); err != nil {
+						return nil, err
+					}
+					return nil, &CorruptError{Offset: start, Size: r.Offset() - start, Err: ErrInvalidFormat}
+				}
+				start = r.off - int64(len(line))
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	r.pending = nil
+
+	var msg bytes.Buffer
+	tooLarge := false
+	add := func(line []byte) {
+		if tooLarge {
+			return
+		}
+		writeCRLF(&msg, line)
+		if r.maxSize > 0 && int64(msg.Len()) > r.maxSize {
+			tooLarge = true
+			msg = bytes.Buffer{}
+		}
+	}
+	done := func() ([]byte, error) {
+		if tooLarge {
+			return nil, &CorruptError{Offset: start, Size: r.Offset() - start, Err: ErrTooLarge}
+		}
+		return msg.Bytes(), nil
+	}
+	contentLength := int64(-1)
+	// Header block
+	for {
+		line, err := r.readLine()
+		if len(line) > 0 {
+			if r.format.usesContentLength() {
+				if v, ok := headerValue(line, "content-length"); ok {
+					if n, perr := strconv.ParseInt(v, 10, 64); perr == nil && n >= 0 {
+						contentLength = n
+					}
+				}
+			}
+			add(line)
+			if isBlank(line) {
+				break
+			}
+		}
+		if err == io.EOF {
+			return done()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Body delimited by Content-Length
+	if contentLength >= 0 {
+		if r.maxSize > 0 && int64(msg.Len())+contentLength > r.maxSize {
+			tooLarge = true
+			n, err := io.CopyN(io.Discard, r.br, contentLength)
+			r.off += n
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return done()
+		}
+		body := make([]byte, contentLength)
+		n, err := io.ReadFull(r.br, body)
+		r.off += int64(n)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		for _, line := range bytes.SplitAfter(body[:n], []byte("\n")) {
+			if len(line) > 0 {
+				add(r.unquote(line))
+			}
+		}
+		return done()
+	}
+
+	// Body delimited by the next From_ line
+	var blank []byte // a trailing blank line belongs to the separator
+	for {
+		line, err := r.readLine()
+		if len(line) > 0 {
+			if isFromLine(line) {
+				r.pending = line
+				break
+			}
+			if blank != nil {
+				add(blank)
+				blank = nil
+			}
+			if isBlank(line) {
+				blank = line
+			} else {
+				add(r.unquote(line))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return done()
+}
+
+// skipToFrom reads up to the next From_ line, which becomes pending.
+func (r *Reader) skipToFrom() error {
+	for {
+		line, err := r.readLine()
+		if isFromLine(line) {
+			r.pending = line
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Reader) unquote(line []byte) []byte {
+	switch r.format {
+	case FormatMboxo, FormatMboxcl:
+		if bytes.HasPrefix(line, []byte(">From ")) {
+			return line[1:]
+		}
+	case FormatMboxrd:
+		trimmed := bytes.TrimLeft(line, ">")
+		if len(trimmed) < len(line) && isFromLine(trimmed) {
+			return line[1:]
+		}
+	}
+	return line
+}
+
+// writeCRLF writes line to buf, normalizing its line ending to CRLF.
+func writeCRLF(buf *bytes.Buffer, line []byte) {
+	if bytes.HasSuffix(line, []byte("\n")) {
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		buf.Write(line)
+		buf.WriteString("\r\n")
+		return
+	}
+	buf.Write(line)
+}
+
+// headerValue returns the value of a header line if its field name matches
+// name (case-insensitive).
+func headerValue(line []byte, name string) (string, bool) {
+	i := bytes.IndexByte(line, ':')
+	if i <= 0 || !strings.EqualFold(string(line[:i]), name) {
+		return "", false
+	}
+	return strings.TrimSpace(string(line[i+1:])), true
+}
@@ -0,0 +1,122 @@
+package mboxutil
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, data string, format Format) ([]string, int64) {
+	t.Helper()
+	r := NewReader(strings.NewReader(data), format)
+	var msgs []string
+	for {
+		m, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextMessage: %v", err)
+		}
+		msgs = append(msgs, string(m))
+	}
+	return msgs, r.Offset()
+}
+
+func TestReaderMboxrd(t *testing.T) {
+	data := "From a@example Mon Jan  1 00:00:00 2024\n" +
+		"Subject: one\n\n" +
+		">From the start\n" +
+		">>From quoted\n\n" +
+		"From b@example Mon Jan  1 00:00:00 2024\n" +
+		"Subject: two\n\nbody\n"
+	msgs, off := readAll(t, data, FormatMboxrd)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	if want := "Subject: one\r\n\r\nFrom the start\r\n>From quoted\r\n"; msgs[0] != want {
+		t.Fatalf("unexpected first message %q", msgs[0])
+	}
+	if off != int64(len(data)) {
+		t.Fatalf("expected offset %d, got %d", len(data), off)
+	}
+}
+
+func TestReaderMboxcl2ContentLength(t *testing.T) {
+	body := "line\nFrom unquoted line\n"
+	data := "From a@example Mon Jan  1 00:00:00 2024\n" +
+		"Subject: one\nContent-Length: 24\n\n" + body + "\n" +
+		"From b@example Mon Jan  1 00:00:00 2024\n" +
+		"Subject: two\nContent-Length: 0\n\n"
+	msgs, _ := readAll(t, data, FormatMboxcl2)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d: %q", len(msgs), msgs)
+	}
+	if !strings.HasSuffix(msgs[0], "line\r\nFrom unquoted line\r\n") {
+		t.Fatalf("body not delimited by Content-Length: %q", msgs[0])
+	}
+	// Without Content-Length handling the same data splits into three.
+	if msgs, _ := readAll(t, data, FormatMboxo); len(msgs) != 3 {
+		t.Fatalf("expected mboxo to split on the unquoted From line, got %d", len(msgs))
+	}
+}
+
+func TestDetect(t *testing.T) {
+	from := "From a@example Mon Jan  1 00:00:00 2024\n"
+	for _, tc := range []struct {
+		name string
+		data string
+		want Format
+	}{
+		{"from-delimited", from + "Subject: one\n\n>From quoted\n\n" + from + "Subject: two\n\nbody\n", FormatMboxrd},
+		{"content-length unquoted", from + "Content-Length: 20\n\nx\nFrom unquoted\nyy\n\n" + from + "Content-Length: 0\n\n", FormatMboxcl2},
+		{"content-length quoted", from + "Content-Length: 15\n\nx\n>From quoted\n\n" + from + "Subject: two\n\nbody\n", FormatMboxcl},
+		// a stale Content-Length copied from the original message
+		{"content-length wrong", from + "Content-Length: 3\n\nlonger body\n\n" + from + "Subject: two\n\nbody\n", FormatMboxrd},
+		{"truncated sample", from + "Content-Length: 999\n\nbody\n", FormatMboxcl2},
+	} {
+		if got := Detect([]byte(tc.data)); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReaderSkipsCorrupt(t *testing.T) {
+	from := "From a@example Mon Jan  1 00:00:00 2024\n"
+	garbage := "garbage before the first separator\n"
+	big := from + "Subject: big\n\n" + strings.Repeat("x", 100) + "\n"
+	data := garbage + from + "Subject: one\n\nbody\n" + big + from + "Subject: two\n\nbody\n"
+	r := NewReader(strings.NewReader(data), FormatMboxrd)
+	r.SetMaxSize(64)
+	var subjects []string
+	var corrupt []*CorruptError
+	for {
+		m, err := r.NextMessage()
+		if err == io.EOF {
+			break
+		}
+		var ce *CorruptError
+		if errors.As(err, &ce) {
+			corrupt = append(corrupt, ce)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("NextMessage: %v", err)
+		}
+		subjects = append(subjects, strings.SplitN(string(m), "\r\n", 2)[0])
+	}
+	if len(subjects) != 2 || subjects[0] != "Subject: one" || subjects[1] != "Subject: two" {
+		t.Fatalf("unexpected messages %q", subjects)
+	}
+	if len(corrupt) != 2 {
+		t.Fatalf("expected 2 corrupt entries, got %v", corrupt)
+	}
+	if c := corrupt[0]; !errors.Is(c, ErrInvalidFormat) || c.Offset != 0 || c.Size != int64(len(garbage)) {
+		t.Fatalf("unexpected first entry %+v", c)
+	}
+	bigAt := int64(strings.Index(data, big))
+	if c := corrupt[1]; !errors.Is(c, ErrTooLarge) || c.Offset != bigAt || c.Size != int64(len(big)) {
+		t.Fatalf("unexpected second entry %+v (want offset %d)", c, bigAt)
+	}
+}
@@ -0,0 +1,58 @@
+package mboxutil
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	_ = g.Reader.Close()
+	return g.f.Close()
+}
+
+// Open opens an mbox file for reading, positioned at offset bytes into the
+// (uncompressed) mbox data. Gzip-compressed files are detected by their magic
+// bytes and decompressed transparently; as they cannot seek, the data before
+// offset is read and discarded.
+func Open(path string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 2)
+	n, _ := io.ReadFull(f, magic)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if n == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		g := &gzipFile{Reader: zr, f: f}
+		if offset > 0 {
+			if _, err := io.CopyN(io.Discard, g, offset); err != nil {
+				g.Close()
+				return nil, fmt.Errorf("skip to offset %d: %w", offset, err)
+			}
+		}
+		return g, nil
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("seek to offset %d: %w", offset, err)
+		}
+	}
+	return f, nil
+}
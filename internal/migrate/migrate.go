@@ -0,0 +1,144 @@
+// Package migrate runs the stages of a migration in order and records
+// their status in a file, so an interrupted migration resumes with the
+// first stage that did not finish.
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Stage is one step of a migration.
+type Stage struct {
+	Name string
+	// Run does the work of the stage and returns a one-line result for
+	// the status, e.g. "12 mailboxes, 34211 messages".
+	Run func(ctx context.Context) (string, error)
+}
+
+// Stage states.
+const (
+	Pending = ""
+	Done    = "done"
+	Failed  = "failed"
+)
+
+// StageStatus is the outcome of one stage.
+type StageStatus struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+	Result   string    `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Duration is how long the stage ran, 0 if it has not finished.
+func (s *StageStatus) Duration() time.Duration {
+	if s.Finished.IsZero() {
+		return 0
+	}
+	return s.Finished.Sub(s.Started).Round(time.Second)
+}
+
+// Status is the progress of a migration.
+type Status struct {
+	path    string
+	Started time.Time      `json:"started"`
+	Stages  []*StageStatus `json:"stages"`
+}
+
+// Load reads the status file at path. A missing file, or an empty path,
+// gives a fresh status; with an empty path Save does nothing.
+func Load(path string) (*Status, error) {
+	s := &Status{path: path}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Save writes the status file.
+func (s *Status) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, b, 0o600)
+}
+
+// Stage returns the status of the stage name, adding it if it is new.
+func (s *Status) Stage(name string) *StageStatus {
+	for _, st := range s.Stages {
+		if st.Name == name {
+			return st
+		}
+	}
+	st := &StageStatus{Name: name}
+	s.Stages = append(s.Stages, st)
+	return st
+}
+
+// Run runs the stages in order and reports each on w. Stages that are
+// done in s are skipped; the first failure stops the run. The status is
+// saved before and after every stage.
+func Run(ctx context.Context, s *Status, stages []Stage, w io.Writer) error {
+	if s.Started.IsZero() {
+		s.Started = time.Now()
+	}
+	for i, stage := range stages {
+		st := s.Stage(stage.Name)
+		prefix := fmt.Sprintf("[%d/%d] %s", i+1, len(stages), stage.Name)
+		if st.State == Done {
+			fmt.Fprintf(w, "%s: done %s, skipped\n", prefix, st.Finished.Format("2006-01-02 15:04"))
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: running\n", prefix)
+		*st = StageStatus{Name: stage.Name, Started: time.Now()}
+		if err := s.Save(); err != nil {
+			return fmt.Errorf("save migration status: %w", err)
+		}
+		result, err := stage.Run(ctx)
+		st.Finished = time.Now()
+		st.Result = result
+		if err != nil {
+			st.State, st.Error = Failed, err.Error()
+		} else {
+			st.State = Done
+		}
+		if serr := s.Save(); serr != nil && err == nil {
+			err = fmt.Errorf("save migration status: %w", serr)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s: failed after %s: %v\n", prefix, st.Duration(), err)
+			return fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		if result != "" {
+			fmt.Fprintf(w, "%s: done in %s: %s\n", prefix, st.Duration(), result)
+		} else {
+			fmt.Fprintf(w, "%s: done in %s\n", prefix, st.Duration())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunResumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate.json")
+	var ran []string
+	fail := true
+	stages := []Stage{
+		{Name: "estimate", Run: func(context.Context) (string, error) {
+			ran = append(ran, "estimate")
+			return "3 mailboxes", nil
+		}},
+		{Name: "copy", Run: func(context.Context) (string, error) {
+			ran = append(ran, "copy")
+			if fail {
+				return "", errors.New("connection reset")
+			}
+			return "", nil
+		}},
+		{Name: "verify", Run: func(context.Context) (string, error) {
+			ran = append(ran, "verify")
+			return "", nil
+		}},
+	}
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Run(context.Background(), s, stages, &out); err == nil || !strings.Contains(err.Error(), "stage copy") {
+		t.Fatalf("Run = %v, want the copy stage to fail", err)
+	}
+	if got := strings.Join(ran, ","); got != "estimate,copy" {
+		t.Errorf("first run ran %s", got)
+	}
+
+	// a new process picks up the saved status
+	fail, ran = false, nil
+	if s, err = Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Stage("copy"); st.State != Failed || st.Error != "connection reset" {
+		t.Errorf("saved copy stage %+v", st)
+	}
+	out.Reset()
+	if err := Run(context.Background(), s, stages, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ran, ","); got != "copy,verify" {
+		t.Errorf("resumed run ran %s, want copy,verify", got)
+	}
+	if !strings.Contains(out.String(), "[1/3] estimate: done") || !strings.Contains(out.String(), "skipped") {
+		t.Errorf("resumed run output:\n%s", out.String())
+	}
+	if st := s.Stage("estimate"); st.Result != "3 mailboxes" {
+		t.Errorf("estimate result %q", st.Result)
+	}
+}
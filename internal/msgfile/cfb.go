@@ -0,0 +1,269 @@
+package msgfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// Compound File Binary format (MS-CFB), the container of .msg files: a
+// FAT file system in a file, with a directory tree of storages and streams.
+
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// ErrNotMsg is returned for files that are not compound files.
+var ErrNotMsg = errors.New("not an Outlook .msg file (no compound file signature)")
+
+const (
+	endOfChain = 0xFFFFFFFE
+	noStream   = 0xFFFFFFFF
+
+	typeStorage = 1
+	typeStream  = 2
+	typeRoot    = 5
+)
+
+// entry is a storage or stream of a compound file.
+type entry struct {
+	name     string
+	typ      byte
+	start    uint32
+	size     uint64
+	children []*entry
+	// left, right and child are directory IDs while loading.
+	left, right, child uint32
+}
+
+// find returns the direct child called name (names compare
+// case-insensitively, as in the format).
+func (e *entry) find(name string) *entry {
+	for _, c := range e.children {
+		if equalFoldASCII(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+type compoundFile struct {
+	data       []byte
+	sectorSize int
+	fat        []uint32
+	miniFAT    []uint32
+	miniStream []byte
+	cutoff     uint64
+	root       *entry
+}
+
+// openCompound parses the compound file held in data.
+func openCompound(data []byte) (*compoundFile, error) {
+	if len(data) < 512 || !bytes.Equal(data[:8], cfbSignature) {
+		return nil, ErrNotMsg
+	}
+	le := binary.LittleEndian
+	shift := le.Uint16(data[0x1E:])
+	if shift != 9 && shift != 12 {
+		return nil, fmt.Errorf("unsupported sector size 2^%d", shift)
+	}
+	cf := &compoundFile{data: data, sectorSize: 1 << shift, cutoff: uint64(le.Uint32(data[0x38:]))}
+
+	// The DIFAT lists the FAT sectors: 109 in the header, more in a chain.
+	var difat []uint32
+	for i := 0; i < 109; i++ {
+		difat = append(difat, le.Uint32(data[0x4C+4*i:]))
+	}
+	next := le.Uint32(data[0x44:])
+	for n := le.Uint32(data[0x48:]); n > 0 && next != endOfChain && next != noStream; n-- {
+		sec, err := cf.sector(next)
+		if err != nil {
+			return nil, fmt.Errorf("DIFAT: %w", err)
+		}
+		per := cf.sectorSize/4 - 1
+		for i := 0; i < per; i++ {
+			difat = append(difat, le.Uint32(sec[4*i:]))
+		}
+		next = le.Uint32(sec[4*per:])
+	}
+	numFAT := int(le.Uint32(data[0x2C:]))
+	for _, s := range difat {
+		if len(cf.fat)/(cf.sectorSize/4) >= numFAT || s == noStream || s == endOfChain {
+			break
+		}
+		sec, err := cf.sector(s)
+		if err != nil {
+			return nil, fmt.Errorf("FAT: %w", err)
+		}
+		for i := 0; i < cf.sectorSize/4; i++ {
+			cf.fat = append(cf.fat, le.Uint32(sec[4*i:]))
+		}
+	}
+
+	dir, err := cf.chain(le.Uint32(data[0x30:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	if mf := le.Uint32(data[0x3C:]); mf != endOfChain && mf != noStream {
+		b, err := cf.chain(mf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("mini FAT: %w", err)
+		}
+		for i := 0; i+4 <= len(b); i += 4 {
+			cf.miniFAT = append(cf.miniFAT, le.Uint32(b[i:]))
+		}
+	}
+
+	var entries []*entry
+	for i := 0; i+128 <= len(dir); i += 128 {
+		d := dir[i : i+128]
+		nameLen := int(le.Uint16(d[64:]))
+		if nameLen > 64 {
+			nameLen = 64
+		}
+		u := make([]uint16, 0, nameLen/2)
+		for j := 0; j+1 < nameLen; j += 2 {
+			if c := le.Uint16(d[j:]); c != 0 {
+				u = append(u, c)
+			}
+		}
+		entries = append(entries, &entry{
+			name:  string(utf16.Decode(u)),
+			typ:   d[66],
+			left:  le.Uint32(d[68:]),
+			right: le.Uint32(d[72:]),
+			child: le.Uint32(d[76:]),
+			start: le.Uint32(d[116:]),
+			size:  le.Uint64(d[120:]),
+		})
+	}
+	if len(entries) == 0 || entries[0].typ != typeRoot {
+		return nil, errors.New("directory: no root entry")
+	}
+	if shift == 9 {
+		// version 3 files may leave garbage in the high half
+		for _, e := range entries {
+			e.size &= 0xFFFFFFFF
+		}
+	}
+	cf.root = entries[0]
+	if cf.miniStream, err = cf.chain(cf.root.start, cf.root.size); err != nil {
+		return nil, fmt.Errorf("mini stream: %w", err)
+	}
+	// Children of a storage form a red-black tree; flatten it.
+	seen := make([]bool, len(entries))
+	var collect func(parent *entry, id uint32) error
+	collect = func(parent *entry, id uint32) error {
+		if id == noStream {
+			return nil
+		}
+		if int(id) >= len(entries) || seen[id] {
+			return errors.New("directory: broken tree")
+		}
+		seen[id] = true
+		e := entries[id]
+		if err := collect(parent, e.left); err != nil {
+			return err
+		}
+		parent.children = append(parent.children, e)
+		if e.typ == typeStorage {
+			if err := collect(e, e.child); err != nil {
+				return err
+			}
+		}
+		return collect(parent, e.right)
+	}
+	seen[0] = true
+	if err := collect(cf.root, cf.root.child); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+func (cf *compoundFile) sector(n uint32) ([]byte, error) {
+	off := (int(n) + 1) * cf.sectorSize
+	if n >= endOfChain || off+cf.sectorSize > len(cf.data) {
+		if n < endOfChain && off < len(cf.data) {
+			return cf.data[off:], nil // short last sector
+		}
+		return nil, fmt.Errorf("sector %d out of range", n)
+	}
+	return cf.data[off : off+cf.sectorSize], nil
+}
+
+// chain reads the sector chain starting at start, truncated to size when
+// size is not 0.
+func (cf *compoundFile) chain(start uint32, size uint64) ([]byte, error) {
+	var b []byte
+	for n, steps := start, 0; n != endOfChain && n != noStream; steps++ {
+		if steps > len(cf.fat) || int(n) >= len(cf.fat) {
+			return nil, errors.New("broken sector chain")
+		}
+		sec, err := cf.sector(n)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, sec...)
+		if size > 0 && uint64(len(b)) >= size {
+			break
+		}
+		n = cf.fat[n]
+	}
+	if size > 0 && uint64(len(b)) > size {
+		b = b[:size]
+	}
+	return b, nil
+}
+
+// read returns the contents of a stream.
+func (cf *compoundFile) read(e *entry) ([]byte, error) {
+	if e.size == 0 {
+		return nil, nil
+	}
+	if e.size >= cf.cutoff {
+		b, err := cf.chain(e.start, e.size)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(b)) < e.size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return b, nil
+	}
+	var b []byte
+	for n, steps := e.start, 0; n != endOfChain && uint64(len(b)) < e.size; steps++ {
+		if int(n) >= len(cf.miniFAT) || steps > len(cf.miniFAT) {
+			return nil, errors.New("broken mini sector chain")
+		}
+		off := int(n) * 64
+		if off+64 > len(cf.miniStream) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b = append(b, cf.miniStream[off:off+64]...)
+		n = cf.miniFAT[n]
+	}
+	if uint64(len(b)) < e.size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b[:e.size], nil
+}
+
+func equalFoldASCII(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if 'a' <= ca && ca <= 'z' {
+			ca -= 'a' - 'A'
+		}
+		if 'a' <= cb && cb <= 'z' {
+			cb -= 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
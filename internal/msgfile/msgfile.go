@@ -0,0 +1,480 @@
+// Package msgfile converts Outlook .msg files (MAPI properties in a
+// compound file, see MS-OXMSG) to RFC 822 messages, so messages exported
+// from Outlook can be uploaded like .eml files.
+package msgfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/emersion/go-imap"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Message is a converted .msg file.
+type Message struct {
+	Raw   []byte    // RFC 822 message with CRLF line endings
+	Date  time.Time // delivery time, else submit time; zero if unknown
+	Flags []string  // IMAP flags from the MAPI message flags
+}
+
+// MAPI property IDs used for the conversion.
+const (
+	propSubject            = 0x0037
+	propClientSubmitTime   = 0x0039
+	propSentRepName        = 0x0042
+	propSentRepEmail       = 0x0065
+	propTransportHeaders   = 0x007D
+	propRecipientType      = 0x0C15
+	propSenderName         = 0x0C1A
+	propSenderEmail        = 0x0C1F
+	propDeliveryTime       = 0x0E06
+	propMessageFlags       = 0x0E07
+	propBody               = 0x1000
+	propHTML               = 0x1013
+	propInternetMessageID  = 0x1035
+	propReferences         = 0x1039
+	propInReplyTo          = 0x1042
+	propLastVerbExecuted   = 0x1081
+	propFlagStatus         = 0x1090
+	propDisplayName        = 0x3001
+	propEmailAddress       = 0x3003
+	propAttachData         = 0x3701
+	propAttachFilename     = 0x3704
+	propAttachMethod       = 0x3705
+	propAttachLongFilename = 0x3707
+	propAttachMimeTag      = 0x370E
+	propAttachContentID    = 0x3712
+	propSMTPAddress        = 0x39FE
+	propInternetCPID       = 0x3FDE
+	propMessageCodepage    = 0x3FFD
+	propSenderSMTP         = 0x5D01
+	propSentRepSMTP        = 0x5D02
+)
+
+const (
+	msgFlagRead   = 0x1
+	msgFlagUnsent = 0x8
+
+	attachByValue  = 1
+	attachEmbedded = 5
+)
+
+// storage is a message, attachment or recipient storage with its fixed
+// size properties.
+type storage struct {
+	cf       *compoundFile
+	e        *entry
+	props    map[uint16]uint64
+	codepage uint64
+}
+
+func newStorage(cf *compoundFile, e *entry, headerSize int) (*storage, error) {
+	s := &storage{cf: cf, e: e, props: map[uint16]uint64{}}
+	if pe := e.find("__properties_version1.0"); pe != nil {
+		b, err := cf.read(pe)
+		if err != nil {
+			return nil, fmt.Errorf("read properties: %w", err)
+		}
+		for i := headerSize; i+16 <= len(b); i += 16 {
+			tag := binary.LittleEndian.Uint32(b[i:])
+			s.props[uint16(tag>>16)] = binary.LittleEndian.Uint64(b[i+8:])
+		}
+	}
+	return s, nil
+}
+
+func (s *storage) stream(id uint16, typ string) []byte {
+	e := s.e.find(fmt.Sprintf("__substg1.0_%04X%s", id, typ))
+	if e == nil || e.typ != typeStream {
+		return nil
+	}
+	b, _ := s.cf.read(e)
+	return b
+}
+
+// str returns a string property, stored as UTF-16 or in the message's
+// code page.
+func (s *storage) str(id uint16) string {
+	if b := s.stream(id, "001F"); b != nil {
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			u = append(u, binary.LittleEndian.Uint16(b[i:]))
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	}
+	if b := s.stream(id, "001E"); b != nil {
+		b = bytes.TrimRight(b, "\x00")
+		if enc, err := htmlindex.Get(codepageName(s.codepage)); err == nil {
+			if d, err := enc.NewDecoder().Bytes(b); err == nil {
+				return string(d)
+			}
+		}
+		return string(b)
+	}
+	return ""
+}
+
+func (s *storage) bin(id uint16) []byte {
+	return s.stream(id, "0102")
+}
+
+func (s *storage) time(id uint16) time.Time {
+	v, ok := s.props[id]
+	if !ok || v == 0 {
+		return time.Time{}
+	}
+	// FILETIME: 100 ns intervals since 1601-01-01
+	const epochDiff = 116444736000000000
+	if v < epochDiff {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(v-epochDiff)*100).UTC()
+}
+
+// codepageName maps a Windows code page to a charset name.
+func codepageName(cp uint64) string {
+	switch {
+	case cp == 0:
+		return "windows-1252"
+	case cp == 65001:
+		return "utf-8"
+	case cp == 874 || (cp >= 1250 && cp <= 1258):
+		return fmt.Sprintf("windows-%d", cp)
+	case cp > 28590 && cp <= 28605:
+		return fmt.Sprintf("iso-8859-%d", cp-28590)
+	}
+	return map[uint64]string{932: "shift_jis", 936: "gbk", 949: "euc-kr", 950: "big5", 20866: "koi8-r", 21866: "koi8-u", 50220: "iso-2022-jp", 51932: "euc-jp"}[cp]
+}
+
+// Convert converts the .msg file in data.
+func Convert(data []byte) (*Message, error) {
+	cf, err := openCompound(data)
+	if err != nil {
+		return nil, err
+	}
+	return convert(cf, cf.root, 32)
+}
+
+func convert(cf *compoundFile, root *entry, headerSize int) (*Message, error) {
+	s, err := newStorage(cf, root, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	s.codepage = s.props[propMessageCodepage]
+	if s.codepage == 0 {
+		s.codepage = s.props[propInternetCPID]
+	}
+	m := &Message{Date: s.time(propDeliveryTime)}
+	if m.Date.IsZero() {
+		m.Date = s.time(propClientSubmitTime)
+	}
+	m.Flags = []string{}
+	if f := s.props[propMessageFlags]; f&msgFlagRead != 0 {
+		m.Flags = append(m.Flags, imap.SeenFlag)
+	}
+	if f := s.props[propMessageFlags]; f&msgFlagUnsent != 0 {
+		m.Flags = append(m.Flags, imap.DraftFlag)
+	}
+	if v := s.props[propLastVerbExecuted]; v == 102 || v == 103 {
+		m.Flags = append(m.Flags, imap.AnsweredFlag)
+	}
+	if s.props[propFlagStatus] == 2 {
+		m.Flags = append(m.Flags, imap.FlaggedFlag)
+	}
+
+	var b bytes.Buffer
+	if th := s.str(propTransportHeaders); strings.TrimSpace(th) != "" {
+		writeTransportHeaders(&b, th)
+	} else {
+		writeHeaders(&b, s)
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if err := writeBody(&b, s); err != nil {
+		return nil, err
+	}
+	m.Raw = b.Bytes()
+	return m, nil
+}
+
+// writeTransportHeaders writes the headers the message arrived with,
+// minus the MIME fields that describe the original body.
+func writeTransportHeaders(b *bytes.Buffer, th string) {
+	th = strings.ReplaceAll(strings.ReplaceAll(th, "\r\n", "\n"), "\n", "\r\n")
+	skip := false
+	for _, line := range strings.SplitAfter(th, "\r\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := strings.Cut(line, ":")
+			switch textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)) {
+			case "Content-Type", "Content-Transfer-Encoding", "Mime-Version", "Content-Disposition":
+				skip = true
+			default:
+				skip = false
+			}
+		}
+		if !skip {
+			b.WriteString(line)
+			if !strings.HasSuffix(line, "\r\n") {
+				b.WriteString("\r\n")
+			}
+		}
+	}
+}
+
+// writeHeaders builds the headers of a message that has no transport
+// headers, e.g. a sent message or a draft.
+func writeHeaders(b *bytes.Buffer, s *storage) {
+	name := s.str(propSentRepName)
+	if name == "" {
+		name = s.str(propSenderName)
+	}
+	addr := firstAddress(s.str(propSentRepSMTP), s.str(propSenderSMTP), s.str(propSentRepEmail), s.str(propSenderEmail))
+	if name != "" || addr != "" {
+		b.WriteString("From: " + formatAddress(name, addr) + "\r\n")
+	}
+	var to, cc []string
+	for _, c := range s.e.children {
+		if c.typ != typeStorage || !strings.HasPrefix(c.name, "__recip_version1.0_") {
+			continue
+		}
+		r, err := newStorage(s.cf, c, 8)
+		if err != nil {
+			continue
+		}
+		r.codepage = s.codepage
+		a := formatAddress(r.str(propDisplayName), firstAddress(r.str(propSMTPAddress), r.str(propEmailAddress)))
+		switch r.props[propRecipientType] {
+		case 2:
+			cc = append(cc, a)
+		case 3:
+			// Bcc stays out of the headers, as in a sent message
+		default:
+			to = append(to, a)
+		}
+	}
+	if len(to) > 0 {
+		b.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	}
+	if len(cc) > 0 {
+		b.WriteString("Cc: " + strings.Join(cc, ", ") + "\r\n")
+	}
+	if subj := s.str(propSubject); subj != "" {
+		b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subj) + "\r\n")
+	}
+	date := s.time(propClientSubmitTime)
+	if date.IsZero() {
+		date = s.time(propDeliveryTime)
+	}
+	if !date.IsZero() {
+		b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	}
+	for _, h := range []struct {
+		name string
+		id   uint16
+	}{{"Message-ID", propInternetMessageID}, {"In-Reply-To", propInReplyTo}, {"References", propReferences}} {
+		if v := strings.TrimSpace(s.str(h.id)); v != "" {
+			b.WriteString(h.name + ": " + v + "\r\n")
+		}
+	}
+}
+
+// firstAddress returns the first candidate that is an internet address;
+// Exchange-internal addresses ("/O=ORG/OU=...") are skipped.
+func firstAddress(candidates ...string) string {
+	for _, a := range candidates {
+		if strings.Contains(a, "@") && !strings.HasPrefix(a, "/") {
+			return strings.TrimSpace(a)
+		}
+	}
+	return ""
+}
+
+func formatAddress(name, addr string) string {
+	if addr != "" {
+		return (&mail.Address{Name: name, Address: addr}).String()
+	}
+	return mime.QEncoding.Encode("utf-8", name) + " <>"
+}
+
+type attachment struct {
+	name, contentType, contentID string
+	data                         []byte
+	embedded                     *Message
+}
+
+func writeBody(b *bytes.Buffer, s *storage) error {
+	text := s.str(propBody)
+	var html []byte
+	htmlCharset := "utf-8"
+	if h := s.bin(propHTML); h != nil {
+		html = h
+		if cs := codepageName(s.props[propInternetCPID]); cs != "" {
+			htmlCharset = cs
+		}
+	} else if h := s.str(propHTML); h != "" {
+		html = []byte(h)
+	}
+	atts, err := attachments(s)
+	if err != nil {
+		return err
+	}
+
+	if len(atts) == 0 {
+		return writeAlternative(b, text, html, htmlCharset)
+	}
+	mw := multipart.NewWriter(b)
+	fmt.Fprintf(b, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mw.Boundary())
+	var body bytes.Buffer
+	if err := writeAlternative(&body, text, html, htmlCharset); err != nil {
+		return err
+	}
+	hdr, content, _ := bytes.Cut(body.Bytes(), []byte("\r\n\r\n"))
+	pw, err := mw.CreatePart(partHeader(string(hdr)))
+	if err != nil {
+		return err
+	}
+	pw.Write(content)
+	for _, a := range atts {
+		h := textproto.MIMEHeader{}
+		if a.embedded != nil {
+			h.Set("Content-Type", "message/rfc822")
+			h.Set("Content-Disposition", "attachment")
+			pw, err := mw.CreatePart(h)
+			if err != nil {
+				return err
+			}
+			pw.Write(a.embedded.Raw)
+			continue
+		}
+		h.Set("Content-Type", mime.FormatMediaType(a.contentType, map[string]string{"name": a.name}))
+		disposition := "attachment"
+		if a.contentID != "" {
+			disposition = "inline"
+			h.Set("Content-ID", "<"+strings.Trim(a.contentID, "<>")+">")
+		}
+		h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.name}))
+		h.Set("Content-Transfer-Encoding", "base64")
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		writeBase64(pw, a.data)
+	}
+	return mw.Close()
+}
+
+// partHeader parses the "Name: value" lines written by writeAlternative.
+func partHeader(s string) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	for _, line := range strings.Split(s, "\r\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			h.Set(name, strings.TrimSpace(value))
+		}
+	}
+	return h
+}
+
+// writeAlternative writes the text and HTML bodies, each alone or both as
+// multipart/alternative, starting with the Content-Type header.
+func writeAlternative(b *bytes.Buffer, text string, html []byte, htmlCharset string) error {
+	switch {
+	case html == nil:
+		writeQP(b, "text/plain; charset=utf-8", []byte(text))
+		return nil
+	case text == "":
+		writeQP(b, mime.FormatMediaType("text/html", map[string]string{"charset": htmlCharset}), html)
+		return nil
+	}
+	mw := multipart.NewWriter(b)
+	fmt.Fprintf(b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	for _, p := range []struct {
+		ct   string
+		data []byte
+	}{{"text/plain; charset=utf-8", []byte(text)}, {mime.FormatMediaType("text/html", map[string]string{"charset": htmlCharset}), html}} {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", p.ct)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		qw.Write(p.data)
+		qw.Close()
+	}
+	return mw.Close()
+}
+
+func writeQP(b *bytes.Buffer, contentType string, data []byte) {
+	b.WriteString("Content-Type: " + contentType + "\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qw := quotedprintable.NewWriter(b)
+	qw.Write(data)
+	qw.Close()
+}
+
+func writeBase64(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		io.WriteString(w, enc[:76]+"\r\n")
+		enc = enc[76:]
+	}
+	io.WriteString(w, enc+"\r\n")
+}
+
+func attachments(s *storage) ([]attachment, error) {
+	var atts []attachment
+	for _, c := range s.e.children {
+		if c.typ != typeStorage || !strings.HasPrefix(c.name, "__attach_version1.0_") {
+			continue
+		}
+		as, err := newStorage(s.cf, c, 8)
+		if err != nil {
+			return nil, err
+		}
+		as.codepage = s.codepage
+		switch as.props[propAttachMethod] {
+		case attachEmbedded:
+			sub := c.find(fmt.Sprintf("__substg1.0_%04X000D", propAttachData))
+			if sub == nil {
+				continue
+			}
+			m, err := convert(s.cf, sub, 24)
+			if err != nil {
+				return nil, fmt.Errorf("embedded message: %w", err)
+			}
+			atts = append(atts, attachment{embedded: m})
+		case attachByValue, 0:
+			a := attachment{data: as.bin(propAttachData), contentID: as.str(propAttachContentID)}
+			if a.data == nil {
+				continue // links and OLE objects have no data to carry over
+			}
+			if a.name = as.str(propAttachLongFilename); a.name == "" {
+				a.name = as.str(propAttachFilename)
+			}
+			if a.contentType = as.str(propAttachMimeTag); a.contentType == "" {
+				a.contentType = mime.TypeByExtension(filepath.Ext(a.name))
+			}
+			if a.contentType == "" {
+				a.contentType = "application/octet-stream"
+			}
+			atts = append(atts, a)
+		}
+	}
+	return atts, nil
+}
@@ -0,0 +1,309 @@
+package msgfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// node is a storage (children set) or stream of a compound file to write.
+type node struct {
+	name     string
+	data     []byte
+	children []*node
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+func strProp(id uint16, s string) *node {
+	return &node{name: fmt.Sprintf("__substg1.0_%04X001F", id), data: utf16le(s)}
+}
+
+// props builds a __properties_version1.0 stream with a header of size hdr.
+func props(hdr int, vals map[uint32]uint64) *node {
+	b := make([]byte, hdr)
+	for tag, v := range vals {
+		b = binary.LittleEndian.AppendUint32(b, tag)
+		b = binary.LittleEndian.AppendUint32(b, 6)
+		b = binary.LittleEndian.AppendUint64(b, v)
+	}
+	return &node{name: "__properties_version1.0", data: b}
+}
+
+// writeCompound writes a version 3 compound file: small streams go to the
+// mini stream, the others to regular sectors.
+func writeCompound(root *node) []byte {
+	const secSize = 512
+	var sectors [][]byte
+	var fat []uint32
+	alloc := func(data []byte) uint32 {
+		if len(data) == 0 {
+			return endOfChain
+		}
+		start := uint32(len(sectors))
+		for off := 0; off < len(data); off += secSize {
+			sec := make([]byte, secSize)
+			copy(sec, data[off:])
+			sectors = append(sectors, sec)
+			fat = append(fat, uint32(len(sectors)))
+		}
+		fat[len(fat)-1] = endOfChain
+		return start
+	}
+
+	type dirEntry struct {
+		n                  *node
+		typ                byte
+		left, right, child uint32
+		start              uint32
+		size               uint64
+	}
+	dir := []*dirEntry{{n: root, typ: typeRoot, left: noStream, right: noStream, child: noStream}}
+	var mini []byte
+	var miniFAT []uint32
+	var add func(parent *dirEntry, n *node)
+	add = func(parent *dirEntry, n *node) {
+		d := &dirEntry{n: n, typ: typeStream, left: noStream, right: noStream, child: noStream}
+		id := uint32(len(dir))
+		dir = append(dir, d)
+		// siblings as a chain of right links
+		if parent.child == noStream {
+			parent.child = id
+		} else {
+			last := dir[parent.child]
+			for last.right != noStream {
+				last = dir[last.right]
+			}
+			last.right = id
+		}
+		switch {
+		case n.children != nil:
+			d.typ = typeStorage
+			for _, c := range n.children {
+				add(d, c)
+			}
+		case len(n.data) >= 4096:
+			d.start, d.size = alloc(n.data), uint64(len(n.data))
+		default:
+			d.start, d.size = uint32(len(mini)/64), uint64(len(n.data))
+			if len(n.data) == 0 {
+				d.start = endOfChain
+			}
+			for off := 0; off < len(n.data); off += 64 {
+				sec := make([]byte, 64)
+				copy(sec, n.data[off:])
+				mini = append(mini, sec...)
+				miniFAT = append(miniFAT, uint32(len(mini)/64))
+			}
+			if len(n.data) > 0 {
+				miniFAT[len(miniFAT)-1] = endOfChain
+			}
+		}
+	}
+	for _, c := range root.children {
+		add(dir[0], c)
+	}
+	dir[0].start, dir[0].size = alloc(mini), uint64(len(mini))
+	var mf []byte
+	for _, v := range miniFAT {
+		mf = binary.LittleEndian.AppendUint32(mf, v)
+	}
+	miniFATStart := alloc(mf)
+
+	var db []byte
+	for _, d := range dir {
+		e := make([]byte, 128)
+		name := utf16le(d.n.name)
+		copy(e, name)
+		binary.LittleEndian.PutUint16(e[64:], uint16(len(name)+2))
+		e[66] = d.typ
+		binary.LittleEndian.PutUint32(e[68:], d.left)
+		binary.LittleEndian.PutUint32(e[72:], d.right)
+		binary.LittleEndian.PutUint32(e[76:], d.child)
+		binary.LittleEndian.PutUint32(e[116:], d.start)
+		binary.LittleEndian.PutUint64(e[120:], d.size)
+		db = append(db, e...)
+	}
+	dirStart := alloc(db)
+
+	numFAT := 1
+	for (len(sectors)+numFAT)*4 > numFAT*secSize {
+		numFAT++
+	}
+	fatStart := uint32(len(sectors))
+	for i := 0; i < numFAT; i++ {
+		fat = append(fat, 0xFFFFFFFD)
+	}
+	var fb []byte
+	for _, v := range fat {
+		fb = binary.LittleEndian.AppendUint32(fb, v)
+	}
+	for len(fb) < numFAT*secSize {
+		fb = binary.LittleEndian.AppendUint32(fb, noStream)
+	}
+	for i := 0; i < numFAT; i++ {
+		sectors = append(sectors, fb[i*secSize:(i+1)*secSize])
+	}
+
+	hdr := make([]byte, secSize)
+	copy(hdr, cfbSignature)
+	le := binary.LittleEndian
+	le.PutUint16(hdr[0x18:], 0x3E)
+	le.PutUint16(hdr[0x1A:], 3)
+	le.PutUint16(hdr[0x1C:], 0xFFFE)
+	le.PutUint16(hdr[0x1E:], 9)
+	le.PutUint16(hdr[0x20:], 6)
+	le.PutUint32(hdr[0x2C:], uint32(numFAT))
+	le.PutUint32(hdr[0x30:], dirStart)
+	le.PutUint32(hdr[0x38:], 4096)
+	le.PutUint32(hdr[0x3C:], miniFATStart)
+	le.PutUint32(hdr[0x40:], uint32(len(mf)+secSize-1)/secSize)
+	le.PutUint32(hdr[0x44:], endOfChain)
+	for i := 0; i < 109; i++ {
+		v := uint32(noStream)
+		if i < numFAT {
+			v = fatStart + uint32(i)
+		}
+		le.PutUint32(hdr[0x4C+4*i:], v)
+	}
+	out := hdr
+	for _, s := range sectors {
+		out = append(out, s...)
+	}
+	return out
+}
+
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func TestConvert(t *testing.T) {
+	sent := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	pdf := bytes.Repeat([]byte("%PDF-1.4 data "), 400) // larger than the mini stream cutoff
+	embedded := &node{name: "__substg1.0_3701000D", children: []*node{
+		props(24, map[uint32]uint64{0x0E070003: msgFlagRead}),
+		strProp(propTransportHeaders, "From: carol@example.org\r\nSubject: Inner\r\nContent-Type: text/html\r\n"),
+		strProp(propBody, "inner body"),
+	}}
+	root := &node{name: "Root Entry", children: []*node{
+		props(32, map[uint32]uint64{
+			0x0E070003: msgFlagRead,
+			0x10900003: 2,
+			0x00390040: filetime(sent),
+		}),
+		strProp(propSubject, "Grüße aus Köln"),
+		strProp(propSenderName, "Alice Example"),
+		strProp(propSenderSMTP, "alice@example.com"),
+		strProp(propBody, "Hello Bob,\r\nsee attached.\r\n"),
+		strProp(propInternetMessageID, "<m1@example.com>"),
+		{name: "__recip_version1.0_#00000000", children: []*node{
+			props(8, map[uint32]uint64{0x0C150003: 1}),
+			strProp(propDisplayName, "Bob"),
+			strProp(propSMTPAddress, "bob@example.com"),
+			strProp(propEmailAddress, "/O=ORG/CN=BOB"),
+		}},
+		{name: "__recip_version1.0_#00000001", children: []*node{
+			props(8, map[uint32]uint64{0x0C150003: 2}),
+			strProp(propEmailAddress, "dave@example.com"),
+		}},
+		{name: "__attach_version1.0_#00000000", children: []*node{
+			props(8, map[uint32]uint64{0x37050003: attachByValue}),
+			strProp(propAttachLongFilename, "report.pdf"),
+			{name: "__substg1.0_37010102", data: pdf},
+		}},
+		{name: "__attach_version1.0_#00000001", children: []*node{
+			props(8, map[uint32]uint64{0x37050003: attachEmbedded}),
+			embedded,
+		}},
+	}}
+
+	m, err := Convert(writeCompound(root))
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if !m.Date.Equal(sent) {
+		t.Errorf("Date = %v, want %v", m.Date, sent)
+	}
+	if got := strings.Join(m.Flags, " "); got != `\Seen \Flagged` {
+		t.Errorf("Flags = %q", got)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v\n%s", err, m.Raw)
+	}
+	dec := new(mime.WordDecoder)
+	if subj, _ := dec.DecodeHeader(msg.Header.Get("Subject")); subj != "Grüße aus Köln" {
+		t.Errorf("Subject = %q", subj)
+	}
+	for h, want := range map[string]string{
+		"From":       `"Alice Example" <alice@example.com>`,
+		"To":         `"Bob" <bob@example.com>`,
+		"Cc":         `<dave@example.com>`,
+		"Message-Id": "<m1@example.com>",
+		"Date":       "Fri, 01 Mar 2024 09:30:00 +0000",
+	} {
+		if got := msg.Header.Get(h); got != want {
+			t.Errorf("%s = %q, want %q", h, got, want)
+		}
+	}
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		ct := p.Header.Get("Content-Type")
+		parts = append(parts, ct)
+		switch {
+		case strings.HasPrefix(ct, "text/plain"):
+			if string(body) != "Hello Bob,\r\nsee attached.\r\n" {
+				t.Errorf("text body = %q", body)
+			}
+		case strings.HasPrefix(ct, "application/pdf"):
+			body, _ = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+			if !bytes.Equal(body, pdf) {
+				t.Errorf("attachment differs (%d bytes, want %d)", len(body), len(pdf))
+			}
+		case ct == "message/rfc822":
+			inner, err := mail.ReadMessage(bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("embedded: %v", err)
+			}
+			if inner.Header.Get("Subject") != "Inner" || !strings.HasPrefix(inner.Header.Get("Content-Type"), "text/plain") {
+				t.Errorf("embedded headers = %v", inner.Header)
+			}
+		}
+	}
+	if want := `text/plain; charset=utf-8|application/pdf; name=report.pdf|message/rfc822`; strings.Join(parts, "|") != want {
+		t.Errorf("parts = %q, want %q", strings.Join(parts, "|"), want)
+	}
+
+	if _, err := Convert([]byte("From: x\r\n\r\nnot a msg file")); err != ErrNotMsg {
+		t.Errorf("Convert(eml) error = %v, want ErrNotMsg", err)
+	}
+}
@@ -0,0 +1,337 @@
+// Package pacer paces destination APPENDs with a token bucket whose rate
+// follows server feedback: it ramps up while appends stay fast, backs off
+// when latency climbs, and halves on throttle replies (NO/BAD responses that
+// ask the client to slow down). Replies that lock the account for much
+// longer (provider bandwidth limits, lockouts) pause the run instead.
+package pacer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// startRate is the initial rate in messages per second.
+	startRate = 5.0
+	// minRate is the floor the rate never drops below.
+	minRate = 0.2
+	// maxAttempts bounds how often a throttled operation is retried.
+	maxAttempts = 8
+	// maxBackoff caps the sleep before retrying a throttled operation.
+	maxBackoff = time.Minute
+)
+
+// backoff is the sleep before the first retry of a throttled operation; it
+// doubles with every further attempt. A variable so tests can shorten it.
+var backoff = time.Second
+
+// throttleHints are substrings of NO/BAD texts servers use to ask clients to
+// slow down. go-imap only exposes the human-readable text of a response.
+var throttleHints = []string{
+	"throttl", "rate limit", "too many", "try again", "slow down",
+	"limit exceeded", "server busy", "temporarily unavailable", "[limit]", "[unavailable]",
+	"server unavailable", // Office 365: "Server Unavailable. 15"
+}
+
+// transientCode matches an enhanced status code of class 4 (RFC 3463),
+// e.g. "4.3.2": a temporary failure. Office 365 puts these in its APPEND
+// replies when it throttles.
+var transientCode = regexp.MustCompile(`(^|[\s(\[])4\.\d{1,3}\.\d{1,3}([\s)\]:;,.]|$)`)
+
+// IsThrottle reports whether err looks like a server throttle reply.
+func IsThrottle(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, h := range throttleHints {
+		if strings.Contains(msg, h) {
+			return true
+		}
+	}
+	return transientCode.MatchString(msg)
+}
+
+// Freeze describes a provider limit that locks an account for minutes to
+// hours, which retrying sooner cannot help.
+type Freeze struct {
+	Provider string
+	Limit    string        // what was hit
+	Advice   string        // what the user can do about it
+	Pause    time.Duration // how long to wait before trying again
+}
+
+func (f Freeze) String() string {
+	return fmt.Sprintf("%s: %s. %s", f.Provider, f.Limit, f.Advice)
+}
+
+var (
+	gmailBandwidth = Freeze{
+		Provider: "Gmail",
+		Limit:    "daily IMAP bandwidth limit reached (about 2500 MB download and 500 MB upload per day)",
+		Advice:   "The lock lifts within 24 hours; spread large migrations over several days or lower --max-rate.",
+		Pause:    time.Hour,
+	}
+	gmailConnections = Freeze{
+		Provider: "Gmail",
+		Limit:    "too many simultaneous IMAP connections (at most 15 per account)",
+		Advice:   "Close other mail clients on this account or lower --concurrency.",
+		Pause:    5 * time.Minute,
+	}
+	yahooLocked = Freeze{
+		Provider: "Yahoo",
+		Limit:    "account temporarily locked after unusual activity",
+		Advice:   "Sign in at login.yahoo.com to unlock it and use an app password for gomap.",
+		Pause:    30 * time.Minute,
+	}
+)
+
+// freezeHints map substrings of NO/BAD/BYE texts to the limit they report.
+var freezeHints = []struct {
+	hint   string
+	freeze Freeze
+}{
+	{"exceeded bandwidth limits", gmailBandwidth},
+	{"exceeded command or bandwidth limits", gmailBandwidth},
+	{"too many simultaneous connections", gmailConnections},
+	{"account is locked", yahooLocked},
+	{"account has been locked", yahooLocked},
+	{"temporarily locked", yahooLocked},
+}
+
+// DetectFreeze reports whether err is a provider reply that locks the
+// account, and which limit was hit.
+func DetectFreeze(err error) (Freeze, bool) {
+	if err == nil {
+		return Freeze{}, false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, h := range freezeHints {
+		if strings.Contains(msg, h.hint) {
+			return h.freeze, true
+		}
+	}
+	return Freeze{}, false
+}
+
+// Pacer is safe for concurrent use; share one per destination connection.
+// A nil *Pacer does not pace.
+type Pacer struct {
+	mu        sync.Mutex
+	rate      float64 // allowed messages per second
+	maxRate   float64
+	tokens    float64
+	last      time.Time // last token refill
+	slowStart bool      // grow multiplicatively until the first back-off
+	avg       time.Duration
+	baseline  time.Duration // lowest smoothed latency seen
+	measured  float64       // smoothed achieved messages per second
+	lastOK    time.Time
+	lastCut   time.Time
+	// frozenUntil is the end of the current freeze pause.
+	frozenUntil time.Time
+}
+
+// New returns a Pacer. maxRate caps the rate in messages per second
+// (0 = no cap).
+func New(maxRate float64) *Pacer {
+	if maxRate <= 0 {
+		maxRate = 1000
+	}
+	rate := startRate
+	if rate > maxRate {
+		rate = maxRate
+	}
+	return &Pacer{rate: rate, maxRate: maxRate, tokens: 1, last: time.Now(), slowStart: true}
+}
+
+// Rate returns the current rate in messages per second.
+func (p *Pacer) Rate() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rate
+}
+
+// Wait blocks until the next operation may start.
+func (p *Pacer) Wait(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		p.tokens += now.Sub(p.last).Seconds() * p.rate
+		if p.tokens > 1 {
+			p.tokens = 1 // no bursts: spacing is the point
+		}
+		p.last = now
+		if p.tokens >= 1 {
+			p.tokens--
+			p.mu.Unlock()
+			return nil
+		}
+		d := time.Duration((1 - p.tokens) / p.rate * float64(time.Second))
+		p.mu.Unlock()
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Observe feeds the outcome of one operation back into the rate and reports
+// whether it was throttled.
+func (p *Pacer) Observe(latency time.Duration, err error) bool {
+	return p.observe(latency, 1, err)
+}
+
+// observe is Observe for an operation that handled n messages.
+func (p *Pacer) observe(latency time.Duration, n int, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if IsThrottle(err) {
+		p.cut(now, 0.5)
+		p.tokens = 0
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	if !p.lastOK.IsZero() {
+		if gap := now.Sub(p.lastOK).Seconds(); gap > 0 {
+			p.measured = smooth(p.measured, float64(n)/gap)
+		}
+	}
+	p.lastOK = now
+	latency /= time.Duration(n)
+	if p.avg == 0 {
+		p.avg = latency
+	} else {
+		p.avg = time.Duration(smooth(float64(p.avg), float64(latency)))
+	}
+	if p.baseline == 0 || p.avg < p.baseline {
+		p.baseline = p.avg
+	}
+	switch {
+	case p.avg > 3*p.baseline && now.Sub(p.lastCut) > time.Second:
+		// Latency well above what this server showed before: it is queueing.
+		p.cut(now, 0.8)
+	case p.measured > 0 && p.rate > 1.5*p.measured:
+		// The pacer is not the bottleneck; growing further would only build
+		// up headroom that hammers the server once it speeds up.
+	case p.slowStart:
+		p.rate *= 1.1
+	default:
+		p.rate += 0.1
+	}
+	if p.rate > p.maxRate {
+		p.rate = p.maxRate
+	}
+	return false
+}
+
+// cut lowers the rate to factor times what was actually achieved.
+func (p *Pacer) cut(now time.Time, factor float64) {
+	r := p.rate
+	if p.measured > 0 && p.measured < r {
+		r = p.measured
+	}
+	p.rate = r * factor
+	if p.rate < minRate {
+		p.rate = minRate
+	}
+	p.slowStart = false
+	p.lastCut = now
+}
+
+// Do runs op paced, retrying it when the server throttles, after a sleep
+// that doubles with every attempt (1s, 2s, 4s, ... up to a minute). When the account
+// is frozen, Do pauses for as long as the limit suggests and tries again;
+// these pauses do not count as attempts. A nil Pacer runs op once.
+func (p *Pacer) Do(ctx context.Context, op func() error) error {
+	return p.DoN(ctx, 1, op)
+}
+
+// DoN is Do for an operation that handles n messages at once, such as a
+// batched APPEND: it waits for n tokens, and its latency and throughput
+// count per message.
+func (p *Pacer) DoN(ctx context.Context, n int, op func() error) error {
+	if p == nil {
+		return op()
+	}
+	n = max(n, 1)
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		for i := 0; i < n; i++ {
+			if werr := p.Wait(ctx); werr != nil {
+				return werr
+			}
+		}
+		start := time.Now()
+		err = op()
+		if f, ok := DetectFreeze(err); ok {
+			if werr := p.pause(ctx, f, err); werr != nil {
+				return werr
+			}
+			attempt--
+			continue
+		}
+		if !p.observe(time.Since(start), n, err) || attempt == maxAttempts {
+			return err
+		}
+		d := min(backoff<<(attempt-1), maxBackoff)
+		log.Printf("[throttled] %v; retrying in %s", err, d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// pause holds the caller for f.Pause and logs the time left every minute.
+// Concurrent callers that hit the same freeze share its end time.
+func (p *Pacer) pause(ctx context.Context, f Freeze, err error) error {
+	p.mu.Lock()
+	if time.Now().After(p.frozenUntil) {
+		p.frozenUntil = time.Now().Add(f.Pause)
+		log.Printf("[paused] %v", err)
+		log.Printf("[paused] %s", f)
+	}
+	until := p.frozenUntil
+	p.rate = minRate
+	p.slowStart = false
+	p.mu.Unlock()
+	for {
+		left := time.Until(until)
+		if left <= 0 {
+			// the pause itself spaced out the retry
+			p.mu.Lock()
+			p.tokens, p.last = 1, time.Now()
+			p.mu.Unlock()
+			return nil
+		}
+		log.Printf("[paused] resuming in %s", left.Round(time.Second))
+		step := time.Minute
+		if left < step {
+			step = left
+		}
+		select {
+		case <-time.After(step):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func smooth(avg, sample float64) float64 {
+	if avg == 0 {
+		return sample
+	}
+	return 0.8*avg + 0.2*sample
+}
@@ -0,0 +1,111 @@
+package pacer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsThrottle(t *testing.T) {
+	for _, msg := range []string{
+		"[LIMIT] Too many commands", "Rate limit hit, try again later", "Server busy",
+		"[THROTTLED] Request is throttled", "APPEND failed: 4.3.2 Mailbox busy", "Server Unavailable. 15",
+	} {
+		if !IsThrottle(errors.New(msg)) {
+			t.Errorf("expected %q to be a throttle reply", msg)
+		}
+	}
+	for _, err := range []error{nil, errors.New("Mailbox does not exist"), errors.New("[OVERQUOTA] Quota exceeded"), errors.New("Mailbox v14.2.1 does not exist")} {
+		if IsThrottle(err) {
+			t.Errorf("did not expect %v to be a throttle reply", err)
+		}
+	}
+}
+
+func TestDetectFreeze(t *testing.T) {
+	f, ok := DetectFreeze(errors.New("[OVERQUOTA] Account exceeded command or bandwidth limits. (Failure)"))
+	if !ok || f.Provider != "Gmail" || f.Pause != time.Hour {
+		t.Fatalf("expected the Gmail bandwidth limit, got %+v %v", f, ok)
+	}
+	if IsThrottle(errors.New("[OVERQUOTA] Account exceeded command or bandwidth limits.")) {
+		t.Errorf("a freeze must not be retried as a throttle")
+	}
+	for _, err := range []error{nil, errors.New("Too many requests"), errors.New("[OVERQUOTA] Quota exceeded")} {
+		if _, ok := DetectFreeze(err); ok {
+			t.Errorf("did not expect %v to be a freeze", err)
+		}
+	}
+}
+
+func TestDoPausesOnFreeze(t *testing.T) {
+	saved := gmailConnections
+	defer func() { freezeHints[2].freeze = saved }()
+	freezeHints[2].freeze.Pause = 50 * time.Millisecond
+	p := New(0)
+	calls := 0
+	start := time.Now()
+	err := p.Do(context.Background(), func() error {
+		calls++
+		if calls <= maxAttempts {
+			return errors.New("Too many simultaneous connections. (Failure)")
+		}
+		return nil
+	})
+	if err != nil || calls != maxAttempts+1 {
+		t.Fatalf("expected success after the pauses, got err=%v calls=%d", err, calls)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("expected Do to pause")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New(0).Do(ctx, func() error { return errors.New("Too many simultaneous connections") }); err == nil {
+		t.Fatal("expected the canceled pause to return an error")
+	}
+}
+
+func TestPacerAdapts(t *testing.T) {
+	p := New(0)
+	start := p.Rate()
+	p.Observe(10*time.Millisecond, nil)
+	if p.Rate() <= start {
+		t.Fatalf("expected rate to grow after success, got %.2f", p.Rate())
+	}
+	before := p.Rate()
+	if !p.Observe(10*time.Millisecond, errors.New("Too many requests")) {
+		t.Fatalf("expected throttle to be reported")
+	}
+	if p.Rate() > before/2+0.001 {
+		t.Fatalf("expected rate to halve, got %.2f from %.2f", p.Rate(), before)
+	}
+	if p := New(2); p.Rate() != 2 {
+		t.Fatalf("expected start rate capped to 2, got %.2f", p.Rate())
+	}
+}
+
+func TestDoRetriesThrottled(t *testing.T) {
+	defer func(saved time.Duration) { backoff = saved }(backoff)
+	backoff = 20 * time.Millisecond
+	p := New(0)
+	calls := 0
+	start := time.Now()
+	err := p.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("[THROTTLED] please slow down")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected success on the third call, got err=%v calls=%d", err, calls)
+	}
+	// backs off 20ms, then 40ms
+	if d := time.Since(start); d < 60*time.Millisecond {
+		t.Errorf("expected Do to back off between retries, took %s", d)
+	}
+	var nilPacer *Pacer
+	if err := nilPacer.Do(context.Background(), func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+// Package quarantine stages destination messages that a mirror run would
+// delete. Instead of being expunged they are moved to a dated folder below
+// gomap-quarantine, keeping the name of the mailbox they came from, and
+// purged once the retention window has passed. A deletion on the source
+// made by mistake can so be undone by moving the messages back.
+package quarantine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+)
+
+// Folder is the top-level folder that holds the quarantine.
+const Folder = "gomap-quarantine"
+
+const dayLayout = "2006-01-02"
+
+// Contains reports whether mailbox is the quarantine or one of its
+// folders. Sync runs leave them alone.
+func Contains(mailbox, delim string) bool {
+	return mailbox == Folder || strings.HasPrefix(mailbox, Folder+delim)
+}
+
+// Path returns the folder that receives the messages removed from mailbox
+// on day, e.g. gomap-quarantine/2024-10-12/Archive/2023.
+func Path(mailbox, delim string, day time.Time) string {
+	return Folder + delim + day.Format(dayLayout) + delim + mailbox
+}
+
+// Stage moves the messages with uids from mailbox into its quarantine
+// folder for now and returns that folder.
+func Stage(c *client.Client, delim, mailbox string, uids []uint32, now time.Time) (string, error) {
+	if len(uids) == 0 {
+		return "", nil
+	}
+	dest := Path(mailbox, delim, now)
+	if err := imaputil.EnsureMailbox(c, dest); err != nil {
+		return "", fmt.Errorf("create %s: %w", dest, err)
+	}
+	if _, err := imaputil.SelectMailbox(c, mailbox, false); err != nil {
+		return "", fmt.Errorf("select %s: %w", mailbox, err)
+	}
+	if err := imaputil.MoveUIDs(c, uids, dest); err != nil {
+		return "", fmt.Errorf("move to %s: %w", dest, err)
+	}
+	return dest, nil
+}
+
+// Expired returns the quarantine folders among boxes whose day is older
+// than retention, children before their parents so they can be deleted in
+// order. Folders with an unparseable day are never returned.
+func Expired(boxes []string, delim string, retention time.Duration, now time.Time) []string {
+	var out []string
+	for _, b := range boxes {
+		rest, ok := strings.CutPrefix(b, Folder+delim)
+		if !ok {
+			continue
+		}
+		day, _, _ := strings.Cut(rest, delim)
+		t, err := time.ParseInLocation(dayLayout, day, now.Location())
+		if err != nil {
+			continue
+		}
+		// a day's folder expires once its last moment is older than retention
+		if now.Sub(t.AddDate(0, 0, 1)) > retention {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if strings.Count(out[i], delim) != strings.Count(out[j], delim) {
+			return strings.Count(out[i], delim) > strings.Count(out[j], delim)
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// Purge deletes the quarantine folders older than retention and returns
+// their names.
+func Purge(c *client.Client, delim string, boxes []string, retention time.Duration, now time.Time) ([]string, error) {
+	var deleted []string
+	for _, b := range Expired(boxes, delim, retention, now) {
+		if err := c.Delete(b); err != nil {
+			return deleted, fmt.Errorf("delete %s: %w", b, err)
+		}
+		deleted = append(deleted, b)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,38 @@
+package quarantine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2024, 10, 12, 9, 0, 0, 0, time.UTC)
+	boxes := []string{
+		"INBOX",
+		"gomap-quarantine",
+		"gomap-quarantine/2024-09-01",
+		"gomap-quarantine/2024-09-01/INBOX",
+		"gomap-quarantine/2024-09-01/Archive/2023",
+		"gomap-quarantine/2024-10-05",
+		"gomap-quarantine/2024-10-05/INBOX",
+		"gomap-quarantine/restored",
+		"gomap-quarantined/2024-01-01",
+	}
+	got := Expired(boxes, "/", 30*24*time.Hour, now)
+	want := []string{
+		"gomap-quarantine/2024-09-01/Archive/2023",
+		"gomap-quarantine/2024-09-01/INBOX",
+		"gomap-quarantine/2024-09-01",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expired = %v, want %v", got, want)
+	}
+
+	if p := Path("Archive.2023", ".", now); p != "gomap-quarantine.2024-10-12.Archive.2023" {
+		t.Errorf("Path = %q", p)
+	}
+	if !Contains("gomap-quarantine.2024-10-12.INBOX", ".") || Contains("gomap-quarantined", ".") {
+		t.Error("Contains matched the wrong folders")
+	}
+}
@@ -0,0 +1,144 @@
+// Package quirks recognises IMAP providers by their greeting, capabilities
+// and host name and describes the workarounds gomap applies for them. The
+// registry only describes; the commands apply a Profile's settings.
+package quirks
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Quirk is one known provider behaviour and its workaround.
+type Quirk struct {
+	Name        string // short identifier, e.g. "gmail-labels"
+	Description string // what gomap does about it
+}
+
+// Profile is what the registry knows about one server.
+type Profile struct {
+	Provider string // "" for servers without known quirks
+	Quirks   []Quirk
+
+	// SkipFolders matches folders that only show messages stored in other
+	// folders too (Gmail's label views); copying them duplicates mail.
+	SkipFolders *regexp.Regexp
+	// MaxRate caps appends per second when the user set no --max-rate.
+	MaxRate float64
+	// FetchRFC822 fetches messages as RFC822 instead of BODY[].
+	FetchRFC822 bool
+	// Roles maps provider-specific special folder names to the role they
+	// play: "drafts", "junk", "sent" or "trash". The special-use
+	// attributes a server reports are added by WithSpecialUse.
+	Roles map[string]string
+}
+
+// Has reports whether p includes the quirk name.
+func (p Profile) Has(name string) bool {
+	for _, q := range p.Quirks {
+		if q.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Role returns the special-folder role of mailbox on this provider, ""
+// if it has none beyond the common names.
+func (p Profile) Role(mailbox string) string {
+	return p.Roles[mailbox]
+}
+
+// specialUseRoles are the roles of the special-use attributes (RFC 6154).
+var specialUseRoles = map[string]string{
+	`\Drafts`: "drafts",
+	`\Junk`:   "junk",
+	`\Sent`:   "sent",
+	`\Trash`:  "trash",
+}
+
+// WithSpecialUse returns p with the roles of the mailboxes the server
+// marks with a special-use attribute added; uses maps mailbox names to
+// the attribute. The server's marks win over the provider's names.
+func (p Profile) WithSpecialUse(uses map[string]string) Profile {
+	if len(uses) == 0 {
+		return p
+	}
+	roles := make(map[string]string, len(p.Roles)+len(uses))
+	for name, role := range p.Roles {
+		roles[name] = role
+	}
+	for name, attr := range uses {
+		if role, ok := specialUseRoles[attr]; ok {
+			roles[name] = role
+		}
+	}
+	p.Roles = roles
+	return p
+}
+
+// Folder returns the mailbox that plays role on this server, "" if none
+// is known. Of several, the first by name is returned.
+func (p Profile) Folder(role string) string {
+	var names []string
+	for name, r := range p.Roles {
+		if r == role {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+var (
+	gmailLabels = Quirk{
+		Name:        "gmail-labels",
+		Description: "Gmail shows every label as a folder and all mail again in All Mail, Important and Starred; those views are skipped unless --include names them",
+	}
+	office365Throttling = Quirk{
+		Name:        "office365-throttling",
+		Description: "Office 365 throttles bursts of APPENDs per mailbox; the append rate is capped at 4 messages per second unless --max-rate is set",
+	}
+	yahooFolders = Quirk{
+		Name:        "yahoo-folders",
+		Description: "Yahoo names its special folders Draft and Bulk; they count as Drafts and Junk for --skip-* and --junk-as",
+	}
+	exchangeBody = Quirk{
+		Name:        "exchange-body",
+		Description: "Exchange can return BODY[] of messages with broken MIME structure re-encoded or cut short; messages are fetched as RFC822 instead",
+	}
+)
+
+// gmailViews are Gmail's label views, in the English and German UI.
+var gmailViews = regexp.MustCompile(`^\[(Gmail|Google Mail)\]/(All Mail|Important|Starred|Alle Nachrichten|Wichtig|Markiert)$`)
+
+// Detect returns the profile of the server at host that sent greeting and
+// announces caps.
+func Detect(host, greeting string, caps map[string]bool) Profile {
+	host = strings.ToLower(host)
+	g := strings.ToLower(greeting)
+	switch {
+	case caps["X-GM-EXT-1"] || strings.Contains(g, "gimap") || hostIn(host, "gmail.com", "googlemail.com"):
+		return Profile{Provider: "Gmail", Quirks: []Quirk{gmailLabels}, SkipFolders: gmailViews}
+	case hostIn(host, "office365.com", "outlook.com") || strings.Contains(g, "outlook.office365.com"):
+		return Profile{Provider: "Office 365", Quirks: []Quirk{office365Throttling, exchangeBody}, MaxRate: 4, FetchRFC822: true}
+	case strings.Contains(g, "microsoft exchange"):
+		return Profile{Provider: "Exchange", Quirks: []Quirk{exchangeBody}, FetchRFC822: true}
+	case hostIn(host, "yahoo.com", "aol.com") || strings.Contains(g, "yahoo"):
+		return Profile{Provider: "Yahoo", Quirks: []Quirk{yahooFolders}, Roles: map[string]string{"Draft": "drafts", "Bulk": "junk"}}
+	}
+	return Profile{}
+}
+
+// hostIn reports whether host is one of domains or a subdomain of one.
+func hostIn(host string, domains ...string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package quirks
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		host, greeting string
+		caps           map[string]bool
+		provider       string
+	}{
+		{"imap.gmail.com", "Gimap ready for requests from 192.0.2.1", nil, "Gmail"},
+		{"mail.example.org", "IMAP4rev1 ready", map[string]bool{"X-GM-EXT-1": true}, "Gmail"},
+		{"outlook.office365.com", "The Microsoft Exchange IMAP4 service is ready.", nil, "Office 365"},
+		{"mail.corp.example", "The Microsoft Exchange IMAP4 service is ready.", nil, "Exchange"},
+		{"imap.mail.yahoo.com", "IMAP4rev1 imapgate ready", nil, "Yahoo"},
+		{"notyahoo.com.example", "Dovecot ready.", nil, ""},
+	}
+	for _, tt := range tests {
+		if p := Detect(tt.host, tt.greeting, tt.caps); p.Provider != tt.provider {
+			t.Errorf("Detect(%q, %q) = %q, want %q", tt.host, tt.greeting, p.Provider, tt.provider)
+		}
+	}
+
+	gmail := Detect("imap.gmail.com", "", nil)
+	if !gmail.Has("gmail-labels") || !gmail.SkipFolders.MatchString("[Gmail]/All Mail") || gmail.SkipFolders.MatchString("[Gmail]/Sent Mail") {
+		t.Errorf("unexpected Gmail profile %+v", gmail)
+	}
+	if yahoo := Detect("imap.mail.yahoo.com", "", nil); yahoo.Role("Bulk") != "junk" || yahoo.Role("INBOX") != "" {
+		t.Errorf("unexpected Yahoo roles %v", yahoo.Roles)
+	}
+}
+
+func TestWithSpecialUse(t *testing.T) {
+	yahoo := Detect("imap.mail.yahoo.com", "", nil)
+	p := yahoo.WithSpecialUse(map[string]string{"Corbeille": `\Trash`, "Bulk": `\Sent`, "Archives": `\Archive`})
+	if p.Role("Corbeille") != "trash" || p.Role("Bulk") != "sent" || p.Role("Draft") != "drafts" || p.Role("Archives") != "" {
+		t.Errorf("unexpected roles %v", p.Roles)
+	}
+	if yahoo.Role("Bulk") != "junk" {
+		t.Errorf("WithSpecialUse changed the provider profile: %v", yahoo.Roles)
+	}
+	if p.Folder("trash") != "Corbeille" || p.Folder("junk") != "" {
+		t.Errorf("Folder(trash) = %q, Folder(junk) = %q", p.Folder("trash"), p.Folder("junk"))
+	}
+}
@@ -0,0 +1,277 @@
+// Package runlog stores a summary of each copy run (folders, their sizes,
+// copied messages, errors) so runs can be compared later.
+package runlog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Summary is the outcome of one run.
+type Summary struct {
+	mu          sync.Mutex
+	ID          string                     `json:"id"`
+	Started     time.Time                  `json:"started"`
+	Finished    time.Time                  `json:"finished"`
+	Source      string                     `json:"source,omitempty"`
+	Destination string                     `json:"destination,omitempty"`
+	Mailboxes   map[string]*MailboxSummary `json:"mailboxes"`
+	Errors      []string                   `json:"errors,omitempty"`
+}
+
+// MailboxSummary describes one source mailbox in a run.
+type MailboxSummary struct {
+	Messages uint32 `json:"messages"` // size of the source mailbox
+	Copied   int    `json:"copied"`
+	// Destination is set when the destination mailbox got a shortened
+	// name to fit the server's limits.
+	Destination string `json:"destination,omitempty"`
+	// Oversized lists the UIDs of messages skipped for exceeding the
+	// maximum message size.
+	Oversized []uint32 `json:"oversized,omitempty"`
+	// Failed lists the messages that could not be copied in a run that
+	// went on past them (copy --continue-on-error).
+	Failed []Failure `json:"failed,omitempty"`
+}
+
+// Failure is a message that could not be copied, and why.
+type Failure struct {
+	Mailbox string `json:"mailbox"`
+	UID     uint32 `json:"uid"`
+	Error   string `json:"error"`
+}
+
+// New starts the summary of a run that begins now. Its ID is the start
+// time in UTC, so IDs sort by time.
+func New(source, destination string) *Summary {
+	now := time.Now()
+	return &Summary{
+		ID:          now.UTC().Format("20060102T150405Z"),
+		Started:     now,
+		Source:      source,
+		Destination: destination,
+		Mailboxes:   map[string]*MailboxSummary{},
+	}
+}
+
+func (s *Summary) mailbox(name string) *MailboxSummary {
+	mb := s.Mailboxes[name]
+	if mb == nil {
+		mb = &MailboxSummary{}
+		s.Mailboxes[name] = mb
+	}
+	return mb
+}
+
+// Selected records the size of a source mailbox. It is safe for
+// concurrent use, like Copied.
+func (s *Summary) Selected(name string, messages uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mailbox(name).Messages = messages
+}
+
+// Copied counts a message copied from a source mailbox.
+func (s *Summary) Copied(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mailbox(name).Copied++
+}
+
+// Renamed records that the destination of a source mailbox was shortened
+// to dst.
+func (s *Summary) Renamed(name, dst string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mailbox(name).Destination = dst
+}
+
+// Skipped records a message of a source mailbox that was skipped for
+// its size.
+func (s *Summary) Skipped(name string, uid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mb := s.mailbox(name)
+	mb.Oversized = append(mb.Oversized, uid)
+}
+
+// Failed records a message of a source mailbox that could not be copied.
+func (s *Summary) Failed(name string, uid uint32, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mb := s.mailbox(name)
+	mb.Failed = append(mb.Failed, Failure{Mailbox: name, UID: uid, Error: err.Error()})
+}
+
+// Failures returns the messages recorded with Failed, by mailbox and UID.
+func (s *Summary) Failures() []Failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Failure
+	for _, mb := range s.Mailboxes {
+		out = append(out, mb.Failed...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Mailbox != out[j].Mailbox {
+			return out[i].Mailbox < out[j].Mailbox
+		}
+		return out[i].UID < out[j].UID
+	})
+	return out
+}
+
+// WriteFailures writes failures as a report: CSV with a header line if
+// csvFormat is set, else a JSON array.
+func WriteFailures(w io.Writer, failures []Failure, csvFormat bool) error {
+	if !csvFormat {
+		if failures == nil {
+			failures = []Failure{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(failures)
+	}
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"mailbox", "uid", "error"})
+	for _, f := range failures {
+		_ = cw.Write([]string{f.Mailbox, strconv.FormatUint(uint64(f.UID), 10), f.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Finish sets the end time and the errors of the run.
+func (s *Summary) Finish(errs []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Finished = time.Now()
+	for _, err := range errs {
+		s.Errors = append(s.Errors, err.Error())
+	}
+}
+
+// Name is the artifact name of the summary.
+func (s *Summary) Name() string {
+	return s.ID + ".json"
+}
+
+// Marshal encodes the summary as JSON.
+func (s *Summary) Marshal() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// Parse decodes a summary written by Marshal.
+func Parse(b []byte) (*Summary, error) {
+	s := &Summary{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.Mailboxes == nil {
+		s.Mailboxes = map[string]*MailboxSummary{}
+	}
+	return s, nil
+}
+
+// Resolve picks the run ref from the sorted run IDs: an exact ID or a
+// unique ID prefix (such as a date, "20241005").
+func Resolve(ids []string, ref string) (string, error) {
+	var match []string
+	for _, id := range ids {
+		if id == ref {
+			return id, nil
+		}
+		if strings.HasPrefix(id, ref) {
+			match = append(match, id)
+		}
+	}
+	switch len(match) {
+	case 0:
+		return "", fmt.Errorf("no run %q", ref)
+	case 1:
+		return match[0], nil
+	}
+	return "", fmt.Errorf("run %q is ambiguous (%s)", ref, strings.Join(match, ", "))
+}
+
+// Diff is what changed from run A to run B.
+type Diff struct {
+	A, B           *Summary
+	NewFolders     []string // in B only
+	RemovedFolders []string // in A only
+	Changed        []FolderDelta
+	NewErrors      []string // errors of B that A did not have
+	ResolvedErrors []string // errors of A that B does not have
+}
+
+// FolderDelta compares a mailbox present in both runs.
+type FolderDelta struct {
+	Mailbox          string
+	Before, After    uint32 // source mailbox size
+	CopiedA, CopiedB int
+}
+
+// Delta is the change of the source mailbox size.
+func (d FolderDelta) Delta() int {
+	return int(d.After) - int(d.Before)
+}
+
+// Compare returns the differences between runs a and b. Changed lists
+// the mailboxes whose size or copy count differs, shrinking ones first.
+func Compare(a, b *Summary) *Diff {
+	d := &Diff{A: a, B: b}
+	for name, mb := range b.Mailboxes {
+		before, ok := a.Mailboxes[name]
+		if !ok {
+			d.NewFolders = append(d.NewFolders, name)
+			continue
+		}
+		if before.Messages == mb.Messages && before.Copied == mb.Copied {
+			continue
+		}
+		d.Changed = append(d.Changed, FolderDelta{Mailbox: name, Before: before.Messages, After: mb.Messages, CopiedA: before.Copied, CopiedB: mb.Copied})
+	}
+	for name := range a.Mailboxes {
+		if _, ok := b.Mailboxes[name]; !ok {
+			d.RemovedFolders = append(d.RemovedFolders, name)
+		}
+	}
+	sort.Strings(d.NewFolders)
+	sort.Strings(d.RemovedFolders)
+	sort.Slice(d.Changed, func(i, j int) bool {
+		di, dj := d.Changed[i].Delta(), d.Changed[j].Delta()
+		if (di < 0) != (dj < 0) {
+			return di < 0
+		}
+		if di < 0 && di != dj {
+			return di < dj
+		}
+		return d.Changed[i].Mailbox < d.Changed[j].Mailbox
+	})
+	d.NewErrors = missing(b.Errors, a.Errors)
+	d.ResolvedErrors = missing(a.Errors, b.Errors)
+	return d
+}
+
+// missing returns the entries of list that are not in other.
+func missing(list, other []string) []string {
+	have := map[string]bool{}
+	for _, e := range other {
+		have[e] = true
+	}
+	var out []string
+	for _, e := range list {
+		if !have[e] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
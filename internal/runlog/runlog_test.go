@@ -0,0 +1,83 @@
+package runlog
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	a := New("src", "dst")
+	a.ID = "20241005T030000Z"
+	a.Selected("INBOX", 100)
+	a.Selected("Archive", 5000)
+	a.Selected("Old", 7)
+	a.Selected("Sent", 40)
+	a.Copied("INBOX")
+	a.Finish([]error{errors.New("Sent: append failed")})
+	b := New("src", "dst")
+	b.ID = "20241012T030000Z"
+	b.Selected("INBOX", 110)
+	b.Selected("Archive", 3000)
+	b.Selected("Sent", 40)
+	b.Selected("Projects", 12)
+	for i := 0; i < 10; i++ {
+		b.Copied("INBOX")
+	}
+	b.Finish([]error{errors.New("Projects: connection reset")})
+
+	ids := []string{a.ID, b.ID}
+	if _, err := Resolve(ids, "2024"); err == nil {
+		t.Error("Resolve(ambiguous prefix) succeeded")
+	}
+	if id, err := Resolve(ids, "20241005"); err != nil || id != a.ID {
+		t.Errorf("Resolve(prefix) = %q, %v", id, err)
+	}
+	roundTrip := func(s *Summary) *Summary {
+		data, err := s.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	ra, rb := roundTrip(a), roundTrip(b)
+
+	d := Compare(ra, rb)
+	if !reflect.DeepEqual(d.NewFolders, []string{"Projects"}) || !reflect.DeepEqual(d.RemovedFolders, []string{"Old"}) {
+		t.Errorf("new %v, removed %v", d.NewFolders, d.RemovedFolders)
+	}
+	want := []FolderDelta{
+		{Mailbox: "Archive", Before: 5000, After: 3000},
+		{Mailbox: "INBOX", Before: 100, After: 110, CopiedA: 1, CopiedB: 10},
+	}
+	if !reflect.DeepEqual(d.Changed, want) {
+		t.Errorf("Changed = %+v, want %+v", d.Changed, want)
+	}
+	if !reflect.DeepEqual(d.NewErrors, []string{"Projects: connection reset"}) || !reflect.DeepEqual(d.ResolvedErrors, []string{"Sent: append failed"}) {
+		t.Errorf("new errors %v, resolved %v", d.NewErrors, d.ResolvedErrors)
+	}
+}
+
+func TestWriteFailures(t *testing.T) {
+	s := New("src", "dst")
+	s.Failed("Sent", 9, errors.New("NO [TOOBIG] too big"))
+	s.Failed("INBOX", 42, errors.New(`NO bad "From" header`))
+	s.Failed("INBOX", 7, errors.New("NO rejected"))
+	var b bytes.Buffer
+	if err := WriteFailures(&b, s.Failures(), true); err != nil {
+		t.Fatal(err)
+	}
+	want := "mailbox,uid,error\nINBOX,7,NO rejected\nINBOX,42,\"NO bad \"\"From\"\" header\"\nSent,9,NO [TOOBIG] too big\n"
+	if b.String() != want {
+		t.Errorf("CSV report:\n%s\nwant:\n%s", b.String(), want)
+	}
+	b.Reset()
+	if err := WriteFailures(&b, nil, false); err != nil || b.String() != "[]\n" {
+		t.Errorf("empty JSON report %q, %v", b.String(), err)
+	}
+}
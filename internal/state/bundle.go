@@ -0,0 +1,74 @@
+package state
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BundleVersion is the current bundle format version.
+const BundleVersion = 1
+
+// Bundle is a portable snapshot of a migration's resume state, used to hand
+// an interrupted run over to another machine.
+type Bundle struct {
+	Version      int       `json:"version"`
+	Created      time.Time `json:"created"`
+	GomapVersion string    `json:"gomap_version"`
+	// ConfigFingerprint identifies the accounts in the exporting machine's
+	// config file (hosts and users, never passwords). Empty without config.
+	ConfigFingerprint string `json:"config_fingerprint,omitempty"`
+	State             *State `json:"state"`
+	// MboxSources describes the MBOX files referenced by State.MboxOffsets,
+	// keyed like MboxOffsets, so the importer can verify its copies.
+	MboxSources map[string]MboxSource `json:"mbox_sources,omitempty"`
+}
+
+// MboxSource fingerprints the part of an MBOX file that was already copied.
+type MboxSource struct {
+	Path string `json:"path"`
+	// TailSHA256 is the SHA-256 of the (decompressed) bytes right before the
+	// stored offset; TailLen is their length.
+	TailSHA256 string `json:"tail_sha256,omitempty"`
+	TailLen    int64  `json:"tail_len,omitempty"`
+}
+
+// WriteBundle writes b as gzip-compressed JSON.
+func WriteBundle(w io.Writer, b *Bundle) error {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(b); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadBundle reads a bundle written by WriteBundle.
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gomap state bundle: %w", err)
+	}
+	defer zr.Close()
+	b := &Bundle{}
+	if err := json.NewDecoder(zr).Decode(b); err != nil {
+		return nil, fmt.Errorf("decode bundle: %w", err)
+	}
+	if b.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if b.State == nil {
+		b.State = &State{}
+	}
+	if b.State.MailMax == nil {
+		b.State.MailMax = make(map[string]uint32)
+	}
+	if b.State.MboxOffsets == nil {
+		b.State.MboxOffsets = make(map[string]int64)
+	}
+	return b, nil
+}
@@ -4,18 +4,104 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"sort"
 	"sync"
 )
 
-// State tracks per-mailbox highest copied UID or a set of completed UIDs.
-// Simple implementation: highest UID per mailbox.
+// State tracks per-mailbox highest copied UID, plus the UIDs above it that
+// were completed out of order.
 
 type State struct {
 	mu      sync.Mutex
 	MailMax map[string]uint32 `json:"mail_max_uid"`
+	// UIDValidity stores the source UIDVALIDITY that MailMax, Windows and
+	// Done of a mailbox refer to.
+	UIDValidity map[string]uint32 `json:"uidvalidity,omitempty"`
+	// Done holds, per mailbox, the UIDs copied that MailMax (or the
+	// backfill floor) does not cover yet: messages confirmed while a lower
+	// one was still pending. They are skipped on resume. UIDs are dropped
+	// once MailMax covers them.
+	Done map[string]*UIDSet `json:"done_uids,omitempty"`
 	// MboxOffsets stores processed byte offsets for MBOX sources keyed by
 	// a composite identifier (e.g., "mbox:/abs/path|dst:MailboxName").
 	MboxOffsets map[string]int64 `json:"mbox_offsets"`
+	// MaildirMarks stores, per Maildir source folder and destination
+	// mailbox, the modification time (Unix nanoseconds) of the newest
+	// message copied so far.
+	MaildirMarks map[string]int64 `json:"maildir_marks,omitempty"`
+	// EmlMaxUID stores, per single-file backup folder and destination
+	// mailbox, the highest UID (file name <uid>.eml) restored so far.
+	EmlMaxUID map[string]uint32 `json:"eml_max_uid,omitempty"`
+	// MsgMarks stores, per folder of Outlook .msg files and destination
+	// mailbox, the name of the last file copied (files go in name order).
+	MsgMarks map[string]string `json:"msg_marks,omitempty"`
+	// GmailDone stores, per Gmail label and destination mailbox (copy
+	// --src-gmail-token), the IDs of the messages copied so far. Gmail
+	// lists messages newest first, so there is no mark to keep instead.
+	GmailDone map[string][]string `json:"gmail_done,omitempty"`
+	// Windows holds per-window checkpoints for initial copies that are split
+	// into date windows, keyed by mailbox and window label (e.g. "2023", or
+	// "2023-04" for monthly windows).
+	// Entries are removed once all windows of a mailbox are complete.
+	Windows map[string]map[string]WindowState `json:"windows,omitempty"`
+	// Backfill holds, per mailbox copied newest first (copy --newest-first),
+	// the lowest UID copied so far: the UIDs from it up to MailMax are
+	// copied, the ones below it are not yet. Entries are removed once
+	// nothing is left below.
+	Backfill map[string]uint32 `json:"backfill,omitempty"`
+	// MessageIDs caches the Message-IDs found in destination mailboxes for
+	// --dedup message-id, so re-runs only fetch messages added since.
+	MessageIDs map[string]*MessageIDIndex `json:"message_ids,omitempty"`
+	// Mirror holds the full message set of source mailboxes synced with
+	// --delete, so messages removed since the last run can be found.
+	Mirror map[string]*MirrorSet `json:"mirror,omitempty"`
+	// ModSeqs holds the mailbox status of CONDSTORE servers at the end of
+	// the last sync run, keyed like the sync Message-ID indexes, so
+	// unchanged mailboxes can be skipped and flag changes fetched.
+	ModSeqs map[string]ModSeqMark `json:"modseq,omitempty"`
+	// UIDMap maps, per source mailbox, the UIDs of copied messages to the
+	// UIDs the destination gave them, as reported in APPENDUID replies
+	// (UIDPLUS). It is valid for the source UIDVALIDITY in UIDValidity.
+	UIDMap map[string]*UIDMapping `json:"uid_map,omitempty"`
+}
+
+// UIDMapping is the UID map of one source mailbox, for one destination
+// mailbox and UIDVALIDITY.
+type UIDMapping struct {
+	DstMailbox     string            `json:"dst_mailbox"`
+	DstUIDValidity uint32            `json:"dst_uidvalidity"`
+	UIDs           map[uint32]uint32 `json:"uids"` // source UID -> destination UID
+}
+
+// ModSeqMark is the STATUS of a mailbox at the end of a run.
+type ModSeqMark struct {
+	UIDValidity   uint32 `json:"uidvalidity"`
+	UIDNext       uint32 `json:"uidnext"`
+	Messages      uint32 `json:"messages"`
+	HighestModSeq uint64 `json:"highestmodseq"`
+}
+
+// MirrorSet is the message set of one source mailbox at the end of the
+// last mirror run: the reconciliation key (usually the Message-ID) per
+// UID, valid for one UIDVALIDITY.
+type MirrorSet struct {
+	UIDValidity uint32            `json:"uidvalidity"`
+	Messages    map[uint32]string `json:"messages"`
+}
+
+// MessageIDIndex is the Message-ID cache of one destination mailbox. It is
+// valid for one UIDVALIDITY and covers the messages up to MaxUID.
+type MessageIDIndex struct {
+	UIDValidity uint32   `json:"uidvalidity"`
+	MaxUID      uint32   `json:"max_uid"`
+	IDs         []string `json:"ids"`
+	seen        map[string]bool
+}
+
+// WindowState is the checkpoint of a single date window.
+type WindowState struct {
+	MaxUID uint32 `json:"max_uid"`
+	Done   bool   `json:"done"`
 }
 
 func Load(path string) (*State, error) {
@@ -60,7 +146,100 @@ func (s *State) SetMaxUID(mailbox string, uid uint32) {
 	defer s.mu.Unlock()
 	if cur, ok := s.MailMax[mailbox]; !ok || uid > cur {
 		s.MailMax[mailbox] = uid
+		s.pruneDone(mailbox)
+	}
+}
+
+// MarkDone records that the message uid of mailbox is copied, whatever
+// the UIDs below it.
+func (s *State) MarkDone(mailbox string, uid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Done == nil {
+		s.Done = make(map[string]*UIDSet)
 	}
+	set := s.Done[mailbox]
+	if set == nil {
+		set = &UIDSet{}
+		s.Done[mailbox] = set
+	}
+	set.Add(uid)
+	s.pruneDone(mailbox)
+}
+
+// SkipDone returns uids without the ones recorded by MarkDone.
+func (s *State) SkipDone(mailbox string, uids []uint32) []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := s.Done[mailbox]
+	if set.Empty() {
+		return uids
+	}
+	out := make([]uint32, 0, len(uids))
+	for _, uid := range uids {
+		if !set.Contains(uid) {
+			out = append(out, uid)
+		}
+	}
+	return out
+}
+
+// pruneDone drops the done UIDs of mailbox that MailMax covers: all up to
+// it, or while backfilling only those from the backfill floor up.
+func (s *State) pruneDone(mailbox string) {
+	set := s.Done[mailbox]
+	if set == nil {
+		return
+	}
+	if maxUID := s.MailMax[mailbox]; maxUID > 0 {
+		lo := uint32(1)
+		if floor, ok := s.Backfill[mailbox]; ok {
+			lo = floor
+		}
+		if lo <= maxUID {
+			set.Remove(lo, maxUID)
+		}
+	}
+	if set.Empty() {
+		delete(s.Done, mailbox)
+	}
+}
+
+// GetUIDValidity returns the UIDVALIDITY the state of mailbox was built
+// for, 0 if unknown.
+func (s *State) GetUIDValidity(mailbox string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.UIDValidity[mailbox]
+}
+
+// CheckUIDValidity records the UIDVALIDITY of a source mailbox. When it
+// differs from the one the resume state was built for, the UIDs stored
+// for the mailbox (highest UID, done UIDs and date windows) no longer
+// mean anything: they are dropped and CheckUIDValidity returns the old
+// value and true.
+// State written before UIDVALIDITY was tracked is adopted as is, and a
+// zero UIDVALIDITY (the server sent none) is ignored.
+func (s *State) CheckUIDValidity(mailbox string, uidValidity uint32) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if uidValidity == 0 {
+		return s.UIDValidity[mailbox], false
+	}
+	if s.UIDValidity == nil {
+		s.UIDValidity = make(map[string]uint32)
+	}
+	old, ok := s.UIDValidity[mailbox]
+	s.UIDValidity[mailbox] = uidValidity
+	if !ok || old == uidValidity {
+		return old, false
+	}
+	delete(s.MailMax, mailbox)
+	delete(s.Done, mailbox)
+	delete(s.Windows, mailbox)
+	delete(s.Backfill, mailbox)
+	delete(s.UIDMap, mailbox)
+	return old, true
 }
 
 // MBOX helpers
@@ -78,3 +257,361 @@ func (s *State) SetMboxOffset(key string, off int64) {
 	}
 	s.MboxOffsets[key] = off
 }
+
+// Maildir helpers
+func (s *State) GetMaildirMark(key string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MaildirMarks[key]
+}
+
+func (s *State) SetMaildirMark(key string, mark int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MaildirMarks == nil {
+		s.MaildirMarks = make(map[string]int64)
+	}
+	if mark > s.MaildirMarks[key] {
+		s.MaildirMarks[key] = mark
+	}
+}
+
+// Single-file (.eml) helpers
+func (s *State) GetEmlMaxUID(key string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.EmlMaxUID[key]
+}
+
+func (s *State) SetEmlMaxUID(key string, uid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.EmlMaxUID == nil {
+		s.EmlMaxUID = make(map[string]uint32)
+	}
+	if uid > s.EmlMaxUID[key] {
+		s.EmlMaxUID[key] = uid
+	}
+}
+
+// Outlook .msg helpers
+func (s *State) GetMsgMark(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MsgMarks[key]
+}
+
+func (s *State) SetMsgMark(key, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MsgMarks == nil {
+		s.MsgMarks = make(map[string]string)
+	}
+	if name > s.MsgMarks[key] {
+		s.MsgMarks[key] = name
+	}
+}
+
+// Gmail API helpers
+
+// GmailDoneIDs returns the IDs of the messages of key copied so far.
+func (s *State) GmailDoneIDs(key string) map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	done := make(map[string]bool, len(s.GmailDone[key]))
+	for _, id := range s.GmailDone[key] {
+		done[id] = true
+	}
+	return done
+}
+
+// AddGmailDone records the message id of key as copied.
+func (s *State) AddGmailDone(key, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.GmailDone == nil {
+		s.GmailDone = make(map[string][]string)
+	}
+	s.GmailDone[key] = append(s.GmailDone[key], id)
+}
+
+// Message-ID index helpers
+
+// MessageIDScan returns how far the index of mailbox reaches: the
+// highest UID scanned, or 0 when the index is missing or was built for
+// another UIDVALIDITY (then it is dropped).
+func (s *State) MessageIDScan(mailbox string, uidValidity uint32) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.MessageIDs[mailbox]
+	if idx == nil {
+		return 0
+	}
+	if idx.UIDValidity != uidValidity {
+		delete(s.MessageIDs, mailbox)
+		return 0
+	}
+	return idx.MaxUID
+}
+
+// AddScannedMessageIDs records Message-IDs fetched from mailbox, up to UID
+// maxUID.
+func (s *State) AddScannedMessageIDs(mailbox string, uidValidity, maxUID uint32, ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.messageIDIndex(mailbox, uidValidity)
+	for _, id := range ids {
+		if !idx.seen[id] {
+			idx.seen[id] = true
+			idx.IDs = append(idx.IDs, id)
+		}
+	}
+	if maxUID > idx.MaxUID {
+		idx.MaxUID = maxUID
+	}
+}
+
+// HasMessageID reports whether mailbox holds a message with this ID.
+func (s *State) HasMessageID(mailbox, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.MessageIDs[mailbox]
+	if idx == nil {
+		return false
+	}
+	idx.buildSeen()
+	return idx.seen[id]
+}
+
+// NoteMessageID remembers an ID appended to mailbox during this run. It is
+// not saved: the next scan picks the message up from the server.
+func (s *State) NoteMessageID(mailbox, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.MessageIDs[mailbox]; idx != nil {
+		idx.buildSeen()
+		idx.seen[id] = true
+	}
+}
+
+func (s *State) messageIDIndex(mailbox string, uidValidity uint32) *MessageIDIndex {
+	if s.MessageIDs == nil {
+		s.MessageIDs = make(map[string]*MessageIDIndex)
+	}
+	idx := s.MessageIDs[mailbox]
+	if idx == nil || idx.UIDValidity != uidValidity {
+		idx = &MessageIDIndex{UIDValidity: uidValidity, IDs: []string{}}
+		s.MessageIDs[mailbox] = idx
+	}
+	idx.buildSeen()
+	return idx
+}
+
+func (idx *MessageIDIndex) buildSeen() {
+	if idx.seen != nil {
+		return
+	}
+	idx.seen = make(map[string]bool, len(idx.IDs))
+	for _, id := range idx.IDs {
+		idx.seen[id] = true
+	}
+}
+
+// Mirror set helpers
+
+// GetMirrorSet returns the recorded message set of mailbox, or nil when
+// there is none for this UIDVALIDITY.
+func (s *State) GetMirrorSet(mailbox string, uidValidity uint32) map[uint32]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.Mirror[mailbox]
+	if m == nil || m.UIDValidity != uidValidity {
+		return nil
+	}
+	out := make(map[uint32]string, len(m.Messages))
+	for uid, key := range m.Messages {
+		out[uid] = key
+	}
+	return out
+}
+
+func (s *State) SetMirrorSet(mailbox string, uidValidity uint32, msgs map[uint32]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Mirror == nil {
+		s.Mirror = make(map[string]*MirrorSet)
+	}
+	s.Mirror[mailbox] = &MirrorSet{UIDValidity: uidValidity, Messages: msgs}
+}
+
+// GetModSeq returns the mark of mailbox, if any.
+func (s *State) GetModSeq(mailbox string) (ModSeqMark, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.ModSeqs[mailbox]
+	return m, ok
+}
+
+// SetModSeq records the mark of mailbox.
+func (s *State) SetModSeq(mailbox string, m ModSeqMark) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ModSeqs == nil {
+		s.ModSeqs = make(map[string]ModSeqMark)
+	}
+	s.ModSeqs[mailbox] = m
+}
+
+// UID map helpers
+
+// SetDstUID records that the message srcUID of mailbox was stored as
+// dstUID in dstMailbox, whose UIDVALIDITY is dstValidity. A map recorded
+// for another destination mailbox or UIDVALIDITY is replaced.
+func (s *State) SetDstUID(mailbox string, srcUID uint32, dstMailbox string, dstValidity, dstUID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.UIDMap == nil {
+		s.UIDMap = make(map[string]*UIDMapping)
+	}
+	m := s.UIDMap[mailbox]
+	if m == nil || m.DstMailbox != dstMailbox || m.DstUIDValidity != dstValidity {
+		m = &UIDMapping{DstMailbox: dstMailbox, DstUIDValidity: dstValidity, UIDs: make(map[uint32]uint32)}
+		s.UIDMap[mailbox] = m
+	}
+	m.UIDs[srcUID] = dstUID
+}
+
+// DstUID returns the destination mailbox and UID of the message srcUID
+// of mailbox, if recorded.
+func (s *State) DstUID(mailbox string, srcUID uint32) (string, uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.UIDMap[mailbox]
+	if m == nil {
+		return "", 0, false
+	}
+	uid, ok := m.UIDs[srcUID]
+	return m.DstMailbox, uid, ok
+}
+
+// MappedUIDs returns, in ascending order, the UIDs of mailbox whose
+// destination UID is recorded: the messages confirmed copied.
+func (s *State) MappedUIDs(mailbox string) []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.UIDMap[mailbox]
+	if m == nil {
+		return nil
+	}
+	uids := make([]uint32, 0, len(m.UIDs))
+	for uid := range m.UIDs {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// Backfill helpers
+
+// GetBackfill returns the backfill floor of a mailbox and whether it is
+// still being backfilled.
+func (s *State) GetBackfill(mailbox string) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uid, ok := s.Backfill[mailbox]
+	return uid, ok
+}
+
+// SetBackfill lowers the backfill floor of a mailbox to uid.
+func (s *State) SetBackfill(mailbox string, uid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Backfill == nil {
+		s.Backfill = make(map[string]uint32)
+	}
+	if cur, ok := s.Backfill[mailbox]; !ok || uid < cur {
+		s.Backfill[mailbox] = uid
+		s.pruneDone(mailbox)
+	}
+}
+
+// FinishBackfill drops the backfill floor of a mailbox once everything
+// below it is copied.
+func (s *State) FinishBackfill(mailbox string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Backfill, mailbox)
+	s.pruneDone(mailbox)
+}
+
+// Date window helpers
+func (s *State) HasWindows(mailbox string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Windows[mailbox]) > 0
+}
+
+// WindowLabels returns the labels of the window checkpoints of mailbox,
+// sorted.
+func (s *State) WindowLabels(mailbox string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	labels := make([]string, 0, len(s.Windows[mailbox]))
+	for label := range s.Windows[mailbox] {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func (s *State) GetWindow(mailbox, window string) WindowState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Windows[mailbox][window]
+}
+
+func (s *State) SetWindowUID(mailbox, window string, uid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws := s.window(mailbox)
+	w := ws[window]
+	if uid > w.MaxUID {
+		w.MaxUID = uid
+	}
+	ws[window] = w
+}
+
+func (s *State) MarkWindowDone(mailbox, window string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws := s.window(mailbox)
+	w := ws[window]
+	w.Done = true
+	ws[window] = w
+}
+
+// FinishWindows folds the window checkpoints of a mailbox into its highest
+// copied UID and drops them, so later runs resume incrementally as usual.
+func (s *State) FinishWindows(mailbox string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.Windows[mailbox] {
+		if cur, ok := s.MailMax[mailbox]; !ok || w.MaxUID > cur {
+			s.MailMax[mailbox] = w.MaxUID
+		}
+	}
+	delete(s.Windows, mailbox)
+	s.pruneDone(mailbox)
+}
+
+func (s *State) window(mailbox string) map[string]WindowState {
+	if s.Windows == nil {
+		s.Windows = make(map[string]map[string]WindowState)
+	}
+	ws := s.Windows[mailbox]
+	if ws == nil {
+		ws = make(map[string]WindowState)
+		s.Windows[mailbox] = ws
+	}
+	return ws
+}
@@ -1,6 +1,12 @@
 package state
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
 
 func TestStateMaxUID(t *testing.T) {
 	st := &State{MailMax: map[string]uint32{}}
@@ -14,3 +20,230 @@ func TestStateMaxUID(t *testing.T) {
 		t.Fatalf("expected 15, got %d", got)
 	}
 }
+
+func TestStateUIDValidity(t *testing.T) {
+	st := &State{MailMax: map[string]uint32{"INBOX": 10}}
+	if _, changed := st.CheckUIDValidity("INBOX", 7); changed || st.GetMaxUID("INBOX") != 10 {
+		t.Fatalf("state without UIDVALIDITY must be adopted")
+	}
+	if _, changed := st.CheckUIDValidity("INBOX", 7); changed {
+		t.Fatalf("same UIDVALIDITY reported as changed")
+	}
+	if _, changed := st.CheckUIDValidity("INBOX", 0); changed || st.UIDValidity["INBOX"] != 7 {
+		t.Fatalf("missing UIDVALIDITY must be ignored")
+	}
+	st.SetWindowUID("INBOX", "2024", 5)
+	old, changed := st.CheckUIDValidity("INBOX", 8)
+	if !changed || old != 7 {
+		t.Fatalf("CheckUIDValidity = %d, %v; want 7, true", old, changed)
+	}
+	if st.GetMaxUID("INBOX") != 0 || st.HasWindows("INBOX") {
+		t.Fatalf("resume state not reset after UIDVALIDITY change")
+	}
+}
+
+func TestStateDone(t *testing.T) {
+	st := &State{MailMax: map[string]uint32{"INBOX": 10}}
+	for _, uid := range []uint32{14, 12, 13, 20} {
+		st.MarkDone("INBOX", uid)
+	}
+	if got := st.Done["INBOX"].String(); got != "12:14,20" {
+		t.Fatalf("done UIDs %q, want 12:14,20", got)
+	}
+	if got := st.SkipDone("INBOX", []uint32{11, 12, 13, 14, 15, 20}); len(got) != 2 || got[0] != 11 || got[1] != 15 {
+		t.Fatalf("SkipDone = %v, want [11 15]", got)
+	}
+	st.SetMaxUID("INBOX", 13)
+	if got := st.Done["INBOX"].String(); got != "14,20" {
+		t.Fatalf("done UIDs %q after max UID 13, want 14,20", got)
+	}
+	st.SetMaxUID("INBOX", 20)
+	if st.Done["INBOX"] != nil {
+		t.Fatalf("done UIDs %v left below the max UID", st.Done["INBOX"])
+	}
+
+	// while backfilling, the UIDs below the floor are not covered
+	st.SetBackfill("INBOX", 15)
+	st.MarkDone("INBOX", 5)
+	st.MarkDone("INBOX", 16)
+	if got := st.Done["INBOX"].String(); got != "5" {
+		t.Fatalf("done UIDs %q while backfilling, want 5", got)
+	}
+	st.CheckUIDValidity("INBOX", 1)
+	st.CheckUIDValidity("INBOX", 2)
+	if st.Done["INBOX"] != nil {
+		t.Fatalf("done UIDs kept after a UIDVALIDITY change")
+	}
+}
+
+func TestUIDSet(t *testing.T) {
+	s := &UIDSet{}
+	for _, uid := range []uint32{5, 3, 4, 9, 1, 7, 8, 0} {
+		s.Add(uid)
+	}
+	if got := s.String(); got != "1,3:5,7:9" {
+		t.Fatalf("String = %q", got)
+	}
+	if s.Len() != 7 || !s.Contains(8) || s.Contains(6) || s.Contains(10) {
+		t.Fatalf("Len/Contains wrong for %s", s)
+	}
+	s.Remove(4, 7)
+	if got := s.String(); got != "1,3,8:9" {
+		t.Fatalf("after Remove(4, 7): %q", got)
+	}
+	b, err := json.Marshal(map[string]*UIDSet{"INBOX": s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back map[string]*UIDSet
+	if err := json.Unmarshal(b, &back); err != nil || back["INBOX"].String() != "1,3,8:9" {
+		t.Fatalf("roundtrip of %s: %v, %v", b, back, err)
+	}
+	if p, err := ParseUIDSet("9:7,1,2,6"); err != nil || p.String() != "1:2,6:9" {
+		t.Fatalf("ParseUIDSet = %v, %v", p, err)
+	}
+	if _, err := ParseUIDSet("1:x"); err == nil {
+		t.Fatal("ParseUIDSet accepted garbage")
+	}
+}
+
+func TestStateWindows(t *testing.T) {
+	st := &State{MailMax: map[string]uint32{}}
+	if st.HasWindows("INBOX") {
+		t.Fatalf("expected no windows")
+	}
+	st.SetWindowUID("INBOX", "2024", 900)
+	st.SetWindowUID("INBOX", "2024", 800)
+	st.MarkWindowDone("INBOX", "2024")
+	st.SetWindowUID("INBOX", "2023", 400)
+	if w := st.GetWindow("INBOX", "2024"); w.MaxUID != 900 || !w.Done {
+		t.Fatalf("unexpected window state %+v", w)
+	}
+	if got := st.GetMaxUID("INBOX"); got != 0 {
+		t.Fatalf("expected no max UID while windows are pending, got %d", got)
+	}
+	st.FinishWindows("INBOX")
+	if st.HasWindows("INBOX") {
+		t.Fatalf("expected windows to be cleared")
+	}
+	if got := st.GetMaxUID("INBOX"); got != 900 {
+		t.Fatalf("expected 900, got %d", got)
+	}
+}
+
+func TestStateMessageIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st := &State{MailMax: map[string]uint32{}}
+	st.AddScannedMessageIDs("INBOX", 7, 20, []string{"<a@x>", "<b@x>", "<a@x>"})
+	st.NoteMessageID("INBOX", "<c@x>")
+	if err := st.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := st.MessageIDScan("INBOX", 7); got != 20 {
+		t.Fatalf("expected scan up to UID 20, got %d", got)
+	}
+	if !st.HasMessageID("INBOX", "<b@x>") || st.HasMessageID("INBOX", "<c@x>") {
+		t.Fatalf("unexpected index %+v", st.MessageIDs["INBOX"])
+	}
+	if got := st.MessageIDScan("INBOX", 8); got != 0 || st.HasMessageID("INBOX", "<a@x>") {
+		t.Fatalf("expected the index to be dropped after a UIDVALIDITY change")
+	}
+}
+
+func TestStateMirrorSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st := &State{MailMax: map[string]uint32{}}
+	st.SetMirrorSet("sync:src:INBOX", 7, map[uint32]string{3: "<a@x>", 9: "<b@x>"})
+	if err := st.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := st.GetMirrorSet("sync:src:INBOX", 7)
+	if len(m) != 2 || m[9] != "<b@x>" {
+		t.Fatalf("unexpected mirror set %v", m)
+	}
+	delete(m, 9)
+	if len(st.GetMirrorSet("sync:src:INBOX", 7)) != 2 {
+		t.Fatalf("GetMirrorSet returned the stored map")
+	}
+	if st.GetMirrorSet("sync:src:INBOX", 8) != nil {
+		t.Fatalf("expected no set for another UIDVALIDITY")
+	}
+}
+
+func TestStateGmailDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st := &State{MailMax: map[string]uint32{}}
+	st.AddGmailDone("gmail:Label_1|dst:Work", "18c2")
+	st.AddGmailDone("gmail:Label_1|dst:Work", "18a7")
+	if err := st.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := st.GmailDoneIDs("gmail:Label_1|dst:Work")
+	if len(done) != 2 || !done["18a7"] || !done["18c2"] {
+		t.Fatalf("unexpected done IDs %v", done)
+	}
+	if len(st.GmailDoneIDs("gmail:INBOX|dst:INBOX")) != 0 {
+		t.Fatalf("expected no IDs for another label")
+	}
+}
+
+func TestStateUIDMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st := &State{MailMax: map[string]uint32{}}
+	st.CheckUIDValidity("INBOX", 7)
+	st.SetDstUID("INBOX", 3, "Copy", 100, 51)
+	st.SetDstUID("INBOX", 4, "Copy", 100, 52)
+	if err := st.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if box, uid, ok := st.DstUID("INBOX", 4); !ok || box != "Copy" || uid != 52 {
+		t.Fatalf("DstUID = %s, %d, %v; want Copy, 52, true", box, uid, ok)
+	}
+	if got := fmt.Sprint(st.MappedUIDs("INBOX")); got != "[3 4]" {
+		t.Fatalf("MappedUIDs = %s, want [3 4]", got)
+	}
+	// a new destination UIDVALIDITY starts over
+	st.SetDstUID("INBOX", 5, "Copy", 101, 1)
+	if _, _, ok := st.DstUID("INBOX", 3); ok {
+		t.Fatalf("expected the map of the old destination UIDVALIDITY to be dropped")
+	}
+	st.CheckUIDValidity("INBOX", 8)
+	if _, _, ok := st.DstUID("INBOX", 5); ok {
+		t.Fatalf("expected the map to be dropped with the source UIDVALIDITY")
+	}
+}
+
+func TestBundleRoundtrip(t *testing.T) {
+	st := &State{MailMax: map[string]uint32{"INBOX": 42}, MboxOffsets: map[string]int64{"mbox:/a.mbox|dst:A": 100}}
+	st.SetWindowUID("Archive", "2023", 7)
+	var buf bytes.Buffer
+	if err := WriteBundle(&buf, &Bundle{Version: BundleVersion, State: st}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ReadBundle(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.State.GetMaxUID("INBOX") != 42 || b.State.GetMboxOffset("mbox:/a.mbox|dst:A") != 100 || b.State.GetWindow("Archive", "2023").MaxUID != 7 {
+		t.Fatalf("state not preserved: %+v", b.State)
+	}
+	if _, err := ReadBundle(bytes.NewReader([]byte("{}"))); err == nil {
+		t.Fatalf("expected error for non-bundle input")
+	}
+}
@@ -0,0 +1,157 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UIDSet is a set of UIDs stored as sorted, non-overlapping ranges. In
+// JSON it is an IMAP sequence set string such as "1:120,125,130:131".
+type UIDSet struct {
+	ranges []uidRange
+}
+
+type uidRange struct{ lo, hi uint32 }
+
+// Add adds uid to the set. UID 0 is not a valid UID and is ignored.
+func (s *UIDSet) Add(uid uint32) {
+	if uid == 0 {
+		return
+	}
+	r := s.ranges
+	// i is the first range reaching up to uid-1 or higher
+	i := sort.Search(len(r), func(i int) bool { return r[i].hi >= uid-1 })
+	switch {
+	case i < len(r) && r[i].lo <= uid && uid <= r[i].hi:
+		return
+	case i < len(r) && r[i].hi == uid-1:
+		r[i].hi = uid
+		if i+1 < len(r) && r[i+1].lo == uid+1 {
+			r[i].hi = r[i+1].hi
+			s.ranges = append(r[:i+1], r[i+2:]...)
+		}
+	case i < len(r) && r[i].lo == uid+1:
+		r[i].lo = uid
+	default:
+		s.ranges = append(r[:i], append([]uidRange{{uid, uid}}, r[i:]...)...)
+	}
+}
+
+// Contains reports whether uid is in the set.
+func (s *UIDSet) Contains(uid uint32) bool {
+	if s == nil {
+		return false
+	}
+	r := s.ranges
+	i := sort.Search(len(r), func(i int) bool { return r[i].hi >= uid })
+	return i < len(r) && r[i].lo <= uid
+}
+
+// Remove removes the UIDs from lo to hi, both included.
+func (s *UIDSet) Remove(lo, hi uint32) {
+	out := s.ranges[:0]
+	for _, r := range s.ranges {
+		if r.hi < lo || r.lo > hi {
+			out = append(out, r)
+			continue
+		}
+		if r.lo < lo {
+			out = append(out, uidRange{r.lo, lo - 1})
+		}
+		if r.hi > hi {
+			out = append(out, uidRange{hi + 1, r.hi})
+		}
+	}
+	s.ranges = out
+}
+
+// Len returns the number of UIDs in the set.
+func (s *UIDSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	n := 0
+	for _, r := range s.ranges {
+		n += int(r.hi-r.lo) + 1
+	}
+	return n
+}
+
+// Empty reports whether the set holds no UID.
+func (s *UIDSet) Empty() bool {
+	return s == nil || len(s.ranges) == 0
+}
+
+func (s *UIDSet) String() string {
+	var b strings.Builder
+	for i, r := range s.ranges {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatUint(uint64(r.lo), 10))
+		if r.hi != r.lo {
+			b.WriteByte(':')
+			b.WriteString(strconv.FormatUint(uint64(r.hi), 10))
+		}
+	}
+	return b.String()
+}
+
+func (s *UIDSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *UIDSet) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	set, err := ParseUIDSet(str)
+	if err != nil {
+		return err
+	}
+	*s = *set
+	return nil
+}
+
+// ParseUIDSet parses a set written by UIDSet.String. Ranges may come in
+// any order and overlap.
+func ParseUIDSet(str string) (*UIDSet, error) {
+	s := &UIDSet{}
+	if str == "" {
+		return s, nil
+	}
+	for _, part := range strings.Split(str, ",") {
+		loStr, hiStr, isRange := strings.Cut(part, ":")
+		if !isRange {
+			hiStr = loStr
+		}
+		lo, err := strconv.ParseUint(loStr, 10, 32)
+		if err != nil || lo == 0 {
+			return nil, fmt.Errorf("invalid UID set %q", str)
+		}
+		hi, err := strconv.ParseUint(hiStr, 10, 32)
+		if err != nil || hi == 0 {
+			return nil, fmt.Errorf("invalid UID set %q", str)
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		s.ranges = append(s.ranges, uidRange{uint32(lo), uint32(hi)})
+	}
+	sort.Slice(s.ranges, func(i, j int) bool { return s.ranges[i].lo < s.ranges[j].lo })
+	out := s.ranges[:1]
+	for _, r := range s.ranges[1:] {
+		last := &out[len(out)-1]
+		if uint64(r.lo) <= uint64(last.hi)+1 {
+			last.hi = max(last.hi, r.hi)
+			continue
+		}
+		out = append(out, r)
+	}
+	s.ranges = out
+	return s, nil
+}
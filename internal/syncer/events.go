@@ -7,6 +7,9 @@ const (
 	EventMailboxStart    EventType = "mailbox_start"
 	EventMailboxProgress EventType = "mailbox_progress"
 	EventMailboxDone     EventType = "mailbox_done"
+	// EventMessageProgress reports the bytes of a large message fetched or
+	// appended so far (see Options.LargeMessage).
+	EventMessageProgress EventType = "message_progress"
 )
 
 // Event carries progress about a mailbox.
@@ -16,4 +19,10 @@ type Event struct {
 	Total   int
 	Done    int
 	Err     error
+	// For EventMessageProgress: the message, whether it is being fetched
+	// or appended ("fetch" or "append"), and Bytes of its Size so far.
+	UID   uint32
+	Phase string
+	Bytes int64
+	Size  int64
 }
@@ -1,9 +1,14 @@
 package syncer
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/mail"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +17,7 @@ import (
 	"github.com/emersion/go-imap/client"
 
 	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/pacer"
 	"github.com/pepperpark/gomap/internal/state"
 )
 
@@ -20,24 +26,195 @@ import (
 type Options struct {
 	DryRun      bool
 	Since       time.Time
+	Before      time.Time // if set, only messages with INTERNALDATE before it
 	Concurrency int
 	Quiet       bool
 	Map         map[string]string // optional exact mailbox name mapping: src->dst
 	IgnoreState bool              // if true, do not use resume state (start from UID 0)
+	// Limit, if set, caps the messages copied per mailbox and run; the
+	// rest is left for later runs.
+	Limit int
+	// NewestFirst copies mailboxes without resume state from the newest
+	// message down, so a Limit seeds the destination with recent mail.
+	// Later runs copy new messages and continue below the oldest one
+	// copied (see state.Backfill).
+	NewestFirst bool
+	// SrcPool and DstPool, if set, hand out the connections mailboxes are
+	// copied on, so Concurrency mailboxes really are copied at the same
+	// time; without them all share src and dst. DstPool is not needed
+	// with Deliver.
+	SrcPool, DstPool *imaputil.Pool
+	// Connections, if above 1, copies large mailboxes over up to this
+	// many source connections, each fetching a part of the UIDs, and as
+	// many destination connections appending (see copyParallel). The
+	// extra connections come from SrcPool and DstPool, as far as they
+	// have some free.
+	Connections int
+	// Reconnects, if above 0, resumes a mailbox on new connections from
+	// SrcPool and DstPool when the server drops one (see
+	// imaputil.ConnClosed): it is selected again and the copy goes on
+	// from the resume state. Reconnects caps the attempts in a row that
+	// copy nothing; without the pools a lost connection ends the mailbox.
+	Reconnects int
+	// UIDs, if set, restricts the copy to these source UIDs of each
+	// mailbox, whatever the resume state says: they are copied again
+	// even if an earlier run copied them (see copy --uid). Copied ones
+	// are recorded as done; the state's high-water mark is left alone.
+	// Limit and NewestFirst do not apply.
+	UIDs *imap.SeqSet
+	// Flags restricts the copy to messages whose flags pass it (see copy
+	// --only-unseen). The resume state still advances to the highest UID
+	// copied, so messages below it that pass only later are not picked up.
+	Flags imaputil.FlagFilter
+	// SplitThreshold splits the initial copy of mailboxes without resume state
+	// into yearly date windows once they hold at least this many messages
+	// (0 disables splitting).
+	SplitThreshold int
+	// SplitMonthly makes those windows months instead of years, for
+	// mailboxes so large that a year is still too much for one SEARCH.
+	SplitMonthly bool
+	// Checkpoint, if set, is called whenever a date window completes so the
+	// caller can persist state.
+	Checkpoint func()
+	// Pacer, if set, paces destination appends and retries throttled ones.
+	Pacer *pacer.Pacer
+	// AppendBatch, if above 1, appends up to this many messages with one
+	// APPEND command where the destination supports MULTIAPPEND, and
+	// AppendBatchBytes, if set, caps the bytes of such a batch. Large
+	// messages (see LargeMessage) are appended singly, as are all with
+	// Deliver.
+	AppendBatch      int
+	AppendBatchBytes int64
+	// Verify, if set, looks up every appended message on the destination
+	// before its UID counts as copied (see imaputil.VerifyAppended). A
+	// message that fails the check fails like one the destination
+	// refuses. Not used with Deliver.
+	Verify imaputil.Verify
+	// FlagMap, if set, translates or drops the flags of appended messages
+	// (see copy --flag-map and --strip-nonstandard-flags).
+	FlagMap *imaputil.FlagMap
+	// Subscribe, if set, reports whether the destination mailbox of the
+	// source mailbox name is to be SUBSCRIBEd once it exists, so clients
+	// that show only subscribed folders list it.
+	Subscribe func(name string) bool
+	// ACL, if set, gives each destination mailbox the access control list
+	// entries of its source mailbox (see imaputil.DiffACL), except those of
+	// the identifiers in ACLOwners; with DryRun the changes are only
+	// logged. Both servers must support the ACL extension.
+	ACL       bool
+	ACLOwners []string
+	// Planned, if set with DryRun, is called with each message the run
+	// would append and its RFC822.SIZE, in place of fetching its body.
+	Planned func(mailbox string, uid uint32, size int64)
+	// Deliver, if set, replaces IMAP APPEND: it receives the mapped
+	// destination mailbox and the raw message. The destination client is
+	// not used and may be nil.
+	Deliver func(ctx context.Context, mailbox string, raw []byte) error
+	// Failed, if set, is called with the source mailbox, UID and error of
+	// each message that cannot be copied, such as one the destination
+	// refuses; the message counts as handled and the copy goes on (see
+	// copy --continue-on-error). Without it, the first failure ends the
+	// mailbox. Failures that leave a connection unusable still end it, as
+	// do maxFailures in a row.
+	Failed func(mailbox string, uid uint32, err error)
+	// Copied, if set, is called with the source mailbox and UID after each
+	// successful append (not in dry-run mode). Unlike progress events it is
+	// never dropped, so it suits counting.
+	Copied func(mailbox string, uid uint32)
+	// Selected, if set, is called with the source mailbox and its number
+	// of messages when the mailbox is opened.
+	Selected func(mailbox string, messages uint32)
+	// Headers, if set, is prepended to every copied message (CRLF-terminated
+	// header lines). IMAP appends send it as a separate CATENATE part where
+	// the destination supports it.
+	Headers []byte
+	// FetchRFC822 fetches messages as RFC822 instead of BODY[], for servers
+	// that mangle BODY[] (see package quirks).
+	FetchRFC822 bool
+	// Skip, if set, is asked with the destination mailbox and the envelope
+	// of each message before its body is fetched; messages it reports as
+	// already present are not copied (see copy --dedup).
+	Skip func(mailbox string, env *imap.Envelope) bool
+	// Filter, if set, is asked with the header fields FilterFields of
+	// each message before its body is fetched; messages it rejects are
+	// not copied and count as handled for the resume state (see copy
+	// --header-filter).
+	FilterFields []string
+	Filter       func(hdr mail.Header) bool
+	// LargeMessage, if set, is the size in bytes from which a message is
+	// fetched in chunks and appended with byte progress, reported as
+	// EventMessageProgress. Not used with FetchRFC822, which cannot be
+	// fetched partially.
+	LargeMessage int64
+	// MaxSize, if set, skips messages whose RFC822.SIZE exceeds it, as
+	// many servers refuse large APPENDs. Each is reported to Oversized,
+	// if set, and counts as handled for the resume state.
+	MaxSize   int64
+	Oversized func(mailbox string, uid uint32, size int64)
+	// Delimiter is the hierarchy delimiter of the destination and
+	// NameLimits caps its mailbox names. When the destination refuses to
+	// create a mailbox for its length or depth, a shortened name is used
+	// (see imaputil.CreateFitting) and reported to Renamed, if set.
+	Delimiter  string
+	NameLimits imaputil.NameLimits
+	Renamed    func(mailbox, dst string)
 }
 
+// largeChunk is the size of the partial fetches of a large message.
+const largeChunk = 8 << 20
+
+// reconnectWait is the pause before the first attempt to resume a mailbox
+// on new connections; each further attempt in a row waits once more.
+var reconnectWait = 5 * time.Second
+
+// MailboxSyncer copies mailboxes from src to dst. With Options.SrcPool
+// and DstPool, each mailbox is copied on connections of its own, checked
+// out of the pools, on a copy of the syncer.
 type MailboxSyncer struct {
 	src, dst *client.Client
 	st       *state.State
 	opts     Options
 	events   chan Event
+	renamed  *renames
+	// progress counts the messages confirmed while copying one mailbox
+	// (see copyUIDs), so reconnects can tell whether an attempt got on.
+	progress int
+	picked   *picked
+}
+
+// picked holds the UIDs of Options.UIDs copied by this run, by source
+// mailbox, so a resumed mailbox does not copy them twice.
+type picked struct {
+	mu sync.Mutex
+	m  map[string]*state.UIDSet
+}
+
+func (p *picked) add(name string, uid uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.m[name] == nil {
+		p.m[name] = &state.UIDSet{}
+	}
+	p.m[name].Add(uid)
+}
+
+func (p *picked) has(name string, uid uint32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.m[name].Contains(uid)
+}
+
+// renames holds the shortened destination names, by source mailbox.
+type renames struct {
+	mu sync.Mutex
+	m  map[string]string
 }
 
 func NewMailboxSyncer(src, dst *client.Client, st *state.State, opts Options) *MailboxSyncer {
 	if opts.Concurrency <= 0 {
 		opts.Concurrency = 1
 	}
-	return &MailboxSyncer{src: src, dst: dst, st: st, opts: opts, events: make(chan Event, 128)}
+	return &MailboxSyncer{src: src, dst: dst, st: st, opts: opts, events: make(chan Event, 128), renamed: &renames{m: map[string]string{}}, picked: &picked{m: map[string]*state.UIDSet{}}}
 }
 
 func (m *MailboxSyncer) SyncAll(ctx context.Context, mailboxes []string) []error {
@@ -51,7 +228,11 @@ func (m *MailboxSyncer) SyncAll(ctx context.Context, mailboxes []string) []error
 		<-ctx.Done()
 		// Best-effort: ignore errors; this should unblock ongoing operations
 		_ = m.src.Logout()
-		_ = m.dst.Logout()
+		if m.dst != nil {
+			_ = m.dst.Logout()
+		}
+		m.opts.SrcPool.Close()
+		m.opts.DstPool.Close()
 	}()
 	for _, box := range mailboxes {
 		box := box
@@ -59,7 +240,7 @@ func (m *MailboxSyncer) SyncAll(ctx context.Context, mailboxes []string) []error
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if err := m.syncMailbox(ctx, box); err != nil {
+			if err := m.syncPooled(ctx, box); err != nil {
 				mu.Lock()
 				errs = append(errs, fmt.Errorf("%s: %w", box, err))
 				mu.Unlock()
@@ -72,134 +253,1192 @@ func (m *MailboxSyncer) SyncAll(ctx context.Context, mailboxes []string) []error
 	return errs
 }
 
+// syncPooled runs syncMailbox on connections checked out of the pools, if
+// set, so mailboxes copied at the same time do not share one connection.
+// When one of them is lost, the mailbox is resumed on new ones, up to
+// Options.Reconnects attempts in a row without progress.
+func (m *MailboxSyncer) syncPooled(ctx context.Context, name string) error {
+	pooled := m.opts.SrcPool != nil && (m.opts.DstPool != nil || m.opts.Deliver != nil)
+	for attempt := 0; ; {
+		w := *m
+		if err := w.checkout(ctx); err != nil {
+			return err
+		}
+		err := w.syncMailbox(ctx, name)
+		lost := err != nil && (imaputil.ConnClosed(err) || !imaputil.Alive(w.src) || w.dst != nil && !imaputil.Alive(w.dst))
+		w.checkin()
+		if !lost || !pooled || ctx.Err() != nil {
+			return err
+		}
+		if w.progress > 0 {
+			attempt = 0
+		}
+		if attempt++; attempt > m.opts.Reconnects {
+			return err
+		}
+		wait := time.Duration(attempt) * reconnectWait
+		log.Printf("[mailbox] %s: connection lost (%v); resuming on a new connection in %s (attempt %d/%d)", name, err, wait, attempt, m.opts.Reconnects)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// checkout takes the connections of m from the pools, if set.
+func (m *MailboxSyncer) checkout(ctx context.Context) error {
+	if m.opts.SrcPool != nil {
+		src, err := m.opts.SrcPool.Get(ctx)
+		if err != nil {
+			return fmt.Errorf("source connection: %w", err)
+		}
+		m.src = src
+	}
+	if m.opts.DstPool != nil && m.opts.Deliver == nil {
+		dst, err := m.opts.DstPool.Get(ctx)
+		if err != nil {
+			if m.opts.SrcPool != nil {
+				m.opts.SrcPool.Put(m.src)
+			}
+			return fmt.Errorf("destination connection: %w", err)
+		}
+		m.dst = dst
+	}
+	return nil
+}
+
+// checkin gives the connections of m back to the pools; lost ones are
+// dropped there.
+func (m *MailboxSyncer) checkin() {
+	if m.opts.SrcPool != nil {
+		m.opts.SrcPool.Put(m.src)
+	}
+	if m.opts.DstPool != nil && m.opts.Deliver == nil {
+		m.opts.DstPool.Put(m.dst)
+	}
+}
+
 func (m *MailboxSyncer) syncMailbox(ctx context.Context, name string) error {
 	if !m.opts.Quiet {
 		log.Printf("[mailbox] %s: start", name)
 	}
 	m.emit(Event{Type: EventMailboxStart, Mailbox: name})
+	// Select source mailbox; one deleted since it was listed is skipped
+	status, err := imaputil.SelectMailbox(m.src, name, true)
+	if errors.Is(err, imaputil.ErrNoMailbox) {
+		log.Printf("[mailbox] %s: no longer exists on the source, skipped", name)
+		m.emit(Event{Type: EventMailboxDone, Mailbox: name})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 	// Ensure destination mailbox exists
-	if !m.opts.DryRun {
+	if !m.opts.DryRun && m.opts.Deliver == nil {
 		if err := m.ensureDstMailbox(name); err != nil {
 			return err
 		}
 	}
-	// Select source mailbox
-	if _, err := imaputil.SelectMailbox(m.src, name, true); err != nil {
-		return err
+	if m.opts.ACL && m.opts.Deliver == nil {
+		m.copyACL(name)
+	}
+	if m.opts.Selected != nil {
+		m.opts.Selected(name, status.Messages)
 	}
 	var minUID uint32
 	if !m.opts.IgnoreState {
+		if old, changed := m.st.CheckUIDValidity(name, status.UidValidity); changed {
+			log.Printf("[mailbox] %s: UIDVALIDITY changed (%d -> %d); resume state reset, rescanning the mailbox", name, old, status.UidValidity)
+		}
 		minUID = m.st.GetMaxUID(name)
 	}
-	uids, err := imaputil.SearchUIDsSince(m.src, m.opts.Since, minUID)
+	if m.opts.UIDs != nil {
+		return m.syncPicked(ctx, name)
+	}
+	// decided on the message count: a SEARCH of the whole mailbox is what
+	// the windows avoid
+	if !m.opts.IgnoreState && minUID == 0 && m.opts.SplitThreshold > 0 &&
+		(int(status.Messages) >= m.opts.SplitThreshold && m.opts.Limit == 0 && !m.opts.NewestFirst || m.st.HasWindows(name)) {
+		return m.syncWindows(ctx, name)
+	}
+	uids, err := imaputil.SearchUIDs(m.src, m.opts.Since, m.opts.Before, minUID, m.opts.Flags)
 	if err != nil {
 		return err
 	}
-	if len(uids) == 0 {
+	// found is set when messages were skipped as done (see foldDone)
+	found := uids
+	if uids = m.skipDone(name, uids); len(uids) == len(found) {
+		found = nil
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	// next is the first UID left for a later run by --limit, if any
+	var next uint32
+	// older: messages copied newest first, below the new ones
+	var older []uint32
+	floor, backfilling := m.st.GetBackfill(name)
+	switch {
+	case backfilling && !m.opts.IgnoreState:
+		all, err := imaputil.SearchUIDs(m.src, m.opts.Since, m.opts.Before, 0, m.opts.Flags)
+		if err != nil {
+			return err
+		}
+		for _, uid := range all {
+			if uid < floor {
+				older = append(older, uid)
+			}
+		}
+		older = m.skipDone(name, older)
+		sort.Slice(older, func(i, j int) bool { return older[i] < older[j] })
+	case m.opts.NewestFirst && minUID == 0:
+		older, uids, found = uids, nil, nil
+	}
+	more := false // messages left for a later run by --limit
+	if m.opts.Limit > 0 {
+		if len(uids) > m.opts.Limit {
+			next = uids[m.opts.Limit]
+			uids, more = uids[:m.opts.Limit], true
+		}
+		if n := m.opts.Limit - len(uids); len(older) > n {
+			older, more = older[len(older)-n:], true
+		}
+	}
+	total := len(uids) + len(older)
+	if total == 0 {
+		m.foldDone(name, found, next)
+		if backfilling && !more && !m.opts.DryRun {
+			m.st.FinishBackfill(name)
+		}
 		if !m.opts.Quiet {
 			log.Printf("[mailbox] %s: no new messages", name)
 		}
 		return nil
 	}
 	if !m.opts.Quiet {
-		log.Printf("[mailbox] %s: copying %d messages (from UID>%d)", name, len(uids), minUID)
+		switch {
+		case len(older) == 0:
+			log.Printf("[mailbox] %s: copying %d messages (from UID>%d)", name, len(uids), minUID)
+		case len(uids) == 0:
+			log.Printf("[mailbox] %s: copying %d messages newest first", name, len(older))
+		default:
+			log.Printf("[mailbox] %s: copying %d messages (from UID>%d), then %d older ones newest first", name, len(uids), minUID, len(older))
+		}
+		if more {
+			log.Printf("[mailbox] %s: --limit %d reached, the rest is left for a later run", name, m.opts.Limit)
+		}
+	}
+	m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: 0})
+	done := 0
+	if len(uids) > 0 {
+		var err error
+		if done, err = m.copyUIDs(ctx, name, uids, 0, total, func(uid uint32) { m.st.SetMaxUID(name, uid) }); err != nil {
+			return err
+		}
+	}
+	m.foldDone(name, found, next)
+	if len(older) > 0 {
+		if err := m.backfill(ctx, name, older, done, total); err != nil {
+			return err
+		}
+		if !more && !m.opts.DryRun {
+			m.st.FinishBackfill(name)
+		}
+	}
+	m.emit(Event{Type: EventMailboxDone, Mailbox: name})
+	return nil
+}
+
+// syncPicked copies the messages of Options.UIDs in the selected source
+// mailbox name that pass the date and flag filters.
+func (m *MailboxSyncer) syncPicked(ctx context.Context, name string) error {
+	var lowest uint32
+	for _, seq := range m.opts.UIDs.Set {
+		if seq.Start > 0 && (lowest == 0 || seq.Start < lowest) {
+			lowest = seq.Start
+		}
+	}
+	all, err := imaputil.SearchUIDs(m.src, m.opts.Since, m.opts.Before, max(lowest, 1)-1, m.opts.Flags)
+	if err != nil {
+		return err
+	}
+	var uids []uint32
+	for _, uid := range all {
+		if m.opts.UIDs.Contains(uid) && !m.picked.has(name, uid) {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	if len(uids) == 0 {
+		if !m.opts.Quiet {
+			log.Printf("[mailbox] %s: no messages with the given UIDs", name)
+		}
+		return nil
+	}
+	if !m.opts.Quiet {
+		log.Printf("[mailbox] %s: copying %d messages by UID", name, len(uids))
 	}
 	m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: len(uids), Done: 0})
+	if _, err := m.copyUIDs(ctx, name, uids, 0, len(uids), func(uint32) {}); err != nil {
+		return err
+	}
+	m.emit(Event{Type: EventMailboxDone, Mailbox: name})
+	return nil
+}
+
+// skipDone drops from uids the messages an earlier run copied out of
+// order (see copyUIDs), unless the resume state is ignored.
+func (m *MailboxSyncer) skipDone(name string, uids []uint32) []uint32 {
+	if m.opts.IgnoreState {
+		return uids
+	}
+	left := m.st.SkipDone(name, uids)
+	if n := len(uids) - len(left); n > 0 && !m.opts.Quiet {
+		log.Printf("[mailbox] %s: %d messages already copied by an earlier run, skipped", name, n)
+	}
+	return left
+}
 
+// foldDone raises the highest copied UID of name over found, the messages
+// above it some of which were skipped as done by an earlier run, once the
+// rest is copied: the state would otherwise stay below the skipped ones.
+// next, if not 0, is the first message left for a later run.
+func (m *MailboxSyncer) foldDone(name string, found []uint32, next uint32) {
+	if m.opts.DryRun {
+		return
+	}
+	var top uint32
+	for _, uid := range found {
+		if (next == 0 || uid < next) && uid > top {
+			top = uid
+		}
+	}
+	if top > 0 {
+		m.st.SetMaxUID(name, top)
+	}
+}
+
+// backfillBatch is the number of messages copyUIDs is given at a time when
+// copying newest first. Messages of an interrupted batch are copied again
+// on resume.
+const backfillBatch = 200
+
+// backfill copies uids (ascending) newest first, in batches taken from the
+// top. After each batch the resume state records the batch: the highest
+// copied UID is raised to it (for the first batch of an initial copy) and
+// the backfill floor lowered to its lowest UID, so later runs copy new
+// messages above and continue below.
+func (m *MailboxSyncer) backfill(ctx context.Context, name string, uids []uint32, doneBase, total int) error {
+	for hi := len(uids); hi > 0; {
+		lo := max(hi-backfillBatch, 0)
+		n, err := m.copyUIDs(ctx, name, uids[lo:hi], doneBase, total, func(uint32) {})
+		doneBase += n
+		if err != nil {
+			return err
+		}
+		if !m.opts.DryRun {
+			m.st.SetBackfill(name, uids[lo])
+			m.st.SetMaxUID(name, uids[hi-1])
+			m.checkpoint()
+		}
+		hi = lo
+	}
+	return nil
+}
+
+type window struct {
+	label string
+	uids  []uint32
+}
+
+// dateWindow is the date range of a window; a zero end is open.
+type dateWindow struct {
+	label      string
+	start, end time.Time
+}
+
+// yearWindows returns the windows of year, newest first: the year itself,
+// or with monthly its months up to now ("2024-03"). The newest window
+// stays open-ended to catch future-dated mail.
+func yearWindows(year int, monthly bool, now time.Time) []dateWindow {
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !monthly {
+		w := dateWindow{label: strconv.Itoa(year), start: start, end: start.AddDate(1, 0, 0)}
+		if year == now.Year() {
+			w.end = time.Time{}
+		}
+		return []dateWindow{w}
+	}
+	var out []dateWindow
+	for month := time.December; month >= time.January; month-- {
+		w := dateWindow{label: fmt.Sprintf("%d-%02d", year, month), start: time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)}
+		w.end = w.start.AddDate(0, 1, 0)
+		if w.start.After(now) {
+			continue
+		}
+		if len(out) == 0 && year == now.Year() {
+			w.end = time.Time{}
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// syncWindows performs the initial copy of a large mailbox in yearly (or
+// with Options.SplitMonthly monthly) date windows, newest first. Each
+// window is searched and checkpointed on its own, so an interrupted run
+// only has to redo the window it was working on.
+func (m *MailboxSyncer) syncWindows(ctx context.Context, name string) error {
+	monthly := m.opts.SplitMonthly
+	if labels := m.st.WindowLabels(name); len(labels) > 0 {
+		// an interrupted split goes on in the windows it started with
+		monthly = strings.Contains(labels[0], "-")
+	}
+	// Plan: walk back year by year until no older messages remain.
+	plan := []window{}
+	total := 0
+	now := time.Now()
+	for year := now.Year(); ; year-- {
+		start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		for _, dw := range yearWindows(year, monthly, now) {
+			since, before := dw.start, dw.end
+			if m.opts.Since.After(since) {
+				since = m.opts.Since
+			}
+			if !m.opts.Before.IsZero() && (before.IsZero() || m.opts.Before.Before(before)) {
+				before = m.opts.Before
+			}
+			if !before.IsZero() && !since.Before(before) {
+				continue // outside --since/--before
+			}
+			ws := m.st.GetWindow(name, dw.label)
+			if ws.Done {
+				continue
+			}
+			uids, err := imaputil.SearchUIDs(m.src, since, before, ws.MaxUID, m.opts.Flags)
+			if err != nil {
+				return err
+			}
+			uids = m.skipDone(name, uids)
+			if len(uids) > 0 {
+				plan = append(plan, window{label: dw.label, uids: uids})
+				total += len(uids)
+			}
+		}
+		older, err := imaputil.SearchUIDsRange(m.src, time.Time{}, start, 0)
+		if err != nil {
+			return err
+		}
+		if len(older) == 0 || !start.After(m.opts.Since) {
+			break
+		}
+	}
+	if !m.opts.Quiet {
+		log.Printf("[mailbox] %s: copying %d messages in %d date window(s)", name, total, len(plan))
+	}
+	m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: 0})
+	done := 0
+	for i, w := range plan {
+		if !m.opts.Quiet {
+			log.Printf("[mailbox] %s: window %s (%d/%d): %d messages", name, w.label, i+1, len(plan), len(w.uids))
+		}
+		n, err := m.copyUIDs(ctx, name, w.uids, done, total, func(uid uint32) { m.st.SetWindowUID(name, w.label, uid) })
+		done += n
+		if err != nil {
+			return fmt.Errorf("window %s: %w", w.label, err)
+		}
+		if !m.opts.DryRun {
+			m.st.MarkWindowDone(name, w.label)
+			m.checkpoint()
+		}
+	}
+	if !m.opts.DryRun {
+		m.st.FinishWindows(name)
+		m.checkpoint()
+	}
+	m.emit(Event{Type: EventMailboxDone, Mailbox: name})
+	return nil
+}
+
+// copyUIDs fetches the given UIDs from the selected source mailbox and
+// appends them to the destination. onCopied advances the resume state: it is
+// called in ascending UID order, and only once the UID and all lower ones
+// are confirmed by the destination (tagged OK of APPEND, or the Deliver
+// reply). Servers may answer FETCH in any order, so a failure after a higher
+// UID was appended must not let the state skip a lower one that was not;
+// such higher UIDs are recorded one by one as done instead (State.MarkDone)
+// and skipped on resume. UIDs the server did not return at all (expunged
+// meanwhile) are passed once the fetch has completed. Nothing is recorded
+// in dry-run mode. Progress events
+// report doneBase plus the number of messages handled so far, out of total.
+// It returns the number of messages handled.
+func (m *MailboxSyncer) copyUIDs(ctx context.Context, name string, uids []uint32, doneBase, total int, onCopied func(uid uint32)) (int, error) {
 	seq := new(imap.SeqSet)
 	for _, uid := range uids {
 		seq.AddNum(uid)
 	}
+	order := append([]uint32(nil), uids...)
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+	confirmed := make(map[uint32]bool, len(order))
+	next := 0
+	confirm := func(uid uint32) {
+		if m.opts.DryRun {
+			return
+		}
+		confirmed[uid] = true
+		m.progress++
+		m.st.MarkDone(name, uid)
+		if m.opts.UIDs != nil {
+			m.picked.add(name, uid)
+		}
+		for next < len(order) && confirmed[order[next]] {
+			onCopied(order[next])
+			next++
+		}
+	}
+
+	handled := 0
+	if m.opts.Skip != nil || m.opts.Filter != nil {
+		skipped, err := m.skipMessages(name, uids)
+		if err != nil {
+			return 0, err
+		}
+		if len(skipped) > 0 {
+			seq = new(imap.SeqSet)
+			for _, uid := range uids {
+				if !skipped[uid] {
+					seq.AddNum(uid)
+				}
+			}
+			for _, uid := range order {
+				if skipped[uid] {
+					confirm(uid)
+				}
+			}
+			handled = len(skipped)
+			m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: doneBase + handled})
+			if seq.Empty() {
+				for _, uid := range order[next:] {
+					confirm(uid)
+				}
+				return handled, nil
+			}
+		}
+	}
+
+	planOnly := m.opts.DryRun && m.opts.Planned != nil
+	var sizes []sizedMessage
+	if m.opts.MaxSize > 0 || m.opts.LargeMessage > 0 && !m.opts.FetchRFC822 || planOnly {
+		var err error
+		if sizes, err = m.messageSizes(seq); err != nil {
+			return handled, err
+		}
+	}
+
+	if m.opts.MaxSize > 0 {
+		over := map[uint32]bool{}
+		for _, s := range sizes {
+			if s.size > m.opts.MaxSize {
+				over[s.uid] = true
+				if !m.opts.Quiet {
+					log.Printf("[mailbox] %s: skipping UID %d, its %d bytes exceed the maximum size", name, s.uid, s.size)
+				}
+				if m.opts.Oversized != nil {
+					m.opts.Oversized(name, s.uid, s.size)
+				}
+			}
+		}
+		if len(over) > 0 {
+			rest := new(imap.SeqSet)
+			for _, uid := range order {
+				if seq.Contains(uid) && !over[uid] {
+					rest.AddNum(uid)
+				}
+				if over[uid] {
+					confirm(uid)
+				}
+			}
+			seq = rest
+			handled += len(over)
+			m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: doneBase + handled})
+			if seq.Empty() {
+				for _, uid := range order[next:] {
+					confirm(uid)
+				}
+				return handled, nil
+			}
+		}
+	}
+
+	if planOnly {
+		for _, s := range sizes {
+			if !seq.Contains(s.uid) {
+				continue
+			}
+			if !m.opts.Quiet {
+				log.Printf("[dry-run] append %s UID %d (%d bytes)", name, s.uid, s.size)
+			}
+			m.opts.Planned(name, s.uid, s.size)
+			handled++
+		}
+		m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: doneBase + handled})
+		return handled, nil
+	}
+
+	failures := 0 // in a row, see skipFailed
+	if m.opts.LargeMessage > 0 && !m.opts.FetchRFC822 {
+		var large []sizedMessage
+		for _, s := range sizes {
+			if s.size >= m.opts.LargeMessage && seq.Contains(s.uid) {
+				large = append(large, s)
+			}
+		}
+		if len(large) > 0 {
+			isLarge := make(map[uint32]bool, len(large))
+			for _, l := range large {
+				isLarge[l.uid] = true
+			}
+			rest := new(imap.SeqSet)
+			for _, uid := range order {
+				if seq.Contains(uid) && !isLarge[uid] {
+					rest.AddNum(uid)
+				}
+			}
+			seq = rest
+		}
+		for _, l := range large {
+			if err := m.copyLarge(ctx, name, l); err != nil {
+				if !m.skipFailed(ctx, m.dst, name, l.uid, err, &failures) {
+					return handled, err
+				}
+			} else {
+				failures = 0
+				if m.opts.Copied != nil && !m.opts.DryRun {
+					m.opts.Copied(name, l.uid)
+				}
+			}
+			confirm(l.uid)
+			handled++
+			m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: doneBase + handled})
+		}
+		if seq.Empty() {
+			for _, uid := range order[next:] {
+				confirm(uid)
+			}
+			return handled, nil
+		}
+	}
 
-	section := &imap.BodySectionName{}
+	// BODY.PEEK[] leaves \Seen alone even where the source mailbox is
+	// selected read-write
+	section := &imap.BodySectionName{Peek: true}
 	items := []imap.FetchItem{section.FetchItem(), imap.FetchInternalDate, imap.FetchFlags, imap.FetchUid}
+	if m.opts.FetchRFC822 {
+		// the RFC822 reply is filed under BODY[], so GetBody(section) works;
+		// RFC822 has no PEEK form, which is one more reason the source is
+		// only ever EXAMINEd
+		items[0] = imap.FetchRFC822
+	}
+	var mu sync.Mutex // guards confirm, done, failures and pending when copying in parallel
+	done := handled
+	// pending holds the messages read for a batched append, per
+	// destination connection; nil where appends are not batched
+	pending := map[*client.Client]*pendingBatch{}
+	batch := func(dst *client.Client) *pendingBatch {
+		mu.Lock()
+		defer mu.Unlock()
+		b, ok := pending[dst]
+		if !ok {
+			if m.batching(dst) {
+				b = &pendingBatch{}
+			}
+			pending[dst] = b
+		}
+		return b
+	}
+	// flush appends the pending batch of dst
+	flush := func(dst *client.Client) error {
+		b := batch(dst)
+		if b == nil || len(b.uids) == 0 {
+			return nil
+		}
+		uids, msgs := b.uids, b.msgs
+		*b = pendingBatch{}
+		for {
+			n, unverified, err := m.appendBatch(ctx, dst, name, uids, msgs)
+			mu.Lock()
+			for i, uid := range uids[:n] {
+				if unverified[i] != nil {
+					if !m.skipFailed(ctx, dst, name, uid, unverified[i], &failures) {
+						m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: doneBase + done})
+						mu.Unlock()
+						return unverified[i]
+					}
+				} else {
+					failures = 0
+					if m.opts.Copied != nil {
+						m.opts.Copied(name, uid)
+					}
+				}
+				confirm(uid)
+				done++
+			}
+			// the rest of the batch goes on after a message that failed
+			skip := err != nil && n < len(uids) && m.skipFailed(ctx, dst, name, uids[n], err, &failures)
+			if skip {
+				confirm(uids[n])
+				done++
+			}
+			m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: doneBase + done})
+			mu.Unlock()
+			if !skip {
+				return err
+			}
+			uids, msgs = uids[n+1:], msgs[n+1:]
+			if len(uids) == 0 {
+				return nil
+			}
+		}
+	}
+	// handle appends one fetched message through dst
+	handle := func(dst *client.Client, msg *imap.Message) error {
+		uid, date, flags := msg.Uid, msg.InternalDate, msg.Flags
+		lit := msg.GetBody(section)
+		if lit == nil {
+			if !m.opts.Quiet {
+				log.Printf("[mailbox] %s: UID %d has no body, skipped", name, uid)
+			}
+			mu.Lock()
+			confirm(uid)
+			mu.Unlock()
+			return nil
+		}
+		if m.opts.DryRun {
+			if !m.opts.Quiet {
+				log.Printf("[dry-run] append %s UID %d flags=%v date=%s", name, uid, flags, date)
+			}
+			mu.Lock()
+			done++
+			m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: doneBase + done})
+			mu.Unlock()
+			return nil
+		}
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(lit); err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+		date = imaputil.InternalDate(date, buf.Bytes())
+		if b := batch(dst); b != nil {
+			if len(b.uids) > 0 && m.opts.AppendBatchBytes > 0 && b.size+int64(buf.Len()) > m.opts.AppendBatchBytes {
+				if err := flush(dst); err != nil {
+					return err
+				}
+			}
+			parts := [][]byte{buf.Bytes()}
+			if len(m.opts.Headers) > 0 {
+				parts = [][]byte{m.opts.Headers, buf.Bytes()}
+			}
+			b.uids = append(b.uids, uid)
+			b.msgs = append(b.msgs, imaputil.AppendMessage{Flags: m.appendFlags(flags), Date: date, Parts: parts})
+			b.size += int64(len(m.opts.Headers) + buf.Len())
+			if len(b.uids) >= m.opts.AppendBatch {
+				return flush(dst)
+			}
+			return nil
+		}
+		err := m.appendToDst(ctx, dst, name, uid, buf.Bytes(), date, flags, nil)
+		if err == nil && m.opts.Copied != nil {
+			m.opts.Copied(name, uid)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			failures = 0
+		} else if !m.skipFailed(ctx, dst, name, uid, err, &failures) {
+			return err
+		}
+		confirm(uid)
+		done++
+		m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: total, Done: doneBase + done})
+		return nil
+	}
+
+	var rest []uint32
+	for _, uid := range order {
+		if seq.Contains(uid) {
+			rest = append(rest, uid)
+		}
+	}
+	var err error
+	if lanes := m.lanes(len(rest)); lanes > 1 {
+		err = m.copyParallel(ctx, name, rest, lanes, items, handle, flush)
+	} else {
+		err = fetchEach(ctx, m.src, seq, items, func(msg *imap.Message) error { return handle(m.dst, msg) })
+		if err == nil {
+			err = flush(m.dst)
+		}
+	}
+	if err != nil {
+		return done, err
+	}
+	// UIDs the server did not return were expunged meanwhile
+	for _, uid := range order[next:] {
+		confirm(uid)
+	}
+	return done, nil
+}
+
+// maxFailures is how many messages in a row may fail before a mailbox is
+// given up despite Options.Failed: that many point at the destination,
+// not at the messages.
+const maxFailures = 20
+
+// skipFailed reports whether the copy of mailbox name goes on after the
+// message uid failed with err, and if so reports it to Options.Failed.
+// failures counts the failures in a row.
+func (m *MailboxSyncer) skipFailed(ctx context.Context, dst *client.Client, name string, uid uint32, err error, failures *int) bool {
+	if m.opts.Failed == nil || ctx.Err() != nil || m.src.State() == imap.LogoutState || dst != nil && dst.State() == imap.LogoutState {
+		return false
+	}
+	if _, frozen := pacer.DetectFreeze(err); frozen {
+		return false
+	}
+	if *failures++; *failures >= maxFailures {
+		log.Printf("[mailbox] %s: %d messages in a row failed, giving up", name, *failures)
+		return false
+	}
+	log.Printf("[mailbox] %s: UID %d not copied: %v", name, uid, err)
+	m.opts.Failed(name, uid, err)
+	return true
+}
+
+// fetchEach runs UID FETCH of seq on c and calls fn for each message as it
+// arrives. It returns the first error of fn, the fetch or ctx.
+func fetchEach(ctx context.Context, c *client.Client, seq *imap.SeqSet, items []imap.FetchItem, fn func(msg *imap.Message) error) error {
 	msgs := make(chan *imap.Message, 64)
 	doneCh := make(chan error, 1)
 	go func() {
-		doneCh <- m.src.UidFetch(seq, items, msgs)
+		doneCh <- c.UidFetch(seq, items, msgs)
 	}()
-	done := 0
 	fetchErr := error(nil)
-	msgsClosed := false
+	fetchDone := false
 	for {
 		select {
 		case msg, ok := <-msgs:
 			if !ok {
-				msgsClosed = true
-				// if fetch already errored, return it
-				if fetchErr != nil {
-					return fetchErr
+				// the channel may close before the fetch reports how it
+				// ended; a broken fetch must not pass the missing UIDs
+				if !fetchDone {
+					select {
+					case fetchErr = <-doneCh:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 				}
-				// otherwise we are done reading all messages
-				m.emit(Event{Type: EventMailboxDone, Mailbox: name})
-				return nil
+				return fetchErr
 			}
 			if msg == nil {
 				continue
 			}
-			uid := msg.Uid
-			date := msg.InternalDate
-			flags := msg.Flags
-			lit := msg.GetBody(section)
-			if lit == nil {
-				if !m.opts.Quiet {
-					log.Printf("[mailbox] %s: UID %d has no body, skipped", name, uid)
-				}
-				continue
-			}
-			if m.opts.DryRun {
-				if !m.opts.Quiet {
-					log.Printf("[dry-run] append %s UID %d flags=%v date=%s", name, uid, flags, date)
-				}
-				done++
-				m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: len(uids), Done: done})
-				continue
-			}
-			if err := m.appendToDst(name, lit, date, flags); err != nil {
+			if err := fn(msg); err != nil {
 				return err
 			}
-			m.st.SetMaxUID(name, uid)
-			done++
-			m.emit(Event{Type: EventMailboxProgress, Mailbox: name, Total: len(uids), Done: done})
 		case err := <-doneCh:
 			// record fetch completion (and possible error) but continue draining msgs
+			fetchDone = true
+			fetchErr = err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// minLaneMessages is the fewest messages worth an extra connection.
+const minLaneMessages = 50
+
+// lanes returns the number of connections to copy n messages of one
+// mailbox with (see Options.Connections).
+func (m *MailboxSyncer) lanes(n int) int {
+	if m.opts.Connections < 2 || m.opts.SrcPool == nil || m.opts.Deliver == nil && m.opts.DstPool == nil {
+		return 1
+	}
+	return max(1, min(m.opts.Connections, n/minLaneMessages))
+}
+
+// copyParallel copies uids (ascending) of the selected source mailbox over
+// up to lanes connection pairs. The UIDs are split into contiguous parts,
+// each fetched on its own source connection, and the messages are handed
+// to one appender per destination connection, which calls flush once it
+// has handled its last message. The first pair is the
+// syncer's own; the others are checked out of the pools while they have
+// free connections and given back at the end.
+func (m *MailboxSyncer) copyParallel(ctx context.Context, name string, uids []uint32, lanes int, items []imap.FetchItem, handle func(dst *client.Client, msg *imap.Message) error, flush func(dst *client.Client) error) error {
+	srcs, dsts := []*client.Client{m.src}, []*client.Client{m.dst}
+	defer func() {
+		for i := 1; i < len(srcs); i++ {
+			m.opts.SrcPool.Put(srcs[i])
+			if dsts[i] != nil {
+				m.opts.DstPool.Put(dsts[i])
+			}
+		}
+	}()
+	for len(srcs) < lanes {
+		src, dst, err := m.lane(ctx, name)
+		if err != nil {
+			if !errors.Is(err, imaputil.ErrPoolBusy) {
+				log.Printf("[mailbox] %s: cannot open connection %d: %v; going on with %d", name, len(srcs)+1, err, len(srcs))
+			}
+			break
+		}
+		srcs, dsts = append(srcs, src), append(dsts, dst)
+	}
+	if len(srcs) > 1 && !m.opts.Quiet {
+		log.Printf("[mailbox] %s: copying %d messages over %d connections", name, len(uids), len(srcs))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	msgs := make(chan *imap.Message, 64)
+	var fetchers, appenders sync.WaitGroup
+	size := (len(uids) + len(srcs) - 1) / len(srcs)
+	for i, src := range srcs {
+		part := uids[min(i*size, len(uids)):min((i+1)*size, len(uids))]
+		if len(part) == 0 {
+			continue
+		}
+		seq := new(imap.SeqSet)
+		seq.AddNum(part...)
+		fetchers.Add(1)
+		go func() {
+			defer fetchers.Done()
+			err := fetchEach(ctx, src, seq, items, func(msg *imap.Message) error {
+				select {
+				case msgs <- msg:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
 			if err != nil {
-				fetchErr = err
-				// if messages channel already closed, return immediately
-				if msgsClosed {
-					return fetchErr
+				fail(err)
+			}
+		}()
+	}
+	go func() {
+		fetchers.Wait()
+		close(msgs)
+	}()
+	for _, dst := range dsts {
+		appenders.Add(1)
+		go func() {
+			defer appenders.Done()
+			for msg := range msgs {
+				if ctx.Err() != nil {
+					continue // drain after a failure
+				}
+				if err := handle(dst, msg); err != nil {
+					fail(err)
 				}
 			}
-		case <-ctx.Done():
-			return ctx.Err()
+			if ctx.Err() == nil {
+				if err := flush(dst); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+	appenders.Wait()
+	return firstErr
+}
+
+// lane checks out an extra source connection, with mailbox name
+// selected, and, unless Options.Deliver replaces IMAP, an extra
+// destination connection.
+func (m *MailboxSyncer) lane(ctx context.Context, name string) (src, dst *client.Client, err error) {
+	if src, err = m.opts.SrcPool.TryGet(ctx); err != nil {
+		return nil, nil, err
+	}
+	if _, err = imaputil.SelectMailbox(src, name, true); err != nil {
+		m.opts.SrcPool.Put(src)
+		return nil, nil, err
+	}
+	if m.opts.Deliver == nil {
+		if dst, err = m.opts.DstPool.TryGet(ctx); err != nil {
+			m.opts.SrcPool.Put(src)
+			return nil, nil, err
 		}
 	}
+	return src, dst, nil
 }
 
-func (m *MailboxSyncer) ensureDstMailbox(name string) error {
+// skipMessages returns the messages of uids that are not to be copied:
+// those Options.Filter rejects and those Options.Skip reports as already
+// in the destination.
+func (m *MailboxSyncer) skipMessages(name string, uids []uint32) (map[uint32]bool, error) {
+	skipped := make(map[uint32]bool)
+	if m.opts.Filter != nil {
+		hdrs, err := imaputil.HeaderFields(m.src, uids, m.opts.FilterFields)
+		if err != nil {
+			return nil, err
+		}
+		kept := make([]uint32, 0, len(uids))
+		for _, uid := range uids {
+			if hdr, ok := hdrs[uid]; ok && !m.opts.Filter(hdr) {
+				skipped[uid] = true
+			} else {
+				kept = append(kept, uid)
+			}
+		}
+		if !m.opts.Quiet && len(skipped) > 0 {
+			log.Printf("[mailbox] %s: %d messages do not match the header filter, skipped", name, len(skipped))
+		}
+		uids = kept
+	}
+	if m.opts.Skip != nil && len(uids) > 0 {
+		present, err := m.skipPresent(name, uids)
+		if err != nil {
+			return nil, err
+		}
+		for uid := range present {
+			skipped[uid] = true
+		}
+		if !m.opts.Quiet && len(present) > 0 {
+			log.Printf("[mailbox] %s: %d messages already in the destination, skipped", name, len(present))
+		}
+	}
+	return skipped, nil
+}
+
+// skipPresent fetches the envelopes of uids and returns those that
+// Options.Skip reports as already in the destination.
+func (m *MailboxSyncer) skipPresent(name string, uids []uint32) (map[uint32]bool, error) {
+	seq := new(imap.SeqSet)
+	seq.AddNum(uids...)
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- m.src.UidFetch(seq, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope}, msgs)
+	}()
+	skipped := make(map[uint32]bool)
 	dstName := m.mapName(name)
-	_, err := imaputil.SelectMailbox(m.dst, dstName, false)
-	if err == nil {
+	for msg := range msgs {
+		if msg != nil && m.opts.Skip(dstName, msg.Envelope) {
+			skipped[msg.Uid] = true
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return skipped, nil
+}
+
+// largeMessage is a message of at least Options.LargeMessage bytes.
+// sizedMessage is a message and its RFC822.SIZE.
+type sizedMessage struct {
+	uid  uint32
+	size int64
+}
+
+// messageSizes returns the RFC822.SIZE of the messages in uids, by UID.
+func (m *MailboxSyncer) messageSizes(uids *imap.SeqSet) ([]sizedMessage, error) {
+	msgs := make(chan *imap.Message, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- m.src.UidFetch(uids, []imap.FetchItem{imap.FetchUid, imap.FetchRFC822Size}, msgs)
+	}()
+	var sizes []sizedMessage
+	for msg := range msgs {
+		if msg != nil {
+			sizes = append(sizes, sizedMessage{uid: msg.Uid, size: int64(msg.Size)})
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].uid < sizes[j].uid })
+	return sizes, nil
+}
+
+// copyLarge copies one large message. It is fetched in partial chunks of
+// largeChunk bytes and appended with imaputil.AppendProgress, and both
+// steps report their bytes as EventMessageProgress, so a message of a few
+// gigabytes does not look like a stalled copy.
+func (m *MailboxSyncer) copyLarge(ctx context.Context, name string, l sizedMessage) error {
+	if m.opts.DryRun {
+		if !m.opts.Quiet {
+			log.Printf("[dry-run] append %s UID %d (%d bytes)", name, l.uid, l.size)
+		}
 		return nil
 	}
-	// Try to create
-	if err := m.dst.Create(dstName); err != nil {
-		// If already exists or cannot create, attempt select again
-		if _, selErr := imaputil.SelectMailbox(m.dst, dstName, false); selErr == nil {
-			return nil
+	if !m.opts.Quiet {
+		log.Printf("[mailbox] %s: UID %d has %d bytes, fetching in chunks", name, l.uid, l.size)
+	}
+	seq := new(imap.SeqSet)
+	seq.AddNum(l.uid)
+	var buf bytes.Buffer
+	buf.Grow(int(l.size))
+	var date time.Time
+	var flags []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		off := buf.Len()
+		section := &imap.BodySectionName{Peek: true, Partial: []int{off, largeChunk}}
+		items := []imap.FetchItem{section.FetchItem()}
+		if off == 0 {
+			items = append(items, imap.FetchInternalDate, imap.FetchFlags)
+		}
+		msgs := make(chan *imap.Message, 1)
+		done := make(chan error, 1)
+		go func() {
+			done <- m.src.UidFetch(seq, items, msgs)
+		}()
+		var lit imap.Literal
+		for msg := range msgs {
+			if msg == nil {
+				continue
+			}
+			if off == 0 {
+				date, flags = msg.InternalDate, msg.Flags
+			}
+			if b := msg.GetBody(section); b != nil {
+				lit = b
+			}
+		}
+		if err := <-done; err != nil {
+			return err
+		}
+		if lit == nil {
+			return fmt.Errorf("UID %d: no body at offset %d", l.uid, off)
+		}
+		n, err := buf.ReadFrom(lit)
+		if err != nil {
+			return fmt.Errorf("read message: %w", err)
+		}
+		m.emit(Event{Type: EventMessageProgress, Mailbox: name, UID: l.uid, Phase: "fetch", Bytes: int64(buf.Len()), Size: l.size})
+		// RFC822.SIZE may be off a little; a short chunk is the end
+		if n < largeChunk {
+			break
+		}
+	}
+	date = imaputil.InternalDate(date, buf.Bytes())
+	return m.appendToDst(ctx, m.dst, name, l.uid, buf.Bytes(), date, flags, func(sent, size int64) {
+		m.emit(Event{Type: EventMessageProgress, Mailbox: name, UID: l.uid, Phase: "append", Bytes: sent, Size: size})
+	})
+}
+
+func (m *MailboxSyncer) ensureDstMailbox(name string) error {
+	dstName := m.mapName(name)
+	got, err := imaputil.CreateFitting(m.dst, dstName, m.opts.Delimiter, m.opts.NameLimits)
+	if err != nil {
+		return fmt.Errorf("create mailbox %s: %w", got, err)
+	}
+	if got != dstName {
+		log.Printf("[mailbox] %s: the destination refused %q as too long or too deep, using %q", name, dstName, got)
+		m.renamed.mu.Lock()
+		m.renamed.m[name] = got
+		m.renamed.mu.Unlock()
+		if m.opts.Renamed != nil {
+			m.opts.Renamed(name, got)
+		}
+	}
+	if m.opts.Subscribe != nil && m.opts.Subscribe(name) {
+		// a refused SUBSCRIBE leaves the folder hidden, not the copy broken
+		if err := m.dst.Subscribe(got); err != nil {
+			log.Printf("[mailbox] %s: subscribe %s: %v", name, got, err)
 		}
-		return fmt.Errorf("create mailbox %s: %w", dstName, err)
 	}
 	return nil
 }
 
-func (m *MailboxSyncer) appendToDst(name string, r imap.Literal, date time.Time, flags []string) error {
-	// Ensure mailbox selected RW
+// copyACL sets the ACL entries of source mailbox name on its destination
+// mailbox, or with DryRun logs what it would set. Failures are logged: a
+// missing right does not stop the copy.
+func (m *MailboxSyncer) copyACL(name string) {
+	dstName := m.mapName(name)
+	src, err := imaputil.GetACL(m.src, name)
+	if err != nil {
+		log.Printf("[acl] %s: getacl: %v", name, err)
+		return
+	}
+	// with DryRun the destination mailbox may not exist yet
+	dst, err := imaputil.GetACL(m.dst, dstName)
+	if err != nil && !m.opts.DryRun {
+		log.Printf("[acl] %s: getacl %s: %v", name, dstName, err)
+		return
+	}
+	for _, ch := range imaputil.DiffACL(src, dst, m.opts.ACLOwners...) {
+		if m.opts.DryRun {
+			log.Printf("[acl] %s: would set %s on %s to %q (now %q)", name, ch.Identifier, dstName, ch.New, ch.Old)
+			continue
+		}
+		if err := imaputil.SetACL(m.dst, dstName, ch.Identifier, ch.New); err != nil {
+			log.Printf("[acl] %s: setacl %s %q on %s: %v", name, ch.Identifier, ch.New, dstName, err)
+			continue
+		}
+		if !m.opts.Quiet {
+			log.Printf("[acl] %s: set %s on %s to %q", name, ch.Identifier, dstName, ch.New)
+		}
+	}
+}
+
+// appendToDst appends raw, the message uid of mailbox name, to its
+// destination through dst, the syncer's destination connection or that of
+// a parallel lane, and records the UID it got there in the state. If
+// progress is set, it is called with the bytes sent so far and the total,
+// including Options.Headers; Deliver reports no progress.
+func (m *MailboxSyncer) appendToDst(ctx context.Context, dst *client.Client, name string, uid uint32, raw []byte, date time.Time, flags []string, progress func(sent, size int64)) error {
 	dstName := m.mapName(name)
-	if _, err := imaputil.SelectMailbox(m.dst, dstName, false); err != nil {
+	if m.opts.Deliver != nil {
+		return m.opts.Pacer.Do(ctx, func() error {
+			return m.opts.Deliver(ctx, dstName, bytes.Join([][]byte{m.opts.Headers, raw}, nil))
+		})
+	}
+	// Ensure mailbox selected RW
+	status, err := imaputil.SelectMailbox(dst, dstName, false)
+	if err != nil {
+		return err
+	}
+	filtered := m.appendFlags(flags)
+	parts := [][]byte{raw}
+	if len(m.opts.Headers) > 0 {
+		parts = [][]byte{m.opts.Headers, raw}
+	}
+
+	var sent func(int64)
+	if progress != nil {
+		size := int64(len(m.opts.Headers) + len(raw))
+		sent = func(n int64) { progress(n, size) }
+	}
+	// raw stays in memory, so a throttled append can be retried
+	var dstUID uint32
+	err = m.opts.Pacer.Do(ctx, func() (err error) {
+		dstUID, err = imaputil.AppendUID(dst, dstName, filtered, date, sent, parts...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("append: %w", err)
+	}
+	if err := imaputil.VerifyAppended(dst, dstName, dstUID, status.UidNext, parts, m.opts.Verify); err != nil {
 		return err
 	}
-	// Filter flags: some servers reject the \Recent system flag on APPEND.
+	if dstUID != 0 {
+		m.st.SetDstUID(name, uid, dstName, status.UidValidity, dstUID)
+	}
+	return nil
+}
+
+// appendFlags returns flags without \Recent, a system flag some servers
+// reject on APPEND, and mapped by Options.FlagMap.
+func (m *MailboxSyncer) appendFlags(flags []string) []string {
 	filtered := make([]string, 0, len(flags))
 	for _, f := range flags {
 		if strings.EqualFold(f, "\\Recent") {
@@ -207,11 +1446,57 @@ func (m *MailboxSyncer) appendToDst(name string, r imap.Literal, date time.Time,
 		}
 		filtered = append(filtered, f)
 	}
+	return m.opts.FlagMap.Apply(filtered)
+}
 
-	if err := m.dst.Append(dstName, filtered, date, r); err != nil {
-		return fmt.Errorf("append: %w", err)
+// pendingBatch is the messages read for one batched APPEND.
+type pendingBatch struct {
+	uids []uint32
+	msgs []imaputil.AppendMessage
+	size int64
+}
+
+// batching reports whether appends through dst are batched (see
+// Options.AppendBatch).
+func (m *MailboxSyncer) batching(dst *client.Client) bool {
+	if m.opts.AppendBatch < 2 || m.opts.Deliver != nil || m.opts.DryRun || dst == nil {
+		return false
 	}
-	return nil
+	ok, _ := dst.Support("MULTIAPPEND")
+	return ok
+}
+
+// appendBatch appends msgs to the destination of mailbox name through dst
+// with one MULTIAPPEND, paced as len(msgs) appends; uids are the source
+// UIDs of msgs. It returns how many of msgs, from the start, are stored
+// and, with Options.Verify, the verification error of each of them (nil
+// if it passed). The UIDs the destination gave them go into the state.
+func (m *MailboxSyncer) appendBatch(ctx context.Context, dst *client.Client, name string, uids []uint32, msgs []imaputil.AppendMessage) (int, []error, error) {
+	dstName := m.mapName(name)
+	status, err := imaputil.SelectMailbox(dst, dstName, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	stored := 0
+	var dstUIDs []uint32
+	// a throttled batch is retried with the messages not yet stored
+	err = m.opts.Pacer.DoN(ctx, len(msgs), func() error {
+		n, got, err := imaputil.MultiAppend(dst, dstName, msgs[stored:])
+		stored += n
+		dstUIDs = append(dstUIDs, got[:n]...)
+		return err
+	})
+	unverified := make([]error, stored)
+	for i, msg := range msgs[:stored] {
+		unverified[i] = imaputil.VerifyAppended(dst, dstName, dstUIDs[i], status.UidNext, msg.Parts, m.opts.Verify)
+		if unverified[i] == nil && dstUIDs[i] != 0 {
+			m.st.SetDstUID(name, uids[i], dstName, status.UidValidity, dstUIDs[i])
+		}
+	}
+	if err != nil {
+		return stored, unverified, fmt.Errorf("append: %w", err)
+	}
+	return stored, unverified, nil
 }
 
 // Events returns a read-only channel of progress events.
@@ -225,7 +1510,19 @@ func (m *MailboxSyncer) emit(ev Event) {
 	}
 }
 
+func (m *MailboxSyncer) checkpoint() {
+	if m.opts.Checkpoint != nil {
+		m.opts.Checkpoint()
+	}
+}
+
 func (m *MailboxSyncer) mapName(name string) string {
+	m.renamed.mu.Lock()
+	to, ok := m.renamed.m[name]
+	m.renamed.mu.Unlock()
+	if ok {
+		return to
+	}
 	if m.opts.Map == nil {
 		return name
 	}
@@ -0,0 +1,1026 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+
+	"github.com/pepperpark/gomap/internal/imaputil"
+	"github.com/pepperpark/gomap/internal/state"
+)
+
+// faultBackend wraps the in-memory backend: it can answer FETCH in reverse
+// UID order (as servers are allowed to) and fail APPEND once a number of
+// messages has been stored, which stands in for a crash between fetch and
+// append. With seen, fetching a body without PEEK sets \Seen, as on a
+// real server; with reject, APPENDs of messages containing it are refused,
+// and with mangle, such messages are stored with a byte added.
+type faultBackend struct {
+	*memory.Backend
+	mu        sync.Mutex
+	reverse   bool
+	failAfter int // appends that succeed before all others fail; -1 never fails
+	appends   int
+	seen      bool
+	reject    string
+	mangle    string
+}
+
+func (be *faultBackend) Login(ci *imap.ConnInfo, username, password string) (backend.User, error) {
+	u, err := be.Backend.Login(ci, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &faultUser{User: u, be: be}, nil
+}
+
+type faultUser struct {
+	backend.User
+	be *faultBackend
+}
+
+func (u *faultUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mb, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultMailbox{Mailbox: mb, be: u.be}, nil
+}
+
+type faultMailbox struct {
+	backend.Mailbox
+	be *faultBackend
+}
+
+func (mb *faultMailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	inner := make(chan *imap.Message)
+	var msgs []*imap.Message
+	collected := make(chan struct{})
+	go func() {
+		for m := range inner {
+			msgs = append(msgs, m)
+		}
+		close(collected)
+	}()
+	err := mb.Mailbox.ListMessages(uid, seqSet, items, inner)
+	<-collected
+	mb.be.mu.Lock()
+	reverse, seen := mb.be.reverse, mb.be.seen
+	mb.be.mu.Unlock()
+	for _, item := range items {
+		if seen && err == nil && (item == imap.FetchRFC822 || strings.HasPrefix(string(item), "BODY[")) {
+			err = mb.Mailbox.UpdateMessagesFlags(uid, seqSet, imap.AddFlags, []string{imap.SeenFlag})
+		}
+	}
+	for i := range msgs {
+		if reverse {
+			ch <- msgs[len(msgs)-1-i]
+		} else {
+			ch <- msgs[i]
+		}
+	}
+	close(ch)
+	return err
+}
+
+func (mb *faultMailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	mb.be.mu.Lock()
+	if mb.be.failAfter >= 0 && mb.be.appends >= mb.be.failAfter {
+		mb.be.mu.Unlock()
+		return errors.New("injected failure")
+	}
+	mb.be.appends++
+	reject, mangle := mb.be.reject, mb.be.mangle
+	mb.be.mu.Unlock()
+	if reject != "" || mangle != "" {
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		if reject != "" && strings.Contains(string(raw), reject) {
+			return backend.ErrTooBig
+		}
+		if mangle != "" && strings.Contains(string(raw), mangle) {
+			raw = append(raw, '\n')
+		}
+		return mb.Mailbox.CreateMessage(flags, date, bytes.NewReader(raw))
+	}
+	return mb.Mailbox.CreateMessage(flags, date, body)
+}
+
+// serve starts an IMAP server for be and returns a logged-in client.
+func serve(t *testing.T, be backend.Backend) *client.Client {
+	t.Helper()
+	c, err := dial(listen(t, be))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// listen serves be, with the server extensions exts, until the test ends
+// and returns its address.
+func listen(t *testing.T, be backend.Backend, exts ...server.Extension) string {
+	t.Helper()
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+	s.Enable(exts...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func dial(addr string) (*client.Client, error) {
+	c, err := client.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Login("username", "password"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func mailbox(t *testing.T, be *memory.Backend, name string) *memory.Mailbox {
+	t.Helper()
+	u, err := be.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb, err := u.GetMailbox(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mb.(*memory.Mailbox)
+}
+
+func TestStateNeverSkipsUnconfirmedAppends(t *testing.T) {
+	srcBe := &faultBackend{Backend: memory.New(), reverse: true, failAfter: -1}
+	inbox := mailbox(t, srcBe.Backend, "INBOX")
+	for i := 0; i < 5; i++ {
+		body := fmt.Sprintf("Subject: %d\r\nMessage-ID: <%d@test>\r\n\r\nbody %d\r\n", i, i, i)
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bodies := map[uint32]string{}
+	for _, m := range inbox.Messages {
+		bodies[m.Uid] = string(m.Body)
+	}
+	dstBe := &faultBackend{Backend: memory.New(), failAfter: 2}
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	opts := Options{Quiet: true, Map: map[string]string{"INBOX": "Copy"}}
+
+	// FETCH returns the highest UIDs first; the third APPEND fails.
+	if errs := NewMailboxSyncer(src, dst, st, opts).SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 1 {
+		t.Fatalf("want the injected failure, got %v", errs)
+	}
+	copied := map[string]bool{}
+	for _, m := range mailbox(t, dstBe.Backend, "Copy").Messages {
+		copied[string(m.Body)] = true
+	}
+	if len(copied) != 2 {
+		t.Fatalf("want 2 messages before the failure, got %d", len(copied))
+	}
+	max := st.GetMaxUID("INBOX")
+	for uid, body := range bodies {
+		if uid <= max && !copied[body] {
+			t.Errorf("state advanced to UID %d, but UID %d was never copied", max, uid)
+		}
+	}
+
+	// Resume without faults: every message arrives, none is skipped, and
+	// the ones copied above the failure are not copied twice.
+	dstBe.mu.Lock()
+	dstBe.failAfter = -1
+	dstBe.mu.Unlock()
+	if errs := NewMailboxSyncer(src, dst, st, opts).SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatalf("resume: %v", errs)
+	}
+	copied = map[string]bool{}
+	msgs := mailbox(t, dstBe.Backend, "Copy").Messages
+	for _, m := range msgs {
+		copied[string(m.Body)] = true
+	}
+	if len(msgs) != len(bodies) {
+		t.Errorf("%d messages after resume, want %d", len(msgs), len(bodies))
+	}
+	for uid, body := range bodies {
+		if !copied[body] {
+			t.Errorf("UID %d missing after resume", uid)
+		}
+	}
+	if got, want := st.GetMaxUID("INBOX"), inbox.Messages[len(inbox.Messages)-1].Uid; got != want {
+		t.Errorf("max UID %d, want %d", got, want)
+	}
+	if len(st.Done) != 0 {
+		t.Errorf("done UIDs left after resume: %v", st.Done)
+	}
+}
+
+func TestLargeMessageProgress(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	large := "Subject: large\r\n\r\n" + strings.Repeat("0123456789abcdef", 20<<20/16) + "\r\n"
+	for _, body := range []string{"Subject: small\r\n\r\nsmall\r\n", large} {
+		if err := inbox.CreateMessage([]string{imap.SeenFlag}, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	w := NewMailboxSyncer(src, dst, st, Options{Quiet: true, Map: map[string]string{"INBOX": "Copy"}, LargeMessage: 1 << 20})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	copied := map[string]bool{}
+	for _, m := range mailbox(t, dstBe, "Copy").Messages {
+		copied[string(m.Body)] = true
+	}
+	if len(copied) != 3 || !copied[large] {
+		t.Fatalf("large message not copied intact (%d messages)", len(copied))
+	}
+	last := map[string]Event{}
+	fetches := 0
+	for ev := range w.Events() {
+		if ev.Type == EventMessageProgress {
+			last[ev.Phase] = ev
+			if ev.Phase == "fetch" {
+				fetches++
+			}
+		}
+	}
+	if fetches != 3 {
+		t.Errorf("%d fetch progress events, want 3 (8 MiB chunks)", fetches)
+	}
+	for _, phase := range []string{"fetch", "append"} {
+		if ev := last[phase]; ev.Bytes != int64(len(large)) || ev.Size != int64(len(large)) {
+			t.Errorf("last %s progress %d of %d, want %d", phase, ev.Bytes, ev.Size, len(large))
+		}
+	}
+}
+
+func TestMaxSizeSkipsOversized(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	big := "Subject: big\r\n\r\n" + strings.Repeat("x", 4096) + "\r\n"
+	for _, body := range []string{big, "Subject: small\r\n\r\nsmall\r\n"} {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	var skipped []uint32
+	w := NewMailboxSyncer(src, dst, st, Options{
+		Quiet:   true,
+		Map:     map[string]string{"INBOX": "Copy"},
+		MaxSize: 1024,
+		Oversized: func(mailbox string, uid uint32, size int64) {
+			if mailbox != "INBOX" || size != int64(len(big)) {
+				t.Errorf("Oversized(%q, %d, %d)", mailbox, uid, size)
+			}
+			skipped = append(skipped, uid)
+		},
+	})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	for _, m := range mailbox(t, dstBe, "Copy").Messages {
+		if string(m.Body) == big {
+			t.Error("oversized message was copied")
+		}
+	}
+	if n := len(mailbox(t, dstBe, "Copy").Messages); n != 2 {
+		t.Errorf("%d messages copied, want 2 (memory INBOX message and the small one)", n)
+	}
+	msgs := inbox.Messages
+	bigUID, lastUID := msgs[len(msgs)-2].Uid, msgs[len(msgs)-1].Uid
+	if len(skipped) != 1 || skipped[0] != bigUID {
+		t.Errorf("skipped %v, want UID %d", skipped, bigUID)
+	}
+	if got := st.GetMaxUID("INBOX"); got != lastUID {
+		t.Errorf("resume state at UID %d, want %d (the skipped message counts as handled)", got, lastUID)
+	}
+}
+
+func TestMissingMailboxSkipped(t *testing.T) {
+	srcBe, dstBe := memory.New(), memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	w := NewMailboxSyncer(src, dst, st, Options{Quiet: true})
+	// "Gone" was listed, then deleted before its turn
+	if errs := w.SyncAll(context.Background(), []string{"Gone", "INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	if n := len(mailbox(t, dstBe, "INBOX").Messages); n != 2 {
+		t.Errorf("%d messages in the destination INBOX, want 2", n)
+	}
+	u, _ := dstBe.Login(nil, "username", "password")
+	if _, err := u.GetMailbox("Gone"); err == nil {
+		t.Error("the missing mailbox was created on the destination")
+	}
+}
+
+func TestFilterSkipsRejected(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for _, body := range []string{
+		"From: news@example.com\r\nList-Id: <news.example.com>\r\nSubject: weekly\r\n\r\nnews\r\n",
+		"From: alice@example.org\r\nSubject: hello\r\n\r\nhi\r\n",
+	} {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	w := NewMailboxSyncer(src, dst, st, Options{
+		Quiet:        true,
+		Map:          map[string]string{"INBOX": "Copy"},
+		FilterFields: []string{"List-Id"},
+		Filter:       func(hdr mail.Header) bool { return hdr.Get("List-Id") == "" },
+	})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	for _, m := range mailbox(t, dstBe, "Copy").Messages {
+		if strings.Contains(string(m.Body), "List-Id") {
+			t.Error("filtered message was copied")
+		}
+	}
+	if n := len(mailbox(t, dstBe, "Copy").Messages); n != 2 {
+		t.Errorf("%d messages copied, want 2 (memory INBOX message and alice's)", n)
+	}
+	msgs := inbox.Messages
+	if got, want := st.GetMaxUID("INBOX"), msgs[len(msgs)-1].Uid; got != want {
+		t.Errorf("resume state at UID %d, want %d", got, want)
+	}
+}
+
+func TestBeforeBound(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for _, d := range []time.Time{
+		time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	} {
+		if err := inbox.CreateMessage(nil, d, bytes.NewBufferString("Subject: "+d.Format("2006")+"\r\n\r\nhi\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	w := NewMailboxSyncer(src, dst, st, Options{
+		Quiet:  true,
+		Map:    map[string]string{"INBOX": "Archive"},
+		Since:  time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Before: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	msgs := mailbox(t, dstBe, "Archive").Messages
+	if len(msgs) != 1 || !strings.Contains(string(msgs[0].Body), "Subject: 2019") {
+		t.Errorf("copied %d messages, want only the one from 2019", len(msgs))
+	}
+}
+
+func TestCopyByUID(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for i := 0; i < 4; i++ {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(fmt.Sprintf("Subject: %d\r\n\r\nhi\r\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	st.SetMaxUID("INBOX", 10) // all copied before (the memory message is UID 6)
+	uids, _ := imap.ParseSeqSet("7:8,20")
+	w := NewMailboxSyncer(src, dst, st, Options{Quiet: true, Map: map[string]string{"INBOX": "Again"}, UIDs: uids})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	var got []string
+	for _, m := range mailbox(t, dstBe, "Again").Messages {
+		got = append(got, strings.SplitN(string(m.Body), "\r\n", 2)[0])
+	}
+	if strings.Join(got, ",") != "Subject: 0,Subject: 1" {
+		t.Errorf("copied %q, want the messages of UIDs 7 and 8", got)
+	}
+	if n := st.GetMaxUID("INBOX"); n != 10 {
+		t.Errorf("resume state at UID %d, want it left at 10", n)
+	}
+}
+
+func TestMonthlyWindows(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	now := time.Now()
+	for _, d := range []time.Time{now.AddDate(-1, 0, 0), now.AddDate(0, -2, 0), now} {
+		if err := inbox.CreateMessage(nil, d, bytes.NewBufferString("Subject: "+d.Format("2006-01")+"\r\n\r\nhi\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	var labels []string
+	w := NewMailboxSyncer(src, dst, st, Options{
+		Quiet:          true,
+		Map:            map[string]string{"INBOX": "Copy"},
+		SplitThreshold: 1,
+		SplitMonthly:   true,
+		Checkpoint: func() {
+			if l := st.WindowLabels("INBOX"); len(l) > 0 {
+				labels = l
+			}
+		},
+	})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	if n := len(mailbox(t, dstBe, "Copy").Messages); n != 4 {
+		t.Errorf("%d messages copied, want 4", n)
+	}
+	// the last checkpoint before FinishWindows holds every window copied
+	want := []string{now.AddDate(-1, 0, 0).Format("2006-01"), now.AddDate(0, -2, 0).Format("2006-01"), now.Format("2006-01")}
+	if strings.Join(labels, ",") != strings.Join(want, ",") {
+		t.Errorf("windows %v, want %v", labels, want)
+	}
+	msgs := inbox.Messages
+	if st.HasWindows("INBOX") || st.GetMaxUID("INBOX") != msgs[len(msgs)-1].Uid {
+		t.Errorf("windows not folded into the resume state: %v, max UID %d", st.WindowLabels("INBOX"), st.GetMaxUID("INBOX"))
+	}
+}
+
+func TestYearWindows(t *testing.T) {
+	now := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	var got []string
+	for _, w := range yearWindows(2024, true, now) {
+		got = append(got, w.label)
+	}
+	if strings.Join(got, ",") != "2024-03,2024-02,2024-01" {
+		t.Errorf("months of 2024 = %v", got)
+	}
+	if ws := yearWindows(2024, true, now); !ws[0].end.IsZero() || ws[1].end != ws[0].start {
+		t.Errorf("the newest month must be open-ended, the others end where the next starts: %+v", ws)
+	}
+	if ws := yearWindows(2023, true, now); len(ws) != 12 || ws[0].end != time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("months of 2023 = %+v", ws)
+	}
+	if ws := yearWindows(2023, false, now); len(ws) != 1 || ws[0].label != "2023" {
+		t.Errorf("yearly windows of 2023 = %+v", ws)
+	}
+}
+
+func TestNewestFirstBackfill(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	add := func(subject string) {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString("Subject: "+subject+"\r\n\r\nhi\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []string{"m1", "m2", "m3", "m4"} {
+		add(s)
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	run := func(opts Options) []string {
+		opts.Quiet = true
+		opts.Map = map[string]string{"INBOX": "Copy"}
+		w := NewMailboxSyncer(src, dst, st, opts)
+		if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+		for range w.Events() {
+		}
+		var got []string
+		for _, m := range mailbox(t, dstBe, "Copy").Messages {
+			if i := bytes.Index(m.Body, []byte("Subject: ")); i >= 0 {
+				got = append(got, strings.Fields(string(m.Body[i+9:]))[0])
+			}
+		}
+		return got
+	}
+
+	if got := strings.Join(run(Options{NewestFirst: true, Limit: 2}), ","); got != "m3,m4" {
+		t.Errorf("first run copied %s, want m3,m4", got)
+	}
+	msgs := inbox.Messages
+	if floor, ok := st.GetBackfill("INBOX"); !ok || floor != msgs[len(msgs)-2].Uid || st.GetMaxUID("INBOX") != msgs[len(msgs)-1].Uid {
+		t.Errorf("state after first run: floor %d (%v), max %d", floor, ok, st.GetMaxUID("INBOX"))
+	}
+	add("m5")
+	if got := strings.Join(run(Options{Limit: 2}), ","); got != "m3,m4,m5,m2" {
+		t.Errorf("second run left %s, want m3,m4,m5,m2 (new mail first, then backfill)", got)
+	}
+	run(Options{})
+	if _, ok := st.GetBackfill("INBOX"); ok {
+		t.Error("backfill floor kept after everything was copied")
+	}
+	if n := len(mailbox(t, dstBe, "Copy").Messages); n != len(inbox.Messages) {
+		t.Errorf("%d messages copied, want %d", n, len(inbox.Messages))
+	}
+}
+
+func TestParallelConnections(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for i := 0; i < 3*minLaneMessages; i++ {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(fmt.Sprintf("Subject: %d\r\n\r\nhi\r\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := listen(t, srcBe)
+	src, err := dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	got := map[string]int{}
+	dials := 0
+	st, _ := state.Load("")
+	w := NewMailboxSyncer(src, nil, st, Options{
+		Quiet:       true,
+		Connections: 3,
+		SrcPool: imaputil.NewPool(src, 3, func(context.Context) (*client.Client, error) {
+			mu.Lock()
+			dials++
+			mu.Unlock()
+			return dial(addr)
+		}),
+		Deliver: func(_ context.Context, mailbox string, raw []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[string(raw)]++
+			return nil
+		},
+	})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	if dials != 2 {
+		t.Errorf("opened %d extra connections, want 2", dials)
+	}
+	if len(got) != len(inbox.Messages) {
+		t.Errorf("delivered %d distinct messages, want %d", len(got), len(inbox.Messages))
+	}
+	for raw, n := range got {
+		if n != 1 {
+			t.Errorf("%q delivered %d times", raw, n)
+		}
+	}
+	if max := inbox.Messages[len(inbox.Messages)-1].Uid; st.GetMaxUID("INBOX") != max {
+		t.Errorf("resume state at UID %d, want %d", st.GetMaxUID("INBOX"), max)
+	}
+}
+
+// multiAppend is a server extension that announces MULTIAPPEND and
+// stores every message of an APPEND, counting the commands. With uidPlus
+// it also announces UIDPLUS and replies with APPENDUID.
+type multiAppend struct {
+	mu       sync.Mutex
+	commands []int // messages per APPEND
+	uidPlus  bool
+}
+
+func (e *multiAppend) Capabilities(server.Conn) []string {
+	if e.uidPlus {
+		return []string{"MULTIAPPEND", "UIDPLUS"}
+	}
+	return []string{"MULTIAPPEND"}
+}
+
+func (e *multiAppend) Command(name string) server.HandlerFactory {
+	if name != "APPEND" {
+		return nil
+	}
+	return func() server.Handler { return &multiAppendHandler{ext: e} }
+}
+
+type multiAppendHandler struct {
+	ext     *multiAppend
+	mailbox string
+	flags   [][]string
+	dates   []time.Time
+	bodies  []imap.Literal
+}
+
+func (h *multiAppendHandler) Parse(fields []interface{}) error {
+	name, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+	h.mailbox = name
+	var flags []string
+	var date time.Time
+	for _, f := range fields[1:] {
+		switch f := f.(type) {
+		case []interface{}:
+			if flags, err = imap.ParseStringList(f); err != nil {
+				return err
+			}
+		case string:
+			if date, err = time.Parse(imap.DateTimeLayout, f); err != nil {
+				return err
+			}
+		case imap.Literal:
+			h.flags, h.dates, h.bodies = append(h.flags, flags), append(h.dates, date), append(h.bodies, f)
+			flags, date = nil, time.Time{}
+		}
+	}
+	return nil
+}
+
+func (h *multiAppendHandler) Handle(conn server.Conn) error {
+	mbox, err := conn.Context().User.GetMailbox(h.mailbox)
+	if err != nil {
+		return err
+	}
+	status, err := mbox.Status([]imap.StatusItem{imap.StatusUidNext, imap.StatusUidValidity})
+	if err != nil {
+		return err
+	}
+	for i, body := range h.bodies {
+		if err := mbox.CreateMessage(h.flags[i], h.dates[i], body); err != nil {
+			return err
+		}
+	}
+	h.ext.mu.Lock()
+	h.ext.commands = append(h.ext.commands, len(h.bodies))
+	h.ext.mu.Unlock()
+	if !h.ext.uidPlus {
+		return nil
+	}
+	uids := new(imap.SeqSet)
+	uids.AddRange(status.UidNext, status.UidNext+uint32(len(h.bodies))-1)
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      "APPENDUID",
+		Arguments: []interface{}{status.UidValidity, imap.RawString(uids.String())},
+		Info:      "APPEND completed",
+	}}
+}
+
+func TestReconnect(t *testing.T) {
+	defer func(w time.Duration) { reconnectWait = w }(reconnectWait)
+	reconnectWait = time.Millisecond
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	// more than the fetch buffers, so the drop cuts the fetch short
+	for i := 0; i < 500; i++ {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(fmt.Sprintf("Subject: %d\r\n\r\nhi\r\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := listen(t, srcBe)
+	src, err := dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	dials := 0
+	st, _ := state.Load("")
+	w := NewMailboxSyncer(src, nil, st, Options{
+		Quiet:      true,
+		Reconnects: 2,
+		SrcPool: imaputil.NewPool(src, 1, func(context.Context) (*client.Client, error) {
+			dials++
+			return dial(addr)
+		}),
+		Deliver: func(_ context.Context, mailbox string, raw []byte) error {
+			got[string(raw)]++
+			if len(got) == 3 && dials == 0 {
+				src.Terminate() // the server drops the connection
+			}
+			return nil
+		},
+	})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	if dials != 1 {
+		t.Errorf("opened %d new connections, want 1", dials)
+	}
+	if len(got) != len(inbox.Messages) {
+		t.Errorf("delivered %d messages, want %d", len(got), len(inbox.Messages))
+	}
+	for raw, n := range got {
+		if n != 1 {
+			t.Errorf("%q delivered %d times", raw, n)
+		}
+	}
+}
+
+func TestAppendBatch(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for i := 0; i < 25; i++ {
+		if err := inbox.CreateMessage([]string{imap.SeenFlag}, time.Now(), bytes.NewBufferString(fmt.Sprintf("Subject: %02d\r\n\r\nhi\r\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	ext := &multiAppend{}
+	src := serve(t, srcBe)
+	dst, err := dial(listen(t, dstBe, ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := state.Load("")
+	w := NewMailboxSyncer(src, dst, st, Options{Quiet: true, Map: map[string]string{"INBOX": "Copy"}, AppendBatch: 10})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	// the memory backend starts with one message of its own
+	if got := fmt.Sprint(ext.commands); got != "[10 10 6]" {
+		t.Errorf("messages per APPEND %s, want [10 10 6]", got)
+	}
+	msgs := mailbox(t, dstBe, "Copy").Messages
+	if len(msgs) != 26 || !strings.Contains(string(msgs[25].Body), "Subject: 24") || len(msgs[1].Flags) != 1 {
+		t.Errorf("copied %d messages", len(msgs))
+	}
+	if want := inbox.Messages[25].Uid; st.GetMaxUID("INBOX") != want {
+		t.Errorf("resume state at UID %d, want %d", st.GetMaxUID("INBOX"), want)
+	}
+}
+
+func TestSourceStaysUnseen(t *testing.T) {
+	srcBe := &faultBackend{Backend: memory.New(), failAfter: -1, seen: true}
+	inbox := mailbox(t, srcBe.Backend, "INBOX")
+	for _, body := range []string{"Subject: small\r\n\r\nhi\r\n", "Subject: large\r\n\r\n" + strings.Repeat("x", 200) + "\r\n"} {
+		if err := inbox.CreateMessage([]string{imap.FlaggedFlag}, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	// the large message is fetched in partial chunks
+	w := NewMailboxSyncer(src, dst, st, Options{Quiet: true, Map: map[string]string{"INBOX": "Copy"}, LargeMessage: 100})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	if n := len(mailbox(t, dstBe, "Copy").Messages); n != 3 {
+		t.Fatalf("copied %d messages, want 3", n)
+	}
+	for _, msg := range inbox.Messages[1:] {
+		if got := strings.Join(msg.Flags, " "); got != imap.FlaggedFlag {
+			t.Errorf("source UID %d has flags %s after the copy", msg.Uid, got)
+		}
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for _, subject := range []string{"one", "bad", "three"} {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString("Subject: "+subject+"\r\n\r\nhi\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad := inbox.Messages[2].Uid
+	for _, tc := range []struct {
+		name     string
+		failed   bool // with Options.Failed
+		wantMax  uint32
+		wantMsgs int
+	}{
+		{"stop", false, inbox.Messages[1].Uid, 2},
+		{"continue", true, inbox.Messages[3].Uid, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dstBe := &faultBackend{Backend: memory.New(), failAfter: -1, reject: "Subject: bad"}
+			src, dst := serve(t, srcBe), serve(t, dstBe)
+			st, _ := state.Load("")
+			var failed []uint32
+			opts := Options{Quiet: true, Map: map[string]string{"INBOX": "Copy"}}
+			if tc.failed {
+				opts.Failed = func(mailbox string, uid uint32, err error) { failed = append(failed, uid) }
+			}
+			w := NewMailboxSyncer(src, dst, st, opts)
+			errs := w.SyncAll(context.Background(), []string{"INBOX"})
+			for range w.Events() {
+			}
+			if tc.failed != (len(errs) == 0) {
+				t.Errorf("errors %v", errs)
+			}
+			if tc.failed && (len(failed) != 1 || failed[0] != bad) {
+				t.Errorf("failed UIDs %v, want [%d]", failed, bad)
+			}
+			if n := len(mailbox(t, dstBe.Backend, "Copy").Messages); n != tc.wantMsgs {
+				t.Errorf("copied %d messages, want %d", n, tc.wantMsgs)
+			}
+			if got := st.GetMaxUID("INBOX"); got != tc.wantMax {
+				t.Errorf("resume state at UID %d, want %d", got, tc.wantMax)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for _, subject := range []string{"one", "bad", "three"} {
+		body := fmt.Sprintf("Message-ID: <%s@test>\r\nSubject: %s\r\n\r\nhi\r\n", subject, subject)
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad := inbox.Messages[2].Uid
+	for _, tc := range []struct {
+		name    string
+		batch   bool // through MULTIAPPEND
+		failed  bool // with Options.Failed
+		wantMax uint32
+	}{
+		{"stop", false, false, inbox.Messages[1].Uid},
+		{"continue", false, true, inbox.Messages[3].Uid},
+		{"batch", true, true, inbox.Messages[3].Uid},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dstBe := &faultBackend{Backend: memory.New(), failAfter: -1, mangle: "Subject: bad"}
+			src := serve(t, srcBe)
+			opts := Options{Quiet: true, Map: map[string]string{"INBOX": "Copy"}, Verify: imaputil.VerifySize}
+			var exts []server.Extension
+			if tc.batch {
+				exts = append(exts, &multiAppend{})
+				opts.AppendBatch = 10
+			}
+			dst, err := dial(listen(t, dstBe, exts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var failed []uint32
+			if tc.failed {
+				opts.Failed = func(mailbox string, uid uint32, err error) { failed = append(failed, uid) }
+			}
+			st, _ := state.Load("")
+			w := NewMailboxSyncer(src, dst, st, opts)
+			errs := w.SyncAll(context.Background(), []string{"INBOX"})
+			for range w.Events() {
+			}
+			if tc.failed != (len(errs) == 0) {
+				t.Errorf("errors %v", errs)
+			}
+			if tc.failed && (len(failed) != 1 || failed[0] != bad) {
+				t.Errorf("failed UIDs %v, want [%d]", failed, bad)
+			}
+			if got := st.GetMaxUID("INBOX"); got != tc.wantMax {
+				t.Errorf("resume state at UID %d, want %d", got, tc.wantMax)
+			}
+		})
+	}
+}
+
+func TestUIDMap(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for i := 0; i < 5; i++ {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(fmt.Sprintf("Subject: %d\r\n\r\nhi\r\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, batch := range []int{1, 3} {
+		dstBe := memory.New()
+		src := serve(t, srcBe)
+		dst, err := dial(listen(t, dstBe, &multiAppend{uidPlus: true}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, _ := state.Load("")
+		w := NewMailboxSyncer(src, dst, st, Options{Quiet: true, Map: map[string]string{"INBOX": "Copy"}, AppendBatch: batch, Verify: imaputil.VerifySize})
+		if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+		for range w.Events() {
+		}
+		copied := mailbox(t, dstBe, "Copy").Messages
+		for i, msg := range inbox.Messages {
+			box, uid, ok := st.DstUID("INBOX", msg.Uid)
+			if !ok || box != "Copy" || uid != copied[i].Uid {
+				t.Errorf("batch %d: UID %d maps to %s %d (%v), want Copy %d", batch, msg.Uid, box, uid, ok, copied[i].Uid)
+			}
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	srcBe := memory.New()
+	u, err := srcBe.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Sub", "Unsub"} {
+		if err := u.CreateMailbox(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mailbox(t, srcBe, "Sub").SetSubscribed(true); err != nil {
+		t.Fatal(err)
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	subs, err := imaputil.Subscribed(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !subs["Sub"] || subs["Unsub"] {
+		t.Fatalf("source subscriptions %v, want only Sub", subs)
+	}
+	st, _ := state.Load("")
+	w := NewMailboxSyncer(src, dst, st, Options{Quiet: true, Subscribe: func(name string) bool { return subs[name] }})
+	if errs := w.SyncAll(context.Background(), []string{"Sub", "Unsub"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	if !mailbox(t, dstBe, "Sub").Subscribed || mailbox(t, dstBe, "Unsub").Subscribed {
+		t.Error("the destination subscriptions do not follow the source")
+	}
+}
+
+func TestDryRunPlanned(t *testing.T) {
+	srcBe := memory.New()
+	inbox := mailbox(t, srcBe, "INBOX")
+	for _, body := range []string{"Subject: a\r\n\r\nhi\r\n", "Subject: b\r\n\r\n" + strings.Repeat("x", 500) + "\r\n"} {
+		if err := inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dstBe := memory.New()
+	src, dst := serve(t, srcBe), serve(t, dstBe)
+	st, _ := state.Load("")
+	planned := map[uint32]int64{}
+	w := NewMailboxSyncer(src, dst, st, Options{Quiet: true, DryRun: true, MaxSize: 400, Map: map[string]string{"INBOX": "Copy"},
+		Planned: func(mailbox string, uid uint32, size int64) { planned[uid] = size }})
+	if errs := w.SyncAll(context.Background(), []string{"INBOX"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for range w.Events() {
+	}
+	// the memory backend's own message, then the small one; the large one
+	// exceeds MaxSize
+	var want int64
+	for _, msg := range inbox.Messages[:2] {
+		want += int64(msg.Size)
+	}
+	if len(planned) != 2 || planned[inbox.Messages[0].Uid]+planned[inbox.Messages[1].Uid] != want {
+		t.Errorf("planned %v, want the first two messages with %d bytes", planned, want)
+	}
+	u, err := dstBe.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.GetMailbox("Copy"); err == nil {
+		t.Error("the dry run created the destination mailbox")
+	}
+}
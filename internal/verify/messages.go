@@ -0,0 +1,65 @@
+package verify
+
+// Message is one message of a mailbox as Compare sees it.
+type Message struct {
+	Key  string // identifies it on both sides, e.g. its Message-ID
+	Size uint32 // RFC822.SIZE; 0 if not compared
+	Hash string // digest of its content; "" if not compared
+}
+
+// Diff is how the messages of a mailbox differ between two sides. Its
+// entries are indices into the slices given to Compare.
+type Diff struct {
+	Missing []int    // source messages the destination lacks
+	Extra   []int    // destination messages the source lacks
+	Changed [][2]int // source and destination message with the same key but another size or hash
+}
+
+// Empty reports whether the sides hold the same messages.
+func (d Diff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Changed) == 0
+}
+
+// Compare matches src and dst messages by key. A key can occur more than
+// once on a side (duplicates): identical messages are paired first, then
+// the rest of a key pair up as changed, and what is left over is missing
+// or extra. The indices in each list are in order.
+func Compare(src, dst []Message) Diff {
+	byKey := map[string][]int{}
+	for i, m := range dst {
+		byKey[m.Key] = append(byKey[m.Key], i)
+	}
+	paired := make([]bool, len(dst))
+	// pair takes the first unpaired destination message of key that
+	// same accepts
+	pair := func(key string, same func(j int) bool) (int, bool) {
+		for _, j := range byKey[key] {
+			if !paired[j] && same(j) {
+				paired[j] = true
+				return j, true
+			}
+		}
+		return 0, false
+	}
+
+	var d Diff
+	var rest []int
+	for i, m := range src {
+		if _, ok := pair(m.Key, func(j int) bool { return dst[j].Size == m.Size && dst[j].Hash == m.Hash }); !ok {
+			rest = append(rest, i)
+		}
+	}
+	for _, i := range rest {
+		if j, ok := pair(src[i].Key, func(int) bool { return true }); ok {
+			d.Changed = append(d.Changed, [2]int{i, j})
+		} else {
+			d.Missing = append(d.Missing, i)
+		}
+	}
+	for j := range dst {
+		if !paired[j] {
+			d.Extra = append(d.Extra, j)
+		}
+	}
+	return d
+}
@@ -0,0 +1,32 @@
+package verify
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	src := []Message{
+		{Key: "<a>", Size: 10},
+		{Key: "<b>", Size: 20},
+		{Key: "<dup>", Size: 30},
+		{Key: "<dup>", Size: 31},
+		{Key: "<c>", Size: 40, Hash: "x"},
+		{Key: "<gone>", Size: 50},
+	}
+	dst := []Message{
+		{Key: "<dup>", Size: 31},
+		{Key: "<a>", Size: 10},
+		{Key: "<new>", Size: 60},
+		{Key: "<dup>", Size: 30},
+		{Key: "<b>", Size: 21},
+		{Key: "<c>", Size: 40, Hash: "y"},
+	}
+	d := Compare(src, dst)
+	if got := fmt.Sprint(d.Missing, d.Extra, d.Changed); got != "[5] [2] [[1 4] [4 5]]" {
+		t.Errorf("Compare = %s", got)
+	}
+	if d.Empty() || !Compare(src[:4], []Message{src[3], src[1], src[0], src[2]}).Empty() {
+		t.Error("Empty is wrong")
+	}
+}
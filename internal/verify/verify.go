@@ -0,0 +1,109 @@
+// Package verify compares a mailbox on two servers cheaply: it counts
+// messages per INTERNALDATE window with server-side SEARCH on both sides,
+// first per year and then per month within the years that differ, so
+// the expensive per-message comparison only has to look at the months
+// where the counts disagree. Compare matches the messages themselves, for
+// a sign-off that counts alone cannot give.
+package verify
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Counter returns how many messages of a mailbox have since <= INTERNALDATE
+// < before. A zero time leaves that bound open.
+type Counter func(since, before time.Time) (int, error)
+
+// Bucket is a date window whose counts differ between the two sides.
+type Bucket struct {
+	Since, Before time.Time // zero for an open bound
+	Src, Dst      int
+}
+
+// Label names the window: "2023-05", or "before 2015" / "after 2024" for
+// the open-ended catch-all windows.
+func (b Bucket) Label() string {
+	switch {
+	case b.Since.IsZero():
+		return fmt.Sprintf("before %d", b.Before.Year())
+	case b.Before.IsZero():
+		return fmt.Sprintf("after %d", b.Since.Year()-1)
+	}
+	return b.Since.Format("2006-01")
+}
+
+// Localize compares src and dst per month and returns the months (and
+// catch-all windows) whose counts differ, oldest first. It counts per year
+// from the year of first up to the year of now and only splits years
+// that differ into months, so a mailbox whose counts agree costs one
+// SEARCH per year and side. While the window before the first year
+// differs it steps back a year at a time, as first (usually the date of
+// the first message) need not be the oldest. Dates are UTC calendar days,
+// as SEARCH SINCE/BEFORE have day resolution.
+func Localize(src, dst Counter, first, now time.Time) ([]Bucket, error) {
+	fromYear, toYear := first.Year(), now.Year()
+	if fromYear > toYear {
+		fromYear = toYear
+	}
+	year := func(y int) time.Time { return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	var out []Bucket
+	compare := func(since, before time.Time) (bool, error) {
+		s, err := src(since, before)
+		if err != nil {
+			return false, fmt.Errorf("source: %w", err)
+		}
+		d, err := dst(since, before)
+		if err != nil {
+			return false, fmt.Errorf("destination: %w", err)
+		}
+		if s == d {
+			return false, nil
+		}
+		out = append(out, Bucket{Since: since, Before: before, Src: s, Dst: d})
+		return true, nil
+	}
+	compareYear := func(y int) error {
+		differs, err := compare(year(y), year(y+1))
+		if err != nil || !differs {
+			return err
+		}
+		out = out[:len(out)-1] // replaced by its months
+		for m := time.January; m <= time.December; m++ {
+			since := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+			if _, err := compare(since, since.AddDate(0, 1, 0)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for y := fromYear; y <= toYear; y++ {
+		if err := compareYear(y); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := compare(year(toYear+1), time.Time{}); err != nil {
+		return nil, err
+	}
+	for y := fromYear; ; y-- {
+		differs, err := compare(time.Time{}, year(y))
+		if err != nil {
+			return nil, err
+		}
+		if !differs || y <= minYear {
+			break
+		}
+		out = out[:len(out)-1]
+		if err := compareYear(y - 1); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	return out, nil
+}
+
+// minYear bounds how far back Localize looks.
+const minYear = 1970
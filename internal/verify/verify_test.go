@@ -0,0 +1,57 @@
+package verify
+
+import (
+	"testing"
+	"time"
+)
+
+// counter counts dates like SEARCH SINCE/BEFORE and records the calls.
+func counter(dates []time.Time, calls *int) Counter {
+	return func(since, before time.Time) (int, error) {
+		*calls++
+		n := 0
+		for _, d := range dates {
+			if (since.IsZero() || !d.Before(since)) && (before.IsZero() || d.Before(before)) {
+				n++
+			}
+		}
+		return n, nil
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 12, 0, 0, 0, time.UTC) }
+	src := []time.Time{day(2009, 3, 1), day(2021, 1, 5), day(2022, 5, 10), day(2022, 5, 11), day(2022, 11, 30), day(2023, 2, 2)}
+	dst := []time.Time{day(2009, 3, 1), day(2021, 1, 5), day(2022, 5, 10), day(2022, 11, 30), day(2023, 2, 2), day(2023, 2, 3)}
+	var sc, dc int
+	got, err := Localize(counter(src, &sc), counter(dst, &dc), day(2021, 1, 5), day(2024, 6, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2022-05", "2023-02"}
+	if len(got) != len(want) {
+		t.Fatalf("Localize = %+v, want months %v", got, want)
+	}
+	for i, b := range got {
+		if b.Label() != want[i] {
+			t.Errorf("bucket %d = %s, want %s", i, b.Label(), want[i])
+		}
+	}
+	if got[0].Src != 2 || got[0].Dst != 1 {
+		t.Errorf("2022-05 counts = %d/%d, want 2/1", got[0].Src, got[0].Dst)
+	}
+	// 4 years, 2 of them split into months, after- and before-bucket
+	if sc != 4+24+1+1 {
+		t.Errorf("source SEARCH calls = %d", sc)
+	}
+
+	// messages older than the first year are found by stepping back
+	got, _ = Localize(counter(src[:1], &sc), counter(nil, &dc), day(2021, 1, 5), day(2024, 6, 1))
+	if len(got) != 1 || got[0].Label() != "2009-03" {
+		t.Errorf("Localize(old message) = %+v", got)
+	}
+	got, _ = Localize(counter([]time.Time{day(1969, 7, 20)}, &sc), counter(nil, &dc), day(2021, 1, 5), day(2024, 6, 1))
+	if len(got) != 1 || got[0].Label() != "before 1970" {
+		t.Errorf("Localize(ancient message) = %+v", got)
+	}
+}
@@ -0,0 +1,172 @@
+// Package webdav is a minimal WebDAV client covering what backup needs:
+// creating collections, uploading files and listing a collection.
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client talks to one WebDAV root.
+type Client struct {
+	root       *url.URL // always ends in "/"
+	user, pass string
+	http       *http.Client
+
+	mu      sync.Mutex
+	created map[string]bool // collections known to exist
+}
+
+// New returns a client for the collection at root (http or https URL).
+func New(root *url.URL, user, pass string) *Client {
+	u := *root
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return &Client{root: &u, user: user, pass: pass, http: &http.Client{Timeout: 30 * time.Minute}, created: map[string]bool{}}
+}
+
+func (c *Client) url(name string) string {
+	u := *c.root
+	u.Path = path.Join(c.root.Path, name)
+	if strings.HasSuffix(name, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+func (c *Client) do(ctx context.Context, method, name string, body io.Reader, size int64, hdr map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	return c.http.Do(req)
+}
+
+func statusErr(method, name string, resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("webdav: %s %s: %s: %s", method, name, resp.Status, strings.TrimSpace(string(b)))
+}
+
+// MkdirAll creates the collection dir and its parents.
+func (c *Client) MkdirAll(ctx context.Context, dir string) error {
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return nil
+	}
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		p := strings.Join(parts[:i+1], "/")
+		c.mu.Lock()
+		done := c.created[p]
+		c.mu.Unlock()
+		if done {
+			continue
+		}
+		resp, err := c.do(ctx, "MKCOL", p+"/", nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// 405 Method Not Allowed: the collection already exists
+		if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return statusErr("MKCOL", p, resp)
+		}
+		c.mu.Lock()
+		c.created[p] = true
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// Put uploads size bytes from r as name, creating parent collections.
+func (c *Client) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := c.MkdirAll(ctx, path.Dir(name)); err != nil {
+		return err
+	}
+	if size == 0 {
+		r = http.NoBody
+	}
+	resp, err := c.do(ctx, http.MethodPut, name, r, size, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusErr("PUT", name, resp)
+	}
+	return nil
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Collection *struct{} `xml:"prop>resourcetype>collection"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`
+
+// List returns the names (relative to the root) of the files directly in
+// dir. A missing dir yields no names.
+func (c *Client) List(ctx context.Context, dir string) ([]string, error) {
+	dir = strings.Trim(dir, "/")
+	resp, err := c.do(ctx, "PROPFIND", dir+"/", strings.NewReader(propfindBody), int64(len(propfindBody)),
+		map[string]string{"Depth": "1", "Content-Type": "application/xml"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusErr("PROPFIND", dir, resp)
+	}
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: PROPFIND %s: %w", dir, err)
+	}
+	names := []string{}
+	for _, r := range ms.Responses {
+		isDir := false
+		for _, ps := range r.Propstat {
+			if ps.Collection != nil {
+				isDir = true
+			}
+		}
+		if isDir {
+			continue
+		}
+		u, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		rel, ok := strings.CutPrefix(u.Path, c.root.Path)
+		if !ok {
+			continue
+		}
+		names = append(names, rel)
+	}
+	return names, nil
+}
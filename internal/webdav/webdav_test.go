@@ -0,0 +1,66 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPutAndList(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if u, p, _ := r.BasicAuth(); u != "me" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case "MKCOL":
+			if r.URL.Path == "/dav/backup/" {
+				w.WriteHeader(http.StatusMethodNotAllowed) // exists
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPut:
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case "PROPFIND":
+			w.WriteHeader(http.StatusMultiStatus)
+			io.WriteString(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">
+<d:response><d:href>/dav/backup/INBOX%20Old/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop></d:propstat></d:response>
+<d:response><d:href>/dav/backup/INBOX%20Old/1.eml</d:href><d:propstat><d:prop><d:resourcetype/></d:prop></d:propstat></d:response>
+<d:response><d:href>http://example/dav/backup/INBOX%20Old/2.eml</d:href><d:propstat><d:prop><d:resourcetype/></d:prop></d:propstat></d:response>
+</d:multistatus>`)
+		}
+	}))
+	defer srv.Close()
+
+	root, _ := url.Parse(srv.URL + "/dav/backup")
+	c := New(root, "me", "secret")
+	ctx := context.Background()
+	if err := c.Put(ctx, "INBOX Old/1.eml", io.NopCloser(nil), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(ctx, "INBOX Old/2.eml", io.NopCloser(nil), 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"MKCOL /dav/backup/INBOX Old/", "PUT /dav/backup/INBOX Old/1.eml", "PUT /dav/backup/INBOX Old/2.eml"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+	names, err := c.List(ctx, "INBOX Old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"INBOX Old/1.eml", "INBOX Old/2.eml"}) {
+		t.Fatalf("unexpected names %q", names)
+	}
+}